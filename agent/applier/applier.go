@@ -11,15 +11,37 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
-const (
+// Where the network config is written. The interface stanzas the API
+// generates, ifupdown and FRR only read these locations, so only tests
+// point them elsewhere.
+var (
 	WireGuardDir         = "/etc/wireguard"
 	NetworkInterfacesDir = "/etc/network/interfaces.d"
 	FRRConfigPath        = "/etc/frr/frr.conf"
-	StateFilePath        = "/var/lib/gluon/config-state.json"
 )
 
+// StateFilePath records the applied config version; state_file in
+// agent.conf moves it.
+var StateFilePath = "/var/lib/gluon/config-state.json"
+
+var (
+	sshDisabled  atomic.Bool
+	forceReapply atomic.Bool
+)
+
+// SetManageSSH turns management of SSH keys, the SSH CA and sudo rules on
+// or off. Turning it back on plans the current bundle again on the next
+// sync even if its version hasn't changed; only SSH files that differ from
+// the bundle are written.
+func SetManageSSH(enabled bool) {
+	if sshDisabled.Swap(!enabled) && enabled {
+		forceReapply.Store(true)
+	}
+}
+
 type ConfigState struct {
 	Version int    `json:"version"`
 	Hash    string `json:"hash"`
@@ -54,42 +76,35 @@ func SaveState(state *ConfigState) error {
 	return os.WriteFile(StateFilePath, data, 0644)
 }
 
-func ApplyConfig(bundle *client.ConfigBundle) error {
+// ApplyPlan applies bundle, making only the changes in plan, which
+// PlanConfig computed for the same bundle.
+func ApplyPlan(bundle *client.ConfigBundle, plan *Plan) error {
 	log.Printf("Applying config version %d...", bundle.Version)
 
-	networkTouched := false
-	if len(bundle.WireGuardConfigs) > 0 {
-		if err := applyWireGuardConfigs(bundle.WireGuardConfigs); err != nil {
-			return fmt.Errorf("failed to apply WireGuard configs: %w", err)
-		}
-		networkTouched = true
-	}
-
-	if strings.TrimSpace(bundle.NetworkInterfaceFile) != "" {
-		if err := applyNetworkInterfaces(bundle.NetworkInterfaceFile); err != nil {
-			return fmt.Errorf("failed to apply network interfaces: %w", err)
-		}
-		networkTouched = true
-	}
-
-	if strings.TrimSpace(bundle.FRRConfigFile) != "" {
-		if err := applyFRRConfig(bundle.FRRConfigFile); err != nil {
-			return fmt.Errorf("failed to apply FRR config: %w", err)
-		}
-		networkTouched = true
-	}
-
-	if err := applySSHAuthorizedKeys(bundle.SSHAuthorizedKeys); err != nil {
-		return fmt.Errorf("failed to apply SSH keys: %w", err)
-	}
-
-	if networkTouched {
-		if err := bringUpInterfaces(); err != nil {
-			return fmt.Errorf("failed to bring up interfaces: %w", err)
-		}
-
-		if err := reloadFRR(); err != nil {
-			return fmt.Errorf("failed to reload FRR: %w", err)
+	if err := applyNetworkPlan(plan); err != nil {
+		// The files are written, so only the failed actions tell the next
+		// sync that this bundle still needs applying.
+		forceReapply.Store(true)
+		return fmt.Errorf("failed to apply network config: %w", err)
+	}
+
+	// SSH access is applied after the network and does not fail the
+	// bundle: the node stays reachable over the mesh, and a failed step is
+	// retried on the next sync.
+	retrySSH := false
+	switch {
+	case sshDisabled.Load():
+		log.Println("SSH management is disabled; leaving SSH keys, CA and sudo rules alone")
+	case plan.sshErr != nil:
+		log.Printf("Warning: %v; retrying on the next sync", plan.sshErr)
+		retrySSH = true
+	case plan.ssh == nil:
+		// SSH management was turned on after the plan was made.
+		retrySSH = true
+	default:
+		if err := applySSHPlan(plan.ssh); err != nil {
+			log.Printf("Warning: failed to apply SSH access: %v; retrying on the next sync", err)
+			retrySSH = true
 		}
 	}
 
@@ -100,6 +115,7 @@ func ApplyConfig(bundle *client.ConfigBundle) error {
 	if err := SaveState(state); err != nil {
 		log.Printf("Warning: failed to save state: %v", err)
 	}
+	forceReapply.Store(retrySSH)
 
 	log.Printf("Config version %d applied successfully", bundle.Version)
 	return nil
@@ -177,95 +193,9 @@ func isLinkUp(iface string) (bool, error) {
 	return re.Match(out), nil
 }
 
-func applyWireGuardConfigs(configs map[string]string) error {
-	if err := os.MkdirAll(WireGuardDir, 0700); err != nil {
-		return err
-	}
-
-	files, _ := filepath.Glob(filepath.Join(WireGuardDir, "wg-*.conf"))
-	for _, f := range files {
-		ifaceName := strings.TrimSuffix(filepath.Base(f), ".conf")
-		if _, ok := configs[ifaceName]; !ok {
-			_ = os.Remove(f)
-		}
-	}
-
-	for ifaceName, configContent := range configs {
-		privateKey, err := keys.GetPrivateKey(ifaceName)
-		if err != nil {
-			return fmt.Errorf("failed to get private key for %s: %w", ifaceName, err)
-		}
-
-		finalConfig := strings.Replace(configContent, "PrivateKey = PRIVATE_KEY_PLACEHOLDER", "PrivateKey = "+privateKey, 1)
-
-		configPath := filepath.Join(WireGuardDir, ifaceName+".conf")
-		if err := os.WriteFile(configPath, []byte(finalConfig), 0600); err != nil {
-			return fmt.Errorf("failed to write %s: %w", configPath, err)
-		}
-		log.Printf("Wrote WireGuard config: %s", configPath)
-	}
-
-	return nil
-}
-
-func applyNetworkInterfaces(content string) error {
-	if err := os.MkdirAll(NetworkInterfacesDir, 0755); err != nil {
-		return err
-	}
-
-	configPath := filepath.Join(NetworkInterfacesDir, "gluon")
-	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
-		return err
-	}
-	log.Printf("Wrote network interfaces config: %s", configPath)
-	return nil
-}
-
-func applyFRRConfig(content string) error {
-	if err := os.WriteFile(FRRConfigPath, []byte(content), 0640); err != nil {
-		return err
-	}
-	log.Printf("Wrote FRR config: %s", FRRConfigPath)
-	return nil
-}
-
-func bringUpInterfaces() error {
-	log.Println("Bringing down existing interfaces...")
-	exec.Command("ifdown", "--force", "dummy").Run()
-
-	files, _ := filepath.Glob(filepath.Join(WireGuardDir, "wg-*.conf"))
-	for _, f := range files {
-		ifaceName := strings.TrimSuffix(filepath.Base(f), ".conf")
-		exec.Command("ifdown", "--force", ifaceName).Run()
-		exec.Command("ip", "link", "delete", ifaceName).Run()
-	}
-
-	exec.Command("ip", "link", "delete", "dummy").Run()
-
-	log.Println("Bringing up interfaces...")
-
-	if err := runCommand("ifup", "dummy"); err != nil {
-		return fmt.Errorf("failed to bring up dummy: %w", err)
-	}
-
-	for _, f := range files {
-		ifaceName := strings.TrimSuffix(filepath.Base(f), ".conf")
-		if err := runCommand("ifup", ifaceName); err != nil {
-			return fmt.Errorf("failed to bring up %s: %w", ifaceName, err)
-		}
-	}
-
-	return nil
-}
-
-func reloadFRR() error {
-	// Use restart instead of reload because reload doesn't apply router-id changes.
-	// The OSPF router-id is only set at startup, so we need a full restart.
-	log.Println("Restarting FRR...")
-	return runCommand("systemctl", "restart", "frr")
-}
-
-func runCommand(name string, args ...string) error {
+// runCommand runs name with the agent's stdout and stderr; tests replace
+// it.
+var runCommand = func(name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -273,6 +203,9 @@ func runCommand(name string, args ...string) error {
 }
 
 func NeedsUpdate(bundle *client.ConfigBundle, state *ConfigState) bool {
+	if forceReapply.Load() {
+		return true
+	}
 	if bundle.Version > state.Version {
 		return true
 	}
@@ -319,8 +252,7 @@ func Cleanup() error {
 	}
 
 	// 6. Remove WireGuard keys
-	keysDir := "/var/lib/gluon/keys"
-	if err := os.RemoveAll(keysDir); err != nil {
+	if err := os.RemoveAll(keys.KeysDir); err != nil {
 		log.Printf("Warning: failed to remove keys directory: %v", err)
 	}
 
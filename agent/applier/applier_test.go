@@ -47,3 +47,20 @@ func TestNeedsUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestSetManageSSHForcesReapply(t *testing.T) {
+	defer SetManageSSH(true)
+	defer forceReapply.Store(false)
+
+	bundle := &client.ConfigBundle{Version: 3, Hash: "h"}
+	state := &ConfigState{Version: 3, Hash: "h"}
+
+	SetManageSSH(true)
+	assert.False(t, NeedsUpdate(bundle, state))
+
+	SetManageSSH(false)
+	assert.False(t, NeedsUpdate(bundle, state), "turning SSH management off leaves files alone")
+
+	SetManageSSH(true)
+	assert.True(t, NeedsUpdate(bundle, state), "turning it back on reapplies the bundle")
+}
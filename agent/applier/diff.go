@@ -0,0 +1,141 @@
+package applier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is how many unchanged lines surround each hunk.
+	diffContext = 2
+	// maxDiffBytes keeps plans small when a file is rewritten wholesale.
+	maxDiffBytes = 16 << 10
+)
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff renders the change from old to new as a unified diff.
+// Private keys are replaced by a fingerprint so plans can be stored and
+// shown without leaking them.
+func unifiedDiff(path, old, new string) string {
+	ops := diffLines(splitLines(redactSecrets(old)), splitLines(redactSecrets(new)))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", path, path)
+	for h := 0; h < len(changes); {
+		first, last := changes[h], changes[h]
+		for h++; h < len(changes) && changes[h]-last <= 2*diffContext; h++ {
+			last = changes[h]
+		}
+		start := max(0, first-diffContext)
+		end := min(len(ops), last+diffContext+1)
+
+		oldStart, newStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				oldStart++
+			}
+			if op.kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		if sb.Len() > maxDiffBytes {
+			return sb.String()[:maxDiffBytes] + "\n... diff truncated\n"
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the edit script from a to b along a longest common
+// subsequence of lines.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// redactSecrets replaces WireGuard private and preshared keys with a short
+// fingerprint, which still shows whether the key changed.
+func redactSecrets(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if name := strings.TrimSpace(key); !ok || (name != "PrivateKey" && name != "PresharedKey") {
+			continue
+		}
+		sum := sha256.Sum256([]byte(strings.TrimSpace(value)))
+		lines[i] = key + "= (redacted sha256:" + hex.EncodeToString(sum[:4]) + ")"
+	}
+	return strings.Join(lines, "\n")
+}
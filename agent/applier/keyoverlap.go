@@ -0,0 +1,119 @@
+package applier
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gluon-agent/client"
+)
+
+// While a far end rotates its WireGuard key the config lists it under both
+// keys, with the allowed IPs on the old one. The far end switches keys on its
+// own schedule, so rather than waiting for the next config sync the overlaps
+// are checked every few seconds and the allowed IPs moved over as soon as the
+// new key has completed a handshake.
+
+const keyOverlapCheckInterval = 2 * time.Second
+
+var (
+	keyOverlapsMu sync.Mutex
+	keyOverlaps   []client.KeyOverlap
+)
+
+// SetKeyOverlaps records the overlaps of the latest config bundle.
+func SetKeyOverlaps(overlaps []client.KeyOverlap) {
+	keyOverlapsMu.Lock()
+	defer keyOverlapsMu.Unlock()
+	keyOverlaps = append([]client.KeyOverlap(nil), overlaps...)
+}
+
+// WatchKeyOverlaps settles key overlaps until ctx is done.
+func WatchKeyOverlaps(ctx context.Context) {
+	ticker := time.NewTicker(keyOverlapCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			settleKeyOverlaps()
+		}
+	}
+}
+
+func settleKeyOverlaps() {
+	keyOverlapsMu.Lock()
+	overlaps := append([]client.KeyOverlap(nil), keyOverlaps...)
+	keyOverlapsMu.Unlock()
+
+	for _, o := range overlaps {
+		handshakes, err := exec.Command("wg", "show", o.Interface, "latest-handshakes").Output()
+		if err != nil {
+			continue
+		}
+		allowed, err := exec.Command("wg", "show", o.Interface, "allowed-ips").Output()
+		if err != nil {
+			continue
+		}
+		move := overlapMove(o, parseWGHandshakes(string(handshakes)), parseWGAllowedIPs(string(allowed)))
+		if len(move) == 0 {
+			continue
+		}
+		// Setting a prefix on one peer takes it off any other, so this
+		// switches the traffic in one step.
+		if out, err := exec.Command("wg", "set", o.Interface, "peer", o.PublicKey, "allowed-ips", strings.Join(move, ",")).CombinedOutput(); err != nil {
+			log.Printf("Failed to move allowed IPs to the new key on %s: %v: %s", o.Interface, err, strings.TrimSpace(string(out)))
+			continue
+		}
+		log.Printf("Peer on %s switched to its new WireGuard key", o.Interface)
+	}
+}
+
+// overlapMove returns the allowed IPs to move to the new key of o, or nil if
+// the old key should keep them.
+func overlapMove(o client.KeyOverlap, handshakes map[string]int64, allowed map[string][]string) []string {
+	if handshakes[o.PublicKey] == 0 || handshakes[o.PublicKey] <= handshakes[o.PreviousPublicKey] {
+		return nil
+	}
+	if len(allowed[o.PublicKey]) > 0 {
+		return nil
+	}
+	return allowed[o.PreviousPublicKey]
+}
+
+// parseWGHandshakes parses `wg show <iface> latest-handshakes`.
+func parseWGHandshakes(out string) map[string]int64 {
+	res := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if ts, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			res[fields[0]] = ts
+		}
+	}
+	return res
+}
+
+// parseWGAllowedIPs parses `wg show <iface> allowed-ips`.
+func parseWGAllowedIPs(out string) map[string][]string {
+	res := make(map[string][]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] == "(none)" {
+			res[fields[0]] = nil
+			continue
+		}
+		res[fields[0]] = fields[1:]
+	}
+	return res
+}
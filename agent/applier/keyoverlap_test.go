@@ -0,0 +1,31 @@
+package applier
+
+import (
+	"testing"
+
+	"gluon-agent/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlapMove(t *testing.T) {
+	o := client.KeyOverlap{Interface: "wg-1", PreviousPublicKey: "old", PublicKey: "new"}
+	allowed := parseWGAllowedIPs("old\t10.0.0.2/32 10.1.0.0/31\nnew\t(none)\nother\t10.9.0.0/24\n")
+	assert.Equal(t, []string{"10.0.0.2/32", "10.1.0.0/31"}, allowed["old"])
+	assert.Nil(t, allowed["new"])
+
+	t.Run("no handshake under the new key", func(t *testing.T) {
+		assert.Nil(t, overlapMove(o, parseWGHandshakes("old\t1700000000\nnew\t0\n"), allowed))
+	})
+	t.Run("new key handshook last", func(t *testing.T) {
+		assert.Equal(t, []string{"10.0.0.2/32", "10.1.0.0/31"},
+			overlapMove(o, parseWGHandshakes("old\t1700000000\nnew\t1700000100\n"), allowed))
+	})
+	t.Run("old key still in use", func(t *testing.T) {
+		assert.Nil(t, overlapMove(o, parseWGHandshakes("old\t1700000200\nnew\t1700000100\n"), allowed))
+	})
+	t.Run("already moved", func(t *testing.T) {
+		moved := parseWGAllowedIPs("old\t(none)\nnew\t10.0.0.2/32\n")
+		assert.Nil(t, overlapMove(o, parseWGHandshakes("old\t1700000000\nnew\t1700000100\n"), moved))
+	})
+}
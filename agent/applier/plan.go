@@ -0,0 +1,481 @@
+package applier
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"gluon-agent/client"
+	"gluon-agent/keys"
+	"gluon-agent/pkgmgr"
+)
+
+// Actions a plan takes once its files are written.
+const (
+	ActionRemoveInterface  = "remove_interface"
+	ActionRestartInterface = "restart_interface"
+	ActionBringUp          = "ifup"
+	ActionWireGuardSync    = "wg_syncconf"
+	ActionFRRReload        = "frr_reload"
+	ActionFRRRestart       = "frr_restart"
+)
+
+// File changes.
+const (
+	FileCreate = "create"
+	FileUpdate = "update"
+	FileDelete = "delete"
+)
+
+// frrReloadScript applies FRR config deltas without restarting the daemons.
+var frrReloadScript = "/usr/lib/frr/frr-reload.py"
+
+// Seams for tests.
+var (
+	privateKey = keys.PrivateKeyFor
+	linkUp     = func(iface string) bool {
+		up, err := isLinkUp(iface)
+		return err == nil && up
+	}
+	configureFRRBGP = pkgmgr.ConfigureFRRBGP
+)
+
+// retryReason prefixes the reason of an action carried over from a failed
+// apply.
+const retryReason = "retrying: "
+
+// unfinishedActions are the actions of the last apply that failed. Their
+// files are already on disk, so a new plan would not repeat them; PlanConfig
+// carries them over until they succeed.
+var (
+	unfinishedMu      sync.Mutex
+	unfinishedActions []client.PlannedAction
+)
+
+func setUnfinishedActions(actions []client.PlannedAction) {
+	unfinishedMu.Lock()
+	defer unfinishedMu.Unlock()
+	unfinishedActions = slices.Clone(actions)
+}
+
+type plannedWrite struct {
+	content string
+	mode    os.FileMode
+}
+
+// fileChanges are the files one step of a plan writes and removes.
+type fileChanges struct {
+	writes  map[string]plannedWrite
+	deletes []string
+}
+
+// Plan is a computed client.ConfigPlan together with what ApplyPlan needs
+// to carry it out. The embedded fileChanges are the network files; SSH
+// access is planned separately since it is applied on its own.
+type Plan struct {
+	client.ConfigPlan
+	fileChanges
+
+	ssh    *sshPlan
+	sshErr error
+}
+
+// Empty reports whether applying the plan would touch no files.
+func (p *Plan) Empty() bool {
+	return len(p.Files) == 0 && len(p.Actions) == 0
+}
+
+func (p *Plan) addAction(action, target string, disruptive bool, reason string) {
+	p.Actions = append(p.Actions, client.PlannedAction{
+		Action:     action,
+		Target:     target,
+		Disruptive: disruptive,
+		Reason:     reason,
+	})
+	if disruptive {
+		p.Disruptive = true
+	}
+}
+
+// stageWrite records content for path among the network files if it
+// differs from the file on disk, and reports whether it does.
+func (p *Plan) stageWrite(path, content string, mode os.FileMode) bool {
+	return p.stageWriteIn(&p.fileChanges, path, content, mode)
+}
+
+func (p *Plan) stageDelete(path string) {
+	p.stageDeleteIn(&p.fileChanges, path)
+}
+
+// stageWriteIn is stageWrite for the files of another step.
+func (p *Plan) stageWriteIn(set *fileChanges, path, content string, mode os.FileMode) bool {
+	current, err := os.ReadFile(path)
+	exists := err == nil
+	if exists && string(current) == content {
+		return false
+	}
+	change := FileUpdate
+	if !exists {
+		change = FileCreate
+	}
+	p.Files = append(p.Files, client.PlannedFile{
+		Path:   path,
+		Change: change,
+		Diff:   unifiedDiff(path, string(current), content),
+	})
+	set.writes[path] = plannedWrite{content: content, mode: mode}
+	return true
+}
+
+func (p *Plan) stageDeleteIn(set *fileChanges, path string) {
+	current, _ := os.ReadFile(path)
+	p.Files = append(p.Files, client.PlannedFile{
+		Path:   path,
+		Change: FileDelete,
+		Diff:   unifiedDiff(path, string(current), ""),
+	})
+	set.deletes = append(set.deletes, path)
+}
+
+// PlanConfig works out what applying bundle would change: the files whose
+// content differs from disk and the least disruptive way to bring the
+// running interfaces and FRR in line with them, followed by the SSH access
+// files unless SSH management is off. It changes nothing.
+func PlanConfig(bundle *client.ConfigBundle) (*Plan, error) {
+	p := &Plan{
+		ConfigPlan: client.ConfigPlan{
+			Version: bundle.Version,
+			Hash:    bundle.Hash,
+			Files:   []client.PlannedFile{},
+			Actions: []client.PlannedAction{},
+		},
+		fileChanges: fileChanges{writes: map[string]plannedWrite{}},
+	}
+
+	// WireGuard configs. wg-*.conf files missing from the bundle belong to
+	// tunnels that are gone, e.g. an idle worker shortcut.
+	var removed []string
+	wgChanged := map[string]bool{}
+	if len(bundle.WireGuardConfigs) > 0 {
+		files, _ := filepath.Glob(filepath.Join(WireGuardDir, "wg-*.conf"))
+		for _, f := range files {
+			iface := strings.TrimSuffix(filepath.Base(f), ".conf")
+			if _, ok := bundle.WireGuardConfigs[iface]; !ok {
+				p.stageDelete(f)
+				removed = append(removed, iface)
+			}
+		}
+		names := make([]string, 0, len(bundle.WireGuardConfigs))
+		for iface := range bundle.WireGuardConfigs {
+			names = append(names, iface)
+		}
+		sort.Strings(names)
+		for _, iface := range names {
+			key, err := privateKey(iface, bundle.RotatedKeys[iface])
+			if err != nil {
+				return nil, fmt.Errorf("failed to get private key for %s: %w", iface, err)
+			}
+			content := strings.Replace(bundle.WireGuardConfigs[iface], "PrivateKey = PRIVATE_KEY_PLACEHOLDER", "PrivateKey = "+key, 1)
+			if p.stageWrite(filepath.Join(WireGuardDir, iface+".conf"), content, 0600) {
+				wgChanged[iface] = true
+			}
+		}
+	}
+
+	handled := map[string]bool{}
+	for _, iface := range removed {
+		p.addAction(ActionRemoveInterface, iface, true, "tunnel removed from the config")
+		handled[iface] = true
+	}
+
+	// The interfaces file holds one stanza per link; only links whose
+	// stanza changed are bounced.
+	if strings.TrimSpace(bundle.NetworkInterfaceFile) != "" {
+		path := filepath.Join(NetworkInterfacesDir, "gluon")
+		current, _ := os.ReadFile(path)
+		oldStanzas, _ := parseInterfaceStanzas(string(current))
+		newStanzas, order := parseInterfaceStanzas(bundle.NetworkInterfaceFile)
+		p.stageWrite(path, bundle.NetworkInterfaceFile, 0644)
+
+		var gone []string
+		for name := range oldStanzas {
+			if _, ok := newStanzas[name]; !ok && !handled[name] {
+				gone = append(gone, name)
+			}
+		}
+		sort.Strings(gone)
+		for _, name := range gone {
+			p.addAction(ActionRemoveInterface, name, true, "interface removed from the config")
+			handled[name] = true
+		}
+
+		for _, name := range order {
+			old, existed := oldStanzas[name]
+			switch {
+			case !existed:
+				p.addAction(ActionBringUp, name, false, "new interface")
+			case old != newStanzas[name]:
+				p.addAction(ActionRestartInterface, name, true, "interface definition changed")
+			case !linkUp(name):
+				p.addAction(ActionBringUp, name, false, "interface is down")
+			default:
+				continue
+			}
+			handled[name] = true
+		}
+	}
+
+	// Peer, key and port changes go through `wg syncconf`, which keeps
+	// the link and every unchanged peer session up.
+	var synced []string
+	for iface := range wgChanged {
+		if !handled[iface] {
+			synced = append(synced, iface)
+		}
+	}
+	sort.Strings(synced)
+	for _, iface := range synced {
+		if linkUp(iface) {
+			p.addAction(ActionWireGuardSync, iface, false, "WireGuard peers or keys changed")
+		} else {
+			p.addAction(ActionBringUp, iface, false, "interface is down")
+		}
+	}
+
+	if strings.TrimSpace(bundle.FRRConfigFile) != "" {
+		current, err := os.ReadFile(FRRConfigPath)
+		if p.stageWrite(FRRConfigPath, bundle.FRRConfigFile, 0640) {
+			if reason := frrRestartReason(string(current), bundle.FRRConfigFile, err == nil); reason != "" {
+				p.addAction(ActionFRRRestart, "frr", true, reason)
+			} else {
+				p.addAction(ActionFRRReload, "frr", false, "FRR config changed")
+			}
+		}
+	}
+
+	p.carryUnfinishedActions()
+
+	// SSH access does not fail the plan; ApplyPlan retries it on the next
+	// sync.
+	if !sshDisabled.Load() {
+		p.ssh, p.sshErr = planSSH(p, bundle)
+	}
+	return p, nil
+}
+
+// carryUnfinishedActions adds the actions a failed apply left undone,
+// unless the plan already acts on their target.
+func (p *Plan) carryUnfinishedActions() {
+	unfinishedMu.Lock()
+	carried := slices.Clone(unfinishedActions)
+	unfinishedMu.Unlock()
+
+	planned := map[string]bool{}
+	for _, a := range p.Actions {
+		planned[a.Target] = true
+	}
+	for _, a := range carried {
+		if planned[a.Target] {
+			continue
+		}
+		reason := a.Reason
+		if !strings.HasPrefix(reason, retryReason) {
+			reason = retryReason + reason
+		}
+		p.addAction(a.Action, a.Target, a.Disruptive, reason)
+		planned[a.Target] = true
+	}
+}
+
+// parseInterfaceStanzas splits an ifupdown file into the text of each
+// interface's auto and iface stanzas, keyed by interface name, plus the
+// names in file order.
+func parseInterfaceStanzas(content string) (map[string]string, []string) {
+	stanzas := map[string]string{}
+	var order []string
+	current := ""
+	for _, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if (fields[0] == "auto" || fields[0] == "iface" || fields[0] == "allow-hotplug") && len(fields) > 1 {
+			current = fields[1]
+			if _, seen := stanzas[current]; !seen {
+				order = append(order, current)
+			}
+		}
+		if current == "" {
+			continue
+		}
+		stanzas[current] += line + "\n"
+	}
+	return stanzas, order
+}
+
+// frrRestartReason says why an FRR change needs a full restart rather than
+// frr-reload.py, or "" if a reload is enough. Router IDs are only read at
+// startup and the daemons file decides whether bgpd runs at all.
+func frrRestartReason(old, new string, existed bool) string {
+	switch {
+	case !existed:
+		return "FRR config created"
+	case frrRouterIDs(old) != frrRouterIDs(new):
+		return "router-id changed"
+	case hasBGP(old) != hasBGP(new):
+		return "bgpd enabled or disabled"
+	}
+	return ""
+}
+
+func frrRouterIDs(content string) string {
+	var ids []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ospf router-id ") || strings.HasPrefix(line, "bgp router-id ") {
+			ids = append(ids, line)
+		}
+	}
+	return strings.Join(ids, "\n")
+}
+
+func hasBGP(content string) bool {
+	return strings.Contains("\n"+content, "\nrouter bgp ")
+}
+
+// applyNetworkPlan writes the planned files and carries out the plan's
+// actions. Links that are removed or restarted are taken down before their
+// files change, so ifdown still sees the definition they were brought up
+// with. A failed action doesn't stop the others; the failed ones are
+// carried into the next plan.
+func applyNetworkPlan(p *Plan) error {
+	for _, a := range p.Actions {
+		if a.Action == ActionRemoveInterface || a.Action == ActionRestartInterface {
+			log.Printf("Taking down %s (%s)", a.Target, a.Reason)
+			takeDown(a.Target)
+		}
+	}
+
+	if err := writeNetworkFiles(p); err != nil {
+		setUnfinishedActions(p.Actions)
+		return err
+	}
+
+	restartFRR := false
+	if w, ok := p.writes[FRRConfigPath]; ok {
+		// Hubs run bgpd for native pod routing.
+		changed, err := configureFRRBGP(hasBGP(w.content))
+		if err != nil {
+			log.Printf("Warning: failed to update FRR daemons: %v", err)
+		}
+		restartFRR = changed
+	}
+
+	var failed []client.PlannedAction
+	var errs []error
+	fail := func(a client.PlannedAction, err error) {
+		failed = append(failed, a)
+		errs = append(errs, err)
+	}
+	frrRestart := client.PlannedAction{Action: ActionFRRRestart, Target: "frr", Disruptive: true, Reason: "FRR daemons changed"}
+	for _, a := range p.Actions {
+		switch a.Action {
+		case ActionRestartInterface, ActionBringUp:
+			if err := runCommand("ifup", a.Target); err != nil {
+				fail(a, fmt.Errorf("failed to bring up %s: %w", a.Target, err))
+				continue
+			}
+			log.Printf("Brought up %s (%s)", a.Target, a.Reason)
+		case ActionWireGuardSync:
+			conf := filepath.Join(WireGuardDir, a.Target+".conf")
+			if err := runCommand("wg", "syncconf", a.Target, conf); err != nil {
+				log.Printf("wg syncconf %s failed (%v); restarting the interface", a.Target, err)
+				takeDown(a.Target)
+				if err := runCommand("ifup", a.Target); err != nil {
+					fail(a, fmt.Errorf("failed to bring up %s: %w", a.Target, err))
+					continue
+				}
+			}
+			log.Printf("Synced WireGuard config of %s", a.Target)
+		case ActionFRRReload:
+			if restartFRR {
+				continue
+			}
+			if _, err := os.Stat(frrReloadScript); err != nil {
+				log.Printf("%s not found; restarting FRR instead", frrReloadScript)
+				restartFRR, frrRestart = true, a
+				continue
+			}
+			if err := runCommand(frrReloadScript, "--reload", FRRConfigPath); err != nil {
+				log.Printf("frr-reload failed (%v); restarting FRR instead", err)
+				restartFRR, frrRestart = true, a
+				continue
+			}
+			log.Println("Reloaded FRR config")
+		case ActionFRRRestart:
+			restartFRR, frrRestart = true, a
+		}
+	}
+
+	if restartFRR {
+		log.Println("Restarting FRR...")
+		if err := runCommand("systemctl", "restart", "frr"); err != nil {
+			frrRestart.Action = ActionFRRRestart
+			fail(frrRestart, fmt.Errorf("failed to restart FRR: %w", err))
+		}
+	}
+	setUnfinishedActions(failed)
+	return errors.Join(errs...)
+}
+
+func writeNetworkFiles(p *Plan) error {
+	for _, path := range p.deletes {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		log.Printf("Removed %s", path)
+	}
+	paths := make([]string, 0, len(p.writes))
+	for path := range p.writes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		w := p.writes[path]
+		dirMode := os.FileMode(0755)
+		if filepath.Dir(path) == WireGuardDir {
+			dirMode = 0700
+		}
+		if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(w.content), w.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		log.Printf("Wrote %s", path)
+	}
+	return nil
+}
+
+// takeDown brings iface down and deletes the link if ifdown left it behind.
+// Failures are logged rather than returned: the link may already be gone,
+// and bringing it back up reports anything that matters.
+func takeDown(iface string) {
+	if out, err := exec.Command("ifdown", "--force", iface).CombinedOutput(); err != nil {
+		log.Printf("Warning: ifdown %s failed: %v: %s", iface, err, strings.TrimSpace(string(out)))
+	}
+	if _, err := isLinkUp(iface); err != nil {
+		return
+	}
+	if out, err := exec.Command("ip", "link", "delete", iface).CombinedOutput(); err != nil {
+		log.Printf("Warning: failed to delete link %s: %v: %s", iface, err, strings.TrimSpace(string(out)))
+	}
+}
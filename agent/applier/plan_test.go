@@ -0,0 +1,311 @@
+package applier
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gluon-agent/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const planInterfaces = `auto dummy
+iface dummy inet static
+    address 10.0.0.1/32
+    pre-up ip link add dummy type dummy
+
+auto wg-1
+iface wg-1 inet static
+    address 10.1.0.1/31
+    pre-up ip link add wg-1 type wireguard
+    pre-up /usr/bin/wg setconf wg-1 /etc/wireguard/wg-1.conf
+`
+
+const planWireGuard = `[Interface]
+ListenPort = 51820
+PrivateKey = PRIVATE_KEY_PLACEHOLDER
+
+[Peer]
+PublicKey = peer-a
+AllowedIPs = 0.0.0.0/0
+`
+
+const planFRR = `router ospf
+ ospf router-id 10.0.0.1
+ network 10.1.0.0/31 area 0
+`
+
+func setupPlanDirs(t *testing.T, up map[string]bool) {
+	dir := t.TempDir()
+	wgDir, ifDir, frrPath, origKey, origUp := WireGuardDir, NetworkInterfacesDir, FRRConfigPath, privateKey, linkUp
+	sshRoot, sudoers, sshState := sshConfigRoot, sudoersPath, SSHStateFilePath
+	WireGuardDir = filepath.Join(dir, "wireguard")
+	NetworkInterfacesDir = filepath.Join(dir, "interfaces.d")
+	FRRConfigPath = filepath.Join(dir, "frr.conf")
+	sshConfigRoot = filepath.Join(dir, "ssh")
+	sudoersPath = filepath.Join(dir, "sudoers.d", "90-gluon")
+	SSHStateFilePath = filepath.Join(dir, "ssh-state.json")
+	privateKey = func(string, string) (string, error) { return "secret-key", nil }
+	linkUp = func(iface string) bool { return up[iface] }
+	t.Cleanup(func() {
+		WireGuardDir, NetworkInterfacesDir, FRRConfigPath = wgDir, ifDir, frrPath
+		sshConfigRoot, sudoersPath, SSHStateFilePath = sshRoot, sudoers, sshState
+		privateKey, linkUp = origKey, origUp
+	})
+}
+
+// writePlan writes the plan's network files the way applyNetworkPlan does,
+// without touching any links.
+func writePlan(t *testing.T, p *Plan) {
+	writeFiles(t, &p.fileChanges)
+}
+
+func writeFiles(t *testing.T, set *fileChanges) {
+	for _, path := range set.deletes {
+		require.NoError(t, os.Remove(path))
+	}
+	for path, w := range set.writes {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(w.content), w.mode))
+	}
+}
+
+func planBundle() *client.ConfigBundle {
+	return &client.ConfigBundle{
+		Version:              1,
+		Hash:                 "h1",
+		WireGuardConfigs:     map[string]string{"wg-1": planWireGuard},
+		NetworkInterfaceFile: planInterfaces,
+		FRRConfigFile:        planFRR,
+	}
+}
+
+func actions(p *Plan) []string {
+	var out []string
+	for _, a := range p.Actions {
+		out = append(out, a.Action+" "+a.Target)
+	}
+	return out
+}
+
+func TestPlanConfigFirstApply(t *testing.T) {
+	setupPlanDirs(t, nil)
+
+	p, err := PlanConfig(planBundle())
+	require.NoError(t, err)
+	assert.Len(t, p.Files, 3)
+	for _, f := range p.Files {
+		assert.Equal(t, FileCreate, f.Change)
+	}
+	assert.Equal(t, []string{"ifup dummy", "ifup wg-1", "frr_restart frr"}, actions(p))
+	assert.True(t, p.Disruptive)
+
+	wg := p.writes[filepath.Join(WireGuardDir, "wg-1.conf")]
+	assert.Contains(t, wg.content, "PrivateKey = secret-key")
+	assert.Equal(t, os.FileMode(0600), wg.mode)
+	for _, f := range p.Files {
+		assert.NotContains(t, f.Diff, "secret-key")
+	}
+}
+
+func TestPlanConfigUnchanged(t *testing.T) {
+	setupPlanDirs(t, map[string]bool{"dummy": true, "wg-1": true})
+	p, err := PlanConfig(planBundle())
+	require.NoError(t, err)
+	writePlan(t, p)
+
+	p, err = PlanConfig(planBundle())
+	require.NoError(t, err)
+	assert.True(t, p.Empty())
+	assert.False(t, p.Disruptive)
+}
+
+func TestPlanConfigPeerChangeUsesSyncconf(t *testing.T) {
+	setupPlanDirs(t, map[string]bool{"dummy": true, "wg-1": true})
+	p, err := PlanConfig(planBundle())
+	require.NoError(t, err)
+	writePlan(t, p)
+
+	bundle := planBundle()
+	bundle.WireGuardConfigs["wg-1"] += "\n[Peer]\nPublicKey = peer-b\nAllowedIPs = 10.9.0.0/24\n"
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg_syncconf wg-1"}, actions(p))
+	assert.False(t, p.Disruptive)
+	require.Len(t, p.Files, 1)
+	assert.Equal(t, FileUpdate, p.Files[0].Change)
+	assert.Contains(t, p.Files[0].Diff, "+PublicKey = peer-b\n")
+}
+
+func TestPlanConfigInterfaceChanges(t *testing.T) {
+	setupPlanDirs(t, map[string]bool{"dummy": true, "wg-1": true})
+	bundle := planBundle()
+	bundle.WireGuardConfigs["wg-2"] = planWireGuard
+	p, err := PlanConfig(bundle)
+	require.NoError(t, err)
+	writePlan(t, p)
+
+	// wg-2 is dropped and wg-1 gains an MTU; dummy is untouched.
+	bundle = planBundle()
+	bundle.NetworkInterfaceFile = planInterfaces[:len(planInterfaces)-len("    pre-up /usr/bin/wg setconf wg-1 /etc/wireguard/wg-1.conf\n")] +
+		"    mtu 1420\n    pre-up /usr/bin/wg setconf wg-1 /etc/wireguard/wg-1.conf\n"
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remove_interface wg-2", "restart_interface wg-1"}, actions(p))
+	assert.True(t, p.Disruptive)
+	assert.Equal(t, []string{filepath.Join(WireGuardDir, "wg-2.conf")}, p.deletes)
+}
+
+func TestPlanConfigFRR(t *testing.T) {
+	setupPlanDirs(t, map[string]bool{"dummy": true, "wg-1": true})
+	p, err := PlanConfig(planBundle())
+	require.NoError(t, err)
+	writePlan(t, p)
+
+	bundle := planBundle()
+	bundle.FRRConfigFile += " network 10.1.0.2/31 area 0\n"
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"frr_reload frr"}, actions(p))
+	assert.False(t, p.Disruptive)
+
+	bundle.FRRConfigFile = planFRR + "router bgp 64512\n bgp router-id 10.0.0.1\n"
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"frr_restart frr"}, actions(p))
+
+	bundle.FRRConfigFile = "router ospf\n ospf router-id 10.0.0.2\n"
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	require.Len(t, p.Actions, 1)
+	assert.Equal(t, "router-id changed", p.Actions[0].Reason)
+}
+
+func TestApplyPlanRetriesFailedActions(t *testing.T) {
+	setupPlanDirs(t, map[string]bool{"dummy": true, "wg-1": true})
+	origState, origRun, origBGP, origReload := StateFilePath, runCommand, configureFRRBGP, frrReloadScript
+	StateFilePath = filepath.Join(t.TempDir(), "state.json")
+	configureFRRBGP = func(bool) (bool, error) { return false, nil }
+	frrReloadScript = filepath.Join(t.TempDir(), "frr-reload.py")
+	require.NoError(t, os.WriteFile(frrReloadScript, nil, 0755))
+	SetManageSSH(false)
+	t.Cleanup(func() {
+		StateFilePath, runCommand, configureFRRBGP, frrReloadScript = origState, origRun, origBGP, origReload
+		SetManageSSH(true)
+		forceReapply.Store(false)
+		setUnfinishedActions(nil)
+	})
+
+	var ran []string
+	failing := map[string]bool{"ifup wg-2": true, "systemctl restart frr": true}
+	runCommand = func(name string, args ...string) error {
+		cmd := strings.Join(append([]string{filepath.Base(name)}, args[:min(len(args), 2)]...), " ")
+		ran = append(ran, cmd)
+		if failing[cmd] {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	p, err := PlanConfig(planBundle())
+	require.NoError(t, err)
+	writePlan(t, p)
+
+	// A new link that fails to come up must not hold back the peer and
+	// route changes planned with it. FRR fails too.
+	bundle := planBundle()
+	bundle.Version = 2
+	bundle.WireGuardConfigs["wg-2"] = planWireGuard
+	bundle.WireGuardConfigs["wg-1"] += "\n[Peer]\nPublicKey = peer-b\nAllowedIPs = 10.9.0.0/24\n"
+	bundle.FRRConfigFile += " network 10.1.0.2/31 area 0\n"
+	p, err = PlanConfig(bundle)
+	failing["frr-reload.py --reload "+FRRConfigPath] = true
+	require.NoError(t, err)
+	require.Equal(t, []string{"wg_syncconf wg-1", "ifup wg-2", "frr_reload frr"}, actions(p))
+	require.Error(t, ApplyPlan(bundle, p))
+	assert.Equal(t, []string{"wg syncconf wg-1", "ifup wg-2", "frr-reload.py --reload " + FRRConfigPath, "systemctl restart frr"}, ran)
+	assert.True(t, NeedsUpdate(bundle, &ConfigState{Version: 2, Hash: bundle.Hash}))
+
+	// The files already match and FRR's state can't be seen from them, so
+	// only the carried-over action brings FRR up to date.
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ifup wg-2", "frr_restart frr"}, actions(p))
+	assert.True(t, strings.HasPrefix(p.Actions[1].Reason, "retrying: "))
+	assert.Empty(t, p.Files)
+
+	ran, failing = nil, nil
+	require.NoError(t, ApplyPlan(bundle, p))
+	assert.Equal(t, []string{"ifup wg-2", "systemctl restart frr"}, ran)
+	assert.False(t, NeedsUpdate(bundle, &ConfigState{Version: 2, Hash: bundle.Hash}))
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.True(t, p.Empty())
+}
+
+func TestParseInterfaceStanzas(t *testing.T) {
+	stanzas, order := parseInterfaceStanzas("# managed\n" + planInterfaces)
+	assert.Equal(t, []string{"dummy", "wg-1"}, order)
+	assert.Equal(t, "auto dummy\niface dummy inet static\naddress 10.0.0.1/32\npre-up ip link add dummy type dummy\n", stanzas["dummy"])
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Empty(t, unifiedDiff("f", "a\nb\n", "a\nb\n"))
+
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	new := "1\n2\n3\nfour\n5\n6\n7\n8\n9\nten\n"
+	assert.Equal(t, "--- f\n+++ f\n"+
+		"@@ -2,5 +2,5 @@\n 2\n 3\n-4\n+four\n 5\n 6\n"+
+		"@@ -8,2 +8,3 @@\n 8\n 9\n+ten\n", unifiedDiff("f", old, new))
+}
+
+func TestPlanConfigSSH(t *testing.T) {
+	setupPlanDirs(t, nil)
+	bundle := &client.ConfigBundle{
+		Version:   1,
+		SSHCA:     &client.SSHCA{TrustedUserCAKeys: []string{"ssh-ed25519 AAAA ca"}},
+		SudoRules: []client.SudoRule{{Username: "deploy", Commands: []string{"ALL"}}},
+	}
+
+	p, err := PlanConfig(bundle)
+	require.NoError(t, err)
+	require.NoError(t, p.sshErr)
+	assert.Empty(t, p.writes, "SSH files are kept apart from the network files")
+	dropIn, _, _, _ := sshCAPaths()
+	assert.Contains(t, p.ssh.writes, dropIn)
+	assert.Contains(t, p.ssh.writes, sudoersPath)
+	assert.True(t, p.ssh.reloadSSHD)
+	var paths []string
+	for _, f := range p.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Contains(t, paths, sudoersPath, "SSH files are part of the reported plan")
+
+	writeFiles(t, &p.ssh.fileChanges)
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.True(t, p.Empty(), "an unchanged bundle writes nothing, SSH included")
+	assert.False(t, p.ssh.reloadSSHD)
+
+	SetManageSSH(false)
+	t.Cleanup(func() { SetManageSSH(true); forceReapply.Store(false) })
+	bundle.SudoRules = nil
+	p, err = PlanConfig(bundle)
+	require.NoError(t, err)
+	assert.Nil(t, p.ssh)
+	assert.True(t, p.Empty(), "SSH files are left alone while SSH management is off")
+}
+
+func TestRedactSecrets(t *testing.T) {
+	out := redactSecrets("[Interface]\nPrivateKey = abc\n\n[Peer]\nPublicKey = def\nPresharedKey = ghi\n")
+	assert.NotContains(t, out, "abc")
+	assert.NotContains(t, out, "ghi")
+	assert.Contains(t, out, "PrivateKey = (redacted sha256:")
+	assert.Contains(t, out, "PresharedKey = (redacted sha256:")
+	assert.Contains(t, out, "PublicKey = def")
+	assert.NotEqual(t, out, redactSecrets("PrivateKey = xyz\n"))
+}
@@ -3,30 +3,32 @@ package applier
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"gluon-agent/client"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// SSHStateFilePath records the users whose keys the agent manages; tests
+// point it at a temporary directory.
+var SSHStateFilePath = "/var/lib/gluon/ssh-state.json"
+
 const (
-	SSHStateFilePath  = "/var/lib/gluon/ssh-state.json"
-	sshManagedBegin   = "# BEGIN GLUON MANAGED KEYS"
-	sshManagedEnd     = "# END GLUON MANAGED KEYS"
+	sshManagedBegin = "# BEGIN GLUON MANAGED KEYS"
+	sshManagedEnd   = "# END GLUON MANAGED KEYS"
 )
 
 type sshState struct {
 	ManagedUsers map[string][]string `json:"managed_users"`
 }
 
-func applySSHAuthorizedKeys(keys []client.SSHAuthorizedKey) error {
+// planAuthorizedKeys stages the managed block of each user's
+// authorized_keys: users in keys get theirs, users the agent managed before
+// have theirs emptied.
+func planAuthorizedKeys(p *Plan, sp *sshPlan, keys []client.SSHAuthorizedKey) error {
 	state, _ := loadSSHState()
-	prevUsers := make(map[string]bool)
-	for u := range state.ManagedUsers {
-		prevUsers[u] = true
-	}
 
 	byUser := make(map[string][]string)
 	for _, k := range keys {
@@ -38,55 +40,53 @@ func applySSHAuthorizedKeys(keys []client.SSHAuthorizedKey) error {
 		byUser[user] = append(byUser[user], line)
 	}
 
-	
-	for username, lines := range byUser {
-		if err := ensureUserExists(username); err != nil {
-			return err
-		}
-		homeDir, err := userHomeDir(username)
-		if err != nil {
-			return err
-		}
-		if err := reconcileAuthorizedKeys(username, homeDir, lines); err != nil {
+	users := make([]string, 0, len(byUser))
+	for username := range byUser {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	for _, username := range users {
+		sp.users = append(sp.users, username)
+		if err := stageAuthorizedKeys(p, sp, username, byUser[username]); err != nil {
 			return err
 		}
-		delete(prevUsers, username)
 	}
 
-	
-	for username := range prevUsers {
+	var dropped []string
+	for username := range state.ManagedUsers {
+		if _, ok := byUser[username]; !ok {
+			dropped = append(dropped, username)
+		}
+	}
+	sort.Strings(dropped)
+	for _, username := range dropped {
 		if err := exec.Command("id", "-u", username).Run(); err != nil {
-			
 			continue
 		}
-		homeDir, err := userHomeDir(username)
-		if err != nil {
-			return err
-		}
-		if err := reconcileAuthorizedKeys(username, homeDir, nil); err != nil {
+		if err := stageAuthorizedKeys(p, sp, username, nil); err != nil {
 			return err
 		}
 	}
 
-	
-	next := make(map[string][]string)
+	sp.managedUsers = make(map[string][]string)
 	for username, lines := range byUser {
-		clean := make([]string, 0, len(lines))
-		seen := make(map[string]bool)
-		for _, l := range lines {
-			ll := strings.TrimSpace(l)
-			if ll == "" || seen[ll] {
-				continue
-			}
-			seen[ll] = true
-			clean = append(clean, ll)
-		}
-		if len(clean) > 0 {
-			next[username] = clean
+		if clean := normalizeKeyLines(lines); len(clean) > 0 {
+			sp.managedUsers[username] = clean
 		}
 	}
-	_ = saveSSHState(&sshState{ManagedUsers: next})
+	return nil
+}
 
+func stageAuthorizedKeys(p *Plan, sp *sshPlan, username string, publicKeys []string) error {
+	homeDir, err := userHomeDir(username)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(homeDir, ".ssh", "authorized_keys")
+	existing, _ := os.ReadFile(path)
+	if p.stageWriteIn(&sp.fileChanges, path, renderAuthorizedKeys(string(existing), publicKeys), 0600) {
+		sp.keyOwners[path] = username
+	}
 	return nil
 }
 
@@ -114,31 +114,11 @@ func userHomeDir(username string) (string, error) {
 	return filepath.Join("/home", username), nil
 }
 
-func reconcileAuthorizedKeys(username string, homeDir string, publicKeys []string) error {
-	sshDir := filepath.Join(homeDir, ".ssh")
-	authKeysPath := filepath.Join(sshDir, "authorized_keys")
-
-	if err := os.MkdirAll(sshDir, 0700); err != nil {
-		return fmt.Errorf("failed to create %s: %w", sshDir, err)
-	}
-	_ = os.Chmod(sshDir, 0700)
-
-	f, err := os.OpenFile(authKeysPath, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open %s: %w", authKeysPath, err)
-	}
-	defer f.Close()
-	_ = os.Chmod(authKeysPath, 0600)
-
-	existingBytes, _ := os.ReadFile(authKeysPath)
-	existingText := string(existingBytes)
-
-	base := stripManagedBlock(existingText)
-
-	desiredKeys := normalizeKeyLines(publicKeys)
-	managed := renderManagedBlock(desiredKeys)
-
-	baseTrim := strings.TrimRight(base, "\n")
+// renderAuthorizedKeys replaces the managed block of an authorized_keys
+// file with publicKeys, keeping every line outside it.
+func renderAuthorizedKeys(existing string, publicKeys []string) string {
+	baseTrim := strings.TrimRight(stripManagedBlock(existing), "\n")
+	managed := renderManagedBlock(normalizeKeyLines(publicKeys))
 	nextText := baseTrim
 	if managed != "" {
 		if baseTrim != "" {
@@ -149,17 +129,8 @@ func reconcileAuthorizedKeys(username string, homeDir string, publicKeys []strin
 	}
 	if !strings.HasSuffix(nextText, "\n") {
 		nextText += "\n"
-	 }
-
-	if nextText != existingText {
-		if err := os.WriteFile(authKeysPath, []byte(nextText), 0600); err != nil {
-			return fmt.Errorf("failed to write %s: %w", authKeysPath, err)
-		}
 	}
-
-	
-	_ = runCommand("chown", "-R", fmt.Sprintf("%s:%s", username, username), sshDir)
-	return nil
+	return nextText
 }
 
 func stripManagedBlock(content string) string {
@@ -0,0 +1,156 @@
+package applier
+
+import (
+	"bytes"
+	"fmt"
+	"gluon-agent/client"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sshConfigRoot is where sshd reads its configuration; tests point it at a
+// temporary directory.
+var sshConfigRoot = "/etc/ssh"
+
+const sshCADropInName = "60-gluon-ca.conf"
+
+func sshCAPaths() (dropIn, caKeys, principalsDir, revoked string) {
+	dir := filepath.Join(sshConfigRoot, "gluon")
+	return filepath.Join(sshConfigRoot, "sshd_config.d", sshCADropInName),
+		filepath.Join(dir, "user_ca.pub"),
+		filepath.Join(dir, "principals"),
+		filepath.Join(dir, "revoked_keys")
+}
+
+// planSSHCA stages the CA key, the per-user principals and the revoked
+// keys, and the drop-in pointing sshd at them. A nil spec removes the
+// drop-in again.
+func planSSHCA(p *Plan, sp *sshPlan, spec *client.SSHCA) error {
+	dropIn, caKeys, principalsDir, revoked := sshCAPaths()
+
+	if spec == nil || len(spec.TrustedUserCAKeys) == 0 {
+		if _, err := os.Stat(dropIn); err == nil {
+			p.stageDeleteIn(&sp.fileChanges, dropIn)
+			sp.reloadSSHD = true
+		}
+		return nil
+	}
+
+	write := func(path, content string) {
+		if p.stageWriteIn(&sp.fileChanges, path, content, 0644) {
+			sp.reloadSSHD = true
+		}
+	}
+
+	if content, ok, err := sshdConfigWithDropIns(); err != nil {
+		return err
+	} else if ok {
+		write(filepath.Join(sshConfigRoot, "sshd_config"), content)
+	}
+	write(caKeys, joinLines(spec.TrustedUserCAKeys))
+	// sshd refuses every key when RevokedKeys names a missing file, so it is
+	// written even when empty.
+	write(revoked, joinLines(spec.RevokedKeys))
+
+	var logins []string
+	for login := range spec.Principals {
+		if login == "" || strings.ContainsAny(login, "/.") {
+			continue
+		}
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	wanted := map[string]bool{}
+	for _, login := range logins {
+		wanted[login] = true
+		write(filepath.Join(principalsDir, login), joinLines(spec.Principals[login]))
+	}
+	entries, _ := os.ReadDir(principalsDir)
+	for _, e := range entries {
+		if !wanted[e.Name()] {
+			p.stageDeleteIn(&sp.fileChanges, filepath.Join(principalsDir, e.Name()))
+			sp.reloadSSHD = true
+		}
+	}
+
+	write(dropIn, renderSSHCADropIn(caKeys, principalsDir, revoked))
+	if sp.reloadSSHD {
+		sp.users = append(sp.users, logins...)
+	}
+	return nil
+}
+
+// checkAndReloadSSHD has sshd check the configuration the plan wrote and
+// reloads it. A configuration sshd rejects loses the drop-in, so sshd keeps
+// running on its own settings.
+func checkAndReloadSSHD() error {
+	if err := exec.Command("sshd", "-t").Run(); err != nil {
+		dropIn, _, _, _ := sshCAPaths()
+		_ = os.Remove(dropIn)
+		return fmt.Errorf("sshd rejected the CA configuration: %w", err)
+	}
+	if err := reloadSSHD(); err != nil {
+		return err
+	}
+	log.Println("SSH CA configuration applied")
+	return nil
+}
+
+func renderSSHCADropIn(caKeys, principalsDir, revoked string) string {
+	var buf bytes.Buffer
+	buf.WriteString("# Managed by gluon-agent; changes will be overwritten.\n")
+	fmt.Fprintf(&buf, "TrustedUserCAKeys %s\n", caKeys)
+	fmt.Fprintf(&buf, "AuthorizedPrincipalsFile %s/%%u\n", principalsDir)
+	fmt.Fprintf(&buf, "RevokedKeys %s\n", revoked)
+	return buf.String()
+}
+
+// sshdConfigWithDropIns returns sshd_config with the sshd_config.d Include
+// that older distributions lack, and whether it was missing; without it
+// the drop-in would be ignored.
+func sshdConfigWithDropIns() (string, bool, error) {
+	path := filepath.Join(sshConfigRoot, "sshd_config")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], "Include") && strings.Contains(fields[1], "sshd_config.d") {
+			return "", false, nil
+		}
+	}
+	// Include must come before any Match block, so it goes first.
+	include := fmt.Sprintf("Include %s/*.conf\n", filepath.Join(sshConfigRoot, "sshd_config.d"))
+	return include + string(data), true, nil
+}
+
+func reloadSSHD() error {
+	for _, unit := range []string{"ssh", "sshd"} {
+		if err := exec.Command("systemctl", "reload", unit).Run(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to reload sshd")
+}
+
+func joinLines(lines []string) string {
+	clean := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			clean = append(clean, l)
+		}
+	}
+	sort.Strings(clean)
+	if len(clean) == 0 {
+		return ""
+	}
+	return strings.Join(clean, "\n") + "\n"
+}
@@ -0,0 +1,75 @@
+package applier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gluon-agent/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSSHPlan() *sshPlan {
+	return &sshPlan{fileChanges: fileChanges{writes: map[string]plannedWrite{}}, keyOwners: map[string]string{}}
+}
+
+// planAndWriteSSHCA plans spec and writes the staged files.
+func planAndWriteSSHCA(t *testing.T, spec *client.SSHCA) *sshPlan {
+	sp := newSSHPlan()
+	require.NoError(t, planSSHCA(&Plan{}, sp, spec))
+	writeFiles(t, &sp.fileChanges)
+	return sp
+}
+
+func TestPlanSSHCA(t *testing.T) {
+	sshConfigRoot = t.TempDir()
+	t.Cleanup(func() { sshConfigRoot = "/etc/ssh" })
+	require.NoError(t, os.WriteFile(filepath.Join(sshConfigRoot, "sshd_config"), []byte("PermitRootLogin no\n"), 0644))
+
+	spec := &client.SSHCA{
+		TrustedUserCAKeys: []string{"ssh-ed25519 AAAA gluon-ssh-user-ca"},
+		Principals:        map[string][]string{"root": {"root@node-3", "root@all-nodes"}},
+	}
+	sp := planAndWriteSSHCA(t, spec)
+	assert.True(t, sp.reloadSSHD)
+	assert.Equal(t, []string{"root"}, sp.users)
+
+	dropIn, caKeys, principalsDir, revoked := sshCAPaths()
+	data, err := os.ReadFile(dropIn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "AuthorizedPrincipalsFile "+principalsDir+"/%u\n")
+	data, err = os.ReadFile(caKeys)
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAA gluon-ssh-user-ca\n", string(data))
+	data, err = os.ReadFile(filepath.Join(principalsDir, "root"))
+	require.NoError(t, err)
+	assert.Equal(t, "root@all-nodes\nroot@node-3\n", string(data))
+	data, err = os.ReadFile(revoked)
+	require.NoError(t, err)
+	assert.Empty(t, data, "revoked keys file exists even when empty")
+	data, err = os.ReadFile(filepath.Join(sshConfigRoot, "sshd_config"))
+	require.NoError(t, err)
+	assert.Equal(t, "Include "+filepath.Join(sshConfigRoot, "sshd_config.d")+"/*.conf\nPermitRootLogin no\n", string(data))
+
+	sp = planAndWriteSSHCA(t, spec)
+	assert.False(t, sp.reloadSSHD, "unchanged spec rewrites nothing")
+	assert.Empty(t, sp.writes)
+	assert.Empty(t, sp.users)
+
+	spec.Principals = map[string][]string{"ops": {"ops@node-3"}}
+	spec.RevokedKeys = []string{"ssh-ed25519 BBBB"}
+	sp = planAndWriteSSHCA(t, spec)
+	assert.True(t, sp.reloadSSHD)
+	_, err = os.Stat(filepath.Join(principalsDir, "root"))
+	assert.True(t, os.IsNotExist(err), "principals of dropped logins are removed")
+
+	sp = planAndWriteSSHCA(t, nil)
+	assert.True(t, sp.reloadSSHD)
+	_, err = os.Stat(dropIn)
+	assert.True(t, os.IsNotExist(err))
+
+	sp = planAndWriteSSHCA(t, nil)
+	assert.False(t, sp.reloadSSHD, "nothing left to remove")
+}
@@ -0,0 +1,102 @@
+package applier
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"gluon-agent/client"
+)
+
+// sshPlan is the SSH access part of a plan: authorized keys, the SSH CA
+// and sudo rules. Its files are staged like the network files, so a sync
+// that changes nothing here writes nothing.
+type sshPlan struct {
+	fileChanges
+
+	// users are the accounts created before any file is written.
+	users []string
+	// keyOwners maps each authorized_keys file written to its user.
+	keyOwners map[string]string
+	// reloadSSHD is set when the CA files change.
+	reloadSSHD bool
+	// managedUsers is the SSH state saved once the keys are written.
+	managedUsers map[string][]string
+}
+
+// planSSH stages the SSH access files of bundle on p.
+func planSSH(p *Plan, bundle *client.ConfigBundle) (*sshPlan, error) {
+	sp := &sshPlan{
+		fileChanges: fileChanges{writes: map[string]plannedWrite{}},
+		keyOwners:   map[string]string{},
+	}
+	if err := planAuthorizedKeys(p, sp, bundle.SSHAuthorizedKeys); err != nil {
+		return nil, fmt.Errorf("failed to plan SSH keys: %w", err)
+	}
+	if err := planSSHCA(p, sp, bundle.SSHCA); err != nil {
+		return nil, fmt.Errorf("failed to plan SSH CA: %w", err)
+	}
+	planSudoRules(p, sp, bundle.SudoRules)
+	return sp, nil
+}
+
+// applySSHPlan creates the users, writes the staged files and has sshd pick
+// up a changed CA.
+func applySSHPlan(sp *sshPlan) error {
+	users := slices.Clone(sp.users)
+	sort.Strings(users)
+	for _, username := range slices.Compact(users) {
+		if err := ensureUserExists(username); err != nil {
+			return fmt.Errorf("failed to create user %s: %w", username, err)
+		}
+	}
+
+	paths := make([]string, 0, len(sp.writes))
+	for path := range sp.writes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		w := sp.writes[path]
+		if path == sudoersPath {
+			if err := installSudoers(w.content); err != nil {
+				return err
+			}
+			continue
+		}
+		dirMode := os.FileMode(0755)
+		if _, ok := sp.keyOwners[path]; ok {
+			dirMode = 0700
+		}
+		if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(w.content), w.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if username, ok := sp.keyOwners[path]; ok {
+			sshDir := filepath.Dir(path)
+			_ = os.Chmod(sshDir, 0700)
+			_ = os.Chmod(path, w.mode)
+			_ = runCommand("chown", "-R", fmt.Sprintf("%s:%s", username, username), sshDir)
+		}
+		log.Printf("Wrote %s", path)
+	}
+	for _, path := range sp.deletes {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		log.Printf("Removed %s", path)
+	}
+	if sp.managedUsers != nil {
+		_ = saveSSHState(&sshState{ManagedUsers: sp.managedUsers})
+	}
+
+	if sp.reloadSSHD {
+		return checkAndReloadSSHD()
+	}
+	return nil
+}
@@ -153,3 +153,19 @@ func TestNormalizeKeyLines(t *testing.T) {
 		}, result)
 	})
 }
+
+func TestRenderAuthorizedKeys(t *testing.T) {
+	existing := "ssh-rsa AAAA user@host\n\n" +
+		"# BEGIN GLUON MANAGED KEYS\n" +
+		"ssh-ed25519 OLD managed@host\n" +
+		"# END GLUON MANAGED KEYS\n"
+
+	out := renderAuthorizedKeys(existing, []string{"ssh-ed25519 NEW managed@host"})
+	assert.Equal(t, "ssh-rsa AAAA user@host\n\n"+
+		"# BEGIN GLUON MANAGED KEYS\n"+
+		"ssh-ed25519 NEW managed@host\n"+
+		"# END GLUON MANAGED KEYS\n", out)
+	assert.Equal(t, out, renderAuthorizedKeys(out, []string{"ssh-ed25519 NEW managed@host"}), "stable once written")
+
+	assert.Equal(t, "ssh-rsa AAAA user@host\n", renderAuthorizedKeys(existing, nil), "dropped user keeps own keys")
+}
@@ -0,0 +1,91 @@
+package applier
+
+import (
+	"bytes"
+	"fmt"
+	"gluon-agent/client"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// sudoersPath is the drop-in holding the access group sudo rules; tests
+// point it at a temporary directory.
+var sudoersPath = "/etc/sudoers.d/90-gluon"
+
+var sudoUsernameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*[$]?$`)
+
+// planSudoRules stages the sudo rules drop-in; no rules removes it.
+func planSudoRules(p *Plan, sp *sshPlan, rules []client.SudoRule) {
+	content := renderSudoers(rules)
+	if content == "" {
+		if _, err := os.Stat(sudoersPath); err == nil {
+			p.stageDeleteIn(&sp.fileChanges, sudoersPath)
+		}
+		return
+	}
+	if !p.stageWriteIn(&sp.fileChanges, sudoersPath, content, 0440) {
+		return
+	}
+	for _, r := range rules {
+		if sudoUsernameRe.MatchString(r.Username) && len(r.Commands) > 0 {
+			sp.users = append(sp.users, r.Username)
+		}
+	}
+}
+
+// installSudoers checks content with visudo before it replaces the drop-in,
+// so a bad rule can never lock sudo out.
+func installSudoers(content string) error {
+	if err := os.MkdirAll(filepath.Dir(sudoersPath), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(sudoersPath), ".gluon.tmp")
+	if err := os.WriteFile(tmp, []byte(content), 0440); err != nil {
+		return err
+	}
+	if out, err := exec.Command("visudo", "-cf", tmp).CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("visudo rejected sudo rules: %s", strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, sudoersPath); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	log.Println("Applied sudo rules")
+	return nil
+}
+
+func renderSudoers(rules []client.SudoRule) string {
+	lines := make([]string, 0, len(rules))
+	for _, r := range rules {
+		if !sudoUsernameRe.MatchString(r.Username) || len(r.Commands) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(r.Commands, func(c string) bool { return strings.ContainsAny(c, "\r\n") }) {
+			log.Printf("Skipping sudo rule for %s: command spans lines", r.Username)
+			continue
+		}
+		tag := ""
+		if r.NoPassword {
+			tag = "NOPASSWD: "
+		}
+		lines = append(lines, fmt.Sprintf("%s ALL=(ALL) %s%s", r.Username, tag, strings.Join(r.Commands, ", ")))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Managed by gluon-agent; changes will be overwritten.\n")
+	for _, l := range lines {
+		buf.WriteString(l + "\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,43 @@
+package applier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gluon-agent/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSudoers(t *testing.T) {
+	assert.Empty(t, renderSudoers(nil))
+
+	out := renderSudoers([]client.SudoRule{
+		{Username: "deploy", Commands: []string{"/usr/bin/systemctl", "/usr/bin/journalctl"}, NoPassword: true},
+		{Username: "alice", Commands: []string{"ALL"}},
+		{Username: "Bad User", Commands: []string{"ALL"}},
+		{Username: "bob"},
+		{Username: "eve", Commands: []string{"/bin/true\neve ALL=(ALL) NOPASSWD: ALL"}},
+	})
+	assert.Equal(t, "# Managed by gluon-agent; changes will be overwritten.\n"+
+		"alice ALL=(ALL) ALL\n"+
+		"deploy ALL=(ALL) NOPASSWD: /usr/bin/systemctl, /usr/bin/journalctl\n", out)
+}
+
+func TestPlanSudoRulesRemovesDropIn(t *testing.T) {
+	sudoersPath = filepath.Join(t.TempDir(), "90-gluon")
+	t.Cleanup(func() { sudoersPath = "/etc/sudoers.d/90-gluon" })
+
+	sp := newSSHPlan()
+	planSudoRules(&Plan{}, sp, nil)
+	assert.Empty(t, sp.deletes, "missing drop-in is fine")
+
+	require.NoError(t, os.WriteFile(sudoersPath, []byte("alice ALL=(ALL) ALL\n"), 0440))
+	planSudoRules(&Plan{}, sp, nil)
+	assert.Equal(t, []string{sudoersPath}, sp.deletes)
+	require.NoError(t, applySSHPlan(sp))
+	_, err := os.Stat(sudoersPath)
+	assert.True(t, os.IsNotExist(err))
+}
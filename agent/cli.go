@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gluon-agent/client"
+	"gluon-agent/config"
+	"gluon-agent/status"
+)
+
+const usage = `usage: gluon-agent [command]
+
+Without a command the agent runs in the foreground.
+
+Commands:
+  status [--json]   show what the running agent is doing
+  sync --now        make the running agent sync its config immediately
+  plan [--json]     show what applying the current config would change
+`
+
+// runSubcommand runs a CLI command against the agent's local status
+// socket and returns the process exit code.
+func runSubcommand(name string, args []string) int {
+	switch name {
+	case "status":
+		return runStatus(args)
+	case "sync":
+		return runSync(args)
+	case "plan":
+		return runPlan(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+}
+
+func socketFlag(fs *flag.FlagSet) *string {
+	// Without a readable agent.conf the default socket is the best guess.
+	t, _ := config.LoadTunables(getConfigPath())
+	return fs.String("socket", statusSocketPath(t), "path of the agent's status socket")
+}
+
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the raw status as JSON")
+	socket := socketFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := status.Fetch(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gluon-agent status: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return 0
+	}
+	report.WriteText(os.Stdout)
+	return 0
+}
+
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	now := fs.Bool("now", false, "sync immediately and wait for the result")
+	socket := socketFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !*now {
+		fmt.Fprintln(os.Stderr, "gluon-agent sync: --now is required")
+		return 2
+	}
+
+	fmt.Println("Syncing config...")
+	if err := status.RequestSync(*socket); err != nil {
+		fmt.Fprintf(os.Stderr, "gluon-agent sync: %v\n", err)
+		return 1
+	}
+	fmt.Println("Config sync completed")
+	return 0
+}
+
+func runPlan(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the raw plan as JSON")
+	socket := socketFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	plan, err := status.RequestPlan(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gluon-agent plan: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(plan)
+		return 0
+	}
+	writePlanText(os.Stdout, plan)
+	return 0
+}
+
+func writePlanText(w io.Writer, plan *client.ConfigPlan) {
+	fmt.Fprintf(w, "Config version %d (%s)\n", plan.Version, plan.Hash)
+	if len(plan.Files) == 0 && len(plan.Actions) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	for _, f := range plan.Files {
+		fmt.Fprintf(w, "\n%s %s\n", f.Change, f.Path)
+		fmt.Fprint(w, f.Diff)
+	}
+	fmt.Fprintln(w, "\nActions:")
+	if len(plan.Actions) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, a := range plan.Actions {
+		mark := ""
+		if a.Disruptive {
+			mark = " [disruptive]"
+		}
+		fmt.Fprintf(w, "  %-18s %-10s %s%s\n", a.Action, a.Target, a.Reason, mark)
+	}
+	if plan.Disruptive {
+		fmt.Fprintln(w, "\nApplying this plan interrupts traffic.")
+	}
+}
@@ -112,13 +112,16 @@ func FetchCACertificate(apiURL string, destPath string) error {
 	return nil
 }
 
-func (c *Client) RequestEnrollment(hostname, provider, os, desiredRole string) (uint, string, error) {
+func (c *Client) RequestEnrollment(hostname, provider, os, desiredRole, bootstrapToken string) (uint, string, error) {
 	payload := map[string]string{
 		"hostname":     hostname,
 		"provider":     provider,
 		"os":           os,
 		"desired_role": desiredRole,
 	}
+	if bootstrapToken != "" {
+		payload["bootstrap_token"] = bootstrapToken
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -205,9 +208,18 @@ func (c *Client) CheckEnrollmentStatus(requestID uint, enrollmentSecret string)
 }
 
 type NetworkInfo struct {
-	NodeID             uint     `json:"node_id"`
-	Role               string   `json:"role"`
-	RequiredInterfaces []string `json:"required_interfaces"`
+	NodeID             uint                    `json:"node_id"`
+	Role               string                  `json:"role"`
+	RequiredInterfaces []string                `json:"required_interfaces"`
+	InterfaceKeys      map[string]InterfaceKey `json:"interface_keys"`
+	EndpointEchoPort   int                     `json:"endpoint_echo_port"`
+}
+
+// InterfaceKey is the public key the API has on record for an interface.
+// RotationPending is set while the previous key is still kept as a fallback.
+type InterfaceKey struct {
+	PublicKey       string `json:"public_key"`
+	RotationPending bool   `json:"rotation_pending"`
 }
 
 func (c *Client) GetNetworkInfo(apiKey string) (*NetworkInfo, error) {
@@ -278,6 +290,37 @@ type ConfigBundle struct {
 	NetworkInterfaceFile string            `json:"network_interface_file"`
 	FRRConfigFile        string            `json:"frr_config_file"`
 	SSHAuthorizedKeys    []SSHAuthorizedKey `json:"ssh_authorized_keys"`
+	ServiceVIPs          []ServiceVIP       `json:"service_vips"`
+	SSHCA                *SSHCA             `json:"ssh_ca,omitempty"`
+	SudoRules            []SudoRule         `json:"sudo_rules"`
+	// RotatedKeys holds the public key each rotated interface should run;
+	// during a rotation that stays the old key until the far ends are ready.
+	RotatedKeys map[string]string `json:"rotated_keys,omitempty"`
+	KeyOverlaps []KeyOverlap      `json:"key_overlaps,omitempty"`
+}
+
+// KeyOverlap is a peer on Interface listed under both keys while it rotates.
+// Its allowed IPs move to PublicKey once a handshake under it is seen.
+type KeyOverlap struct {
+	Interface         string `json:"interface"`
+	PreviousPublicKey string `json:"previous_public_key"`
+	PublicKey         string `json:"public_key"`
+}
+
+// SudoRule lets Username run Commands as root; it comes from the SSH
+// access groups the node falls under.
+type SudoRule struct {
+	Username   string   `json:"username"`
+	Commands   []string `json:"commands"`
+	NoPassword bool     `json:"no_password"`
+}
+
+// SSHCA makes sshd accept certificates from the API's user CA. Principals
+// maps each login user to the certificate principals it accepts.
+type SSHCA struct {
+	TrustedUserCAKeys []string            `json:"trusted_user_ca_keys"`
+	Principals        map[string][]string `json:"principals"`
+	RevokedKeys       []string            `json:"revoked_keys"`
 }
 
 type SSHAuthorizedKey struct {
@@ -285,6 +328,29 @@ type SSHAuthorizedKey struct {
 	PublicKey string `json:"public_key"`
 }
 
+type ServiceVIP struct {
+	ID              uint   `json:"id"`
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	CheckType       string `json:"check_type"`
+	CheckTarget     string `json:"check_target"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	Rise            int    `json:"rise"`
+	Fall            int    `json:"fall"`
+}
+
+type ServiceVIPStatus struct {
+	ID        uint   `json:"id"`
+	Address   string `json:"address"`
+	Healthy   bool   `json:"healthy"`
+	Announced bool   `json:"announced"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ServiceVIPStatusProvider is set by main to report service VIP health in heartbeats
+var ServiceVIPStatusProvider func() []ServiceVIPStatus
+
 func (c *Client) GetConfig(apiKey string) (*ConfigBundle, error) {
 	req, err := http.NewRequest("GET", c.BaseURL+"/api/agent/config", nil)
 	if err != nil {
@@ -389,6 +455,21 @@ type KubernetesTask struct {
 	JoinCommand          string `json:"join_command,omitempty"`
 	Note                 string `json:"note,omitempty"`
 	BootstrapOwner       bool   `json:"bootstrap_owner,omitempty"`
+	CNI                  string `json:"cni,omitempty"`
+
+	// Cluster names the cluster the node belongs to. UploadKubeconfig asks
+	// the bootstrap hub to send its admin.conf with the init report.
+	Cluster          string `json:"cluster,omitempty"`
+	UploadKubeconfig bool   `json:"upload_kubeconfig,omitempty"`
+
+	// UpgradeApply marks the control plane that runs `kubeadm upgrade apply`
+	// in an "upgrade" task; other nodes run `kubeadm upgrade node`.
+	UpgradeApply bool `json:"upgrade_apply,omitempty"`
+
+	// Snapshot to restore for a "restore_etcd" task.
+	EtcdRestoreID      uint   `json:"etcd_restore_id,omitempty"`
+	EtcdSnapshotID     uint   `json:"etcd_snapshot_id,omitempty"`
+	EtcdSnapshotSHA256 string `json:"etcd_snapshot_sha256,omitempty"`
 }
 
 func (c *Client) GetKubernetesTask(apiKey string) (*KubernetesTask, error) {
@@ -432,6 +513,9 @@ type KubernetesReport struct {
 	WorkerJoinCommand       string `json:"worker_join_command,omitempty"`
 	ControlPlaneJoinCommand string `json:"control_plane_join_command,omitempty"`
 	JoinCommandExpiresAt    string `json:"join_command_expires_at,omitempty"` 
+
+	// Kubeconfig carries admin.conf when the task asked for it.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
 }
 
 func (c *Client) ReportKubernetes(apiKey string, report KubernetesReport) error {
@@ -461,3 +545,136 @@ func (c *Client) ReportKubernetes(apiKey string, report KubernetesReport) error
 	}
 	return nil
 }
+
+// transferClient shares the API client's transport but allows the long
+// transfers snapshot uploads and downloads need.
+func (c *Client) transferClient() *http.Client {
+	return &http.Client{Transport: c.HTTPClient.Transport, Timeout: 15 * time.Minute}
+}
+
+func (c *Client) EtcdSnapshotDue(apiKey string) (bool, error) {
+	req, err := http.NewRequest("GET", c.BaseURL+"/api/agent/etcd/snapshots/due", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("get etcd snapshot schedule failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var result struct {
+		Due bool `json:"due"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Due, nil
+}
+
+// KubernetesTaint and KubernetesNodeMetadata mirror the API's desired
+// per-node label and taint sets.
+type KubernetesTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+type KubernetesNodeMetadata struct {
+	NodeID   uint              `json:"node_id"`
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels"`
+	Taints   []KubernetesTaint `json:"taints"`
+}
+
+func (c *Client) GetKubernetesNodeMetadata(apiKey string) ([]KubernetesNodeMetadata, error) {
+	req, err := http.NewRequest("GET", c.BaseURL+"/api/agent/kubernetes/node-metadata", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get kubernetes node metadata failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var result struct {
+		Nodes []KubernetesNodeMetadata `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Nodes, nil
+}
+
+// UploadEtcdSnapshot sends a gzip-compressed snapshot. sha256 is the hex
+// checksum of the uncompressed snapshot.
+func (c *Client) UploadEtcdSnapshot(apiKey string, gz io.Reader, sha256 string, revision int64) error {
+	req, err := http.NewRequest("POST", c.BaseURL+"/api/agent/etcd/snapshots", gz)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("X-Snapshot-Sha256", sha256)
+	req.Header.Set("X-Snapshot-Revision", fmt.Sprintf("%d", revision))
+
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload etcd snapshot failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
+
+// DownloadEtcdSnapshot writes the gzip-compressed snapshot queued for
+// restore on this node to w.
+func (c *Client) DownloadEtcdSnapshot(apiKey string, id uint, w io.Writer) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/agent/etcd/snapshots/%d", c.BaseURL, id), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("download etcd snapshot failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return nil
+}
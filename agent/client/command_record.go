@@ -0,0 +1,119 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CommandRecordPath keeps the results of the commands this agent ran. The API
+// hands a command out again when its result never arrived; with the record
+// the agent reports the result it already has instead of running it twice,
+// and results that failed to send are retried on the next heartbeat.
+var CommandRecordPath = "/var/lib/gluon/commands.json"
+
+// maxCommandRecords bounds the record; only recent commands can come back.
+const maxCommandRecords = 50
+
+type commandRecordEntry struct {
+	Result   CommandResult `json:"result"`
+	Reported bool          `json:"reported"`
+}
+
+var commandRecordMu sync.Mutex
+
+func loadCommandRecord() []commandRecordEntry {
+	b, err := os.ReadFile(CommandRecordPath)
+	if err != nil {
+		return nil
+	}
+	var entries []commandRecordEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil
+	}
+	return entries
+}
+
+func saveCommandRecord(entries []commandRecordEntry) error {
+	if len(entries) > maxCommandRecords {
+		entries = entries[len(entries)-maxCommandRecords:]
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(CommandRecordPath), 0o755); err != nil {
+		return err
+	}
+	tmp := CommandRecordPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, CommandRecordPath)
+}
+
+// recordedCommandResult returns the result of command id if it already ran.
+func recordedCommandResult(id uint) (CommandResult, bool) {
+	commandRecordMu.Lock()
+	defer commandRecordMu.Unlock()
+	for _, e := range loadCommandRecord() {
+		if e.Result.ID == id {
+			return e.Result, true
+		}
+	}
+	return CommandResult{}, false
+}
+
+// recordCommandResult stores res before it is reported.
+func recordCommandResult(res CommandResult) error {
+	commandRecordMu.Lock()
+	defer commandRecordMu.Unlock()
+	entries := loadCommandRecord()
+	for i := range entries {
+		if entries[i].Result.ID == res.ID {
+			entries[i] = commandRecordEntry{Result: res}
+			return saveCommandRecord(entries)
+		}
+	}
+	return saveCommandRecord(append(entries, commandRecordEntry{Result: res}))
+}
+
+// markCommandReported notes that the API has the result of command id.
+func markCommandReported(id uint) error {
+	commandRecordMu.Lock()
+	defer commandRecordMu.Unlock()
+	entries := loadCommandRecord()
+	for i := range entries {
+		if entries[i].Result.ID == id {
+			entries[i].Reported = true
+			return saveCommandRecord(entries)
+		}
+	}
+	return nil
+}
+
+// unreportedCommandResults lists the recorded results the API never got.
+func unreportedCommandResults() []CommandResult {
+	commandRecordMu.Lock()
+	defer commandRecordMu.Unlock()
+	var out []CommandResult
+	for _, e := range loadCommandRecord() {
+		if !e.Reported {
+			out = append(out, e.Result)
+		}
+	}
+	return out
+}
+
+// reportCommandResult sends res and, once the API has it, marks it reported.
+func reportCommandResult(res CommandResult, report func(CommandResult) error) error {
+	if err := report(res); err != nil {
+		return err
+	}
+	if err := markCommandReported(res.ID); err != nil {
+		log.Printf("Failed to record that the result of command %d was sent: %v", res.ID, err)
+	}
+	return nil
+}
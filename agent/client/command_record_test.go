@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandRecord(t *testing.T) {
+	orig := CommandRecordPath
+	CommandRecordPath = filepath.Join(t.TempDir(), "commands.json")
+	t.Cleanup(func() { CommandRecordPath = orig })
+
+	_, ok := recordedCommandResult(1)
+	assert.False(t, ok)
+
+	res := CommandResult{ID: 1, Status: "succeeded", Output: "done"}
+	require.NoError(t, recordCommandResult(res))
+	got, ok := recordedCommandResult(1)
+	require.True(t, ok)
+	assert.Equal(t, res, got)
+
+	failing := func(CommandResult) error { return errors.New("api down") }
+	assert.Error(t, reportCommandResult(res, failing))
+	assert.Equal(t, []CommandResult{res}, unreportedCommandResults(), "kept for a retry")
+
+	var sent []CommandResult
+	sending := func(r CommandResult) error { sent = append(sent, r); return nil }
+	require.NoError(t, reportCommandResult(res, sending))
+	assert.Equal(t, []CommandResult{res}, sent)
+	assert.Empty(t, unreportedCommandResults())
+
+	for id := uint(2); id <= maxCommandRecords+1; id++ {
+		require.NoError(t, recordCommandResult(CommandResult{ID: id, Status: "failed"}))
+	}
+	_, ok = recordedCommandResult(1)
+	assert.False(t, ok, "oldest record dropped")
+	_, ok = recordedCommandResult(maxCommandRecords + 1)
+	assert.True(t, ok)
+}
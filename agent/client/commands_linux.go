@@ -6,10 +6,14 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"gluon-agent/keys"
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,29 +27,183 @@ type CommandResult struct {
 // DecommissionHandler is set by main to handle decommission commands
 var DecommissionHandler func()
 
-func executeCommands(commands []struct {
-	ID      uint            `json:"id"`
-	Kind    string          `json:"kind"`
-	Payload json.RawMessage `json:"payload"`
-}) []CommandResult {
-	out := make([]CommandResult, 0, len(commands))
+// Command is one command handed to the agent with a heartbeat response.
+type Command struct {
+	ID             uint            `json:"id"`
+	Kind           string          `json:"kind"`
+	Payload        json.RawMessage `json:"payload"`
+	TimeoutSeconds int             `json:"timeout_seconds"`
+}
+
+// defaultCommandTimeout applies when the API sends no timeout.
+const defaultCommandTimeout = 5 * time.Minute
+
+type queuedCommand struct {
+	cmd    Command
+	ctx    context.Context
+	report func(CommandResult) error
+}
+
+// activeCommand is a command that is queued or running on the worker.
+type activeCommand struct {
+	cancel context.CancelFunc
+	info   PendingCommand
+}
+
+// Commands run one at a time on a background worker so heartbeats keep
+// flowing while they execute; that is how cancellations reach them.
+var (
+	commandMu     sync.Mutex
+	commandActive = map[uint]*activeCommand{}
+	commandQueue  = make(chan queuedCommand, 64)
+	commandWorker sync.Once
+)
+
+// enqueueCommands schedules commands and calls report with each result.
+// Commands already queued or running are ignored, and commands that already
+// ran have their recorded result reported again instead of running twice.
+func enqueueCommands(commands []Command, report func(CommandResult) error) {
+	commandWorker.Do(func() { go runCommandQueue() })
 	for _, cmd := range commands {
 		if cmd.ID == 0 {
 			continue
 		}
-		switch strings.ToLower(strings.TrimSpace(cmd.Kind)) {
-		case "restart_service":
-			out = append(out, runRestartService(cmd.ID, cmd.Payload))
-		case "decommission":
-			out = append(out, runDecommission(cmd.ID))
-		default:
-			out = append(out, CommandResult{ID: cmd.ID, Status: "failed", Error: "unsupported command"})
+		if res, ok := recordedCommandResult(cmd.ID); ok {
+			log.Printf("Command %d already ran; reporting its result again", cmd.ID)
+			if err := reportCommandResult(res, report); err != nil {
+				log.Printf("Failed to report result of command %d: %v", cmd.ID, err)
+			}
+			continue
+		}
+		timeout := defaultCommandTimeout
+		if cmd.TimeoutSeconds > 0 {
+			timeout = time.Duration(cmd.TimeoutSeconds) * time.Second
+		}
+
+		commandMu.Lock()
+		if _, dup := commandActive[cmd.ID]; dup {
+			commandMu.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		commandActive[cmd.ID] = &activeCommand{
+			cancel: cancel,
+			info:   PendingCommand{ID: cmd.ID, Kind: cmd.Kind, State: "queued", Since: time.Now()},
 		}
+		commandMu.Unlock()
+
+		commandQueue <- queuedCommand{cmd: cmd, ctx: ctx, report: report}
 	}
+}
+
+// cancelCommands stops the given commands if they are queued or running.
+func cancelCommands(ids []uint) {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+	for _, id := range ids {
+		if active, ok := commandActive[id]; ok {
+			log.Printf("Cancelling command %d", id)
+			active.cancel()
+		}
+	}
+}
+
+// PendingCommands lists the commands queued or running on this agent.
+func PendingCommands() []PendingCommand {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+	out := make([]PendingCommand, 0, len(commandActive))
+	for _, active := range commandActive {
+		out = append(out, active.info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
 	return out
 }
 
-func runDecommission(id uint) CommandResult {
+func runCommandQueue() {
+	for q := range commandQueue {
+		commandMu.Lock()
+		if active, ok := commandActive[q.cmd.ID]; ok {
+			active.info.State, active.info.Since = "running", time.Now()
+		}
+		commandMu.Unlock()
+
+		res := runCommand(q.ctx, q.cmd)
+
+		commandMu.Lock()
+		if active, ok := commandActive[q.cmd.ID]; ok {
+			active.cancel()
+			delete(commandActive, q.cmd.ID)
+		}
+		commandMu.Unlock()
+
+		if err := recordCommandResult(res); err != nil {
+			log.Printf("Failed to record result of command %d: %v", res.ID, err)
+		}
+		if err := reportCommandResult(res, q.report); err != nil {
+			log.Printf("Failed to report result of command %d: %v", res.ID, err)
+		}
+	}
+}
+
+// retryCommandReports resends recorded results the API never acknowledged.
+func retryCommandReports(report func(CommandResult) error) {
+	for _, res := range unreportedCommandResults() {
+		commandMu.Lock()
+		_, active := commandActive[res.ID]
+		commandMu.Unlock()
+		if active {
+			continue
+		}
+		if err := reportCommandResult(res, report); err != nil {
+			log.Printf("Failed to report result of command %d: %v", res.ID, err)
+			return
+		}
+	}
+}
+
+// runCommand executes cmd unless it was cancelled or timed out while queued.
+// A command that completed keeps its own result even if ctx ended just
+// after; only a command that did not succeed is reported as cancelled or
+// timed out once ctx is done.
+func runCommand(ctx context.Context, cmd Command) CommandResult {
+	res := CommandResult{ID: cmd.ID}
+	if ctx.Err() == nil {
+		res = executeCommand(ctx, cmd)
+		if res.Status == "succeeded" {
+			return res
+		}
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		res.Status = "cancelled"
+		res.Error = "cancelled by admin"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		res.Status = "failed"
+		res.Error = "timed out"
+	}
+	return res
+}
+
+func executeCommand(ctx context.Context, cmd Command) CommandResult {
+	switch strings.ToLower(strings.TrimSpace(cmd.Kind)) {
+	case "restart_service":
+		return runRestartService(ctx, cmd.ID, cmd.Payload)
+	case "decommission":
+		return runDecommission(ctx, cmd.ID)
+	case "rotate_wireguard_keys":
+		return runRotateWireGuardKeys(ctx, cmd.ID, cmd.Payload)
+	case "run_diagnostic":
+		return runDiagnostic(ctx, cmd.ID, cmd.Payload)
+	default:
+		return CommandResult{ID: cmd.ID, Status: "failed", Error: "unsupported command"}
+	}
+}
+
+func runDecommission(ctx context.Context, id uint) CommandResult {
+	if err := ctx.Err(); err != nil {
+		return CommandResult{ID: id, Status: "failed", Error: err.Error()}
+	}
 	log.Println("Received decommission command, initiating cleanup...")
 
 	// If a handler is set, call it (allows main to do cleanup)
@@ -66,7 +224,7 @@ func runDecommission(id uint) CommandResult {
 	return result
 }
 
-func runRestartService(id uint, payload json.RawMessage) CommandResult {
+func runRestartService(ctx context.Context, id uint, payload json.RawMessage) CommandResult {
 	var p struct {
 		Name string `json:"name"`
 	}
@@ -76,9 +234,6 @@ func runRestartService(id uint, payload json.RawMessage) CommandResult {
 		return CommandResult{ID: id, Status: "failed", Error: "missing service name"}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
 	cmd := exec.CommandContext(ctx, "systemctl", "restart", service)
 	b, err := cmd.CombinedOutput()
 	if err != nil {
@@ -86,3 +241,45 @@ func runRestartService(id uint, payload json.RawMessage) CommandResult {
 	}
 	return CommandResult{ID: id, Status: "succeeded", Output: string(b)}
 }
+
+// runRotateWireGuardKeys only replaces the key files; the next config sync
+// uploads the new public keys. The interfaces keep running the old keys until
+// the API reports every far end ready for the new ones.
+func runRotateWireGuardKeys(ctx context.Context, id uint, payload json.RawMessage) CommandResult {
+	var p struct {
+		Interfaces []string `json:"interfaces"`
+	}
+	_ = json.Unmarshal(payload, &p)
+
+	rotated, err := keys.RotateKeys(ctx, p.Interfaces)
+	if err != nil {
+		return CommandResult{ID: id, Status: "failed", Output: strings.Join(rotated, "\n"), Error: err.Error()}
+	}
+	log.Printf("Rotated WireGuard keys for %v", rotated)
+	return CommandResult{ID: id, Status: "succeeded", Output: "rotated " + strings.Join(rotated, ", ")}
+}
+
+// runDiagnostic runs an allow-listed diagnostic and returns its combined
+// output, capped at maxDiagnosticOutput.
+func runDiagnostic(ctx context.Context, id uint, payload json.RawMessage) CommandResult {
+	var p struct {
+		Name   string            `json:"name"`
+		Params map[string]string `json:"params"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return CommandResult{ID: id, Status: "failed", Error: "invalid payload"}
+	}
+	argv, err := buildDiagnostic(p.Name, p.Params)
+	if err != nil {
+		return CommandResult{ID: id, Status: "failed", Error: err.Error()}
+	}
+
+	out := &limitedBuffer{max: maxDiagnosticOutput}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return CommandResult{ID: id, Status: "failed", Output: out.String(), Error: err.Error()}
+	}
+	return CommandResult{ID: id, Status: "succeeded", Output: out.String()}
+}
@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommandAborted(t *testing.T) {
+	res := runCommand(context.Background(), Command{ID: 1, Kind: "bogus"})
+	assert.Equal(t, CommandResult{ID: 1, Status: "failed", Error: "unsupported command"}, res,
+		"a failure on a live context keeps its own error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res = runCommand(ctx, Command{ID: 2, Kind: "decommission"})
+	assert.Equal(t, "cancelled", res.Status, "cancelled while queued never runs")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	res = runCommand(ctx, Command{ID: 3, Kind: "rotate_wireguard_keys"})
+	assert.Equal(t, CommandResult{ID: 3, Status: "failed", Error: "timed out"}, res)
+}
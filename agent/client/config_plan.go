@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ConfigPlan is what applying a config bundle would change on the node:
+// the files it rewrites and the actions it takes on interfaces and FRR.
+type ConfigPlan struct {
+	Version    int             `json:"version"`
+	Hash       string          `json:"hash"`
+	DryRun     bool            `json:"dry_run"`
+	Disruptive bool            `json:"disruptive"`
+	Files      []PlannedFile   `json:"files"`
+	Actions    []PlannedAction `json:"actions"`
+}
+
+// PlannedFile is one file the bundle creates, updates or deletes. Diff is
+// a unified diff with private keys redacted.
+type PlannedFile struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// PlannedAction is one step taken after the files are written. Disruptive
+// actions interrupt traffic on their target.
+type PlannedAction struct {
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	Disruptive bool   `json:"disruptive"`
+	Reason     string `json:"reason"`
+}
+
+// Fingerprint is a hash of everything in the plan, so a plan that is
+// computed again unchanged can be recognised.
+func (p *ConfigPlan) Fingerprint() string {
+	b, _ := json.Marshal(p)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReportConfigPlan sends the plan to the API before it is applied.
+func (c *Client) ReportConfigPlan(apiKey string, plan *ConfigPlan) error {
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/api/agent/config/plan", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report config plan failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigPlanFingerprint(t *testing.T) {
+	plan := func() *ConfigPlan {
+		return &ConfigPlan{Version: 2, Hash: "h", Files: []PlannedFile{{Path: "/etc/frr/frr.conf", Change: "update", Diff: "-a\n+b\n"}}}
+	}
+	assert.Equal(t, plan().Fingerprint(), plan().Fingerprint())
+
+	changed := plan()
+	changed.Files[0].Diff = "-a\n+c\n"
+	assert.NotEqual(t, plan().Fingerprint(), changed.Fingerprint())
+
+	dry := plan()
+	dry.DryRun = true
+	assert.NotEqual(t, plan().Fingerprint(), dry.Fingerprint())
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxDiagnosticOutput caps what a diagnostic sends back; the rest is cut.
+const maxDiagnosticOutput = 64 << 10
+
+var (
+	diagInterfaceRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+	diagUnitRe      = regexp.MustCompile(`^[A-Za-z0-9@._:-]{1,128}$`)
+)
+
+// diagnostics is the allow-list of read-only commands the API may ask for
+// by name. Each builds the argv from validated parameters; nothing reaches
+// a shell.
+var diagnostics = map[string]func(params map[string]string) ([]string, error){
+	"wg_show": func(p map[string]string) ([]string, error) {
+		if err := diagAllowParams(p, "interface"); err != nil {
+			return nil, err
+		}
+		if iface, ok := p["interface"]; ok {
+			if !diagInterfaceRe.MatchString(iface) {
+				return nil, fmt.Errorf("invalid interface %q", iface)
+			}
+			return []string{"wg", "show", iface}, nil
+		}
+		return []string{"wg", "show"}, nil
+	},
+	"ospf_neighbors":  fixedDiagnostic("vtysh", "-c", "show ip ospf neighbor"),
+	"ospf_routes":     fixedDiagnostic("vtysh", "-c", "show ip route ospf"),
+	"ip_route":        fixedDiagnostic("ip", "route"),
+	"ip_addr":         fixedDiagnostic("ip", "-brief", "addr"),
+	"conntrack_stats": fixedDiagnostic("conntrack", "-S"),
+	"journal": func(p map[string]string) ([]string, error) {
+		if err := diagAllowParams(p, "unit", "lines"); err != nil {
+			return nil, err
+		}
+		unit := p["unit"]
+		if !diagUnitRe.MatchString(unit) || strings.HasPrefix(unit, "-") {
+			return nil, fmt.Errorf("invalid unit %q", unit)
+		}
+		lines := 200
+		if raw, ok := p["lines"]; ok {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > 2000 {
+				return nil, fmt.Errorf("lines must be between 1 and 2000")
+			}
+			lines = n
+		}
+		return []string{"journalctl", "--no-pager", "-u", unit, "-n", strconv.Itoa(lines)}, nil
+	},
+	"service_status": func(p map[string]string) ([]string, error) {
+		if err := diagAllowParams(p, "unit"); err != nil {
+			return nil, err
+		}
+		unit := p["unit"]
+		if !diagUnitRe.MatchString(unit) || strings.HasPrefix(unit, "-") {
+			return nil, fmt.Errorf("invalid unit %q", unit)
+		}
+		return []string{"systemctl", "--no-pager", "status", unit}, nil
+	},
+}
+
+// buildDiagnostic resolves a diagnostic request to the argv to run.
+func buildDiagnostic(name string, params map[string]string) ([]string, error) {
+	build, ok := diagnostics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown diagnostic %q", name)
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	return build(params)
+}
+
+func fixedDiagnostic(argv ...string) func(map[string]string) ([]string, error) {
+	return func(p map[string]string) ([]string, error) {
+		if err := diagAllowParams(p); err != nil {
+			return nil, err
+		}
+		return argv, nil
+	}
+}
+
+func diagAllowParams(params map[string]string, allowed ...string) error {
+	for k := range params {
+		if !slices.Contains(allowed, k) {
+			return fmt.Errorf("unexpected parameter %q", k)
+		}
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and counts the
+// rest, so a chatty command cannot blow up the result report.
+type limitedBuffer struct {
+	max       int
+	buf       []byte
+	truncated int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.max - len(b.buf)
+	if room >= len(p) {
+		b.buf = append(b.buf, p...)
+		return len(p), nil
+	}
+	if room > 0 {
+		b.buf = append(b.buf, p[:room]...)
+	}
+	b.truncated += len(p) - max(room, 0)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated == 0 {
+		return string(b.buf)
+	}
+	return fmt.Sprintf("%s\n[output truncated: %d more bytes]\n", b.buf, b.truncated)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDiagnostic(t *testing.T) {
+	argv, err := buildDiagnostic("wg_show", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg", "show"}, argv)
+
+	argv, err = buildDiagnostic("wg_show", map[string]string{"interface": "wg-hub1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg", "show", "wg-hub1"}, argv)
+
+	argv, err = buildDiagnostic("journal", map[string]string{"unit": "frr"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"journalctl", "--no-pager", "-u", "frr", "-n", "200"}, argv)
+
+	argv, err = buildDiagnostic("ospf_neighbors", map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vtysh", "-c", "show ip ospf neighbor"}, argv)
+
+	_, err = buildDiagnostic("rm", nil)
+	assert.ErrorContains(t, err, "unknown diagnostic")
+	_, err = buildDiagnostic("ip_route", map[string]string{"table": "all"})
+	assert.ErrorContains(t, err, "unexpected parameter")
+	_, err = buildDiagnostic("wg_show", map[string]string{"interface": "wg0; reboot"})
+	assert.ErrorContains(t, err, "invalid interface")
+	_, err = buildDiagnostic("journal", map[string]string{"unit": "--since=yesterday"})
+	assert.ErrorContains(t, err, "invalid unit")
+	_, err = buildDiagnostic("journal", map[string]string{"unit": "frr", "lines": "100000"})
+	assert.ErrorContains(t, err, "lines")
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 8}
+	_, _ = b.Write([]byte("hello "))
+	_, _ = b.Write([]byte("world"))
+	assert.Equal(t, "hello wo\n[output truncated: 3 more bytes]\n", b.String())
+
+	b = &limitedBuffer{max: 8}
+	_, _ = b.Write([]byte("short"))
+	assert.Equal(t, "short", b.String())
+	assert.False(t, strings.Contains(b.String(), "truncated"))
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	endpointEchoMagic     = "GLUON-ECHO1"
+	endpointEchoProbeSize = 64
+)
+
+// EndpointObservation is the agent's view of its own public address, as
+// learned from the API's endpoint echo.
+type EndpointObservation struct {
+	PublicIP  string `json:"public_ip"`
+	BehindNAT bool   `json:"behind_nat"`
+}
+
+var (
+	endpointMu          sync.Mutex
+	endpointObservation *EndpointObservation
+)
+
+// ObservePublicEndpoint asks the API's UDP echo which address our packets
+// come from and remembers the answer for the next heartbeat. The node is
+// behind NAT when that address isn't configured on any local interface.
+func (c *Client) ObservePublicEndpoint(port int) (*EndpointObservation, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid API URL %q", c.BaseURL)
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(u.Hostname(), strconv.Itoa(port)), 3*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach endpoint echo: %w", err)
+	}
+	defer conn.Close()
+
+	probe := make([]byte, endpointEchoProbeSize)
+	copy(probe, endpointEchoMagic)
+
+	buf := make([]byte, 512)
+	var observed string
+	for attempt := 0; attempt < 3 && observed == ""; attempt++ {
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(probe); err != nil {
+			return nil, fmt.Errorf("failed to send echo probe: %w", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			continue
+		}
+		if ip, ok := parseEndpointEchoReply(buf[:n]); ok {
+			observed = ip
+		}
+	}
+	if observed == "" {
+		return nil, fmt.Errorf("no reply from endpoint echo")
+	}
+
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local addresses: %w", err)
+	}
+	obs := &EndpointObservation{
+		PublicIP:  observed,
+		BehindNAT: !addressIsLocal(observed, local),
+	}
+
+	endpointMu.Lock()
+	endpointObservation = obs
+	endpointMu.Unlock()
+	return obs, nil
+}
+
+func currentEndpointObservation() *EndpointObservation {
+	endpointMu.Lock()
+	defer endpointMu.Unlock()
+	if endpointObservation == nil {
+		return nil
+	}
+	obs := *endpointObservation
+	return &obs
+}
+
+// parseEndpointEchoReply extracts the IP from "GLUON-ECHO1 <ip>:<port>".
+func parseEndpointEchoReply(b []byte) (string, bool) {
+	rest, ok := strings.CutPrefix(string(b), endpointEchoMagic+" ")
+	if !ok {
+		return "", false
+	}
+	ap, err := netip.ParseAddrPort(strings.TrimSpace(rest))
+	if err != nil {
+		return "", false
+	}
+	return ap.Addr().Unmap().String(), true
+}
+
+func addressIsLocal(ip string, addrs []net.Addr) bool {
+	target, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		var candidate string
+		switch v := a.(type) {
+		case *net.IPNet:
+			candidate = v.IP.String()
+		case *net.IPAddr:
+			candidate = v.IP.String()
+		default:
+			candidate, _, _ = strings.Cut(a.String(), "/")
+		}
+		if addr, err := netip.ParseAddr(candidate); err == nil && addr.Unmap() == target.Unmap() {
+			return true
+		}
+	}
+	return false
+}
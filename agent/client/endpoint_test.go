@@ -0,0 +1,29 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpointEchoReply(t *testing.T) {
+	ip, ok := parseEndpointEchoReply([]byte("GLUON-ECHO1 198.51.100.7:40000"))
+	assert.True(t, ok)
+	assert.Equal(t, "198.51.100.7", ip)
+
+	_, ok = parseEndpointEchoReply([]byte("GLUON-ECHO1 garbage"))
+	assert.False(t, ok)
+	_, ok = parseEndpointEchoReply([]byte("HELLO 198.51.100.7:40000"))
+	assert.False(t, ok)
+}
+
+func TestAddressIsLocal(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("192.168.1.20"), Mask: net.CIDRMask(24, 32)},
+	}
+	assert.True(t, addressIsLocal("192.168.1.20", addrs))
+	assert.False(t, addressIsLocal("198.51.100.7", addrs))
+	assert.False(t, addressIsLocal("not-an-ip", addrs))
+}
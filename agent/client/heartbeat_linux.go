@@ -10,9 +10,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -34,6 +36,15 @@ type heartbeatPayload struct {
 	OSPFNeighbors  []ospfNeighborSnapshot  `json:"ospf_neighbors"`
 	SystemUsers   []string `json:"system_users"`
 	SystemServices []systemServiceSnapshot `json:"system_services"`
+	ServiceVIPs    []ServiceVIPStatus      `json:"service_vips,omitempty"`
+	LoopbackTraffic []loopbackTrafficSnapshot `json:"loopback_traffic,omitempty"`
+	NAT             *EndpointObservation      `json:"nat,omitempty"`
+}
+
+type loopbackTrafficSnapshot struct {
+	PeerIP          string  `json:"peer_ip"`
+	Bytes           uint64  `json:"bytes"`
+	IntervalSeconds float64 `json:"interval_seconds"`
 }
 
 type systemServiceSnapshot struct {
@@ -81,6 +92,11 @@ func (c *Client) Heartbeat(apiKey string, desiredRole string) error {
 		OSPFNeighbors:  readOSPFNeighbors(),
 		SystemUsers:    readSystemUsers(),
 		SystemServices: readSystemServices(),
+		LoopbackTraffic: readLoopbackTraffic(),
+		NAT:             currentEndpointObservation(),
+	}
+	if ServiceVIPStatusProvider != nil {
+		payload.ServiceVIPs = ServiceVIPStatusProvider()
 	}
 
 	body, err := json.Marshal(payload)
@@ -114,25 +130,122 @@ func (c *Client) Heartbeat(apiKey string, desiredRole string) error {
 
 	
 	var respPayload struct {
-		Commands []struct {
-			ID      uint            `json:"id"`
-			Kind    string          `json:"kind"`
-			Payload json.RawMessage `json:"payload"`
-		} `json:"commands"`
+		Commands []Command `json:"commands"`
+		Cancel   []uint    `json:"cancel"`
 	}
 	if b, _ := io.ReadAll(resp.Body); len(b) > 0 {
 		_ = json.Unmarshal(b, &respPayload)
 	}
+	if len(respPayload.Cancel) > 0 {
+		cancelCommands(respPayload.Cancel)
+	}
+	report := func(res CommandResult) error {
+		return c.ReportCommandResults(apiKey, []CommandResult{res})
+	}
+	retryCommandReports(report)
 	if len(respPayload.Commands) > 0 {
-		results := executeCommands(respPayload.Commands)
-		if len(results) > 0 {
-			_ = c.ReportCommandResults(apiKey, results)
-		}
+		enqueueCommands(respPayload.Commands, report)
 	}
 
 	return nil
 }
 
+// maxLoopbackTrafficPeers caps the heartbeat size on busy nodes; the API only
+// acts on the heaviest pairs anyway.
+const maxLoopbackTrafficPeers = 32
+
+var (
+	conntrackAcctOnce   sync.Once
+	loopbackTrafficMu   sync.Mutex
+	lastLoopbackTraffic struct {
+		flows map[string]uint64
+		at    time.Time
+	}
+)
+
+// conntrackAcctPath turns on per-flow byte counters. The setting is
+// system-wide: once the agent enables it, every conntrack entry on the host
+// carries counters, at a small cost per packet.
+const conntrackAcctPath = "/proc/sys/net/netfilter/nf_conntrack_acct"
+
+// enableConntrackAccounting switches on nf_conntrack_acct if it is off,
+// saying so in the log since it changes host-wide behaviour.
+func enableConntrackAccounting() {
+	current, err := os.ReadFile(conntrackAcctPath)
+	if err != nil {
+		log.Printf("Warning: cannot read %s; worker shortcut traffic will not be measured: %v", conntrackAcctPath, err)
+		return
+	}
+	if strings.TrimSpace(string(current)) == "1" {
+		return
+	}
+	if err := os.WriteFile(conntrackAcctPath, []byte("1\n"), 0644); err != nil {
+		log.Printf("Warning: failed to enable %s; worker shortcut traffic will not be measured: %v", conntrackAcctPath, err)
+		return
+	}
+	log.Printf("Enabled %s (system-wide) to measure loopback traffic for worker shortcuts", conntrackAcctPath)
+}
+
+// readLoopbackTraffic reports bytes exchanged between this node's loopback and
+// other addresses since the previous heartbeat. The API uses it to decide
+// which worker pairs deserve a direct shortcut. The first call only primes the
+// counters, and enables conntrack accounting, which they depend on.
+func readLoopbackTraffic() []loopbackTrafficSnapshot {
+	conntrackAcctOnce.Do(enableConntrackAccounting)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrOut, err := exec.CommandContext(ctx, "ip", "-4", "-o", "addr", "show", "dev", "dummy").Output()
+	if err != nil {
+		return nil
+	}
+	local := parseInetAddrs(addrOut)
+	if len(local) == 0 {
+		return nil
+	}
+
+	table, err := os.ReadFile("/proc/net/nf_conntrack")
+	if err != nil {
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel2()
+		table, err = exec.CommandContext(ctx2, "conntrack", "-L").Output()
+		if err != nil {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	loopbackTrafficMu.Lock()
+	perPeer, next := loopbackTrafficDeltas(lastLoopbackTraffic.flows, parseConntrackFlows(table), local)
+	prevAt := lastLoopbackTraffic.at
+	lastLoopbackTraffic.flows = next
+	lastLoopbackTraffic.at = now
+	loopbackTrafficMu.Unlock()
+
+	if prevAt.IsZero() {
+		return nil
+	}
+	interval := now.Sub(prevAt).Seconds()
+	if interval <= 0 {
+		return nil
+	}
+
+	out := make([]loopbackTrafficSnapshot, 0, len(perPeer))
+	for peer, n := range perPeer {
+		out = append(out, loopbackTrafficSnapshot{PeerIP: peer, Bytes: n, IntervalSeconds: interval})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].PeerIP < out[j].PeerIP
+	})
+	if len(out) > maxLoopbackTrafficPeers {
+		out = out[:maxLoopbackTrafficPeers]
+	}
+	return out
+}
+
 func readAgentLogsLastTwoMinutes() []string {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -0,0 +1,33 @@
+package client
+
+import "time"
+
+// InterfaceStatus is the WireGuard and OSPF state of one interface, as shown
+// by the agent's local status API.
+type InterfaceStatus struct {
+	Name            string               `json:"name"`
+	Peers           int                  `json:"peers"`
+	PeersUp         int                  `json:"peers_up"`
+	LatestHandshake *time.Time           `json:"latest_handshake,omitempty"`
+	RxBytes         uint64               `json:"rx_bytes"`
+	TxBytes         uint64               `json:"tx_bytes"`
+	OSPFNeighbors   []OSPFNeighborStatus `json:"ospf_neighbors"`
+}
+
+// OSPFNeighborStatus is one OSPF adjacency on an interface.
+type OSPFNeighborStatus struct {
+	RouterID string `json:"router_id"`
+	State    string `json:"state"`
+}
+
+// PendingCommand is a command the agent has received but not yet reported.
+type PendingCommand struct {
+	ID    uint      `json:"id"`
+	Kind  string    `json:"kind"`
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+}
+
+// peerUpWindow is how recent a handshake must be for a peer to count as
+// up; WireGuard re-handshakes every two minutes while traffic flows.
+const peerUpWindow = 3 * time.Minute
@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package client
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocalInterfaces reports the WireGuard peers and OSPF neighbors of every
+// interface that has either.
+func LocalInterfaces() []InterfaceStatus {
+	return summarizeInterfaces(readWireGuardPeers(), readOSPFNeighbors(), time.Now())
+}
+
+func summarizeInterfaces(peers []wireGuardPeerSnapshot, neighbors []ospfNeighborSnapshot, now time.Time) []InterfaceStatus {
+	byName := map[string]*InterfaceStatus{}
+	get := func(name string) *InterfaceStatus {
+		st, ok := byName[name]
+		if !ok {
+			st = &InterfaceStatus{Name: name, OSPFNeighbors: []OSPFNeighborStatus{}}
+			byName[name] = st
+		}
+		return st
+	}
+
+	for _, p := range peers {
+		st := get(p.Interface)
+		st.Peers++
+		st.RxBytes += p.RxBytes
+		st.TxBytes += p.TxBytes
+		if p.LatestHandshakeUnix <= 0 {
+			continue
+		}
+		hs := time.Unix(p.LatestHandshakeUnix, 0)
+		if now.Sub(hs) <= peerUpWindow {
+			st.PeersUp++
+		}
+		if st.LatestHandshake == nil || hs.After(*st.LatestHandshake) {
+			st.LatestHandshake = &hs
+		}
+	}
+	for _, n := range neighbors {
+		// FRR names neighbors on an interface "wg0:10.0.0.1".
+		name, _, _ := strings.Cut(n.Interface, ":")
+		st := get(name)
+		st.OSPFNeighbors = append(st.OSPFNeighbors, OSPFNeighborStatus{RouterID: n.RouterID, State: n.State})
+	}
+
+	out := make([]InterfaceStatus, 0, len(byName))
+	for _, st := range byName {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeInterfaces(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	peers := []wireGuardPeerSnapshot{
+		{Interface: "wg0", PeerPublicKey: "a", LatestHandshakeUnix: now.Add(-time.Minute).Unix(), RxBytes: 10, TxBytes: 1},
+		{Interface: "wg0", PeerPublicKey: "b", LatestHandshakeUnix: now.Add(-10 * time.Minute).Unix(), RxBytes: 5, TxBytes: 2},
+		{Interface: "wg1", PeerPublicKey: "c"},
+	}
+	neighbors := []ospfNeighborSnapshot{
+		{RouterID: "10.0.0.2", State: "Full", Interface: "wg0:10.1.0.1"},
+		{RouterID: "10.0.0.3", State: "Init", Interface: "eth1"},
+	}
+
+	got := summarizeInterfaces(peers, neighbors, now)
+	require.Len(t, got, 3)
+	assert.Equal(t, "eth1", got[0].Name)
+	assert.Equal(t, "wg0", got[1].Name)
+	assert.Equal(t, "wg1", got[2].Name)
+
+	wg0 := got[1]
+	assert.Equal(t, 2, wg0.Peers)
+	assert.Equal(t, 1, wg0.PeersUp)
+	assert.Equal(t, uint64(15), wg0.RxBytes)
+	assert.Equal(t, uint64(3), wg0.TxBytes)
+	require.NotNil(t, wg0.LatestHandshake)
+	assert.True(t, wg0.LatestHandshake.Equal(now.Add(-time.Minute)))
+	assert.Equal(t, []OSPFNeighborStatus{{RouterID: "10.0.0.2", State: "Full"}}, wg0.OSPFNeighbors)
+
+	assert.Equal(t, 1, got[2].Peers)
+	assert.Zero(t, got[2].PeersUp, "a peer that never shook hands is down")
+	assert.Nil(t, got[2].LatestHandshake)
+
+	assert.Zero(t, got[0].Peers)
+	assert.Len(t, got[0].OSPFNeighbors, 1)
+}
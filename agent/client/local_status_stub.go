@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+// LocalInterfaces reports nothing off Linux; there is no WireGuard or FRR.
+func LocalInterfaces() []InterfaceStatus { return nil }
+
+// PendingCommands reports nothing off Linux, where commands are not run.
+func PendingCommands() []PendingCommand { return nil }
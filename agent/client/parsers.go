@@ -299,3 +299,121 @@ func normalizeMillisToSeconds(v *uint64) *uint64 {
 	val := (*v + 500) / 1000
 	return &val
 }
+
+type conntrackFlow struct {
+	Key   string
+	Src   string
+	Dst   string
+	Bytes uint64
+}
+
+// parseConntrackFlows reads /proc/net/nf_conntrack or `conntrack -L` output.
+// The original-direction tuple identifies a flow; the byte counters of both
+// directions are summed. Counters are only present with nf_conntrack_acct=1.
+func parseConntrackFlows(b []byte) []conntrackFlow {
+	flows := make([]conntrackFlow, 0)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		proto := ""
+		var src, dst, sport, dport string
+		var total uint64
+		hasBytes := false
+		for _, f := range fields {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				if proto == "" && f != "ipv4" && f != "ipv6" {
+					if _, err := strconv.Atoi(f); err != nil {
+						proto = f
+					}
+				}
+				continue
+			}
+			switch k {
+			case "src":
+				if src == "" {
+					src = v
+				}
+			case "dst":
+				if dst == "" {
+					dst = v
+				}
+			case "sport":
+				if sport == "" {
+					sport = v
+				}
+			case "dport":
+				if dport == "" {
+					dport = v
+				}
+			case "bytes":
+				if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+					total += n
+					hasBytes = true
+				}
+			}
+		}
+		if src == "" || dst == "" || !hasBytes {
+			continue
+		}
+
+		flows = append(flows, conntrackFlow{
+			Key:   strings.Join([]string{proto, src, dst, sport, dport}, " "),
+			Src:   src,
+			Dst:   dst,
+			Bytes: total,
+		})
+	}
+	return flows
+}
+
+// loopbackTrafficDeltas returns the bytes exchanged with each remote address
+// since the previous snapshot, counting only flows with one end on a local
+// address. It also returns the counters to pass in as prev next time. A flow
+// that is new, or whose counter went backwards, counts in full.
+func loopbackTrafficDeltas(prev map[string]uint64, flows []conntrackFlow, local map[string]bool) (map[string]uint64, map[string]uint64) {
+	perPeer := make(map[string]uint64)
+	next := make(map[string]uint64)
+	for _, f := range flows {
+		var peer string
+		switch {
+		case local[f.Src] && !local[f.Dst]:
+			peer = f.Dst
+		case local[f.Dst] && !local[f.Src]:
+			peer = f.Src
+		default:
+			continue
+		}
+
+		next[f.Key] = f.Bytes
+		delta := f.Bytes
+		if before, ok := prev[f.Key]; ok && f.Bytes >= before {
+			delta = f.Bytes - before
+		}
+		if delta > 0 {
+			perPeer[peer] += delta
+		}
+	}
+	return perPeer, next
+}
+
+// parseInetAddrs extracts IPv4 addresses from `ip -4 -o addr show` output.
+func parseInetAddrs(b []byte) map[string]bool {
+	addrs := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] != "inet" {
+				continue
+			}
+			addr, _, _ := strings.Cut(fields[i+1], "/")
+			if addr != "" {
+				addrs[addr] = true
+			}
+		}
+	}
+	return addrs
+}
@@ -604,3 +604,41 @@ func TestNormalizeMillisToSeconds(t *testing.T) {
 		})
 	}
 }
+
+func TestParseConntrackFlows(t *testing.T) {
+	input := []byte(`ipv4     2 tcp      6 431999 ESTABLISHED src=10.255.0.5 dst=10.255.0.9 sport=40000 dport=8472 packets=10 bytes=1500 src=10.255.0.9 dst=10.255.0.5 sport=8472 dport=40000 packets=8 bytes=500 [ASSURED] mark=0 zone=0 use=2
+udp      17 29 src=10.255.0.5 dst=10.255.0.7 sport=5353 dport=5353 packets=1 bytes=100 src=10.255.0.7 dst=10.255.0.5 sport=5353 dport=5353 packets=0 bytes=0 mark=0 use=1
+ipv4     2 tcp      6 10 TIME_WAIT src=10.0.0.1 dst=10.0.0.2 sport=1 dport=2 src=10.0.0.2 dst=10.0.0.1 sport=2 dport=1 mark=0 use=1
+`)
+
+	flows := parseConntrackFlows(input)
+	assert.Equal(t, []conntrackFlow{
+		{Key: "tcp 10.255.0.5 10.255.0.9 40000 8472", Src: "10.255.0.5", Dst: "10.255.0.9", Bytes: 2000},
+		{Key: "udp 10.255.0.5 10.255.0.7 5353 5353", Src: "10.255.0.5", Dst: "10.255.0.7", Bytes: 100},
+	}, flows)
+}
+
+func TestLoopbackTrafficDeltas(t *testing.T) {
+	local := map[string]bool{"10.255.0.5": true}
+	flows := []conntrackFlow{
+		{Key: "a", Src: "10.255.0.5", Dst: "10.255.0.9", Bytes: 3000},
+		{Key: "b", Src: "10.255.0.9", Dst: "10.255.0.5", Bytes: 700},
+		{Key: "c", Src: "10.255.0.5", Dst: "10.255.0.7", Bytes: 50},
+		{Key: "d", Src: "192.168.1.1", Dst: "192.168.1.2", Bytes: 9999},
+	}
+	prev := map[string]uint64{"a": 1000, "c": 80}
+
+	perPeer, next := loopbackTrafficDeltas(prev, flows, local)
+
+	// a grew by 2000, b is new; c's counter went backwards so it counts in full.
+	assert.Equal(t, map[string]uint64{"10.255.0.9": 2700, "10.255.0.7": 50}, perPeer)
+	assert.Equal(t, map[string]uint64{"a": 3000, "b": 700, "c": 50}, next)
+}
+
+func TestParseInetAddrs(t *testing.T) {
+	input := []byte(`5: dummy    inet 10.255.0.5/32 scope global dummy\       valid_lft forever preferred_lft forever
+5: dummy    inet 10.255.20.3/32 scope global dummy:vip\       valid_lft forever preferred_lft forever
+`)
+	assert.Equal(t, map[string]bool{"10.255.0.5": true, "10.255.20.3": true}, parseInetAddrs(input))
+	assert.Empty(t, parseInetAddrs(nil))
+}
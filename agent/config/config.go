@@ -17,9 +17,14 @@ type Config struct {
 	Provider         string `json:"provider"`
 	OS               string `json:"os"`
 	DesiredRole      string `json:"desired_role"`
+	// BootstrapToken enrolls the agent without waiting for an admin; it is
+	// cleared once the enrollment request has been sent.
+	BootstrapToken   string `json:"bootstrap_token,omitempty"`
 	// TLS settings
 	CACertPath       string `json:"ca_cert_path,omitempty"`
 	TLSSkipVerify    bool   `json:"tls_skip_verify,omitempty"` // For development only
+
+	Tunables
 }
 
 func Load(path string) (*Config, error) {
@@ -58,15 +63,19 @@ func Load(path string) (*Config, error) {
 		return cfg, nil
 	}
 	defer file.Close()
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(cfg)
-	if err != nil {
+	if err := decode(file, path, cfg); err != nil {
 		return nil, err
 	}
 	return cfg, nil
 }
 
 func (c *Config) Save(path string) error {
+	// Tunables are only ever edited by hand; keep what the file holds now
+	// rather than what was loaded at startup.
+	out := *c
+	if t, err := LoadTunables(path); err == nil {
+		out.Tunables = *t
+	}
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -74,7 +83,7 @@ func (c *Config) Save(path string) error {
 	defer file.Close()
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	err = encoder.Encode(c)
+	err = encoder.Encode(&out)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tunables are the agent.conf settings that shape how the agent behaves
+// rather than who it is. Zero values mean the default. Intervals, the log
+// level, the feature toggles and dry_run are re-read on SIGHUP; paths only
+// at startup.
+type Tunables struct {
+	HeartbeatIntervalSeconds      int    `json:"heartbeat_interval_seconds,omitempty"`
+	ConfigSyncIntervalSeconds     int    `json:"config_sync_interval_seconds,omitempty"`
+	EnrollmentPollIntervalSeconds int    `json:"enrollment_poll_interval_seconds,omitempty"`
+	LogLevel                      string `json:"log_level,omitempty"`
+
+	ManageKubernetes *bool `json:"manage_kubernetes,omitempty"`
+	ManageSSH        *bool `json:"manage_ssh,omitempty"`
+	InstallPackages  *bool `json:"install_packages,omitempty"`
+
+	// DryRun makes config syncs plan and report changes without applying
+	// them.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// The paths of the agent's own files. Where the WireGuard, ifupdown
+	// and FRR config go is fixed: those services, and the interface
+	// stanzas the API generates, only read their standard locations.
+	StatusSocket       string `json:"status_socket,omitempty"`
+	StateFile          string `json:"state_file,omitempty"`
+	KeysDir            string `json:"keys_dir,omitempty"`
+	KeyUploadStateFile string `json:"key_upload_state_file,omitempty"`
+}
+
+const (
+	DefaultHeartbeatInterval      = 30 * time.Second
+	DefaultConfigSyncInterval     = 60 * time.Second
+	DefaultEnrollmentPollInterval = 10 * time.Second
+	DefaultLogLevel               = "info"
+)
+
+// LogLevels are the accepted log_level values, most verbose first.
+var LogLevels = []string{"debug", "info", "warn"}
+
+// intervalBounds are the accepted ranges of the interval settings, in
+// seconds.
+var intervalBounds = map[string][2]int{
+	"heartbeat_interval_seconds":       {5, 3600},
+	"config_sync_interval_seconds":     {10, 86400},
+	"enrollment_poll_interval_seconds": {1, 3600},
+}
+
+func seconds(v int, def time.Duration) time.Duration {
+	if v <= 0 {
+		return def
+	}
+	return time.Duration(v) * time.Second
+}
+
+func (t *Tunables) HeartbeatInterval() time.Duration {
+	return seconds(t.HeartbeatIntervalSeconds, DefaultHeartbeatInterval)
+}
+
+func (t *Tunables) ConfigSyncInterval() time.Duration {
+	return seconds(t.ConfigSyncIntervalSeconds, DefaultConfigSyncInterval)
+}
+
+func (t *Tunables) EnrollmentPollInterval() time.Duration {
+	return seconds(t.EnrollmentPollIntervalSeconds, DefaultEnrollmentPollInterval)
+}
+
+func (t *Tunables) Level() string {
+	if t.LogLevel == "" {
+		return DefaultLogLevel
+	}
+	return t.LogLevel
+}
+
+func enabled(v *bool) bool { return v == nil || *v }
+
+// KubernetesEnabled reports whether the agent bootstraps and joins
+// Kubernetes; on by default.
+func (t *Tunables) KubernetesEnabled() bool { return enabled(t.ManageKubernetes) }
+
+// SSHEnabled reports whether the agent manages SSH keys, the SSH CA and
+// sudo rules; on by default.
+func (t *Tunables) SSHEnabled() bool { return enabled(t.ManageSSH) }
+
+// PackagesEnabled reports whether the agent may install packages; on by
+// default. When off, missing dependencies are an error.
+func (t *Tunables) PackagesEnabled() bool { return enabled(t.InstallPackages) }
+
+// Validate checks the ranges of intervals, the log level and that paths
+// are absolute.
+func (t *Tunables) Validate() error {
+	for _, iv := range []struct {
+		key string
+		v   int
+	}{
+		{"heartbeat_interval_seconds", t.HeartbeatIntervalSeconds},
+		{"config_sync_interval_seconds", t.ConfigSyncIntervalSeconds},
+		{"enrollment_poll_interval_seconds", t.EnrollmentPollIntervalSeconds},
+	} {
+		bounds := intervalBounds[iv.key]
+		if iv.v != 0 && (iv.v < bounds[0] || iv.v > bounds[1]) {
+			return fmt.Errorf("%s must be between %d and %d, got %d", iv.key, bounds[0], bounds[1], iv.v)
+		}
+	}
+
+	if t.LogLevel != "" && !slices.Contains(LogLevels, t.LogLevel) {
+		return fmt.Errorf("log_level must be one of %s, got %q", strings.Join(LogLevels, ", "), t.LogLevel)
+	}
+
+	for _, p := range t.Paths() {
+		if p.Value != "" && !filepath.IsAbs(p.Value) {
+			return fmt.Errorf("%s must be an absolute path, got %q", p.Key, p.Value)
+		}
+	}
+	return nil
+}
+
+// PathSetting is one of the path tunables, by its agent.conf key.
+type PathSetting struct {
+	Key   string
+	Value string
+}
+
+// Paths lists the path tunables; empty values keep the built-in default.
+func (t *Tunables) Paths() []PathSetting {
+	return []PathSetting{
+		{"status_socket", t.StatusSocket},
+		{"state_file", t.StateFile},
+		{"keys_dir", t.KeysDir},
+		{"key_upload_state_file", t.KeyUploadStateFile},
+	}
+}
+
+// LoadTunables re-reads only the tunables from the config file at path.
+// A missing file yields the defaults.
+func LoadTunables(path string) (*Tunables, error) {
+	var cfg Config
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg.Tunables, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	if err := decode(file, path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg.Tunables, nil
+}
+
+// decode reads a config file into cfg, rejecting unknown keys and invalid
+// tunables with errors that name the offending key.
+func decode(r io.Reader, path string, cfg *Config) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		var syntaxErr *json.SyntaxError
+		switch {
+		case errors.As(err, &typeErr):
+			return fmt.Errorf("%s: %s must be a %s, not a %s", path, typeErr.Field, typeErr.Type, typeErr.Value)
+		case errors.As(err, &syntaxErr):
+			return fmt.Errorf("%s: invalid JSON at byte %d: %v", path, syntaxErr.Offset, err)
+		}
+		if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			key = strings.Trim(key, `"`)
+			msg := fmt.Sprintf("%s: unknown key %q", path, key)
+			if s := suggestKey(key); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			return errors.New(msg)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Tunables.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Keys lists every key agent.conf accepts.
+func Keys() []string {
+	var keys []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				walk(f.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				keys = append(keys, name)
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	sort.Strings(keys)
+	return keys
+}
+
+// suggestKey returns the known key closest to an unknown one, if any is
+// close enough to be a likely typo.
+func suggestKey(key string) string {
+	best, bestDist := "", 4
+	for _, k := range Keys() {
+		if strings.HasPrefix(k, key) || strings.HasPrefix(key, k) {
+			return k
+		}
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
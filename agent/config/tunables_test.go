@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConf(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.conf")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestTunableDefaults(t *testing.T) {
+	var tun Tunables
+	assert.Equal(t, 30*time.Second, tun.HeartbeatInterval())
+	assert.Equal(t, 60*time.Second, tun.ConfigSyncInterval())
+	assert.Equal(t, 10*time.Second, tun.EnrollmentPollInterval())
+	assert.Equal(t, "info", tun.Level())
+	assert.True(t, tun.KubernetesEnabled())
+	assert.True(t, tun.SSHEnabled())
+	assert.True(t, tun.PackagesEnabled())
+}
+
+func TestLoadTunables(t *testing.T) {
+	path := writeConf(t, `{
+  "api_url": "https://api.example.com",
+  "heartbeat_interval_seconds": 15,
+  "config_sync_interval_seconds": 300,
+  "log_level": "debug",
+  "manage_kubernetes": false,
+  "install_packages": false,
+  "status_socket": "/run/gluon-test/agent.sock",
+  "keys_dir": "/srv/gluon/keys"
+}`)
+	tun, err := LoadTunables(path)
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, tun.HeartbeatInterval())
+	assert.Equal(t, 5*time.Minute, tun.ConfigSyncInterval())
+	assert.Equal(t, "debug", tun.Level())
+	assert.False(t, tun.KubernetesEnabled())
+	assert.True(t, tun.SSHEnabled())
+	assert.False(t, tun.PackagesEnabled())
+	assert.Equal(t, "/run/gluon-test/agent.sock", tun.StatusSocket)
+	assert.Equal(t, "/srv/gluon/keys", tun.KeysDir)
+
+	tun, err = LoadTunables(filepath.Join(t.TempDir(), "missing.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, Tunables{}, *tun)
+}
+
+func TestLoadTunablesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown key with suggestion", `{"heartbeat_interval": 10}`, `unknown key "heartbeat_interval" (did you mean "heartbeat_interval_seconds"?)`},
+		{"misspelt key", `{"log_levle": "info"}`, `unknown key "log_levle" (did you mean "log_level"?)`},
+		{"unknown key without suggestion", `{"colour": "blue"}`, `unknown key "colour"`},
+		{"wrong type", `{"manage_ssh": "no"}`, "manage_ssh must be a bool, not a string"},
+		{"interval too short", `{"heartbeat_interval_seconds": 1}`, "heartbeat_interval_seconds must be between 5 and 3600, got 1"},
+		{"bad log level", `{"log_level": "verbose"}`, `log_level must be one of debug, info, warn, got "verbose"`},
+		{"relative path", `{"state_file": "state.json"}`, `state_file must be an absolute path, got "state.json"`},
+		{"relative keys dir", `{"keys_dir": "keys"}`, `keys_dir must be an absolute path, got "keys"`},
+		{"network config path", `{"wireguard_dir": "/srv/wireguard"}`, `unknown key "wireguard_dir"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConf(t, tt.content)
+			_, err := LoadTunables(path)
+			require.Error(t, err)
+			assert.Equal(t, path+": "+tt.want, err.Error())
+
+			_, err = Load(path)
+			assert.Error(t, err, "Load must reject what LoadTunables rejects")
+		})
+	}
+}
+
+func TestSaveKeepsTunablesFromFile(t *testing.T) {
+	path := writeConf(t, `{"api_url": "https://a", "log_level": "warn"}`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	// The file is edited by hand while the agent runs.
+	require.NoError(t, os.WriteFile(path, []byte(`{"api_url": "https://a", "log_level": "debug"}`), 0600))
+
+	cfg.APIKey = "key-1"
+	require.NoError(t, cfg.Save(path))
+
+	saved, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", saved.APIKey)
+	assert.Equal(t, "debug", saved.LogLevel)
+}
@@ -8,9 +8,8 @@ import (
 	"strings"
 )
 
-const (
-	KeysDir = "/etc/wireguard/keys"
-)
+// KeysDir holds the WireGuard key pairs; keys_dir in agent.conf moves it.
+var KeysDir = "/etc/wireguard/keys"
 
 func EnsureKeysDir() error {
 	return os.MkdirAll(KeysDir, 0700)
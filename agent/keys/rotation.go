@@ -0,0 +1,198 @@
+package keys
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// During a rotation the key pair that was in use is kept next to the new one
+// as <iface>.key.prev / <iface>.pub.prev until the API confirms the new key
+// works, so the agent can switch back if it doesn't.
+const prevSuffix = ".prev"
+
+// A rotation writes the new pair as <iface>.key.new / <iface>.pub.new and,
+// while an earlier rotation is unconfirmed, parks the pair it replaces as
+// <iface>.key.old / <iface>.pub.old until the new one is in place.
+const (
+	newSuffix = ".new"
+	oldSuffix = ".old"
+)
+
+// RotateKeys generates a new key pair for each interface, keeping the old one
+// as a fallback. An empty list rotates every interface with a key on disk.
+// It stops between interfaces once ctx is done.
+func RotateKeys(ctx context.Context, ifaces []string) ([]string, error) {
+	if len(ifaces) == 0 {
+		var err error
+		ifaces, err = listKeyInterfaces(KeysDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rotated := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if err := ctx.Err(); err != nil {
+			return rotated, err
+		}
+		privKey, pubKey, err := GenerateKeyPair()
+		if err != nil {
+			return rotated, fmt.Errorf("failed to generate keypair for %s: %w", iface, err)
+		}
+		if err := rotateKeyFiles(KeysDir, iface, privKey, pubKey); err != nil {
+			return rotated, err
+		}
+		rotated = append(rotated, iface)
+	}
+	return rotated, nil
+}
+
+// ReconcileRotation settles a rotation of iface against the key the API
+// expects it to run. Once the API no longer reports the rotation pending the
+// old pair is deleted; if the API went back to the old key (the rotation was
+// never confirmed), the old pair is restored and true is returned.
+func ReconcileRotation(iface string, expectedPublicKey string, pending bool) (bool, error) {
+	return reconcileRotation(KeysDir, iface, expectedPublicKey, pending)
+}
+
+// PrivateKeyFor returns the private key of iface whose public half is
+// publicKey: the kept pair while the far ends aren't ready for the new one,
+// the current pair otherwise. An empty publicKey means the current pair.
+func PrivateKeyFor(iface string, publicKey string) (string, error) {
+	return privateKeyFor(KeysDir, iface, publicKey)
+}
+
+func privateKeyFor(dir string, iface string, publicKey string) (string, error) {
+	keyPath := filepath.Join(dir, iface+".key")
+	if publicKey != "" {
+		prevPub, err := os.ReadFile(filepath.Join(dir, iface+".pub"+prevSuffix))
+		if err == nil && strings.TrimSpace(string(prevPub)) == strings.TrimSpace(publicKey) {
+			keyPath += prevSuffix
+		}
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key for %s: %w", iface, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// rotateKeyFiles installs a new pair for iface. The old pair is moved aside
+// before the new one is moved in, and every step is undone if a later one
+// fails, so the private and public key on disk always belong together.
+func rotateKeyFiles(dir string, iface string, privKey string, pubKey string) error {
+	keyPath := filepath.Join(dir, iface+".key")
+	pubPath := filepath.Join(dir, iface+".pub")
+
+	if _, err := os.Stat(keyPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no key for %s to rotate", iface)
+		}
+		return err
+	}
+
+	defer os.Remove(keyPath + newSuffix)
+	defer os.Remove(pubPath + newSuffix)
+	if err := os.WriteFile(keyPath+newSuffix, []byte(privKey+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write private key for %s: %w", iface, err)
+	}
+	if err := os.WriteFile(pubPath+newSuffix, []byte(pubKey+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write public key for %s: %w", iface, err)
+	}
+
+	// If an earlier rotation is still unconfirmed its fallback is the last
+	// key known to work, so it stays and only the current pair is replaced.
+	aside := prevSuffix
+	if _, err := os.Stat(keyPath + prevSuffix); !os.IsNotExist(err) {
+		aside = oldSuffix
+	}
+	if err := renameKeyPair(keyPath, pubPath, "", aside); err != nil {
+		return fmt.Errorf("failed to keep old key pair for %s: %w", iface, err)
+	}
+	if err := renameKeyPair(keyPath, pubPath, newSuffix, ""); err != nil {
+		if rerr := renameKeyPair(keyPath, pubPath, aside, ""); rerr != nil {
+			return fmt.Errorf("failed to install new key pair for %s: %w (restoring the old pair also failed: %v)", iface, err, rerr)
+		}
+		return fmt.Errorf("failed to install new key pair for %s: %w", iface, err)
+	}
+	if aside == oldSuffix {
+		_ = os.Remove(keyPath + oldSuffix)
+		_ = os.Remove(pubPath + oldSuffix)
+	}
+	return nil
+}
+
+// renameKeyPair renames keyPath+from and pubPath+from to the to suffix. If
+// the public key can't follow, the private key is moved back.
+func renameKeyPair(keyPath string, pubPath string, from string, to string) error {
+	if err := os.Rename(keyPath+from, keyPath+to); err != nil {
+		return err
+	}
+	if err := os.Rename(pubPath+from, pubPath+to); err != nil {
+		if rerr := os.Rename(keyPath+to, keyPath+from); rerr != nil {
+			return fmt.Errorf("%w (moving the private key back also failed: %v)", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+func reconcileRotation(dir string, iface string, expectedPublicKey string, pending bool) (bool, error) {
+	keyPath := filepath.Join(dir, iface+".key")
+	pubPath := filepath.Join(dir, iface+".pub")
+
+	prevPub, err := os.ReadFile(pubPath + prevSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	curPub, err := os.ReadFile(pubPath)
+	if err != nil {
+		return false, err
+	}
+
+	expected := strings.TrimSpace(expectedPublicKey)
+	switch {
+	case expected == "":
+		return false, nil
+	case strings.TrimSpace(string(curPub)) == expected:
+		if pending {
+			return false, nil
+		}
+		if err := os.Remove(keyPath + prevSuffix); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if err := os.Remove(pubPath + prevSuffix); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		return false, nil
+	case strings.TrimSpace(string(prevPub)) == expected:
+		if err := os.Rename(keyPath+prevSuffix, keyPath); err != nil {
+			return false, fmt.Errorf("failed to restore private key for %s: %w", iface, err)
+		}
+		if err := os.Rename(pubPath+prevSuffix, pubPath); err != nil {
+			return false, fmt.Errorf("failed to restore public key for %s: %w", iface, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func listKeyInterfaces(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, strings.TrimSuffix(filepath.Base(m), ".key"))
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,133 @@
+package keys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyPair(t *testing.T, dir string, iface string, priv string, pub string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, iface+".key"), []byte(priv+"\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, iface+".pub"), []byte(pub+"\n"), 0644))
+}
+
+func readKey(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.TrimSpace(string(b))
+}
+
+func TestRotateKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "wg-hub1", "priv-a", "pub-a")
+
+	require.NoError(t, rotateKeyFiles(dir, "wg-hub1", "priv-b", "pub-b"))
+	assert.Equal(t, "pub-b", readKey(t, filepath.Join(dir, "wg-hub1.pub")))
+	assert.Equal(t, "pub-a", readKey(t, filepath.Join(dir, "wg-hub1.pub.prev")))
+	assert.Equal(t, "priv-a", readKey(t, filepath.Join(dir, "wg-hub1.key.prev")))
+
+	// A second rotation before the first is confirmed keeps the original
+	// fallback.
+	require.NoError(t, rotateKeyFiles(dir, "wg-hub1", "priv-c", "pub-c"))
+	assert.Equal(t, "pub-c", readKey(t, filepath.Join(dir, "wg-hub1.pub")))
+	assert.Equal(t, "pub-a", readKey(t, filepath.Join(dir, "wg-hub1.pub.prev")))
+
+	assert.Error(t, rotateKeyFiles(dir, "wg-missing", "priv", "pub"))
+
+	ifaces, err := listKeyInterfaces(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg-hub1"}, ifaces)
+}
+
+func TestRotateKeyFilesKeepsPairOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "wg-hub1.key")
+	require.NoError(t, os.WriteFile(keyPath, []byte("priv-a\n"), 0600))
+
+	// Without a public key the second rename fails; the private key must be
+	// moved back instead of being left as a fallback with no public half.
+	assert.Error(t, rotateKeyFiles(dir, "wg-hub1", "priv-b", "pub-b"))
+	assert.Equal(t, "priv-a", readKey(t, keyPath))
+	assert.NoFileExists(t, keyPath+prevSuffix)
+	assert.NoFileExists(t, keyPath+newSuffix)
+
+	// The same holds when the unconfirmed pair can't be moved aside during
+	// a second rotation.
+	writeKeyPair(t, dir, "wg-hub2", "priv-a", "pub-a")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg-hub2.key"+prevSuffix), []byte("priv-0\n"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "wg-hub2.pub"+oldSuffix, "busy"), 0755))
+	assert.Error(t, rotateKeyFiles(dir, "wg-hub2", "priv-b", "pub-b"))
+	assert.Equal(t, "priv-a", readKey(t, filepath.Join(dir, "wg-hub2.key")))
+	assert.Equal(t, "pub-a", readKey(t, filepath.Join(dir, "wg-hub2.pub")))
+}
+
+func TestReconcileRotation(t *testing.T) {
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		writeKeyPair(t, dir, "wg-hub1", "priv-a", "pub-a")
+		require.NoError(t, rotateKeyFiles(dir, "wg-hub1", "priv-b", "pub-b"))
+		return dir
+	}
+
+	t.Run("keeps the old pair while pending", func(t *testing.T) {
+		dir := setup(t)
+		restored, err := reconcileRotation(dir, "wg-hub1", "pub-b", true)
+		require.NoError(t, err)
+		assert.False(t, restored)
+		assert.FileExists(t, filepath.Join(dir, "wg-hub1.key.prev"))
+	})
+
+	t.Run("retires the old pair once confirmed", func(t *testing.T) {
+		dir := setup(t)
+		restored, err := reconcileRotation(dir, "wg-hub1", "pub-b", false)
+		require.NoError(t, err)
+		assert.False(t, restored)
+		assert.NoFileExists(t, filepath.Join(dir, "wg-hub1.key.prev"))
+		assert.NoFileExists(t, filepath.Join(dir, "wg-hub1.pub.prev"))
+		assert.Equal(t, "priv-b", readKey(t, filepath.Join(dir, "wg-hub1.key")))
+	})
+
+	t.Run("restores the old pair after a rollback", func(t *testing.T) {
+		dir := setup(t)
+		restored, err := reconcileRotation(dir, "wg-hub1", "pub-a", false)
+		require.NoError(t, err)
+		assert.True(t, restored)
+		assert.Equal(t, "priv-a", readKey(t, filepath.Join(dir, "wg-hub1.key")))
+		assert.Equal(t, "pub-a", readKey(t, filepath.Join(dir, "wg-hub1.pub")))
+		assert.NoFileExists(t, filepath.Join(dir, "wg-hub1.key.prev"))
+	})
+
+	t.Run("leaves keys alone before the new key is uploaded", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKeyPair(t, dir, "wg-hub1", "priv-a", "pub-a")
+		restored, err := reconcileRotation(dir, "wg-hub1", "pub-a", false)
+		require.NoError(t, err)
+		assert.False(t, restored)
+		assert.Equal(t, "priv-a", readKey(t, filepath.Join(dir, "wg-hub1.key")))
+	})
+}
+
+func TestPrivateKeyFor(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "wg-hub1", "priv-a", "pub-a")
+
+	key, err := privateKeyFor(dir, "wg-hub1", "pub-a")
+	require.NoError(t, err)
+	assert.Equal(t, "priv-a", key)
+
+	require.NoError(t, rotateKeyFiles(dir, "wg-hub1", "priv-b", "pub-b"))
+	for pub, want := range map[string]string{"pub-a": "priv-a", "pub-b": "priv-b", "": "priv-b", "pub-x": "priv-b"} {
+		key, err := privateKeyFor(dir, "wg-hub1", pub)
+		require.NoError(t, err)
+		assert.Equal(t, want, key, pub)
+	}
+
+	_, err = privateKeyFor(dir, "wg-missing", "")
+	assert.Error(t, err)
+}
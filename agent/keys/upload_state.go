@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 )
 
-const UploadStatePath = "/var/lib/gluon/wg-keys-state.json"
+// UploadStatePath records the public keys last uploaded to the API;
+// key_upload_state_file in agent.conf moves it.
+var UploadStatePath = "/var/lib/gluon/wg-keys-state.json"
 
 type UploadState struct {
 	PublicKeys map[string]string `json:"public_keys"`
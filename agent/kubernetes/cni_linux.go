@@ -0,0 +1,274 @@
+//go:build linux
+// +build linux
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cniFlannel = "flannel"
+	cniCalico  = "calico"
+	cniCilium  = "cilium"
+
+	flannelManifestURL = "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml"
+
+	calicoVersion           = "v3.28.2"
+	tigeraOperatorURLFormat = "https://raw.githubusercontent.com/projectcalico/calico/%s/manifests/tigera-operator.yaml"
+
+	ciliumVersion      = "1.16.5"
+	ciliumCLIURLFormat = "https://github.com/cilium/cilium-cli/releases/latest/download/cilium-linux-%s.tar.gz"
+	ciliumCLIPath      = "/usr/local/bin/cilium"
+
+	// Must match the API's FRR rendering: the hubs' bgpd reflects pod
+	// routes in this AS on this port.
+	podBGPASN  = 64512
+	podBGPPort = 1179
+
+	calicoPeerLabel = "gluon.io/hub-peer"
+)
+
+var cniEnsureMu sync.Mutex
+var lastCNIEnsure time.Time
+
+func normalizeCNI(cni string) string {
+	cni = strings.ToLower(strings.TrimSpace(cni))
+	if cni == "" {
+		return cniFlannel
+	}
+	return cni
+}
+
+// installCNI installs the cluster's pod network right after kubeadm init.
+func installCNI(ctx context.Context, cni string, podCIDR string) error {
+	switch normalizeCNI(cni) {
+	case cniFlannel:
+		log.Println("Installing Flannel CNI...")
+		if out, err := runKubectlLogged(ctx, "apply", "-f", flannelManifestURL); err != nil {
+			return fmt.Errorf("apply flannel manifest: %w\n%s", err, truncate(out, 4000))
+		}
+		ensureFlannelTolerations(ctx)
+		return nil
+	case cniCalico:
+		return installCalico(ctx, podCIDR)
+	case cniCilium:
+		return installCilium(ctx)
+	default:
+		return fmt.Errorf("unsupported cni %q", cni)
+	}
+}
+
+// ensureCNI keeps the installed plugin in the shape the fabric needs.
+func ensureCNI(ctx context.Context, cni string) {
+	switch normalizeCNI(cni) {
+	case cniFlannel:
+		ensureFlannelTolerations(ctx)
+	case cniCalico:
+		if err := ensureCalicoHubPeers(ctx); err != nil {
+			log.Printf("Warning: failed to reconcile Calico hub peers: %v", err)
+		}
+	}
+}
+
+func maybeEnsureCNI(ctx context.Context, cni string) {
+	if normalizeCNI(cni) == cniFlannel {
+		maybeEnsureFlannelTolerations(ctx)
+		return
+	}
+	if !isInitialized() {
+		return
+	}
+
+	cniEnsureMu.Lock()
+	defer cniEnsureMu.Unlock()
+	if time.Since(lastCNIEnsure) < 2*time.Minute {
+		return
+	}
+	lastCNIEnsure = time.Now()
+
+	if err := ensureRootKubeconfig(); err != nil {
+		log.Printf("Warning: failed to set up kubeconfig: %v", err)
+		return
+	}
+	ensureCNI(ctx, cni)
+}
+
+// installCalico installs Calico through the Tigera operator in BGP mode
+// without encapsulation. The node-to-node mesh is off: every node peers
+// with the hubs' FRR instead, which reflects pod routes whose next hops are
+// node loopbacks, so pod traffic rides the WireGuard links as-is.
+func installCalico(ctx context.Context, podCIDR string) error {
+	if strings.TrimSpace(podCIDR) == "" {
+		return fmt.Errorf("calico needs the pod CIDR")
+	}
+
+	log.Printf("Installing Calico %s CNI (BGP, no encapsulation)...", calicoVersion)
+	if out, err := runKubectlLogged(ctx, "apply", "--server-side", "--force-conflicts", "-f", fmt.Sprintf(tigeraOperatorURLFormat, calicoVersion)); err != nil {
+		return fmt.Errorf("apply tigera operator: %w\n%s", err, truncate(out, 4000))
+	}
+	for _, crd := range []string{"installations.operator.tigera.io", "bgpconfigurations.crd.projectcalico.org", "bgppeers.crd.projectcalico.org"} {
+		if out, err := runKubectlLogged(ctx, "wait", "--for=condition=established", "--timeout=120s", "crd/"+crd); err != nil {
+			return fmt.Errorf("wait for %s: %w\n%s", crd, err, truncate(out, 4000))
+		}
+	}
+
+	if err := applyManifest(ctx, calicoManifest(podCIDR)); err != nil {
+		return fmt.Errorf("apply calico installation: %w", err)
+	}
+	return ensureCalicoHubPeers(ctx)
+}
+
+func calicoManifest(podCIDR string) string {
+	return fmt.Sprintf(`apiVersion: operator.tigera.io/v1
+kind: Installation
+metadata:
+  name: default
+spec:
+  calicoNetwork:
+    bgp: Enabled
+    nodeAddressAutodetectionV4:
+      kubernetes: NodeInternalIP
+    ipPools:
+    - name: default-ipv4-ippool
+      cidr: %s
+      blockSize: 26
+      encapsulation: None
+      natOutgoing: Enabled
+      nodeSelector: all()
+---
+apiVersion: crd.projectcalico.org/v1
+kind: BGPConfiguration
+metadata:
+  name: default
+spec:
+  nodeToNodeMeshEnabled: false
+  asNumber: %d
+`, podCIDR, podBGPASN)
+}
+
+// ensureCalicoHubPeers points every Calico node at each hub's FRR. Hubs are
+// the control-plane nodes; their InternalIP is the loopback FRR listens on.
+// Calico skips the peer whose address is the node's own.
+func ensureCalicoHubPeers(ctx context.Context) error {
+	out, err := runKubectlCaptured(ctx, "get", "nodes", "-l", "node-role.kubernetes.io/control-plane",
+		"-o", `jsonpath={range .items[*]}{.metadata.name}{" "}{.status.addresses[?(@.type=="InternalIP")].address}{"\n"}{end}`)
+	if err != nil {
+		return fmt.Errorf("list control-plane nodes: %w\n%s", err, truncate(out, 2000))
+	}
+
+	desired := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		desired["gluon-hub-"+sanitizeResourceName(fields[0])] = fields[1]
+	}
+	if len(desired) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, `---
+apiVersion: crd.projectcalico.org/v1
+kind: BGPPeer
+metadata:
+  name: %s
+  labels:
+    %s: "true"
+spec:
+  nodeSelector: all()
+  peerIP: %s:%d
+  asNumber: %d
+`, name, calicoPeerLabel, desired[name], podBGPPort, podBGPASN)
+	}
+	if err := applyManifest(ctx, b.String()); err != nil {
+		return err
+	}
+
+	// Drop peers for hubs that have left the cluster.
+	existing, err := runKubectlCaptured(ctx, "get", "bgppeers.crd.projectcalico.org", "-l", calicoPeerLabel+"=true", "-o", "name")
+	if err != nil {
+		return nil
+	}
+	for _, ref := range strings.Fields(existing) {
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		if out, err := runKubectlLogged(ctx, "delete", "bgppeers.crd.projectcalico.org", name, "--ignore-not-found"); err != nil {
+			log.Printf("Warning: failed to delete stale Calico peer %s: %v\n%s", name, err, truncate(out, 2000))
+		}
+	}
+	return nil
+}
+
+func sanitizeResourceName(s string) string {
+	s = strings.ToLower(s)
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+// installCilium installs Cilium with the cilium CLI, using the pod CIDRs
+// kubeadm assigns to each node.
+func installCilium(ctx context.Context) error {
+	if !fileExists(ciliumCLIPath) {
+		log.Println("Installing cilium CLI...")
+		url := fmt.Sprintf(ciliumCLIURLFormat, runtime.GOARCH)
+		if out, err := runLogged(ctx, "sh", "-c", fmt.Sprintf("curl -fsSL %q | tar -xz -C %s cilium", url, "/usr/local/bin")); err != nil {
+			return fmt.Errorf("install cilium CLI: %w\n%s", err, truncate(out, 4000))
+		}
+	}
+
+	log.Printf("Installing Cilium %s CNI...", ciliumVersion)
+	env := []string{"KUBECONFIG=" + adminConfPath, "HOME=/root"}
+	if out, err := runLoggedWithEnv(ctx, env, ciliumCLIPath, "install",
+		"--version", ciliumVersion,
+		"--set", "ipam.mode=kubernetes",
+	); err != nil {
+		return fmt.Errorf("cilium install: %w\n%s", err, truncate(out, 4000))
+	}
+	return nil
+}
+
+func applyManifest(ctx context.Context, manifest string) error {
+	f, err := os.CreateTemp("", "gluon-manifest-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(manifest); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if out, err := runKubectlLogged(ctx, "apply", "-f", f.Name()); err != nil {
+		return fmt.Errorf("%w\n%s", err, truncate(out, 4000))
+	}
+	return nil
+}
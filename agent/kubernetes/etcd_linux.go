@@ -0,0 +1,455 @@
+//go:build linux
+// +build linux
+
+package kubernetes
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gluon-agent/client"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	etcdDataDir        = "/var/lib/etcd"
+	etcdSnapshotDir    = "/var/lib/gluon/etcd-snapshots"
+	staticManifestsDir = "/etc/kubernetes/manifests"
+	manifestsStashDir  = "/etc/kubernetes/manifests.gluon-restore"
+	etcdRestoreMarker  = "/var/lib/gluon/etcd-snapshots/restored.json"
+	containerdEndpoint = "unix:///run/containerd/containerd.sock"
+)
+
+var etcdSnapshotMu sync.Mutex
+var lastEtcdSnapshotCheck time.Time
+
+var etcdImageRe = regexp.MustCompile(`(?m)^\s*image:\s*"?([^\s"]+)"?\s*$`)
+
+// maybeSnapshotEtcd takes an etcd snapshot when the API says one is due and
+// uploads it. Only the bootstrap hub is asked, and only while its etcd is
+// healthy, so a snapshot never captures a member that has fallen behind.
+func maybeSnapshotEtcd(ctx context.Context, apiClient *client.Client, apiKey string) {
+	if !isControlPlaneNode() {
+		return
+	}
+
+	etcdSnapshotMu.Lock()
+	defer etcdSnapshotMu.Unlock()
+	if time.Since(lastEtcdSnapshotCheck) < 5*time.Minute {
+		return
+	}
+	lastEtcdSnapshotCheck = time.Now()
+
+	due, err := apiClient.EtcdSnapshotDue(apiKey)
+	if err != nil {
+		log.Printf("Kubernetes: failed to check etcd snapshot schedule: %v", err)
+		return
+	}
+	if !due {
+		return
+	}
+	if ok, reason := etcdHealthy(ctx); !ok {
+		log.Printf("Kubernetes: skipping etcd snapshot, etcd unhealthy: %s", reason)
+		return
+	}
+
+	if err := takeEtcdSnapshot(ctx, apiClient, apiKey); err != nil {
+		log.Printf("Kubernetes: etcd snapshot failed: %v", err)
+		return
+	}
+	// A requested snapshot is no longer due once uploaded; check again
+	// straight away next time in case another request came in meanwhile.
+	lastEtcdSnapshotCheck = time.Time{}
+}
+
+func takeEtcdSnapshot(ctx context.Context, apiClient *client.Client, apiKey string) error {
+	if err := os.MkdirAll(etcdSnapshotDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(etcdSnapshotDir, fmt.Sprintf("snapshot-%d.db", time.Now().Unix()))
+	defer os.Remove(path)
+
+	if _, err := runEtcdTool(ctx, "etcdctl",
+		"--endpoints=https://127.0.0.1:2379",
+		"--cacert="+etcdCACertPath,
+		"--cert="+etcdHealthCertPath,
+		"--key="+etcdHealthKeyPath,
+		"snapshot", "save", path,
+	); err != nil {
+		return err
+	}
+
+	var status struct {
+		Revision int64 `json:"revision"`
+	}
+	if out, err := runEtcdTool(ctx, "etcdutl", "snapshot", "status", path, "-w", "json"); err == nil {
+		_ = json.Unmarshal([]byte(lastLine(out)), &status)
+	}
+
+	gzPath := path + ".gz"
+	defer os.Remove(gzPath)
+	sum, err := gzipFile(path, gzPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(gzPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := apiClient.UploadEtcdSnapshot(apiKey, f, sum, status.Revision); err != nil {
+		return err
+	}
+	log.Printf("Kubernetes: uploaded etcd snapshot (revision %d, sha256:%s)", status.Revision, sum)
+	return nil
+}
+
+// gzipFile compresses src into dst and returns the hex sha256 of src.
+func gzipFile(src string, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(io.MultiWriter(gz, h), in); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), out.Close()
+}
+
+// restoreEtcd rebuilds this hub's etcd as a single member from a snapshot,
+// for when quorum is lost for good. The control-plane static pods are
+// stopped, the old data directory is kept aside, and the snapshot is
+// restored under this member's name and peer URL. The other hubs have to be
+// reset and rejoined afterwards; the API tells the operator which.
+func restoreEtcd(ctx context.Context, apiClient *client.Client, apiKey string, task *client.KubernetesTask) {
+	progress := func(msg string) {
+		log.Printf("Kubernetes etcd restore: %s", msg)
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "etcd_restoring", Message: msg})
+	}
+	fail := func(err error) {
+		log.Printf("Kubernetes etcd restore failed: %v", err)
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "etcd_restore_failed", Message: err.Error()})
+	}
+
+	if !isControlPlaneNode() {
+		fail(errors.New("node is not a control plane"))
+		return
+	}
+	// A restore whose report got lost is reported again rather than run
+	// twice; a second run would throw away what was written since.
+	if done, ok := readEtcdRestoreMarker(); ok && task.EtcdRestoreID != 0 && done.RestoreID == task.EtcdRestoreID {
+		log.Printf("Kubernetes etcd restore: restore %d already done, reporting it again", done.RestoreID)
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{
+			State:   "etcd_restored",
+			Message: "previous data kept in " + done.Backup,
+		})
+		return
+	}
+	name := manifestFlagValueFromFile(etcdManifestPath, "--name")
+	peerURL := manifestFlagValueFromFile(etcdManifestPath, "--initial-advertise-peer-urls")
+	if name == "" || peerURL == "" {
+		fail(fmt.Errorf("could not read --name and --initial-advertise-peer-urls from %s", etcdManifestPath))
+		return
+	}
+
+	progress(fmt.Sprintf("downloading snapshot %d", task.EtcdSnapshotID))
+	if err := os.MkdirAll(etcdSnapshotDir, 0700); err != nil {
+		fail(err)
+		return
+	}
+	snapPath := filepath.Join(etcdSnapshotDir, fmt.Sprintf("restore-%d.db", task.EtcdSnapshotID))
+	defer os.Remove(snapPath)
+	if err := downloadSnapshot(apiClient, apiKey, task, snapPath); err != nil {
+		fail(err)
+		return
+	}
+
+	progress("stopping control-plane static pods")
+	if err := moveManifests(staticManifestsDir, manifestsStashDir); err != nil {
+		fail(err)
+		return
+	}
+	// Whatever happens next, the control plane has to come back up.
+	defer func() {
+		if err := moveManifests(manifestsStashDir, staticManifestsDir); err != nil {
+			log.Printf("Kubernetes etcd restore: failed to put static pod manifests back: %v", err)
+		}
+		_, _ = runLogged(ctx, "systemctl", "restart", "kubelet")
+	}()
+	if err := waitForEtcdStopped(ctx, 2*time.Minute); err != nil {
+		fail(err)
+		return
+	}
+
+	backup := fmt.Sprintf("%s.pre-restore-%d", etcdDataDir, time.Now().Unix())
+	if err := os.Rename(etcdDataDir, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fail(fmt.Errorf("move %s aside: %w", etcdDataDir, err))
+		return
+	}
+
+	progress("restoring snapshot into " + etcdDataDir)
+	if out, err := runEtcdTool(ctx, "etcdutl", "snapshot", "restore", snapPath,
+		"--data-dir", etcdDataDir,
+		"--name", name,
+		"--initial-cluster", name+"="+peerURL,
+		"--initial-advertise-peer-urls", peerURL,
+	); err != nil {
+		_ = os.RemoveAll(etcdDataDir)
+		_ = os.Rename(backup, etcdDataDir)
+		fail(fmt.Errorf("%w: %s", err, truncate(out, 2000)))
+		return
+	}
+
+	stashedEtcd := filepath.Join(manifestsStashDir, filepath.Base(etcdManifestPath))
+	manifest, err := os.ReadFile(stashedEtcd)
+	if err != nil {
+		fail(err)
+		return
+	}
+	manifest = []byte(singleMemberEtcdManifest(string(manifest), name+"="+peerURL))
+	if err := os.WriteFile(stashedEtcd, manifest, 0600); err != nil {
+		fail(err)
+		return
+	}
+
+	if err := moveManifests(manifestsStashDir, staticManifestsDir); err != nil {
+		fail(err)
+		return
+	}
+	_, _ = runLogged(ctx, "systemctl", "restart", "kubelet")
+
+	progress("waiting for etcd to become healthy")
+	deadline := time.Now().Add(5 * time.Minute)
+	for {
+		ok, reason := etcdHealthy(ctx)
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			fail(fmt.Errorf("etcd did not become healthy after restore: %s (previous data kept in %s)", reason, backup))
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	lastControlPlaneJoinCompleted = time.Now()
+	if err := writeEtcdRestoreMarker(etcdRestoreDone{RestoreID: task.EtcdRestoreID, Backup: backup}); err != nil {
+		log.Printf("Kubernetes etcd restore: failed to record the restore: %v", err)
+	}
+	log.Printf("Kubernetes etcd restore: done; previous data kept in %s", backup)
+	_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{
+		State:   "etcd_restored",
+		Message: "previous data kept in " + backup,
+	})
+}
+
+func downloadSnapshot(apiClient *client.Client, apiKey string, task *client.KubernetesTask, dst string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(apiClient.DownloadEtcdSnapshot(apiKey, task.EtcdSnapshotID, pw))
+	}()
+	defer pr.Close()
+
+	gz, err := gzip.NewReader(pr)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), gz); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	want := strings.TrimPrefix(task.EtcdSnapshotSHA256, "sha256:")
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("snapshot checksum mismatch: got sha256:%s, expected sha256:%s", got, want)
+	}
+	return out.Close()
+}
+
+// moveManifests moves the static pod manifests between the kubelet's
+// manifest directory and the stash, which stops or starts the pods.
+func moveManifests(from string, to string) error {
+	entries, err := os.ReadDir(from)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(to, 0700); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(from, e.Name()), filepath.Join(to, e.Name())); err != nil {
+			return err
+		}
+	}
+	if from == manifestsStashDir {
+		_ = os.Remove(from)
+	}
+	return nil
+}
+
+// etcdRestoreDone is the local record of the last restore that finished.
+type etcdRestoreDone struct {
+	RestoreID uint   `json:"restore_id"`
+	Backup    string `json:"backup"`
+}
+
+func readEtcdRestoreMarker() (etcdRestoreDone, bool) {
+	var done etcdRestoreDone
+	b, err := os.ReadFile(etcdRestoreMarker)
+	if err != nil {
+		return done, false
+	}
+	if err := json.Unmarshal(b, &done); err != nil {
+		return done, false
+	}
+	return done, true
+}
+
+func writeEtcdRestoreMarker(done etcdRestoreDone) error {
+	b, err := json.Marshal(done)
+	if err != nil {
+		return err
+	}
+	tmp := etcdRestoreMarker + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, etcdRestoreMarker)
+}
+
+// waitForEtcdStopped waits for the etcd container and process to go away
+// once the static pod manifests are moved out. Health says nothing here: a
+// member without quorum is unhealthy while still holding the data
+// directory.
+func waitForEtcdStopped(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := etcdRunning(ctx)
+		if err == nil && !running {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("could not check that etcd stopped: %w", err)
+			}
+			return fmt.Errorf("etcd still running %s after its manifest was moved", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// etcdRunning reports whether an etcd container is running, or an etcd
+// process left behind by one.
+func etcdRunning(ctx context.Context) (bool, error) {
+	out, err := output(ctx, "crictl", "--runtime-endpoint", containerdEndpoint, "ps", "--quiet", "--state", "running", "--name", "^etcd$")
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(string(out)) != "" {
+		return true, nil
+	}
+	// pgrep exits 1 when nothing matches.
+	_, err = output(ctx, "pgrep", "-x", "etcd")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// singleMemberEtcdManifest points the etcd static pod at itself alone and
+// drops the flags that only make sense when joining or forcing a cluster.
+func singleMemberEtcdManifest(manifest string, initialCluster string) string {
+	lines := strings.Split(manifest, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "- --initial-cluster="):
+			line = line[:len(line)-len(strings.TrimLeft(line, " \t"))] + "- --initial-cluster=" + initialCluster
+		case strings.HasPrefix(trimmed, "- --initial-cluster-state="), trimmed == "- --force-new-cluster":
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// runEtcdTool runs etcdctl or etcdutl from the etcd image kubeadm deployed,
+// through containerd, since the host has neither installed. Host networking
+// reaches the local member; /var/lib and the etcd PKI are mounted so data
+// and snapshot paths are the same inside and out. It works while etcd
+// itself is stopped, which a restore needs.
+func runEtcdTool(ctx context.Context, args ...string) (string, error) {
+	image := etcdImage()
+	if image == "" {
+		return "", fmt.Errorf("could not find the etcd image in %s", etcdManifestPath)
+	}
+	ctrArgs := []string{
+		"-n", "k8s.io", "run", "--rm", "--net-host",
+		"--mount", "type=bind,src=/var/lib,dst=/var/lib,options=rbind:rw",
+		"--mount", "type=bind,src=/etc/kubernetes/pki/etcd,dst=/etc/kubernetes/pki/etcd,options=rbind:ro",
+		image, fmt.Sprintf("gluon-etcd-tool-%d", time.Now().UnixNano()),
+	}
+	return runLogged(ctx, "ctr", append(ctrArgs, args...)...)
+}
+
+func etcdImage() string {
+	path := etcdManifestPath
+	if !fileExists(path) {
+		path = filepath.Join(manifestsStashDir, filepath.Base(etcdManifestPath))
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if m := etcdImageRe.FindSubmatch(b); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
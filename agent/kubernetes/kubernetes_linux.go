@@ -81,19 +81,36 @@ type initResult struct {
 }
 
 func Sync(ctx context.Context, apiClient *client.Client, apiKey string) {
+	if unmanaged.Load() {
+		recordSync("unmanaged", nil)
+		return
+	}
 	maybeRecoverBootstrapControlPlane(ctx)
 
 	task, err := apiClient.GetKubernetesTask(apiKey)
 	if err != nil {
 		log.Printf("Failed to get kubernetes task: %v", err)
+		recordSync("", err)
 		return
 	}
 	if task != nil && task.BootstrapOwner {
 		markBootstrapOwner()
 	}
 
+	// A restore replaces etcd wholesale; none of the repairs below should
+	// second-guess the control plane while it runs.
+	if task != nil && strings.EqualFold(strings.TrimSpace(task.Action), "restore_etcd") {
+		restoreEtcd(ctx, apiClient, apiKey, task)
+		recordSync("restore_etcd", nil)
+		return
+	}
+	if task != nil && task.BootstrapOwner {
+		maybeSnapshotEtcd(ctx, apiClient, apiKey)
+		maybeReconcileNodeMetadata(ctx, apiClient, apiKey)
+	}
+
 	
-	maybeEnsureFlannelTolerations(ctx)
+	maybeEnsureCNI(ctx, task.CNI)
 	
 	maybeKickKubeletForCNI(ctx)
 	
@@ -111,6 +128,7 @@ func Sync(ctx context.Context, apiClient *client.Client, apiKey string) {
 	task = maybeForceControlPlaneRejoinWhenLocalAPIServerUnhealthy(ctx, apiClient, apiKey, task)
 
 	action := strings.ToLower(strings.TrimSpace(task.Action))
+	recordSync(action, nil)
 	if action != "" && action != "none" {
 		if task.Note != "" {
 			log.Printf("Kubernetes task: action=%s note=%s", action, task.Note)
@@ -167,8 +185,8 @@ DECIDE:
 			return
 		}
 
-		log.Printf("Kubernetes init parameters: endpoint=%q podCIDR=%q serviceCIDR=%q version=%q",
-			task.ControlPlaneEndpoint, task.PodCIDR, task.ServiceCIDR, task.KubernetesVersion)
+		log.Printf("Kubernetes init parameters: cluster=%q endpoint=%q podCIDR=%q serviceCIDR=%q version=%q",
+			task.Cluster, task.ControlPlaneEndpoint, task.PodCIDR, task.ServiceCIDR, task.KubernetesVersion)
 
 		res, err := initCluster(ctx, task)
 		if err != nil {
@@ -190,6 +208,13 @@ DECIDE:
 			ControlPlaneJoinCommand: res.controlPlaneJoinCommand,
 			JoinCommandExpiresAt:    res.joinExpiresAt.UTC().Format(time.RFC3339),
 		}
+		if task.UploadKubeconfig {
+			if data, err := os.ReadFile(adminConfPath); err != nil {
+				log.Printf("Kubernetes: failed to read %s for upload: %v", adminConfPath, err)
+			} else {
+				report.Kubeconfig = string(data)
+			}
+		}
 		if err := apiClient.ReportKubernetes(apiKey, report); err != nil {
 			log.Printf("Failed to report kubernetes init: %v", err)
 		}
@@ -263,6 +288,11 @@ DECIDE:
 			_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "error", Message: fmt.Sprintf("dependency install failed: %v", err)})
 			return
 		}
+		if err := ensureClusterVersion(ctx, task.KubernetesVersion); err != nil {
+			log.Printf("Kubernetes join(control-plane) failed (version): %v", err)
+			_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "error", Message: fmt.Sprintf("kubernetes %s install failed: %v", task.KubernetesVersion, err)})
+			return
+		}
 		log.Printf("Kubernetes join(control-plane): target=%s", parseJoinTarget(task.JoinCommand))
 		if err := joinCluster(ctx, task.JoinCommand); err != nil {
 			errMsg := err.Error()
@@ -288,7 +318,10 @@ DECIDE:
 		ensureControlPlaneLabels(ctx)
 		maybeEnsureKubeletNodeIP(ctx)
 		lastControlPlaneJoinCompleted = time.Now() // Mark join time for grace period
-		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "joined_control_plane"})
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{
+			State:             "joined_control_plane",
+			KubernetesVersion: pkgmgr.InstalledKubernetesVersion(ctx, "kubelet"),
+		})
 		return
 	case "join_worker":
 		if isJoined() {
@@ -318,6 +351,11 @@ DECIDE:
 			_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "error", Message: fmt.Sprintf("dependency install failed: %v", err)})
 			return
 		}
+		if err := ensureClusterVersion(ctx, task.KubernetesVersion); err != nil {
+			log.Printf("Kubernetes join(worker) failed (version): %v", err)
+			_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "error", Message: fmt.Sprintf("kubernetes %s install failed: %v", task.KubernetesVersion, err)})
+			return
+		}
 		log.Printf("Kubernetes join(worker): target=%s", parseJoinTarget(task.JoinCommand))
 		if err := joinCluster(ctx, task.JoinCommand); err != nil {
 			errMsg := err.Error()
@@ -341,7 +379,13 @@ DECIDE:
 			}
 		}
 		maybeEnsureKubeletNodeIP(ctx)
-		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "joined_worker"})
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{
+			State:             "joined_worker",
+			KubernetesVersion: pkgmgr.InstalledKubernetesVersion(ctx, "kubelet"),
+		})
+		return
+	case "upgrade":
+		upgradeNode(ctx, apiClient, apiKey, task)
 		return
 	default:
 		log.Printf("Unknown kubernetes task action: %q", task.Action)
@@ -349,6 +393,93 @@ DECIDE:
 	}
 }
 
+// upgradeNode runs this node's step of a rolling upgrade. The API has
+// drained the node already and uncordons it once the kubelet is back Ready
+// on the new release. Progress goes to the API as it happens; a failure
+// stops the whole upgrade until an operator retries it.
+func upgradeNode(ctx context.Context, apiClient *client.Client, apiKey string, task *client.KubernetesTask) {
+	version := task.KubernetesVersion
+	progress := func(msg string) {
+		log.Printf("Kubernetes upgrade: %s", msg)
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "upgrading", Message: msg})
+	}
+	fail := func(err error) {
+		log.Printf("Kubernetes upgrade failed: %v", err)
+		_ = apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "upgrade_failed", Message: err.Error()})
+	}
+	done := func(release string) {
+		log.Printf("Kubernetes upgrade: node is on %s", release)
+		if err := apiClient.ReportKubernetes(apiKey, client.KubernetesReport{State: "upgraded", KubernetesVersion: release}); err != nil {
+			log.Printf("Failed to report kubernetes upgrade: %v", err)
+		}
+	}
+
+	// The kubelet is upgraded last, so finding it on the target release
+	// means an earlier run finished and only its report got lost.
+	if installed := pkgmgr.InstalledKubernetesVersion(ctx, "kubelet"); pkgmgr.KubernetesVersionMatches(installed, version) {
+		done(installed)
+		return
+	}
+
+	if isControlPlaneNode() {
+		if ok, reason := etcdHealthy(ctx); !ok {
+			progress("waiting for local etcd to become healthy: " + reason)
+			return
+		}
+	}
+
+	progress("installing kubeadm " + version)
+	release, err := pkgmgr.InstallKubernetesPackages(ctx, version, "kubeadm")
+	if err != nil {
+		fail(fmt.Errorf("install kubeadm: %w", err))
+		return
+	}
+
+	if task.UpgradeApply {
+		progress("running kubeadm upgrade apply " + release)
+		if out, err := runLogged(ctx, "kubeadm", "upgrade", "apply", "-y", release); err != nil {
+			fail(fmt.Errorf("%w: %s", err, truncate(out, 2000)))
+			return
+		}
+	} else {
+		progress("running kubeadm upgrade node")
+		if out, err := runLogged(ctx, "kubeadm", "upgrade", "node"); err != nil {
+			fail(fmt.Errorf("%w: %s", err, truncate(out, 2000)))
+			return
+		}
+	}
+
+	progress("installing kubelet and kubectl " + release)
+	if _, err := pkgmgr.InstallKubernetesPackages(ctx, release, "kubelet", "kubectl"); err != nil {
+		fail(fmt.Errorf("install kubelet: %w", err))
+		return
+	}
+	_, _ = runLogged(ctx, "systemctl", "daemon-reload")
+	if _, err := runLogged(ctx, "systemctl", "restart", "kubelet"); err != nil {
+		fail(err)
+		return
+	}
+	if isControlPlaneNode() {
+		// The static pods restart on the new release; give them the same
+		// grace period as a fresh join before health checks may force a rejoin.
+		lastControlPlaneJoinCompleted = time.Now()
+	}
+	done(release)
+}
+
+// ensureClusterVersion installs the cluster's release before a join, so a
+// node joining after an upgrade doesn't come up on the default packages.
+func ensureClusterVersion(ctx context.Context, version string) error {
+	if version == "" {
+		return nil
+	}
+	if pkgmgr.KubernetesVersionMatches(pkgmgr.InstalledKubernetesVersion(ctx, "kubeadm"), version) {
+		return nil
+	}
+	_, err := pkgmgr.InstallKubernetesPackages(ctx, version, "kubeadm", "kubelet", "kubectl")
+	return err
+}
+
 func maybeForceRejoinWhenNotJoined(apiClient *client.Client, apiKey string) bool {
 	missingJoinMu.Lock()
 	defer missingJoinMu.Unlock()
@@ -497,7 +628,7 @@ func initCluster(ctx context.Context, task *client.KubernetesTask) (*initResult,
 		if err := ensureRootKubeconfig(); err != nil {
 			log.Printf("Warning: failed to set up kubeconfig: %v", err)
 		}
-		ensureFlannelTolerations(ctx)
+		ensureCNI(ctx, task.CNI)
 		return generateJoinCommands(ctx)
 	}
 
@@ -554,12 +685,9 @@ func initCluster(ctx context.Context, task *client.KubernetesTask) (*initResult,
 
 	maybeEnsureApiserverAnonymousAuth(ctx)
 
-	
-	log.Println("Installing Flannel CNI...")
-	if out, err := runKubectlLogged(ctx, "apply", "-f", "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml"); err != nil {
-		log.Printf("Warning: failed to apply flannel manifest: %v\n%s", err, truncate(out, 4000))
+	if err := installCNI(ctx, task.CNI, podCIDR); err != nil {
+		log.Printf("Warning: failed to install %s CNI: %v", normalizeCNI(task.CNI), err)
 	}
-	ensureFlannelTolerations(ctx)
 
 	return generateJoinCommands(ctx)
 }
@@ -661,7 +789,7 @@ func ensureFlannelTolerations(ctx context.Context) {
 		if time.Since(lastFlannelInstallAttempt) > 5*time.Minute {
 			lastFlannelInstallAttempt = time.Now()
 			log.Printf("No flannel daemonset found; attempting to install Flannel CNI...")
-			if out, err := runKubectlLogged(ctx, "apply", "-f", "https://github.com/flannel-io/flannel/releases/latest/download/kube-flannel.yml"); err != nil {
+			if out, err := runKubectlLogged(ctx, "apply", "-f", flannelManifestURL); err != nil {
 				log.Printf("Warning: failed to apply flannel manifest: %v\n%s", err, truncate(out, 4000))
 				return
 			}
@@ -735,6 +863,10 @@ func ensureControlPlaneLabels(ctx context.Context) {
 	_, _ = runKubectlLogged(ctx, "taint", "node", nodeName, "node-role.kubernetes.io/control-plane=:NoSchedule", "--overwrite")
 }
 
+func localClusterState() (initialized, joined, controlPlane bool) {
+	return isInitialized(), isJoined(), isControlPlaneNode()
+}
+
 func isInitialized() bool {
 	_, err := os.Stat(adminConfPath)
 	return err == nil
@@ -1261,6 +1393,7 @@ type ipAddrShowEntry struct {
 		Family    string `json:"family"`
 		Local     string `json:"local"`
 		PrefixLen int    `json:"prefixlen"`
+		Label     string `json:"label"`
 	} `json:"addr_info"`
 }
 
@@ -1302,6 +1435,10 @@ func detectWireGuardAdvertiseAddress(ctx context.Context) (string, error) {
 			if ai.Family != "inet" {
 				continue
 			}
+			// Service VIPs float between nodes; never advertise the API server on one.
+			if strings.HasSuffix(ai.Label, ":vip") {
+				continue
+			}
 			addr, err := netip.ParseAddr(strings.TrimSpace(ai.Local))
 			if err != nil {
 				continue
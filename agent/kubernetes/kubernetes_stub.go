@@ -10,3 +10,5 @@ import (
 
 
 func Sync(_ context.Context, _ *client.Client, _ string) {}
+
+func localClusterState() (initialized, joined, controlPlane bool) { return false, false, false }
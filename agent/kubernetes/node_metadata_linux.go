@@ -0,0 +1,257 @@
+//go:build linux
+// +build linux
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gluon-agent/client"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Annotations remembering which labels and taints Gluon put on a node, so
+// ones dropped from the desired set are removed without touching anything
+// set by other controllers or by hand.
+const (
+	managedLabelsAnnotation = "gluon.io/managed-labels"
+	managedTaintsAnnotation = "gluon.io/managed-taints"
+)
+
+var nodeMetadataMu sync.Mutex
+var lastNodeMetadataSync time.Time
+
+type kubeNodeList struct {
+	Items []kubeNode `json:"items"`
+}
+
+type kubeNode struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []kubeTaint `json:"taints"`
+	} `json:"spec"`
+}
+
+type kubeTaint struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Effect    string `json:"effect"`
+	TimeAdded string `json:"timeAdded,omitempty"`
+}
+
+// maybeReconcileNodeMetadata applies the labels and taints the API derives
+// for every node. Only the bootstrap hub runs it, as workers have no
+// credentials to edit node objects.
+func maybeReconcileNodeMetadata(ctx context.Context, apiClient *client.Client, apiKey string) {
+	if !isInitialized() || !isControlPlaneNode() {
+		return
+	}
+
+	nodeMetadataMu.Lock()
+	defer nodeMetadataMu.Unlock()
+	if time.Since(lastNodeMetadataSync) < 2*time.Minute {
+		return
+	}
+	lastNodeMetadataSync = time.Now()
+
+	if err := ensureRootKubeconfig(); err != nil {
+		log.Printf("Warning: failed to set up kubeconfig: %v", err)
+		return
+	}
+
+	desired, err := apiClient.GetKubernetesNodeMetadata(apiKey)
+	if err != nil {
+		log.Printf("Kubernetes: failed to get node metadata: %v", err)
+		return
+	}
+	if len(desired) == 0 {
+		return
+	}
+
+	out, err := runKubectlCaptured(ctx, "get", "nodes", "-o", "json")
+	if err != nil {
+		log.Printf("Kubernetes: failed to list nodes for metadata sync: %v", err)
+		return
+	}
+	var nodes kubeNodeList
+	if err := json.Unmarshal([]byte(out), &nodes); err != nil {
+		log.Printf("Kubernetes: failed to parse node list: %v", err)
+		return
+	}
+
+	for _, md := range desired {
+		node := findKubeNode(nodes.Items, md.Hostname)
+		if node == nil {
+			continue
+		}
+		if err := patchNodeMetadata(ctx, node, md); err != nil {
+			log.Printf("Kubernetes: failed to update labels/taints on %s: %v", node.Metadata.Name, err)
+		}
+	}
+}
+
+// patchNodeMetadata patches node towards md. A merge patch replaces the
+// whole taint list, so it carries the resourceVersion it was computed from:
+// a taint added meanwhile, such as the out-of-service taint during
+// failover, makes the patch conflict instead of being dropped, and the
+// patch is rebuilt from a fresh copy of the node.
+func patchNodeMetadata(ctx context.Context, node *kubeNode, md client.KubernetesNodeMetadata) error {
+	const attempts = 3
+	for attempt := 1; ; attempt++ {
+		patch, changed := nodeMetadataPatch(node, md)
+		if !changed {
+			return nil
+		}
+		if node.Metadata.ResourceVersion != "" {
+			metadata, _ := patch["metadata"].(map[string]any)
+			if metadata == nil {
+				metadata = map[string]any{}
+				patch["metadata"] = metadata
+			}
+			metadata["resourceVersion"] = node.Metadata.ResourceVersion
+		}
+		body, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		out, err := runKubectlLogged(ctx, "patch", "node", node.Metadata.Name, "--type=merge", "-p", string(body))
+		if err == nil {
+			log.Printf("Kubernetes: updated labels/taints on %s", node.Metadata.Name)
+			return nil
+		}
+		if attempt == attempts || !isConflict(out) {
+			return fmt.Errorf("%w\n%s", err, truncate(out, 2000))
+		}
+
+		fresh, err := runKubectlCaptured(ctx, "get", "node", node.Metadata.Name, "-o", "json")
+		if err != nil {
+			return err
+		}
+		node = &kubeNode{}
+		if err := json.Unmarshal([]byte(fresh), node); err != nil {
+			return err
+		}
+	}
+}
+
+func isConflict(out string) bool {
+	return strings.Contains(out, "(Conflict)") || strings.Contains(out, "the object has been modified")
+}
+
+func findKubeNode(nodes []kubeNode, hostname string) *kubeNode {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	short := strings.SplitN(hostname, ".", 2)[0]
+	for i := range nodes {
+		n := &nodes[i]
+		if n.Metadata.Name == hostname || n.Metadata.Name == short || n.Metadata.Labels["kubernetes.io/hostname"] == hostname {
+			return n
+		}
+	}
+	return nil
+}
+
+// nodeMetadataPatch builds a merge patch moving node to md: desired labels
+// are set, previously managed ones no longer desired are removed, and the
+// taint list keeps every taint Gluon does not manage.
+func nodeMetadataPatch(node *kubeNode, md client.KubernetesNodeMetadata) (map[string]any, bool) {
+	labels := map[string]any{}
+	annotations := map[string]any{}
+
+	for k, v := range md.Labels {
+		if cur, ok := node.Metadata.Labels[k]; !ok || cur != v {
+			labels[k] = v
+		}
+	}
+	for _, k := range splitManaged(node.Metadata.Annotations[managedLabelsAnnotation]) {
+		if _, keep := md.Labels[k]; keep {
+			continue
+		}
+		if _, present := node.Metadata.Labels[k]; present {
+			labels[k] = nil
+		}
+	}
+	labelKeys := make([]string, 0, len(md.Labels))
+	for k := range md.Labels {
+		labelKeys = append(labelKeys, k)
+	}
+	if v := joinManaged(labelKeys); v != node.Metadata.Annotations[managedLabelsAnnotation] {
+		annotations[managedLabelsAnnotation] = v
+	}
+
+	managedTaints := map[string]bool{}
+	for _, id := range splitManaged(node.Metadata.Annotations[managedTaintsAnnotation]) {
+		managedTaints[id] = true
+	}
+	desiredIDs := make([]string, 0, len(md.Taints))
+	for _, t := range md.Taints {
+		id := t.Key + ":" + t.Effect
+		managedTaints[id] = true
+		desiredIDs = append(desiredIDs, id)
+	}
+
+	taints := []kubeTaint{}
+	current := map[string]kubeTaint{}
+	for _, t := range node.Spec.Taints {
+		id := t.Key + ":" + t.Effect
+		current[id] = t
+		if !managedTaints[id] {
+			taints = append(taints, t)
+		}
+	}
+	taintsChanged := len(current) != len(taints)+len(md.Taints)
+	for _, t := range md.Taints {
+		id := t.Key + ":" + t.Effect
+		kt := kubeTaint{Key: t.Key, Value: t.Value, Effect: t.Effect}
+		if cur, ok := current[id]; ok && cur.Value == t.Value {
+			kt.TimeAdded = cur.TimeAdded
+		} else {
+			taintsChanged = true
+		}
+		taints = append(taints, kt)
+	}
+	if v := joinManaged(desiredIDs); v != node.Metadata.Annotations[managedTaintsAnnotation] {
+		annotations[managedTaintsAnnotation] = v
+	}
+
+	patch := map[string]any{}
+	metadata := map[string]any{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if len(metadata) > 0 {
+		patch["metadata"] = metadata
+	}
+	if taintsChanged {
+		patch["spec"] = map[string]any{"taints": taints}
+	}
+	return patch, len(patch) > 0
+}
+
+func splitManaged(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func joinManaged(keys []string) string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
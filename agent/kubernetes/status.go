@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// unmanaged is set when manage_kubernetes is off in agent.conf; Sync then
+// runs no Kubernetes task at all.
+var unmanaged atomic.Bool
+
+// SetManaged turns Kubernetes management on or off.
+func SetManaged(enabled bool) {
+	unmanaged.Store(!enabled)
+}
+
+// LocalStatus is the Kubernetes side of the agent's local status API.
+type LocalStatus struct {
+	Initialized  bool       `json:"initialized"`
+	Joined       bool       `json:"joined"`
+	ControlPlane bool       `json:"control_plane"`
+	LastSync     *time.Time `json:"last_sync,omitempty"`
+	LastAction   string     `json:"last_action,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+var (
+	syncStatusMu sync.Mutex
+	syncStatus   LocalStatus
+)
+
+// recordSync notes the outcome of the latest Sync.
+func recordSync(action string, err error) {
+	now := time.Now()
+	syncStatusMu.Lock()
+	defer syncStatusMu.Unlock()
+	syncStatus.LastSync = &now
+	syncStatus.LastAction = action
+	syncStatus.LastError = ""
+	if err != nil {
+		syncStatus.LastError = err.Error()
+	}
+}
+
+// Status reports the local cluster membership and the latest Sync.
+func Status() LocalStatus {
+	syncStatusMu.Lock()
+	st := syncStatus
+	syncStatusMu.Unlock()
+	st.Initialized, st.Joined, st.ControlPlane = localClusterState()
+	return st
+}
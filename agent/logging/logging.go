@@ -0,0 +1,50 @@
+// Package logging filters the agent's routine progress messages by the
+// log_level set in agent.conf. Warnings and errors go through the standard
+// log package and are always written.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+)
+
+var level atomic.Int32
+
+func init() { level.Store(levelInfo) }
+
+// SetLevel switches to one of "debug", "info" or "warn". Debug also adds
+// source locations to every log line.
+func SetLevel(name string) {
+	switch name {
+	case "debug":
+		level.Store(levelDebug)
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+		return
+	case "warn":
+		level.Store(levelWarn)
+	default:
+		level.Store(levelInfo)
+	}
+	log.SetFlags(log.LstdFlags)
+}
+
+// Debugf logs detail that is only useful while debugging.
+func Debugf(format string, args ...any) {
+	if level.Load() <= levelDebug {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof logs routine progress, such as a heartbeat sent.
+func Infof(format string, args ...any) {
+	if level.Load() <= levelInfo {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
@@ -3,22 +3,31 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"gluon-agent/applier"
 	"gluon-agent/client"
 	"gluon-agent/config"
 	"gluon-agent/keys"
 	"gluon-agent/kubernetes"
+	"gluon-agent/logging"
 	"gluon-agent/pkgmgr"
+	"gluon-agent/status"
+	"gluon-agent/vip"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+	}
+
 	log.Println("gluon-agent v0 starting up...")
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -31,6 +40,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	startupTunables := cfg.Tunables
+	applyTunables(&startupTunables)
+	reloadOnSIGHUP(ctx, configPath)
 
 	if cfg.APIURL == "" {
 		cfg.APIURL = getEnvOrDefault("GLUON_API_URL", "http://localhost:3000")
@@ -48,6 +60,61 @@ func main() {
 
 	log.Printf("System info: hostname=%s, os=%s, provider=%s", cfg.Hostname, cfg.OS, cfg.Provider)
 
+	// Syncs requested through the local socket are run by the config sync
+	// loop, which starts once the agent is enrolled.
+	syncRequests := make(chan chan error)
+	var syncReady atomic.Bool
+	requestSync := func(ctx context.Context) error {
+		if !syncReady.Load() {
+			return status.ErrSyncUnavailable
+		}
+		reply := make(chan error, 1)
+		select {
+		case syncRequests <- reply:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case err := <-reply:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Plans go through the same loop so they never read files a sync is
+	// halfway through writing.
+	type planResult struct {
+		plan *client.ConfigPlan
+		err  error
+	}
+	planRequests := make(chan chan planResult)
+	requestPlan := func(ctx context.Context) (*client.ConfigPlan, error) {
+		if !syncReady.Load() {
+			return nil, status.ErrSyncUnavailable
+		}
+		reply := make(chan planResult, 1)
+		select {
+		case planRequests <- reply:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		select {
+		case r := <-reply:
+			return r.plan, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	status.SetHostname(cfg.Hostname)
+	socketPath := statusSocketPath(currentTunables())
+	go func() {
+		if err := status.Serve(ctx, socketPath, status.Handlers{Sync: requestSync, Plan: requestPlan}); err != nil {
+			log.Printf("Local status API unavailable: %v", err)
+		}
+	}()
+
 	// Bootstrap TLS: fetch CA certificate if needed and using HTTPS
 	apiClient := createAPIClient(cfg, configPath)
 
@@ -74,6 +141,7 @@ func main() {
 		}
 
 		log.Printf("Resuming enrollment polling for request_id=%d", requestID)
+		status.SetEnrollment(status.Enrollment{State: status.EnrollmentPending, RequestID: cfg.RequestID})
 		log.Println("Waiting for admin approval...")
 
 		if err := pollForApproval(ctx, apiClient, uint(requestID), cfg, configPath); err != nil {
@@ -89,10 +157,13 @@ func main() {
 			cfg.Provider,
 			cfg.OS,
 			cfg.DesiredRole,
+			cfg.BootstrapToken,
 		)
 		if err != nil {
 			log.Fatalf("Enrollment request failed: %v", err)
 		}
+		// The token has done its job; don't keep it on disk.
+		cfg.BootstrapToken = ""
 
 		cfg.RequestID = strconv.Itoa(int(requestID))
 		cfg.EnrollmentSecret = enrollmentSecret
@@ -101,6 +172,7 @@ func main() {
 		}
 
 		log.Printf("Enrollment requested, request_id=%d", requestID)
+		status.SetEnrollment(status.Enrollment{State: status.EnrollmentPending, RequestID: cfg.RequestID})
 		log.Println("Waiting for admin approval...")
 
 		if err := pollForApproval(ctx, apiClient, requestID, cfg, configPath); err != nil {
@@ -110,21 +182,19 @@ func main() {
 		log.Printf("Enrollment successful! Node ID: %s", cfg.NodeID)
 	}
 
-	heartbeatSecondsStr := getEnvOrDefault("GLUON_HEARTBEAT_INTERVAL_SECONDS", "30")
-	heartbeatSeconds, err := strconv.Atoi(heartbeatSecondsStr)
-	if err != nil || heartbeatSeconds <= 0 {
-		heartbeatSeconds = 30
-	}
+	status.SetEnrollment(status.Enrollment{State: status.EnrollmentEnrolled, NodeID: cfg.NodeID})
 
-	log.Printf("Starting heartbeat loop (%ds interval)...", heartbeatSeconds)
-	heartbeatTicker := time.NewTicker(time.Duration(heartbeatSeconds) * time.Second)
+	log.Printf("Starting heartbeat loop (%s interval)...", heartbeatInterval())
+	heartbeatTicker := time.NewTicker(heartbeatInterval())
 	defer heartbeatTicker.Stop()
 
 	go func() {
-		if err := apiClient.Heartbeat(cfg.APIKey, cfg.DesiredRole); err != nil {
+		err := apiClient.Heartbeat(cfg.APIKey, cfg.DesiredRole)
+		status.RecordHeartbeat(err)
+		if err != nil {
 			log.Printf("Initial heartbeat failed: %v", err)
 		} else {
-			log.Println("Initial heartbeat sent successfully")
+			logging.Infof("Initial heartbeat sent successfully")
 		}
 
 		for {
@@ -132,30 +202,53 @@ func main() {
 			case <-ctx.Done():
 				log.Println("Heartbeat goroutine exiting...")
 				return
+			case <-heartbeatWake:
+				heartbeatTicker.Reset(heartbeatInterval())
 			case <-heartbeatTicker.C:
-				if err := apiClient.Heartbeat(cfg.APIKey, cfg.DesiredRole); err != nil {
+				err := apiClient.Heartbeat(cfg.APIKey, cfg.DesiredRole)
+				status.RecordHeartbeat(err)
+				if err != nil {
 					log.Printf("Heartbeat failed: %v", err)
 				} else {
-					log.Println("Heartbeat sent")
+					logging.Infof("Heartbeat sent")
 				}
 			}
 		}
 	}()
 
-	log.Println("Starting config sync loop (60s interval)...")
-	configTicker := time.NewTicker(60 * time.Second)
+	client.ServiceVIPStatusProvider = vip.Default.Statuses
+	go vip.Default.Run(ctx)
+	go applier.WatchKeyOverlaps(ctx)
+
+	log.Printf("Starting config sync loop (%s interval)...", currentTunables().ConfigSyncInterval())
+	configTicker := time.NewTicker(currentTunables().ConfigSyncInterval())
 	defer configTicker.Stop()
 
 	go func() {
-		syncConfig(ctx, apiClient, cfg.APIKey)
+		runSync := func() error {
+			err := syncConfig(ctx, apiClient, cfg.APIKey)
+			status.RecordConfigSync(err)
+			return err
+		}
+		syncReady.Store(true)
+		runSync()
 
 		for {
 			select {
 			case <-ctx.Done():
 				log.Println("Config sync goroutine exiting...")
 				return
+			case <-configSyncWake:
+				configTicker.Reset(currentTunables().ConfigSyncInterval())
 			case <-configTicker.C:
-				syncConfig(ctx, apiClient, cfg.APIKey)
+				runSync()
+			case reply := <-syncRequests:
+				log.Println("Config sync requested through the local status API")
+				reply <- runSync()
+				configTicker.Reset(currentTunables().ConfigSyncInterval())
+			case reply := <-planRequests:
+				plan, err := planConfig(apiClient, cfg.APIKey)
+				reply <- planResult{plan, err}
 			}
 		}
 	}()
@@ -166,7 +259,7 @@ func main() {
 }
 
 func pollForApproval(ctx context.Context, apiClient *client.Client, requestID uint, cfg *config.Config, configPath string) error {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(currentTunables().EnrollmentPollInterval())
 	defer ticker.Stop()
 
 	if cfg.EnrollmentSecret == "" {
@@ -179,6 +272,7 @@ func pollForApproval(ctx context.Context, apiClient *client.Client, requestID ui
 			return ctx.Err()
 
 		case <-ticker.C:
+			ticker.Reset(currentTunables().EnrollmentPollInterval())
 			status, nodeID, apiKey, err := apiClient.CheckEnrollmentStatus(requestID, cfg.EnrollmentSecret)
 			if err != nil {
 				if errors.Is(err, client.ErrInvalidEnrollmentSecret) {
@@ -308,10 +402,11 @@ func trySwitchToHTTPS(cfg *config.Config, configPath, httpsURL string) bool {
 	return true
 }
 
-func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) {
-	log.Println("Syncing configuration...")
+func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) error {
+	logging.Infof("Syncing configuration...")
 	// Kubernetes bootstrap/join (single cluster) is driven by the API task endpoint,
 	// and should run even when the network/config bundle is unchanged.
+	// manage_kubernetes=false turns every task into a no-op.
 	defer kubernetes.Sync(ctx, apiClient, apiKey)
 
 	networkInfo, err := apiClient.GetNetworkInfo(apiKey)
@@ -320,15 +415,17 @@ func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) {
 	} else if len(networkInfo.RequiredInterfaces) == 0 {
 		log.Println("No network interfaces configured yet; skipping WireGuard key upload")
 	} else {
-		log.Printf("Required interfaces: %v", networkInfo.RequiredInterfaces)
+		logging.Debugf("Required interfaces: %v", networkInfo.RequiredInterfaces)
+
+		state, _ := keys.LoadUploadState()
+		reconcileKeyRotations(networkInfo, state)
 
 		pubKeys, err := keys.EnsureKeys(networkInfo.RequiredInterfaces)
 		if err != nil {
 			log.Printf("Failed to ensure keys: %v", err)
 		} else {
-			state, _ := keys.LoadUploadState()
-			if state != nil && keys.EqualPublicKeys(state.PublicKeys, pubKeys) {
-				log.Printf("WireGuard public keys unchanged (%d); skipping upload", len(pubKeys))
+			if state != nil && keys.EqualPublicKeys(state.PublicKeys, pubKeys) && apiHasKeys(networkInfo, pubKeys) {
+				logging.Debugf("WireGuard public keys unchanged (%d); skipping upload", len(pubKeys))
 			} else if err := apiClient.UploadPublicKeys(apiKey, pubKeys); err != nil {
 				log.Printf("Failed to upload public keys: %v", err)
 			} else {
@@ -338,11 +435,21 @@ func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) {
 		}
 	}
 
+	if networkInfo != nil && networkInfo.EndpointEchoPort > 0 {
+		if obs, err := apiClient.ObservePublicEndpoint(networkInfo.EndpointEchoPort); err != nil {
+			log.Printf("Endpoint discovery failed: %v", err)
+		} else if obs.BehindNAT {
+			log.Printf("Public address %s is not local; node is behind NAT", obs.PublicIP)
+		}
+	}
+
 	configBundle, err := apiClient.GetConfig(apiKey)
 	if err != nil {
 		log.Printf("Failed to get config: %v", err)
-		return
+		return fmt.Errorf("failed to get config: %w", err)
 	}
+	vip.Default.Update(configBundle.ServiceVIPs)
+	applier.SetKeyOverlaps(configBundle.KeyOverlaps)
 
 	state, err := applier.LoadState()
 	if err != nil {
@@ -351,7 +458,7 @@ func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) {
 	}
 
 	if !applier.NeedsUpdate(configBundle, state) {
-		log.Printf("Config is up to date (version %d)", state.Version)
+		logging.Infof("Config is up to date (version %d)", state.Version)
 		// Even if the bundle is unchanged, ensure interfaces are up (e.g., after reboot),
 		// otherwise kubelet can fall back to the LAN IP and control-plane traffic may break.
 		if networkInfo != nil && len(networkInfo.RequiredInterfaces) > 0 {
@@ -359,19 +466,117 @@ func syncConfig(ctx context.Context, apiClient *client.Client, apiKey string) {
 		} else {
 			applier.EnsureInterfacesUp(nil)
 		}
-		return
+		return nil
 	}
 
 	log.Printf("Config update needed: current=%d, new=%d", state.Version, configBundle.Version)
 
-	if err := applier.ApplyConfig(configBundle); err != nil {
+	plan, err := applier.PlanConfig(configBundle)
+	if err != nil {
+		log.Printf("Failed to plan config: %v", err)
+		return fmt.Errorf("failed to plan config: %w", err)
+	}
+	dryRun := currentTunables().DryRun
+	plan.DryRun = dryRun
+	logPlan(plan)
+	reportPlan(apiClient, apiKey, &plan.ConfigPlan)
+	if dryRun {
+		log.Printf("dry_run is set; not applying config version %d", configBundle.Version)
+		return nil
+	}
+
+	if err := applier.ApplyPlan(configBundle, plan); err != nil {
 		log.Printf("Failed to apply config: %v", err)
-		return
+		return fmt.Errorf("failed to apply config: %w", err)
 	}
 
 	if err := apiClient.ReportConfigApplied(apiKey, configBundle.Version, configBundle.Hash); err != nil {
 		log.Printf("Failed to report config applied: %v", err)
 	}
 
-	log.Println("Config sync completed successfully")
+	logging.Infof("Config sync completed successfully")
+	return nil
+}
+
+// lastReportedPlan is the fingerprint of the plan last sent to the API. A
+// sync that computes the same plan again, e.g. while an apply keeps
+// failing, doesn't report it again. Only the config sync loop touches it.
+var lastReportedPlan string
+
+func reportPlan(apiClient *client.Client, apiKey string, plan *client.ConfigPlan) {
+	fingerprint := plan.Fingerprint()
+	if fingerprint == lastReportedPlan {
+		logging.Debugf("Config plan unchanged since the last report; not reporting it again")
+		return
+	}
+	if err := apiClient.ReportConfigPlan(apiKey, plan); err != nil {
+		log.Printf("Failed to report config plan: %v", err)
+		return
+	}
+	lastReportedPlan = fingerprint
+}
+
+// logPlan logs the actions a config plan takes, marking the ones that
+// interrupt traffic.
+func logPlan(plan *applier.Plan) {
+	if plan.Empty() {
+		logging.Infof("Config plan: no changes")
+		return
+	}
+	log.Printf("Config plan: %d file(s) changed, %d action(s), disruptive=%t", len(plan.Files), len(plan.Actions), plan.Disruptive)
+	for _, a := range plan.Actions {
+		mark := ""
+		if a.Disruptive {
+			mark = " (disruptive)"
+		}
+		log.Printf("  %s %s%s: %s", a.Action, a.Target, mark, a.Reason)
+	}
+}
+
+// planConfig fetches the current bundle and plans it without applying or
+// reporting it, for `gluon-agent plan`.
+func planConfig(apiClient *client.Client, apiKey string) (*client.ConfigPlan, error) {
+	configBundle, err := apiClient.GetConfig(apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+	plan, err := applier.PlanConfig(configBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan config: %w", err)
+	}
+	plan.DryRun = true
+	return &plan.ConfigPlan, nil
+}
+
+// reconcileKeyRotations retires or restores old WireGuard key pairs as the
+// API decides. Interfaces whose current key hasn't been uploaded yet are
+// skipped: the API still expecting the old key then just means it hasn't
+// heard about the rotation, not that it rolled it back.
+func reconcileKeyRotations(networkInfo *client.NetworkInfo, state *keys.UploadState) {
+	if state == nil {
+		return
+	}
+	for iface, expected := range networkInfo.InterfaceKeys {
+		current, err := keys.GetPublicKey(iface)
+		if err != nil || current != state.PublicKeys[iface] {
+			continue
+		}
+		restored, err := keys.ReconcileRotation(iface, expected.PublicKey, expected.RotationPending)
+		if err != nil {
+			log.Printf("Failed to reconcile key rotation for %s: %v", iface, err)
+		} else if restored {
+			log.Printf("WireGuard key rotation for %s was rolled back; restored previous key", iface)
+		}
+	}
+}
+
+// apiHasKeys reports whether the API's record matches the local public keys,
+// so keys are re-uploaded if the API lost or rolled back one.
+func apiHasKeys(networkInfo *client.NetworkInfo, pubKeys map[string]string) bool {
+	for iface, pub := range pubKeys {
+		if k, ok := networkInfo.InterfaceKeys[iface]; !ok || k.PublicKey != pub {
+			return false
+		}
+	}
+	return true
 }
@@ -8,7 +8,10 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -21,8 +24,8 @@ const (
 
 	k8sAptListPath    = "/etc/apt/sources.list.d/kubernetes.list"
 	k8sKeyringPath    = "/etc/apt/keyrings/kubernetes-apt-keyring.gpg"
-	k8sKeyURL         = "https://pkgs.k8s.io/core:/stable:/v1.29/deb/Release.key"
-	k8sRepoURL        = "https://pkgs.k8s.io/core:/stable:/v1.29/deb/"
+	k8sRepoURLFormat  = "https://pkgs.k8s.io/core:/stable:/%s/deb/"
+	defaultK8sMinor   = "v1.29"
 	k8sModulesPath    = "/etc/modules-load.d/k8s.conf"
 	k8sSysctlPath     = "/etc/sysctl.d/99-kubernetes-cri.conf"
 	containerdCfgPath = "/etc/containerd/config.toml"
@@ -32,6 +35,29 @@ const (
 
 var aptUpdated bool
 
+// ErrInstallDisabled is returned when a dependency is missing but
+// install_packages is off in agent.conf.
+var ErrInstallDisabled = errors.New("package installation is disabled by install_packages in agent.conf")
+
+var (
+	installDisabled    atomic.Bool
+	kubernetesDisabled atomic.Bool
+)
+
+// Configure sets whether missing packages may be installed and whether the
+// Kubernetes dependencies are wanted at all.
+func Configure(installPackages, kubernetes bool) {
+	installDisabled.Store(!installPackages)
+	kubernetesDisabled.Store(!kubernetes)
+}
+
+func installAllowed(what string) error {
+	if installDisabled.Load() {
+		return fmt.Errorf("%s is not installed and %w", what, ErrInstallDisabled)
+	}
+	return nil
+}
+
 func EnsureDependencies(ctx context.Context) error {
 	if os.Geteuid() != 0 {
 		return errors.New("package installation requires root privileges")
@@ -45,6 +71,9 @@ func EnsureDependencies(ctx context.Context) error {
 		return fmt.Errorf("frr dependency: %w", err)
 	}
 
+	if kubernetesDisabled.Load() {
+		return nil
+	}
 	if err := ensureKubernetes(ctx); err != nil {
 		return fmt.Errorf("kubernetes dependency: %w", err)
 	}
@@ -57,6 +86,9 @@ func ensureWireGuard(ctx context.Context) error {
 		return nil
 	}
 
+	if err := installAllowed("WireGuard"); err != nil {
+		return err
+	}
 	log.Println("WireGuard not detected, installing wireguard + wireguard-tools...")
 	if err := aptUpdate(ctx); err != nil {
 		return err
@@ -70,6 +102,9 @@ func ensureFRR(ctx context.Context) error {
 		return nil
 	}
 
+	if err := installAllowed("FRR"); err != nil {
+		return err
+	}
 	log.Println("FRR not detected, installing from FRRouting repository...")
 
 	if err := ensureFRRRepo(ctx); err != nil {
@@ -111,6 +146,9 @@ func ensureKubernetes(ctx context.Context) error {
 		return nil
 	}
 
+	if err := installAllowed("kubeadm/kubelet/kubectl"); err != nil {
+		return err
+	}
 	log.Println("Kubernetes tools not detected, installing container runtime + kubeadm/kubelet/kubectl...")
 
 	if err := aptUpdate(ctx); err != nil {
@@ -127,7 +165,7 @@ func ensureKubernetes(ctx context.Context) error {
 		return err
 	}
 
-	if err := ensureK8sRepo(ctx); err != nil {
+	if err := ensureK8sRepo(ctx, defaultK8sMinor); err != nil {
 		return err
 	}
 
@@ -186,6 +224,9 @@ func ensureContainerd(ctx context.Context) error {
 		return configureContainerdSystemdCgroup(ctx)
 	}
 
+	if err := installAllowed("containerd"); err != nil {
+		return err
+	}
 	if err := aptUpdate(ctx); err != nil {
 		return err
 	}
@@ -222,17 +263,27 @@ func configureContainerdSystemdCgroup(ctx context.Context) error {
 	return nil
 }
 
-func ensureK8sRepo(ctx context.Context) error {
+// ensureK8sRepo points the Kubernetes apt repository at one minor release
+// (vX.Y); pkgs.k8s.io publishes a repository per minor. Switching forces the
+// next aptUpdate to refetch the package lists.
+func ensureK8sRepo(ctx context.Context, minor string) error {
+	repoURL := fmt.Sprintf(k8sRepoURLFormat, minor)
+	repoLine := fmt.Sprintf("deb [signed-by=%s] %s /\n", k8sKeyringPath, repoURL)
+	if current, err := os.ReadFile(k8sAptListPath); err == nil && string(current) == repoLine && fileExists(k8sKeyringPath) {
+		return nil
+	}
+
 	if err := os.MkdirAll("/etc/apt/keyrings", 0755); err != nil {
 		return err
 	}
 
-	keyBytes := commandOutput(ctx, "curl", "-fsSL", k8sKeyURL)
+	keyURL := repoURL + "Release.key"
+	keyBytes := commandOutput(ctx, "curl", "-fsSL", keyURL)
 	if len(keyBytes) == 0 {
-		keyBytes = commandOutput(ctx, "wget", "-qO-", k8sKeyURL)
+		keyBytes = commandOutput(ctx, "wget", "-qO-", keyURL)
 	}
 	if len(keyBytes) == 0 {
-		return fmt.Errorf("failed to download Kubernetes key from %s", k8sKeyURL)
+		return fmt.Errorf("failed to download Kubernetes key from %s", keyURL)
 	}
 
 	cmd := exec.CommandContext(ctx, "gpg", "--dearmor")
@@ -247,14 +298,135 @@ func ensureK8sRepo(ctx context.Context) error {
 		return fmt.Errorf("write keyring: %w", err)
 	}
 
-	repoLine := fmt.Sprintf("deb [signed-by=%s] %s /\n", k8sKeyringPath, k8sRepoURL)
 	if err := os.WriteFile(k8sAptListPath, []byte(repoLine), 0644); err != nil {
 		return fmt.Errorf("write repo list: %w", err)
 	}
+	aptUpdated = false
 
 	return nil
 }
 
+// InstallKubernetesPackages installs pkgs (kubeadm, kubelet, kubectl) at the
+// release version names: the newest patch of a vX.Y minor, or exactly
+// vX.Y.Z. The apt repository is switched to that minor first and the
+// packages' holds are lifted for the install and put back afterwards. It
+// returns the release installed, as vX.Y.Z.
+func InstallKubernetesPackages(ctx context.Context, version string, pkgs ...string) (string, error) {
+	minor, err := kubernetesMinor(version)
+	if err != nil {
+		return "", err
+	}
+	if installDisabled.Load() {
+		return "", fmt.Errorf("cannot install Kubernetes %s: %w", version, ErrInstallDisabled)
+	}
+	if err := ensureK8sRepo(ctx, minor); err != nil {
+		return "", err
+	}
+	if err := aptUpdate(ctx); err != nil {
+		return "", err
+	}
+
+	out, err := runCommand(ctx, "apt-cache", "madison", pkgs[0])
+	if err != nil {
+		return "", err
+	}
+	pkgVersion, err := pickPackageVersion(string(out), version)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"install", "-y", "--allow-change-held-packages", "--allow-downgrades"}
+	for _, pkg := range pkgs {
+		args = append(args, pkg+"="+pkgVersion)
+	}
+	_, _ = runCommand(ctx, "apt-mark", append([]string{"unhold"}, pkgs...)...)
+	_, installErr := runCommand(ctx, "apt-get", args...)
+	_, _ = runCommand(ctx, "apt-mark", append([]string{"hold"}, pkgs...)...)
+	if installErr != nil {
+		return "", installErr
+	}
+	return releaseFromPackageVersion(pkgVersion), nil
+}
+
+// InstalledKubernetesVersion returns the installed release of pkg as vX.Y.Z,
+// or "" when it isn't installed.
+func InstalledKubernetesVersion(ctx context.Context, pkg string) string {
+	out := commandOutput(ctx, "dpkg-query", "-W", "-f=${Version}", pkg)
+	if len(out) == 0 {
+		return ""
+	}
+	return releaseFromPackageVersion(strings.TrimSpace(string(out)))
+}
+
+// KubernetesVersionMatches reports whether an installed vX.Y.Z release
+// satisfies want, which is either a vX.Y minor or an exact release.
+func KubernetesVersionMatches(installed string, want string) bool {
+	if installed == "" || want == "" {
+		return false
+	}
+	want = "v" + strings.TrimPrefix(want, "v")
+	if strings.Count(want, ".") == 2 {
+		return installed == want
+	}
+	return strings.HasPrefix(installed, want+".")
+}
+
+// kubernetesMinor reduces vX.Y or vX.Y.Z to the vX.Y apt channel.
+func kubernetesMinor(version string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("invalid Kubernetes version %q", version)
+	}
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", fmt.Errorf("invalid Kubernetes version %q", version)
+		}
+	}
+	return "v" + parts[0] + "." + parts[1], nil
+}
+
+// pickPackageVersion chooses a package version from `apt-cache madison`
+// output ("kubeadm | 1.30.2-1.1 | https://... Packages"): the exact release
+// when version is vX.Y.Z, otherwise the highest patch of vX.Y.
+func pickPackageVersion(madison string, version string) (string, error) {
+	want := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	exact := strings.Count(want, ".") == 2
+
+	best, bestPatch := "", -1
+	for _, line := range strings.Split(madison, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 2 {
+			continue
+		}
+		pkgVersion := strings.TrimSpace(fields[1])
+		release := strings.TrimPrefix(releaseFromPackageVersion(pkgVersion), "v")
+		if exact {
+			if release == want {
+				return pkgVersion, nil
+			}
+			continue
+		}
+		if !strings.HasPrefix(release, want+".") {
+			continue
+		}
+		patch, err := strconv.Atoi(strings.TrimPrefix(release, want+"."))
+		if err == nil && patch > bestPatch {
+			best, bestPatch = pkgVersion, patch
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no package available for Kubernetes %s", version)
+	}
+	return best, nil
+}
+
+// releaseFromPackageVersion strips the Debian revision: 1.30.2-1.1 becomes
+// v1.30.2.
+func releaseFromPackageVersion(pkgVersion string) string {
+	release, _, _ := strings.Cut(pkgVersion, "-")
+	return "v" + release
+}
+
 func disableSwapInFstab() error {
 	const fstabPath = "/etc/fstab"
 	data, err := os.ReadFile(fstabPath)
@@ -359,18 +531,54 @@ func fileExists(path string) bool {
 }
 
 func configureFRRDaemons() error {
-	desired := map[string]string{
-		"ospfd": "yes",
+	_, err := writeFRRDaemons(map[string]string{"ospfd": "yes"})
+	return err
+}
+
+// frrBGPDaemonOptions moves bgpd off port 179, which Calico's BIRD holds on
+// hubs that also run pods.
+const frrBGPDaemonOptions = `"   -A 0.0.0.0 -p 1179"`
+
+// ConfigureFRRBGP turns bgpd on or off in /etc/frr/daemons. It reports
+// whether the file changed; FRR needs a restart to pick that up.
+func ConfigureFRRBGP(enabled bool) (bool, error) {
+	if !enabled && !fileExists(frrDaemonsPath) {
+		return false, nil
+	}
+	desired := map[string]string{"bgpd": "no"}
+	if enabled {
+		desired = map[string]string{"bgpd": "yes", "bgpd_options": frrBGPDaemonOptions}
 	}
+	return writeFRRDaemons(desired)
+}
 
-	current := map[string]string{}
-	var lines []string
+func writeFRRDaemons(desired map[string]string) (bool, error) {
+	var existing string
 	if fileExists(frrDaemonsPath) {
 		data, err := os.ReadFile(frrDaemonsPath)
 		if err != nil {
-			return err
+			return false, err
 		}
-		for _, line := range strings.Split(string(data), "\n") {
+		existing = string(data)
+	}
+
+	content := mergeFRRDaemons(existing, desired)
+	if content == existing {
+		return false, nil
+	}
+	if err := os.WriteFile(frrDaemonsPath, []byte(content), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mergeFRRDaemons sets the desired keys in a daemons file, keeping
+// everything else as it was. An empty file gets the full default list.
+func mergeFRRDaemons(existing string, desired map[string]string) string {
+	current := map[string]string{}
+	var lines []string
+	if existing != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(existing, "\n"), "\n") {
 			trim := strings.TrimSpace(line)
 			if strings.HasPrefix(trim, "#") || !strings.Contains(trim, "=") {
 				lines = append(lines, line)
@@ -407,19 +615,28 @@ func configureFRRDaemons() error {
 			"vrrpd=no",
 			"pathd=no",
 		}
+		for i, line := range lines {
+			if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+				current[parts[0]] = parts[1]
+				if v, ok := desired[parts[0]]; ok {
+					lines[i] = parts[0] + "=" + v
+				}
+			}
+		}
 	}
 
-	for k, v := range desired {
-		if cur, ok := current[k]; !ok || cur != v {
-			lines = append(lines, fmt.Sprintf("%s=%s", k, v))
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := current[k]; !ok {
+			lines = append(lines, fmt.Sprintf("%s=%s", k, desired[k]))
 		}
 	}
 
-	content := strings.Join(lines, "\n") + "\n"
-	if err := os.WriteFile(frrDaemonsPath, []byte(content), 0644); err != nil {
-		return err
-	}
-	return nil
+	return strings.Join(lines, "\n") + "\n"
 }
 
 func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
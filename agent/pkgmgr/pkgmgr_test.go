@@ -0,0 +1,91 @@
+package pkgmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const madison = `   kubeadm | 1.30.10-1.1 | https://pkgs.k8s.io/core:/stable:/v1.30/deb  Packages
+   kubeadm | 1.30.2-1.1 | https://pkgs.k8s.io/core:/stable:/v1.30/deb  Packages
+   kubeadm | 1.30.9-1.1 | https://pkgs.k8s.io/core:/stable:/v1.30/deb  Packages
+   kubeadm | 1.29.15-1.1 | https://pkgs.k8s.io/core:/stable:/v1.29/deb  Packages
+`
+
+func TestPickPackageVersion(t *testing.T) {
+	for version, want := range map[string]string{
+		"v1.30":   "1.30.10-1.1",
+		"1.30":    "1.30.10-1.1",
+		"v1.30.2": "1.30.2-1.1",
+		"v1.29":   "1.29.15-1.1",
+	} {
+		got, err := pickPackageVersion(madison, version)
+		require.NoError(t, err, version)
+		assert.Equal(t, want, got, version)
+	}
+
+	for _, version := range []string{"v1.31", "v1.30.3", "v1.3"} {
+		_, err := pickPackageVersion(madison, version)
+		assert.Error(t, err, version)
+	}
+}
+
+func TestKubernetesVersionMatches(t *testing.T) {
+	tests := []struct {
+		installed string
+		want      string
+		match     bool
+	}{
+		{"v1.30.2", "v1.30", true},
+		{"v1.30.2", "v1.30.2", true},
+		{"v1.30.2", "1.30.2", true},
+		{"v1.30.2", "v1.30.3", false},
+		{"v1.30.2", "v1.3", false},
+		{"v1.29.9", "v1.30", false},
+		{"", "v1.30", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, KubernetesVersionMatches(tt.installed, tt.want), "%s vs %s", tt.installed, tt.want)
+	}
+}
+
+func TestKubernetesMinor(t *testing.T) {
+	for in, want := range map[string]string{"v1.30": "v1.30", "v1.30.2": "v1.30", "1.31.0": "v1.31"} {
+		got, err := kubernetesMinor(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got)
+	}
+	for _, in := range []string{"", "v1", "v1.x", "v1.2.3.4"} {
+		_, err := kubernetesMinor(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestMergeFRRDaemons(t *testing.T) {
+	existing := "# comment\nbgpd=no\nospfd=yes\n"
+
+	enabled := mergeFRRDaemons(existing, map[string]string{"bgpd": "yes", "bgpd_options": frrBGPDaemonOptions})
+	assert.Equal(t, "# comment\nbgpd=yes\nospfd=yes\nbgpd_options="+frrBGPDaemonOptions+"\n", enabled)
+
+	// Applying the same settings again leaves the file alone.
+	assert.Equal(t, enabled, mergeFRRDaemons(enabled, map[string]string{"bgpd": "yes", "bgpd_options": frrBGPDaemonOptions}))
+	assert.Equal(t, existing, mergeFRRDaemons(existing, map[string]string{"bgpd": "no"}))
+
+	fresh := mergeFRRDaemons("", map[string]string{"ospfd": "yes", "bgpd": "yes"})
+	assert.Contains(t, fresh, "\nbgpd=yes\n")
+	assert.Equal(t, 1, strings.Count(fresh, "ospfd=yes"))
+}
+
+func TestInstallAllowed(t *testing.T) {
+	defer Configure(true, true)
+
+	Configure(true, true)
+	assert.NoError(t, installAllowed("FRR"))
+
+	Configure(false, true)
+	err := installAllowed("FRR")
+	require.ErrorIs(t, err, ErrInstallDisabled)
+	assert.Contains(t, err.Error(), "FRR is not installed")
+}
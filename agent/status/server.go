@@ -0,0 +1,215 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gluon-agent/client"
+)
+
+// DefaultSocketPath is where the agent listens for local status requests.
+const DefaultSocketPath = "/run/gluon/agent.sock"
+
+// syncTimeout bounds how long `gluon-agent sync --now` waits; a sync may
+// apply config and join Kubernetes.
+const syncTimeout = 10 * time.Minute
+
+// planTimeout bounds `gluon-agent plan`, which only fetches the bundle and
+// compares it with the files on disk.
+const planTimeout = time.Minute
+
+// ErrSyncUnavailable is returned by a SyncFunc or PlanFunc before the agent can sync,
+// e.g. while it waits for enrollment approval.
+var ErrSyncUnavailable = errors.New("config sync is not running yet")
+
+// SyncFunc runs a config sync and returns its result.
+type SyncFunc func(ctx context.Context) error
+
+// PlanFunc fetches the current config bundle and returns what applying it
+// would change, without applying it.
+type PlanFunc func(ctx context.Context) (*client.ConfigPlan, error)
+
+// Handlers are the agent operations the socket exposes besides status.
+type Handlers struct {
+	Sync SyncFunc
+	Plan PlanFunc
+}
+
+// Serve answers local status requests on the Unix socket at path until ctx
+// ends. Only root can connect.
+func Serve(ctx context.Context, path string, h Handlers) error {
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: handler(h)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Local status API listening on %s", path)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	_ = os.Remove(path)
+	return nil
+}
+
+// listenPrivate binds the socket inside a fresh 0700 directory, restricts it
+// to 0600 and only then moves it to path, so nobody else can connect in the
+// window between Listen and Chmod.
+func listenPrivate(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	private, err := os.MkdirTemp(dir, ".agent-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(private)
+
+	bound := filepath.Join(private, filepath.Base(path))
+	ln, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is renamed below; Serve removes it at path itself.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(bound, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	// Rename replaces a socket left behind by a previous run.
+	if err := os.Rename(bound, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func handler(h Handlers) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Collect())
+	})
+	mux.HandleFunc("POST /v1/sync", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), syncTimeout)
+		defer cancel()
+		err := h.Sync(ctx)
+		switch {
+		case errors.Is(err, ErrSyncUnavailable):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"message": "config sync completed"})
+		}
+	})
+	mux.HandleFunc("POST /v1/plan", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), planTimeout)
+		defer cancel()
+		plan, err := h.Plan(ctx)
+		switch {
+		case errors.Is(err, ErrSyncUnavailable):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, plan)
+		}
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func socketClient(path string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// Fetch asks the agent listening on path for its status.
+func Fetch(path string) (*Report, error) {
+	resp, err := socketClient(path, 30*time.Second).Get("http://agent/v1/status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("failed to decode status: %w", err)
+	}
+	return &r, nil
+}
+
+// RequestSync asks the agent listening on path to sync its config now and
+// waits for the result.
+func RequestSync(path string) error {
+	resp, err := socketClient(path, syncTimeout+30*time.Second).Post("http://agent/v1/sync", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach agent at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// RequestPlan asks the agent listening on path what applying its current
+// config bundle would change.
+func RequestPlan(path string) (*client.ConfigPlan, error) {
+	resp, err := socketClient(path, planTimeout+30*time.Second).Post("http://agent/v1/plan", "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	var plan client.ConfigPlan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %w", err)
+	}
+	return &plan, nil
+}
+
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		return errors.New(payload.Error)
+	}
+	return fmt.Errorf("agent returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,226 @@
+// Package status keeps track of what the agent is doing and serves it on a
+// local Unix socket for `gluon-agent status`.
+package status
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gluon-agent/applier"
+	"gluon-agent/client"
+	"gluon-agent/kubernetes"
+)
+
+// Enrollment states.
+const (
+	EnrollmentUnenrolled = "unenrolled"
+	EnrollmentPending    = "pending"
+	EnrollmentEnrolled   = "enrolled"
+)
+
+// Enrollment is where the agent is in enrolling with the API.
+type Enrollment struct {
+	State     string `json:"state"`
+	NodeID    string `json:"node_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Attempt is the outcome of the latest heartbeat or config sync.
+type Attempt struct {
+	At    *time.Time `json:"at,omitempty"`
+	OK    bool       `json:"ok"`
+	Error string     `json:"error,omitempty"`
+}
+
+// AppliedConfig is the config bundle last applied, from the applier state.
+type AppliedConfig struct {
+	Version int    `json:"version"`
+	Hash    string `json:"hash,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is everything `gluon-agent status` shows.
+type Report struct {
+	AgentVersion  string                   `json:"agent_version"`
+	Hostname      string                   `json:"hostname"`
+	Enrollment    Enrollment               `json:"enrollment"`
+	Heartbeat     Attempt                  `json:"heartbeat"`
+	ConfigSync    Attempt                  `json:"config_sync"`
+	AppliedConfig AppliedConfig            `json:"applied_config"`
+	Interfaces    []client.InterfaceStatus `json:"interfaces"`
+	Kubernetes    kubernetes.LocalStatus   `json:"kubernetes"`
+	Commands      []client.PendingCommand  `json:"commands"`
+}
+
+var (
+	mu         sync.Mutex
+	hostname   string
+	enrollment = Enrollment{State: EnrollmentUnenrolled}
+	heartbeat  Attempt
+	configSync Attempt
+)
+
+// SetHostname records the hostname the agent enrolls with.
+func SetHostname(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	hostname = name
+}
+
+// SetEnrollment records the enrollment state.
+func SetEnrollment(e Enrollment) {
+	mu.Lock()
+	defer mu.Unlock()
+	enrollment = e
+}
+
+// RecordHeartbeat records the result of a heartbeat.
+func RecordHeartbeat(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	heartbeat = newAttempt(err)
+}
+
+// RecordConfigSync records the result of a config sync.
+func RecordConfigSync(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	configSync = newAttempt(err)
+}
+
+func newAttempt(err error) Attempt {
+	now := time.Now()
+	a := Attempt{At: &now, OK: err == nil}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+// Collect builds a report, reading live interface and cluster state.
+func Collect() Report {
+	mu.Lock()
+	r := Report{
+		AgentVersion: client.AgentVersion,
+		Hostname:     hostname,
+		Enrollment:   enrollment,
+		Heartbeat:    heartbeat,
+		ConfigSync:   configSync,
+	}
+	mu.Unlock()
+
+	if state, err := applier.LoadState(); err != nil {
+		r.AppliedConfig.Error = err.Error()
+	} else {
+		r.AppliedConfig.Version, r.AppliedConfig.Hash = state.Version, state.Hash
+	}
+	r.Interfaces = client.LocalInterfaces()
+	r.Kubernetes = kubernetes.Status()
+	r.Commands = client.PendingCommands()
+	return r
+}
+
+// WriteText renders the report for a terminal.
+func (r *Report) WriteText(w io.Writer) {
+	now := time.Now()
+	fmt.Fprintf(w, "Agent:       %s (%s)\n", r.Hostname, r.AgentVersion)
+
+	enroll := r.Enrollment.State
+	switch {
+	case r.Enrollment.NodeID != "":
+		enroll += " (node " + r.Enrollment.NodeID + ")"
+	case r.Enrollment.RequestID != "":
+		enroll += " (request " + r.Enrollment.RequestID + ")"
+	}
+	fmt.Fprintf(w, "Enrollment:  %s\n", enroll)
+	fmt.Fprintf(w, "Heartbeat:   %s\n", r.Heartbeat.describe(now))
+	fmt.Fprintf(w, "Config sync: %s\n", r.ConfigSync.describe(now))
+
+	applied := fmt.Sprintf("version %d", r.AppliedConfig.Version)
+	if r.AppliedConfig.Hash != "" {
+		applied += ", hash " + shortHash(r.AppliedConfig.Hash)
+	}
+	if r.AppliedConfig.Error != "" {
+		applied = "unknown: " + r.AppliedConfig.Error
+	}
+	fmt.Fprintf(w, "Applied:     %s\n", applied)
+
+	k := r.Kubernetes
+	var kube []string
+	switch {
+	case k.ControlPlane:
+		kube = append(kube, "control plane")
+	case k.Joined:
+		kube = append(kube, "worker")
+	default:
+		kube = append(kube, "not joined")
+	}
+	if k.LastSync != nil {
+		action := k.LastAction
+		if action == "" {
+			action = "none"
+		}
+		kube = append(kube, fmt.Sprintf("last sync %s ago (action %s)", since(now, *k.LastSync), action))
+	}
+	if k.LastError != "" {
+		kube = append(kube, "error: "+k.LastError)
+	}
+	fmt.Fprintf(w, "Kubernetes:  %s\n", strings.Join(kube, ", "))
+
+	fmt.Fprintln(w)
+	if len(r.Interfaces) == 0 {
+		fmt.Fprintln(w, "Interfaces:  none")
+	} else {
+		fmt.Fprintln(w, "Interfaces:")
+		for _, i := range r.Interfaces {
+			line := fmt.Sprintf("  %-12s", i.Name)
+			if i.Peers > 0 {
+				line += fmt.Sprintf(" wg %d/%d peers up", i.PeersUp, i.Peers)
+				if i.LatestHandshake != nil {
+					line += fmt.Sprintf(", handshake %s ago", since(now, *i.LatestHandshake))
+				}
+			}
+			for _, n := range i.OSPFNeighbors {
+				line += fmt.Sprintf(" ospf %s %s", n.RouterID, n.State)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if len(r.Commands) == 0 {
+		fmt.Fprintln(w, "Commands:    none pending")
+		return
+	}
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range r.Commands {
+		fmt.Fprintf(w, "  #%-6d %-24s %s for %s\n", c.ID, c.Kind, c.State, since(now, c.Since))
+	}
+}
+
+func (a Attempt) describe(now time.Time) string {
+	if a.At == nil {
+		return "never"
+	}
+	if !a.OK {
+		return fmt.Sprintf("failed %s ago: %s", since(now, *a.At), a.Error)
+	}
+	return fmt.Sprintf("ok %s ago", since(now, *a.At))
+}
+
+func since(now, t time.Time) time.Duration {
+	d := now.Sub(t).Round(time.Second)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
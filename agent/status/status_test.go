@@ -0,0 +1,116 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gluon-agent/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	now := time.Now()
+	hs := now.Add(-30 * time.Second)
+	r := Report{
+		AgentVersion:  "1.2.3",
+		Hostname:      "node-1",
+		Enrollment:    Enrollment{State: EnrollmentEnrolled, NodeID: "7"},
+		Heartbeat:     Attempt{At: &now, OK: true},
+		ConfigSync:    Attempt{At: &now, Error: "failed to get config: boom"},
+		AppliedConfig: AppliedConfig{Version: 12, Hash: "0123456789abcdef"},
+		Interfaces: []client.InterfaceStatus{{
+			Name: "wg0", Peers: 2, PeersUp: 1, LatestHandshake: &hs,
+			OSPFNeighbors: []client.OSPFNeighborStatus{{RouterID: "10.0.0.2", State: "Full"}},
+		}},
+		Commands: []client.PendingCommand{{ID: 4, Kind: "run_diagnostic", State: "running", Since: now}},
+	}
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "Agent:       node-1 (1.2.3)")
+	assert.Contains(t, out, "Enrollment:  enrolled (node 7)")
+	assert.Contains(t, out, "Heartbeat:   ok 0s ago")
+	assert.Contains(t, out, "Config sync: failed 0s ago: failed to get config: boom")
+	assert.Contains(t, out, "Applied:     version 12, hash 0123456789ab")
+	assert.Contains(t, out, "Kubernetes:  not joined")
+	assert.Contains(t, out, "wg 1/2 peers up, handshake 30s ago ospf 10.0.0.2 Full")
+	assert.Contains(t, out, "#4      run_diagnostic           running for 0s")
+}
+
+func TestWriteTextNeverAttempted(t *testing.T) {
+	r := Report{Enrollment: Enrollment{State: EnrollmentPending, RequestID: "3"}}
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "Enrollment:  pending (request 3)")
+	assert.Contains(t, out, "Heartbeat:   never")
+	assert.Contains(t, out, "Interfaces:  none")
+	assert.Contains(t, out, "Commands:    none pending")
+}
+
+func TestServeStatusAndSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	var syncErr error
+	syncs := 0
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, path, Handlers{
+			Sync: func(context.Context) error {
+				syncs++
+				return syncErr
+			},
+			Plan: func(context.Context) (*client.ConfigPlan, error) {
+				return &client.ConfigPlan{Version: 4, Actions: []client.PlannedAction{
+					{Action: "wg_syncconf", Target: "wg-1"},
+				}}, nil
+			},
+		})
+	}()
+
+	SetHostname("node-1")
+	SetEnrollment(Enrollment{State: EnrollmentEnrolled, NodeID: "7"})
+	RecordHeartbeat(errors.New("unauthorized"))
+
+	var report *Report
+	require.Eventually(t, func() bool {
+		var err error
+		report, err = Fetch(path)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0600, info.Mode())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "private bind directory removed")
+	assert.Equal(t, "node-1", report.Hostname)
+	assert.Equal(t, "7", report.Enrollment.NodeID)
+	assert.False(t, report.Heartbeat.OK)
+	assert.Equal(t, "unauthorized", report.Heartbeat.Error)
+
+	require.NoError(t, RequestSync(path))
+	syncErr = errors.New("failed to apply config: boom")
+	assert.EqualError(t, RequestSync(path), "failed to apply config: boom")
+	syncErr = ErrSyncUnavailable
+	assert.EqualError(t, RequestSync(path), ErrSyncUnavailable.Error())
+	assert.Equal(t, 3, syncs)
+
+	plan, err := RequestPlan(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, plan.Version)
+	assert.Equal(t, "wg-1", plan.Actions[0].Target)
+
+	cancel()
+	require.NoError(t, <-served)
+}
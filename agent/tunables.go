@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gluon-agent/applier"
+	"gluon-agent/config"
+	"gluon-agent/keys"
+	"gluon-agent/kubernetes"
+	"gluon-agent/logging"
+	"gluon-agent/pkgmgr"
+	"gluon-agent/status"
+)
+
+// tunables holds the agent.conf settings in effect; SIGHUP replaces them.
+var tunables atomic.Pointer[config.Tunables]
+
+// The heartbeat and config sync loops are woken after a reload so new
+// intervals apply at once rather than after the old one runs out.
+var (
+	heartbeatWake  = make(chan struct{}, 1)
+	configSyncWake = make(chan struct{}, 1)
+)
+
+func currentTunables() *config.Tunables {
+	return tunables.Load()
+}
+
+// heartbeatInterval falls back to GLUON_HEARTBEAT_INTERVAL_SECONDS, which
+// predates heartbeat_interval_seconds in agent.conf.
+func heartbeatInterval() time.Duration {
+	t := currentTunables()
+	if t.HeartbeatIntervalSeconds == 0 {
+		if n, err := strconv.Atoi(os.Getenv("GLUON_HEARTBEAT_INTERVAL_SECONDS")); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return t.HeartbeatInterval()
+}
+
+// statusSocketPath is where the agent serves, and the CLI looks for, the
+// local status API. It falls back to GLUON_STATUS_SOCKET, which predates
+// status_socket in agent.conf.
+func statusSocketPath(t *config.Tunables) string {
+	if t != nil && t.StatusSocket != "" {
+		return t.StatusSocket
+	}
+	return getEnvOrDefault("GLUON_STATUS_SOCKET", status.DefaultSocketPath)
+}
+
+// pathTargets are the package paths the path tunables move, by key.
+// status_socket is read through statusSocketPath instead.
+var pathTargets = map[string]*string{
+	"state_file":            &applier.StateFilePath,
+	"keys_dir":              &keys.KeysDir,
+	"key_upload_state_file": &keys.UploadStatePath,
+}
+
+// applyTunables puts t into effect. Paths are only applied at startup.
+func applyTunables(t *config.Tunables) {
+	logging.SetLevel(t.Level())
+	pkgmgr.Configure(t.PackagesEnabled(), t.KubernetesEnabled())
+	kubernetes.SetManaged(t.KubernetesEnabled())
+	applier.SetManageSSH(t.SSHEnabled())
+
+	old := tunables.Swap(t)
+	if old == nil {
+		for _, p := range t.Paths() {
+			if target := pathTargets[p.Key]; target != nil && p.Value != "" {
+				*target = p.Value
+			}
+		}
+		return
+	}
+	oldPaths := old.Paths()
+	for i, p := range t.Paths() {
+		if p.Value != oldPaths[i].Value {
+			log.Printf("%s changed; it takes effect after a restart", p.Key)
+		}
+	}
+	for _, ch := range []chan struct{}{heartbeatWake, configSyncWake} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// reloadOnSIGHUP re-reads the tunables from configPath on every SIGHUP. A
+// file that fails validation is ignored and the current settings stay.
+func reloadOnSIGHUP(ctx context.Context, configPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				t, err := config.LoadTunables(configPath)
+				if err != nil {
+					log.Printf("Ignoring reload, keeping current settings: %v", err)
+					continue
+				}
+				applyTunables(t)
+				log.Printf("Reloaded %s: heartbeat=%s config_sync=%s log_level=%s kubernetes=%t ssh=%t packages=%t dry_run=%t",
+					configPath, heartbeatInterval(), t.ConfigSyncInterval(), t.Level(),
+					t.KubernetesEnabled(), t.SSHEnabled(), t.PackagesEnabled(), t.DryRun)
+			}
+		}
+	}()
+}
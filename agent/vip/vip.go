@@ -0,0 +1,292 @@
+package vip
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gluon-agent/client"
+)
+
+// Interface is where service VIPs are placed. Anything on the dummy interface
+// is already part of OSPF, so adding/removing the /32 is what (un)announces it.
+const Interface = "dummy"
+
+// AddressLabel marks VIP addresses so they can be told apart from the node loopback.
+const AddressLabel = Interface + ":vip"
+
+type entry struct {
+	spec      client.ServiceVIP
+	state     checkState
+	applied   bool
+	announced bool
+	message   string
+	nextCheck time.Time
+}
+
+// Manager runs the health checks for the service VIPs assigned to this node
+// and keeps the announced set in sync with the check results.
+type Manager struct {
+	mu      sync.Mutex
+	entries map[uint]*entry
+}
+
+var Default = NewManager()
+
+func NewManager() *Manager {
+	return &Manager{entries: make(map[uint]*entry)}
+}
+
+// Update replaces the desired VIP set with the one from the config bundle.
+// VIPs that are no longer assigned (or whose address changed) are withdrawn.
+func (m *Manager) Update(specs []client.ServiceVIP) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[uint]client.ServiceVIP, len(specs))
+	for _, s := range specs {
+		if s.ID == 0 || strings.TrimSpace(s.Address) == "" {
+			continue
+		}
+		desired[s.ID] = s
+	}
+
+	for id, e := range m.entries {
+		s, ok := desired[id]
+		if ok && s.Address == e.spec.Address {
+			continue
+		}
+		if e.announced || !e.applied {
+			if err := withdrawAddress(e.spec.Address); err != nil {
+				log.Printf("Service VIP %s: failed to withdraw %s: %v", e.spec.Name, e.spec.Address, err)
+			}
+		}
+		delete(m.entries, id)
+	}
+
+	for id, s := range desired {
+		if e, ok := m.entries[id]; ok {
+			e.spec = s
+			continue
+		}
+		log.Printf("Service VIP %s (%s): tracking %s check %q", s.Name, s.Address, s.CheckType, s.CheckTarget)
+		m.entries[id] = &entry{spec: s, message: "pending first check"}
+	}
+}
+
+// Statuses returns the current health of every tracked VIP for the heartbeat.
+func (m *Manager) Statuses() []client.ServiceVIPStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]client.ServiceVIPStatus, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, client.ServiceVIPStatus{
+			ID:        e.spec.ID,
+			Address:   e.spec.Address,
+			Healthy:   e.state.healthy,
+			Announced: e.announced,
+			Message:   e.message,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Run drives the health checks until ctx is cancelled. On shutdown every
+// announced VIP is withdrawn so traffic fails over to the remaining nodes.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.withdrawAll()
+			return
+		case <-ticker.C:
+			m.checkDue(ctx)
+		}
+	}
+}
+
+func (m *Manager) checkDue(ctx context.Context) {
+	now := time.Now()
+
+	m.mu.Lock()
+	due := make([]client.ServiceVIP, 0)
+	for _, e := range m.entries {
+		if now.Before(e.nextCheck) {
+			continue
+		}
+		e.nextCheck = now.Add(intervalOf(e.spec))
+		due = append(due, e.spec)
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, spec := range due {
+		wg.Add(1)
+		go func(spec client.ServiceVIP) {
+			defer wg.Done()
+			err := runCheck(ctx, spec)
+			m.record(spec, err)
+		}(spec)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) record(spec client.ServiceVIP, checkErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[spec.ID]
+	if !ok || e.spec.Address != spec.Address {
+		return
+	}
+
+	changed := e.state.observe(checkErr == nil, spec.Rise, spec.Fall)
+	if checkErr != nil {
+		e.message = checkErr.Error()
+	} else {
+		e.message = "ok"
+	}
+	if changed {
+		log.Printf("Service VIP %s (%s): healthy=%t (%s)", spec.Name, spec.Address, e.state.healthy, e.message)
+	}
+
+	// Re-assert on every check: ifup/ifdown during config applies recreates
+	// the dummy interface and drops any address we added earlier.
+	if e.state.healthy {
+		if err := announceAddress(spec.Address); err != nil {
+			e.message = fmt.Sprintf("announce failed: %v", err)
+			e.announced = false
+			return
+		}
+		e.announced = true
+	} else if e.announced || !e.applied {
+		if err := withdrawAddress(spec.Address); err != nil {
+			e.message = fmt.Sprintf("withdraw failed: %v", err)
+			return
+		}
+		e.announced = false
+	}
+	e.applied = true
+}
+
+func (m *Manager) withdrawAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if !e.announced {
+			continue
+		}
+		if err := withdrawAddress(e.spec.Address); err != nil {
+			log.Printf("Service VIP %s: failed to withdraw %s: %v", e.spec.Name, e.spec.Address, err)
+			continue
+		}
+		e.announced = false
+	}
+}
+
+// checkState implements rise/fall hysteresis so a single flapping check does
+// not move traffic around the fabric.
+type checkState struct {
+	healthy   bool
+	successes int
+	failures  int
+}
+
+func (s *checkState) observe(ok bool, rise int, fall int) bool {
+	if rise < 1 {
+		rise = 1
+	}
+	if fall < 1 {
+		fall = 1
+	}
+
+	if ok {
+		s.failures = 0
+		s.successes++
+		if !s.healthy && s.successes >= rise {
+			s.healthy = true
+			return true
+		}
+		return false
+	}
+
+	s.successes = 0
+	s.failures++
+	if s.healthy && s.failures >= fall {
+		s.healthy = false
+		return true
+	}
+	return false
+}
+
+func intervalOf(spec client.ServiceVIP) time.Duration {
+	if spec.IntervalSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(spec.IntervalSeconds) * time.Second
+}
+
+func timeoutOf(spec client.ServiceVIP) time.Duration {
+	if spec.TimeoutSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(spec.TimeoutSeconds) * time.Second
+}
+
+func runCheck(ctx context.Context, spec client.ServiceVIP) error {
+	timeout := timeoutOf(spec)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch strings.ToLower(strings.TrimSpace(spec.CheckType)) {
+	case "tcp":
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.DialContext(ctx, "tcp", spec.CheckTarget)
+		if err != nil {
+			return fmt.Errorf("tcp %s: %w", spec.CheckTarget, err)
+		}
+		_ = conn.Close()
+		return nil
+	case "http":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec.CheckTarget, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := (&http.Client{Timeout: timeout}).Do(req)
+		if err != nil {
+			return fmt.Errorf("http %s: %w", spec.CheckTarget, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("http %s: %s", spec.CheckTarget, resp.Status)
+		}
+		return nil
+	case "exec":
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", spec.CheckTarget).CombinedOutput()
+		if err != nil {
+			msg := strings.TrimSpace(string(out))
+			if len(msg) > 200 {
+				msg = msg[:200]
+			}
+			if msg != "" {
+				return fmt.Errorf("exec: %w: %s", err, msg)
+			}
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown check type %q", spec.CheckType)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package vip
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func announceAddress(address string) error {
+	out, err := exec.Command("ip", "addr", "replace", address+"/32", "dev", Interface, "label", AddressLabel).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func withdrawAddress(address string) error {
+	out, err := exec.Command("ip", "addr", "del", address+"/32", "dev", Interface).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		// Already gone (or the dummy interface itself is down): nothing to withdraw.
+		if strings.Contains(msg, "Cannot assign requested address") || strings.Contains(msg, "Cannot find device") {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil
+}
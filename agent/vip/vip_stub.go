@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package vip
+
+func announceAddress(_ string) error {
+	return nil
+}
+
+func withdrawAddress(_ string) error {
+	return nil
+}
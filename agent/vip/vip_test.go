@@ -0,0 +1,62 @@
+package vip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckStateObserve(t *testing.T) {
+	tests := []struct {
+		name        string
+		rise        int
+		fall        int
+		results     []bool
+		wantHealthy []bool
+		wantChanged []bool
+	}{
+		{
+			name:        "needs rise consecutive successes to become healthy",
+			rise:        2,
+			fall:        2,
+			results:     []bool{true, true, true},
+			wantHealthy: []bool{false, true, true},
+			wantChanged: []bool{false, true, false},
+		},
+		{
+			name:        "a single failure does not withdraw with fall=2",
+			rise:        1,
+			fall:        2,
+			results:     []bool{true, false, true, false, false},
+			wantHealthy: []bool{true, true, true, true, false},
+			wantChanged: []bool{true, false, false, false, true},
+		},
+		{
+			name:        "interrupted successes reset the rise counter",
+			rise:        3,
+			fall:        1,
+			results:     []bool{true, true, false, true, true, true},
+			wantHealthy: []bool{false, false, false, false, false, true},
+			wantChanged: []bool{false, false, false, false, false, true},
+		},
+		{
+			name:        "zero thresholds behave like 1",
+			rise:        0,
+			fall:        0,
+			results:     []bool{true, false},
+			wantHealthy: []bool{true, false},
+			wantChanged: []bool{true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s checkState
+			for i, ok := range tt.results {
+				changed := s.observe(ok, tt.rise, tt.fall)
+				assert.Equal(t, tt.wantChanged[i], changed, "step %d changed", i)
+				assert.Equal(t, tt.wantHealthy[i], s.healthy, "step %d healthy", i)
+			}
+		})
+	}
+}
@@ -17,12 +17,25 @@ type Settings struct {
 	Hub3WorkerCIDR         string
 	KubernetesPodCIDR      string
 	KubernetesServiceCIDR  string
+	// CNI for clusters created from now on: flannel, calico or cilium.
+	KubernetesCNI          string
+	ServiceVIPCIDR         string
+	WorkerShortcutCIDR     string
 	OSPFArea               int
 	OSPFHelloInterval      int
 	OSPFDeadInterval       int
 	OSPFHubToHubCost       int
 	OSPFHubToWorkerCost    int
 	OSPFWorkerToHubCost    int
+	OSPFWorkerShortcutCost int
+	// OSPFWorkerShortcutHubCost replaces OSPFWorkerToHubCost on the hub
+	// links of a worker that has shortcuts, so the shortcut wins clearly.
+	OSPFWorkerShortcutHubCost int
+	// Worker shortcut tuning. A threshold of 0 disables automatic shortcuts;
+	// admin-pinned pairs are still honoured.
+	WorkerShortcutThresholdBytesPerSec int
+	WorkerShortcutIdleMinutes          int
+	WorkerShortcutMaxPerWorker         int
 	// TLS settings
 	TLSEnabled   bool
 	TLSCertPath  string
@@ -32,6 +45,58 @@ type Settings struct {
 	TLSHosts     []string // Hostnames/IPs for server certificate
 
 	AgentBinaryPath string
+
+	// UDP port of the endpoint echo agents use to learn their public address.
+	// 0 disables it.
+	EndpointEchoPort int
+
+	// WireGuard key rotation. Rotations not confirmed by handshakes within
+	// the timeout are rolled back; an interval of 0 days disables scheduled
+	// rotation.
+	WireGuardKeyRotationDays           int
+	WireGuardKeyRotationTimeoutMinutes int
+
+	// Where uploaded Kubernetes application bundles are kept, how large
+	// one may be unpacked (MiB), and how many revisions each application
+	// keeps.
+	ApplicationsDir              string
+	ApplicationBundleMaxMB       int
+	ApplicationRevisionRetention int
+
+	// Ranges further clusters' pod and service CIDRs are carved from, one
+	// /16 each. The default cluster keeps KubernetesPodCIDR and
+	// KubernetesServiceCIDR.
+	KubernetesPodSupernet     string
+	KubernetesServiceSupernet string
+	// Admin kubeconfigs uploaded by the bootstrap hubs of clusters other
+	// than the one GLUON_KUBECONFIG points at.
+	KubeconfigsDir string
+
+	// etcd snapshots taken by the bootstrap hub. An interval of 0 minutes
+	// disables scheduled snapshots; on-demand ones still work.
+	EtcdSnapshotsDir            string
+	EtcdSnapshotIntervalMinutes int
+	EtcdSnapshotRetention       int
+	EtcdSnapshotMaxMB           int
+
+	// SSH user CA. Certificates are valid for the default TTL unless the
+	// admin asks for another, never longer than the max; the login users
+	// are the accounts certificates may log in as.
+	SSHUserCAKeyPath      string
+	SSHCertDefaultMinutes int
+	SSHCertMaxMinutes     int
+	SSHCertLoginUsers     []string
+
+	// Largest request body accepted, in MiB. Snapshot and bundle uploads
+	// have their own limits.
+	BodyLimitMB int
+
+	// Accounts that may grant or revoke any elevated permission without
+	// holding it; the first holder of a permission has to come from here.
+	PermissionBootstrapEmails []string
+
+	// Browser origins besides the API's own that may open pod exec sessions.
+	ExecAllowedOrigins []string
 }
 
 type Overrides struct {
@@ -65,12 +130,20 @@ func Load() error {
 		Hub3WorkerCIDR:        envOrDefault("GLUON_HUB3_WORKER_CIDR", "10.255.16.0/22"),
 		KubernetesPodCIDR:     envOrDefault("GLUON_K8S_POD_CIDR", "10.244.0.0/16"),
 		KubernetesServiceCIDR: envOrDefault("GLUON_K8S_SERVICE_CIDR", "10.96.0.0/16"),
+		KubernetesCNI:         strings.ToLower(envOrDefault("GLUON_K8S_CNI", "flannel")),
+		ServiceVIPCIDR:        envOrDefault("GLUON_SERVICE_VIP_CIDR", "10.255.20.0/24"),
+		WorkerShortcutCIDR:    envOrDefault("GLUON_WORKER_SHORTCUT_CIDR", "10.255.24.0/22"),
 		OSPFArea:              envIntOrDefault("GLUON_OSPF_AREA", 10),
 		OSPFHelloInterval:     envIntOrDefault("GLUON_OSPF_HELLO_INTERVAL", 1),
 		OSPFDeadInterval:      envIntOrDefault("GLUON_OSPF_DEAD_INTERVAL", 3),
 		OSPFHubToHubCost:      envIntOrDefault("GLUON_OSPF_HUB_TO_HUB_COST", 10),
 		OSPFHubToWorkerCost:   envIntOrDefault("GLUON_OSPF_HUB_TO_WORKER_COST", 100),
 		OSPFWorkerToHubCost:   envIntOrDefault("GLUON_OSPF_WORKER_TO_HUB_COST", 10),
+		OSPFWorkerShortcutCost: envIntOrDefault("GLUON_OSPF_WORKER_SHORTCUT_COST", 5),
+		OSPFWorkerShortcutHubCost: envIntOrDefault("GLUON_OSPF_WORKER_SHORTCUT_HUB_COST", 50),
+		WorkerShortcutThresholdBytesPerSec: envIntOrDefault("GLUON_WORKER_SHORTCUT_THRESHOLD_BPS", 1<<20),
+		WorkerShortcutIdleMinutes:          envIntOrDefault("GLUON_WORKER_SHORTCUT_IDLE_MINUTES", 30),
+		WorkerShortcutMaxPerWorker:         envIntOrDefault("GLUON_WORKER_SHORTCUT_MAX_PER_WORKER", 4),
 		// TLS settings
 		TLSEnabled:  envBoolOrDefault("GLUON_TLS_ENABLED", true),
 		TLSCertPath: envOrDefault("GLUON_TLS_CERT_PATH", "/var/lib/gluon/certs/server.crt"),
@@ -79,6 +152,26 @@ func Load() error {
 		CAKeyPath:   envOrDefault("GLUON_CA_KEY_PATH", "/var/lib/gluon/certs/ca.key"),
 		TLSHosts:        envListOrDefault("GLUON_TLS_HOSTS", []string{"localhost", "127.0.0.1"}),
 		AgentBinaryPath: envOrDefault("GLUON_AGENT_BINARY_PATH", "/var/lib/gluon/gluon-agent"),
+		EndpointEchoPort: envIntOrDefault("GLUON_ENDPOINT_ECHO_PORT", 3478),
+		WireGuardKeyRotationDays:           envIntOrDefault("GLUON_WG_KEY_ROTATION_DAYS", 0),
+		WireGuardKeyRotationTimeoutMinutes: envIntOrDefault("GLUON_WG_KEY_ROTATION_TIMEOUT_MINUTES", 15),
+		ApplicationsDir:                    envOrDefault("GLUON_APPLICATIONS_DIR", "/var/lib/gluon/applications"),
+		ApplicationBundleMaxMB:             envIntOrDefault("GLUON_APPLICATION_BUNDLE_MAX_MB", 64),
+		ApplicationRevisionRetention:       envIntOrDefault("GLUON_APPLICATION_REVISION_RETENTION", 50),
+		KubernetesPodSupernet:              envOrDefault("GLUON_K8S_POD_SUPERNET", "10.240.0.0/12"),
+		KubernetesServiceSupernet:          envOrDefault("GLUON_K8S_SERVICE_SUPERNET", "10.96.0.0/12"),
+		KubeconfigsDir:                     envOrDefault("GLUON_KUBECONFIGS_DIR", "/var/lib/gluon/kubeconfigs"),
+		EtcdSnapshotsDir:                   envOrDefault("GLUON_ETCD_SNAPSHOTS_DIR", "/var/lib/gluon/etcd-snapshots"),
+		EtcdSnapshotIntervalMinutes:        envIntOrDefault("GLUON_ETCD_SNAPSHOT_INTERVAL_MINUTES", 360),
+		EtcdSnapshotRetention:              envIntOrDefault("GLUON_ETCD_SNAPSHOT_RETENTION", 14),
+		EtcdSnapshotMaxMB:                  envIntOrDefault("GLUON_ETCD_SNAPSHOT_MAX_MB", 8192),
+		SSHUserCAKeyPath:                   envOrDefault("GLUON_SSH_USER_CA_KEY_PATH", "/var/lib/gluon/certs/ssh_user_ca"),
+		SSHCertDefaultMinutes:              envIntOrDefault("GLUON_SSH_CERT_DEFAULT_MINUTES", 60),
+		SSHCertMaxMinutes:                  envIntOrDefault("GLUON_SSH_CERT_MAX_MINUTES", 1440),
+		SSHCertLoginUsers:                  envListOrDefault("GLUON_SSH_CERT_LOGIN_USERS", []string{"root"}),
+		BodyLimitMB:                        envIntOrDefault("GLUON_BODY_LIMIT_MB", 16),
+		PermissionBootstrapEmails:          envListOrDefault("GLUON_PERMISSION_BOOTSTRAP_EMAILS", nil),
+		ExecAllowedOrigins:                 envListOrDefault("GLUON_EXEC_ALLOWED_ORIGINS", nil),
 	}
 
 	if cfg.SecretKey == "" {
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"encoding/hex"
+	"errors"
 	"gluon-api/database"
 	"gluon-api/logger"
 	"gluon-api/models"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func AgentStatus(c *fiber.Ctx) error {
@@ -47,6 +49,11 @@ func RequestEnrollment(c *fiber.Ctx) error {
 		})
 	}
 
+	// A bootstrap token is optional; with a valid one the request is
+	// approved on the spot.
+	bootstrapToken, _ := raw["bootstrap_token"].(string)
+	delete(raw, "bootstrap_token")
+
 	allowedFields := []string{"hostname", "provider", "os", "desired_role"}
 	if len(raw) != len(allowedFields) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
@@ -106,6 +113,10 @@ func RequestEnrollment(c *fiber.Ctx) error {
 		})
 	}
 
+	if bootstrapToken != "" {
+		return enrollWithBootstrapToken(c, &req, bootstrapToken, plainSecret)
+	}
+
 	if err := database.DB.Create(&req).Error; err != nil {
 		logger.Error("Failed to save enrollment request: ", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -161,6 +172,30 @@ func AcceptAgentEnrollment(c *fiber.Ctx) error {
 	logger.Audit(c, "Accepted enrollment request", &uid, "accept_enrollment_request", "node_enrollment_request", map[string]any{
 		"request_id": req_id,
 	})
+	node, err := createNodeFromEnrollment(database.DB, &request, &user.ID)
+	if err != nil {
+		return err
+	}
+	setupEnrolledNode(node, nil)
+
+	request.ApprovedBy = user
+	now := time.Now()
+	request.ApprovedAt = &now
+	request.ConvertedNodeID = &node.ID
+	if err := database.DB.Save(&request).Error; err != nil {
+		logger.Error("Failed to update enrollment request approver: ", "error", err)
+		return err
+	}
+
+	logger.Info("Enrollment request accepted", "request_id", req_id)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Enrollment request accepted",
+		"node_id": node.ID,
+	})
+}
+
+// createNodeFromEnrollment turns an accepted enrollment request into a node.
+func createNodeFromEnrollment(tx *gorm.DB, request *models.NodeEnrollmentRequest, enrolledByID *uint) (*models.Node, error) {
 	node := models.Node{
 		Hostname:            request.Hostname,
 		Role:                request.DesiredRole,
@@ -168,33 +203,102 @@ func AcceptAgentEnrollment(c *fiber.Ctx) error {
 		Provider:            request.Provider,
 		OS:                  request.OS,
 		Status:              models.NodeStatusActive,
-		EnrolledByID:        &user.ID,
-		EnrollmentRequestID: uint(req_id),
+		EnrolledByID:        enrolledByID,
+		EnrollmentRequestID: request.ID,
 	}
-	if err := database.DB.Create(&node).Error; err != nil {
+	if err := tx.Create(&node).Error; err != nil {
 		logger.Error("Failed to create node from enrollment request: ", "error", err)
-		return err
+		return nil, err
+	}
+	return &node, nil
+}
+
+// setupEnrolledNode labels a newly enrolled node and sets up its
+// networking.
+func setupEnrolledNode(node *models.Node, labels map[string]string) {
+	if len(labels) > 0 {
+		if err := services.SetNodeLabels(node, labels); err != nil {
+			logger.Error("Failed to label enrolled node", "error", err, "node_id", node.ID)
+		}
 	}
 
-	if err := services.SetupNodeNetworking(&node); err != nil {
+	if err := services.SetupNodeNetworking(node); err != nil {
 		logger.Error("Failed to setup networking for node: ", "error", err, "node_id", node.ID)
 	} else {
 		logger.Info("Networking setup completed for node", "node_id", node.ID)
 	}
+}
 
-	request.ApprovedBy = user
-	now := time.Now()
-	request.ApprovedAt = &now
-	request.ConvertedNodeID = &node.ID
-	if err := database.DB.Save(&request).Error; err != nil {
-		logger.Error("Failed to update enrollment request approver: ", "error", err)
-		return err
+// enrollWithBootstrapToken approves req without an admin when token is
+// valid, applying the token's role, provider and labels to the new node.
+// The token use, the request and the node are written in one transaction,
+// so an enrollment that fails half way does not use up the token.
+func enrollWithBootstrapToken(c *fiber.Ctx, req *models.NodeEnrollmentRequest, plainToken string, plainSecret string) error {
+	var token *models.BootstrapToken
+	var node *models.Node
+	var redeemErr error
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		token, redeemErr = services.RedeemBootstrapToken(tx, plainToken, c.IP())
+		if redeemErr != nil {
+			return redeemErr
+		}
+
+		if token.Role != "" {
+			req.DesiredRole = token.Role
+		}
+		if token.Provider != "" {
+			req.Provider = token.Provider
+		}
+		now := time.Now()
+		req.Status = "accepted"
+		req.ApprovedAt = &now
+		req.BootstrapTokenID = &token.ID
+		if err := tx.Create(req).Error; err != nil {
+			logger.Error("Failed to save enrollment request: ", "error", err)
+			return err
+		}
+
+		var err error
+		node, err = createNodeFromEnrollment(tx, req, nil)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(req).Update("converted_node_id", node.ID).Error; err != nil {
+			logger.Error("Failed to link enrollment request to node: ", "error", err)
+			return err
+		}
+		return nil
+	})
+	if redeemErr != nil {
+		args := []any{"hostname", req.Hostname, "public_ip", req.PublicIP, "reason", redeemErr.Error()}
+		if token != nil {
+			args = append(args, "bootstrap_token_id", token.ID)
+		}
+		logger.Audit(c, "Rejected bootstrap token", nil, "redeem_bootstrap_token", "bootstrap_token", args...)
+		if errors.Is(redeemErr, services.ErrBootstrapTokenInvalid) || token != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid bootstrap token",
+			})
+		}
+		logger.Error("Failed to check bootstrap token", "error", redeemErr)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process enrollment request",
+		})
 	}
 
-	logger.Info("Enrollment request accepted", "request_id", req_id)
+	setupEnrolledNode(node, services.BootstrapTokenLabels(token))
+
+	logger.Audit(c, "Enrolled node with bootstrap token", nil, "redeem_bootstrap_token", "bootstrap_token",
+		"bootstrap_token_id", token.ID, "request_id", req.ID, "node_id", node.ID, "hostname", node.Hostname,
+		"role", node.Role, "uses", token.Uses)
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Enrollment request accepted",
-		"node_id": node.ID,
+		"message":           "Enrollment approved with bootstrap token",
+		"request_id":        req.ID,
+		"status":            req.Status,
+		"enrollment_secret": plainSecret,
 	})
 }
 
@@ -401,6 +505,12 @@ func Heartbeat(c *fiber.Ctx) error {
 			Cost                 *uint64 `json:"cost"`
 			Priority             *uint64 `json:"priority"`
 		} `json:"ospf_neighbors"`
+		ServiceVIPs     []serviceVIPReport               `json:"service_vips"`
+		LoopbackTraffic []services.LoopbackTrafficReport `json:"loopback_traffic"`
+		NAT             *struct {
+			PublicIP  string `json:"public_ip"`
+			BehindNAT bool   `json:"behind_nat"`
+		} `json:"nat"`
 	}
 
 	var input HeartbeatInput
@@ -521,6 +631,20 @@ func Heartbeat(c *fiber.Ctx) error {
 				ifaceByName[iface.Name] = iface
 			}
 
+			// Keys being rotated away from must not be written back into
+			// peer entries by the endpoint fallback below, or a far end that
+			// hasn't reapplied yet would undo the rotation.
+			rotatingKeys, err := services.RotatingKeys()
+			if err != nil {
+				logger.Error("Failed to load retiring WireGuard keys", "error", err)
+			}
+			retiring := make(map[string]bool, len(rotatingKeys))
+			for k, iface := range rotatingKeys {
+				if k == iface.PreviousPublicKey {
+					retiring[k] = true
+				}
+			}
+
 			seenIfaces := make(map[string]bool)
 			for _, p := range input.WireGuardPeers {
 				iface, ok := ifaceByName[p.Interface]
@@ -533,14 +657,23 @@ func Heartbeat(c *fiber.Ctx) error {
 				if p.LatestHandshakeUnix > 0 {
 					t := time.Unix(p.LatestHandshakeUnix, 0)
 					handshakeAt = &t
+				} else if r, ok := rotatingKeys[p.PeerPublicKey]; ok && p.PeerPublicKey == r.PublicKey {
+					// The far end is still on its old key, which is listed
+					// alongside this one; keep the last handshake on record.
+					continue
 				}
 
 				updates := map[string]any{
-					"endpoint":          p.Endpoint,
 					"last_handshake_at": handshakeAt,
 					"rx_bytes":          p.RxBytes,
 					"tx_bytes":          p.TxBytes,
 				}
+				// The endpoint `wg show` reports is where the peer really is,
+				// e.g. a NAT mapping. A peer that hasn't been heard from yet
+				// shows none, which must not clear a known endpoint.
+				if p.Endpoint != "" && p.Endpoint != "(none)" {
+					updates["endpoint"] = p.Endpoint
+				}
 
 				tx := database.DB.Model(&models.NodePeer{}).
 					Where("interface_id = ? AND peer_public_key = ?", iface.ID, p.PeerPublicKey).
@@ -549,7 +682,7 @@ func Heartbeat(c *fiber.Ctx) error {
 					logger.Error("Failed to update WG peer telemetry", "error", tx.Error, "node_id", node.ID, "interface", p.Interface)
 					continue
 				}
-				if tx.RowsAffected == 0 && p.Endpoint != "" && p.Endpoint != "(none)" {
+				if tx.RowsAffected == 0 && p.Endpoint != "" && p.Endpoint != "(none)" && !retiring[p.PeerPublicKey] {
 					fallbackUpdates := make(map[string]any, len(updates)+1)
 					for k, v := range updates {
 						fallbackUpdates[k] = v
@@ -635,6 +768,20 @@ func Heartbeat(c *fiber.Ctx) error {
 		})
 	}
 
+	// Agents leave service_vips out when they announce nothing, so a
+	// missing list still withdraws the node's assignments.
+	updateServiceVIPHealth(&node, input.ServiceVIPs)
+	if input.NAT != nil {
+		if err := services.ObserveNodeEndpoint(&node, input.NAT.PublicIP, input.NAT.BehindNAT); err != nil {
+			logger.Error("Failed to record observed endpoint", "error", err, "node_id", node.ID)
+		}
+	}
+	if len(input.LoopbackTraffic) > 0 {
+		if err := services.RecordLoopbackTraffic(&node, input.LoopbackTraffic); err != nil {
+			logger.Error("Failed to record loopback traffic", "error", err, "node_id", node.ID)
+		}
+	}
+
 	if previousStatus != models.NodeStatusActive && node.Status == models.NodeStatusActive {
 		event := models.Event{
 			Kind:    models.EventKindNodeOnline,
@@ -646,28 +793,27 @@ func Heartbeat(c *fiber.Ctx) error {
 		}
 	}
 
-	commands := []models.NodeCommand{}
+	pending := []models.NodeCommand{}
 	if err := database.DB.
 		Where("node_id = ? AND status = ?", node.ID, models.NodeCommandStatusPending).
 		Order("id asc").
 		Limit(10).
-		Find(&commands).Error; err != nil {
+		Find(&pending).Error; err != nil {
 		logger.Error("Failed to load pending node commands", "error", err, "node_id", node.ID)
-		commands = []models.NodeCommand{}
+		pending = []models.NodeCommand{}
 	}
 
-	if len(commands) > 0 {
-		now := time.Now()
-		for i := range commands {
-			cmd := commands[i]
-			_ = database.DB.Model(&models.NodeCommand{}).
-				Where("id = ? AND status = ?", cmd.ID, models.NodeCommandStatusPending).
-				Updates(map[string]any{
-					"status":     models.NodeCommandStatusRunning,
-					"started_at": &now,
-				}).Error
-			commands[i].Status = models.NodeCommandStatusRunning
-			commands[i].StartedAt = &now
+	// Only commands that moved to running are handed out; the others were
+	// cancelled or reaped since they were read.
+	commands := make([]models.NodeCommand, 0, len(pending))
+	for i := range pending {
+		started, err := services.StartNodeCommand(&pending[i], now)
+		if err != nil {
+			logger.Error("Failed to start node command", "error", err, "command_id", pending[i].ID)
+			continue
+		}
+		if started {
+			commands = append(commands, pending[i])
 		}
 	}
 
@@ -675,6 +821,7 @@ func Heartbeat(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message":  "Heartbeat received",
 		"commands": commands,
+		"cancel":   services.CommandsToCancel(node.ID),
 	})
 
 }
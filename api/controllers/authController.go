@@ -229,6 +229,7 @@ func DeleteUser(c *fiber.Ctx) error {
 			"error": "Failed to delete user",
 		})
 	}
+	database.DB.Where("user_id = ?", user.ID).Delete(&models.UserPermission{})
 
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("User %s deleted successfully", user.Email),
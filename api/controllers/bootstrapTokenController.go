@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+	"gluon-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func AdminListBootstrapTokens(c *fiber.Ctx) error {
+	var tokens []models.BootstrapToken
+	if err := database.DB.Order("id desc").Find(&tokens).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list bootstrap tokens"})
+	}
+	return c.JSON(fiber.Map{"tokens": tokens})
+}
+
+// AdminCreateBootstrapToken issues a token. The plain token is only in
+// this response; the API keeps its hash.
+func AdminCreateBootstrapToken(c *fiber.Ctx) error {
+	var input struct {
+		Description string            `json:"description"`
+		MaxUses     *int              `json:"max_uses"`
+		ExpiresAt   *time.Time        `json:"expires_at"`
+		TTLSeconds  int64             `json:"ttl_seconds"`
+		Role        string            `json:"role"`
+		Provider    string            `json:"provider"`
+		Labels      map[string]string `json:"labels"`
+		AllowedCIDR string            `json:"allowed_cidr"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	token := models.BootstrapToken{
+		Description: input.Description,
+		MaxUses:     1,
+		ExpiresAt:   input.ExpiresAt,
+		Role:        models.NodeRole(input.Role),
+		Provider:    input.Provider,
+		AllowedCIDR: input.AllowedCIDR,
+	}
+	if input.MaxUses != nil {
+		token.MaxUses = *input.MaxUses
+	}
+	if input.TTLSeconds < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ttl_seconds must be positive"})
+	}
+	if input.TTLSeconds > 0 {
+		if input.ExpiresAt != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Set expires_at or ttl_seconds, not both"})
+		}
+		expires := time.Now().Add(time.Duration(input.TTLSeconds) * time.Second)
+		token.ExpiresAt = &expires
+	}
+	if err := services.ValidateBootstrapToken(&token, input.Labels); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	plain, err := utils.GenerateBootstrapToken()
+	if err != nil {
+		logger.Error("Failed to generate bootstrap token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate bootstrap token"})
+	}
+	token.Hash, token.HashIndex, err = utils.HashBootstrapToken(plain)
+	if err != nil {
+		logger.Error("Failed to hash bootstrap token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate bootstrap token"})
+	}
+	token.Prefix = plain[:12]
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	token.CreatedByID = actorID
+	// max_uses defaults to 1 in the schema, so an unlimited token needs the
+	// zero written explicitly, before the token can be redeemed.
+	unlimited := token.MaxUses == 0
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&token).Error; err != nil {
+			return err
+		}
+		if unlimited {
+			token.MaxUses = 0
+			return tx.Model(&token).Update("max_uses", 0).Error
+		}
+		return nil
+	}); err != nil {
+		logger.Error("Failed to create bootstrap token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create bootstrap token"})
+	}
+
+	logger.Audit(c, "Created bootstrap token", actorID, "create", "BootstrapToken",
+		"bootstrap_token_id", token.ID, "prefix", token.Prefix, "max_uses", token.MaxUses, "role", token.Role, "allowed_cidr", token.AllowedCIDR)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"token": plain, "bootstrap_token": token})
+}
+
+// AdminRevokeBootstrapToken stops a token from enrolling more nodes. The
+// record stays so enrolled nodes can still be traced back to it.
+func AdminRevokeBootstrapToken(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
+	}
+	var token models.BootstrapToken
+	if err := database.DB.First(&token, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Bootstrap token not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load bootstrap token"})
+	}
+	if token.RevokedAt != nil {
+		return c.JSON(fiber.Map{"message": "already revoked"})
+	}
+	now := time.Now()
+	if err := database.DB.Model(&token).Update("revoked_at", &now).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke bootstrap token"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Revoked bootstrap token", actorID, "revoke", "BootstrapToken", "bootstrap_token_id", token.ID, "prefix", token.Prefix)
+	return c.JSON(fiber.Map{"message": "revoked"})
+}
@@ -115,3 +115,32 @@ func TestCheckCIDROverlaps(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeAdvertisedPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wantValue string
+		wantErr   bool
+	}{
+		{name: "canonical prefix passes", value: "192.168.10.0/24", wantValue: "192.168.10.0/24"},
+		{name: "host bits are masked", value: " 192.168.10.7/24 ", wantValue: "192.168.10.0/24"},
+		{name: "bare address becomes /32", value: "10.99.0.1", wantValue: "10.99.0.1/32"},
+		{name: "default route rejected", value: "0.0.0.0/0", wantErr: true},
+		{name: "ipv6 rejected", value: "fd00::/64", wantErr: true},
+		{name: "garbage rejected", value: "lan", wantErr: true},
+		{name: "empty rejected", value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeAdvertisedPrefix(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantValue, got)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AdminListCommandJobs(c *fiber.Ctx) error {
+	var jobs []models.CommandJob
+	q := database.DB.Order("id desc").Limit(100)
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if err := q.Find(&jobs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list command jobs"})
+	}
+	return c.JSON(fiber.Map{"jobs": jobs})
+}
+
+// AdminCreateCommandJob runs one command on every node matching a label
+// selector, e.g. a rolling restart of frr.service across workers.
+func AdminCreateCommandJob(c *fiber.Ctx) error {
+	var input struct {
+		Kind        string          `json:"kind"`
+		Payload     json.RawMessage `json:"payload"`
+		Selector    string          `json:"selector"`
+		Concurrency int             `json:"concurrency"`
+		MaxFailures int             `json:"max_failures"`
+
+		TimeoutSeconds int `json:"timeout_seconds"`
+		MaxAttempts    int `json:"max_attempts"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	return startCommandJob(c, services.CommandJobSpec{
+		Kind:        input.Kind,
+		Payload:     input.Payload,
+		Selector:    input.Selector,
+		Concurrency: input.Concurrency,
+		MaxFailures: input.MaxFailures,
+
+		TimeoutSeconds: input.TimeoutSeconds,
+		MaxAttempts:    input.MaxAttempts,
+	})
+}
+
+// AdminGetCommandJob returns the job with per-node status, output and
+// errors and counts per status.
+func AdminGetCommandJob(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID"})
+	}
+	summary, err := services.SummarizeCommandJob(uint(id))
+	if errors.Is(err, services.ErrCommandJobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Command job not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load command job"})
+	}
+	return c.JSON(summary)
+}
+
+// AdminCancelCommandJob stops a running job: commands not handed out yet
+// are cancelled and running ones are asked to stop.
+func AdminCancelCommandJob(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	job, err := services.CancelCommandJob(uint(id))
+	if errors.Is(err, services.ErrCommandJobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Command job not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to cancel command job"})
+	}
+	if job.Status != models.CommandJobStatusCancelled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Command job already finished", "status": job.Status})
+	}
+
+	logger.Audit(c, "Cancelled command job", actorID, "cancel", "CommandJob", "job_id", job.ID, "kind", job.Kind)
+	summary, err := services.SummarizeCommandJob(job.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load command job"})
+	}
+	return c.JSON(summary)
+}
+
+func startCommandJob(c *fiber.Ctx, spec services.CommandJobSpec) error {
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	spec.CreatedByID = actorID
+
+	job, err := services.CreateCommandJob(spec)
+	if errors.Is(err, services.ErrCommandJobNoNodes) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary, err := services.SummarizeCommandJob(job.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load command job"})
+	}
+
+	logger.Audit(c, "Started command job", actorID, "create", "CommandJob",
+		"job_id", job.ID, "kind", job.Kind, "selector", job.Selector, "nodes", summary.Total,
+		"concurrency", job.Concurrency, "max_failures", job.MaxFailures)
+	return c.Status(fiber.StatusAccepted).JSON(summary)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReportConfigPlan stores what the agent is about to change to apply a
+// config version. Agents report it before applying, or instead of applying
+// when dry_run is set in agent.conf.
+func ReportConfigPlan(c *fiber.Ctx) error {
+	nodeID := c.Locals("node_id").(uint)
+
+	var input services.ConfigPlanInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	plan, err := services.NewConfigPlan(nodeID, &input)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := services.RecordConfigPlan(plan); err != nil {
+		logger.Error("Failed to store config plan", "error", err, "node_id", nodeID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store config plan"})
+	}
+
+	logger.Info("Config plan reported by agent", "node_id", nodeID, "version", plan.Version,
+		"dry_run", plan.DryRun, "disruptive", plan.Disruptive)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": plan.ID})
+}
+
+// AdminListNodeConfigPlans lists the node's recent config plans, newest
+// first, without their diffs.
+func AdminListNodeConfigPlans(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	var plans []models.NodeConfigPlan
+	if err := database.DB.Omit("files").
+		Where("node_id = ?", nodeID).
+		Order("id desc").Find(&plans).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list config plans"})
+	}
+	return c.JSON(fiber.Map{"plans": plans})
+}
+
+// AdminGetNodeConfigPlan returns one config plan with its file diffs.
+func AdminGetNodeConfigPlan(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	planID, err := strconv.ParseUint(c.Params("planId"), 10, 64)
+	if err != nil || planID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid plan id"})
+	}
+	var plan models.NodeConfigPlan
+	if err := database.DB.Where("id = ? AND node_id = ?", planID, nodeID).First(&plan).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Config plan not found"})
+	}
+	return c.JSON(plan)
+}
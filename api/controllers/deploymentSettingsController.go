@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"fmt"
+	"gluon-api/config"
 	"gluon-api/models"
 	"gluon-api/services"
 	"net"
@@ -90,6 +91,8 @@ func AdminUpdateDeploymentSettings(c *fiber.Ctx) error {
 		"hub3_worker_cidr":       hub3WorkerCIDR,
 		"kubernetes_pod_cidr":    podCIDR,
 		"kubernetes_service_cidr": serviceCIDR,
+		"service_vip_cidr":       config.Current().ServiceVIPCIDR,
+		"worker_shortcut_cidr":   config.Current().WorkerShortcutCIDR,
 	}); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
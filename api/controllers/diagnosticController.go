@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminRunNodeDiagnostic asks the node's agent to run one of its
+// allow-listed diagnostics (wg_show, ospf_neighbors, journal, ...). The
+// output arrives with the next command report.
+func AdminRunNodeDiagnostic(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	var node models.Node
+	if err := database.DB.Select("id", "status").First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if node.Status == models.NodeStatusDecommissioned {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Node is decommissioned"})
+	}
+
+	payload, err := services.DiagnosticPayload(json.RawMessage(c.Body()))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	var policy struct {
+		TimeoutSeconds int `json:"timeout_seconds"`
+	}
+	_ = json.Unmarshal(c.Body(), &policy)
+	if err := services.ValidateCommandPolicy(policy.TimeoutSeconds, 0); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	cmd := models.NodeCommand{
+		NodeID:         node.ID,
+		Kind:           models.CmdKindRunDiagnostic,
+		Payload:        payload,
+		Status:         models.NodeCommandStatusPending,
+		TimeoutSeconds: policy.TimeoutSeconds,
+	}
+	if err := database.DB.Create(&cmd).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue diagnostic"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Requested node diagnostic", actorID, "run_diagnostic", "Node",
+		"node_id", node.ID, "command_id", cmd.ID, "payload", string(payload))
+	return c.Status(fiber.StatusAccepted).JSON(cmd)
+}
+
+func AdminListNodeDiagnostics(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	var cmds []models.NodeCommand
+	if err := database.DB.Omit("output").
+		Where("node_id = ? AND kind = ?", nodeID, models.CmdKindRunDiagnostic).
+		Order("id desc").Limit(50).Find(&cmds).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list diagnostics"})
+	}
+	return c.JSON(fiber.Map{"diagnostics": cmds})
+}
+
+// AdminGetNodeDiagnostic returns one diagnostic run with its output.
+func AdminGetNodeDiagnostic(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	commandID, err := strconv.ParseUint(c.Params("commandId"), 10, 64)
+	if err != nil || commandID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid command id"})
+	}
+	var cmd models.NodeCommand
+	if err := database.DB.
+		Where("id = ? AND node_id = ? AND kind = ?", commandID, nodeID, models.CmdKindRunDiagnostic).
+		First(&cmd).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Diagnostic not found"})
+	}
+	return c.JSON(cmd)
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetEtcdSnapshotDue tells the bootstrap hub's agent whether to take a
+// snapshot now.
+func GetEtcdSnapshotDue(c *fiber.Ctx) error {
+	nodeID := c.Locals("node_id").(uint)
+	return c.JSON(fiber.Map{"due": services.EtcdSnapshotDue(nodeID, time.Now())})
+}
+
+// UploadEtcdSnapshot receives a gzip-compressed snapshot. The checksum and
+// etcd revision come in headers since the body is the snapshot itself, which
+// is streamed to disk rather than read into memory.
+func UploadEtcdSnapshot(c *fiber.Ctx) error {
+	nodeID := c.Locals("node_id").(uint)
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if !wantsControlPlane(&node) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only control-plane hubs upload etcd snapshots"})
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	maxSize := int64(config.Current().EtcdSnapshotMaxMB) << 20
+	limited := &io.LimitedReader{R: body, N: maxSize + 1}
+
+	revision, _ := strconv.ParseInt(c.Get("X-Snapshot-Revision"), 10, 64)
+	snap, err := services.StoreEtcdSnapshot(nodeID, limited, c.Get("X-Snapshot-Sha256"), revision)
+	if limited.N <= 0 {
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "Snapshot is too large"})
+	}
+	if err != nil {
+		logger.Error("Rejected etcd snapshot upload", "error", err, "node_id", nodeID)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(snap)
+}
+
+// DownloadEtcdSnapshot serves a snapshot to the agent restoring it; nodes
+// without a restore of that snapshot queued get nothing.
+func DownloadEtcdSnapshot(c *fiber.Ctx) error {
+	nodeID := c.Locals("node_id").(uint)
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid snapshot id"})
+	}
+
+	_, snap, ok := services.EtcdRestoreTaskFor(nodeID)
+	if !ok || snap.ID != uint(id) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No restore of this snapshot is queued for this node"})
+	}
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Set("X-Snapshot-Sha256", snap.SHA256)
+	return c.SendFile(snap.Path, false)
+}
+
+func AdminListEtcdSnapshots(c *fiber.Ctx) error {
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+	var snaps []models.EtcdSnapshot
+	if err := services.KubernetesClusterScope(database.DB, cluster).Order("id desc").Find(&snaps).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve snapshots"})
+	}
+	return c.JSON(fiber.Map{"snapshots": snaps, "requested_at": cluster.EtcdSnapshotRequestedAt})
+}
+
+// AdminRequestEtcdSnapshot asks the bootstrap hub for a snapshot on its
+// next sync instead of waiting for the schedule.
+func AdminRequestEtcdSnapshot(c *fiber.Ctx) error {
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+	if cluster.InitializedAt == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Cluster is not initialized"})
+	}
+	now := time.Now()
+	if err := database.DB.Model(cluster).Update("etcd_snapshot_requested_at", &now).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to request snapshot"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Requested etcd snapshot", actorID, "request_snapshot", "EtcdSnapshot", "cluster", cluster.Name)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "snapshot requested", "requested_at": now})
+}
+
+func AdminDownloadEtcdSnapshot(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid snapshot id"})
+	}
+	var snap models.EtcdSnapshot
+	if err := database.DB.First(&snap, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Downloaded etcd snapshot", actorID, "download", "EtcdSnapshot", "snapshot_id", snap.ID)
+	c.Set("X-Snapshot-Sha256", snap.SHA256)
+	return c.Download(snap.Path, filepath.Base(snap.Path))
+}
+
+func AdminDeleteEtcdSnapshot(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid snapshot id"})
+	}
+	var snap models.EtcdSnapshot
+	if err := database.DB.First(&snap, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+
+	var inUse int64
+	database.DB.Model(&models.EtcdRestore{}).
+		Where("snapshot_id = ? AND status IN ?", snap.ID, []string{models.EtcdRestorePending, models.EtcdRestoreRunning}).
+		Count(&inUse)
+	if inUse > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Snapshot is being restored"})
+	}
+
+	if err := services.DeleteEtcdSnapshot(&snap); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete snapshot"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Deleted etcd snapshot", actorID, "delete", "EtcdSnapshot", "snapshot_id", snap.ID)
+	return c.JSON(fiber.Map{"message": "Snapshot deleted"})
+}
+
+// AdminRestoreEtcdSnapshot queues a restore of a snapshot on a hub. It is
+// destructive: the hub's etcd is replaced by a single member holding the
+// snapshot, so the request has to be confirmed explicitly.
+func AdminRestoreEtcdSnapshot(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid snapshot id"})
+	}
+	var snap models.EtcdSnapshot
+	if err := database.DB.First(&snap, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+
+	var input struct {
+		NodeID  uint `json:"node_id"`
+		Confirm bool `json:"confirm"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if !input.Confirm {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Restoring replaces etcd on the hub with a single member; set confirm to true"})
+	}
+	if input.NodeID == 0 {
+		// Default to the bootstrap hub of the cluster the snapshot was
+		// taken from.
+		cluster, err := services.KubernetesClusterByID(snap.KubernetesClusterID)
+		if err != nil || cluster.BootstrapNodeID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "node_id is required"})
+		}
+		input.NodeID = *cluster.BootstrapNodeID
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	restore, err := services.StartEtcdRestore(snap.ID, input.NodeID, actorID)
+	if errors.Is(err, services.ErrRestoreInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	logger.Audit(c, "Queued etcd restore", actorID, "restore", "EtcdSnapshot",
+		"snapshot_id", snap.ID, "node_id", input.NodeID, "restore_id", restore.ID)
+	return c.Status(fiber.StatusAccepted).JSON(restore)
+}
+
+func AdminListEtcdRestores(c *fiber.Ctx) error {
+	var restores []models.EtcdRestore
+	if err := database.DB.Order("id desc").Limit(50).Find(&restores).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve restores"})
+	}
+	return c.JSON(restores)
+}
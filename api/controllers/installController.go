@@ -78,6 +78,7 @@ API_URL="{{.APIURL}}"
 ROLE="{{.Role}}"
 HOSTNAME="{{if .Hostname}}{{.Hostname}}{{else}}$(hostname -f 2>/dev/null || hostname){{end}}"
 PROVIDER="{{.Provider}}"
+BOOTSTRAP_TOKEN="${GLUON_BOOTSTRAP_TOKEN:-}"
 
 while [ $# -gt 0 ]; do
   case "$1" in
@@ -85,12 +86,21 @@ while [ $# -gt 0 ]; do
     --role)     ROLE="$2";     shift 2;;
     --hostname) HOSTNAME="$2"; shift 2;;
     --provider) PROVIDER="$2"; shift 2;;
+    --token)    BOOTSTRAP_TOKEN="$2"; shift 2;;
     *) echo "[gluon] unknown option: $1"; exit 1;;
   esac
 done
 
 log() { echo "[gluon] $*"; }
 
+# json_escape prints $1 as the inside of a JSON string.
+json_escape() {
+  local s
+  s=$(printf '%s' "$1" | tr -d '\000-\037')
+  s=${s//\\/\\\\}
+  printf '%s' "${s//\"/\\\"}"
+}
+
 # pre-flight
 [ "$(id -u)" -eq 0 ] || { log "must run as root"; exit 1; }
 command -v apt >/dev/null || { log "apt not found"; exit 1; }
@@ -134,13 +144,15 @@ else
   log "writing agent config..."
   cat > /etc/gluon/agent.conf <<CONF
 {
-  "api_url": "$API_URL",
-  "desired_role": "$ROLE",
-  "hostname": "$HOSTNAME",
-  "provider": "$PROVIDER",
+  "api_url": "$(json_escape "$API_URL")",
+  "desired_role": "$(json_escape "$ROLE")",
+  "hostname": "$(json_escape "$HOSTNAME")",
+  "provider": "$(json_escape "$PROVIDER")",
+  "bootstrap_token": "$(json_escape "$BOOTSTRAP_TOKEN")",
   "ca_cert_path": "/etc/gluon/ca.crt"
 }
 CONF
+  chmod 600 /etc/gluon/agent.conf
 fi
 
 # systemd service
@@ -154,6 +166,7 @@ Wants=network-online.target
 [Service]
 Type=simple
 ExecStart=/usr/local/bin/gluon-agent
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=5
 
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminListKeyRotations lists interfaces with a WireGuard key rotation in
+// flight, i.e. whose old key is still kept as a fallback.
+func AdminListKeyRotations(c *fiber.Ctx) error {
+	var ifaces []models.WireGuardInterface
+	if err := database.DB.
+		Preload("Node").
+		Where("previous_public_key <> ''").
+		Order("key_rotated_at asc").
+		Find(&ifaces).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve key rotations"})
+	}
+
+	out := make([]fiber.Map, 0, len(ifaces))
+	for _, iface := range ifaces {
+		out = append(out, fiber.Map{
+			"node_id":             iface.NodeID,
+			"hostname":            iface.Node.Hostname,
+			"interface":           iface.Name,
+			"public_key":          iface.PublicKey,
+			"previous_public_key": iface.PreviousPublicKey,
+			"key_rotated_at":      iface.KeyRotatedAt,
+		})
+	}
+	return c.JSON(out)
+}
+
+// AdminRotateNodeKeys queues a WireGuard key rotation for one node. The body
+// may list interfaces; by default every interface is rotated.
+func AdminRotateNodeKeys(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if node.Status == models.NodeStatusDecommissioned {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Node is decommissioned"})
+	}
+
+	var input struct {
+		Interfaces []string `json:"interfaces"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+		}
+	}
+
+	var names []string
+	if err := database.DB.Model(&models.WireGuardInterface{}).Where("node_id = ?", node.ID).Pluck("name", &names).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get interfaces"})
+	}
+	if len(names) == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Node has no WireGuard interfaces"})
+	}
+	known := make(map[string]bool, len(names))
+	for _, n := range names {
+		known[n] = true
+	}
+	ifaces := make([]string, 0, len(input.Interfaces))
+	for _, n := range input.Interfaces {
+		n = strings.TrimSpace(n)
+		if !known[n] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown interface: " + n})
+		}
+		ifaces = append(ifaces, n)
+	}
+
+	cmd, created, err := services.QueueKeyRotation(node.ID, ifaces)
+	if err != nil {
+		logger.Error("Failed to queue key rotation", "error", err, "node_id", node.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue command"})
+	}
+
+	if created {
+		var actorID *uint
+		if user, err := getUserFromToken(c); err == nil {
+			actorID = &user.ID
+		}
+		logger.Audit(c, "Queued WireGuard key rotation", actorID, "rotate_keys", "Node", "node_id", node.ID, "command_id", cmd.ID)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"command_id": cmd.ID,
+		"node_id":    cmd.NodeID,
+		"kind":       cmd.Kind,
+		"queued":     created,
+	})
+}
+
+// AdminRotateFleetKeys queues a WireGuard key rotation on every active node.
+func AdminRotateFleetKeys(c *fiber.Ctx) error {
+	var nodeIDs []uint
+	if err := database.DB.Model(&models.WireGuardInterface{}).
+		Joins("JOIN nodes ON nodes.id = wire_guard_interfaces.node_id").
+		Where("nodes.status = ?", models.NodeStatusActive).
+		Distinct().
+		Pluck("wire_guard_interfaces.node_id", &nodeIDs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve nodes"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	commands := make([]fiber.Map, 0, len(nodeIDs))
+	queued := 0
+	for _, nodeID := range nodeIDs {
+		cmd, created, err := services.QueueKeyRotation(nodeID, nil)
+		if err != nil {
+			logger.Error("Failed to queue key rotation", "error", err, "node_id", nodeID)
+			continue
+		}
+		if created {
+			queued++
+		}
+		commands = append(commands, fiber.Map{
+			"command_id": cmd.ID,
+			"node_id":    nodeID,
+			"queued":     created,
+		})
+	}
+
+	logger.Audit(c, "Queued fleet-wide WireGuard key rotation", actorID, "rotate_keys", "Node", "nodes", len(nodeIDs), "queued", queued)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"commands": commands,
+		"queued":   queued,
+	})
+}
@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var applicationNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type applicationInput struct {
+	Name                string  `json:"name"`
+	SourceType          string  `json:"source_type"`
+	RepoPath            *string `json:"repo_path"`
+	Revision            *string `json:"revision"`
+	Path                *string `json:"path"`
+	AutoSync            *bool   `json:"auto_sync"`
+	SyncIntervalSeconds *int    `json:"sync_interval_seconds"`
+}
+
+// applyApplicationInput validates input onto app. Fields left out keep their
+// current values.
+func applyApplicationInput(app *models.KubernetesApplication, input applicationInput) error {
+	if input.RepoPath != nil {
+		app.RepoPath = strings.TrimSpace(*input.RepoPath)
+	}
+	if input.Revision != nil {
+		app.Revision = strings.TrimSpace(*input.Revision)
+	}
+	if app.Revision == "" {
+		app.Revision = "HEAD"
+	}
+	if strings.HasPrefix(app.Revision, "-") {
+		return errors.New("invalid revision")
+	}
+	if input.Path != nil {
+		path, err := services.ValidateApplicationPath(*input.Path)
+		if err != nil {
+			return err
+		}
+		app.Path = path
+	}
+	if input.AutoSync != nil {
+		app.AutoSync = *input.AutoSync
+	}
+	if input.SyncIntervalSeconds != nil {
+		app.SyncIntervalSeconds = *input.SyncIntervalSeconds
+	}
+	if app.SyncIntervalSeconds < 30 || app.SyncIntervalSeconds > 86400 {
+		return errors.New("sync_interval_seconds must be between 30 and 86400")
+	}
+
+	if app.SourceType == models.ApplicationSourceGit {
+		if app.RepoPath == "" || !filepath.IsAbs(app.RepoPath) {
+			return errors.New("repo_path must be an absolute path on the API host")
+		}
+		if info, err := os.Stat(app.RepoPath); err != nil || !info.IsDir() {
+			return errors.New("repo_path does not exist")
+		}
+	}
+	return nil
+}
+
+func AdminListApplications(c *fiber.Ctx) error {
+	q := database.DB.Order("name asc")
+	if c.Query("cluster") != "" {
+		cluster, err := findSelectedKubernetesCluster(c)
+		if cluster == nil {
+			return err
+		}
+		q = services.KubernetesClusterScope(q, cluster)
+	}
+
+	var apps []models.KubernetesApplication
+	if err := q.Find(&apps).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve applications"})
+	}
+	return c.JSON(apps)
+}
+
+func AdminGetApplication(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	var app models.KubernetesApplication
+	if err := database.DB.First(&app, appID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+	return c.JSON(app)
+}
+
+func AdminCreateApplication(c *fiber.Ctx) error {
+	var input applicationInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	name := strings.ToLower(strings.TrimSpace(input.Name))
+	if !applicationNameRe.MatchString(name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid name (lowercase letters, digits and dashes)"})
+	}
+
+	// The application is synced to the cluster selected at creation.
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+
+	app := models.KubernetesApplication{
+		Name:                name,
+		SourceType:          strings.ToLower(strings.TrimSpace(input.SourceType)),
+		KubernetesClusterID: &cluster.ID,
+		Revision:            "HEAD",
+		AutoSync:            true,
+		SyncIntervalSeconds: 180,
+		SyncStatus:          models.ApplicationSyncUnknown,
+	}
+	if app.SourceType != models.ApplicationSourceGit && app.SourceType != models.ApplicationSourceBundle {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "source_type must be git or bundle"})
+	}
+	if err := applyApplicationInput(&app, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var existing models.KubernetesApplication
+	if err := database.DB.Where("name = ?", name).First(&existing).Error; err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Application name already in use"})
+	}
+
+	if err := database.DB.Create(&app).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create application"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Created Kubernetes application", actorID, "create", "KubernetesApplication",
+		"application_id", app.ID, "name", app.Name, "source_type", app.SourceType)
+
+	return c.Status(fiber.StatusCreated).JSON(app)
+}
+
+func AdminUpdateApplication(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	var app models.KubernetesApplication
+	if err := database.DB.First(&app, appID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	var input applicationInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if err := applyApplicationInput(&app, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Force a check on the next reconciler pass.
+	if err := database.DB.Model(&app).Updates(map[string]any{
+		"repo_path":             app.RepoPath,
+		"revision":              app.Revision,
+		"path":                  app.Path,
+		"auto_sync":             app.AutoSync,
+		"sync_interval_seconds": app.SyncIntervalSeconds,
+		"last_checked_at":       nil,
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update application"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated Kubernetes application", actorID, "update", "KubernetesApplication",
+		"application_id", app.ID, "name", app.Name)
+
+	database.DB.First(&app, app.ID)
+	return c.JSON(app)
+}
+
+// AdminDeleteApplication stops managing an application. Objects it applied
+// are left in the cluster.
+func AdminDeleteApplication(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	var app models.KubernetesApplication
+	if err := database.DB.First(&app, appID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+	if err := database.DB.Delete(&app).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete application"})
+	}
+	os.RemoveAll(filepath.Dir(services.ApplicationBundleDir(app.ID)))
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Deleted Kubernetes application", actorID, "delete", "KubernetesApplication",
+		"application_id", app.ID, "name", app.Name)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// AdminUploadApplicationBundle replaces the source of a bundle application
+// with an uploaded tar or tar.gz archive (multipart field "bundle").
+func AdminUploadApplicationBundle(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	var app models.KubernetesApplication
+	if err := database.DB.First(&app, appID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+	if app.SourceType != models.ApplicationSourceBundle {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Application does not use a bundle source"})
+	}
+
+	fh, err := c.FormFile("bundle")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bundle file is required"})
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Failed to read bundle"})
+	}
+	// StoreApplicationBundle refuses anything over the limit; reading one
+	// byte past it is enough to tell.
+	data, err := io.ReadAll(io.LimitReader(f, int64(config.Current().ApplicationBundleMaxMB)<<20+1))
+	f.Close()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Failed to read bundle"})
+	}
+
+	digest, err := services.StoreApplicationBundle(app.ID, data)
+	if errors.Is(err, services.ErrBundleTooLarge) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&app).Updates(map[string]any{
+		"bundle_digest":      digest,
+		"bundle_uploaded_at": now,
+		"last_checked_at":    nil,
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update application"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Uploaded Kubernetes application bundle", actorID, "upload_bundle", "KubernetesApplication",
+		"application_id", app.ID, "name", app.Name, "digest", digest, "size", len(data))
+
+	return c.JSON(fiber.Map{"bundle_digest": digest})
+}
+
+// AdminSyncApplication renders and compares an application now. The source
+// is applied unless ?dry_run=true, even if auto-sync is off.
+func AdminSyncApplication(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	var app models.KubernetesApplication
+	if err := database.DB.First(&app, appID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	kc, err := services.ApplicationKubeClient(&app)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Minute)
+	defer cancel()
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+	result := services.SyncApplication(ctx, kc, &app, !dryRun, actorID)
+	if result.Applied {
+		logger.Audit(c, "Synced Kubernetes application", actorID, "sync", "KubernetesApplication",
+			"application_id", app.ID, "name", app.Name, "source_revision", result.SourceRevision,
+			"revision_id", result.RevisionID, "status", result.Status)
+	}
+
+	return c.JSON(result)
+}
+
+// AdminListApplicationHistory lists the manifest revisions applied for an
+// application, newest first.
+func AdminListApplicationHistory(c *fiber.Ctx) error {
+	appID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || appID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid application id"})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var revs []models.KubernetesManifestRevision
+	if err := database.DB.Where("application_id = ?", appID).Order("id desc").Limit(limit).Find(&revs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve history"})
+	}
+	return c.JSON(revs)
+}
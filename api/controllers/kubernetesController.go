@@ -5,16 +5,19 @@ import (
 	"errors"
 	"gluon-api/config"
 	"gluon-api/database"
+	"gluon-api/kube"
 	"gluon-api/logger"
 	"gluon-api/models"
 	"gluon-api/services"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -36,6 +39,24 @@ type kubernetesTask struct {
 	JoinCommand          string `json:"join_command,omitempty"`
 	Note                 string `json:"note,omitempty"`
 	BootstrapOwner       bool   `json:"bootstrap_owner,omitempty"`
+	CNI                  string `json:"cni,omitempty"`
+
+	// Cluster names the cluster the node belongs to. UploadKubeconfig asks
+	// its bootstrap hub to send admin.conf with its next report, when the
+	// API has no kubeconfig for that cluster yet.
+	Cluster          string `json:"cluster,omitempty"`
+	UploadKubeconfig bool   `json:"upload_kubeconfig,omitempty"`
+
+	// UpgradeApply is set on the upgrade task of the control plane that runs
+	// `kubeadm upgrade apply`; every other node runs `kubeadm upgrade node`.
+	UpgradeApply bool `json:"upgrade_apply,omitempty"`
+
+	// Snapshot to restore for a "restore_etcd" task; the agent downloads it
+	// and checks it against the checksum before touching etcd. The restore
+	// ID lets it recognise a restore it already finished.
+	EtcdRestoreID      uint   `json:"etcd_restore_id,omitempty"`
+	EtcdSnapshotID     uint   `json:"etcd_snapshot_id,omitempty"`
+	EtcdSnapshotSHA256 string `json:"etcd_snapshot_sha256,omitempty"`
 }
 
 type kubernetesReport struct {
@@ -51,6 +72,10 @@ type kubernetesReport struct {
 	WorkerJoinCommand       string `json:"worker_join_command,omitempty"`
 	ControlPlaneJoinCommand string `json:"control_plane_join_command,omitempty"`
 	JoinCommandExpiresAt    string `json:"join_command_expires_at,omitempty"` 
+
+	// Kubeconfig is the bootstrap hub's admin.conf, sent when the task
+	// asked for it.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
 }
 
 func GetKubernetesTask(c *fiber.Ctx) error {
@@ -62,22 +87,22 @@ func GetKubernetesTask(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
 	}
 
-	var bootstrapHub models.Node
-	if err := database.DB.
-		Where("role = ? OR reported_desired_role = ?", models.NodeRoleHub, string(models.NodeRoleHub)).
-		Order("id asc").
-		First(&bootstrapHub).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.JSON(kubernetesTask{Action: "none", Note: "No hubs enrolled yet"})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to determine bootstrap hub"})
+	cluster, bootstrapHub, err := kubernetesClusterForNode(&node)
+	if errors.Is(err, errNoBootstrapHub) {
+		return c.JSON(kubernetesTask{Action: "none", Note: "No hubs enrolled yet"})
 	}
-
-	cluster, err := getOrCreateKubernetesCluster(&bootstrapHub)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load cluster state"})
 	}
 	isBootstrap := cluster.BootstrapNodeID != nil && node.ID == *cluster.BootstrapNodeID
+	needKubeconfig := isBootstrap && !services.ClusterKubeconfigAvailable(cluster)
+	// Every task carries the CNI so agents keep the right plugin healthy.
+	respond := func(task kubernetesTask) error {
+		task.CNI = cluster.CNI
+		task.Cluster = cluster.Name
+		task.UploadKubeconfig = task.Action == "init" && needKubeconfig
+		return c.JSON(task)
+	}
 
 	if cluster.InitializedAt == nil {
 		if isBootstrap {
@@ -89,22 +114,43 @@ func GetKubernetesTask(c *fiber.Ctx) error {
 					endpoint = bootstrapHub.PublicIP
 				}
 			}
-			return c.JSON(kubernetesTask{
+			return respond(kubernetesTask{
 				Action:               "init",
 				ControlPlaneEndpoint: endpoint,
 				PodCIDR:              nonEmpty(cluster.PodCIDR, cfg.KubernetesPodCIDR),
 				ServiceCIDR:          nonEmpty(cluster.ServiceCIDR, cfg.KubernetesServiceCIDR),
 				KubernetesVersion:    nonEmpty(cluster.KubernetesVersion, defaultK8sVersion),
-				Note:                 "Bootstrap control-plane with kubeadm init (single-cluster mode)",
+				Note:                 "Bootstrap control-plane of cluster " + cluster.Name + " with kubeadm init",
 				BootstrapOwner:       isBootstrap,
 			})
 		}
-		return c.JSON(kubernetesTask{Action: "wait", Note: "Waiting for bootstrap hub to initialize the cluster", BootstrapOwner: isBootstrap})
+		return respond(kubernetesTask{Action: "wait", Note: "Waiting for bootstrap hub to initialize the cluster", BootstrapOwner: isBootstrap})
+	}
+
+	if restore, snap, ok := services.EtcdRestoreTaskFor(node.ID); ok {
+		return respond(kubernetesTask{
+			Action:             "restore_etcd",
+			EtcdRestoreID:      restore.ID,
+			EtcdSnapshotID:     snap.ID,
+			EtcdSnapshotSHA256: snap.SHA256,
+			Note:               "Restore etcd from snapshot as a single-member cluster",
+			BootstrapOwner:     isBootstrap,
+		})
+	}
+
+	if step, version, ok := services.UpgradeTaskFor(node.ID); ok {
+		return respond(kubernetesTask{
+			Action:            "upgrade",
+			KubernetesVersion: version,
+			UpgradeApply:      step.Apply,
+			Note:              "Upgrade Kubernetes to " + version,
+			BootstrapOwner:    isBootstrap,
+		})
 	}
 
 	
 	if wantsControlPlane(&node) {
-		if isBootstrap && shouldRefreshJoinCommands(cluster) {
+		if isBootstrap && (shouldRefreshJoinCommands(cluster) || needKubeconfig) {
 			endpoint := cluster.ControlPlaneEndpoint
 			if endpoint == "" {
 				if ip, err := services.GetNodeLoopbackIP(bootstrapHub.ID); err == nil && ip != "" {
@@ -113,7 +159,7 @@ func GetKubernetesTask(c *fiber.Ctx) error {
 					endpoint = bootstrapHub.PublicIP
 				}
 			}
-			return c.JSON(kubernetesTask{
+			return respond(kubernetesTask{
 				Action:               "init",
 				ControlPlaneEndpoint: endpoint,
 				PodCIDR:              nonEmpty(cluster.PodCIDR, cfg.KubernetesPodCIDR),
@@ -125,36 +171,62 @@ func GetKubernetesTask(c *fiber.Ctx) error {
 		}
 
 		if node.K8sState == "joined_control_plane" || (isBootstrap && node.K8sState == "cluster_initialized") {
-			return c.JSON(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
+			return respond(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
 		}
 		if cluster.ControlPlaneJoinCommand == "" {
-			return c.JSON(kubernetesTask{Action: "wait", Note: "Cluster initialized; join command not available yet", BootstrapOwner: isBootstrap})
+			return respond(kubernetesTask{Action: "wait", Note: "Cluster initialized; join command not available yet", BootstrapOwner: isBootstrap})
 		}
-		return c.JSON(kubernetesTask{
-			Action:      "join_control_plane",
-			JoinCommand: cluster.ControlPlaneJoinCommand,
-			Note:        "Join as additional control-plane node",
-			BootstrapOwner: isBootstrap,
+		return respond(kubernetesTask{
+			Action:            "join_control_plane",
+			JoinCommand:       cluster.ControlPlaneJoinCommand,
+			KubernetesVersion: cluster.KubernetesVersion,
+			Note:              "Join as additional control-plane node",
+			BootstrapOwner:    isBootstrap,
 		})
 	}
 
 	switch node.Role {
 	case models.NodeRoleWorker:
 		if node.K8sState == "joined_worker" {
-			return c.JSON(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
+			return respond(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
 		}
 		if cluster.WorkerJoinCommand == "" {
-			return c.JSON(kubernetesTask{Action: "wait", Note: "Cluster initialized; join command not available yet", BootstrapOwner: isBootstrap})
+			return respond(kubernetesTask{Action: "wait", Note: "Cluster initialized; join command not available yet", BootstrapOwner: isBootstrap})
 		}
-		return c.JSON(kubernetesTask{
-			Action:      "join_worker",
-			JoinCommand: cluster.WorkerJoinCommand,
-			Note:        "Join as worker node",
-			BootstrapOwner: isBootstrap,
+		return respond(kubernetesTask{
+			Action:            "join_worker",
+			JoinCommand:       cluster.WorkerJoinCommand,
+			KubernetesVersion: cluster.KubernetesVersion,
+			Note:              "Join as worker node",
+			BootstrapOwner:    isBootstrap,
 		})
 	default:
-		return c.JSON(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
+		return respond(kubernetesTask{Action: "none", BootstrapOwner: isBootstrap})
+	}
+}
+
+// GetKubernetesNodeMetadata gives the bootstrap hub's agent the labels and
+// taints every joined node should carry.
+func GetKubernetesNodeMetadata(c *fiber.Ctx) error {
+	nodeID := c.Locals("node_id").(uint)
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if !wantsControlPlane(&node) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only control-plane hubs reconcile node metadata"})
+	}
+
+	cluster, err := services.KubernetesClusterForNode(&node)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cluster not found"})
 	}
+	nodes, err := services.ListDesiredKubernetesNodeMetadata(cluster)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load node metadata"})
+	}
+	return c.JSON(fiber.Map{"nodes": nodes})
 }
 
 func ReportKubernetes(c *fiber.Ctx) error {
@@ -170,10 +242,34 @@ func ReportKubernetes(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
 	}
 
+	switch input.State {
+	case "upgrading", "upgraded", "upgrade_failed":
+		// Upgrade progress belongs to the upgrade, not the node's join state.
+		if err := services.RecordUpgradeReport(nodeID, input.State, truncateString(input.Message, 4000), input.KubernetesVersion); err != nil {
+			if errors.Is(err, services.ErrUpgradeNotFound) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "No upgrade step is running on this node"})
+			}
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "ok"})
+
+	case "etcd_restoring", "etcd_restored", "etcd_restore_failed":
+		if err := services.RecordEtcdRestoreReport(nodeID, input.State, truncateString(input.Message, 4000)); err != nil {
+			if errors.Is(err, services.ErrRestoreNotFound) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "ok"})
+	}
+
 	now := time.Now()
 	updates := map[string]any{
 		"k8s_last_attempt_at": &now,
 	}
+	if input.KubernetesVersion != "" && input.State != "cluster_initialized" {
+		updates["k8s_version"] = input.KubernetesVersion
+	}
 
 	switch input.State {
 	case "cluster_initialized":
@@ -181,12 +277,19 @@ func ReportKubernetes(c *fiber.Ctx) error {
 		updates["k8s_joined_at"] = &now
 		updates["k8s_last_error"] = ""
 
-		cluster, err := upsertClusterFromReport(nodeID, &input)
+		cluster, err := upsertClusterFromReport(&node, &input)
 		if err != nil {
 			logger.Error("Failed to update cluster from report", "error", err, "node_id", nodeID)
 			updates["k8s_last_error"] = "failed to update cluster state on API"
 		} else {
 			logger.Info("Kubernetes cluster initialized", "bootstrap_node_id", nodeID, "cluster_id", cluster.ID)
+			if input.Kubeconfig != "" && cluster.BootstrapNodeID != nil && *cluster.BootstrapNodeID == nodeID {
+				if err := services.StoreClusterKubeconfig(cluster, []byte(input.Kubeconfig)); err != nil {
+					logger.Error("Failed to store cluster kubeconfig", "error", err, "cluster_id", cluster.ID, "node_id", nodeID)
+				} else {
+					logger.Info("Stored cluster kubeconfig", "cluster_id", cluster.ID, "cluster", cluster.Name)
+				}
+			}
 		}
 
 	case "joined_control_plane":
@@ -208,8 +311,7 @@ func ReportKubernetes(c *fiber.Ctx) error {
 		
 		low := strings.ToLower(errMsg)
 		if strings.Contains(low, "kubeadm-certs") && strings.Contains(low, "secret") && strings.Contains(low, "not found") {
-			var cluster models.KubernetesCluster
-			if err := database.DB.Order("id asc").First(&cluster).Error; err == nil && cluster.InitializedAt != nil {
+			if cluster, err := services.KubernetesClusterForNode(&node); err == nil && cluster.InitializedAt != nil {
 				expires := time.Now().Add(-1 * time.Minute)
 				if err := database.DB.Model(&models.KubernetesCluster{}).
 					Where("id = ?", cluster.ID).
@@ -225,8 +327,7 @@ func ReportKubernetes(c *fiber.Ctx) error {
 			}
 		}
 		if strings.Contains(low, "kubeadm-config") && strings.Contains(low, "unauthorized") {
-			var cluster models.KubernetesCluster
-			if err := database.DB.Order("id asc").First(&cluster).Error; err == nil && cluster.InitializedAt != nil {
+			if cluster, err := services.KubernetesClusterForNode(&node); err == nil && cluster.InitializedAt != nil {
 				expires := time.Now().Add(-1 * time.Minute)
 				if err := database.DB.Model(&models.KubernetesCluster{}).
 					Where("id = ?", cluster.ID).
@@ -278,10 +379,12 @@ func maybeCleanupKubernetesJoinFailure(node *models.Node, errMsg string) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if nodeName != "" {
-			if out, err := kubectlRaw(ctx, []string{"delete", "node", nodeName, "--ignore-not-found"}); err != nil {
-				logger.Error("Failed to delete existing node before rejoin", "error", err, "node_name", nodeName, "output", strings.TrimSpace(out))
+			if kc, err := nodeKubeClient(node); err != nil {
+				logger.Error("Failed to delete existing node before rejoin", "error", err, "node_name", nodeName)
+			} else if err := kc.DeleteNode(ctx, nodeName); err != nil {
+				logger.Error("Failed to delete existing node before rejoin", "error", err, "node_name", nodeName)
 			} else {
-				logger.Info("Deleted existing node before rejoin", "node_name", nodeName, "output", strings.TrimSpace(out))
+				logger.Info("Deleted existing node before rejoin", "node_name", nodeName)
 			}
 		}
 	}
@@ -304,8 +407,8 @@ func cleanupEtcdLearner(node *models.Node) {
 		return
 	}
 
-	var cluster models.KubernetesCluster
-	if err := database.DB.Order("id asc").First(&cluster).Error; err != nil || cluster.BootstrapNodeID == nil {
+	cluster, err := services.KubernetesClusterForNode(node)
+	if err != nil || cluster.BootstrapNodeID == nil {
 		return
 	}
 	var bootstrap models.Node
@@ -317,10 +420,15 @@ func cleanupEtcdLearner(node *models.Node) {
 		return
 	}
 
+	kc, err := kube.SharedFor(services.ClusterKubeconfigPath(cluster))
+	if err != nil {
+		logger.Error("Failed to list etcd members", "error", err)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	listOut, err := kubectlRaw(ctx, []string{
-		"-n", "kube-system", "exec", etcdPod, "--",
+	listOut, err := kc.Exec(ctx, "kube-system", etcdPod, "", []string{
 		"env", "ETCDCTL_API=3", "etcdctl",
 		"--endpoints=https://127.0.0.1:2379",
 		"--cacert=/etc/kubernetes/pki/etcd/ca.crt",
@@ -354,8 +462,7 @@ func cleanupEtcdLearner(node *models.Node) {
 		return
 	}
 
-	rmOut, err := kubectlRaw(ctx, []string{
-		"-n", "kube-system", "exec", etcdPod, "--",
+	rmOut, err := kc.Exec(ctx, "kube-system", etcdPod, "", []string{
 		"env", "ETCDCTL_API=3", "etcdctl",
 		"--endpoints=https://127.0.0.1:2379",
 		"--cacert=/etc/kubernetes/pki/etcd/ca.crt",
@@ -370,56 +477,255 @@ func cleanupEtcdLearner(node *models.Node) {
 	logger.Info("Removed etcd learner member", "member_id", memberID, "node_id", node.ID)
 }
 
+type kubernetesClusterSummary struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	BootstrapNodeID *uint `json:"bootstrap_node_id,omitempty"`
+
+	ControlPlaneEndpoint string `json:"control_plane_endpoint"`
+	PodCIDR              string `json:"pod_cidr"`
+	ServiceCIDR          string `json:"service_cidr"`
+	KubernetesVersion    string `json:"kubernetes_version"`
+	CNI                  string `json:"cni"`
+
+	InitializedAt        *time.Time `json:"initialized_at,omitempty"`
+	JoinCommandExpiresAt *time.Time `json:"join_command_expires_at,omitempty"`
+
+	Default             bool  `json:"default"`
+	NodeCount           int64 `json:"node_count"`
+	KubeconfigAvailable bool  `json:"kubeconfig_available"`
+}
+
+func summarizeKubernetesCluster(cluster *models.KubernetesCluster) kubernetesClusterSummary {
+	var count int64
+	services.KubernetesClusterMembers(database.DB.Model(&models.Node{}), cluster).Count(&count)
+	return kubernetesClusterSummary{
+		ID:                   cluster.ID,
+		Name:                 cluster.Name,
+		CreatedAt:            cluster.CreatedAt,
+		UpdatedAt:            cluster.UpdatedAt,
+		BootstrapNodeID:      cluster.BootstrapNodeID,
+		ControlPlaneEndpoint: cluster.ControlPlaneEndpoint,
+		PodCIDR:              cluster.PodCIDR,
+		ServiceCIDR:          cluster.ServiceCIDR,
+		KubernetesVersion:    cluster.KubernetesVersion,
+		CNI:                  cluster.CNI,
+		InitializedAt:        cluster.InitializedAt,
+		JoinCommandExpiresAt: cluster.JoinCommandExpiresAt,
+		Default:              services.IsDefaultKubernetesCluster(cluster),
+		NodeCount:            count,
+		KubeconfigAvailable:  services.ClusterKubeconfigAvailable(cluster),
+	}
+}
+
+// findSelectedKubernetesCluster loads the cluster named by ?cluster=, the
+// default one when it is absent, and writes the error response itself.
+func findSelectedKubernetesCluster(c *fiber.Ctx) (*models.KubernetesCluster, error) {
+	cluster, err := services.FindKubernetesCluster(c.Query("cluster"))
+	if errors.Is(err, services.ErrClusterNotFound) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cluster not found"})
+	}
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load cluster"})
+	}
+	return cluster, nil
+}
+
 func AdminGetKubernetesCluster(c *fiber.Ctx) error {
-	var cluster models.KubernetesCluster
-	if err := database.DB.Order("id asc").First(&cluster).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.JSON(fiber.Map{"cluster": nil})
+	cluster, err := services.FindKubernetesCluster(c.Query("cluster"))
+	if err != nil {
+		if errors.Is(err, services.ErrClusterNotFound) {
+			if c.Query("cluster") == "" {
+				return c.JSON(fiber.Map{"cluster": nil})
+			}
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cluster not found"})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load cluster"})
 	}
 
-	
-	type clusterSummary struct {
-		ID        uint      `json:"id"`
-		CreatedAt time.Time `json:"created_at"`
-		UpdatedAt time.Time `json:"updated_at"`
-
-		BootstrapNodeID *uint `json:"bootstrap_node_id,omitempty"`
-
-		ControlPlaneEndpoint string `json:"control_plane_endpoint"`
-		PodCIDR              string `json:"pod_cidr"`
-		ServiceCIDR          string `json:"service_cidr"`
-		KubernetesVersion    string `json:"kubernetes_version"`
-
-		InitializedAt        *time.Time `json:"initialized_at,omitempty"`
-		JoinCommandExpiresAt *time.Time `json:"join_command_expires_at,omitempty"`
-	}
-
-	return c.JSON(fiber.Map{
-		"cluster": clusterSummary{
-			ID:                   cluster.ID,
-			CreatedAt:            cluster.CreatedAt,
-			UpdatedAt:            cluster.UpdatedAt,
-			BootstrapNodeID:      cluster.BootstrapNodeID,
-			ControlPlaneEndpoint: cluster.ControlPlaneEndpoint,
-			PodCIDR:              cluster.PodCIDR,
-			ServiceCIDR:          cluster.ServiceCIDR,
-			KubernetesVersion:    cluster.KubernetesVersion,
-			InitializedAt:        cluster.InitializedAt,
-			JoinCommandExpiresAt: cluster.JoinCommandExpiresAt,
-		},
-	})
+	return c.JSON(fiber.Map{"cluster": summarizeKubernetesCluster(cluster)})
 }
 
-func AdminRefreshKubernetesJoinCommands(c *fiber.Ctx) error {
+func AdminListKubernetesClusters(c *fiber.Ctx) error {
+	var clusters []models.KubernetesCluster
+	if err := database.DB.Order("id asc").Find(&clusters).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list clusters"})
+	}
+	out := make([]kubernetesClusterSummary, 0, len(clusters))
+	for i := range clusters {
+		out = append(out, summarizeKubernetesCluster(&clusters[i]))
+	}
+	return c.JSON(fiber.Map{"clusters": out})
+}
+
+// AdminCreateKubernetesCluster registers another cluster bootstrapped by
+// the given hub. Its pod and service ranges are allocated from the IPAM
+// supernets so they overlap no other cluster.
+func AdminCreateKubernetesCluster(c *fiber.Ctx) error {
+	var input struct {
+		Name              string `json:"name"`
+		BootstrapNodeID   uint   `json:"bootstrap_node_id"`
+		CNI               string `json:"cni"`
+		KubernetesVersion string `json:"kubernetes_version"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if errs := validation.IsDNS1123Label(input.Name); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name must be a DNS label: " + strings.Join(errs, "; ")})
+	}
+	if _, err := strconv.ParseUint(input.Name, 10, 64); err == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name must not be a number"})
+	}
+	cni, err := services.NormalizeCNI(input.CNI)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	version := nonEmpty(strings.TrimSpace(input.KubernetesVersion), defaultK8sVersion)
+
+	var hub models.Node
+	if err := database.DB.First(&hub, input.BootstrapNodeID).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bootstrap_node_id must name an enrolled node"})
+	}
+	if !wantsControlPlane(&hub) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The bootstrap node must be a hub"})
+	}
+	if hub.K8sState != "" && hub.K8sState != "none" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The bootstrap node is already part of a cluster; reset it first"})
+	}
+
+	var existing int64
+	database.DB.Model(&models.KubernetesCluster{}).Count(&existing)
+	if _, err := services.FindKubernetesCluster(input.Name); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A cluster with that name already exists"})
+	}
+	podCIDR, serviceCIDR, err := services.PickKubernetesClusterCIDRs(existing == 0)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	endpoint := hub.PublicIP
+	if ip, err := services.GetNodeLoopbackIP(hub.ID); err == nil && ip != "" {
+		endpoint = ip
+	}
+	cluster := models.KubernetesCluster{
+		Name:                 input.Name,
+		BootstrapNodeID:      &hub.ID,
+		ControlPlaneEndpoint: endpoint,
+		PodCIDR:              podCIDR,
+		ServiceCIDR:          serviceCIDR,
+		KubernetesVersion:    version,
+		CNI:                  cni,
+	}
+	if err := database.DB.Create(&cluster).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create cluster"})
+	}
+	if err := services.RegisterKubernetesClusterPools(&cluster); err != nil {
+		logger.Error("Failed to register cluster IP pools", "error", err, "cluster_id", cluster.ID)
+	}
+	if err := database.DB.Model(&hub).Update("kubernetes_cluster_id", cluster.ID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to assign bootstrap node"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Created Kubernetes cluster", actorID, "create", "KubernetesCluster",
+		"cluster_id", cluster.ID, "name", cluster.Name, "bootstrap_node_id", hub.ID, "pod_cidr", podCIDR, "service_cidr", serviceCIDR)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"cluster": summarizeKubernetesCluster(&cluster)})
+}
+
+// AdminDeleteKubernetesCluster forgets a cluster that no node belongs to
+// any more, releasing its IP ranges. The default cluster stays.
+func AdminDeleteKubernetesCluster(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
 	var cluster models.KubernetesCluster
-	if err := database.DB.Order("id asc").First(&cluster).Error; err != nil {
+	if err := database.DB.First(&cluster, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cluster not found"})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load cluster"})
 	}
+	if services.IsDefaultKubernetesCluster(&cluster) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The default cluster cannot be deleted"})
+	}
+	var members int64
+	database.DB.Model(&models.Node{}).Where("kubernetes_cluster_id = ?", cluster.ID).Count(&members)
+	if members > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Move or remove the cluster's nodes first"})
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("kubernetes_cluster_id = ?", cluster.ID).Delete(&models.IPPool{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&cluster).Error
+	}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete cluster"})
+	}
+	services.RemoveClusterKubeconfig(&cluster)
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Deleted Kubernetes cluster", actorID, "delete", "KubernetesCluster", "cluster_id", cluster.ID, "name", cluster.Name)
+	return c.JSON(fiber.Map{"message": "deleted"})
+}
+
+// AdminUpdateKubernetesCluster changes the cluster's CNI. The plugin is
+// installed by kubeadm init, so it is fixed once the cluster exists.
+func AdminUpdateKubernetesCluster(c *fiber.Ctx) error {
+	var input struct {
+		CNI string `json:"cni"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if strings.TrimSpace(input.CNI) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cni is required"})
+	}
+	cni, err := services.NormalizeCNI(input.CNI)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+	if cluster.CNI == cni {
+		return c.JSON(fiber.Map{"message": "unchanged", "cni": cni})
+	}
+	if cluster.InitializedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The CNI cannot be changed after the cluster is initialized"})
+	}
+
+	if err := database.DB.Model(cluster).Update("cni", cni).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update cluster"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Changed Kubernetes CNI", actorID, "update", "KubernetesCluster", "cluster_id", cluster.ID, "cni", cni)
+	return c.JSON(fiber.Map{"message": "updated", "cni": cni})
+}
+
+func AdminRefreshKubernetesJoinCommands(c *fiber.Ctx) error {
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
 
 	
 	now := time.Now()
@@ -433,10 +739,74 @@ func AdminRefreshKubernetesJoinCommands(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to request refresh"})
 	}
 
-	logger.Info("Requested Kubernetes join command refresh", "cluster_id", cluster.ID)
+	logger.Info("Requested Kubernetes join command refresh", "cluster_id", cluster.ID, "cluster", cluster.Name)
 	return c.JSON(fiber.Map{"message": "refresh requested"})
 }
 
+var errNoBootstrapHub = errors.New("no hub to bootstrap the cluster")
+
+// kubernetesClusterForNode returns the cluster node belongs to and that
+// cluster's bootstrap hub. Nodes not assigned to a cluster belong to the
+// default one, which is created on first use with the lowest-ID hub.
+func kubernetesClusterForNode(node *models.Node) (*models.KubernetesCluster, *models.Node, error) {
+	cluster, err := services.KubernetesClusterForNode(node)
+	if err != nil && (node.KubernetesClusterID != nil || !errors.Is(err, services.ErrClusterNotFound)) {
+		return nil, nil, err
+	}
+
+	var bootstrapHub models.Node
+	if cluster != nil && cluster.BootstrapNodeID != nil {
+		if err := database.DB.First(&bootstrapHub, *cluster.BootstrapNodeID).Error; err == nil {
+			return cluster, &bootstrapHub, nil
+		}
+	}
+	hubs := database.DB.Where("kubernetes_cluster_id IS NULL")
+	if cluster != nil {
+		hubs = services.KubernetesClusterMembers(database.DB, cluster)
+	}
+	if err := hubs.
+		Where("role = ? OR reported_desired_role = ?", models.NodeRoleHub, string(models.NodeRoleHub)).
+		Order("id asc").
+		First(&bootstrapHub).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errNoBootstrapHub
+		}
+		return nil, nil, err
+	}
+
+	if cluster == nil || services.IsDefaultKubernetesCluster(cluster) {
+		cluster, err = getOrCreateKubernetesCluster(&bootstrapHub)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cluster, &bootstrapHub, nil
+	}
+
+	updates := map[string]any{"bootstrap_node_id": bootstrapHub.ID}
+	cluster.BootstrapNodeID = &bootstrapHub.ID
+	if cluster.ControlPlaneEndpoint == "" {
+		endpoint := bootstrapHub.PublicIP
+		if ip, err := services.GetNodeLoopbackIP(bootstrapHub.ID); err == nil && ip != "" {
+			endpoint = ip
+		}
+		updates["control_plane_endpoint"] = endpoint
+		cluster.ControlPlaneEndpoint = endpoint
+	}
+	if err := database.DB.Model(cluster).Updates(updates).Error; err != nil {
+		return nil, nil, err
+	}
+	return cluster, &bootstrapHub, nil
+}
+
+// nodeKubeClient returns the API's client for the cluster node belongs to.
+func nodeKubeClient(node *models.Node) (*kube.Client, error) {
+	cluster, err := services.KubernetesClusterForNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return kube.SharedFor(services.ClusterKubeconfigPath(cluster))
+}
+
 func getOrCreateKubernetesCluster(bootstrapHub *models.Node) (*models.KubernetesCluster, error) {
 	cfg := config.Current()
 	var cluster models.KubernetesCluster
@@ -450,17 +820,26 @@ func getOrCreateKubernetesCluster(bootstrapHub *models.Node) (*models.Kubernetes
 		if ip, err := services.GetNodeLoopbackIP(bootstrapHub.ID); err == nil && ip != "" {
 			endpoint = ip
 		}
+		podCIDR, serviceCIDR, err := services.PickKubernetesClusterCIDRs(true)
+		if err != nil {
+			return nil, err
+		}
 
 		newCluster := models.KubernetesCluster{
+			Name:                 "default",
 			BootstrapNodeID:      &bootstrapHub.ID,
 			ControlPlaneEndpoint: endpoint,
-			PodCIDR:              cfg.KubernetesPodCIDR,
-			ServiceCIDR:          cfg.KubernetesServiceCIDR,
+			PodCIDR:              podCIDR,
+			ServiceCIDR:          serviceCIDR,
 			KubernetesVersion:    defaultK8sVersion,
+			CNI:                  defaultCNI(),
 		}
 		if err := database.DB.Create(&newCluster).Error; err != nil {
 			return nil, err
 		}
+		if err := services.RegisterKubernetesClusterPools(&newCluster); err != nil {
+			logger.Error("Failed to register cluster IP pools", "error", err, "cluster_id", newCluster.ID)
+		}
 		return &newCluster, nil
 	}
 
@@ -489,6 +868,10 @@ func getOrCreateKubernetesCluster(bootstrapHub *models.Node) (*models.Kubernetes
 		cluster.KubernetesVersion = defaultK8sVersion
 		changed = true
 	}
+	if cluster.CNI == "" {
+		cluster.CNI = defaultCNI()
+		changed = true
+	}
 	if changed {
 		if err := database.DB.Save(&cluster).Error; err != nil {
 			return nil, err
@@ -498,14 +881,15 @@ func getOrCreateKubernetesCluster(bootstrapHub *models.Node) (*models.Kubernetes
 	return &cluster, nil
 }
 
-func upsertClusterFromReport(nodeID uint, r *kubernetesReport) (*models.KubernetesCluster, error) {
+func upsertClusterFromReport(node *models.Node, r *kubernetesReport) (*models.KubernetesCluster, error) {
+	nodeID := node.ID
 	var cluster models.KubernetesCluster
-	err := database.DB.Order("id asc").First(&cluster).Error
-	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
-		}
-		cluster = models.KubernetesCluster{}
+	if found, err := services.KubernetesClusterForNode(node); err == nil {
+		cluster = *found
+	} else if !errors.Is(err, services.ErrClusterNotFound) || node.KubernetesClusterID != nil {
+		return nil, err
+	} else {
+		cluster = models.KubernetesCluster{Name: "default"}
 	}
 
 	changed := false
@@ -569,6 +953,15 @@ func upsertClusterFromReport(nodeID uint, r *kubernetesReport) (*models.Kubernet
 	return &cluster, nil
 }
 
+func defaultCNI() string {
+	cni, err := services.NormalizeCNI("")
+	if err != nil {
+		logger.Error("Invalid GLUON_K8S_CNI; using flannel", "error", err)
+		return models.CNIFlannel
+	}
+	return cni
+}
+
 func nonEmpty(v, fallback string) string {
 	if v != "" {
 		return v
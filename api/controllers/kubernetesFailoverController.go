@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type failoverPolicyInput struct {
+	Namespace              *string `json:"namespace"`
+	Kind                   *string `json:"kind"`
+	Name                   *string `json:"name"`
+	Enabled                *bool   `json:"enabled"`
+	GraceSeconds           *int    `json:"grace_seconds"`
+	RecoveryTimeoutSeconds *int    `json:"recovery_timeout_seconds"`
+}
+
+// applyFailoverPolicyInput validates input onto p. Fields left out keep
+// their current values.
+func applyFailoverPolicyInput(p *models.KubernetesFailoverPolicy, input failoverPolicyInput) error {
+	if input.Namespace != nil {
+		p.Namespace = *input.Namespace
+	}
+	if input.Kind != nil {
+		p.Kind = *input.Kind
+	}
+	if input.Name != nil {
+		p.Name = *input.Name
+	}
+	if input.Enabled != nil {
+		p.Enabled = *input.Enabled
+	}
+	if input.GraceSeconds != nil {
+		p.GraceSeconds = *input.GraceSeconds
+	}
+	if input.RecoveryTimeoutSeconds != nil {
+		p.RecoveryTimeoutSeconds = *input.RecoveryTimeoutSeconds
+	}
+	return services.ValidateFailoverPolicy(p)
+}
+
+func AdminListFailoverPolicies(c *fiber.Ctx) error {
+	q := database.DB
+	if c.Query("cluster") != "" {
+		cluster, err := findSelectedKubernetesCluster(c)
+		if cluster == nil {
+			return err
+		}
+		q = services.KubernetesClusterScope(q, cluster)
+	}
+	var policies []models.KubernetesFailoverPolicy
+	if err := q.Order("namespace asc, kind asc, name asc").Find(&policies).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve failover policies"})
+	}
+	return c.JSON(policies)
+}
+
+func AdminCreateFailoverPolicy(c *fiber.Ctx) error {
+	var input failoverPolicyInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+
+	policy := models.KubernetesFailoverPolicy{KubernetesClusterID: &cluster.ID, Enabled: true, RecoveryTimeoutSeconds: 900}
+	if err := applyFailoverPolicyInput(&policy, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var existing models.KubernetesFailoverPolicy
+	if err := services.KubernetesClusterScope(database.DB, cluster).Where("namespace = ? AND kind = ? AND name = ?", policy.Namespace, policy.Kind, policy.Name).First(&existing).Error; err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A failover policy for this workload already exists"})
+	}
+
+	// Enabled defaults to true in the schema, so a false value has to be
+	// written explicitly after the insert.
+	enabled := policy.Enabled
+	if err := database.DB.Create(&policy).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create failover policy"})
+	}
+	if !enabled {
+		database.DB.Model(&policy).Update("enabled", false)
+		policy.Enabled = false
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Created failover policy", actorID, "create", "KubernetesFailoverPolicy",
+		"policy_id", policy.ID, "cluster", cluster.Name, "workload", policy.Kind+" "+policy.Namespace+"/"+policy.Name)
+	return c.Status(fiber.StatusCreated).JSON(policy)
+}
+
+func AdminUpdateFailoverPolicy(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid policy id"})
+	}
+	var input failoverPolicyInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	var policy models.KubernetesFailoverPolicy
+	if err := database.DB.First(&policy, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": services.ErrFailoverPolicyNotFound.Error()})
+	}
+	if input.Namespace != nil || input.Kind != nil || input.Name != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The target workload cannot be changed; create a new policy"})
+	}
+	if err := applyFailoverPolicyInput(&policy, input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := database.DB.Model(&policy).Updates(map[string]any{
+		"enabled":                  policy.Enabled,
+		"grace_seconds":            policy.GraceSeconds,
+		"recovery_timeout_seconds": policy.RecoveryTimeoutSeconds,
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update failover policy"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated failover policy", actorID, "update", "KubernetesFailoverPolicy",
+		"policy_id", policy.ID, "enabled", policy.Enabled, "grace_seconds", policy.GraceSeconds)
+	return c.JSON(policy)
+}
+
+func AdminDeleteFailoverPolicy(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid policy id"})
+	}
+	var policy models.KubernetesFailoverPolicy
+	if err := database.DB.First(&policy, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": services.ErrFailoverPolicyNotFound.Error()})
+	}
+	if err := database.DB.Delete(&policy).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete failover policy"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Deleted failover policy", actorID, "delete", "KubernetesFailoverPolicy",
+		"policy_id", policy.ID, "workload", policy.Kind+" "+policy.Namespace+"/"+policy.Name)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// AdminListFailovers returns recent failovers, newest first, with the
+// measured time to recovery of each finished one.
+func AdminListFailovers(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	q := database.DB.Order("id desc").Limit(limit)
+	if raw := c.Query("policy_id"); raw != "" {
+		policyID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid policy id"})
+		}
+		q = q.Where("policy_id = ?", policyID)
+	}
+
+	var failovers []models.KubernetesFailover
+	if err := q.Find(&failovers).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve failovers"})
+	}
+	return c.JSON(failovers)
+}
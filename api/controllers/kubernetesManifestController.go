@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func AdminListManifestRevisions(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var revs []models.KubernetesManifestRevision
+	if err := database.DB.Order("id desc").Limit(limit).Find(&revs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve revisions"})
+	}
+	return c.JSON(revs)
+}
+
+func AdminGetManifestRevision(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid revision id"})
+	}
+	var rev models.KubernetesManifestRevision
+	if err := database.DB.First(&rev, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+	return c.JSON(rev)
+}
+
+// AdminReapplyManifestRevision applies a stored manifest again as a new
+// revision, e.g. to undo manual edits made in the cluster since.
+func AdminReapplyManifestRevision(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid revision id"})
+	}
+	var rev models.KubernetesManifestRevision
+	if err := database.DB.First(&rev, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+	manifest, err := services.ManifestRevisionManifest(&rev)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open the revision's manifest"})
+	}
+	if manifest == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Revision has no manifest to re-apply"})
+	}
+
+	objs, err := kube.DecodeManifest(manifest)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	kc, err := revisionKubeClient(&rev)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	newRev, results, err := services.ApplyManifest(ctx, kc, manifest, objs, services.ManifestApply{
+		Operation:      models.ManifestOperationReapply,
+		ParentID:       &rev.ID,
+		ClusterID:      rev.KubernetesClusterID,
+		ApplicationID:  rev.ApplicationID,
+		SourceRevision: rev.SourceRevision,
+		ActorID:        actorID,
+	})
+	resp := applyManifestResponse{
+		Success: err == nil,
+		Output:  appliedOutput(results),
+		Objects: results,
+	}
+	if newRev != nil {
+		resp.RevisionID = newRev.ID
+		logger.Audit(c, "Re-applied Kubernetes manifest", actorID, "reapply", "KubernetesManifestRevision",
+			"revision_id", newRev.ID, "parent_id", rev.ID, "success", newRev.Success)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return c.JSON(resp)
+}
+
+// AdminRollbackManifestRevision restores the live state captured before a
+// revision was applied: objects it changed are re-applied as they were and
+// objects it created are deleted. The rollback is recorded as a revision
+// too, so it can itself be rolled back.
+func AdminRollbackManifestRevision(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid revision id"})
+	}
+	var rev models.KubernetesManifestRevision
+	if err := database.DB.First(&rev, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+	if rev.RolledBackAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Revision has already been rolled back"})
+	}
+
+	snaps, err := services.ManifestRevisionSnapshots(&rev)
+	if err != nil {
+		logger.Error("Failed to read manifest snapshot", "error", err, "revision_id", rev.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read revision snapshot"})
+	}
+	if len(snaps) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Revision has no snapshot to roll back to"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
+	defer cancel()
+
+	kc, err := revisionKubeClient(&rev)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	current, err := kc.Snapshot(ctx, snapshotObjects(snaps))
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to snapshot live state: " + err.Error()})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	results, restoreErr := kc.Restore(ctx, snaps)
+	newRev := services.RecordManifestRevision(services.ManifestApply{
+		Operation:     models.ManifestOperationRollback,
+		ParentID:      &rev.ID,
+		ClusterID:     rev.KubernetesClusterID,
+		ApplicationID: rev.ApplicationID,
+		ActorID:       actorID,
+	}, "", results, current, restoreErr)
+	if restoreErr == nil {
+		now := time.Now()
+		if err := database.DB.Model(&rev).Update("rolled_back_at", &now).Error; err != nil {
+			logger.Error("Failed to mark manifest revision rolled back", "error", err, "revision_id", rev.ID)
+		}
+	}
+
+	resp := applyManifestResponse{
+		Success: restoreErr == nil,
+		Output:  appliedOutput(results),
+		Objects: results,
+	}
+	if newRev != nil {
+		resp.RevisionID = newRev.ID
+		logger.Audit(c, "Rolled back Kubernetes manifest", actorID, "rollback", "KubernetesManifestRevision",
+			"revision_id", newRev.ID, "parent_id", rev.ID, "success", newRev.Success)
+	}
+	if restoreErr != nil {
+		resp.Error = restoreErr.Error()
+	}
+	return c.JSON(resp)
+}
+
+// revisionKubeClient returns the client for the cluster rev was applied
+// to, which a re-apply or rollback has to target whatever ?cluster says.
+func revisionKubeClient(rev *models.KubernetesManifestRevision) (*kube.Client, error) {
+	cluster, err := services.KubernetesClusterByID(rev.KubernetesClusterID)
+	if err != nil {
+		return nil, err
+	}
+	return services.KubeClientFor(cluster)
+}
+
+// snapshotObjects turns snapshots back into object references so their
+// current state can be snapshotted in turn.
+func snapshotObjects(snaps []kube.ObjectSnapshot) []kube.ManifestObject {
+	objs := make([]kube.ManifestObject, 0, len(snaps))
+	for _, snap := range snaps {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(snap.APIVersion)
+		obj.SetKind(snap.Kind)
+		obj.SetNamespace(snap.Namespace)
+		obj.SetName(snap.Name)
+		objs = append(objs, kube.ManifestObject{Unstructured: obj})
+	}
+	return objs
+}
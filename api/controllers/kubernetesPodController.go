@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// parseLogOptions reads the log query parameters shared by the SSE and
+// WebSocket variants of the logs endpoint.
+func parseLogOptions(query func(string) string) (kube.LogOptions, error) {
+	opts := kube.LogOptions{
+		Container:  query("container"),
+		Follow:     query("follow") == "true" || query("follow") == "1",
+		Previous:   query("previous") == "true" || query("previous") == "1",
+		Timestamps: query("timestamps") == "true" || query("timestamps") == "1",
+	}
+	if opts.Follow && opts.Previous {
+		return opts, fmt.Errorf("follow and previous can't be combined")
+	}
+	if raw := query("tail"); raw != "" {
+		tail, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || tail < 0 {
+			return opts, fmt.Errorf("invalid tail")
+		}
+		opts.TailLines = &tail
+	}
+	if raw := query("since_seconds"); raw != "" {
+		since, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || since <= 0 {
+			return opts, fmt.Errorf("invalid since_seconds")
+		}
+		opts.SinceSeconds = &since
+	}
+	return opts, nil
+}
+
+// logKeepAlive is how often an idle log stream sends an SSE comment, so a
+// client that went away is noticed even when the pod logs nothing.
+const logKeepAlive = 15 * time.Second
+
+// AdminStreamPodLogs streams a pod's logs as server-sent events, one event
+// per line. A WebSocket upgrade is handed to AdminPodLogsSocket instead.
+func AdminStreamPodLogs(c *fiber.Ctx) error {
+	opts, err := parseLogOptions(func(key string) string { return c.Query(key) })
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if websocket.IsWebSocketUpgrade(c) {
+		if !execOriginAllowed(c.Get(fiber.HeaderOrigin), string(c.Request().Host()), config.Current().ExecAllowedOrigins) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Origin not allowed"})
+		}
+		return c.Next()
+	}
+
+	kc, err := services.KubeClientForCluster(c.Query("cluster"))
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	namespace := c.Params("ns")
+	name := c.Params("name")
+
+	// Open the stream before committing to SSE so a missing pod or container
+	// is still reported as a normal error response.
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := kc.StreamLogs(ctx, namespace, name, opts)
+	if err != nil {
+		cancel()
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer stream.Close()
+
+		lines := make(chan string)
+		scanErr := make(chan error, 1)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				select {
+				case lines <- scanner.Text():
+				case <-ctx.Done():
+					return
+				}
+			}
+			scanErr <- scanner.Err()
+		}()
+
+		keepAlive := time.NewTicker(logKeepAlive)
+		defer keepAlive.Stop()
+		for open := true; open; {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					open = false
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", line)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				// Client went away; cancelling ctx ends the log stream.
+				return
+			}
+		}
+		if err := <-scanErr; err != nil && ctx.Err() == nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+		} else {
+			fmt.Fprint(w, "event: end\ndata: \n\n")
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// AdminPodLogsSocket streams a pod's logs over a WebSocket, one text message
+// per line. Closing the socket ends a followed stream.
+func AdminPodLogsSocket(conn *websocket.Conn) {
+	opts, _ := parseLogOptions(func(key string) string { return conn.Query(key) })
+
+	kc, err := services.KubeClientForCluster(conn.Query("cluster"))
+	if err != nil {
+		writeSocketEvent(conn, "error", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readerDone := make(chan struct{})
+	defer func() {
+		// The connection is recycled once the handler returns, so the reader
+		// must be gone by then.
+		conn.Close()
+		<-readerDone
+	}()
+	go func() {
+		defer close(readerDone)
+		// Reads only serve to notice the client closing the socket.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	stream, err := kc.StreamLogs(ctx, conn.Params("ns"), conn.Params("name"), opts)
+	if err != nil {
+		writeSocketEvent(conn, "error", err.Error())
+		return
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		writeSocketEvent(conn, "error", err.Error())
+		return
+	}
+	writeSocketEvent(conn, "end", "")
+}
+
+func writeSocketEvent(conn *websocket.Conn, kind string, message string) {
+	event := map[string]string{"type": kind}
+	if message != "" {
+		event["error"] = message
+	}
+	payload, _ := json.Marshal(event)
+	conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+// execCommand reads the command to run from the query string. Repeated
+// command parameters are taken as argv; a single one is split on spaces.
+func execCommand(c *fiber.Ctx) []string {
+	var args []string
+	for _, v := range c.Context().QueryArgs().PeekMulti("command") {
+		args = append(args, string(v))
+	}
+	if len(args) == 1 {
+		args = strings.Fields(args[0])
+	}
+	if len(args) == 0 {
+		return []string{"sh"}
+	}
+	return args
+}
+
+// execOriginAllowed reports whether a browser at origin may open an exec or
+// logs session on host. Browsers don't apply CORS to WebSockets, so without
+// this any page could open a shell or read pod logs with a logged-in admin's
+// credentials. Requests without an Origin don't come from a browser.
+func execOriginAllowed(origin string, host string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminPodExecUpgrade authorises an exec session before the WebSocket
+// upgrade. Exec requires the pod_exec permission on top of admin access, and
+// every session is audited when it starts and when it ends.
+func AdminPodExecUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "WebSocket upgrade required"})
+	}
+	if !execOriginAllowed(c.Get(fiber.HeaderOrigin), string(c.Request().Host()), config.Current().ExecAllowedOrigins) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Origin not allowed"})
+	}
+
+	user, err := getUserFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if !userHasPermission(user.ID, models.PermissionPodExec) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "The pod_exec permission is required"})
+	}
+	if _, err := services.KubeClientForCluster(c.Query("cluster")); err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	command := execCommand(c)
+	container := c.Query("container")
+	logger.Audit(c, "Pod exec session started", &user.ID, "exec", "Pod",
+		"namespace", c.Params("ns"), "pod", c.Params("name"), "container", container, "command", strings.Join(command, " "))
+
+	c.Locals("execActorID", user.ID)
+	c.Locals("execCommand", command)
+	c.Locals("execContainer", container)
+	c.Locals("execIP", c.IP())
+	c.Locals("execUserAgent", c.Get("User-Agent"))
+	return c.Next()
+}
+
+// execMessage is a client-to-server control message. Binary frames are
+// forwarded to stdin as-is.
+type execMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+type execSizeQueue struct {
+	ctx   context.Context
+	sizes chan remotecommand.TerminalSize
+}
+
+func (q *execSizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.sizes:
+		return &size
+	case <-q.ctx.Done():
+		return nil
+	}
+}
+
+type execOutput struct {
+	conn *websocket.Conn
+}
+
+func (o execOutput) Write(p []byte) (int, error) {
+	if err := o.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// AdminPodExec runs an interactive TTY session in a pod. Terminal output is
+// sent as binary frames; the client sends {"type":"stdin","data":...} and
+// {"type":"resize","cols":...,"rows":...} messages, and gets a final
+// {"type":"exit"} message when the process ends.
+func AdminPodExec(conn *websocket.Conn) {
+	actorID, _ := conn.Locals("execActorID").(uint)
+	command, _ := conn.Locals("execCommand").([]string)
+	container, _ := conn.Locals("execContainer").(string)
+	ip, _ := conn.Locals("execIP").(string)
+	userAgent, _ := conn.Locals("execUserAgent").(string)
+	namespace := conn.Params("ns")
+	name := conn.Params("name")
+	started := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stdin, stdinWriter := io.Pipe()
+	sizes := &execSizeQueue{ctx: ctx, sizes: make(chan remotecommand.TerminalSize, 1)}
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer stdinWriter.Close()
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				cancel()
+				return
+			}
+			if msgType == websocket.BinaryMessage {
+				if _, err := stdinWriter.Write(data); err != nil {
+					return
+				}
+				continue
+			}
+
+			var msg execMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "stdin":
+				if _, err := stdinWriter.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			case "resize":
+				if msg.Cols == 0 || msg.Rows == 0 {
+					continue
+				}
+				select {
+				case sizes.sizes <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var execErr error
+	kc, err := services.KubeClientForCluster(conn.Query("cluster"))
+	if err != nil {
+		execErr = err
+	} else {
+		execErr = kc.StreamExec(ctx, namespace, name, kube.ExecOptions{
+			Container: container,
+			Command:   command,
+			TTY:       true,
+			Stdin:     stdin,
+			Stdout:    execOutput{conn: conn},
+			Resize:    sizes,
+		})
+	}
+	stdin.Close()
+
+	exit := map[string]string{"type": "exit"}
+	if execErr != nil && ctx.Err() == nil {
+		exit["error"] = execErr.Error()
+	}
+	if payload, err := json.Marshal(exit); err == nil {
+		conn.WriteMessage(websocket.TextMessage, payload)
+	}
+
+	args := []any{
+		"namespace", namespace, "pod", name, "container", container,
+		"command", strings.Join(command, " "),
+		"duration_seconds", int64(time.Since(started).Seconds()),
+	}
+	if exit["error"] != "" {
+		args = append(args, "error", exit["error"])
+	}
+	logger.AuditFrom(ip, userAgent, "Pod exec session ended", &actorID, "exec_end", "Pod", args...)
+
+	conn.Close()
+	<-readerDone
+}
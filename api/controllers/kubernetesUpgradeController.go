@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func AdminListKubernetesUpgrades(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	q := database.DB.Preload("Nodes", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") })
+	if c.Query("cluster") != "" {
+		cluster, err := findSelectedKubernetesCluster(c)
+		if cluster == nil {
+			return err
+		}
+		q = services.KubernetesClusterScope(q, cluster)
+	}
+
+	var upgrades []models.KubernetesUpgrade
+	if err := q.Order("id desc").Limit(limit).Find(&upgrades).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve upgrades"})
+	}
+	return c.JSON(upgrades)
+}
+
+func AdminGetKubernetesUpgrade(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upgrade id"})
+	}
+	var upgrade models.KubernetesUpgrade
+	if err := database.DB.
+		Preload("Nodes", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).
+		First(&upgrade, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Upgrade not found"})
+	}
+	return c.JSON(upgrade)
+}
+
+// AdminStartKubernetesUpgrade bumps the cluster's target version and starts
+// a rolling upgrade towards it. The upgrade itself runs in the background.
+func AdminStartKubernetesUpgrade(c *fiber.Ctx) error {
+	var input struct {
+		Version string `json:"version"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	version := strings.TrimSpace(input.Version)
+	if version == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "version is required"})
+	}
+
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+	kc, err := services.KubeClientForSynced(ctx, cluster)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	upgrade, err := services.StartKubernetesUpgrade(kc, cluster, version, actorID)
+	if errors.Is(err, services.ErrUpgradeInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	logger.Audit(c, "Started Kubernetes upgrade", actorID, "upgrade", "KubernetesUpgrade",
+		"upgrade_id", upgrade.ID, "cluster", cluster.Name, "from", upgrade.FromVersion, "to", upgrade.ToVersion)
+	return c.Status(fiber.StatusCreated).JSON(upgrade)
+}
+
+func AdminRetryKubernetesUpgrade(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upgrade id"})
+	}
+
+	upgrade, err := services.RetryKubernetesUpgrade(uint(id))
+	switch {
+	case errors.Is(err, services.ErrUpgradeNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Upgrade not found"})
+	case err != nil:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Retried Kubernetes upgrade", actorID, "retry", "KubernetesUpgrade", "upgrade_id", upgrade.ID)
+	return c.JSON(upgrade)
+}
+
+func AdminCancelKubernetesUpgrade(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upgrade id"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	upgrade, err := services.CancelKubernetesUpgrade(ctx, uint(id))
+	switch {
+	case errors.Is(err, services.ErrUpgradeNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Upgrade not found"})
+	case err != nil:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Cancelled Kubernetes upgrade", actorID, "cancel", "KubernetesUpgrade", "upgrade_id", upgrade.ID)
+	return c.JSON(upgrade)
+}
@@ -5,130 +5,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-type k8sObjectMeta struct {
-	Name              string    `json:"name"`
-	Namespace         string    `json:"namespace"`
-	CreationTimestamp time.Time `json:"creationTimestamp"`
-}
-
-type k8sList[T any] struct {
-	Items []T `json:"items"`
-}
-
-type k8sDeployment struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		Replicas *int32 `json:"replicas"`
-		Template struct {
-			Spec struct {
-				Containers []struct {
-					Image string `json:"image"`
-				} `json:"containers"`
-			} `json:"spec"`
-		} `json:"template"`
-	} `json:"spec"`
-	Status struct {
-		Replicas        *int32 `json:"replicas"`
-		ReadyReplicas   *int32 `json:"readyReplicas"`
-		UpdatedReplicas *int32 `json:"updatedReplicas"`
-	} `json:"status"`
-}
-
-type k8sStatefulSet struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		Replicas *int32 `json:"replicas"`
-		Template struct {
-			Spec struct {
-				Containers []struct {
-					Image string `json:"image"`
-				} `json:"containers"`
-			} `json:"spec"`
-		} `json:"template"`
-	} `json:"spec"`
-	Status struct {
-		Replicas      *int32 `json:"replicas"`
-		ReadyReplicas *int32 `json:"readyReplicas"`
-	} `json:"status"`
-}
-
-type k8sDaemonSet struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		Template struct {
-			Spec struct {
-				Containers []struct {
-					Image string `json:"image"`
-				} `json:"containers"`
-			} `json:"spec"`
-		} `json:"template"`
-	} `json:"spec"`
-	Status struct {
-		DesiredNumberScheduled *int32 `json:"desiredNumberScheduled"`
-		NumberReady            *int32 `json:"numberReady"`
-	} `json:"status"`
-}
-
-type k8sJob struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Status   struct {
-		Active    *int32 `json:"active"`
-		Succeeded *int32 `json:"succeeded"`
-		Failed    *int32 `json:"failed"`
-	} `json:"status"`
-}
-
-type k8sPod struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		NodeName   string `json:"nodeName"`
-		Containers []struct {
-			Image string `json:"image"`
-			Name  string `json:"name"`
-		} `json:"containers"`
-	} `json:"spec"`
-	Status struct {
-		Phase             string `json:"phase"`
-		Reason            string `json:"reason"`
-		Message           string `json:"message"`
-		ContainerStatuses []struct {
-			Name         string `json:"name"`
-			RestartCount int32  `json:"restartCount"`
-			State        struct {
-				Waiting struct {
-					Reason  string `json:"reason"`
-					Message string `json:"message"`
-				} `json:"waiting"`
-				Terminated struct {
-					Reason  string `json:"reason"`
-					Message string `json:"message"`
-				} `json:"terminated"`
-			} `json:"state"`
-			LastState struct {
-				Terminated struct {
-					Reason  string `json:"reason"`
-					Message string `json:"message"`
-				} `json:"terminated"`
-			} `json:"lastState"`
-		} `json:"containerStatuses"`
-		Conditions []struct {
-			Type   string `json:"type"`
-			Status string `json:"status"`
-			Reason string `json:"reason"`
-		} `json:"conditions"`
-	} `json:"status"`
-}
-
 type workloadNamespaceSummary struct {
 	Namespace string `json:"namespace"`
 
@@ -195,31 +88,36 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(c.Context(), 8*time.Second)
 	defer cancel()
 
-	var deployments k8sList[k8sDeployment]
-	if err := kubectlJSON(ctx, []string{"get", "deployments", "-A", "-o", "json"}, &deployments); err != nil {
+	kc, err := services.KubeClientForClusterSynced(ctx, c.Query("cluster"))
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	var daemonsets k8sList[k8sDaemonSet]
-	if err := kubectlJSON(ctx, []string{"get", "daemonsets", "-A", "-o", "json"}, &daemonsets); err != nil {
+	deployments, err := kc.Deployments.List(labels.Everything())
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
-
-	var statefulsets k8sList[k8sStatefulSet]
-	if err := kubectlJSON(ctx, []string{"get", "statefulsets", "-A", "-o", "json"}, &statefulsets); err != nil {
+	daemonsets, err := kc.DaemonSets.List(labels.Everything())
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
-
-	var jobs k8sList[k8sJob]
-	if err := kubectlJSON(ctx, []string{"get", "jobs", "-A", "-o", "json"}, &jobs); err != nil {
+	statefulsets, err := kc.StatefulSets.List(labels.Everything())
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
-
-	var pods k8sList[k8sPod]
-	if err := kubectlJSON(ctx, []string{"get", "pods", "-A", "-o", "json"}, &pods); err != nil {
+	jobs, err := kc.Jobs.List(labels.Everything())
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+	pods, err := kc.Pods.List(labels.Everything())
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	return c.JSON(summarizeWorkloads(time.Now(), deployments, daemonsets, statefulsets, jobs, pods))
+}
+
+func summarizeWorkloads(now time.Time, deployments []*appsv1.Deployment, daemonsets []*appsv1.DaemonSet, statefulsets []*appsv1.StatefulSet, jobs []*batchv1.Job, pods []*corev1.Pod) kubernetesWorkloadsResponse {
 	byNS := map[string]*workloadNamespaceSummary{}
 	getNS := func(ns string) *workloadNamespaceSummary {
 		if ns == "" {
@@ -233,11 +131,11 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		return v
 	}
 
-	for _, d := range deployments.Items {
-		ns := getNS(d.Metadata.Namespace)
+	for _, d := range deployments {
+		ns := getNS(d.Namespace)
 		ns.DeploymentsTotal++
 		desired := derefInt32(d.Spec.Replicas)
-		ready := derefInt32(d.Status.ReadyReplicas)
+		ready := d.Status.ReadyReplicas
 		if desired == 0 && ready == 0 {
 			
 		} else if ready >= desired {
@@ -245,11 +143,11 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		}
 	}
 
-	for _, ds := range daemonsets.Items {
-		ns := getNS(ds.Metadata.Namespace)
+	for _, ds := range daemonsets {
+		ns := getNS(ds.Namespace)
 		ns.DaemonSetsTotal++
-		desired := derefInt32(ds.Status.DesiredNumberScheduled)
-		ready := derefInt32(ds.Status.NumberReady)
+		desired := ds.Status.DesiredNumberScheduled
+		ready := ds.Status.NumberReady
 		if desired == 0 && ready == 0 {
 			
 		} else if ready >= desired {
@@ -257,11 +155,11 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		}
 	}
 
-	for _, s := range statefulsets.Items {
-		ns := getNS(s.Metadata.Namespace)
+	for _, s := range statefulsets {
+		ns := getNS(s.Namespace)
 		ns.StatefulSetsTotal++
 		desired := derefInt32(s.Spec.Replicas)
-		ready := derefInt32(s.Status.ReadyReplicas)
+		ready := s.Status.ReadyReplicas
 		if desired == 0 && ready == 0 {
 			
 		} else if ready >= desired {
@@ -269,29 +167,29 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		}
 	}
 
-	for _, j := range jobs.Items {
-		ns := getNS(j.Metadata.Namespace)
+	for _, j := range jobs {
+		ns := getNS(j.Namespace)
 		ns.JobsTotal++
-		ns.JobsActive += int(derefInt32(j.Status.Active))
-		ns.JobsSucceeded += int(derefInt32(j.Status.Succeeded))
-		ns.JobsFailed += int(derefInt32(j.Status.Failed))
+		ns.JobsActive += int(j.Status.Active)
+		ns.JobsSucceeded += int(j.Status.Succeeded)
+		ns.JobsFailed += int(j.Status.Failed)
 	}
 
 	nodeCounts := map[string]*workloadNodeSummary{}
 	var unhealthy []workloadPodIssue
 
-	for _, p := range pods.Items {
-		ns := getNS(p.Metadata.Namespace)
+	for _, p := range pods {
+		ns := getNS(p.Namespace)
 		ns.PodsTotal++
 
-		switch strings.ToLower(strings.TrimSpace(p.Status.Phase)) {
-		case "running":
+		switch p.Status.Phase {
+		case corev1.PodRunning:
 			ns.PodsRunning++
-		case "pending":
+		case corev1.PodPending:
 			ns.PodsPending++
-		case "succeeded":
+		case corev1.PodSucceeded:
 			ns.PodsSucceeded++
-		case "failed":
+		case corev1.PodFailed:
 			ns.PodsFailed++
 		default:
 			
@@ -302,13 +200,13 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		waitingMessage := ""
 		for _, cs := range p.Status.ContainerStatuses {
 			restarts += int(cs.RestartCount)
-			if cs.State.Waiting.Reason != "" && waitingReason == "" {
-				waitingReason = cs.State.Waiting.Reason
-				waitingMessage = cs.State.Waiting.Message
+			if w := cs.State.Waiting; w != nil && w.Reason != "" && waitingReason == "" {
+				waitingReason = w.Reason
+				waitingMessage = w.Message
 			}
-			if cs.State.Terminated.Reason != "" && waitingReason == "" {
-				waitingReason = cs.State.Terminated.Reason
-				waitingMessage = cs.State.Terminated.Message
+			if t := cs.State.Terminated; t != nil && t.Reason != "" && waitingReason == "" {
+				waitingReason = t.Reason
+				waitingMessage = t.Message
 			}
 		}
 		ns.RestartsTotal += restarts
@@ -321,9 +219,9 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 			nodeCounts[nodeName].Pods++
 		}
 
-		ready := podReady(&p)
-		phaseLower := strings.ToLower(strings.TrimSpace(p.Status.Phase))
-		unhealthyPod := phaseLower == "failed" || phaseLower == "pending" || (phaseLower == "running" && !ready)
+		ready := podReady(p)
+		phase := p.Status.Phase
+		unhealthyPod := phase == corev1.PodFailed || phase == corev1.PodPending || (phase == corev1.PodRunning && !ready)
 		if unhealthyPod {
 			ns.PodsUnhealthy++
 			reason := strings.TrimSpace(p.Status.Reason)
@@ -334,25 +232,14 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 					msg = waitingMessage
 				}
 			}
-			imagesSet := map[string]struct{}{}
-			for _, c := range p.Spec.Containers {
-				img := strings.TrimSpace(c.Image)
-				if img == "" {
-					continue
-				}
-				imagesSet[img] = struct{}{}
-			}
-			images := make([]string, 0, len(imagesSet))
-			for img := range imagesSet {
-				images = append(images, img)
-			}
+			images := extractImages(p.Spec.Containers)
 			sort.Strings(images)
-			age := time.Since(p.Metadata.CreationTimestamp)
+			age := now.Sub(p.CreationTimestamp.Time)
 			issue := workloadPodIssue{
-				Namespace: p.Metadata.Namespace,
-				Name:      p.Metadata.Name,
+				Namespace: p.Namespace,
+				Name:      p.Name,
 				Node:      nodeName,
-				Phase:     p.Status.Phase,
+				Phase:     string(p.Status.Phase),
 				Reason:    reason,
 				Message:   msg,
 				Images:    images,
@@ -406,37 +293,34 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 
 	
 	var resources []workloadResourceInfo
-	for _, d := range deployments.Items {
-		images := extractImages(d.Spec.Template.Spec.Containers)
+	for _, d := range deployments {
 		resources = append(resources, workloadResourceInfo{
-			Namespace:  d.Metadata.Namespace,
-			Name:       d.Metadata.Name,
+			Namespace:  d.Namespace,
+			Name:       d.Name,
 			Kind:       "Deployment",
-			Ready:      fmt.Sprintf("%d/%d", derefInt32(d.Status.ReadyReplicas), derefInt32(d.Spec.Replicas)),
-			Images:     images,
-			AgeSeconds: int64(time.Since(d.Metadata.CreationTimestamp).Seconds()),
+			Ready:      fmt.Sprintf("%d/%d", d.Status.ReadyReplicas, derefInt32(d.Spec.Replicas)),
+			Images:     extractImages(d.Spec.Template.Spec.Containers),
+			AgeSeconds: int64(now.Sub(d.CreationTimestamp.Time).Seconds()),
 		})
 	}
-	for _, ds := range daemonsets.Items {
-		images := extractImages(ds.Spec.Template.Spec.Containers)
+	for _, ds := range daemonsets {
 		resources = append(resources, workloadResourceInfo{
-			Namespace:  ds.Metadata.Namespace,
-			Name:       ds.Metadata.Name,
+			Namespace:  ds.Namespace,
+			Name:       ds.Name,
 			Kind:       "DaemonSet",
-			Ready:      fmt.Sprintf("%d/%d", derefInt32(ds.Status.NumberReady), derefInt32(ds.Status.DesiredNumberScheduled)),
-			Images:     images,
-			AgeSeconds: int64(time.Since(ds.Metadata.CreationTimestamp).Seconds()),
+			Ready:      fmt.Sprintf("%d/%d", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled),
+			Images:     extractImages(ds.Spec.Template.Spec.Containers),
+			AgeSeconds: int64(now.Sub(ds.CreationTimestamp.Time).Seconds()),
 		})
 	}
-	for _, s := range statefulsets.Items {
-		images := extractImages(s.Spec.Template.Spec.Containers)
+	for _, s := range statefulsets {
 		resources = append(resources, workloadResourceInfo{
-			Namespace:  s.Metadata.Namespace,
-			Name:       s.Metadata.Name,
+			Namespace:  s.Namespace,
+			Name:       s.Name,
 			Kind:       "StatefulSet",
-			Ready:      fmt.Sprintf("%d/%d", derefInt32(s.Status.ReadyReplicas), derefInt32(s.Spec.Replicas)),
-			Images:     images,
-			AgeSeconds: int64(time.Since(s.Metadata.CreationTimestamp).Seconds()),
+			Ready:      fmt.Sprintf("%d/%d", s.Status.ReadyReplicas, derefInt32(s.Spec.Replicas)),
+			Images:     extractImages(s.Spec.Template.Spec.Containers),
+			AgeSeconds: int64(now.Sub(s.CreationTimestamp.Time).Seconds()),
 		})
 	}
 
@@ -450,18 +334,16 @@ func AdminGetKubernetesWorkloads(c *fiber.Ctx) error {
 		return resources[i].Name < resources[j].Name
 	})
 
-	return c.JSON(kubernetesWorkloadsResponse{
-		GeneratedAt:   time.Now(),
+	return kubernetesWorkloadsResponse{
+		GeneratedAt:   now,
 		Namespaces:    namespaces,
 		Nodes:         nodes,
 		UnhealthyPods: unhealthy,
 		Resources:     resources,
-	})
+	}
 }
 
-func extractImages(containers []struct {
-	Image string `json:"image"`
-}) []string {
+func extractImages(containers []corev1.Container) []string {
 	var images []string
 	seen := map[string]bool{}
 	for _, c := range containers {
@@ -481,189 +363,142 @@ func derefInt32(v *int32) int32 {
 	return *v
 }
 
-func podReady(p *k8sPod) bool {
+func podReady(p *corev1.Pod) bool {
 	for _, c := range p.Status.Conditions {
-		if c.Type == "Ready" {
-			return strings.ToLower(c.Status) == "true"
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
 		}
 	}
 	return false
 }
 
-func kubectlJSON(ctx context.Context, args []string, out any) error {
-	kubeconfig := strings.TrimSpace(os.Getenv("GLUON_KUBECONFIG"))
-	if kubeconfig == "" {
-		kubeconfig = "/etc/kubernetes/admin.conf"
-	}
-
-	cmdArgs := append([]string{"--kubeconfig", kubeconfig}, args...)
-	cmd := exec.CommandContext(ctx, "kubectl", cmdArgs...)
-	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfig)
-	b, err := cmd.CombinedOutput()
-	if err != nil {
-		msg := strings.TrimSpace(string(b))
-		if msg == "" {
-			msg = err.Error()
-		}
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return fmt.Errorf("kubectl timeout")
-		}
-		return fmt.Errorf("kubectl failed: %s", msg)
-	}
-	if err := json.Unmarshal(b, out); err != nil {
-		return fmt.Errorf("failed to parse kubectl output: %w", err)
-	}
-	return nil
-}
-
-func kubectlRaw(ctx context.Context, args []string) (string, error) {
-	kubeconfig := strings.TrimSpace(os.Getenv("GLUON_KUBECONFIG"))
-	if kubeconfig == "" {
-		kubeconfig = "/etc/kubernetes/admin.conf"
-	}
-
-	cmdArgs := append([]string{"--kubeconfig", kubeconfig}, args...)
-	cmd := exec.CommandContext(ctx, "kubectl", cmdArgs...)
-	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfig)
-	b, err := cmd.CombinedOutput()
-	output := strings.TrimSpace(string(b))
-	if err != nil {
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return output, fmt.Errorf("kubectl timeout")
-		}
-		if output == "" {
-			output = err.Error()
-		}
-		return output, fmt.Errorf("kubectl failed: %s", output)
-	}
-	return output, nil
-}
-
 type applyManifestInput struct {
-	YAML string `json:"yaml"`
+	YAML   string `json:"yaml"`
+	DryRun bool   `json:"dry_run"`
 }
 
 type applyManifestResponse struct {
-	Success bool   `json:"success"`
-	Output  string `json:"output"`
-	Error   string `json:"error,omitempty"`
-}
-
+	Success    bool                 `json:"success"`
+	Output     string               `json:"output"`
+	Error      string               `json:"error,omitempty"`
+	DryRun     bool                 `json:"dry_run,omitempty"`
+	RevisionID uint                 `json:"revision_id,omitempty"`
+	Objects    []kube.AppliedObject `json:"objects,omitempty"`
+	Diff       []kube.ObjectDiff    `json:"diff,omitempty"`
+	Errors     []kube.DocumentError `json:"errors,omitempty"`
+}
+
+// AdminApplyKubernetesManifest server-side applies a manifest and records it
+// as a revision. With dry_run set nothing is changed; the response carries a
+// per-object diff against live state instead.
 func AdminApplyKubernetesManifest(c *fiber.Ctx) error {
 	var input applyManifestInput
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON"})
 	}
 
-	yaml := strings.TrimSpace(input.YAML)
-	if yaml == "" {
+	manifest := strings.TrimSpace(input.YAML)
+	if manifest == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "YAML content is required"})
 	}
 
-	
-	tmpFile, err := os.CreateTemp("", "kubectl-apply-*.yaml")
+	objs, err := kube.DecodeManifest(manifest)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create temp file"})
+		resp := applyManifestResponse{Success: false, DryRun: input.DryRun, Error: err.Error()}
+		var manifestErr kube.ManifestError
+		if errors.As(err, &manifestErr) {
+			resp.Errors = manifestErr
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+	if len(objs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Manifest contains no objects"})
 	}
-	defer os.Remove(tmpFile.Name())
 
-	if _, err := tmpFile.WriteString(yaml); err != nil {
-		tmpFile.Close()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to write temp file"})
+	cluster, err := findSelectedKubernetesCluster(c)
+	if cluster == nil {
+		return err
 	}
-	tmpFile.Close()
 
-	
 	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
 	defer cancel()
 
-	output, err := kubectlRaw(ctx, []string{"apply", "-f", tmpFile.Name()})
+	kc, err := services.KubeClientFor(cluster)
 	if err != nil {
 		return c.JSON(applyManifestResponse{
 			Success: false,
-			Output:  output,
+			DryRun:  input.DryRun,
 			Error:   err.Error(),
 		})
 	}
 
-	return c.JSON(applyManifestResponse{
-		Success: true,
-		Output:  output,
-	})
-}
+	if input.DryRun {
+		return c.JSON(dryRunResponse(kc.Diff(ctx, objs)))
+	}
 
-type getResourceYAMLResponse struct {
-	YAML  string `json:"yaml"`
-	Error string `json:"error,omitempty"`
-}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
 
-type k8sResourceWithAnnotations struct {
-	Metadata struct {
-		Annotations map[string]string `json:"annotations"`
-	} `json:"metadata"`
+	rev, results, err := services.ApplyManifest(ctx, kc, manifest, objs, services.ManifestApply{
+		Operation: models.ManifestOperationApply,
+		ClusterID: &cluster.ID,
+		ActorID:   actorID,
+	})
+	resp := applyManifestResponse{
+		Success: err == nil,
+		Output:  appliedOutput(results),
+		Objects: results,
+	}
+	if rev != nil {
+		resp.RevisionID = rev.ID
+		logger.Audit(c, "Applied Kubernetes manifest", actorID, "apply", "KubernetesManifestRevision",
+			"revision_id", rev.ID, "objects", len(objs), "success", rev.Success)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return c.JSON(resp)
+}
+
+func dryRunResponse(diffs []kube.ObjectDiff) applyManifestResponse {
+	resp := applyManifestResponse{Success: true, DryRun: true, Diff: diffs}
+	lines := make([]string, 0, len(diffs))
+	var failed []string
+	for _, d := range diffs {
+		ref := strings.ToLower(d.Kind) + "/" + d.Name
+		switch d.Action {
+		case kube.DiffError:
+			lines = append(lines, ref+" failed: "+d.Error)
+			failed = append(failed, fmt.Sprintf("document %d: %s: %s", d.Document, ref, d.Error))
+		case kube.DiffUpdate:
+			lines = append(lines, fmt.Sprintf("%s configured (dry run, %d changes)", ref, len(d.Changes)))
+		case kube.DiffCreate:
+			lines = append(lines, ref+" created (dry run)")
+		default:
+			lines = append(lines, ref+" unchanged (dry run)")
+		}
+	}
+	resp.Output = strings.Join(lines, "\n")
+	if len(failed) > 0 {
+		resp.Success = false
+		resp.Error = strings.Join(failed, "; ")
+	}
+	return resp
 }
 
-
-type k8sService struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		Type         string `json:"type"`
-		ClusterIP    string `json:"clusterIP"`
-		ExternalIP   string `json:"externalIP,omitempty"`
-		Ports        []struct {
-			Name       string `json:"name,omitempty"`
-			Port       int32  `json:"port"`
-			TargetPort any    `json:"targetPort"` 
-			NodePort   int32  `json:"nodePort,omitempty"`
-			Protocol   string `json:"protocol"`
-		} `json:"ports"`
-		Selector map[string]string `json:"selector,omitempty"`
-	} `json:"spec"`
-	Status struct {
-		LoadBalancer struct {
-			Ingress []struct {
-				IP       string `json:"ip,omitempty"`
-				Hostname string `json:"hostname,omitempty"`
-			} `json:"ingress,omitempty"`
-		} `json:"loadBalancer,omitempty"`
-	} `json:"status"`
+func appliedOutput(results []kube.AppliedObject) string {
+	lines := make([]string, 0, len(results))
+	for _, r := range results {
+		lines = append(lines, r.String())
+	}
+	return strings.Join(lines, "\n")
 }
 
-type k8sIngress struct {
-	Metadata k8sObjectMeta `json:"metadata"`
-	Spec     struct {
-		IngressClassName *string `json:"ingressClassName,omitempty"`
-		TLS              []struct {
-			Hosts      []string `json:"hosts,omitempty"`
-			SecretName string   `json:"secretName,omitempty"`
-		} `json:"tls,omitempty"`
-		Rules []struct {
-			Host string `json:"host,omitempty"`
-			HTTP *struct {
-				Paths []struct {
-					Path     string `json:"path,omitempty"`
-					PathType string `json:"pathType,omitempty"`
-					Backend  struct {
-						Service *struct {
-							Name string `json:"name"`
-							Port struct {
-								Number int32  `json:"number,omitempty"`
-								Name   string `json:"name,omitempty"`
-							} `json:"port"`
-						} `json:"service,omitempty"`
-					} `json:"backend"`
-				} `json:"paths,omitempty"`
-			} `json:"http,omitempty"`
-		} `json:"rules,omitempty"`
-	} `json:"spec"`
-	Status struct {
-		LoadBalancer struct {
-			Ingress []struct {
-				IP       string `json:"ip,omitempty"`
-				Hostname string `json:"hostname,omitempty"`
-			} `json:"ingress,omitempty"`
-		} `json:"loadBalancer,omitempty"`
-	} `json:"status"`
+type getResourceYAMLResponse struct {
+	YAML  string `json:"yaml"`
+	Error string `json:"error,omitempty"`
 }
 
 type serviceInfo struct {
@@ -715,25 +550,34 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(c.Context(), 8*time.Second)
 	defer cancel()
 
-	var services k8sList[k8sService]
-	if err := kubectlJSON(ctx, []string{"get", "services", "-A", "-o", "json"}, &services); err != nil {
+	kc, err := services.KubeClientForClusterSynced(ctx, c.Query("cluster"))
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	services, err := kc.Services.List(labels.Everything())
+	if err != nil {
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	var ingresses k8sList[k8sIngress]
-	if err := kubectlJSON(ctx, []string{"get", "ingresses", "-A", "-o", "json"}, &ingresses); err != nil {
+	ingresses, err := kc.Ingresses.List(labels.Everything())
+	if err != nil {
 		
-		ingresses = k8sList[k8sIngress]{}
+		ingresses = nil
 	}
 
-	
+	return c.JSON(summarizeNetworking(time.Now(), services, ingresses))
+}
+
+func summarizeNetworking(now time.Time, services []*corev1.Service, ingresses []*networkingv1.Ingress) kubernetesNetworkingResponse {
 	var svcList []serviceInfo
-	for _, s := range services.Items {
+	for _, s := range services {
 		var ports []string
 		for _, p := range s.Spec.Ports {
-			portStr := fmt.Sprintf("%d/%s", p.Port, strings.ToUpper(p.Protocol))
+			protocol := strings.ToUpper(string(p.Protocol))
+			portStr := fmt.Sprintf("%d/%s", p.Port, protocol)
 			if p.NodePort > 0 {
-				portStr = fmt.Sprintf("%d:%d/%s", p.Port, p.NodePort, strings.ToUpper(p.Protocol))
+				portStr = fmt.Sprintf("%d:%d/%s", p.Port, p.NodePort, protocol)
 			}
 			ports = append(ports, portStr)
 		}
@@ -748,13 +592,13 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 		}
 
 		svcList = append(svcList, serviceInfo{
-			Namespace:  s.Metadata.Namespace,
-			Name:       s.Metadata.Name,
-			Type:       s.Spec.Type,
+			Namespace:  s.Namespace,
+			Name:       s.Name,
+			Type:       string(s.Spec.Type),
 			ClusterIP:  s.Spec.ClusterIP,
 			ExternalIP: externalIP,
 			Ports:      ports,
-			AgeSeconds: int64(time.Since(s.Metadata.CreationTimestamp).Seconds()),
+			AgeSeconds: int64(now.Sub(s.CreationTimestamp.Time).Seconds()),
 		})
 	}
 
@@ -767,7 +611,7 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 
 	
 	var ingList []ingressDetailInfo
-	for _, ing := range ingresses.Items {
+	for _, ing := range ingresses {
 		ingressClass := ""
 		if ing.Spec.IngressClassName != nil {
 			ingressClass = *ing.Spec.IngressClassName
@@ -804,10 +648,14 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 						servicePort = path.Backend.Service.Port.Name
 					}
 				}
+				pathType := ""
+				if path.PathType != nil {
+					pathType = string(*path.PathType)
+				}
 				rules = append(rules, ingressRuleInfo{
 					Host:        rule.Host,
 					Path:        path.Path,
-					PathType:    path.PathType,
+					PathType:    pathType,
 					ServiceName: serviceName,
 					ServicePort: servicePort,
 				})
@@ -815,14 +663,14 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 		}
 
 		ingList = append(ingList, ingressDetailInfo{
-			Namespace:    ing.Metadata.Namespace,
-			Name:         ing.Metadata.Name,
+			Namespace:    ing.Namespace,
+			Name:         ing.Name,
 			IngressClass: ingressClass,
 			TLS:          hasTLS,
 			TLSHosts:     tlsHosts,
 			Rules:        rules,
 			Address:      address,
-			AgeSeconds:   int64(time.Since(ing.Metadata.CreationTimestamp).Seconds()),
+			AgeSeconds:   int64(now.Sub(ing.CreationTimestamp.Time).Seconds()),
 		})
 	}
 
@@ -833,11 +681,11 @@ func AdminGetKubernetesNetworking(c *fiber.Ctx) error {
 		return ingList[i].Name < ingList[j].Name
 	})
 
-	return c.JSON(kubernetesNetworkingResponse{
-		GeneratedAt: time.Now(),
+	return kubernetesNetworkingResponse{
+		GeneratedAt: now,
 		Services:    svcList,
 		Ingresses:   ingList,
-	})
+	}
 }
 
 func AdminGetKubernetesResourceYAML(c *fiber.Ctx) error {
@@ -849,53 +697,50 @@ func AdminGetKubernetesResourceYAML(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "namespace, kind, and name are required"})
 	}
 
-	
-	validKinds := map[string]bool{
-		"deployment":  true,
-		"statefulset": true,
-		"daemonset":   true,
-		"service":     true,
-		"configmap":   true,
-		"secret":      true,
-		"ingress":     true,
-		"job":         true,
-		"cronjob":     true,
-		"pod":         true,
-	}
 	kindLower := strings.ToLower(kind)
-	if !validKinds[kindLower] {
+	if _, ok := kube.ResourceKinds[kindLower]; !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid resource kind"})
 	}
 
 	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
 	defer cancel()
 
-	output, err := kubectlRaw(ctx, []string{"get", kindLower, name, "-n", namespace, "-o", "yaml"})
+	kc, err := services.KubeClientForCluster(c.Query("cluster"))
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(getResourceYAMLResponse{
+			Error: err.Error(),
+		})
+	}
+
+	obj, err := kc.Get(ctx, kindLower, namespace, name)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(getResourceYAMLResponse{
-			Error: output,
+			Error: err.Error(),
 		})
 	}
 
-	var resource k8sResourceWithAnnotations
-	if err := yaml.Unmarshal([]byte(output), &resource); err == nil {
-		if applied := strings.TrimSpace(resource.Metadata.Annotations["kubectl.kubernetes.io/last-applied-configuration"]); applied != "" {
-			var appliedObj any
-			if err := json.Unmarshal([]byte(applied), &appliedObj); err == nil {
-				if formatted, err := yaml.Marshal(appliedObj); err == nil {
-					return c.JSON(getResourceYAMLResponse{
-						YAML: string(formatted),
-					})
-				}
+	if applied := strings.TrimSpace(obj.GetAnnotations()["kubectl.kubernetes.io/last-applied-configuration"]); applied != "" {
+		var appliedObj any
+		if err := json.Unmarshal([]byte(applied), &appliedObj); err == nil {
+			if formatted, err := yaml.Marshal(appliedObj); err == nil {
+				return c.JSON(getResourceYAMLResponse{
+					YAML: string(formatted),
+				})
 			}
-			return c.JSON(getResourceYAMLResponse{
-				YAML: applied,
-			})
 		}
+		return c.JSON(getResourceYAMLResponse{
+			YAML: applied,
+		})
 	}
 
+	formatted, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(getResourceYAMLResponse{
+			Error: "Failed to render resource",
+		})
+	}
 	return c.JSON(getResourceYAMLResponse{
-		YAML: output,
+		YAML: string(formatted),
 	})
 }
 
@@ -914,28 +759,23 @@ func AdminDeleteKubernetesResource(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "namespace, kind, and name are required"})
 	}
 
-	
-	validKinds := map[string]bool{
-		"deployment":  true,
-		"statefulset": true,
-		"daemonset":   true,
-		"service":     true,
-		"configmap":   true,
-		"secret":      true,
-		"ingress":     true,
-		"job":         true,
-		"cronjob":     true,
-		"pod":         true,
-	}
 	kindLower := strings.ToLower(kind)
-	if !validKinds[kindLower] {
+	if _, ok := kube.ResourceKinds[kindLower]; !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid resource kind"})
 	}
 
 	ctx, cancel := context.WithTimeout(c.Context(), 30*time.Second)
 	defer cancel()
 
-	output, err := kubectlRaw(ctx, []string{"delete", kindLower, name, "-n", namespace})
+	kc, err := services.KubeClientForCluster(c.Query("cluster"))
+	if err != nil {
+		return c.JSON(deleteResourceResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	output, err := kc.Delete(ctx, kindLower, namespace, name)
 	if err != nil {
 		return c.JSON(deleteResourceResponse{
 			Success: false,
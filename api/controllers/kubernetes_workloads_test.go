@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSummarizeWorkloads(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	created := metav1.NewTime(now.Add(-time.Hour))
+	replicas := int32(2)
+
+	deployments := []*appsv1.Deployment{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend", CreationTimestamp: created},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:1"}}}},
+		},
+		Status: appsv1.DeploymentStatus{ReadyReplicas: 2},
+	}}
+	jobs := []*batchv1.Job{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "migrate"},
+		Status:     batchv1.JobStatus{Succeeded: 1},
+	}}
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend-a", CreationTimestamp: created},
+			Spec:       corev1.PodSpec{NodeName: "worker1"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend-b", CreationTimestamp: created},
+			Spec:       corev1.PodSpec{NodeName: "worker1", Containers: []corev1.Container{{Image: "nginx:1"}}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					RestartCount: 3,
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+						Reason: "CrashLoopBackOff", Message: "back-off",
+					}},
+				}},
+			},
+		},
+	}
+
+	resp := summarizeWorkloads(now, deployments, nil, nil, jobs, pods)
+
+	require.Len(t, resp.Namespaces, 1)
+	ns := resp.Namespaces[0]
+	assert.Equal(t, "web", ns.Namespace)
+	assert.Equal(t, 1, ns.DeploymentsReady)
+	assert.Equal(t, 1, ns.JobsSucceeded)
+	assert.Equal(t, 2, ns.PodsRunning)
+	assert.Equal(t, 1, ns.PodsUnhealthy)
+	assert.Equal(t, 3, ns.RestartsTotal)
+
+	require.Len(t, resp.UnhealthyPods, 1)
+	issue := resp.UnhealthyPods[0]
+	assert.Equal(t, "frontend-b", issue.Name)
+	assert.Equal(t, "CrashLoopBackOff", issue.Reason)
+	assert.Equal(t, []string{"nginx:1"}, issue.Images)
+	assert.Equal(t, int64(3600), issue.AgeSeconds)
+
+	assert.Equal(t, []workloadNodeSummary{{Node: "worker1", Pods: 2, UnhealthyPods: 1}}, resp.Nodes)
+
+	require.Len(t, resp.Resources, 1)
+	assert.Equal(t, "2/2", resp.Resources[0].Ready)
+}
+
+func TestSummarizeNetworking(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	prefix := networkingv1.PathTypePrefix
+
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeNodePort,
+			ClusterIP: "10.96.0.10",
+			Ports:     []corev1.ServicePort{{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}}
+	ingresses := []*networkingv1.Ingress{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "example.com",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &prefix,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: "frontend",
+							Port: networkingv1.ServiceBackendPort{Number: 80},
+						}},
+					}},
+				}},
+			}},
+		},
+	}}
+
+	resp := summarizeNetworking(now, services, ingresses)
+
+	require.Len(t, resp.Services, 1)
+	assert.Equal(t, []string{"80:30080/TCP"}, resp.Services[0].Ports)
+	assert.Equal(t, "NodePort", resp.Services[0].Type)
+
+	require.Len(t, resp.Ingresses, 1)
+	assert.Equal(t, []ingressRuleInfo{{
+		Host: "example.com", Path: "/", PathType: "Prefix", ServiceName: "frontend", ServicePort: "80",
+	}}, resp.Ingresses[0].Rules)
+}
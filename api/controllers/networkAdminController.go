@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"gluon-api/database"
 	"gluon-api/models"
+	"gluon-api/services"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -112,6 +113,8 @@ func listWireGuardPeers(c *fiber.Ctx, nodeID *string) error {
 				if localNode.Hostname != "" {
 					peerIfaceName = fmt.Sprintf("wg-%s", localNode.Hostname)
 				}
+			} else if localNode.Role == models.NodeRoleWorker && peerNode.Role == models.NodeRoleWorker {
+				peerIfaceName = services.WorkerShortcutInterfaceName(localNode.ID)
 			} else if localNode.Role == models.NodeRoleHub && peerNode.Role == models.NodeRoleHub {
 				if localNode.Hostname != "" {
 					peerIfaceName = fmt.Sprintf("wg-%s", localNode.Hostname)
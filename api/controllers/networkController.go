@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gluon-api/config"
 	"gluon-api/database"
 	"gluon-api/generators"
 	"gluon-api/logger"
 	"gluon-api/models"
 	"gluon-api/services"
+	"slices"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -35,8 +37,15 @@ func GetNetworkInfo(c *fiber.Ctx) error {
 	}
 
 	requiredInterfaces := make([]string, 0, len(interfaces))
+	interfaceKeys := make(map[string]fiber.Map, len(interfaces))
 	for _, iface := range interfaces {
 		requiredInterfaces = append(requiredInterfaces, iface.Name)
+		if iface.PublicKey != "" {
+			interfaceKeys[iface.Name] = fiber.Map{
+				"public_key":       iface.PublicKey,
+				"rotation_pending": iface.PreviousPublicKey != "",
+			}
+		}
 	}
 
 	return c.JSON(fiber.Map{
@@ -44,6 +53,8 @@ func GetNetworkInfo(c *fiber.Ctx) error {
 		"role":                node.Role,
 		"hub_number":          node.HubNumber,
 		"required_interfaces": requiredInterfaces,
+		"interface_keys":      interfaceKeys,
+		"endpoint_echo_port":  config.Current().EndpointEchoPort,
 	})
 }
 
@@ -81,21 +92,42 @@ func UploadPublicKeys(c *fiber.Ctx) error {
 			continue
 		}
 
+		change := services.PublicKeyChange(iface, publicKey, time.Now())
 		if err := database.DB.Model(&models.WireGuardInterface{}).
 			Where("id = ?", iface.ID).
-			UpdateColumn("public_key", publicKey).Error; err != nil {
+			UpdateColumns(change).Error; err != nil {
 			logger.Error("Failed to save public key", "error", err, "node_id", nodeID, "interface", ifaceName)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to save public key",
 			})
 		}
 		updated++
+		if prev, ok := change["previous_public_key"].(string); ok && prev != "" {
+			logger.Info("WireGuard key rotation started", "node_id", nodeID, "interface", ifaceName)
+		}
 
-		endpoint := fmt.Sprintf("%s:%d", node.PublicIP, iface.ListenPort)
-		if err := database.DB.Model(&models.NodePeer{}).
-			Where("peer_node_id = ? AND endpoint = ?", nodeID, endpoint).
-			Update("peer_public_key", publicKey).Error; err != nil {
-			logger.Error("Failed to update peer records with public key", "error", err, "node_id", nodeID)
+		// Match the far ends by link subnet rather than by endpoint: peers of a
+		// node behind NAT have no endpoint to match on.
+		facing, err := services.LinkPeersFacing(iface)
+		if err != nil {
+			logger.Error("Failed to find peer records for public key", "error", err, "node_id", nodeID)
+			continue
+		}
+		if len(facing) == 0 {
+			endpoint := fmt.Sprintf("%s:%d", node.PublicIP, iface.ListenPort)
+			if err := database.DB.Model(&models.NodePeer{}).
+				Where("peer_node_id = ? AND endpoint = ?", nodeID, endpoint).
+				Update("peer_public_key", publicKey).Error; err != nil {
+				logger.Error("Failed to update peer records with public key", "error", err, "node_id", nodeID)
+			}
+			continue
+		}
+		for _, p := range facing {
+			if err := database.DB.Model(&models.NodePeer{}).
+				Where("id = ?", p.ID).
+				Update("peer_public_key", publicKey).Error; err != nil {
+				logger.Error("Failed to update peer records with public key", "error", err, "node_id", nodeID)
+			}
 		}
 	}
 
@@ -145,6 +177,18 @@ func GetConfig(c *fiber.Ctx) error {
 			if stringsTrim(sshKeysJSON) == "" {
 				sshKeysJSON = "[]"
 			}
+			serviceVIPsJSON := existingConfig.ServiceVIPs
+			if stringsTrim(serviceVIPsJSON) == "" {
+				serviceVIPsJSON = "[]"
+			}
+			sshCAJSON := existingConfig.SSHCA
+			if stringsTrim(sshCAJSON) == "" {
+				sshCAJSON = "null"
+			}
+			sudoRulesJSON := existingConfig.SudoRules
+			if stringsTrim(sudoRulesJSON) == "" {
+				sudoRulesJSON = "[]"
+			}
 			return c.JSON(fiber.Map{
 				"version":                 existingConfig.Version,
 				"hash":                    existingConfig.Hash,
@@ -152,27 +196,61 @@ func GetConfig(c *fiber.Ctx) error {
 				"network_interface_file":  existingConfig.NetworkInterfaceConfig,
 				"frr_config_file":         existingConfig.FRRConfig,
 				"ssh_authorized_keys":     json.RawMessage(sshKeysJSON),
+				"service_vips":            json.RawMessage(serviceVIPsJSON),
+				"ssh_ca":                  json.RawMessage(sshCAJSON),
+				"sudo_rules":              json.RawMessage(sudoRulesJSON),
+				"rotated_keys":            configBundle.RotatedKeys,
+				"key_overlaps":            configBundle.KeyOverlaps,
 			})
 		}
 		version = existingConfig.Version + 1
 	}
 
-	wgConfigsJSON, _ := json.Marshal(configBundle.WireGuardConfigs)
-	sshKeysJSON, _ := json.Marshal(configBundle.SSHAuthorizedKeys)
+	var existing *models.NodeConfig
+	if hasExistingConfig {
+		existing = &existingConfig
+	}
+	storeNodeConfig(nodeID, existing, version, hash, configBundle)
+
+	return c.JSON(fiber.Map{
+		"version":                version,
+		"hash":                   hash,
+		"wireguard_configs":      configBundle.WireGuardConfigs,
+		"network_interface_file": configBundle.NetworkInterfaceFile,
+		"frr_config_file":        configBundle.FRRConfigFile,
+		"ssh_authorized_keys":    configBundle.SSHAuthorizedKeys,
+		"service_vips":           configBundle.ServiceVIPs,
+		"ssh_ca":                 configBundle.SSHCA,
+		"sudo_rules":             configBundle.SudoRules,
+		"rotated_keys":           configBundle.RotatedKeys,
+		"key_overlaps":           configBundle.KeyOverlaps,
+	})
+}
+
+// storeNodeConfig records bundle as the node's config at version.
+func storeNodeConfig(nodeID uint, existing *models.NodeConfig, version int, hash string, bundle *configBundle) {
+	wgConfigsJSON, _ := json.Marshal(bundle.WireGuardConfigs)
+	sshKeysJSON, _ := json.Marshal(bundle.SSHAuthorizedKeys)
+	serviceVIPsJSON, _ := json.Marshal(bundle.ServiceVIPs)
+	sshCAJSON, _ := json.Marshal(bundle.SSHCA)
+	sudoRulesJSON, _ := json.Marshal(bundle.SudoRules)
 
 	newConfig := models.NodeConfig{
 		NodeID:                 nodeID,
 		Version:                version,
 		WireGuardConfigs:       string(wgConfigsJSON),
-		NetworkInterfaceConfig: configBundle.NetworkInterfaceFile,
-		FRRConfig:              configBundle.FRRConfigFile,
+		NetworkInterfaceConfig: bundle.NetworkInterfaceFile,
+		FRRConfig:              bundle.FRRConfigFile,
 		SSHAuthorizedKeys:      string(sshKeysJSON),
+		ServiceVIPs:            string(serviceVIPsJSON),
+		SSHCA:                  string(sshCAJSON),
+		SudoRules:              string(sudoRulesJSON),
 		Hash:                   hash,
 		GeneratedAt:            time.Now(),
 	}
 
-	if hasExistingConfig {
-		newConfig.ID = existingConfig.ID
+	if existing != nil {
+		newConfig.ID = existing.ID
 		if err := database.DB.Save(&newConfig).Error; err != nil {
 			logger.Error("Failed to save config", "error", err, "node_id", nodeID)
 		}
@@ -181,15 +259,33 @@ func GetConfig(c *fiber.Ctx) error {
 			logger.Error("Failed to create config", "error", err, "node_id", nodeID)
 		}
 	}
+}
 
-	return c.JSON(fiber.Map{
-		"version":                version,
-		"hash":                   hash,
-		"wireguard_configs":      configBundle.WireGuardConfigs,
-		"network_interface_file": configBundle.NetworkInterfaceFile,
-		"frr_config_file":        configBundle.FRRConfigFile,
-		"ssh_authorized_keys":    configBundle.SSHAuthorizedKeys,
-	})
+// refreshNodeConfigs regenerates the config of the given nodes right away,
+// so a change shows up as a new version for exactly the nodes it affects.
+// Nodes whose config is unchanged, or that never fetched one, are skipped.
+func refreshNodeConfigs(nodeIDs []uint) {
+	for _, id := range nodeIDs {
+		var existing models.NodeConfig
+		if err := database.DB.Where("node_id = ?", id).First(&existing).Error; err != nil {
+			continue
+		}
+		var node models.Node
+		if err := database.DB.First(&node, id).Error; err != nil {
+			continue
+		}
+		bundle, err := generateConfigBundle(&node)
+		if err != nil {
+			logger.Error("Failed to regenerate config", "error", err, "node_id", id)
+			continue
+		}
+		hash := calculateConfigHash(bundle)
+		if hash == existing.Hash {
+			continue
+		}
+		storeNodeConfig(id, &existing, existing.Version+1, hash, bundle)
+		logger.Info("Node config refreshed", "node_id", id, "version", existing.Version+1)
+	}
 }
 
 func ReportConfigApplied(c *fiber.Ctx) error {
@@ -233,6 +329,27 @@ type configBundle struct {
 	NetworkInterfaceFile string
 	FRRConfigFile        string
 	SSHAuthorizedKeys    []sshAuthorizedKey
+	ServiceVIPs          []serviceVIPSpec
+	SSHCA                *services.SSHCASpec
+	// SudoRules come from SSH access groups granting sudo.
+	SudoRules []services.SSHSudoRule
+	// RotatedKeys holds the public key each rotated interface should be
+	// running, so a rotation, its staging or a rollback makes the agent
+	// reapply with the matching private key; interfaces that were never
+	// rotated are left out to keep existing hashes stable.
+	RotatedKeys map[string]string
+	// KeyOverlaps lists the peers configured under both keys while their
+	// far end rotates.
+	KeyOverlaps []keyOverlapSpec
+}
+
+// keyOverlapSpec tells the agent that Interface lists a peer under both its
+// previous and its new key; the agent moves the allowed IPs to PublicKey once
+// a handshake under it is seen.
+type keyOverlapSpec struct {
+	Interface         string `json:"interface"`
+	PreviousPublicKey string `json:"previous_public_key"`
+	PublicKey         string `json:"public_key"`
 }
 
 type sshAuthorizedKey struct {
@@ -240,6 +357,18 @@ type sshAuthorizedKey struct {
 	PublicKey string `json:"public_key"`
 }
 
+type serviceVIPSpec struct {
+	ID              uint   `json:"id"`
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	CheckType       string `json:"check_type"`
+	CheckTarget     string `json:"check_target"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	Rise            int    `json:"rise"`
+	Fall            int    `json:"fall"`
+}
+
 func generateConfigBundle(node *models.Node) (*configBundle, error) {
 	loopbackIP, err := services.GetNodeLoopbackIP(node.ID)
 	if err != nil {
@@ -251,13 +380,36 @@ func generateConfigBundle(node *models.Node) (*configBundle, error) {
 		return nil, fmt.Errorf("failed to get interfaces: %w", err)
 	}
 
+	prefixesByNode, err := services.LoadAdvertisedPrefixes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get advertised prefixes: %w", err)
+	}
+	vipPrefixesByNode, err := services.LoadServiceVIPPrefixes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service VIPs: %w", err)
+	}
+	allowedPrefixesByNode := services.MergePrefixes(prefixesByNode, vipPrefixesByNode)
+	// With native pod routing any link may carry pod traffic; every link
+	// has a single peer, so the pod CIDR can be allowed on all of them.
+	podCIDRs := services.CalicoPodCIDRs()
+
 	wgConfigs := make(map[string]string)
 	networkInterfaces := make([]generators.NetworkInterface, 0)
 	frrInterfaceNames := make([]string, 0)
 	hubLinkInterfaces := make(map[string]bool)
 	hubLinkPeerLoopbacks := make(map[string][]string)
+	rotatedKeys := make(map[string]string)
+	keyOverlaps := make([]keyOverlapSpec, 0)
+	rotating, err := services.RotatingKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key rotations: %w", err)
+	}
 
 	for _, iface := range interfaces {
+		if iface.KeyRotatedAt != nil {
+			rotatedKeys[iface.Name] = services.ActivePublicKey(iface)
+		}
+
 		var peers []models.NodePeer
 		if err := database.DB.Where("interface_id = ?", iface.ID).Preload("PeerNode").Find(&peers).Error; err != nil {
 			return nil, fmt.Errorf("failed to get peers for interface %s: %w", iface.Name, err)
@@ -275,12 +427,30 @@ func generateConfigBundle(node *models.Node) (*configBundle, error) {
 					hubLinkPeerLoopbacks[iface.Name] = append(hubLinkPeerLoopbacks[iface.Name], stringsTrim(peerLB))
 				}
 			}
-			wgPeers = append(wgPeers, generators.WireGuardPeer{
+			wgPeer := generators.WireGuardPeer{
 				PublicKey:           peer.PeerPublicKey,
-				Endpoint:            peer.Endpoint,
-				AllowedIPs:          splitAllowedIPs(peer.AllowedIPs),
+				Endpoint:            services.GeneratedEndpoint(node, &peer.PeerNode, peer.Endpoint),
+				AllowedIPs:          podAllowedIPs(append(splitAllowedIPs(peer.AllowedIPs), services.PrefixAllowedIPs(node.ID, peer.PeerNode, allowedPrefixesByNode)...), podCIDRs),
 				PersistentKeepalive: peer.PersistentKeepAlive,
-			})
+			}
+
+			// A peer rotating its key is listed under both keys. The old key
+			// keeps the allowed IPs until the agent sees a handshake under
+			// the new one, so the switch doesn't wait for a config sync.
+			if r, ok := rotating[peer.PeerPublicKey]; ok && r.NodeID == peer.PeerNodeID {
+				wgPeer.PublicKey = r.PreviousPublicKey
+				newPeer := wgPeer
+				newPeer.PublicKey = r.PublicKey
+				newPeer.AllowedIPs = nil
+				wgPeers = append(wgPeers, wgPeer, newPeer)
+				keyOverlaps = append(keyOverlaps, keyOverlapSpec{
+					Interface:         iface.Name,
+					PreviousPublicKey: r.PreviousPublicKey,
+					PublicKey:         r.PublicKey,
+				})
+				continue
+			}
+			wgPeers = append(wgPeers, wgPeer)
 		}
 
 		wgConfig := generators.GenerateWireGuardConfig(iface.ListenPort, "", wgPeers)
@@ -310,6 +480,15 @@ func generateConfigBundle(node *models.Node) (*configBundle, error) {
 
 	networkInterfaceFile := generators.GenerateNetworkInterfacesConfig(loopbackIP+"/32", networkInterfaces)
 
+	advertisedPrefixes := make([]generators.AdvertisedPrefix, 0, len(prefixesByNode[node.ID]))
+	for _, p := range prefixesByNode[node.ID] {
+		advertisedPrefixes = append(advertisedPrefixes, generators.AdvertisedPrefix{
+			Prefix:  p.Prefix,
+			Kind:    string(p.Kind),
+			NextHop: p.NextHop,
+		})
+	}
+
 	var frrConfig string
 	if node.Role == models.NodeRoleHub {
 		var hubToHubInterfaces []string
@@ -321,19 +500,48 @@ func generateConfigBundle(node *models.Node) (*configBundle, error) {
 				workerInterfaces = append(workerInterfaces, ifaceName)
 			}
 		}
-		frrConfig = generators.GenerateFRRConfigForHub(node.Hostname, loopbackIP, hubToHubInterfaces, workerInterfaces)
+		frrConfig = generators.GenerateFRRConfigForHub(node.Hostname, loopbackIP, hubToHubInterfaces, workerInterfaces, advertisedPrefixes, podCIDRs)
 	} else {
-		frrConfig = generators.GenerateFRRConfigForWorker(node.Hostname, loopbackIP, frrInterfaceNames)
+		var hubInterfaces []string
+		var shortcutInterfaces []string
+		for _, ifaceName := range frrInterfaceNames {
+			if services.IsWorkerShortcutInterface(ifaceName) {
+				shortcutInterfaces = append(shortcutInterfaces, ifaceName)
+			} else {
+				hubInterfaces = append(hubInterfaces, ifaceName)
+			}
+		}
+		frrConfig = generators.GenerateFRRConfigForWorker(node.Hostname, loopbackIP, hubInterfaces, shortcutInterfaces, advertisedPrefixes, podCIDRs)
+	}
+
+	sshKeys, sudoRules := loadSSHAuthorizedKeys(node)
+	sshCA, err := services.NodeSSHCASpec(node.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	return &configBundle{
 		WireGuardConfigs:     wgConfigs,
 		NetworkInterfaceFile: networkInterfaceFile,
 		FRRConfigFile:        frrConfig,
-		SSHAuthorizedKeys:    loadSSHAuthorizedKeys(node.ID),
+		SSHAuthorizedKeys:    sshKeys,
+		ServiceVIPs:          loadServiceVIPSpecs(node.ID),
+		SSHCA:                sshCA,
+		SudoRules:            sudoRules,
+		RotatedKeys:          rotatedKeys,
+		KeyOverlaps:          keyOverlaps,
 	}, nil
 }
 
+func podAllowedIPs(allowed []string, podCIDRs []string) []string {
+	for _, cidr := range podCIDRs {
+		if !slices.Contains(allowed, cidr) {
+			allowed = append(allowed, cidr)
+		}
+	}
+	return allowed
+}
+
 func calculateConfigHash(bundle *configBundle) string {
 	h := sha256.New()
 
@@ -343,25 +551,78 @@ func calculateConfigHash(bundle *configBundle) string {
 	h.Write([]byte(bundle.FRRConfigFile))
 	sshJSON, _ := json.Marshal(bundle.SSHAuthorizedKeys)
 	h.Write(sshJSON)
+	if len(bundle.ServiceVIPs) > 0 {
+		vipJSON, _ := json.Marshal(bundle.ServiceVIPs)
+		h.Write(vipJSON)
+	}
+	if len(bundle.RotatedKeys) > 0 {
+		keysJSON, _ := json.Marshal(bundle.RotatedKeys)
+		h.Write(keysJSON)
+	}
+	if bundle.SSHCA != nil {
+		caJSON, _ := json.Marshal(bundle.SSHCA)
+		h.Write(caJSON)
+	}
+	if len(bundle.SudoRules) > 0 {
+		sudoJSON, _ := json.Marshal(bundle.SudoRules)
+		h.Write(sudoJSON)
+	}
 
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func loadSSHAuthorizedKeys(nodeID uint) []sshAuthorizedKey {
+// loadSSHAuthorizedKeys merges the node's own keys with those of the SSH
+// access groups it falls under, and returns the groups' sudo rules.
+func loadSSHAuthorizedKeys(node *models.Node) ([]sshAuthorizedKey, []services.SSHSudoRule) {
 	var keys []models.NodeSSHAuthorizedKey
-	if err := database.DB.Select("username", "public_key").Where("node_id = ?", nodeID).Find(&keys).Error; err != nil {
-		return []sshAuthorizedKey{}
+	if err := database.DB.Select("username", "public_key").Where("node_id = ?", node.ID).Find(&keys).Error; err != nil {
+		keys = nil
+	}
+	groupKeys, sudoRules := services.ExpandSSHAccessGroups(node)
+
+	seen := make(map[sshAuthorizedKey]bool)
+	out := make([]sshAuthorizedKey, 0, len(keys)+len(groupKeys))
+	add := func(username, publicKey string) {
+		k := sshAuthorizedKey{Username: stringsTrim(username), PublicKey: stringsTrim(publicKey)}
+		if k.Username == "" || k.PublicKey == "" || seen[k] {
+			return
+		}
+		seen[k] = true
+		out = append(out, k)
 	}
-	out := make([]sshAuthorizedKey, 0, len(keys))
 	for _, k := range keys {
-		u := stringsTrim(k.Username)
-		p := stringsTrim(k.PublicKey)
-		if u == "" || p == "" {
+		add(k.Username, k.PublicKey)
+	}
+	for _, k := range groupKeys {
+		add(k.Username, k.PublicKey)
+	}
+	sortSSHKeys(out)
+	return out, sudoRules
+}
+
+func loadServiceVIPSpecs(nodeID uint) []serviceVIPSpec {
+	var assignments []models.ServiceVIPAssignment
+	if err := database.DB.Preload("ServiceVIP").Where("node_id = ?", nodeID).Order("service_vip_id asc").Find(&assignments).Error; err != nil {
+		return []serviceVIPSpec{}
+	}
+	out := make([]serviceVIPSpec, 0, len(assignments))
+	for _, a := range assignments {
+		vip := a.ServiceVIP
+		if stringsTrim(vip.Address) == "" {
 			continue
 		}
-		out = append(out, sshAuthorizedKey{Username: u, PublicKey: p})
+		out = append(out, serviceVIPSpec{
+			ID:              vip.ID,
+			Name:            vip.Name,
+			Address:         vip.Address,
+			CheckType:       string(vip.CheckType),
+			CheckTarget:     vip.CheckTarget,
+			IntervalSeconds: vip.CheckIntervalSeconds,
+			TimeoutSeconds:  vip.CheckTimeoutSeconds,
+			Rise:            vip.Rise,
+			Fall:            vip.Fall,
+		})
 	}
-	sortSSHKeys(out)
 	return out
 }
 
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminListNodeCommands is the command history, newest first, filterable by
+// node_id, kind, status and job_id. Pages continue with before_id.
+func AdminListNodeCommands(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be between 1 and 500"})
+	}
+
+	q := database.DB.Omit("output").Order("id desc").Limit(limit)
+	for _, filter := range []string{"node_id", "job_id", "before_id"} {
+		raw := c.Query(filter)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || id == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid " + filter})
+		}
+		if filter == "before_id" {
+			q = q.Where("id < ?", id)
+		} else {
+			q = q.Where(filter+" = ?", id)
+		}
+	}
+	if kind := c.Query("kind"); kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var cmds []models.NodeCommand
+	if err := q.Find(&cmds).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list commands"})
+	}
+	return c.JSON(fiber.Map{"commands": cmds})
+}
+
+func AdminGetNodeCommand(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid command ID"})
+	}
+	var cmd models.NodeCommand
+	if err := database.DB.First(&cmd, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Command not found"})
+	}
+	return c.JSON(cmd)
+}
+
+// AdminCancelNodeCommand cancels a command that has not finished. Running
+// commands are stopped by the agent on its next heartbeat.
+func AdminCancelNodeCommand(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid command ID"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	cmd, err := services.CancelNodeCommand(uint(id))
+	if errors.Is(err, services.ErrNodeCommandNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Command not found"})
+	}
+	if errors.Is(err, services.ErrNodeCommandFinished) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Command already finished", "status": cmd.Status})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to cancel command"})
+	}
+
+	logger.Audit(c, "Cancelled node command", actorID, "cancel", "NodeCommand",
+		"command_id", cmd.ID, "node_id", cmd.NodeID, "kind", cmd.Kind, "status", cmd.Status)
+	return c.JSON(cmd)
+}
@@ -8,15 +8,28 @@ import (
 	"gluon-api/models"
 	"gluon-api/services"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
+// ListNodes returns all nodes, or those matching the label selector in
+// ?selector= (e.g. `provider=hetzner,role!=hub`).
 func ListNodes(c *fiber.Ctx) error {
+	query := database.DB
+	if raw := c.Query("selector"); raw != "" {
+		sel, err := services.ParseNodeSelector(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		query = sel.Scope(query)
+	}
+
 	var nodes []models.Node
-	result := database.DB.Find(&nodes)
+	result := query.Find(&nodes)
 	if result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to retrieve nodes",
@@ -195,3 +208,191 @@ func performDecommission(nodeID int) (models.NodeCommand, *models.Node, bool, er
 
 	return cmd, &node, false, nil
 }
+
+// SetNodeNAT pins whether a node is behind NAT, overriding what its agent
+// detects. Sending "behind_nat": null hands the decision back to the agent.
+func SetNodeNAT(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+
+	var input struct {
+		BehindNAT *bool `json:"behind_nat"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+
+	updates := map[string]any{"nat_override": input.BehindNAT != nil}
+	if input.BehindNAT != nil {
+		updates["behind_nat"] = *input.BehindNAT
+	}
+	if err := database.DB.Model(&node).Updates(updates).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update node"})
+	}
+	node.NATOverride = input.BehindNAT != nil
+	if input.BehindNAT != nil {
+		node.BehindNAT = *input.BehindNAT
+	}
+	if err := services.RefreshNodeEndpoints(node.ID); err != nil {
+		logger.Error("Failed to refresh peer endpoints", "error", err, "node_id", node.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to refresh peer endpoints"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated node NAT setting", actorID, "update", "Node", "node_id", node.ID, "behind_nat", node.BehindNAT, "override", node.NATOverride)
+
+	return c.JSON(node)
+}
+
+func GetNodeKubernetesMetadata(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+
+	hubs, err := services.KubernetesAffinityHubs()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve hubs"})
+	}
+
+	return c.JSON(fiber.Map{
+		"region":  node.Region,
+		"labels":  services.NodeKubernetesLabels(&node),
+		"taints":  services.NodeKubernetesTaints(&node),
+		"desired": services.DesiredKubernetesNodeMetadata(&node, hubs),
+	})
+}
+
+// SetNodeKubernetesMetadata replaces a node's region and the labels and
+// taints an admin keeps on it. The bootstrap hub's agent applies the result
+// on its next Kubernetes sync.
+func SetNodeKubernetesMetadata(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+
+	var input struct {
+		Region *string                  `json:"region"`
+		Labels map[string]string        `json:"labels"`
+		Taints []models.KubernetesTaint `json:"taints"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if input.Labels == nil {
+		input.Labels = map[string]string{}
+	}
+	if input.Taints == nil {
+		input.Taints = []models.KubernetesTaint{}
+	}
+	if err := services.ValidateKubernetesNodeLabels(input.Labels); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := services.ValidateKubernetesTaints(input.Taints); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+
+	labelsJSON, _ := json.Marshal(input.Labels)
+	taintsJSON, _ := json.Marshal(input.Taints)
+	updates := map[string]any{
+		"k8s_labels": datatypes.JSON(labelsJSON),
+		"k8s_taints": datatypes.JSON(taintsJSON),
+	}
+	if input.Region != nil {
+		updates["region"] = strings.TrimSpace(*input.Region)
+	}
+	if err := database.DB.Model(&node).Updates(updates).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update node"})
+	}
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reload node"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated node Kubernetes metadata", actorID, "update", "Node",
+		"node_id", node.ID, "region", node.Region, "labels", len(input.Labels), "taints", len(input.Taints))
+
+	hubs, err := services.KubernetesAffinityHubs()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve hubs"})
+	}
+
+	return c.JSON(fiber.Map{
+		"region":  node.Region,
+		"labels":  input.Labels,
+		"taints":  input.Taints,
+		"desired": services.DesiredKubernetesNodeMetadata(&node, hubs),
+	})
+}
+
+// SetNodeKubernetesCluster moves a node that has not joined Kubernetes yet
+// into another cluster. An empty selector returns it to the default one.
+func SetNodeKubernetesCluster(c *fiber.Ctx) error {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+
+	var input struct {
+		Cluster string `json:"cluster"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	switch node.K8sState {
+	case "cluster_initialized", "joined_control_plane", "joined_worker":
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The node has joined a cluster; reset it before moving it"})
+	}
+
+	var clusterID *uint
+	clusterName := ""
+	if strings.TrimSpace(input.Cluster) != "" {
+		cluster, err := services.FindKubernetesCluster(input.Cluster)
+		if errors.Is(err, services.ErrClusterNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cluster not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load cluster"})
+		}
+		clusterID, clusterName = &cluster.ID, cluster.Name
+	}
+	if err := database.DB.Model(&node).Update("kubernetes_cluster_id", clusterID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update node"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Moved node to Kubernetes cluster", actorID, "update", "Node", "node_id", node.ID, "cluster", clusterName)
+
+	return c.JSON(fiber.Map{"kubernetes_cluster_id": clusterID})
+}
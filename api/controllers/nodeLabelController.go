@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"strconv"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminListNodeLabelValues lists every label in use and how many nodes
+// carry each value.
+func AdminListNodeLabelValues(c *fiber.Ctx) error {
+	values, err := services.ListNodeLabelValues()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list node labels"})
+	}
+	return c.JSON(fiber.Map{"labels": values})
+}
+
+func GetNodeLabels(c *fiber.Ctx) error {
+	node, err := findLabelledNode(c)
+	if node == nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"labels": services.NodeLabels(node)})
+}
+
+// ReplaceNodeLabels sets the node's labels to exactly the given set.
+func ReplaceNodeLabels(c *fiber.Ctx) error {
+	node, err := findLabelledNode(c)
+	if node == nil {
+		return err
+	}
+	var input struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	return saveNodeLabels(c, node, input.Labels)
+}
+
+// UpdateNodeLabels adds or overwrites the labels in set and drops the keys
+// in remove, leaving the others alone.
+func UpdateNodeLabels(c *fiber.Ctx) error {
+	node, err := findLabelledNode(c)
+	if node == nil {
+		return err
+	}
+	var input struct {
+		Set    map[string]string `json:"set"`
+		Remove []string          `json:"remove"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	if err := services.ValidateNodeLabels(input.Set); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	before, err := services.MergeNodeLabels(node, input.Set, input.Remove)
+	if err != nil {
+		logger.Error("Failed to update node labels", "error", err, "node_id", node.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update node labels"})
+	}
+	return nodeLabelsChanged(c, node, before)
+}
+
+func findLabelledNode(c *fiber.Ctx) (*models.Node, error) {
+	nodeID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || nodeID == 0 {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	return &node, nil
+}
+
+// saveNodeLabels replaces the node's labels with labels.
+func saveNodeLabels(c *fiber.Ctx, node *models.Node, labels map[string]string) error {
+	before := services.NodeLabels(node)
+	if err := services.ValidateNodeLabels(labels); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := services.SetNodeLabels(node, labels); err != nil {
+		logger.Error("Failed to set node labels", "error", err, "node_id", node.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update node labels"})
+	}
+	return nodeLabelsChanged(c, node, before)
+}
+
+// nodeLabelsChanged regenerates the node's config, since SSH access groups
+// may select it differently now, and audits the change.
+func nodeLabelsChanged(c *fiber.Ctx, node *models.Node, before map[string]string) error {
+	refreshNodeConfigs([]uint{node.ID})
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated node labels", actorID, "update_labels", "Node",
+		"node_id", node.ID, "before", before, "after", services.NodeLabels(node))
+	return c.JSON(fiber.Map{"labels": services.NodeLabels(node)})
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"fmt"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ListNodePrefixes(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var node models.Node
+	if err := database.DB.Select("id").First(&node, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+
+	var prefixes []models.NodePrefix
+	if err := database.DB.Where("node_id = ?", node.ID).Order("prefix asc").Find(&prefixes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve prefixes"})
+	}
+
+	return c.JSON(prefixes)
+}
+
+func CreateNodePrefix(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	nodeID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+
+	var node models.Node
+	if err := database.DB.Select("id").First(&node, nodeID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+
+	var input struct {
+		Prefix      string `json:"prefix"`
+		Kind        string `json:"kind"`
+		NextHop     string `json:"next_hop"`
+		Description string `json:"description"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	prefix, err := normalizeAdvertisedPrefix(input.Prefix)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	kind := models.NodePrefixKind(strings.ToLower(strings.TrimSpace(input.Kind)))
+	if kind == "" {
+		kind = models.NodePrefixKindConnected
+	}
+	if kind != models.NodePrefixKindConnected && kind != models.NodePrefixKindStatic {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Kind must be connected or static"})
+	}
+
+	nextHop := strings.TrimSpace(input.NextHop)
+	if nextHop != "" {
+		if kind != models.NodePrefixKindStatic {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "next_hop is only valid for static prefixes"})
+		}
+		addr, err := netip.ParseAddr(nextHop)
+		if err != nil || !addr.Is4() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "next_hop must be an IPv4 address"})
+		}
+		nextHop = addr.String()
+	}
+
+	var pools []models.IPPool
+	if err := database.DB.Find(&pools).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load IP pools"})
+	}
+	for _, pool := range pools {
+		if prefixesOverlap(prefix, pool.CIDR) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": fmt.Sprintf("Prefix overlaps %s pool %s", pool.Purpose, pool.CIDR),
+			})
+		}
+	}
+
+	var existing models.NodePrefix
+	if err := database.DB.Where("node_id = ? AND prefix = ?", node.ID, prefix).First(&existing).Error; err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Prefix already advertised by this node"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	record := models.NodePrefix{
+		NodeID:      node.ID,
+		Prefix:      prefix,
+		Kind:        kind,
+		NextHop:     nextHop,
+		Description: strings.TrimSpace(input.Description),
+		CreatedByID: actorID,
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store prefix"})
+	}
+
+	logger.Audit(c, "Added advertised prefix", actorID, "create", "NodePrefix", "node_id", node.ID, "prefix", prefix, "kind", kind)
+
+	return c.Status(fiber.StatusCreated).JSON(record)
+}
+
+func DeleteNodePrefix(c *fiber.Ctx) error {
+	id := c.Params("id")
+	prefixID := c.Params("prefixId")
+
+	nodeID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || nodeID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid node id"})
+	}
+	pid, err := strconv.ParseUint(prefixID, 10, 64)
+	if err != nil || pid == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid prefix id"})
+	}
+
+	var record models.NodePrefix
+	if err := database.DB.Where("node_id = ? AND id = ?", uint(nodeID), uint(pid)).First(&record).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prefix not found"})
+	}
+
+	if err := database.DB.Delete(&record).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete prefix"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Removed advertised prefix", actorID, "delete", "NodePrefix", "node_id", record.NodeID, "prefix", record.Prefix)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// normalizeAdvertisedPrefix accepts an IPv4 CIDR and returns it in canonical
+// form. A default route is refused: it would end up in the hubs' AllowedIPs.
+func normalizeAdvertisedPrefix(value string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return "", fmt.Errorf("prefix is required")
+	}
+	if !strings.Contains(trimmed, "/") {
+		trimmed += "/32"
+	}
+	p, err := netip.ParsePrefix(trimmed)
+	if err != nil || !p.Addr().Is4() {
+		return "", fmt.Errorf("prefix must be a valid IPv4 CIDR")
+	}
+	if p.Bits() == 0 {
+		return "", fmt.Errorf("default route cannot be advertised")
+	}
+	return p.Masked().String(), nil
+}
+
+func prefixesOverlap(a string, b string) bool {
+	pa, err := netip.ParsePrefix(strings.TrimSpace(a))
+	if err != nil {
+		return false
+	}
+	pb, err := netip.ParsePrefix(strings.TrimSpace(b))
+	if err != nil {
+		return false
+	}
+	return pa.Overlaps(pb)
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	errPermissionNotGranted = errors.New("permission not granted")
+	errLastPermissionHolder = errors.New("last holder of permission")
+)
+
+func userHasPermission(userID uint, permission string) bool {
+	var count int64
+	if err := database.DB.Model(&models.UserPermission{}).
+		Where("user_id = ? AND permission = ?", userID, permission).
+		Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// canManagePermission reports whether actor may grant or revoke permission:
+// holders may, and so may the bootstrap accounts from
+// GLUON_PERMISSION_BOOTSTRAP_EMAILS, which is how the first holder is made.
+func canManagePermission(actor *models.User, permission string) bool {
+	if userHasPermission(actor.ID, permission) {
+		return true
+	}
+	for _, email := range config.Current().PermissionBootstrapEmails {
+		if strings.EqualFold(email, actor.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func ListUserPermissions(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || userID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+
+	var perms []models.UserPermission
+	if err := database.DB.Where("user_id = ?", userID).Order("permission asc").Find(&perms).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve permissions"})
+	}
+	return c.JSON(fiber.Map{
+		"permissions": perms,
+		"known":       models.KnownPermissions,
+	})
+}
+
+// GrantUserPermission grants an elevated permission. Only holders of a
+// permission and the bootstrap accounts can hand it out, so a plain admin
+// account can't escalate itself.
+func GrantUserPermission(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || userID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	permission := c.Params("permission")
+	if !slices.Contains(models.KnownPermissions, permission) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown permission"})
+	}
+
+	actor, err := getUserFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var target models.User
+	if err := database.DB.First(&target, userID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if !canManagePermission(actor, permission) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only holders of this permission can grant it"})
+	}
+
+	if userHasPermission(target.ID, permission) {
+		return c.JSON(fiber.Map{"message": "Permission already granted"})
+	}
+
+	perm := models.UserPermission{
+		UserID:      target.ID,
+		Permission:  permission,
+		GrantedByID: &actor.ID,
+	}
+	if err := database.DB.Create(&perm).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to grant permission"})
+	}
+
+	logger.Audit(c, "Granted permission", &actor.ID, "grant_permission", "User", "user_id", target.ID, "permission", permission)
+
+	return c.Status(fiber.StatusCreated).JSON(perm)
+}
+
+// RevokeUserPermission takes an elevated permission away. The same accounts
+// that can grant it can revoke it, and its last holder is kept so it can't be
+// lost altogether.
+func RevokeUserPermission(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || userID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	permission := c.Params("permission")
+
+	actor, err := getUserFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if !canManagePermission(actor, permission) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only holders of this permission can revoke it"})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var holders int64
+		if err := tx.Model(&models.UserPermission{}).Where("permission = ?", permission).Count(&holders).Error; err != nil {
+			return err
+		}
+		res := tx.Where("user_id = ? AND permission = ?", userID, permission).Delete(&models.UserPermission{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errPermissionNotGranted
+		}
+		if holders <= 1 {
+			return errLastPermissionHolder
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errPermissionNotGranted):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Permission not granted"})
+	case errors.Is(err, errLastPermissionHolder):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Cannot revoke the last holder of a permission"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke permission"})
+	}
+
+	logger.Audit(c, "Revoked permission", &actor.ID, "revoke_permission", "User", "user_id", userID, "permission", permission)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupPermissionTest(t *testing.T) (*fiber.App, []models.User) {
+	t.Helper()
+	t.Setenv("GLUON_SECRET_KEY", "test")
+	t.Setenv("GLUON_PERMISSION_BOOTSTRAP_EMAILS", "root@example.com")
+	t.Setenv("GLUON_EXEC_ALLOWED_ORIGINS", "https://console.example.com")
+	require.NoError(t, config.Load())
+	logger.Init()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.UserPermission{}, &models.AuditLog{}))
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	users := []models.User{
+		{Name: "Root", Email: "root@example.com"},
+		{Name: "Alice", Email: "alice@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+	}
+	require.NoError(t, db.Create(&users).Error)
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": c.Get("X-Test-User")}))
+		return c.Next()
+	})
+	app.Put("/users/:id/permissions/:permission", GrantUserPermission)
+	app.Delete("/users/:id/permissions/:permission", RevokeUserPermission)
+	app.Get("/pods/:ns/:name/exec", AdminPodExecUpgrade, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusSwitchingProtocols)
+	})
+	app.Get("/pods/:ns/:name/logs", AdminStreamPodLogs, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusSwitchingProtocols)
+	})
+	return app, users
+}
+
+func permissionRequest(t *testing.T, app *fiber.App, method string, actor models.User, target models.User) int {
+	t.Helper()
+	req := httptest.NewRequest(method, "/users/"+strconv.Itoa(int(target.ID))+"/permissions/"+models.PermissionPodExec, nil)
+	req.Header.Set("X-Test-User", strconv.Itoa(int(actor.ID)))
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestGrantUserPermission(t *testing.T) {
+	app, users := setupPermissionTest(t)
+	root, alice, bob := users[0], users[1], users[2]
+
+	// Nobody holds pod_exec yet; a plain admin can't take it.
+	assert.Equal(t, fiber.StatusForbidden, permissionRequest(t, app, fiber.MethodPut, alice, alice))
+	// The bootstrap account can hand it out without holding it.
+	assert.Equal(t, fiber.StatusCreated, permissionRequest(t, app, fiber.MethodPut, root, alice))
+	// Holders can grant it on; others still can't.
+	assert.Equal(t, fiber.StatusForbidden, permissionRequest(t, app, fiber.MethodPut, bob, bob))
+	assert.Equal(t, fiber.StatusCreated, permissionRequest(t, app, fiber.MethodPut, alice, bob))
+	assert.Equal(t, fiber.StatusOK, permissionRequest(t, app, fiber.MethodPut, alice, bob))
+}
+
+func TestRevokeUserPermission(t *testing.T) {
+	app, users := setupPermissionTest(t)
+	root, alice, bob := users[0], users[1], users[2]
+	require.Equal(t, fiber.StatusCreated, permissionRequest(t, app, fiber.MethodPut, root, alice))
+
+	// Non-holders can't revoke, and the last holder stays.
+	assert.Equal(t, fiber.StatusForbidden, permissionRequest(t, app, fiber.MethodDelete, bob, alice))
+	assert.Equal(t, fiber.StatusConflict, permissionRequest(t, app, fiber.MethodDelete, alice, alice))
+	assert.True(t, userHasPermission(alice.ID, models.PermissionPodExec))
+
+	require.Equal(t, fiber.StatusCreated, permissionRequest(t, app, fiber.MethodPut, alice, bob))
+	assert.Equal(t, fiber.StatusNoContent, permissionRequest(t, app, fiber.MethodDelete, bob, alice))
+	assert.False(t, userHasPermission(alice.ID, models.PermissionPodExec))
+	assert.Equal(t, fiber.StatusNotFound, permissionRequest(t, app, fiber.MethodDelete, bob, alice))
+}
+
+func TestAdminPodExecUpgradeChecks(t *testing.T) {
+	app, users := setupPermissionTest(t)
+	root, alice, bob := users[0], users[1], users[2]
+	require.Equal(t, fiber.StatusCreated, permissionRequest(t, app, fiber.MethodPut, root, alice))
+
+	exec := func(actor models.User, origin string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "http://api.example.com/pods/default/web/exec", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("X-Test-User", strconv.Itoa(int(actor.ID)))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusForbidden, exec(alice, "https://evil.example.net"))
+	assert.Equal(t, fiber.StatusForbidden, exec(bob, "http://api.example.com"))
+	// Past the origin and permission checks the request only fails for lack
+	// of a cluster.
+	assert.Equal(t, fiber.StatusServiceUnavailable, exec(alice, "http://api.example.com"))
+	assert.Equal(t, fiber.StatusServiceUnavailable, exec(alice, "https://console.example.com"))
+	assert.Equal(t, fiber.StatusServiceUnavailable, exec(alice, ""))
+}
+
+func TestAdminPodLogsSocketChecksOrigin(t *testing.T) {
+	app, _ := setupPermissionTest(t)
+
+	logs := func(origin string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "http://api.example.com/pods/default/web/logs?follow=true", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusForbidden, logs("https://evil.example.net"))
+	assert.Equal(t, fiber.StatusSwitchingProtocols, logs("http://api.example.com"))
+	assert.Equal(t, fiber.StatusSwitchingProtocols, logs("https://console.example.com"))
+	assert.Equal(t, fiber.StatusSwitchingProtocols, logs(""))
+}
+
+func TestExecOriginAllowed(t *testing.T) {
+	allowed := []string{"https://console.example.com/"}
+	assert.True(t, execOriginAllowed("", "api:3000", allowed))
+	assert.True(t, execOriginAllowed("https://api:3000", "api:3000", allowed))
+	assert.True(t, execOriginAllowed("https://console.example.com", "api:3000", allowed))
+	assert.False(t, execOriginAllowed("https://api:3001", "api:3000", allowed))
+	assert.False(t, execOriginAllowed("null", "api:3000", allowed))
+	assert.False(t, execOriginAllowed("https://evil.example.net", "api:3000", allowed))
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	query := func(values map[string]string) func(string) string {
+		return func(key string) string { return values[key] }
+	}
+
+	opts, err := parseLogOptions(query(map[string]string{
+		"container": "app", "follow": "true", "tail": "100", "since_seconds": "60",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "app", opts.Container)
+	assert.True(t, opts.Follow)
+	assert.False(t, opts.Previous)
+	require.NotNil(t, opts.TailLines)
+	assert.Equal(t, int64(100), *opts.TailLines)
+	require.NotNil(t, opts.SinceSeconds)
+	assert.Equal(t, int64(60), *opts.SinceSeconds)
+
+	opts, err = parseLogOptions(query(map[string]string{"previous": "1"}))
+	require.NoError(t, err)
+	assert.True(t, opts.Previous)
+	assert.Nil(t, opts.TailLines)
+
+	_, err = parseLogOptions(query(map[string]string{"follow": "true", "previous": "true"}))
+	assert.Error(t, err)
+	_, err = parseLogOptions(query(map[string]string{"tail": "-1"}))
+	assert.Error(t, err)
+	_, err = parseLogOptions(query(map[string]string{"since_seconds": "abc"}))
+	assert.Error(t, err)
+}
@@ -3,17 +3,18 @@ package controllers
 import (
 	"encoding/json"
 	"gluon-api/database"
+	"gluon-api/logger"
 	"gluon-api/models"
-	"regexp"
+	"gluon-api/services"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-var systemdUnitNameRe = regexp.MustCompile(`^[A-Za-z0-9@._:-]+$`)
-
 func QueueRestartService(c *fiber.Ctx) error {
 	id := c.Params("id")
 	nodeID, err := strconv.ParseUint(id, 10, 64)
@@ -27,30 +28,24 @@ func QueueRestartService(c *fiber.Ctx) error {
 	}
 
 	var input struct {
-		Name string `json:"name"`
+		Name           string `json:"name"`
+		TimeoutSeconds int    `json:"timeout_seconds"`
+		MaxAttempts    int    `json:"max_attempts"`
 	}
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
 	}
 
-	name := strings.TrimSpace(input.Name)
-	if name == "" || !systemdUnitNameRe.MatchString(name) {
+	name, ok := services.SystemdUnitName(input.Name)
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service name"})
 	}
-	if !strings.Contains(name, ".") {
-		
-		name = name + ".service"
+	if err := services.ValidateCommandPolicy(input.TimeoutSeconds, input.MaxAttempts); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	payload, _ := json.Marshal(fiber.Map{"name": name})
-	cmd := models.NodeCommand{
-		NodeID:  node.ID,
-		Kind:    "restart_service",
-		Payload: payload,
-		Status:  models.NodeCommandStatusPending,
-	}
-
-	if err := database.DB.Create(&cmd).Error; err != nil {
+	cmd, err := queueRestartServiceCommand(node.ID, name, input.TimeoutSeconds, input.MaxAttempts)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue command"})
 	}
 
@@ -62,6 +57,48 @@ func QueueRestartService(c *fiber.Ctx) error {
 	})
 }
 
+// QueueRestartServiceBySelector restarts a service on every node matching
+// the label selector as a command job, by default one node at a time.
+func QueueRestartServiceBySelector(c *fiber.Ctx) error {
+	var input struct {
+		Name        string `json:"name"`
+		Selector    string `json:"selector"`
+		Concurrency int    `json:"concurrency"`
+		MaxFailures int    `json:"max_failures"`
+
+		TimeoutSeconds int `json:"timeout_seconds"`
+		MaxAttempts    int `json:"max_attempts"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	payload, _ := json.Marshal(fiber.Map{"name": input.Name})
+	return startCommandJob(c, services.CommandJobSpec{
+		Kind:        models.CmdKindRestartService,
+		Payload:     payload,
+		Selector:    input.Selector,
+		Concurrency: input.Concurrency,
+		MaxFailures: input.MaxFailures,
+
+		TimeoutSeconds: input.TimeoutSeconds,
+		MaxAttempts:    input.MaxAttempts,
+	})
+}
+
+func queueRestartServiceCommand(nodeID uint, name string, timeoutSeconds int, maxAttempts int) (models.NodeCommand, error) {
+	payload, _ := json.Marshal(fiber.Map{"name": name})
+	cmd := models.NodeCommand{
+		NodeID:         nodeID,
+		Kind:           models.CmdKindRestartService,
+		Payload:        payload,
+		Status:         models.NodeCommandStatusPending,
+		TimeoutSeconds: timeoutSeconds,
+		MaxAttempts:    maxAttempts,
+	}
+	err := database.DB.Create(&cmd).Error
+	return cmd, err
+}
+
 func ReportCommandResults(c *fiber.Ctx) error {
 	nodeID := c.Locals("node_id").(uint)
 
@@ -80,6 +117,7 @@ func ReportCommandResults(c *fiber.Ctx) error {
 	now := time.Now()
 	updated := 0
 	decommissionCompleted := false
+	var jobIDs []uint
 
 	for _, r := range input.Results {
 		if r.ID == 0 {
@@ -92,33 +130,45 @@ func ReportCommandResults(c *fiber.Ctx) error {
 			status = models.NodeCommandStatusSucceeded
 		case "failed", "error":
 			status = models.NodeCommandStatusFailed
+		case "cancelled", "canceled":
+			status = models.NodeCommandStatusCancelled
 		default:
 			status = models.NodeCommandStatusFailed
 		}
 
+		// A result for a command the reaper already settled is dropped; a
+		// retried command still takes the result of its earlier attempt.
 		tx := database.DB.Model(&models.NodeCommand{}).
-			Where("id = ? AND node_id = ?", r.ID, nodeID).
+			Where("id = ? AND node_id = ? AND status IN ?", r.ID, nodeID,
+				[]models.NodeCommandStatus{models.NodeCommandStatusRunning, models.NodeCommandStatusPending}).
 			Updates(map[string]any{
 				"status":       status,
 				"completed_at": &now,
-				"output":       r.Output,
-				"error":        r.Error,
+				"output":       truncateCommandText(r.Output),
+				"error":        truncateCommandText(r.Error),
 			})
 		if tx.Error == nil && tx.RowsAffected > 0 {
 			updated++
-			if status == models.NodeCommandStatusSucceeded {
-				var cmd models.NodeCommand
-				if err := database.DB.Select("id", "kind").
-					Where("id = ? AND node_id = ?", r.ID, nodeID).
-					First(&cmd).Error; err == nil {
-					if cmd.Kind == models.CmdKindDecommission {
-						decommissionCompleted = true
-					}
+			var cmd models.NodeCommand
+			if err := database.DB.Select("id", "kind", "job_id").
+				Where("id = ? AND node_id = ?", r.ID, nodeID).
+				First(&cmd).Error; err == nil {
+				if status == models.NodeCommandStatusSucceeded && cmd.Kind == models.CmdKindDecommission {
+					decommissionCompleted = true
+				}
+				if cmd.JobID != nil && !slices.Contains(jobIDs, *cmd.JobID) {
+					jobIDs = append(jobIDs, *cmd.JobID)
 				}
 			}
 		}
 	}
 
+	for _, jobID := range jobIDs {
+		if err := services.AdvanceCommandJob(jobID); err != nil {
+			logger.Error("Failed to advance command job", "error", err, "job_id", jobID)
+		}
+	}
+
 	if decommissionCompleted {
 		_ = database.DB.Model(&models.APIKey{}).
 			Where("node_id = ? AND revoked_at IS NULL", nodeID).
@@ -127,3 +177,19 @@ func ReportCommandResults(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{"updated": updated})
 }
+
+// maxCommandText bounds what one command result may store; agents cap their
+// own output well below this.
+const maxCommandText = 256 << 10
+
+func truncateCommandText(s string) string {
+	if len(s) <= maxCommandText {
+		return s
+	}
+	// Cut on a rune boundary so the stored text stays valid UTF-8.
+	n := maxCommandText
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "\n[truncated]"
+}
@@ -0,0 +1,416 @@
+package controllers
+
+import (
+	"fmt"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var serviceVIPNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type serviceVIPInput struct {
+	Name                 string `json:"name"`
+	Address              string `json:"address"`
+	CheckType            string `json:"check_type"`
+	CheckTarget          string `json:"check_target"`
+	CheckIntervalSeconds int    `json:"check_interval_seconds"`
+	CheckTimeoutSeconds  int    `json:"check_timeout_seconds"`
+	Rise                 int    `json:"rise"`
+	Fall                 int    `json:"fall"`
+	NodeIDs              []uint `json:"node_ids"`
+}
+
+func AdminListServiceVIPs(c *fiber.Ctx) error {
+	var vips []models.ServiceVIP
+	if err := database.DB.
+		Preload("Assignments", func(db *gorm.DB) *gorm.DB { return db.Order("node_id asc") }).
+		Preload("Assignments.Node").
+		Order("name asc").
+		Find(&vips).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve service VIPs"})
+	}
+
+	return c.JSON(vips)
+}
+
+func AdminGetServiceVIP(c *fiber.Ctx) error {
+	vipID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || vipID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service VIP id"})
+	}
+
+	var vip models.ServiceVIP
+	if err := database.DB.
+		Preload("Assignments", func(db *gorm.DB) *gorm.DB { return db.Order("node_id asc") }).
+		Preload("Assignments.Node").
+		First(&vip, vipID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Service VIP not found"})
+	}
+
+	healthy := 0
+	for _, a := range vip.Assignments {
+		if a.Healthy && a.Announced {
+			healthy++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"service_vip":      vip,
+		"announcing_nodes": healthy,
+	})
+}
+
+func AdminCreateServiceVIP(c *fiber.Ctx) error {
+	var input serviceVIPInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	name := strings.ToLower(strings.TrimSpace(input.Name))
+	if !serviceVIPNameRe.MatchString(name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid name (lowercase letters, digits and dashes)"})
+	}
+
+	checkType, checkTarget, err := normalizeServiceVIPCheck(input.CheckType, input.CheckTarget)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	interval := input.CheckIntervalSeconds
+	if interval == 0 {
+		interval = 5
+	}
+	timeout := input.CheckTimeoutSeconds
+	if timeout == 0 {
+		timeout = 2
+	}
+	if interval < 1 || interval > 300 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "check_interval_seconds must be between 1 and 300"})
+	}
+	if timeout < 1 || timeout > interval {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "check_timeout_seconds must be between 1 and check_interval_seconds"})
+	}
+	rise, fall := input.Rise, input.Fall
+	if rise == 0 {
+		rise = 2
+	}
+	if fall == 0 {
+		fall = 2
+	}
+	if rise < 1 || rise > 10 || fall < 1 || fall > 10 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "rise and fall must be between 1 and 10"})
+	}
+
+	nodeIDs, err := loadServiceVIPNodeIDs(input.NodeIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var existing models.ServiceVIP
+	if err := database.DB.Where("name = ?", name).First(&existing).Error; err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Service VIP name already in use"})
+	}
+
+	var allocation *models.IPAllocation
+	if strings.TrimSpace(input.Address) != "" {
+		allocation, err = reserveServiceVIPAddress(input.Address)
+		if err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+	} else {
+		allocation, err = services.AllocateServiceVIPAddress()
+		if err != nil {
+			logger.Error("Failed to allocate service VIP address", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to allocate VIP address"})
+		}
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	vip := models.ServiceVIP{
+		Name:                 name,
+		Address:              strings.TrimSuffix(allocation.IP, "/32"),
+		AllocationID:         &allocation.ID,
+		CheckType:            checkType,
+		CheckTarget:          checkTarget,
+		CheckIntervalSeconds: interval,
+		CheckTimeoutSeconds:  timeout,
+		Rise:                 rise,
+		Fall:                 fall,
+		CreatedByID:          actorID,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&vip).Error; err != nil {
+			return err
+		}
+		for _, nodeID := range nodeIDs {
+			if err := tx.Create(&models.ServiceVIPAssignment{ServiceVIPID: vip.ID, NodeID: nodeID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = database.DB.Delete(&models.IPAllocation{}, allocation.ID).Error
+		logger.Error("Failed to create service VIP", "error", err, "name", name)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create service VIP"})
+	}
+
+	logger.Audit(c, "Created service VIP", actorID, "create", "ServiceVIP", "name", vip.Name, "address", vip.Address, "check_type", vip.CheckType, "node_ids", nodeIDs)
+
+	return c.Status(fiber.StatusCreated).JSON(vip)
+}
+
+func AdminSetServiceVIPNodes(c *fiber.Ctx) error {
+	vipID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || vipID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service VIP id"})
+	}
+
+	var vip models.ServiceVIP
+	if err := database.DB.First(&vip, vipID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Service VIP not found"})
+	}
+
+	var input struct {
+		NodeIDs []uint `json:"node_ids"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+
+	nodeIDs, err := loadServiceVIPNodeIDs(input.NodeIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("service_vip_id = ? AND node_id NOT IN ?", vip.ID, append(nodeIDs, 0)).
+			Delete(&models.ServiceVIPAssignment{}).Error; err != nil {
+			return err
+		}
+		for _, nodeID := range nodeIDs {
+			var existing models.ServiceVIPAssignment
+			if err := tx.Where("service_vip_id = ? AND node_id = ?", vip.ID, nodeID).First(&existing).Error; err == nil {
+				continue
+			}
+			if err := tx.Create(&models.ServiceVIPAssignment{ServiceVIPID: vip.ID, NodeID: nodeID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update assignments"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Updated service VIP nodes", actorID, "update", "ServiceVIP", "name", vip.Name, "node_ids", nodeIDs)
+
+	var assignments []models.ServiceVIPAssignment
+	database.DB.Where("service_vip_id = ?", vip.ID).Order("node_id asc").Find(&assignments)
+	return c.JSON(assignments)
+}
+
+func AdminDeleteServiceVIP(c *fiber.Ctx) error {
+	vipID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || vipID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service VIP id"})
+	}
+
+	var vip models.ServiceVIP
+	if err := database.DB.First(&vip, vipID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Service VIP not found"})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("service_vip_id = ?", vip.ID).Delete(&models.ServiceVIPAssignment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&vip).Error; err != nil {
+			return err
+		}
+		if vip.AllocationID != nil {
+			if err := tx.Delete(&models.IPAllocation{}, *vip.AllocationID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete service VIP"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Deleted service VIP", actorID, "delete", "ServiceVIP", "name", vip.Name, "address", vip.Address)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// updateServiceVIPHealth stores the per-VIP health an agent reported in its
+// heartbeat and raises an event whenever a node starts or stops announcing.
+func updateServiceVIPHealth(node *models.Node, reports []serviceVIPReport) {
+	var assignments []models.ServiceVIPAssignment
+	if err := database.DB.Preload("ServiceVIP").
+		Where("node_id = ?", node.ID).
+		Find(&assignments).Error; err != nil {
+		logger.Error("Failed to load service VIP assignments", "error", err, "node_id", node.ID)
+		return
+	}
+
+	byID := make(map[uint]serviceVIPReport, len(reports))
+	for _, r := range reports {
+		if r.ID != 0 {
+			byID[r.ID] = r
+		}
+	}
+
+	now := time.Now()
+	for _, assignment := range assignments {
+		// An assignment the agent didn't report on isn't announced.
+		r, ok := byID[assignment.ServiceVIPID]
+		if !ok {
+			r = serviceVIPReport{ID: assignment.ServiceVIPID, Message: "not reported by agent"}
+		}
+
+		wasAnnounced := assignment.Announced
+		if err := database.DB.Model(&models.ServiceVIPAssignment{}).
+			Where("id = ?", assignment.ID).
+			Updates(map[string]any{
+				"healthy":          r.Healthy,
+				"announced":        r.Announced,
+				"message":          truncateString(r.Message, 512),
+				"last_reported_at": &now,
+			}).Error; err != nil {
+			logger.Error("Failed to update service VIP health", "error", err, "node_id", node.ID, "service_vip_id", r.ID)
+			continue
+		}
+
+		if wasAnnounced == r.Announced {
+			continue
+		}
+		kind := models.EventKindServiceVIPDown
+		msg := fmt.Sprintf("Service VIP %s (%s) withdrawn on %s", assignment.ServiceVIP.Name, assignment.ServiceVIP.Address, node.Hostname)
+		if r.Announced {
+			kind = models.EventKindServiceVIPUp
+			msg = fmt.Sprintf("Service VIP %s (%s) announced on %s", assignment.ServiceVIP.Name, assignment.ServiceVIP.Address, node.Hostname)
+		}
+		if err := database.DB.Create(&models.Event{Kind: kind, NodeID: &node.ID, Message: msg}).Error; err != nil {
+			logger.Error("Failed to create service VIP event", "error", err, "node_id", node.ID)
+		}
+	}
+}
+
+type serviceVIPReport struct {
+	ID        uint   `json:"id"`
+	Address   string `json:"address"`
+	Healthy   bool   `json:"healthy"`
+	Announced bool   `json:"announced"`
+	Message   string `json:"message"`
+}
+
+func normalizeServiceVIPCheck(checkType string, target string) (models.ServiceVIPCheckType, string, error) {
+	target = strings.TrimSpace(target)
+	switch models.ServiceVIPCheckType(strings.ToLower(strings.TrimSpace(checkType))) {
+	case models.ServiceVIPCheckTCP:
+		if port, err := strconv.Atoi(target); err == nil {
+			target = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		}
+		host, portStr, err := net.SplitHostPort(target)
+		if err != nil || host == "" {
+			return "", "", fmt.Errorf("tcp check_target must be host:port or a port")
+		}
+		if port, err := strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+			return "", "", fmt.Errorf("tcp check_target has an invalid port")
+		}
+		return models.ServiceVIPCheckTCP, target, nil
+	case models.ServiceVIPCheckHTTP:
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", "", fmt.Errorf("http check_target must be an http(s) URL")
+		}
+		return models.ServiceVIPCheckHTTP, target, nil
+	case models.ServiceVIPCheckExec:
+		if target == "" {
+			return "", "", fmt.Errorf("exec check_target must be a command")
+		}
+		return models.ServiceVIPCheckExec, target, nil
+	default:
+		return "", "", fmt.Errorf("check_type must be tcp, http or exec")
+	}
+}
+
+func loadServiceVIPNodeIDs(ids []uint) ([]uint, error) {
+	seen := make(map[uint]bool)
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.Node{}).Where("id IN ?", out).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up nodes")
+	}
+	if int(count) != len(out) {
+		return nil, fmt.Errorf("node_ids contains unknown nodes")
+	}
+	return out, nil
+}
+
+func reserveServiceVIPAddress(address string) (*models.IPAllocation, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(address))
+	if err != nil || !addr.Is4() {
+		return nil, fmt.Errorf("address must be an IPv4 address")
+	}
+
+	if err := services.EnsureDefaultPools(); err != nil {
+		return nil, fmt.Errorf("failed to load service VIP pool")
+	}
+	var pool models.IPPool
+	if err := database.DB.Where("purpose = ?", models.IPPoolPurposeServiceVIP).First(&pool).Error; err != nil {
+		return nil, fmt.Errorf("service VIP pool not found")
+	}
+	prefix, err := netip.ParsePrefix(pool.CIDR)
+	if err != nil || !prefix.Contains(addr) {
+		return nil, fmt.Errorf("address must be inside the service VIP pool %s", pool.CIDR)
+	}
+
+	allocation := models.IPAllocation{
+		PoolID:  pool.ID,
+		IP:      addr.String() + "/32",
+		Purpose: string(models.IPPoolPurposeServiceVIP),
+	}
+	if err := database.DB.Create(&allocation).Error; err != nil {
+		return nil, fmt.Errorf("address %s is already allocated", addr)
+	}
+	return &allocation, nil
+}
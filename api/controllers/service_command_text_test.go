@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateCommandText(t *testing.T) {
+	assert.Equal(t, "ok", truncateCommandText("ok"))
+
+	// A multi-byte rune straddling the limit is dropped whole.
+	s := strings.Repeat("a", maxCommandText-1) + "é" + "tail"
+	out := truncateCommandText(s)
+	assert.True(t, utf8.ValidString(out))
+	assert.Equal(t, strings.Repeat("a", maxCommandText-1)+"\n[truncated]", out)
+}
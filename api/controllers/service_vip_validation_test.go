@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"gluon-api/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeServiceVIPCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkType  string
+		target     string
+		wantType   models.ServiceVIPCheckType
+		wantTarget string
+		wantErr    bool
+	}{
+		{name: "tcp host:port", checkType: "tcp", target: "10.0.0.5:443", wantType: models.ServiceVIPCheckTCP, wantTarget: "10.0.0.5:443"},
+		{name: "tcp bare port targets localhost", checkType: "TCP", target: " 8080 ", wantType: models.ServiceVIPCheckTCP, wantTarget: "127.0.0.1:8080"},
+		{name: "tcp port out of range", checkType: "tcp", target: "70000", wantErr: true},
+		{name: "tcp missing port", checkType: "tcp", target: "localhost", wantErr: true},
+		{name: "http url", checkType: "http", target: "http://127.0.0.1:8080/healthz", wantType: models.ServiceVIPCheckHTTP, wantTarget: "http://127.0.0.1:8080/healthz"},
+		{name: "http requires scheme", checkType: "http", target: "127.0.0.1/healthz", wantErr: true},
+		{name: "exec command", checkType: "exec", target: "systemctl is-active nginx", wantType: models.ServiceVIPCheckExec, wantTarget: "systemctl is-active nginx"},
+		{name: "exec empty", checkType: "exec", target: "  ", wantErr: true},
+		{name: "unknown type", checkType: "icmp", target: "10.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, gotTarget, err := normalizeServiceVIPCheck(tt.checkType, tt.target)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantType, gotType)
+			assert.Equal(t, tt.wantTarget, gotTarget)
+		})
+	}
+}
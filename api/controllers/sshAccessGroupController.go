@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type sshAccessGroupInput struct {
+	Name           *string   `json:"name"`
+	Description    *string   `json:"description"`
+	Selector       *string   `json:"selector"`
+	SudoCommands   *[]string `json:"sudo_commands"`
+	SudoNoPassword *bool     `json:"sudo_no_password"`
+
+	// Replaced by Selector; still decoded so a request using them is
+	// refused instead of silently granting access on every node.
+	Roles      any `json:"roles"`
+	Providers  any `json:"providers"`
+	HubNumbers any `json:"hub_numbers"`
+	Labels     any `json:"labels"`
+}
+
+// applySSHAccessGroupInput copies the fields present in input onto group.
+func applySSHAccessGroupInput(group *models.SSHAccessGroup, input *sshAccessGroupInput) string {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return "name is required"
+		}
+		group.Name = name
+	}
+	if input.Description != nil {
+		group.Description = strings.TrimSpace(*input.Description)
+	}
+
+	if input.Roles != nil || input.Providers != nil || input.HubNumbers != nil || input.Labels != nil {
+		return "roles, providers, hub_numbers and labels are replaced by selector, e.g. role=hub,provider in (hetzner),env=prod"
+	}
+	if input.Selector != nil {
+		if err := services.SetSSHAccessGroupSelector(group, *input.Selector); err != nil {
+			return err.Error()
+		}
+	}
+
+	if input.SudoCommands != nil {
+		if err := services.SetSSHAccessGroupSudo(group, *input.SudoCommands); err != nil {
+			return err.Error()
+		}
+	} else if len(group.SudoCommands) == 0 {
+		_ = services.SetSSHAccessGroupSudo(group, nil)
+	}
+	if input.SudoNoPassword != nil {
+		group.SudoNoPassword = *input.SudoNoPassword
+	}
+	return ""
+}
+
+func findSSHAccessGroup(c *fiber.Ctx) (*models.SSHAccessGroup, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid group ID"})
+	}
+	var group models.SSHAccessGroup
+	if err := database.DB.Preload("Members").First(&group, id).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "SSH access group not found"})
+	}
+	return &group, nil
+}
+
+// sshAccessGroupNodes lists the nodes a group reaches. Groups without
+// members push nothing, so they reach no node either.
+func sshAccessGroupNodes(group *models.SSHAccessGroup) []uint {
+	if len(group.Members) == 0 {
+		return nil
+	}
+	ids, err := services.SSHAccessGroupNodeIDs(group)
+	if err != nil {
+		logger.Error("Failed to resolve SSH access group nodes", "error", err, "group_id", group.ID)
+	}
+	return ids
+}
+
+// refreshSSHAccessGroupNodes bumps the config of every node the group
+// reached before or reaches after a change.
+func refreshSSHAccessGroupNodes(before []uint, group *models.SSHAccessGroup) []uint {
+	affected := uniqueNodeIDs(append(before, sshAccessGroupNodes(group)...))
+	refreshNodeConfigs(affected)
+	return affected
+}
+
+func uniqueNodeIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func AdminListSSHAccessGroups(c *fiber.Ctx) error {
+	var groups []models.SSHAccessGroup
+	if err := database.DB.Preload("Members").Order("name asc").Find(&groups).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list SSH access groups"})
+	}
+	return c.JSON(fiber.Map{"groups": groups})
+}
+
+func AdminGetSSHAccessGroup(c *fiber.Ctx) error {
+	group, err := findSSHAccessGroup(c)
+	if group == nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"group": group, "node_ids": sshAccessGroupNodes(group)})
+}
+
+// AdminCreateSSHAccessGroup creates an empty group; members are added
+// separately, so no node config changes yet.
+func AdminCreateSSHAccessGroup(c *fiber.Ctx) error {
+	var input sshAccessGroupInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if input.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	group := models.SSHAccessGroup{CreatedByID: actorID}
+	if msg := applySSHAccessGroupInput(&group, &input); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	var count int64
+	database.DB.Model(&models.SSHAccessGroup{}).Where("name = ?", group.Name).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "SSH access group name already exists"})
+	}
+	if err := database.DB.Create(&group).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create SSH access group"})
+	}
+
+	logger.Audit(c, "Created SSH access group", actorID, "create", "SSHAccessGroup",
+		"group_id", group.ID, "name", group.Name, "sudo_commands", string(group.SudoCommands))
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+// AdminUpdateSSHAccessGroup changes the selector or sudo rule; nodes that
+// enter or leave the selector get a new config version.
+func AdminUpdateSSHAccessGroup(c *fiber.Ctx) error {
+	group, err := findSSHAccessGroup(c)
+	if group == nil {
+		return err
+	}
+	var input sshAccessGroupInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	before := sshAccessGroupNodes(group)
+	if msg := applySSHAccessGroupInput(group, &input); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	var count int64
+	database.DB.Model(&models.SSHAccessGroup{}).Where("name = ? AND id <> ?", group.Name, group.ID).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "SSH access group name already exists"})
+	}
+	if err := database.DB.Omit("Members").Save(group).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update SSH access group"})
+	}
+	affected := refreshSSHAccessGroupNodes(before, group)
+
+	logger.Audit(c, "Updated SSH access group", actorID, "update", "SSHAccessGroup",
+		"group_id", group.ID, "name", group.Name, "affected_nodes", len(affected))
+	return c.JSON(fiber.Map{"group": group, "affected_node_ids": affected})
+}
+
+func AdminDeleteSSHAccessGroup(c *fiber.Ctx) error {
+	group, err := findSSHAccessGroup(c)
+	if group == nil {
+		return err
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	before := sshAccessGroupNodes(group)
+	if err := database.DB.Where("group_id = ?", group.ID).Delete(&models.SSHAccessGroupMember{}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete SSH access group"})
+	}
+	if err := database.DB.Delete(group).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete SSH access group"})
+	}
+	group.Members = nil
+	affected := refreshSSHAccessGroupNodes(before, group)
+
+	logger.Audit(c, "Deleted SSH access group", actorID, "delete", "SSHAccessGroup",
+		"group_id", group.ID, "name", group.Name, "affected_nodes", len(affected))
+	return c.JSON(fiber.Map{"message": "deleted", "affected_node_ids": affected})
+}
+
+func AdminAddSSHAccessGroupMember(c *fiber.Ctx) error {
+	group, err := findSSHAccessGroup(c)
+	if group == nil {
+		return err
+	}
+	var input struct {
+		Username  string `json:"username"`
+		PublicKey string `json:"public_key"`
+		Comment   string `json:"comment"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	username := strings.TrimSpace(input.Username)
+	line, err := services.ValidateSSHAccessMember(username, input.PublicKey, input.Comment)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, m := range group.Members {
+		if m.Username == username && m.PublicKey == line {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Member already exists"})
+		}
+	}
+
+	before := sshAccessGroupNodes(group)
+	member := models.SSHAccessGroupMember{
+		GroupID:   group.ID,
+		Username:  username,
+		PublicKey: line,
+		Comment:   strings.TrimSpace(input.Comment),
+	}
+	if err := database.DB.Create(&member).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to add member"})
+	}
+	group.Members = append(group.Members, member)
+	affected := refreshSSHAccessGroupNodes(before, group)
+
+	logger.Audit(c, "Added SSH access group member", actorID, "add_member", "SSHAccessGroup",
+		"group_id", group.ID, "member_id", member.ID, "username", username, "affected_nodes", len(affected))
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"member": member, "affected_node_ids": affected})
+}
+
+func AdminRemoveSSHAccessGroupMember(c *fiber.Ctx) error {
+	group, err := findSSHAccessGroup(c)
+	if group == nil {
+		return err
+	}
+	memberID, err := strconv.ParseUint(c.Params("memberId"), 10, 64)
+	if err != nil || memberID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid member ID"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	idx := -1
+	for i, m := range group.Members {
+		if uint64(m.ID) == memberID {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Member not found"})
+	}
+	member := group.Members[idx]
+
+	before := sshAccessGroupNodes(group)
+	if err := database.DB.Delete(&member).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to remove member"})
+	}
+	group.Members = append(group.Members[:idx], group.Members[idx+1:]...)
+	affected := refreshSSHAccessGroupNodes(before, group)
+
+	logger.Audit(c, "Removed SSH access group member", actorID, "remove_member", "SSHAccessGroup",
+		"group_id", group.ID, "member_id", member.ID, "username", member.Username, "affected_nodes", len(affected))
+	return c.JSON(fiber.Map{"message": "removed", "affected_node_ids": affected})
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminGetSSHUserCA returns the CA public key, e.g. to trust it on hosts
+// the agent does not manage.
+func AdminGetSSHUserCA(c *fiber.Ctx) error {
+	key, err := services.SSHUserCAPublicKey()
+	if err != nil {
+		logger.Error("Failed to load SSH user CA", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load SSH user CA"})
+	}
+	return c.JSON(fiber.Map{"public_key": key})
+}
+
+func AdminListSSHCertificates(c *fiber.Ctx) error {
+	q := database.DB.Order("id desc").Limit(200)
+	if c.QueryBool("active") {
+		q = q.Where("revoked_at IS NULL AND valid_before > ?", time.Now())
+	}
+	var certs []models.SSHCertificate
+	if err := q.Find(&certs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list SSH certificates"})
+	}
+	return c.JSON(fiber.Map{"certificates": certs})
+}
+
+// AdminIssueSSHCertificate signs the caller's public key for a login on
+// the chosen nodes, valid for a limited time.
+func AdminIssueSSHCertificate(c *fiber.Ctx) error {
+	user, err := getUserFromToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var input struct {
+		PublicKey  string `json:"public_key"`
+		Login      string `json:"login"`
+		NodeIDs    []uint `json:"node_ids"`
+		AllNodes   bool   `json:"all_nodes"`
+		TTLMinutes int    `json:"ttl_minutes"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if input.TTLMinutes < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ttl_minutes must be positive"})
+	}
+
+	record, cert, err := services.IssueSSHCertificate(services.SSHCertificateRequest{
+		PublicKey: input.PublicKey,
+		Login:     input.Login,
+		NodeIDs:   input.NodeIDs,
+		AllNodes:  input.AllNodes,
+		TTL:       time.Duration(input.TTLMinutes) * time.Minute,
+		UserID:    &user.ID,
+		Username:  user.Email,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	logger.Audit(c, "Issued SSH certificate", &user.ID, "issue", "SSHCertificate",
+		"certificate_id", record.ID, "key_id", record.KeyID, "login", record.Login, "fingerprint", record.Fingerprint,
+		"principals", string(record.Principals), "valid_before", record.ValidBefore)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"certificate":  cert,
+		"serial":       record.ID,
+		"key_id":       record.KeyID,
+		"valid_before": record.ValidBefore,
+		"principals":   record.Principals,
+		"login":        record.Login,
+	})
+}
+
+// AdminRevokeSSHCertificate adds the certificate's key to the revoked keys
+// pushed to every node.
+func AdminRevokeSSHCertificate(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid certificate ID"})
+	}
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	record, err := services.RevokeSSHCertificate(uint(id), actorID)
+	if errors.Is(err, services.ErrSSHCertificateNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "SSH certificate not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke SSH certificate"})
+	}
+
+	logger.Audit(c, "Revoked SSH certificate", actorID, "revoke", "SSHCertificate",
+		"certificate_id", record.ID, "key_id", record.KeyID, "fingerprint", record.Fingerprint)
+	return c.JSON(fiber.Map{"message": "revoked", "certificate": record})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"gluon-api/services"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AdminListWorkerShortcuts(c *fiber.Ctx) error {
+	var shortcuts []models.WorkerShortcut
+	if err := database.DB.
+		Preload("NodeA").
+		Preload("NodeB").
+		Order("id asc").
+		Find(&shortcuts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve shortcuts"})
+	}
+
+	var samples []models.WorkerTrafficSample
+	if err := database.DB.Order("bytes_per_second desc").Find(&samples).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve traffic samples"})
+	}
+
+	return c.JSON(fiber.Map{
+		"shortcuts": shortcuts,
+		"traffic":   samples,
+	})
+}
+
+// AdminPinWorkerShortcut creates (or pins an existing) shortcut between two
+// workers. Pinned shortcuts are never removed for being idle.
+func AdminPinWorkerShortcut(c *fiber.Ctx) error {
+	var input struct {
+		NodeAID uint   `json:"node_a_id"`
+		NodeBID uint   `json:"node_b_id"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
+	}
+	if input.NodeAID == 0 || input.NodeBID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "node_a_id and node_b_id are required"})
+	}
+	if input.NodeAID == input.NodeBID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A shortcut needs two different nodes"})
+	}
+
+	var a, b models.Node
+	if err := database.DB.First(&a, input.NodeAID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if err := database.DB.First(&b, input.NodeBID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Node not found"})
+	}
+	if a.Role != models.NodeRoleWorker || b.Role != models.NodeRoleWorker {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Shortcuts can only connect two workers"})
+	}
+	if a.Status == models.NodeStatusDecommissioned || b.Status == models.NodeStatusDecommissioned {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Node is decommissioned"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		reason = "pinned by admin"
+	}
+
+	shortcut, err := services.CreateWorkerShortcut(&a, &b, true, truncateString(reason, 200), actorID)
+	if errors.Is(err, services.ErrShortcutBothBehindNAT) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Both workers are behind NAT"})
+	}
+	if err != nil {
+		logger.Error("Failed to create worker shortcut", "error", err, "node_a_id", a.ID, "node_b_id", b.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create shortcut"})
+	}
+
+	logger.Audit(c, "Pinned worker shortcut", actorID, "create", "WorkerShortcut", "node_a_id", shortcut.NodeAID, "node_b_id", shortcut.NodeBID)
+
+	return c.Status(fiber.StatusCreated).JSON(shortcut)
+}
+
+func AdminDeleteWorkerShortcut(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil || id == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shortcut id"})
+	}
+
+	var shortcut models.WorkerShortcut
+	if err := database.DB.First(&shortcut, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Shortcut not found"})
+	}
+
+	if err := services.RemoveWorkerShortcut(&shortcut); err != nil {
+		logger.Error("Failed to remove worker shortcut", "error", err, "shortcut_id", shortcut.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete shortcut"})
+	}
+
+	var actorID *uint
+	if user, err := getUserFromToken(c); err == nil {
+		actorID = &user.ID
+	}
+	logger.Audit(c, "Removed worker shortcut", actorID, "delete", "WorkerShortcut", "node_a_id", shortcut.NodeAID, "node_b_id", shortcut.NodeBID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
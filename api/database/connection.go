@@ -58,14 +58,26 @@ func ConnectDB() (*gorm.DB, error) {
 		&models.IPAllocation{},
 		&models.LinkAllocation{},
 
+		&models.BootstrapToken{},
 		&models.NodeEnrollmentRequest{},
 		&models.Node{},
+		&models.NodeLabel{},
 		&models.WireGuardInterface{},
 		&models.NodePeer{},
+		&models.NodePrefix{},
+		&models.ServiceVIP{},
+		&models.ServiceVIPAssignment{},
+		&models.WorkerShortcut{},
+		&models.WorkerTrafficSample{},
 
 		&models.NodeConfig{},
+		&models.NodeConfigPlan{},
 		&models.NodeSSHAuthorizedKey{},
+		&models.SSHCertificate{},
+		&models.SSHAccessGroup{},
+		&models.SSHAccessGroupMember{},
 		&models.NodeCommand{},
+		&models.CommandJob{},
 
 		&models.APIKey{},
 
@@ -73,15 +85,32 @@ func ConnectDB() (*gorm.DB, error) {
 		&models.OSPFProfile{},
 
 		&models.KubernetesCluster{},
+		&models.KubernetesManifestRevision{},
+		&models.KubernetesApplication{},
+		&models.KubernetesUpgrade{},
+		&models.KubernetesUpgradeNode{},
+		&models.EtcdSnapshot{},
+		&models.EtcdRestore{},
+		&models.KubernetesFailoverPolicy{},
+		&models.KubernetesFailover{},
 		&models.DeploymentSettings{},
 
 		&models.AuditLog{},
+		&models.UserPermission{},
 		&models.Event{},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Failover policies became unique per cluster; the old index would
+	// still refuse the same workload name in a second cluster.
+	if db.Migrator().HasIndex(&models.KubernetesFailoverPolicy{}, "idx_failover_policy_target") {
+		if err := db.Migrator().DropIndex(&models.KubernetesFailoverPolicy{}, "idx_failover_policy_target"); err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
@@ -16,15 +16,39 @@ type OSPFInterface struct {
 	PrefixSuppression bool
 }
 
+// AdvertisedPrefix is an extra prefix redistributed into OSPF. Kind is
+// "connected" or "static"; static prefixes without a NextHop are blackholed.
+type AdvertisedPrefix struct {
+	Prefix  string
+	Kind    string
+	NextHop string
+}
+
 type FRRConfig struct {
-	Hostname       string
-	RouterID       string
-	IsHub          bool
-	LoopbackIP     string
-	Interfaces     []OSPFInterface
-	OSPFArea       int
+	Hostname           string
+	RouterID           string
+	IsHub              bool
+	LoopbackIP         string
+	Interfaces         []OSPFInterface
+	OSPFArea           int
+	AdvertisedPrefixes []AdvertisedPrefix
+
+	// PodCIDRs are set when pod routes are carried natively over the
+	// fabric (Calico in BGP mode), one per such cluster. Hubs then act as
+	// BGP route reflectors for the Calico nodes in LoopbackCIDR; next hops
+	// are node loopbacks, which OSPF already resolves.
+	PodCIDRs     []string
+	LoopbackCIDR string
 }
 
+const (
+	// PodBGPASN is the private AS shared by Calico and the hubs' FRR.
+	PodBGPASN = 64512
+	// PodBGPPort is where the hubs' bgpd listens, since Calico's BIRD
+	// already holds 179 on the same host.
+	PodBGPPort = 1179
+)
+
 func GenerateFRRConfig(config FRRConfig) string {
 	var sb strings.Builder
 
@@ -33,8 +57,14 @@ func GenerateFRRConfig(config FRRConfig) string {
 	sb.WriteString(fmt.Sprintf("hostname %s\n", config.Hostname))
 	sb.WriteString("log syslog informational\n")
 
+	connected, static := splitAdvertisedPrefixes(config.AdvertisedPrefixes)
+
 	if !config.IsHub {
-		sb.WriteString("no ip forwarding\n")
+		if len(connected) > 0 || len(static) > 0 || len(config.PodCIDRs) > 0 {
+			sb.WriteString("ip forwarding\n")
+		} else {
+			sb.WriteString("no ip forwarding\n")
+		}
 	}
 	sb.WriteString("no ipv6 forwarding\n")
 	sb.WriteString("service integrated-vtysh-config\n")
@@ -77,6 +107,20 @@ func GenerateFRRConfig(config FRRConfig) string {
 		sb.WriteString("!\n")
 	}
 
+	writeRedistributionPolicy(&sb, "CONNECTED", connected)
+	writeRedistributionPolicy(&sb, "STATIC", static)
+
+	for _, p := range static {
+		nextHop := strings.TrimSpace(p.NextHop)
+		if nextHop == "" {
+			nextHop = "blackhole"
+		}
+		sb.WriteString(fmt.Sprintf("ip route %s %s\n", p.Prefix, nextHop))
+	}
+	if len(static) > 0 {
+		sb.WriteString("!\n")
+	}
+
 	sb.WriteString("router ospf\n")
 	sb.WriteString(fmt.Sprintf(" ospf router-id %s\n", config.RouterID))
 
@@ -86,9 +130,19 @@ func GenerateFRRConfig(config FRRConfig) string {
 	}
 
 	sb.WriteString(" passive-interface default\n")
+	if len(connected) > 0 {
+		sb.WriteString(" redistribute connected route-map RM_GLUON_CONNECTED\n")
+	}
+	if len(static) > 0 {
+		sb.WriteString(" redistribute static route-map RM_GLUON_STATIC\n")
+	}
 	sb.WriteString("exit\n")
 	sb.WriteString("!\n")
 
+	if config.IsHub && len(config.PodCIDRs) > 0 {
+		writePodBGP(&sb, config)
+	}
+
 	if !config.IsHub && config.LoopbackIP != "" {
 		sb.WriteString("ip protocol ospf route-map RM_SET_SRC\n")
 		sb.WriteString("!\n")
@@ -97,7 +151,77 @@ func GenerateFRRConfig(config FRRConfig) string {
 	return sb.String()
 }
 
-func GenerateFRRConfigForWorker(hostname string, loopbackIP string, hubInterfaces []string) string {
+// writePodBGP renders the hub side of native pod routing: any Calico node
+// on a loopback may connect, only pod prefixes are accepted or sent, and
+// the hub's own pod blocks (the blackhole routes BIRD installs for them)
+// are announced since Calico never peers a node with itself.
+func writePodBGP(sb *strings.Builder, config FRRConfig) {
+	for i, cidr := range config.PodCIDRs {
+		sb.WriteString(fmt.Sprintf("ip prefix-list PL_GLUON_PODS seq %d permit %s le 32\n", (i+1)*5, cidr))
+	}
+	sb.WriteString("!\n")
+	sb.WriteString("route-map RM_GLUON_PODS permit 10\n")
+	sb.WriteString(" match ip address prefix-list PL_GLUON_PODS\n")
+	sb.WriteString("exit\n")
+	sb.WriteString("!\n")
+	sb.WriteString("route-map RM_GLUON_LOCAL_PODS permit 10\n")
+	sb.WriteString(" match ip address prefix-list PL_GLUON_PODS\n")
+	sb.WriteString(" match ip next-hop type blackhole\n")
+	sb.WriteString("exit\n")
+	sb.WriteString("!\n")
+
+	sb.WriteString(fmt.Sprintf("router bgp %d\n", PodBGPASN))
+	sb.WriteString(fmt.Sprintf(" bgp router-id %s\n", config.RouterID))
+	sb.WriteString(" no bgp default ipv4-unicast\n")
+	sb.WriteString(" neighbor CALICO peer-group\n")
+	sb.WriteString(fmt.Sprintf(" neighbor CALICO remote-as %d\n", PodBGPASN))
+	if config.LoopbackCIDR != "" {
+		sb.WriteString(fmt.Sprintf(" bgp listen range %s peer-group CALICO\n", config.LoopbackCIDR))
+	}
+	sb.WriteString(" !\n")
+	sb.WriteString(" address-family ipv4 unicast\n")
+	sb.WriteString("  redistribute kernel route-map RM_GLUON_LOCAL_PODS\n")
+	sb.WriteString("  neighbor CALICO activate\n")
+	sb.WriteString("  neighbor CALICO route-reflector-client\n")
+	sb.WriteString("  neighbor CALICO route-map RM_GLUON_PODS in\n")
+	sb.WriteString("  neighbor CALICO route-map RM_GLUON_PODS out\n")
+	sb.WriteString(" exit-address-family\n")
+	sb.WriteString("exit\n")
+	sb.WriteString("!\n")
+}
+
+func splitAdvertisedPrefixes(prefixes []AdvertisedPrefix) ([]AdvertisedPrefix, []AdvertisedPrefix) {
+	var connected, static []AdvertisedPrefix
+	for _, p := range prefixes {
+		if strings.TrimSpace(p.Prefix) == "" {
+			continue
+		}
+		if p.Kind == "static" {
+			static = append(static, p)
+		} else {
+			connected = append(connected, p)
+		}
+	}
+	return connected, static
+}
+
+// writeRedistributionPolicy renders a prefix-list and matching route-map so
+// only the configured prefixes leak into OSPF, never arbitrary local routes.
+func writeRedistributionPolicy(sb *strings.Builder, name string, prefixes []AdvertisedPrefix) {
+	if len(prefixes) == 0 {
+		return
+	}
+	for i, p := range prefixes {
+		sb.WriteString(fmt.Sprintf("ip prefix-list PL_GLUON_%s seq %d permit %s\n", name, (i+1)*5, p.Prefix))
+	}
+	sb.WriteString("!\n")
+	sb.WriteString(fmt.Sprintf("route-map RM_GLUON_%s permit 10\n", name))
+	sb.WriteString(fmt.Sprintf(" match ip address prefix-list PL_GLUON_%s\n", name))
+	sb.WriteString("exit\n")
+	sb.WriteString("!\n")
+}
+
+func GenerateFRRConfigForWorker(hostname string, loopbackIP string, hubInterfaces []string, shortcutInterfaces []string, prefixes []AdvertisedPrefix, podCIDRs []string) string {
 	cfg := config.Current()
 	interfaces := []OSPFInterface{
 		{
@@ -106,10 +230,28 @@ func GenerateFRRConfigForWorker(hostname string, loopbackIP string, hubInterface
 		},
 	}
 
+	// Hub paths cost more once the worker has shortcuts, so OSPF prefers
+	// the shortcut for the peer's loopback while the hubs remain the
+	// fallback.
+	hubCost := cfg.OSPFWorkerToHubCost
+	if len(shortcutInterfaces) > 0 && cfg.OSPFWorkerShortcutHubCost > hubCost {
+		hubCost = cfg.OSPFWorkerShortcutHubCost
+	}
 	for _, ifaceName := range hubInterfaces {
 		interfaces = append(interfaces, OSPFInterface{
 			Name:              ifaceName,
-			Cost:              cfg.OSPFWorkerToHubCost,
+			Cost:              hubCost,
+			IsPointToPoint:    true,
+			HelloInterval:     cfg.OSPFHelloInterval,
+			DeadInterval:      cfg.OSPFDeadInterval,
+			PrefixSuppression: true,
+		})
+	}
+
+	for _, ifaceName := range shortcutInterfaces {
+		interfaces = append(interfaces, OSPFInterface{
+			Name:              ifaceName,
+			Cost:              cfg.OSPFWorkerShortcutCost,
 			IsPointToPoint:    true,
 			HelloInterval:     cfg.OSPFHelloInterval,
 			DeadInterval:      cfg.OSPFDeadInterval,
@@ -124,12 +266,15 @@ func GenerateFRRConfigForWorker(hostname string, loopbackIP string, hubInterface
 		LoopbackIP: loopbackIP,
 		Interfaces: interfaces,
 		OSPFArea:   cfg.OSPFArea,
+
+		AdvertisedPrefixes: prefixes,
+		PodCIDRs:           podCIDRs,
 	}
 
 	return GenerateFRRConfig(config)
 }
 
-func GenerateFRRConfigForHub(hostname string, loopbackIP string, hubToHubInterfaces []string, workerInterfaces []string) string {
+func GenerateFRRConfigForHub(hostname string, loopbackIP string, hubToHubInterfaces []string, workerInterfaces []string, prefixes []AdvertisedPrefix, podCIDRs []string) string {
 	cfg := config.Current()
 	interfaces := []OSPFInterface{
 		{
@@ -168,6 +313,10 @@ func GenerateFRRConfigForHub(hostname string, loopbackIP string, hubToHubInterfa
 		LoopbackIP: loopbackIP,
 		Interfaces: interfaces,
 		OSPFArea:   cfg.OSPFArea,
+
+		AdvertisedPrefixes: prefixes,
+		PodCIDRs:           podCIDRs,
+		LoopbackCIDR:       cfg.LoopbackCIDR,
 	}
 
 	return GenerateFRRConfig(config)
@@ -148,6 +148,100 @@ func TestGenerateFRRConfig(t *testing.T) {
 				assert.NotContains(t, result, "ip ospf network point-to-point")
 			},
 		},
+		{
+			name: "worker with advertised prefixes redistributes through route-maps",
+			config: FRRConfig{
+				Hostname:   "worker-lan",
+				RouterID:   "10.255.0.20",
+				IsHub:      false,
+				LoopbackIP: "10.255.0.20",
+				Interfaces: []OSPFInterface{
+					{Name: "dummy", IsDummy: true},
+				},
+				OSPFArea: 0,
+				AdvertisedPrefixes: []AdvertisedPrefix{
+					{Prefix: "192.168.50.0/24", Kind: "connected"},
+					{Prefix: "172.16.10.0/24", Kind: "static", NextHop: "192.168.50.1"},
+					{Prefix: "172.16.20.0/24", Kind: "static"},
+				},
+			},
+			checks: func(t *testing.T, result string) {
+				// Worker must forward once it fronts a LAN
+				assert.Contains(t, result, "ip forwarding")
+				assert.NotContains(t, result, "no ip forwarding")
+				// Prefix lists and route-maps
+				assert.Contains(t, result, "ip prefix-list PL_GLUON_CONNECTED seq 5 permit 192.168.50.0/24")
+				assert.Contains(t, result, "ip prefix-list PL_GLUON_STATIC seq 5 permit 172.16.10.0/24")
+				assert.Contains(t, result, "ip prefix-list PL_GLUON_STATIC seq 10 permit 172.16.20.0/24")
+				assert.Contains(t, result, "route-map RM_GLUON_CONNECTED permit 10\n match ip address prefix-list PL_GLUON_CONNECTED")
+				assert.Contains(t, result, "route-map RM_GLUON_STATIC permit 10\n match ip address prefix-list PL_GLUON_STATIC")
+				// Static routes
+				assert.Contains(t, result, "ip route 172.16.10.0/24 192.168.50.1")
+				assert.Contains(t, result, "ip route 172.16.20.0/24 blackhole")
+				// Redistribution inside router ospf
+				assert.Contains(t, result, " redistribute connected route-map RM_GLUON_CONNECTED\n")
+				assert.Contains(t, result, " redistribute static route-map RM_GLUON_STATIC\n")
+			},
+		},
+		{
+			name: "no advertised prefixes means no redistribution",
+			config: FRRConfig{
+				Hostname:   "hub-plain",
+				RouterID:   "10.255.0.1",
+				IsHub:      true,
+				LoopbackIP: "10.255.0.1",
+				Interfaces: []OSPFInterface{{Name: "dummy", IsDummy: true}},
+				OSPFArea:   0,
+			},
+			checks: func(t *testing.T, result string) {
+				assert.NotContains(t, result, "redistribute")
+				assert.NotContains(t, result, "ip prefix-list")
+				assert.NotContains(t, result, "ip route ")
+				assert.NotContains(t, result, "router bgp")
+			},
+		},
+		{
+			name: "hub with native pod routing reflects Calico routes",
+			config: FRRConfig{
+				Hostname:     "hub1",
+				RouterID:     "10.255.0.1",
+				IsHub:        true,
+				LoopbackIP:   "10.255.0.1",
+				Interfaces:   []OSPFInterface{{Name: "dummy", IsDummy: true}},
+				OSPFArea:     10,
+				PodCIDRs:     []string{"10.244.0.0/16", "10.245.0.0/16"},
+				LoopbackCIDR: "10.255.0.0/22",
+			},
+			checks: func(t *testing.T, result string) {
+				assert.Contains(t, result, "ip prefix-list PL_GLUON_PODS seq 5 permit 10.244.0.0/16 le 32")
+				assert.Contains(t, result, "ip prefix-list PL_GLUON_PODS seq 10 permit 10.245.0.0/16 le 32")
+				assert.Contains(t, result, "router bgp 64512\n bgp router-id 10.255.0.1\n")
+				assert.Contains(t, result, " bgp listen range 10.255.0.0/22 peer-group CALICO\n")
+				assert.Contains(t, result, "  neighbor CALICO route-reflector-client\n")
+				assert.Contains(t, result, "  neighbor CALICO route-map RM_GLUON_PODS in\n")
+				assert.Contains(t, result, "  redistribute kernel route-map RM_GLUON_LOCAL_PODS\n")
+				assert.Contains(t, result, " match ip next-hop type blackhole\n")
+				// Pod routes stay in BGP; OSPF only carries the loopbacks.
+				assert.NotContains(t, result, " redistribute bgp")
+			},
+		},
+		{
+			name: "worker with native pod routing forwards but runs no BGP",
+			config: FRRConfig{
+				Hostname:   "worker1",
+				RouterID:   "10.255.0.10",
+				IsHub:      false,
+				LoopbackIP: "10.255.0.10",
+				Interfaces: []OSPFInterface{{Name: "dummy", IsDummy: true}},
+				OSPFArea:   10,
+				PodCIDRs:   []string{"10.244.0.0/16"},
+			},
+			checks: func(t *testing.T, result string) {
+				assert.Contains(t, result, "ip forwarding")
+				assert.NotContains(t, result, "no ip forwarding")
+				assert.NotContains(t, result, "router bgp")
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -160,11 +254,12 @@ func TestGenerateFRRConfig(t *testing.T) {
 
 func TestGenerateFRRConfigForWorker(t *testing.T) {
 	tests := []struct {
-		name          string
-		hostname      string
-		loopbackIP    string
-		hubInterfaces []string
-		checks        func(t *testing.T, result string)
+		name               string
+		hostname           string
+		loopbackIP         string
+		hubInterfaces      []string
+		shortcutInterfaces []string
+		checks             func(t *testing.T, result string)
 	}{
 		{
 			name:          "worker with two hub interfaces",
@@ -235,11 +330,24 @@ func TestGenerateFRRConfigForWorker(t *testing.T) {
 				assert.NotContains(t, result, "ip ospf cost")
 			},
 		},
+		{
+			name:               "worker with shortcuts raises its hub path cost",
+			hostname:           "worker-fast",
+			loopbackIP:         "10.255.0.60",
+			hubInterfaces:      []string{"wg-hub1"},
+			shortcutInterfaces: []string{"wg-w7"},
+			checks: func(t *testing.T, result string) {
+				// Worker shortcut hub cost (default: 50)
+				assert.Contains(t, result, "interface wg-hub1\n ip ospf area 10\n ip ospf cost 50\n")
+				// Worker shortcut cost (default: 5)
+				assert.Contains(t, result, "interface wg-w7\n ip ospf area 10\n ip ospf cost 5\n")
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := GenerateFRRConfigForWorker(tt.hostname, tt.loopbackIP, tt.hubInterfaces)
+			result := GenerateFRRConfigForWorker(tt.hostname, tt.loopbackIP, tt.hubInterfaces, tt.shortcutInterfaces, nil, nil)
 			tt.checks(t, result)
 		})
 	}
@@ -372,7 +480,7 @@ func TestGenerateFRRConfigForHub(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := GenerateFRRConfigForHub(tt.hostname, tt.loopbackIP, tt.hubToHubInterfaces, tt.workerInterfaces)
+			result := GenerateFRRConfigForHub(tt.hostname, tt.loopbackIP, tt.hubToHubInterfaces, tt.workerInterfaces, nil, nil)
 			tt.checks(t, result)
 		})
 	}
@@ -0,0 +1,223 @@
+// Package kube is the API's connection to the Kubernetes cluster it manages.
+// Read-mostly views are served from informer caches so a page load doesn't
+// turn into a burst of list calls; writes go through server-side apply.
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// FieldManager is the server-side apply field manager for everything the API
+// writes to the cluster.
+const FieldManager = "gluon"
+
+// ErrNotConfigured is returned while there is no kubeconfig, i.e. before the
+// cluster has been bootstrapped.
+var ErrNotConfigured = errors.New("kubernetes is not configured")
+
+const resyncPeriod = 10 * time.Minute
+
+type Client struct {
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Mapper    meta.RESTMapper
+
+	// restConfig is needed for streaming calls (exec); nil for fake clients.
+	restConfig *rest.Config
+
+	factory informers.SharedInformerFactory
+	synced  []cache.InformerSynced
+	start   sync.Once
+	stop    chan struct{}
+
+	Deployments  appslisters.DeploymentLister
+	DaemonSets   appslisters.DaemonSetLister
+	StatefulSets appslisters.StatefulSetLister
+	Jobs         batchlisters.JobLister
+	Pods         corelisters.PodLister
+	Services     corelisters.ServiceLister
+	Nodes        corelisters.NodeLister
+	Ingresses    networkinglisters.IngressLister
+}
+
+// New builds a client for the cluster described by restConfig.
+func New(restConfig *rest.Config) (*Client, error) {
+	cs, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(cs.Discovery()))
+
+	c := NewFromInterfaces(cs, dyn, mapper)
+	c.restConfig = restConfig
+	return c, nil
+}
+
+// NewFromInterfaces wires a client around existing interfaces, e.g. the fake
+// clientset in tests.
+func NewFromInterfaces(cs kubernetes.Interface, dyn dynamic.Interface, mapper meta.RESTMapper) *Client {
+	factory := informers.NewSharedInformerFactory(cs, resyncPeriod)
+	c := &Client{
+		Clientset: cs,
+		Dynamic:   dyn,
+		Mapper:    mapper,
+		factory:   factory,
+		stop:      make(chan struct{}),
+	}
+
+	apps := factory.Apps().V1()
+	c.Deployments = apps.Deployments().Lister()
+	c.DaemonSets = apps.DaemonSets().Lister()
+	c.StatefulSets = apps.StatefulSets().Lister()
+	c.Jobs = factory.Batch().V1().Jobs().Lister()
+	c.Pods = factory.Core().V1().Pods().Lister()
+	c.Services = factory.Core().V1().Services().Lister()
+	c.Nodes = factory.Core().V1().Nodes().Lister()
+	c.Ingresses = factory.Networking().V1().Ingresses().Lister()
+
+	c.synced = []cache.InformerSynced{
+		apps.Deployments().Informer().HasSynced,
+		apps.DaemonSets().Informer().HasSynced,
+		apps.StatefulSets().Informer().HasSynced,
+		factory.Batch().V1().Jobs().Informer().HasSynced,
+		factory.Core().V1().Pods().Informer().HasSynced,
+		factory.Core().V1().Services().Informer().HasSynced,
+		factory.Core().V1().Nodes().Informer().HasSynced,
+		factory.Networking().V1().Ingresses().Informer().HasSynced,
+	}
+	return c
+}
+
+// WaitForCache starts the informers on first use and blocks until their
+// caches are filled or ctx expires.
+func (c *Client) WaitForCache(ctx context.Context) error {
+	c.start.Do(func() {
+		c.factory.Start(c.stop)
+	})
+
+	if !cache.WaitForCacheSync(mergeStop(ctx, c.stop), c.synced...) {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("timed out waiting for kubernetes caches: %w", err)
+		}
+		return errors.New("kubernetes client stopped")
+	}
+	return nil
+}
+
+// Stop shuts the informers down.
+func (c *Client) Stop() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	c.factory.Shutdown()
+}
+
+func mergeStop(ctx context.Context, stop <-chan struct{}) <-chan struct{} {
+	out := make(chan struct{})
+	go func() {
+		defer close(out)
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+	}()
+	return out
+}
+
+// KubeconfigPath is the admin kubeconfig the API talks to the cluster with.
+func KubeconfigPath() string {
+	if p := strings.TrimSpace(os.Getenv("GLUON_KUBECONFIG")); p != "" {
+		return p
+	}
+	return "/etc/kubernetes/admin.conf"
+}
+
+type sharedClient struct {
+	client  *Client
+	modTime time.Time
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*sharedClient{}
+)
+
+// Shared returns the process-wide client for the default kubeconfig.
+func Shared() (*Client, error) {
+	return SharedFor(KubeconfigPath())
+}
+
+// SharedFor returns the process-wide client for the kubeconfig at path,
+// creating it once the file exists. A rewritten kubeconfig (e.g. after the
+// cluster was re-initialised) replaces the client and its caches.
+func SharedFor(path string) (*Client, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: kubeconfig not found at %s", ErrNotConfigured, path)
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	cur := shared[path]
+	if cur != nil && st.ModTime().Equal(cur.modTime) {
+		return cur.client, nil
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	c, err := New(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		cur.client.Stop()
+	}
+	shared[path] = &sharedClient{client: c, modTime: st.ModTime()}
+	return c, nil
+}
+
+// SharedSynced returns the default client once its caches are filled.
+func SharedSynced(ctx context.Context) (*Client, error) {
+	return SharedSyncedFor(ctx, KubeconfigPath())
+}
+
+// SharedSyncedFor returns the client for path once its caches are filled.
+func SharedSyncedFor(ctx context.Context, path string) (*Client, error) {
+	c, err := SharedFor(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.WaitForCache(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,246 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClientServesListsFromCache(t *testing.T) {
+	cs := fake.NewClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "etcd-hub1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}},
+	)
+	c := NewFromInterfaces(cs, nil, nil)
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForCache(ctx))
+
+	deployments, err := c.Deployments.List(labels.Everything())
+	require.NoError(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, "web", deployments[0].Name)
+
+	pods, err := c.Pods.Pods("kube-system").List(labels.Everything())
+	require.NoError(t, err)
+	assert.Len(t, pods, 1)
+
+	// Writes made through the clientset reach the cache via the watch.
+	_, err = cs.CoreV1().Pods("default").Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		pods, err := c.Pods.List(labels.Everything())
+		return err == nil && len(pods) == 2
+	}, 5*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, c.DeleteNode(ctx, "worker1"))
+	require.NoError(t, c.DeleteNode(ctx, "worker1"), "missing node is not an error")
+}
+
+func TestDecodeManifest(t *testing.T) {
+	objs, err := DecodeManifest(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: one
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: two
+    namespace: web
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: three
+`)
+	require.NoError(t, err)
+	require.Len(t, objs, 3)
+	assert.Equal(t, "ConfigMap", objs[0].GetKind())
+	assert.Equal(t, "web", objs[1].GetNamespace())
+	assert.Equal(t, "apps/v1", objs[2].GetAPIVersion())
+
+	assert.Equal(t, 1, objs[0].Document)
+	// Empty documents don't count.
+	assert.Equal(t, 2, objs[1].Document)
+	assert.Equal(t, 2, objs[2].Document)
+
+	_, err = DecodeManifest("kind: ConfigMap\nmetadata:\n  name: x\n")
+	assert.Error(t, err, "apiVersion is required")
+
+	_, err = DecodeManifest("apiVersion: v1\nkind: ConfigMap\n")
+	assert.Error(t, err, "name is required")
+
+	// Every invalid document is reported, keyed by its index.
+	_, err = DecodeManifest(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ok
+---
+kind: ConfigMap
+metadata:
+  name: no-version
+---
+apiVersion: v1
+kind: Secret
+`)
+	var manifestErr ManifestError
+	require.ErrorAs(t, err, &manifestErr)
+	assert.Equal(t, ManifestError{
+		{Document: 2, Message: "apiVersion and kind are required"},
+		{Document: 3, Message: "metadata.name is required"},
+	}, manifestErr)
+}
+
+func TestDiffFields(t *testing.T) {
+	live := map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]any{
+			"name":            "web",
+			"resourceVersion": "41",
+			"labels":          map[string]any{"app": "web", "app.kubernetes.io/part-of": "shop"},
+		},
+		"spec":   map[string]any{"replicas": int64(2), "paused": false},
+		"status": map[string]any{"readyReplicas": int64(2)},
+	}
+	desired := map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]any{
+			"name":            "web",
+			"resourceVersion": "42",
+			"labels":          map[string]any{"app": "web"},
+		},
+		"spec":   map[string]any{"replicas": int64(3), "template": map[string]any{"metadata": map[string]any{"name": "x"}}},
+		"status": map[string]any{"readyReplicas": int64(1)},
+	}
+
+	changes := DiffFields(pruneServerFields(live), pruneServerFields(desired))
+	assert.Equal(t, []FieldChange{
+		{Path: "metadata.labels[app.kubernetes.io/part-of]", Old: "shop"},
+		{Path: "spec.paused", Old: false},
+		{Path: "spec.replicas", Old: int64(2), New: int64(3)},
+		{Path: "spec.template.metadata.name", New: "x"},
+	}, changes)
+
+	assert.Empty(t, DiffFields(pruneServerFields(live), pruneServerFields(live)))
+	assert.Contains(t, live, "status", "pruning works on a copy")
+}
+
+func TestResourceFor(t *testing.T) {
+	gvr, err := resourceFor("Deployment")
+	require.NoError(t, err)
+	assert.Equal(t, "deployment.apps", qualifiedResource(gvr))
+
+	gvr, err = resourceFor("ingress")
+	require.NoError(t, err)
+	assert.Equal(t, "ingress.networking.k8s.io", qualifiedResource(gvr))
+
+	_, err = resourceFor("clusterrole")
+	assert.ErrorIs(t, err, ErrUnknownKind)
+}
+
+func TestDrainEvictsWorkloadPods(t *testing.T) {
+	onNode := func(name string, mutate func(*corev1.Pod)) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.PodSpec{NodeName: "worker1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if mutate != nil {
+			mutate(pod)
+		}
+		return pod
+	}
+	cs := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}},
+		onNode("web", nil),
+		onNode("agent", func(p *corev1.Pod) {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent"}}
+		}),
+		onNode("static", func(p *corev1.Pod) {
+			p.Annotations = map[string]string{mirrorPodAnnotation: "x"}
+		}),
+		onNode("done", func(p *corev1.Pod) { p.Status.Phase = corev1.PodSucceeded }),
+		onNode("elsewhere", func(p *corev1.Pod) { p.Spec.NodeName = "worker2" }),
+	)
+	c := NewFromInterfaces(cs, nil, nil)
+	defer c.Stop()
+	ctx := context.Background()
+
+	_, err := c.Drain(ctx, "worker1")
+	require.NoError(t, err)
+
+	node, err := cs.CoreV1().Nodes().Get(ctx, "worker1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+
+	var evicted []string
+	for _, action := range cs.Actions() {
+		if action.GetSubresource() == "eviction" {
+			evicted = append(evicted, action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+		}
+	}
+	assert.Equal(t, []string{"web"}, evicted)
+
+	require.NoError(t, c.SetUnschedulable(ctx, "worker1", false))
+	node, err = cs.CoreV1().Nodes().Get(ctx, "worker1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, node.Spec.Unschedulable)
+}
+
+func TestEtcdHealthy(t *testing.T) {
+	cp := func(name string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{controlPlaneLabel: ""}}}
+	}
+	etcd := func(node string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "etcd-" + node, Labels: map[string]string{"component": "etcd"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+
+	check := func(objs ...runtime.Object) (bool, string) {
+		c := NewFromInterfaces(fake.NewClientset(objs...), nil, nil)
+		defer c.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, c.WaitForCache(ctx))
+		return c.EtcdHealthy()
+	}
+
+	ok, reason := check(cp("hub1"), cp("hub2"), etcd("hub1", corev1.ConditionTrue), etcd("hub2", corev1.ConditionTrue))
+	assert.True(t, ok, reason)
+
+	ok, reason = check(cp("hub1"), cp("hub2"), etcd("hub1", corev1.ConditionTrue), etcd("hub2", corev1.ConditionFalse))
+	assert.False(t, ok)
+	assert.Equal(t, "etcd on hub2 is not ready", reason)
+
+	ok, reason = check(cp("hub1"), cp("hub2"), etcd("hub1", corev1.ConditionTrue))
+	assert.False(t, ok)
+	assert.Equal(t, "etcd on hub2 is not ready", reason)
+}
@@ -0,0 +1,255 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Diff actions reported by Diff.
+const (
+	DiffCreate    = "create"
+	DiffUpdate    = "update"
+	DiffUnchanged = "unchanged"
+	DiffError     = "error"
+)
+
+// FieldChange is one changed leaf between the live and the desired object.
+// Lists are compared as a whole. Old is absent for added fields and New is
+// absent for removed ones.
+type FieldChange struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// ObjectDiff is the dry-run outcome for one manifest object.
+type ObjectDiff struct {
+	Document  int           `json:"document"`
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name"`
+	Action    string        `json:"action"`
+	Changes   []FieldChange `json:"changes,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Diff server-side applies objs with dryRun=All and compares the result with
+// live state, so defaulting, admission and field ownership are all taken into
+// account. Nothing is persisted.
+func (c *Client) Diff(ctx context.Context, objs []ManifestObject) []ObjectDiff {
+	diffs := make([]ObjectDiff, 0, len(objs))
+	for _, obj := range objs {
+		d := ObjectDiff{Document: obj.Document, Kind: obj.GetKind(), Name: obj.GetName()}
+		changes, action, err := c.diffObject(ctx, obj.Unstructured)
+		d.Namespace = obj.GetNamespace()
+		if err != nil {
+			d.Action = DiffError
+			d.Error = err.Error()
+		} else {
+			d.Action = action
+			d.Changes = changes
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+func (c *Client) diffObject(ctx context.Context, obj *unstructured.Unstructured) ([]FieldChange, string, error) {
+	ri, err := c.resourceInterface(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, "", err
+	}
+	desired, err := c.applyObject(ctx, obj.DeepCopy(), true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !exists {
+		return DiffFields(nil, pruneServerFields(desired.Object)), DiffCreate, nil
+	}
+	changes := DiffFields(pruneServerFields(live.Object), pruneServerFields(desired.Object))
+	if len(changes) == 0 {
+		return nil, DiffUnchanged, nil
+	}
+	return changes, DiffUpdate, nil
+}
+
+// DiffFields lists the leaf differences between two object trees, sorted by
+// path.
+func DiffFields(live map[string]any, desired map[string]any) []FieldChange {
+	var changes []FieldChange
+	diffMaps("", live, desired, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffMaps(prefix string, live map[string]any, desired map[string]any, changes *[]FieldChange) {
+	for key, newVal := range desired {
+		path := joinPath(prefix, key)
+		oldVal, ok := live[key]
+		if !ok {
+			if m, isMap := newVal.(map[string]any); isMap {
+				diffMaps(path, nil, m, changes)
+				continue
+			}
+			*changes = append(*changes, FieldChange{Path: path, New: newVal})
+			continue
+		}
+		oldMap, oldIsMap := oldVal.(map[string]any)
+		newMap, newIsMap := newVal.(map[string]any)
+		if oldIsMap && newIsMap {
+			diffMaps(path, oldMap, newMap, changes)
+			continue
+		}
+		if !reflect.DeepEqual(oldVal, newVal) {
+			*changes = append(*changes, FieldChange{Path: path, Old: oldVal, New: newVal})
+		}
+	}
+	for key, oldVal := range live {
+		if _, ok := desired[key]; !ok {
+			*changes = append(*changes, FieldChange{Path: joinPath(prefix, key), Old: oldVal})
+		}
+	}
+}
+
+func joinPath(prefix string, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		key = "[" + key + "]"
+		if prefix == "" {
+			return key
+		}
+		return prefix + key
+	}
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// pruneServerFields returns a copy of obj without status and the metadata
+// the API server maintains, leaving the fields a manifest can set.
+func pruneServerFields(obj map[string]any) map[string]any {
+	out := deepCopyObject(obj)
+	delete(out, "status")
+	if md, ok := out["metadata"].(map[string]any); ok {
+		for _, f := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp", "selfLink"} {
+			delete(md, f)
+		}
+		if ann, ok := md["annotations"].(map[string]any); ok {
+			delete(ann, "kubectl.kubernetes.io/last-applied-configuration")
+			delete(ann, "deployment.kubernetes.io/revision")
+			if len(ann) == 0 {
+				delete(md, "annotations")
+			}
+		}
+	}
+	return out
+}
+
+func deepCopyObject(obj map[string]any) map[string]any {
+	if obj == nil {
+		return map[string]any{}
+	}
+	return (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+}
+
+// ObjectSnapshot records an object's live state before a manifest is applied,
+// so the apply can be rolled back. Object is nil when it didn't exist.
+type ObjectSnapshot struct {
+	APIVersion string         `json:"api_version"`
+	Kind       string         `json:"kind"`
+	Namespace  string         `json:"namespace,omitempty"`
+	Name       string         `json:"name"`
+	Existed    bool           `json:"existed"`
+	Object     map[string]any `json:"object,omitempty"`
+	// Sealed replaces Object for Secrets when the snapshot is stored, so
+	// their data isn't kept in the clear.
+	Sealed string `json:"sealed,omitempty"`
+}
+
+// Snapshot captures the live state of every object in objs. Namespaces are
+// defaulted on objs as a side effect, like ApplyObjects does.
+func (c *Client) Snapshot(ctx context.Context, objs []ManifestObject) ([]ObjectSnapshot, error) {
+	snaps := make([]ObjectSnapshot, 0, len(objs))
+	for _, obj := range objs {
+		ri, err := c.resourceInterface(obj.Unstructured)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		snap := ObjectSnapshot{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+		live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			return nil, fmt.Errorf("%s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		default:
+			snap.Existed = true
+			snap.Object = pruneServerFields(live.Object)
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+// Restore puts objects back into their snapshotted state, in reverse order:
+// objects that existed are re-applied, objects that didn't are deleted.
+func (c *Client) Restore(ctx context.Context, snaps []ObjectSnapshot) ([]AppliedObject, error) {
+	results := make([]AppliedObject, 0, len(snaps))
+	var failed []string
+	for i := len(snaps) - 1; i >= 0; i-- {
+		snap := snaps[i]
+		res := AppliedObject{Kind: snap.Kind, Namespace: snap.Namespace, Name: snap.Name}
+
+		var err error
+		if snap.Existed {
+			_, err = c.applyObject(ctx, &unstructured.Unstructured{Object: snap.Object}, false)
+		} else {
+			res.Deleted = true
+			err = c.deleteObject(ctx, snap)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			failed = append(failed, fmt.Sprintf("%s/%s: %v", snap.Kind, snap.Name, err))
+		}
+		results = append(results, res)
+	}
+	if len(failed) > 0 {
+		return results, errors.New(strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+func (c *Client) deleteObject(ctx context.Context, snap ObjectSnapshot) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(snap.APIVersion)
+	obj.SetKind(snap.Kind)
+	obj.SetNamespace(snap.Namespace)
+	obj.SetName(snap.Name)
+	ri, err := c.resourceInterface(obj)
+	if err != nil {
+		return err
+	}
+	err = ri.Delete(ctx, snap.Name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// ExecOptions describes an exec session. Stdin and Resize may be nil; with
+// TTY set, stderr is merged into Stdout by the kubelet.
+type ExecOptions struct {
+	Container string
+	Command   []string
+	TTY       bool
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Resize remotecommand.TerminalSizeQueue
+}
+
+// StreamExec runs a command in a pod and streams its I/O until it exits or
+// ctx is cancelled.
+func (c *Client) StreamExec(ctx context.Context, namespace string, pod string, opts ExecOptions) error {
+	if c.restConfig == nil {
+		return errors.New("exec is not available on this client")
+	}
+
+	req := c.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(c.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	stream := remotecommand.StreamOptions{
+		Stdin:             opts.Stdin,
+		Stdout:            opts.Stdout,
+		Tty:               opts.TTY,
+		TerminalSizeQueue: opts.Resize,
+	}
+	if !opts.TTY {
+		stream.Stderr = opts.Stderr
+	}
+	return executor.StreamWithContext(ctx, stream)
+}
+
+// Exec runs command in a pod container and returns its combined output.
+// An empty container selects the pod's only (or default) container.
+func (c *Client) Exec(ctx context.Context, namespace string, pod string, container string, command []string) (string, error) {
+	var out lockedBuffer
+	err := c.StreamExec(ctx, namespace, pod, ExecOptions{
+		Container: container,
+		Command:   command,
+		Stdout:    &out,
+		Stderr:    &out,
+	})
+	output := strings.TrimSpace(out.String())
+	if err != nil {
+		if output == "" {
+			output = err.Error()
+		}
+		return output, fmt.Errorf("exec failed: %s", output)
+	}
+	return output, nil
+}
+
+// lockedBuffer collects stdout and stderr together; the executor copies the
+// two streams from separate goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,28 @@
+package kube
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockedBufferConcurrentWrites(t *testing.T) {
+	var b lockedBuffer
+	var wg sync.WaitGroup
+	for _, s := range []string{"o", "e"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				_, _ = b.Write([]byte(s))
+			}
+		}()
+	}
+	wg.Wait()
+
+	out := b.String()
+	assert.Len(t, out, 2000)
+	assert.Equal(t, 1000, strings.Count(out, "o"))
+}
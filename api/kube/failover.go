@@ -0,0 +1,182 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// outOfServiceValue is the value kubectl's documentation uses for the
+// non-graceful node shutdown taint; only the key and effect matter.
+const outOfServiceValue = "nodeshutdown"
+
+// FenceNode taints a node out-of-service, which tells Kubernetes the node
+// is down for good: its pods may be deleted without the kubelet confirming
+// and their volumes detached.
+func (c *Client) FenceNode(ctx context.Context, name string) error {
+	node, err := c.Clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, t := range node.Spec.Taints {
+		if t.Key == corev1.TaintNodeOutOfService && t.Effect == corev1.TaintEffectNoExecute {
+			return nil
+		}
+	}
+	now := metav1.Now()
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+		Key:       corev1.TaintNodeOutOfService,
+		Value:     outOfServiceValue,
+		Effect:    corev1.TaintEffectNoExecute,
+		TimeAdded: &now,
+	})
+	_, err = c.Clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: FieldManager})
+	return err
+}
+
+// UnfenceNode removes the out-of-service taint once the node is back.
+func (c *Client) UnfenceNode(ctx context.Context, name string) error {
+	node, err := c.Clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	taints := node.Spec.Taints[:0]
+	for _, t := range node.Spec.Taints {
+		if t.Key != corev1.TaintNodeOutOfService {
+			taints = append(taints, t)
+		}
+	}
+	if len(taints) == len(node.Spec.Taints) {
+		return nil
+	}
+	node.Spec.Taints = taints
+	_, err = c.Clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: FieldManager})
+	return err
+}
+
+// WorkloadPodsOnNode returns the pods of a Deployment or StatefulSet that
+// are scheduled on node, from the cached views.
+func (c *Client) WorkloadPodsOnNode(kind, namespace, name, node string) ([]*corev1.Pod, error) {
+	var selector *metav1.LabelSelector
+	switch kind {
+	case "Deployment":
+		d, err := c.Deployments.Deployments(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case "StatefulSet":
+		s, err := c.StatefulSets.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		selector = s.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", kind)
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.Pods.Pods(namespace).List(sel)
+	if err != nil {
+		return nil, err
+	}
+	var out []*corev1.Pod
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node {
+			out = append(out, pod)
+		}
+	}
+	return out, nil
+}
+
+// WorkloadAvailable reports whether a Deployment or StatefulSet has all of
+// its desired replicas available at its current generation.
+func (c *Client) WorkloadAvailable(kind, namespace, name string) (bool, error) {
+	switch kind {
+	case "Deployment":
+		d, err := c.Deployments.Deployments(namespace).Get(name)
+		if err != nil {
+			return false, err
+		}
+		return d.Status.ObservedGeneration >= d.Generation && d.Status.AvailableReplicas >= replicas(d.Spec.Replicas), nil
+	case "StatefulSet":
+		s, err := c.StatefulSets.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return false, err
+		}
+		return s.Status.ObservedGeneration >= s.Generation && s.Status.AvailableReplicas >= replicas(s.Spec.Replicas), nil
+	default:
+		return false, fmt.Errorf("unsupported workload kind %q", kind)
+	}
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+// ForceDeletePod deletes a pod without waiting for its kubelet, which is
+// gone. A pod already deleted counts as success.
+func (c *Client) ForceDeletePod(ctx context.Context, pod *corev1.Pod) error {
+	zero := int64(0)
+	err := c.Clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &zero})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// DetachPodVolumes deletes the VolumeAttachments holding the pods'
+// persistent volumes on node, so the attach-detach controller can attach
+// them where the replacement pods land. It returns how many it removed.
+func (c *Client) DetachPodVolumes(ctx context.Context, node string, pods []*corev1.Pod) (int, error) {
+	volumes := map[string]bool{}
+	for _, pod := range pods {
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil {
+				continue
+			}
+			pvc, err := c.Clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, v.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			if pvc.Spec.VolumeName != "" {
+				volumes[pvc.Spec.VolumeName] = true
+			}
+		}
+	}
+	if len(volumes) == 0 {
+		return 0, nil
+	}
+
+	attachments, err := c.Clientset.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	detached := 0
+	for _, va := range attachments.Items {
+		pv := va.Spec.Source.PersistentVolumeName
+		if va.Spec.NodeName != node || pv == nil || !volumes[*pv] {
+			continue
+		}
+		err := c.Clientset.StorageV1().VolumeAttachments().Delete(ctx, va.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return detached, fmt.Errorf("detach %s: %w", *pv, err)
+		}
+		detached++
+	}
+	return detached, nil
+}
@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFenceAndUnfenceNode(t *testing.T) {
+	cs := fake.NewClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker1"},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule},
+		}},
+	})
+	c := NewFromInterfaces(cs, nil, nil)
+	ctx := context.Background()
+
+	require.NoError(t, c.FenceNode(ctx, "worker1"))
+	require.NoError(t, c.FenceNode(ctx, "worker1"), "fencing twice is a no-op")
+	node, err := cs.CoreV1().Nodes().Get(ctx, "worker1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, node.Spec.Taints, 2)
+	assert.Equal(t, corev1.TaintNodeOutOfService, node.Spec.Taints[1].Key)
+	assert.Equal(t, corev1.TaintEffectNoExecute, node.Spec.Taints[1].Effect)
+
+	require.NoError(t, c.UnfenceNode(ctx, "worker1"))
+	node, err = cs.CoreV1().Nodes().Get(ctx, "worker1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.Taint{{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}}, node.Spec.Taints)
+
+	require.NoError(t, c.UnfenceNode(ctx, "gone"), "missing node is not an error")
+}
+
+func TestWorkloadPodsOnNodeAndAvailability(t *testing.T) {
+	replicas := int32(2)
+	cs := fake.NewClientset(
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg", Generation: 3},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "pg"}},
+			},
+			Status: appsv1.StatefulSetStatus{ObservedGeneration: 3, AvailableReplicas: 1},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-0", Labels: map[string]string{"app": "pg"}}, Spec: corev1.PodSpec{NodeName: "worker1"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-1", Labels: map[string]string{"app": "pg"}}, Spec: corev1.PodSpec{NodeName: "worker2"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "other", Labels: map[string]string{"app": "other"}}, Spec: corev1.PodSpec{NodeName: "worker1"}},
+	)
+	c := NewFromInterfaces(cs, nil, nil)
+	defer c.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.WaitForCache(ctx))
+
+	pods, err := c.WorkloadPodsOnNode("StatefulSet", "db", "pg", "worker1")
+	require.NoError(t, err)
+	require.Len(t, pods, 1)
+	assert.Equal(t, "pg-0", pods[0].Name)
+
+	available, err := c.WorkloadAvailable("StatefulSet", "db", "pg")
+	require.NoError(t, err)
+	assert.False(t, available)
+
+	_, err = c.WorkloadAvailable("Deployment", "db", "pg")
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = c.WorkloadPodsOnNode("DaemonSet", "db", "pg", "worker1")
+	assert.Error(t, err)
+}
+
+func TestDetachPodVolumes(t *testing.T) {
+	pv := func(name string) *string { return &name }
+	cs := fake.NewClientset(
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "data-pg-0"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "va-a"},
+			Spec:       storagev1.VolumeAttachmentSpec{NodeName: "worker1", Source: storagev1.VolumeAttachmentSource{PersistentVolumeName: pv("pv-a")}},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "va-b"},
+			Spec:       storagev1.VolumeAttachmentSpec{NodeName: "worker1", Source: storagev1.VolumeAttachmentSource{PersistentVolumeName: pv("pv-b")}},
+		},
+	)
+	c := NewFromInterfaces(cs, nil, nil)
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "pg-0"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "data",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-pg-0"}},
+		}}},
+	}
+	detached, err := c.DetachPodVolumes(ctx, "worker1", []*corev1.Pod{pod})
+	require.NoError(t, err)
+	assert.Equal(t, 1, detached)
+
+	list, err := cs.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "va-b", list.Items[0].Name)
+}
@@ -0,0 +1,31 @@
+package kube
+
+import (
+	"context"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LogOptions selects which pod logs to stream.
+type LogOptions struct {
+	Container    string
+	Follow       bool
+	Previous     bool
+	Timestamps   bool
+	TailLines    *int64
+	SinceSeconds *int64
+}
+
+// StreamLogs opens a pod's log stream. The caller must close it; cancelling
+// ctx ends a followed stream.
+func (c *Client) StreamLogs(ctx context.Context, namespace string, pod string, opts LogOptions) (io.ReadCloser, error) {
+	return c.Clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container:    opts.Container,
+		Follow:       opts.Follow,
+		Previous:     opts.Previous,
+		Timestamps:   opts.Timestamps,
+		TailLines:    opts.TailLines,
+		SinceSeconds: opts.SinceSeconds,
+	}).Stream(ctx)
+}
@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	controlPlaneLabel   = "node-role.kubernetes.io/control-plane"
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+// NodeReady reports whether the node's Ready condition is True.
+func NodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// FindNode looks a node up by hostname, falling back to the short hostname
+// and the kubernetes.io/hostname label, since kubeadm registers whatever the
+// host reported at join time.
+func (c *Client) FindNode(hostname string) (*corev1.Node, error) {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if hostname == "" {
+		return nil, fmt.Errorf("node has no hostname")
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	nodes, err := c.Nodes.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.Name == hostname || node.Name == short || node.Labels[corev1.LabelHostname] == hostname {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("node %q is not registered in the cluster", hostname)
+}
+
+// SetUnschedulable cordons or uncordons a node.
+func (c *Client) SetUnschedulable(ctx context.Context, name string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := c.Clientset.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// Drain cordons a node and asks the eviction API to move its pods elsewhere,
+// so PodDisruptionBudgets are honoured. DaemonSet, mirror and finished pods
+// are left alone. It returns the number of pods still on the node; callers
+// poll until that reaches zero.
+func (c *Client) Drain(ctx context.Context, name string) (int, error) {
+	if err := c.SetUnschedulable(ctx, name, true); err != nil {
+		return 0, fmt.Errorf("cordon: %w", err)
+	}
+	pods, err := c.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != name || !needsEviction(pod) {
+			continue
+		}
+		remaining++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		err := c.Clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+		})
+		switch {
+		case err == nil, apierrors.IsTooManyRequests(err):
+			// Evicted, or blocked by a disruption budget for now.
+		case apierrors.IsNotFound(err):
+			remaining--
+		default:
+			return remaining, fmt.Errorf("evict %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return remaining, nil
+}
+
+func needsEviction(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// EtcdHealthy checks from the cached views that every control-plane node
+// runs a Ready etcd static pod. The reason is empty when healthy.
+func (c *Client) EtcdHealthy() (bool, string) {
+	controlPlanes, err := c.Nodes.List(labels.SelectorFromSet(labels.Set{controlPlaneLabel: ""}))
+	if err != nil {
+		return false, err.Error()
+	}
+	if len(controlPlanes) == 0 {
+		return false, "no control-plane nodes found"
+	}
+	pods, err := c.Pods.Pods("kube-system").List(labels.SelectorFromSet(labels.Set{"component": "etcd"}))
+	if err != nil {
+		return false, err.Error()
+	}
+	ready := map[string]bool{}
+	for _, pod := range pods {
+		ready[pod.Spec.NodeName] = podReady(pod)
+	}
+	for _, node := range controlPlanes {
+		if !ready[node.Name] {
+			return false, fmt.Sprintf("etcd on %s is not ready", node.Name)
+		}
+	}
+	return true, ""
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
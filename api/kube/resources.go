@@ -0,0 +1,287 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// ResourceKinds are the namespaced kinds the admin UI can view and delete,
+// keyed by lowercase kind.
+var ResourceKinds = map[string]schema.GroupVersionResource{
+	"deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"statefulset": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"daemonset":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"service":     {Version: "v1", Resource: "services"},
+	"configmap":   {Version: "v1", Resource: "configmaps"},
+	"secret":      {Version: "v1", Resource: "secrets"},
+	"ingress":     {Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+	"job":         {Group: "batch", Version: "v1", Resource: "jobs"},
+	"cronjob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+	"pod":         {Version: "v1", Resource: "pods"},
+}
+
+// ErrUnknownKind is returned for kinds outside ResourceKinds.
+var ErrUnknownKind = errors.New("unsupported resource kind")
+
+func resourceFor(kind string) (schema.GroupVersionResource, error) {
+	gvr, ok := ResourceKinds[strings.ToLower(strings.TrimSpace(kind))]
+	if !ok {
+		return schema.GroupVersionResource{}, ErrUnknownKind
+	}
+	return gvr, nil
+}
+
+// Get fetches a single namespaced object. managedFields are dropped, like
+// kubectl does by default.
+func (c *Client) Get(ctx context.Context, kind string, namespace string, name string) (*unstructured.Unstructured, error) {
+	gvr, err := resourceFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.Dynamic.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	obj.SetManagedFields(nil)
+	return obj, nil
+}
+
+// Delete removes a namespaced object and returns a kubectl-style summary.
+func (c *Client) Delete(ctx context.Context, kind string, namespace string, name string) (string, error) {
+	gvr, err := resourceFor(kind)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Dynamic.Resource(gvr).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %q deleted", qualifiedResource(gvr), name), nil
+}
+
+// DeleteNode removes a Node object; a missing node is not an error.
+func (c *Client) DeleteNode(ctx context.Context, name string) error {
+	err := c.Clientset.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// AppliedObject is the outcome of applying (or, during a rollback, deleting)
+// one object from a manifest. Document is the index of the YAML document the
+// object came from, as in ManifestObject.
+type AppliedObject struct {
+	Document  int    `json:"document,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// String renders the result the way kubectl reports a server-side apply.
+func (a AppliedObject) String() string {
+	ref := strings.ToLower(a.Kind) + "/" + a.Name
+	if a.Error != "" {
+		return ref + " failed: " + a.Error
+	}
+	if a.Deleted {
+		return ref + " deleted"
+	}
+	return ref + " serverside-applied"
+}
+
+// ManifestObject is an object decoded from a manifest, tagged with the
+// 1-based index of its YAML document among the non-empty ones. Objects from
+// a List share an index.
+type ManifestObject struct {
+	Document int
+	*unstructured.Unstructured
+}
+
+// DocumentError is a validation error for one document of a manifest.
+type DocumentError struct {
+	Document int    `json:"document"`
+	Message  string `json:"message"`
+}
+
+// ManifestError collects the validation errors of a manifest.
+type ManifestError []DocumentError
+
+func (e ManifestError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, d := range e {
+		msgs = append(msgs, fmt.Sprintf("document %d: %s", d.Document, d.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Apply server-side applies every object in a (multi-document) YAML or JSON
+// manifest. Objects are applied in order and a failure doesn't stop the
+// rest; the returned error summarises all failures.
+func (c *Client) Apply(ctx context.Context, manifest string) ([]AppliedObject, error) {
+	objs, err := DecodeManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return c.ApplyObjects(ctx, objs)
+}
+
+// ApplyObjects server-side applies already decoded objects; see Apply.
+func (c *Client) ApplyObjects(ctx context.Context, objs []ManifestObject) ([]AppliedObject, error) {
+	if len(objs) == 0 {
+		return nil, errors.New("manifest contains no objects")
+	}
+
+	results := make([]AppliedObject, 0, len(objs))
+	var failed []string
+	for _, obj := range objs {
+		_, err := c.applyObject(ctx, obj.Unstructured, false)
+		res := AppliedObject{Document: obj.Document, Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		if err != nil {
+			res.Error = err.Error()
+			failed = append(failed, fmt.Sprintf("%s/%s: %v", res.Kind, res.Name, err))
+		}
+		results = append(results, res)
+	}
+	if len(failed) > 0 {
+		return results, errors.New(strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// applyObject server-side applies obj and returns the object as stored (or,
+// with dryRun, as it would be stored).
+func (c *Client) applyObject(ctx context.Context, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	ri, err := c.resourceInterface(obj)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	opts := metav1.PatchOptions{FieldManager: FieldManager, Force: boolPtr(true)}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, opts)
+}
+
+// resourceInterface resolves the dynamic client for obj, defaulting the
+// namespace of namespaced objects.
+func (c *Client) resourceInterface(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	mapping, err := c.restMapping(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.Dynamic.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+	return c.Dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// restMapping resolves a kind to its resource. A kind the mapper doesn't
+// know yet (e.g. a CRD created earlier in the same manifest) triggers one
+// rediscovery.
+func (c *Client) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err == nil || !meta.IsNoMatchError(err) {
+		return mapping, err
+	}
+	if r, ok := c.Mapper.(meta.ResettableRESTMapper); ok {
+		r.Reset()
+		return c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return nil, err
+}
+
+// DecodeManifest splits a YAML or JSON manifest into objects. Empty documents
+// are skipped and List objects are flattened. Invalid documents are reported
+// together as a ManifestError; a syntax error stops decoding since the
+// document boundaries after it can't be trusted.
+func DecodeManifest(manifest string) ([]ManifestObject, error) {
+	dec := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader([]byte(manifest)), 4096)
+	var out []ManifestObject
+	var errs ManifestError
+	for i := 1; ; i++ {
+		var raw map[string]any
+		if err := dec.Decode(&raw); err != nil {
+			if !errors.Is(err, io.EOF) {
+				errs = append(errs, DocumentError{Document: i, Message: err.Error()})
+			}
+			break
+		}
+		if len(raw) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: raw}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				errs = append(errs, DocumentError{Document: i, Message: err.Error()})
+				continue
+			}
+			for j := range list.Items {
+				if msg := validateObject(&list.Items[j]); msg != "" {
+					errs = append(errs, DocumentError{Document: i, Message: fmt.Sprintf("item %d: %s", j, msg)})
+					continue
+				}
+				out = append(out, ManifestObject{Document: i, Unstructured: &list.Items[j]})
+			}
+			continue
+		}
+		if msg := validateObject(obj); msg != "" {
+			errs = append(errs, DocumentError{Document: i, Message: msg})
+			continue
+		}
+		out = append(out, ManifestObject{Document: i, Unstructured: obj})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out, nil
+}
+
+func validateObject(obj *unstructured.Unstructured) string {
+	if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+		return "apiVersion and kind are required"
+	}
+	if obj.GetName() == "" {
+		return "metadata.name is required"
+	}
+	return ""
+}
+
+// qualifiedResource renders a resource the way kubectl names it in output,
+// e.g. "deployment.apps".
+func qualifiedResource(gvr schema.GroupVersionResource) string {
+	singular := strings.TrimSuffix(gvr.Resource, "s")
+	if gvr.Resource == "ingresses" {
+		singular = "ingress"
+	}
+	if gvr.Group == "" {
+		return singular
+	}
+	return singular + "." + gvr.Group
+}
+
+func boolPtr(v bool) *bool { return &v }
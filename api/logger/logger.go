@@ -38,6 +38,12 @@ func Debug(msg string, args ...any) {
 }
 
 func Audit(c *fiber.Ctx, msg string, actorID *uint, action string, entity string, args ...any) {
+	AuditFrom(c.IP(), c.Get("User-Agent"), msg, actorID, action, entity, args...)
+}
+
+// AuditFrom records an audit entry outside a request handler, e.g. when a
+// long-lived WebSocket session ends.
+func AuditFrom(ip string, userAgent string, msg string, actorID *uint, action string, entity string, args ...any) {
 	Logger.Info("AUDIT: "+msg, args...)
 	db := database.DB
 	if db != nil {
@@ -54,8 +60,8 @@ func Audit(c *fiber.Ctx, msg string, actorID *uint, action string, entity string
 		auditLog := models.AuditLog{
 			Action:    action,
 			Entity:    entity,
-			IP:        c.IP(),
-			UserAgent: c.Get("User-Agent"),
+			IP:        ip,
+			UserAgent: userAgent,
 			ActorID:   actorID,
 			Details:   detailsJSON,
 		}
@@ -37,15 +37,41 @@ func main() {
 
 	controllers.AddDemoUser()
 	startWorkerOfflineMonitor()
+	startWorkerShortcutReconciler()
+	startKeyRotationReconciler()
+	startApplicationReconciler()
+	startKubernetesUpgradeReconciler()
+	startFailoverReconciler()
+	startCommandReconciler()
+	if port := config.Current().EndpointEchoPort; port > 0 {
+		if err := services.StartEndpointEcho(port); err != nil {
+			logger.Error("Failed to start endpoint echo", "error", err)
+		}
+	}
 	metrics.StartDatabaseMetrics(30 * time.Second)
 	if err := services.AssignHubNumbers(); err != nil {
 		logger.Error("Failed to assign hub numbers", "error", err)
 	}
+	if err := services.RebuildNodeLabelIndex(); err != nil {
+		logger.Error("Failed to rebuild node label index", "error", err)
+	}
+	if err := services.MigrateSSHAccessGroupSelectors(); err != nil {
+		logger.Error("Failed to migrate SSH access group selectors", "error", err)
+	}
+	if err := services.SealManifestRevisions(); err != nil {
+		logger.Error("Failed to seal manifest revisions", "error", err)
+	}
 
 	logger.Info("Database connection successful")
 
 	logger.Debug("Setting up Fiber app")
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		BodyLimit: config.Current().BodyLimitMB << 20,
+		// Larger bodies are streamed rather than refused so the upload
+		// routes can take them; routes.SetupRoutes enforces the limit.
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
 
 	logger.Debug("Setting up CORS middleware")
 	app.Use(cors.New(cors.Config{
@@ -187,3 +213,98 @@ func startWorkerOfflineMonitor() {
 		}
 	}()
 }
+
+func startWorkerShortcutReconciler() {
+	const checkInterval = time.Minute
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReconcileWorkerShortcuts(); err != nil {
+				logger.Error("Failed to reconcile worker shortcuts", "error", err)
+			}
+		}
+	}()
+}
+
+func startApplicationReconciler() {
+	const checkInterval = 30 * time.Second
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReconcileApplications(); err != nil {
+				logger.Error("Failed to reconcile Kubernetes applications", "error", err)
+			}
+		}
+	}()
+}
+
+func startKubernetesUpgradeReconciler() {
+	const checkInterval = 15 * time.Second
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReconcileKubernetesUpgrade(); err != nil {
+				logger.Error("Failed to reconcile Kubernetes upgrade", "error", err)
+			}
+		}
+	}()
+}
+
+func startFailoverReconciler() {
+	const checkInterval = 10 * time.Second
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReconcileFailover(); err != nil {
+				logger.Error("Failed to reconcile workload failover", "error", err)
+			}
+		}
+	}()
+}
+
+// startCommandReconciler reaps commands whose agent never reported back
+// and keeps command jobs moving, e.g. after an API restart.
+func startCommandReconciler() {
+	const checkInterval = 15 * time.Second
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReapNodeCommands(); err != nil {
+				logger.Error("Failed to reap node commands", "error", err)
+			}
+			if err := services.ReconcileCommandJobs(); err != nil {
+				logger.Error("Failed to reconcile command jobs", "error", err)
+			}
+		}
+	}()
+}
+
+func startKeyRotationReconciler() {
+	const checkInterval = 30 * time.Second
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := services.ReconcileKeyRotations(); err != nil {
+				logger.Error("Failed to reconcile WireGuard key rotations", "error", err)
+			}
+		}
+	}()
+}
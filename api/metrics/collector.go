@@ -3,17 +3,18 @@ package metrics
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 	"time"
 
 	"gluon-api/database"
+	"gluon-api/kube"
 	"gluon-api/logger"
 	"gluon-api/models"
+	"gluon-api/services"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var (
@@ -185,16 +186,16 @@ var (
 	k8sPodsTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gluon_k8s_pods_total",
-			Help: "Total Kubernetes pods grouped by namespace and phase.",
+			Help: "Total Kubernetes pods grouped by cluster, namespace and phase.",
 		},
-		[]string{"namespace", "phase"},
+		[]string{"cluster", "namespace", "phase"},
 	)
 	k8sWorkloadsTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gluon_k8s_workloads_total",
-			Help: "Total Kubernetes workloads grouped by namespace and kind.",
+			Help: "Total Kubernetes workloads grouped by cluster, namespace and kind.",
 		},
-		[]string{"namespace", "kind"},
+		[]string{"cluster", "namespace", "kind"},
 	)
 )
 
@@ -268,25 +269,6 @@ type nodeUsageRow struct {
 	Uptime      *uint64
 }
 
-type k8sList[T any] struct {
-	Items []T `json:"items"`
-}
-
-type k8sPod struct {
-	Metadata struct {
-		Namespace string `json:"namespace"`
-	} `json:"metadata"`
-	Status struct {
-		Phase string `json:"phase"`
-	} `json:"status"`
-}
-
-type k8sWorkload struct {
-	Metadata struct {
-		Namespace string `json:"namespace"`
-	} `json:"metadata"`
-}
-
 func StartDatabaseMetrics(interval time.Duration) {
 	if interval <= 0 {
 		interval = 30 * time.Second
@@ -543,70 +525,74 @@ func updateUsageMetrics() {
 }
 
 func updateKubernetesMetrics() {
+	var clusters []models.KubernetesCluster
+	if err := database.DB.Order("id asc").Find(&clusters).Error; err != nil {
+		logger.Error("metrics: failed to list k8s clusters", "error", err)
+		return
+	}
+
+	k8sPodsTotal.Reset()
+	k8sWorkloadsTotal.Reset()
+	for i := range clusters {
+		updateClusterMetrics(&clusters[i])
+	}
+}
+
+func updateClusterMetrics(cluster *models.KubernetesCluster) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	podBytes, err := kubectlJSON(ctx, []string{"get", "pods", "-A", "-o", "json"})
+	kc, err := services.KubeClientForSynced(ctx, cluster)
+	if err != nil {
+		if !errors.Is(err, kube.ErrNotConfigured) {
+			logger.Error("metrics: failed to collect k8s state", "error", err, "cluster", cluster.Name)
+		}
+		return
+	}
+
+	pods, err := kc.Pods.List(labels.Everything())
 	if err != nil {
-		logger.Error("metrics: failed to collect k8s pods", "error", err)
+		logger.Error("metrics: failed to collect k8s pods", "error", err, "cluster", cluster.Name)
 	} else {
-		var pods k8sList[k8sPod]
-		if err := json.Unmarshal(podBytes, &pods); err != nil {
-			logger.Error("metrics: failed to parse k8s pods", "error", err)
-		} else {
-			k8sPodsTotal.Reset()
-			for _, pod := range pods.Items {
-				ns := pod.Metadata.Namespace
-				phase := pod.Status.Phase
-				if phase == "" {
-					phase = "Unknown"
-				}
-				k8sPodsTotal.WithLabelValues(ns, phase).Inc()
+		for _, pod := range pods {
+			phase := string(pod.Status.Phase)
+			if phase == "" {
+				phase = "Unknown"
 			}
+			k8sPodsTotal.WithLabelValues(cluster.Name, pod.Namespace, phase).Inc()
 		}
 	}
 
-	workloadKinds := []string{"deployments", "daemonsets", "statefulsets", "jobs"}
-	k8sWorkloadsTotal.Reset()
-	for _, kind := range workloadKinds {
-		payload, err := kubectlJSON(ctx, []string{"get", kind, "-A", "-o", "json"})
-		if err != nil {
-			continue
+	namespaces := map[string][]string{}
+	if items, err := kc.Deployments.List(labels.Everything()); err == nil {
+		for _, item := range items {
+			namespaces["deployments"] = append(namespaces["deployments"], item.Namespace)
 		}
-		var items k8sList[k8sWorkload]
-		if err := json.Unmarshal(payload, &items); err != nil {
-			continue
-		}
-		for _, item := range items.Items {
-			ns := item.Metadata.Namespace
-			if ns == "" {
-				ns = "default"
-			}
-			k8sWorkloadsTotal.WithLabelValues(ns, kind).Inc()
+	}
+	if items, err := kc.DaemonSets.List(labels.Everything()); err == nil {
+		for _, item := range items {
+			namespaces["daemonsets"] = append(namespaces["daemonsets"], item.Namespace)
 		}
 	}
-}
-
-func kubectlJSON(ctx context.Context, args []string) ([]byte, error) {
-	kubeconfig := strings.TrimSpace(os.Getenv("GLUON_KUBECONFIG"))
-	if kubeconfig == "" {
-		kubeconfig = "/etc/kubernetes/admin.conf"
+	if items, err := kc.StatefulSets.List(labels.Everything()); err == nil {
+		for _, item := range items {
+			namespaces["statefulsets"] = append(namespaces["statefulsets"], item.Namespace)
+		}
 	}
-	if _, err := os.Stat(kubeconfig); err != nil {
-		return nil, fmt.Errorf("kubeconfig not found at %s", kubeconfig)
+	if items, err := kc.Jobs.List(labels.Everything()); err == nil {
+		for _, item := range items {
+			namespaces["jobs"] = append(namespaces["jobs"], item.Namespace)
+		}
 	}
-	cmdArgs := append([]string{"--kubeconfig", kubeconfig}, args...)
-	cmd := exec.CommandContext(ctx, "kubectl", cmdArgs...)
-	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfig)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		msg := strings.TrimSpace(string(out))
-		if msg == "" {
-			msg = err.Error()
+
+	for kind, list := range namespaces {
+		for _, ns := range list {
+			if ns == "" {
+				ns = "default"
+			}
+			k8sWorkloadsTotal.WithLabelValues(cluster.Name, ns, kind).Inc()
 		}
-		return nil, fmt.Errorf("kubectl failed: %s", msg)
 	}
-	return out, nil
 }
 
 // WireGuard peer row for metrics collection
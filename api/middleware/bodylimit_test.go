@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyLimit(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 16, StreamRequestBody: true})
+	app.Use(BodyLimit(16, func(c *fiber.Ctx) int {
+		if c.Path() == "/upload" {
+			return 64
+		}
+		return 0
+	}))
+	app.Post("/small", func(c *fiber.Ctx) error {
+		return c.SendString(string(c.Body()))
+	})
+	app.Post("/upload", func(c *fiber.Ctx) error {
+		var body io.Reader = c.Context().RequestBodyStream()
+		if body == nil {
+			body = bytes.NewReader(c.Body())
+		}
+		n, err := io.Copy(io.Discard, body)
+		require.NoError(t, err)
+		return c.JSON(n)
+	})
+
+	send := func(path, body string, chunked bool) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+
+	code, out := send("/small", "hello", false)
+	assert.Equal(t, fiber.StatusOK, code)
+	assert.Equal(t, "hello", out)
+
+	code, _ = send("/small", strings.Repeat("x", 17), false)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, code)
+	code, _ = send("/small", strings.Repeat("x", 17), true)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, code)
+	code, out = send("/small", "chunked", true)
+	assert.Equal(t, fiber.StatusOK, code)
+	assert.Equal(t, "chunked", out)
+
+	code, out = send("/upload", strings.Repeat("x", 64), false)
+	assert.Equal(t, fiber.StatusOK, code)
+	assert.Equal(t, "64", out)
+	code, _ = send("/upload", strings.Repeat("x", 65), false)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, code)
+}
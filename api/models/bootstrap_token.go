@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// BootstrapToken is a pre-shared enrollment token. An agent presenting a
+// valid one is approved without an admin, and its node takes the token's
+// role, provider and labels.
+type BootstrapToken struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Description string `json:"description" gorm:"not null;default:''"`
+	// Prefix is the start of the token, shown so admins can tell tokens
+	// apart; the token itself is only returned when it is created.
+	Prefix    string `json:"prefix" gorm:"not null;default:''"`
+	Hash      string `json:"-" gorm:"not null"`
+	HashIndex string `json:"-" gorm:"uniqueIndex;not null"`
+
+	// MaxUses is how many nodes may enroll with the token; 0 is unlimited.
+	MaxUses    int        `json:"max_uses" gorm:"not null;default:1"`
+	Uses       int        `json:"uses" gorm:"not null;default:0"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	// Template for enrolled nodes. An empty role or provider keeps what the
+	// agent asked for.
+	Role     NodeRole       `json:"role" gorm:"not null;default:''"`
+	Provider string         `json:"provider" gorm:"not null;default:''"`
+	Labels   datatypes.JSON `json:"labels,omitempty"`
+
+	// AllowedCIDR limits which source addresses may use the token.
+	AllowedCIDR string `json:"allowed_cidr" gorm:"not null;default:''"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
@@ -13,6 +13,12 @@ const (
 	NodeCommandStatusRunning   NodeCommandStatus = "running"
 	NodeCommandStatusSucceeded NodeCommandStatus = "succeeded"
 	NodeCommandStatusFailed    NodeCommandStatus = "failed"
+	// Queued commands belong to a job and wait for a free slot before they
+	// become pending; skipped ones were never sent because the job stopped.
+	NodeCommandStatusQueued  NodeCommandStatus = "queued"
+	NodeCommandStatusSkipped NodeCommandStatus = "skipped"
+	// Cancelled commands were withdrawn by an admin before they finished.
+	NodeCommandStatusCancelled NodeCommandStatus = "cancelled"
 )
 
 // Command kinds
@@ -24,6 +30,10 @@ const (
 	CmdKindAddLatency       = "add_latency"
 	CmdKindDisableOSPF      = "disable_ospf"
 	CmdKindRestoreNetwork   = "restore_network"
+
+	CmdKindRotateWireGuardKeys = "rotate_wireguard_keys"
+	CmdKindRestartService      = "restart_service"
+	CmdKindRunDiagnostic       = "run_diagnostic"
 )
 
 type NodeCommand struct {
@@ -34,6 +44,9 @@ type NodeCommand struct {
 	NodeID uint `json:"node_id" gorm:"not null;index"`
 	Node   Node `json:"node,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 
+	// JobID is set when the command is one node's share of a CommandJob.
+	JobID *uint `json:"job_id,omitempty" gorm:"index"`
+
 	Kind    string             `json:"kind" gorm:"not null;index"`
 	Payload datatypes.JSON     `json:"payload,omitempty"`
 	Status  NodeCommandStatus  `json:"status" gorm:"not null;default:'pending';index"`
@@ -41,6 +54,19 @@ type NodeCommand struct {
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 
+	// TimeoutSeconds bounds one attempt; 0 uses the kind's default. A
+	// running command past its Deadline is retried until MaxAttempts
+	// attempts were made, then failed. A pending command of a job has a
+	// Deadline too and fails if its node doesn't pick it up by then.
+	TimeoutSeconds int        `json:"timeout_seconds" gorm:"not null;default:0"`
+	Deadline       *time.Time `json:"deadline,omitempty"`
+	Attempts       int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts    int        `json:"max_attempts" gorm:"not null;default:1"`
+
+	// CancelRequestedAt is set when an admin cancels a running command; the
+	// agent learns about it from its next heartbeat.
+	CancelRequestedAt *time.Time `json:"cancel_requested_at,omitempty"`
+
 	Output string `json:"output,omitempty" gorm:"type:text"`
 	Error  string `json:"error,omitempty" gorm:"type:text"`
 }
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+type CommandJobStatus string
+
+const (
+	CommandJobStatusRunning   CommandJobStatus = "running"
+	CommandJobStatusSucceeded CommandJobStatus = "succeeded"
+	CommandJobStatusFailed    CommandJobStatus = "failed"
+	CommandJobStatusCancelled CommandJobStatus = "cancelled"
+)
+
+// CommandJob runs one command on every node a selector matched, at most
+// Concurrency nodes at a time. Once more than MaxFailures nodes failed, the
+// nodes not started yet are skipped.
+type CommandJob struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Kind     string         `json:"kind" gorm:"not null"`
+	Payload  datatypes.JSON `json:"payload,omitempty"`
+	Selector string         `json:"selector" gorm:"not null;default:''"`
+
+	Concurrency int `json:"concurrency" gorm:"not null;default:1"`
+	MaxFailures int `json:"max_failures" gorm:"not null;default:0"`
+
+	// TimeoutSeconds and MaxAttempts are copied onto every node's command.
+	TimeoutSeconds int `json:"timeout_seconds" gorm:"not null;default:0"`
+	MaxAttempts    int `json:"max_attempts" gorm:"not null;default:1"`
+
+	Status      CommandJobStatus `json:"status" gorm:"not null;default:'running';index"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// NodeConfigPlan is what an agent reported it would change to apply a
+// config version, sent before applying it (or instead, in dry-run mode).
+type NodeConfigPlan struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	NodeID uint `json:"node_id" gorm:"not null;index"`
+	Node   Node `json:"node,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Version    int    `json:"version" gorm:"not null"`
+	Hash       string `json:"hash"`
+	DryRun     bool   `json:"dry_run" gorm:"not null;default:false"`
+	Disruptive bool   `json:"disruptive" gorm:"not null;default:false"`
+
+	// Files holds the changed paths with their (redacted) unified diffs;
+	// Actions the interface and FRR steps the agent takes afterwards.
+	Files   datatypes.JSON `json:"files,omitempty"`
+	Actions datatypes.JSON `json:"actions"`
+}
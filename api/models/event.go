@@ -17,6 +17,14 @@ const (
 	EventKindOSPFNeighborUp   EventKind = "ospf_neighbor_up"
 	EventKindIPPoolExhausted  EventKind = "ip_pool_exhausted"
 	EventKindNodeDecommission EventKind = "node_decommissioned"
+	EventKindServiceVIPUp     EventKind = "service_vip_up"
+	EventKindServiceVIPDown   EventKind = "service_vip_down"
+	EventKindNodeEndpointChanged EventKind = "node_endpoint_changed"
+	EventKindWireGuardKeyRotated EventKind = "wireguard_key_rotated"
+	EventKindWireGuardKeyRolledBack EventKind = "wireguard_key_rolled_back"
+	EventKindNodeFenced             EventKind = "node_fenced"
+	EventKindNodeUnfenced           EventKind = "node_unfenced"
+	EventKindWorkloadFailedOver     EventKind = "workload_failed_over"
 )
 
 type Event struct {
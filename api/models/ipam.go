@@ -20,6 +20,9 @@ const (
 	IPPoolPurposeHub2Worker IPPoolPurpose = "hub2_worker"
 	IPPoolPurposeHub3Worker IPPoolPurpose = "hub3_worker"
 	IPPoolPurposeKubernetesServices IPPoolPurpose = "kubernetes_services"
+	IPPoolPurposeKubernetesPods IPPoolPurpose = "kubernetes_pods"
+	IPPoolPurposeServiceVIP IPPoolPurpose = "service_vip"
+	IPPoolPurposeWorkerShortcut IPPoolPurpose = "worker_shortcut"
 )
 
 type IPPool struct {
@@ -32,6 +35,10 @@ type IPPool struct {
 	CIDR    string        `json:"cidr" gorm:"not null;unique"`
 
 	HubNumber *int `json:"hub_number,omitempty"`
+
+	// KubernetesClusterID owns kubernetes pools: each cluster gets its own
+	// pod and service ranges.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
 }
 
 type IPAllocation struct {
@@ -83,6 +90,9 @@ type NodeConfig struct {
 	NetworkInterfaceConfig string `json:"network_interface_config" gorm:"type:text"`
 	FRRConfig              string `json:"frr_config" gorm:"type:text"`
 	SSHAuthorizedKeys      string `json:"ssh_authorized_keys" gorm:"type:text"`
+	ServiceVIPs            string `json:"service_vips" gorm:"type:text"`
+	SSHCA                  string `json:"ssh_ca" gorm:"type:text"`
+	SudoRules              string `json:"sudo_rules" gorm:"type:text"`
 
 	Hash string `json:"hash" gorm:"not null"`
 
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
 
 
 
@@ -9,6 +13,10 @@ type KubernetesCluster struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
+	// Name selects the cluster in the admin API. The lowest-ID cluster is
+	// the default one: nodes not assigned to any cluster belong to it.
+	Name string `json:"name" gorm:"uniqueIndex;not null;default:'default'"`
+
 	BootstrapNodeID *uint `json:"bootstrap_node_id,omitempty"`
 
 	ControlPlaneEndpoint string `json:"control_plane_endpoint" gorm:"not null;default:''"`
@@ -16,10 +24,318 @@ type KubernetesCluster struct {
 	ServiceCIDR          string `json:"service_cidr" gorm:"not null;default:'10.96.0.0/12'"`
 	KubernetesVersion    string `json:"kubernetes_version" gorm:"not null;default:'v1.29'"`
 
+	// Pod network plugin installed by kubeadm init; one of the CNI*
+	// constants. It can only be changed before the cluster is initialized.
+	CNI string `json:"cni" gorm:"not null;default:'flannel'"`
+
 	InitializedAt *time.Time `json:"initialized_at,omitempty"`
 
 	WorkerJoinCommand       string     `json:"worker_join_command" gorm:"not null;default:''"`
 	ControlPlaneJoinCommand string     `json:"control_plane_join_command" gorm:"not null;default:''"`
 	JoinCommandExpiresAt    *time.Time `json:"join_command_expires_at,omitempty"`
+
+	// Set by an admin asking for an etcd snapshot outside the schedule.
+	EtcdSnapshotRequestedAt *time.Time `json:"etcd_snapshot_requested_at,omitempty"`
+}
+
+// KubernetesTaint is a taint Gluon keeps on a node's Kubernetes object.
+type KubernetesTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+const (
+	CNIFlannel = "flannel"
+	// Calico runs in BGP mode without encapsulation and peers with the
+	// node's FRR, which carries pod routes over the OSPF fabric.
+	CNICalico = "calico"
+	CNICilium = "cilium"
+)
+
+// KubernetesManifestRevision records a manifest applied through the admin
+// API, along with the live state it replaced so it can be rolled back.
+// Re-applies and rollbacks are revisions of their own pointing at ParentID.
+type KubernetesManifestRevision struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Operation string `json:"operation" gorm:"not null;index"`
+	ParentID  *uint  `json:"parent_id,omitempty" gorm:"index"`
+
+	// KubernetesClusterID is the cluster the manifest was applied to; nil
+	// means the default cluster, like on nodes.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
+
+	// Set for revisions applied by an application sync (and re-applies or
+	// rollbacks of those). SourceRevision is the commit or bundle digest the
+	// manifest was rendered from.
+	ApplicationID  *uint  `json:"application_id,omitempty" gorm:"index"`
+	SourceRevision string `json:"source_revision,omitempty" gorm:"not null;default:''"`
+
+	// Manifest is shown with the values of its Secrets redacted; when it has
+	// any, SealedManifest holds the original, encrypted like the snapshot.
+	Manifest       string         `json:"manifest" gorm:"type:text;not null;default:''"`
+	SealedManifest string         `json:"-" gorm:"type:text;not null;default:''"`
+	Objects        datatypes.JSON `json:"objects"`
+	Snapshot       datatypes.JSON `json:"-"`
+
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+
+	RolledBackAt *time.Time `json:"rolled_back_at,omitempty"`
+
+	AppliedByID *uint `json:"applied_by_id,omitempty"`
+	AppliedBy   *User `json:"applied_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+const (
+	ManifestOperationApply    = "apply"
+	ManifestOperationReapply  = "reapply"
+	ManifestOperationRollback = "rollback"
+	ManifestOperationSync     = "sync"
+)
+
+const (
+	ApplicationSourceGit    = "git"
+	ApplicationSourceBundle = "bundle"
+)
+
+const (
+	ApplicationSyncUnknown   = "unknown"
+	ApplicationSyncSynced    = "synced"
+	ApplicationSyncOutOfSync = "out_of_sync"
+	ApplicationSyncError     = "error"
+)
+
+// KubernetesApplication is a set of manifests kept in sync with the cluster.
+// The source is either a git repository on the API host (RepoPath at
+// Revision) or a directory bundle uploaded through the API; Path selects a
+// subdirectory of it. A kustomization there is built with Kustomize,
+// otherwise every YAML/JSON file under it is applied.
+type KubernetesApplication struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name       string `json:"name" gorm:"uniqueIndex;not null"`
+	SourceType string `json:"source_type" gorm:"not null"`
+
+	// KubernetesClusterID is the cluster the application is synced to; nil
+	// means the default cluster.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
+
+	RepoPath string `json:"repo_path" gorm:"not null;default:''"`
+	Revision string `json:"revision" gorm:"not null;default:'HEAD'"`
+	Path     string `json:"path" gorm:"not null;default:''"`
+
+	BundleDigest     string     `json:"bundle_digest,omitempty" gorm:"not null;default:''"`
+	BundleUploadedAt *time.Time `json:"bundle_uploaded_at,omitempty"`
+
+	// With AutoSync off, drift is still detected and reported but only a
+	// manual sync applies the source. It has no schema default, which GORM
+	// would write instead of an explicit false.
+	AutoSync            bool `json:"auto_sync" gorm:"not null"`
+	SyncIntervalSeconds int  `json:"sync_interval_seconds" gorm:"not null;default:180"`
+
+	SyncStatus     string         `json:"sync_status" gorm:"not null;default:'unknown'"`
+	SyncError      string         `json:"sync_error,omitempty" gorm:"not null;default:''"`
+	SourceRevision string         `json:"source_revision,omitempty" gorm:"not null;default:''"`
+	SyncedRevision string         `json:"synced_revision,omitempty" gorm:"not null;default:''"`
+	Drift          datatypes.JSON `json:"drift,omitempty"`
+	LastCheckedAt  *time.Time     `json:"last_checked_at,omitempty"`
+	LastSyncedAt   *time.Time     `json:"last_synced_at,omitempty"`
+}
+
+const (
+	UpgradeStatusRunning   = "running"
+	UpgradeStatusSucceeded = "succeeded"
+	UpgradeStatusFailed    = "failed"
+	UpgradeStatusCancelled = "cancelled"
+)
+
+// Per-node upgrade states. The API drains the node, the agent upgrades the
+// packages and runs kubeadm, then the API waits for the kubelet to come back
+// Ready on the new version before uncordoning it.
+const (
+	UpgradeNodePending   = "pending"
+	UpgradeNodeDraining  = "draining"
+	UpgradeNodeUpgrading = "upgrading"
+	UpgradeNodeVerifying = "verifying"
+	UpgradeNodeDone      = "done"
+	UpgradeNodeFailed    = "failed"
+)
+
+// KubernetesUpgrade is a rolling upgrade of the cluster to ToVersion, one
+// node at a time: control-plane hubs first, then workers. ToVersion may be a
+// minor (v1.30) or an exact patch release; ResolvedVersion is the release
+// the first control plane actually installed, which every other node then
+// installs too.
+type KubernetesUpgrade struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// KubernetesClusterID is the cluster being upgraded; nil means the
+	// default cluster. Each cluster runs at most one upgrade at a time.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
+
+	FromVersion     string `json:"from_version" gorm:"not null"`
+	ToVersion       string `json:"to_version" gorm:"not null"`
+	ResolvedVersion string `json:"resolved_version,omitempty" gorm:"not null;default:''"`
+
+	Status  string `json:"status" gorm:"not null;index"`
+	Message string `json:"message,omitempty" gorm:"not null;default:''"`
+
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	StartedByID *uint `json:"started_by_id,omitempty"`
+	StartedBy   *User `json:"started_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+
+	Nodes []KubernetesUpgradeNode `json:"nodes" gorm:"foreignKey:UpgradeID;constraint:OnDelete:CASCADE;"`
+}
+
+// KubernetesUpgradeNode tracks one node through an upgrade. Apply is set on
+// the control plane that runs `kubeadm upgrade apply`; every other node runs
+// `kubeadm upgrade node`.
+type KubernetesUpgradeNode struct {
+	ID        uint `gorm:"primaryKey;autoIncrement" json:"id"`
+	UpgradeID uint `json:"upgrade_id" gorm:"not null;index"`
+	NodeID    uint `json:"node_id" gorm:"not null;index"`
+
+	// NodeName is the Kubernetes node name, resolved when the upgrade starts.
+	NodeName     string `json:"node_name" gorm:"not null"`
+	Position     int    `json:"position" gorm:"not null"`
+	ControlPlane bool   `json:"control_plane"`
+	Apply        bool   `json:"apply"`
+
+	State   string `json:"state" gorm:"not null"`
+	Message string `json:"message,omitempty" gorm:"not null;default:''"`
+	Version string `json:"version,omitempty" gorm:"not null;default:''"`
+
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// TargetVersion is the version nodes should install: the resolved release
+// once the first control plane has picked one, the requested one before.
+func (u KubernetesUpgrade) TargetVersion() string {
+	if u.ResolvedVersion != "" {
+		return u.ResolvedVersion
+	}
+	return u.ToVersion
+}
+
+// EtcdSnapshot is a snapshot of the cluster's etcd taken by a hub agent and
+// stored gzip-compressed under the API's snapshot directory. SHA256 and
+// SizeBytes describe the uncompressed snapshot, which is what a restore
+// verifies; StoredBytes is the size on disk.
+type EtcdSnapshot struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	NodeID   uint  `json:"node_id" gorm:"not null;index"`
+	Revision int64 `json:"revision"`
+
+	// KubernetesClusterID is the cluster of the hub that took the snapshot;
+	// nil means the default cluster.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
+
+	SizeBytes   int64  `json:"size_bytes"`
+	StoredBytes int64  `json:"stored_bytes"`
+	SHA256      string `json:"sha256" gorm:"not null"`
+	Path        string `json:"-" gorm:"not null"`
+}
+
+const (
+	EtcdRestorePending   = "pending"
+	EtcdRestoreRunning   = "running"
+	EtcdRestoreSucceeded = "succeeded"
+	EtcdRestoreFailed    = "failed"
+)
+
+// EtcdRestore asks a hub's agent to rebuild a single-member etcd from a
+// snapshot, for when quorum is lost for good. The other hubs have to be
+// reset and rejoined afterwards; Message says which.
+type EtcdRestore struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	SnapshotID uint `json:"snapshot_id" gorm:"not null;index"`
+	NodeID     uint `json:"node_id" gorm:"not null;index"`
+
+	Status  string `json:"status" gorm:"not null;index"`
+	Message string `json:"message,omitempty" gorm:"not null;default:''"`
+
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	RequestedByID *uint `json:"requested_by_id,omitempty"`
+	RequestedBy   *User `json:"requested_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+const (
+	FailoverKindDeployment  = "Deployment"
+	FailoverKindStatefulSet = "StatefulSet"
+)
+
+// KubernetesFailoverPolicy opts a Deployment or StatefulSet into fast
+// failover. Once one of its pods sits on a node Gluon has marked offline
+// and Kubernetes reports NotReady, the node is tainted out-of-service, the
+// pods are force-deleted and their volumes detached, instead of waiting
+// out the default eviction delay.
+type KubernetesFailoverPolicy struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// KubernetesClusterID is the cluster the workload runs in; nil means
+	// the default cluster.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"uniqueIndex:idx_failover_policy_cluster_target"`
+
+	Namespace string `json:"namespace" gorm:"not null;uniqueIndex:idx_failover_policy_cluster_target"`
+	Kind      string `json:"kind" gorm:"not null;uniqueIndex:idx_failover_policy_cluster_target"`
+	Name      string `json:"name" gorm:"not null;uniqueIndex:idx_failover_policy_cluster_target"`
+
+	Enabled bool `json:"enabled" gorm:"not null;default:true"`
+	// GraceSeconds is how long past the node's last heartbeat to wait
+	// before fencing, on top of the offline detection itself.
+	GraceSeconds int `json:"grace_seconds" gorm:"not null;default:0"`
+	// RecoveryTimeoutSeconds bounds how long the workload may take to be
+	// fully available elsewhere before the failover is recorded as failed.
+	RecoveryTimeoutSeconds int `json:"recovery_timeout_seconds" gorm:"not null;default:900"`
 }
 
+const (
+	FailoverStatusRecovering = "recovering"
+	FailoverStatusRecovered  = "recovered"
+	FailoverStatusFailed     = "failed"
+)
+
+// KubernetesFailover records one workload failing over off one node.
+// RecoverySeconds runs from the node's last heartbeat to the moment the
+// workload was fully available again.
+type KubernetesFailover struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	PolicyID uint   `json:"policy_id" gorm:"not null;index"`
+	NodeID   uint   `json:"node_id" gorm:"not null;index"`
+	NodeName string `json:"node_name" gorm:"not null"`
+
+	Namespace string `json:"namespace" gorm:"not null"`
+	Kind      string `json:"kind" gorm:"not null"`
+	Name      string `json:"name" gorm:"not null"`
+
+	Status  string `json:"status" gorm:"not null;index"`
+	Message string `json:"message,omitempty" gorm:"not null;default:''"`
+
+	PodsDeleted     int `json:"pods_deleted"`
+	VolumesDetached int `json:"volumes_detached"`
+
+	NodeLastSeenAt  *time.Time `json:"node_last_seen_at,omitempty"`
+	FencedAt        time.Time  `json:"fenced_at"`
+	RecoveredAt     *time.Time `json:"recovered_at,omitempty"`
+	RecoverySeconds *float64   `json:"recovery_seconds,omitempty"`
+}
@@ -30,6 +30,15 @@ type WireGuardInterface struct {
 	Address    string `json:"address" gorm:"not null;unique"`
 	ListenPort int    `json:"listen_port" gorm:"not null"`
 
+	// PreviousPublicKey is set while a key rotation is in flight: the agent
+	// keeps the old private key until handshakes under PublicKey are seen on
+	// every far end, and falls back to it if they never are.
+	PreviousPublicKey string     `json:"previous_public_key,omitempty"`
+	KeyRotatedAt      *time.Time `json:"key_rotated_at,omitempty"`
+	// KeyStagedAt is set once every far end has applied a config listing
+	// both keys; until then the agent keeps running PreviousPublicKey.
+	KeyStagedAt *time.Time `json:"key_staged_at,omitempty"`
+
 	Status InterfaceStatus `json:"status" gorm:"default:'down';not null"`
 
 }
@@ -56,3 +65,30 @@ type NodePeer struct {
 
 	Status PeerStatus `json:"status" gorm:"default:'active';not null"`
 }
+
+type NodePrefixKind string
+
+const (
+	NodePrefixKindConnected NodePrefixKind = "connected"
+	NodePrefixKindStatic    NodePrefixKind = "static"
+)
+
+// NodePrefix is an extra prefix a node originates into OSPF, e.g. a LAN
+// behind a worker or a service VIP. Connected prefixes must exist on a local
+// interface; static prefixes are installed by FRR via NextHop (or blackhole).
+type NodePrefix struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NodeID uint `json:"node_id" gorm:"not null;index;uniqueIndex:idx_node_prefix,priority:1"`
+	Node   Node `json:"node,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Prefix      string         `json:"prefix" gorm:"not null;uniqueIndex:idx_node_prefix,priority:2"`
+	Kind        NodePrefixKind `json:"kind" gorm:"not null;default:'connected'"`
+	NextHop     string         `json:"next_hop,omitempty" gorm:"default:''"`
+	Description string         `json:"description,omitempty" gorm:"default:''"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty" gorm:"index"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
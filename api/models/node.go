@@ -36,7 +36,22 @@ type Node struct {
 	Provider     string `json:"provider" gorm:"not null"`
 	OS           string `json:"os" gorm:"not null"`
 
+	// BehindNAT means peers cannot dial this node; it is detected by the agent
+	// unless NATOverride pins the admin's choice.
+	BehindNAT          bool       `json:"behind_nat" gorm:"not null;default:false"`
+	NATOverride        bool       `json:"nat_override" gorm:"not null;default:false"`
+	ObservedPublicIP   string     `json:"observed_public_ip" gorm:"not null;default:''"`
+	EndpointObservedAt *time.Time `json:"endpoint_observed_at,omitempty"`
+
 	Labels     datatypes.JSON `json:"labels,omitempty"`
+
+	// Region and the extra Kubernetes labels and taints (KubernetesTaint
+	// list) an admin set; combined with the node's own metadata they are
+	// reconciled onto its Kubernetes node.
+	Region    string         `json:"region" gorm:"not null;default:''"`
+	K8sLabels datatypes.JSON `json:"k8s_labels,omitempty"`
+	K8sTaints datatypes.JSON `json:"k8s_taints,omitempty"`
+
 	Status     NodeStatus     `json:"status" gorm:"default:'active';not null"`
 	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
 
@@ -57,10 +72,18 @@ type Node struct {
 	ReportedDesiredRole string `json:"reported_desired_role" gorm:"not null;default:''"`
 
 	
+	// KubernetesClusterID is the cluster the node joins; nil means the
+	// default cluster.
+	KubernetesClusterID *uint `json:"kubernetes_cluster_id,omitempty" gorm:"index"`
+
 	K8sState         string     `json:"k8s_state" gorm:"not null;default:'not_configured'"`
 	K8sJoinedAt      *time.Time `json:"k8s_joined_at,omitempty"`
 	K8sLastAttemptAt *time.Time `json:"k8s_last_attempt_at,omitempty"`
 	K8sLastError     string     `json:"k8s_last_error,omitempty" gorm:"not null;default:''"`
+	K8sVersion       string     `json:"k8s_version,omitempty" gorm:"not null;default:''"`
+	// K8sFencedAt is set while the failover engine holds the node's
+	// Kubernetes object tainted out-of-service.
+	K8sFencedAt *time.Time `json:"k8s_fenced_at,omitempty"`
 
 	EnrolledByID        *uint `json:"enrolled_by_id,omitempty"`
 	EnrolledBy          *User `json:"enrolled_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
@@ -91,4 +114,7 @@ type NodeEnrollmentRequest struct {
 	RejectedBy      *User      `json:"rejected_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 
 	ConvertedNodeID *uint `json:"converted_node_id,omitempty"`
+
+	// BootstrapTokenID is the token the request was auto-approved with.
+	BootstrapTokenID *uint `json:"bootstrap_token_id,omitempty" gorm:"index"`
 }
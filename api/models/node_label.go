@@ -0,0 +1,13 @@
+package models
+
+// NodeLabel mirrors one entry of Node.Labels so selectors can filter nodes
+// with an indexed lookup instead of decoding every node's JSON.
+type NodeLabel struct {
+	ID uint `gorm:"primaryKey;autoIncrement" json:"id"`
+
+	NodeID uint  `json:"node_id" gorm:"not null;uniqueIndex:idx_node_label_key,priority:1"`
+	Node   *Node `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Key   string `json:"key" gorm:"not null;uniqueIndex:idx_node_label_key,priority:2;index:idx_node_label_value,priority:1"`
+	Value string `json:"value" gorm:"not null;default:'';index:idx_node_label_value,priority:2"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Permissions gate operations that go beyond normal administration and must
+// be granted to a user explicitly.
+const (
+	PermissionPodExec = "pod_exec"
+)
+
+var KnownPermissions = []string{
+	PermissionPodExec,
+}
+
+type UserPermission struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID uint `json:"user_id" gorm:"not null;uniqueIndex:idx_user_permission"`
+	User   User `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Permission string `json:"permission" gorm:"not null;uniqueIndex:idx_user_permission"`
+
+	GrantedByID *uint `json:"granted_by_id,omitempty"`
+	GrantedBy   *User `json:"granted_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+type ServiceVIPCheckType string
+
+const (
+	ServiceVIPCheckTCP  ServiceVIPCheckType = "tcp"
+	ServiceVIPCheckHTTP ServiceVIPCheckType = "http"
+	ServiceVIPCheckExec ServiceVIPCheckType = "exec"
+)
+
+// ServiceVIP is an anycast address handed to a set of nodes. Each assigned
+// agent health-checks the service locally and only keeps the address on its
+// dummy interface (and therefore in OSPF) while the check passes.
+type ServiceVIP struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name    string `json:"name" gorm:"not null;unique"`
+	Address string `json:"address" gorm:"not null;unique"`
+
+	AllocationID *uint         `json:"allocation_id,omitempty" gorm:"index"`
+	Allocation   *IPAllocation `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+
+	CheckType            ServiceVIPCheckType `json:"check_type" gorm:"not null"`
+	CheckTarget          string              `json:"check_target" gorm:"not null"`
+	CheckIntervalSeconds int                 `json:"check_interval_seconds" gorm:"not null;default:5"`
+	CheckTimeoutSeconds  int                 `json:"check_timeout_seconds" gorm:"not null;default:2"`
+	Rise                 int                 `json:"rise" gorm:"not null;default:2"`
+	Fall                 int                 `json:"fall" gorm:"not null;default:2"`
+
+	Assignments []ServiceVIPAssignment `json:"assignments,omitempty"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty" gorm:"index"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+type ServiceVIPAssignment struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ServiceVIPID uint       `json:"service_vip_id" gorm:"not null;index;uniqueIndex:idx_vip_node,priority:1"`
+	ServiceVIP   ServiceVIP `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	NodeID uint `json:"node_id" gorm:"not null;index;uniqueIndex:idx_vip_node,priority:2"`
+	Node   Node `json:"node,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Healthy        bool       `json:"healthy" gorm:"not null;default:false"`
+	Announced      bool       `json:"announced" gorm:"not null;default:false"`
+	Message        string     `json:"message,omitempty" gorm:"not null;default:''"`
+	LastReportedAt *time.Time `json:"last_reported_at,omitempty"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// WorkerShortcut is a direct WireGuard link between two workers that bypasses
+// the hubs. NodeAID is always the lower node ID so a pair maps to one row.
+// Unpinned shortcuts are created from traffic samples and removed once the
+// pair has been idle for the configured timeout.
+type WorkerShortcut struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NodeAID uint `json:"node_a_id" gorm:"not null;uniqueIndex:idx_worker_shortcut_pair"`
+	NodeA   Node `json:"node_a,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:NodeAID"`
+	NodeBID uint `json:"node_b_id" gorm:"not null;uniqueIndex:idx_worker_shortcut_pair"`
+	NodeB   Node `json:"node_b,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:NodeBID"`
+
+	LinkAllocationID *uint           `json:"link_allocation_id,omitempty" gorm:"index"`
+	LinkAllocation   *LinkAllocation `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+
+	Pinned bool   `json:"pinned" gorm:"not null;default:false"`
+	Reason string `json:"reason"`
+
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty" gorm:"index"`
+	CreatedBy   *User `json:"created_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+// WorkerTrafficSample is the latest loopback-to-loopback rate reported by a
+// worker for one peer worker, overwritten on every heartbeat.
+type WorkerTrafficSample struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NodeID     uint `json:"node_id" gorm:"not null;uniqueIndex:idx_worker_traffic_pair"`
+	Node       Node `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	PeerNodeID uint `json:"peer_node_id" gorm:"not null;uniqueIndex:idx_worker_traffic_pair"`
+	PeerNode   Node `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:PeerNodeID"`
+
+	BytesPerSecond float64   `json:"bytes_per_second"`
+	SampledAt      time.Time `json:"sampled_at" gorm:"not null;index"`
+}
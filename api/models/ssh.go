@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
 
 type NodeSSHAuthorizedKey struct {
 	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
@@ -17,3 +21,61 @@ type NodeSSHAuthorizedKey struct {
 	CreatedByID *uint `json:"created_by_id,omitempty" gorm:"index"`
 	CreatedBy   *User `json:"created_by,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 }
+
+// SSHCertificate records a user certificate signed by the SSH user CA. The
+// serial is the record ID.
+type SSHCertificate struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID *uint `json:"user_id,omitempty" gorm:"index"`
+	User   *User `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+
+	KeyID       string         `json:"key_id" gorm:"not null"`
+	Login       string         `json:"login" gorm:"not null"`
+	Principals  datatypes.JSON `json:"principals"`
+	PublicKey   string         `json:"public_key" gorm:"type:text;not null"`
+	Fingerprint string         `json:"fingerprint" gorm:"not null;index"`
+	ValidAfter  time.Time      `json:"valid_after"`
+	ValidBefore time.Time      `json:"valid_before" gorm:"index"`
+
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	RevokedByID *uint      `json:"revoked_by_id,omitempty"`
+}
+
+// SSHAccessGroup grants its members SSH access, and optionally sudo, on
+// every node its selector matches. An empty selector matches any node.
+type SSHAccessGroup struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name        string `json:"name" gorm:"uniqueIndex;not null"`
+	Description string `json:"description" gorm:"not null;default:''"`
+
+	// Selector is a label selector (`env=prod,role!=hub,hub_number=1`)
+	// over node labels and fields.
+	Selector string `json:"selector" gorm:"not null;default:''"`
+
+	// SudoCommands are the commands members may run as root ("ALL" for
+	// any); none means no sudo rule.
+	SudoCommands   datatypes.JSON `json:"sudo_commands,omitempty"`
+	SudoNoPassword bool           `json:"sudo_no_password" gorm:"not null;default:false"`
+
+	Members []SSHAccessGroupMember `json:"members,omitempty" gorm:"foreignKey:GroupID"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty"`
+}
+
+type SSHAccessGroupMember struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	GroupID uint            `json:"group_id" gorm:"not null;index;uniqueIndex:idx_group_user_pub,priority:1"`
+	Group   *SSHAccessGroup `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Username  string `json:"username" gorm:"not null;uniqueIndex:idx_group_user_pub,priority:2"`
+	PublicKey string `json:"public_key" gorm:"type:text;not null;uniqueIndex:idx_group_user_pub,priority:3"`
+	Comment   string `json:"comment,omitempty" gorm:"default:''"`
+}
@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"strings"
+
 	"gluon-api/config"
 	"gluon-api/controllers"
 	"gluon-api/middleware"
@@ -8,9 +10,12 @@ import (
 	"github.com/gofiber/fiber/v2"
 
 	jwtware "github.com/gofiber/contrib/jwt"
+	"github.com/gofiber/contrib/websocket"
 )
 
 func SetupRoutes(app *fiber.App) {
+	app.Use(middleware.BodyLimit(config.Current().BodyLimitMB<<20, uploadBodyLimit))
+
 	app.Get("/metrics", controllers.Metrics)
 	app.Get("/api", controllers.Hello)
 	app.Get("/api/ca.crt", controllers.GetCACertificate) // Unauthenticated - for TLS bootstrap
@@ -31,33 +36,119 @@ func SetupRoutes(app *fiber.App) {
 
 	admin.Post("modifyRegistrationRequest", controllers.ModifyUserRegistration)
 	admin.Post("deleteUser", controllers.DeleteUser)
+	admin.Get("users/:id/permissions", controllers.ListUserPermissions)
+	admin.Put("users/:id/permissions/:permission", controllers.GrantUserPermission)
+	admin.Delete("users/:id/permissions/:permission", controllers.RevokeUserPermission)
 	admin.Get("userRegRequests", controllers.ListUserRegRequests)
 	admin.Post("generateAPIKey", controllers.GenerateAPIKey)
 	admin.Post("enrollments/:id/approve", controllers.AcceptAgentEnrollment)
 	admin.Post("enrollments/:id/reject", controllers.RejectAgentEnrollment)
 	admin.Get("enrollments", controllers.ListAgentEnrollmentRequests)
+	admin.Get("bootstrap-tokens", controllers.AdminListBootstrapTokens)
+	admin.Post("bootstrap-tokens", controllers.AdminCreateBootstrapToken)
+	admin.Delete("bootstrap-tokens/:id", controllers.AdminRevokeBootstrapToken)
 	admin.Get("nodes", controllers.ListNodes)
+	admin.Get("nodes/labels", controllers.AdminListNodeLabelValues)
 	admin.Get("nodes/:id", controllers.GetNode)
 	admin.Get("nodes/:id/logs", controllers.ListNodeLogs)
 	admin.Delete("nodes/:id", controllers.DeleteNode)
 	admin.Post("nodes/:id/decommission", controllers.DecommissionNode)
+	admin.Put("nodes/:id/nat", controllers.SetNodeNAT)
+	admin.Get("nodes/:id/kubernetes-metadata", controllers.GetNodeKubernetesMetadata)
+	admin.Put("nodes/:id/kubernetes-metadata", controllers.SetNodeKubernetesMetadata)
+	admin.Put("nodes/:id/kubernetes-cluster", controllers.SetNodeKubernetesCluster)
+	admin.Get("nodes/:id/labels", controllers.GetNodeLabels)
+	admin.Put("nodes/:id/labels", controllers.ReplaceNodeLabels)
+	admin.Patch("nodes/:id/labels", controllers.UpdateNodeLabels)
 	admin.Post("revokeApiKey", controllers.RevokeAPIKey)
 	admin.Get("network/wireguard/peers", controllers.ListWireGuardPeers)
+	admin.Get("network/wireguard/key-rotations", controllers.AdminListKeyRotations)
+	admin.Post("network/wireguard/rotate-keys", controllers.AdminRotateFleetKeys)
 	admin.Get("network/ospf/neighbors", controllers.ListOSPFNeighbors)
+	admin.Get("network/shortcuts", controllers.AdminListWorkerShortcuts)
+	admin.Post("network/shortcuts", controllers.AdminPinWorkerShortcut)
+	admin.Delete("network/shortcuts/:id", controllers.AdminDeleteWorkerShortcut)
 	admin.Get("nodes/:id/network/wireguard/peers", controllers.ListWireGuardPeersForNode)
+	admin.Post("nodes/:id/network/wireguard/rotate-keys", controllers.AdminRotateNodeKeys)
 	admin.Get("nodes/:id/network/ospf/neighbors", controllers.ListOSPFNeighborsForNode)
 	admin.Get("nodes/:id/ssh-keys", controllers.ListNodeSSHKeys)
 	admin.Post("nodes/:id/ssh-keys", controllers.CreateNodeSSHKey)
 	admin.Post("nodes/:id/ssh-keys/generate", controllers.GenerateNodeSSHKey)
 	admin.Delete("nodes/:id/ssh-keys/:keyId", controllers.DeleteNodeSSHKey)
+	admin.Get("ssh/ca", controllers.AdminGetSSHUserCA)
+	admin.Get("ssh/certificates", controllers.AdminListSSHCertificates)
+	admin.Post("ssh/certificates", controllers.AdminIssueSSHCertificate)
+	admin.Post("ssh/certificates/:id/revoke", controllers.AdminRevokeSSHCertificate)
+	admin.Get("ssh/groups", controllers.AdminListSSHAccessGroups)
+	admin.Post("ssh/groups", controllers.AdminCreateSSHAccessGroup)
+	admin.Get("ssh/groups/:id", controllers.AdminGetSSHAccessGroup)
+	admin.Put("ssh/groups/:id", controllers.AdminUpdateSSHAccessGroup)
+	admin.Delete("ssh/groups/:id", controllers.AdminDeleteSSHAccessGroup)
+	admin.Post("ssh/groups/:id/members", controllers.AdminAddSSHAccessGroupMember)
+	admin.Delete("ssh/groups/:id/members/:memberId", controllers.AdminRemoveSSHAccessGroupMember)
+	admin.Get("nodes/:id/prefixes", controllers.ListNodePrefixes)
+	admin.Post("nodes/:id/prefixes", controllers.CreateNodePrefix)
+	admin.Delete("nodes/:id/prefixes/:prefixId", controllers.DeleteNodePrefix)
+	admin.Post("nodes/services/restart", controllers.QueueRestartServiceBySelector)
+	admin.Get("command-jobs", controllers.AdminListCommandJobs)
+	admin.Post("command-jobs", controllers.AdminCreateCommandJob)
+	admin.Get("command-jobs/:id", controllers.AdminGetCommandJob)
+	admin.Post("command-jobs/:id/cancel", controllers.AdminCancelCommandJob)
+	admin.Get("commands", controllers.AdminListNodeCommands)
+	admin.Get("commands/:id", controllers.AdminGetNodeCommand)
+	admin.Post("commands/:id/cancel", controllers.AdminCancelNodeCommand)
 	admin.Post("nodes/:id/services/restart", controllers.QueueRestartService)
+	admin.Get("nodes/:id/diagnostics", controllers.AdminListNodeDiagnostics)
+	admin.Post("nodes/:id/diagnostics", controllers.AdminRunNodeDiagnostic)
+	admin.Get("nodes/:id/diagnostics/:commandId", controllers.AdminGetNodeDiagnostic)
+	admin.Get("nodes/:id/config-plans", controllers.AdminListNodeConfigPlans)
+	admin.Get("nodes/:id/config-plans/:planId", controllers.AdminGetNodeConfigPlan)
+	admin.Get("service-vips", controllers.AdminListServiceVIPs)
+	admin.Post("service-vips", controllers.AdminCreateServiceVIP)
+	admin.Get("service-vips/:id", controllers.AdminGetServiceVIP)
+	admin.Put("service-vips/:id/nodes", controllers.AdminSetServiceVIPNodes)
+	admin.Delete("service-vips/:id", controllers.AdminDeleteServiceVIP)
 	admin.Get("kubernetes/cluster", controllers.AdminGetKubernetesCluster)
+	admin.Put("kubernetes/cluster", controllers.AdminUpdateKubernetesCluster)
 	admin.Post("kubernetes/refresh-join", controllers.AdminRefreshKubernetesJoinCommands)
+	admin.Get("kubernetes/clusters", controllers.AdminListKubernetesClusters)
+	admin.Post("kubernetes/clusters", controllers.AdminCreateKubernetesCluster)
+	admin.Delete("kubernetes/clusters/:id", controllers.AdminDeleteKubernetesCluster)
+	admin.Get("kubernetes/upgrades", controllers.AdminListKubernetesUpgrades)
+	admin.Post("kubernetes/upgrades", controllers.AdminStartKubernetesUpgrade)
+	admin.Get("kubernetes/upgrades/:id", controllers.AdminGetKubernetesUpgrade)
+	admin.Post("kubernetes/upgrades/:id/retry", controllers.AdminRetryKubernetesUpgrade)
+	admin.Post("kubernetes/upgrades/:id/cancel", controllers.AdminCancelKubernetesUpgrade)
+	admin.Get("kubernetes/etcd/snapshots", controllers.AdminListEtcdSnapshots)
+	admin.Post("kubernetes/etcd/snapshots", controllers.AdminRequestEtcdSnapshot)
+	admin.Get("kubernetes/etcd/snapshots/:id/download", controllers.AdminDownloadEtcdSnapshot)
+	admin.Delete("kubernetes/etcd/snapshots/:id", controllers.AdminDeleteEtcdSnapshot)
+	admin.Post("kubernetes/etcd/snapshots/:id/restore", controllers.AdminRestoreEtcdSnapshot)
+	admin.Get("kubernetes/etcd/restores", controllers.AdminListEtcdRestores)
+	admin.Get("kubernetes/failover-policies", controllers.AdminListFailoverPolicies)
+	admin.Post("kubernetes/failover-policies", controllers.AdminCreateFailoverPolicy)
+	admin.Put("kubernetes/failover-policies/:id", controllers.AdminUpdateFailoverPolicy)
+	admin.Delete("kubernetes/failover-policies/:id", controllers.AdminDeleteFailoverPolicy)
+	admin.Get("kubernetes/failovers", controllers.AdminListFailovers)
 	admin.Get("kubernetes/workloads", controllers.AdminGetKubernetesWorkloads)
 	admin.Post("kubernetes/apply", controllers.AdminApplyKubernetesManifest)
+	admin.Get("kubernetes/manifests", controllers.AdminListManifestRevisions)
+	admin.Get("kubernetes/manifests/:id", controllers.AdminGetManifestRevision)
+	admin.Post("kubernetes/manifests/:id/reapply", controllers.AdminReapplyManifestRevision)
+	admin.Post("kubernetes/manifests/:id/rollback", controllers.AdminRollbackManifestRevision)
+	admin.Get("kubernetes/applications", controllers.AdminListApplications)
+	admin.Post("kubernetes/applications", controllers.AdminCreateApplication)
+	admin.Get("kubernetes/applications/:id", controllers.AdminGetApplication)
+	admin.Put("kubernetes/applications/:id", controllers.AdminUpdateApplication)
+	admin.Delete("kubernetes/applications/:id", controllers.AdminDeleteApplication)
+	admin.Post("kubernetes/applications/:id/bundle", controllers.AdminUploadApplicationBundle)
+	admin.Post("kubernetes/applications/:id/sync", controllers.AdminSyncApplication)
+	admin.Get("kubernetes/applications/:id/history", controllers.AdminListApplicationHistory)
 	admin.Get("kubernetes/resource", controllers.AdminGetKubernetesResourceYAML)
 	admin.Delete("kubernetes/resource", controllers.AdminDeleteKubernetesResource)
 	admin.Get("kubernetes/networking", controllers.AdminGetKubernetesNetworking)
+	admin.Get("kubernetes/pods/:ns/:name/logs", controllers.AdminStreamPodLogs, websocket.New(controllers.AdminPodLogsSocket))
+	admin.Get("kubernetes/pods/:ns/:name/exec", controllers.AdminPodExecUpgrade, websocket.New(controllers.AdminPodExec))
 	admin.Get("deployment/settings", controllers.AdminGetDeploymentSettings)
 	admin.Put("deployment/settings", controllers.AdminUpdateDeploymentSettings)
 
@@ -70,8 +161,13 @@ func SetupRoutes(app *fiber.App) {
 	agent.Post("network/keys", controllers.UploadPublicKeys)
 	agent.Get("config", controllers.GetConfig)
 	agent.Post("config/applied", controllers.ReportConfigApplied)
+	agent.Post("config/plan", controllers.ReportConfigPlan)
 	agent.Get("kubernetes/task", controllers.GetKubernetesTask)
 	agent.Post("kubernetes/report", controllers.ReportKubernetes)
+	agent.Get("kubernetes/node-metadata", controllers.GetKubernetesNodeMetadata)
+	agent.Get("etcd/snapshots/due", controllers.GetEtcdSnapshotDue)
+	agent.Post("etcd/snapshots", controllers.UploadEtcdSnapshot)
+	agent.Get("etcd/snapshots/:id", controllers.DownloadEtcdSnapshot)
 
 	admin.Get("ipam/pools", controllers.ListIPPools)
 	admin.Post("ipam/pools", controllers.AddIPPool)
@@ -83,3 +179,20 @@ func SetupRoutes(app *fiber.App) {
 	admin.Get("ipam/pools/:id/next", controllers.GetNextAvailableIP)
 	admin.Post("ipam/pools/:id/allocate-next", controllers.AllocateNextAvailableIP)
 }
+
+// uploadBodyLimit returns the body limit of the routes that stream large
+// uploads, or 0 for the global one.
+func uploadBodyLimit(c *fiber.Ctx) int {
+	if c.Method() != fiber.MethodPost {
+		return 0
+	}
+	path := c.Path()
+	switch {
+	case path == "/api/agent/etcd/snapshots":
+		return config.Current().EtcdSnapshotMaxMB << 20
+	case strings.HasPrefix(path, "/api/admin/kubernetes/applications/") && strings.HasSuffix(path, "/bundle"):
+		// Leave room for the multipart framing around the archive.
+		return (config.Current().ApplicationBundleMaxMB + 1) << 20
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+// Package selector parses and matches Kubernetes-style label selectors
+// such as `provider=hetzner,role!=hub,zone in (a,b),!gpu`. It only depends
+// on the standard library so tools outside the API (chaosctl) share it.
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Op string
+
+const (
+	Equals    Op = "="
+	NotEquals Op = "!="
+	In        Op = "in"
+	NotIn     Op = "notin"
+	Exists    Op = "exists"
+	NotExists Op = "!exists"
+)
+
+// Requirement is one comma-separated term of a selector.
+type Requirement struct {
+	Key    string
+	Op     Op
+	Values []string
+}
+
+// Selector is a parsed label selector. Every requirement must hold; the
+// empty selector matches everything.
+type Selector []Requirement
+
+var setTermRe = regexp.MustCompile(`^(\S+)\s+(in|notin)\s+\((.*)\)$`)
+
+// Parse parses s. It only checks the term syntax; callers validate keys
+// and values against their own rules.
+func Parse(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		req, err := parseTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// splitTerms splits on commas that are not inside a value set.
+func splitTerms(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseTerm(term string) (Requirement, error) {
+	var req Requirement
+	switch {
+	case setTermRe.MatchString(term):
+		m := setTermRe.FindStringSubmatch(term)
+		req.Key, req.Op = m[1], Op(m[2])
+		for _, v := range strings.Split(m[3], ",") {
+			req.Values = append(req.Values, strings.TrimSpace(v))
+		}
+	case strings.Contains(term, "!="):
+		k, v, _ := strings.Cut(term, "!=")
+		req.Key, req.Op, req.Values = strings.TrimSpace(k), NotEquals, []string{strings.TrimSpace(v)}
+	case strings.Contains(term, "=="):
+		k, v, _ := strings.Cut(term, "==")
+		req.Key, req.Op, req.Values = strings.TrimSpace(k), Equals, []string{strings.TrimSpace(v)}
+	case strings.Contains(term, "="):
+		k, v, _ := strings.Cut(term, "=")
+		req.Key, req.Op, req.Values = strings.TrimSpace(k), Equals, []string{strings.TrimSpace(v)}
+	case strings.HasPrefix(term, "!"):
+		req.Key, req.Op = strings.TrimSpace(term[1:]), NotExists
+	default:
+		req.Key, req.Op = term, Exists
+	}
+
+	if req.Key == "" || (req.Values == nil && strings.ContainsAny(req.Key, " ()")) {
+		return req, fmt.Errorf("invalid selector term %q", term)
+	}
+	return req, nil
+}
+
+// String renders the selector back in canonical form.
+func (s Selector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.Op {
+		case Equals, NotEquals:
+			terms = append(terms, r.Key+string(r.Op)+r.Values[0])
+		case In, NotIn:
+			terms = append(terms, fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ",")))
+		case Exists:
+			terms = append(terms, r.Key)
+		case NotExists:
+			terms = append(terms, "!"+r.Key)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// Matches reports whether every requirement holds for the labels lookup
+// returns.
+func (s Selector) Matches(lookup func(key string) (string, bool)) bool {
+	for _, r := range s {
+		value, ok := lookup(r.Key)
+		switch r.Op {
+		case Equals, In:
+			if !ok || !slices.Contains(r.Values, value) {
+				return false
+			}
+		case NotEquals, NotIn:
+			if ok && slices.Contains(r.Values, value) {
+				return false
+			}
+		case Exists:
+			if !ok {
+				return false
+			}
+		case NotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MatchesLabels reports whether labels satisfy every requirement.
+func (s Selector) MatchesLabels(labels map[string]string) bool {
+	return s.Matches(func(key string) (string, bool) {
+		v, ok := labels[key]
+		return v, ok
+	})
+}
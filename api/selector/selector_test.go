@@ -0,0 +1,49 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	sel, err := Parse(" provider=hetzner, role!=hub,zone in (fsn1, nbg1),!gpu,example.com/tier, env==prod")
+	require.NoError(t, err)
+	assert.Equal(t, Selector{
+		{Key: "provider", Op: Equals, Values: []string{"hetzner"}},
+		{Key: "role", Op: NotEquals, Values: []string{"hub"}},
+		{Key: "zone", Op: In, Values: []string{"fsn1", "nbg1"}},
+		{Key: "gpu", Op: NotExists},
+		{Key: "example.com/tier", Op: Exists},
+		{Key: "env", Op: Equals, Values: []string{"prod"}},
+	}, sel)
+	assert.Equal(t, "provider=hetzner,role!=hub,zone in (fsn1,nbg1),!gpu,example.com/tier,env=prod", sel.String())
+
+	sel, err = Parse("")
+	require.NoError(t, err)
+	assert.Empty(t, sel)
+
+	for _, bad := range []string{"zone in fsn1", "=x", "!", "a (b)"} {
+		_, err := Parse(bad)
+		assert.ErrorContains(t, err, "invalid selector term", bad)
+	}
+}
+
+func TestMatchesLabels(t *testing.T) {
+	match := func(s string, labels map[string]string) bool {
+		sel, err := Parse(s)
+		require.NoError(t, err)
+		return sel.MatchesLabels(labels)
+	}
+	labels := map[string]string{"zone": "fsn1", "gpu": ""}
+
+	assert.True(t, match("", labels))
+	assert.True(t, match("zone in (fsn1,nbg1)", labels))
+	assert.False(t, match("zone notin (fsn1)", labels))
+	assert.True(t, match("zone!=nbg1", labels))
+	assert.True(t, match("gpu", labels))
+	assert.False(t, match("!gpu", labels))
+	assert.False(t, match("rack=r1", labels))
+	assert.True(t, match("rack!=r1", labels))
+}
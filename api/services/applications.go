@@ -0,0 +1,470 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/datatypes"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Applications are reconciled like this: the source is rendered to a
+// manifest, which is dry-run applied to find drift. Drift is anything the
+// apply would change, whether the source moved on or someone edited the
+// cluster. Auto-sync applications then apply the manifest; the others are
+// only marked out of sync. Applies are recorded as manifest revisions, which
+// gives each application a history that can be rolled back.
+
+// ValidateApplicationPath checks that a source subdirectory stays inside the
+// source and returns it cleaned.
+func ValidateApplicationPath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", nil
+	}
+	cleaned := filepath.Clean(path)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path must be relative to the source root")
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// ApplicationBundleDir is where the uploaded bundle of an application lives.
+func ApplicationBundleDir(appID uint) string {
+	return filepath.Join(config.Current().ApplicationsDir, strconv.FormatUint(uint64(appID), 10), "bundle")
+}
+
+// ErrBundleTooLarge is returned for bundles over ApplicationBundleMaxMB,
+// packed or unpacked.
+var ErrBundleTooLarge = errors.New("bundle is too large")
+
+// StoreApplicationBundle unpacks a tar or tar.gz bundle as the source of an
+// application, replacing any previous bundle, and returns its digest.
+func StoreApplicationBundle(appID uint, data []byte) (string, error) {
+	maxSize := int64(config.Current().ApplicationBundleMaxMB) << 20
+	if int64(len(data)) > maxSize {
+		return "", ErrBundleTooLarge
+	}
+
+	dir := ApplicationBundleDir(appID)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dir), "upload-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("invalid gzip data: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	// A small gzip stream can unpack to far more than was uploaded.
+	limited := &io.LimitedReader{R: r, N: maxSize + 1}
+	err = extractTar(limited, staging)
+	if limited.N <= 0 {
+		return "", ErrBundleTooLarge
+	}
+	if err != nil {
+		return "", err
+	}
+
+	old := dir + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(dir, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		os.Rename(old, dir)
+		return "", err
+	}
+	os.RemoveAll(old)
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// extractTar unpacks regular files and directories into dest. Links and
+// other special entries are skipped; entries escaping dest are rejected.
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("archive entry %q escapes the bundle", hdr.Name)
+		}
+		target := filepath.Join(dest, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RenderApplication renders an application's source to a manifest and
+// returns it with the source revision (commit or bundle digest) it came
+// from.
+func RenderApplication(ctx context.Context, app models.KubernetesApplication) (string, string, error) {
+	switch app.SourceType {
+	case models.ApplicationSourceGit:
+		return renderGitSource(ctx, app.RepoPath, app.Revision, app.Path)
+	case models.ApplicationSourceBundle:
+		if app.BundleDigest == "" {
+			return "", "", errors.New("no bundle has been uploaded")
+		}
+		manifest, err := renderDir(ApplicationBundleDir(app.ID), app.Path)
+		return manifest, app.BundleDigest, err
+	default:
+		return "", "", fmt.Errorf("unknown source type %q", app.SourceType)
+	}
+}
+
+// renderGitSource exports the tree at revision with git archive, which works
+// on bare repositories and leaves any working tree alone.
+func renderGitSource(ctx context.Context, repo string, revision string, path string) (string, string, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", repo, "rev-parse", "--verify", "--end-of-options", revision+"^{commit}").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve %s in %s: %w", revision, repo, gitError(err))
+	}
+	commit := strings.TrimSpace(string(out))
+
+	tmp, err := os.MkdirTemp("", "gluon-app-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "archive", "--format=tar", commit)
+	archive, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", "", err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", "", err
+	}
+	extractErr := extractTar(archive, tmp)
+	io.Copy(io.Discard, archive)
+	if err := cmd.Wait(); err != nil {
+		return "", "", fmt.Errorf("git archive failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		return "", "", extractErr
+	}
+
+	manifest, err := renderDir(tmp, path)
+	return manifest, commit, err
+}
+
+func gitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// renderDir builds root/path with Kustomize if it has a kustomization, and
+// otherwise concatenates every YAML/JSON file under it in path order.
+func renderDir(root string, path string) (string, error) {
+	dir := filepath.Join(root, path)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("directory %q not found in source", "/"+path)
+	}
+
+	for _, name := range kustomizationFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), dir)
+			if err != nil {
+				return "", fmt.Errorf("kustomize build failed: %w", err)
+			}
+			out, err := resources.AsYaml()
+			return string(out), err
+		}
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	docs := make([]string, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, strings.TrimSpace(string(data)))
+	}
+	return strings.Join(docs, "\n---\n") + "\n", nil
+}
+
+// ApplicationSyncResult is the outcome of one SyncApplication run.
+type ApplicationSyncResult struct {
+	SourceRevision string               `json:"source_revision"`
+	Drift          []kube.ObjectDiff    `json:"drift"`
+	Applied        bool                 `json:"applied"`
+	RevisionID     uint                 `json:"revision_id,omitempty"`
+	Objects        []kube.AppliedObject `json:"objects,omitempty"`
+	Status         string               `json:"status"`
+	Error          string               `json:"error,omitempty"`
+}
+
+// SyncApplication renders app, compares it with the cluster and, if it
+// drifted and apply is set, applies it. The application's status columns
+// are updated either way.
+func SyncApplication(ctx context.Context, kc *kube.Client, app *models.KubernetesApplication, apply bool, actorID *uint) ApplicationSyncResult {
+	now := time.Now()
+	result := ApplicationSyncResult{}
+	updates := map[string]any{"last_checked_at": now}
+	defer func() {
+		updates["sync_status"] = result.Status
+		updates["sync_error"] = result.Error
+		if err := database.DB.Model(app).Updates(updates).Error; err != nil {
+			logger.Error("Failed to update application status", "application", app.Name, "error", err)
+		}
+	}()
+
+	manifest, sourceRevision, err := RenderApplication(ctx, *app)
+	if err != nil {
+		result.Status = models.ApplicationSyncError
+		result.Error = err.Error()
+		return result
+	}
+	result.SourceRevision = sourceRevision
+	updates["source_revision"] = sourceRevision
+
+	objs, err := kube.DecodeManifest(manifest)
+	if err == nil && len(objs) == 0 {
+		err = errors.New("source renders no objects")
+	}
+	if err != nil {
+		result.Status = models.ApplicationSyncError
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, d := range kc.Diff(ctx, objs) {
+		if d.Action != kube.DiffUnchanged {
+			result.Drift = append(result.Drift, d)
+		}
+	}
+	driftJSON, _ := json.Marshal(result.Drift)
+	updates["drift"] = datatypes.JSON(driftJSON)
+
+	if len(result.Drift) == 0 {
+		result.Status = models.ApplicationSyncSynced
+		updates["synced_revision"] = sourceRevision
+		return result
+	}
+	if !apply {
+		result.Status = models.ApplicationSyncOutOfSync
+		return result
+	}
+
+	// Drift that keeps coming back, e.g. a field another controller owns,
+	// would otherwise add a revision every interval. Re-applying the
+	// manifest of the latest revision only records a new one if that
+	// revision failed; its snapshot still undoes the manifest.
+	var rev *models.KubernetesManifestRevision
+	var results []kube.AppliedObject
+	var applyErr error
+	if last := latestApplicationRevision(app.ID); last != nil && last.Success && revisionAppliedManifest(last, manifest) {
+		rev = last
+		results, applyErr = kc.ApplyObjects(ctx, objs)
+	} else {
+		rev, results, applyErr = ApplyManifest(ctx, kc, manifest, objs, ManifestApply{
+			Operation:      models.ManifestOperationSync,
+			ClusterID:      app.KubernetesClusterID,
+			ApplicationID:  &app.ID,
+			SourceRevision: sourceRevision,
+			ActorID:        actorID,
+		})
+		if rev != nil {
+			pruneApplicationRevisions(app.ID, config.Current().ApplicationRevisionRetention)
+		}
+	}
+	result.Applied = rev != nil
+	result.Objects = results
+	if rev != nil {
+		result.RevisionID = rev.ID
+	}
+	if applyErr != nil {
+		result.Status = models.ApplicationSyncError
+		result.Error = applyErr.Error()
+		return result
+	}
+
+	result.Status = models.ApplicationSyncSynced
+	result.Drift = nil
+	updates["drift"] = datatypes.JSON("null")
+	updates["synced_revision"] = sourceRevision
+	updates["last_synced_at"] = now
+	return result
+}
+
+// ApplicationKubeClient returns the client for the cluster app syncs to.
+func ApplicationKubeClient(app *models.KubernetesApplication) (*kube.Client, error) {
+	cluster, err := KubernetesClusterByID(app.KubernetesClusterID)
+	if err != nil {
+		return nil, err
+	}
+	return KubeClientFor(cluster)
+}
+
+// revisionAppliedManifest reports whether rev applied exactly manifest.
+func revisionAppliedManifest(rev *models.KubernetesManifestRevision, manifest string) bool {
+	applied, err := ManifestRevisionManifest(rev)
+	return err == nil && applied == manifest
+}
+
+func latestApplicationRevision(appID uint) *models.KubernetesManifestRevision {
+	var rev models.KubernetesManifestRevision
+	if err := database.DB.Where("application_id = ?", appID).Order("id desc").First(&rev).Error; err != nil {
+		return nil
+	}
+	return &rev
+}
+
+// pruneApplicationRevisions deletes all but the keep newest revisions of an
+// application. A keep of zero or less keeps everything.
+func pruneApplicationRevisions(appID uint, keep int) {
+	if keep <= 0 {
+		return
+	}
+	var ids []uint
+	if err := database.DB.Model(&models.KubernetesManifestRevision{}).
+		Where("application_id = ?", appID).
+		Order("id desc").
+		Offset(keep).
+		Pluck("id", &ids).Error; err != nil {
+		logger.Error("Failed to list old application revisions", "application_id", appID, "error", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := database.DB.Delete(&models.KubernetesManifestRevision{}, ids).Error; err != nil {
+		logger.Error("Failed to prune application revisions", "application_id", appID, "error", err)
+	}
+}
+
+// ReconcileApplications checks every application that is due and applies
+// the auto-sync ones that drifted, each in its own cluster. Applications
+// whose cluster has no kubeconfig yet are skipped.
+func ReconcileApplications() error {
+	var apps []models.KubernetesApplication
+	if err := database.DB.Find(&apps).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range apps {
+		app := &apps[i]
+		interval := time.Duration(app.SyncIntervalSeconds) * time.Second
+		if app.LastCheckedAt != nil && now.Sub(*app.LastCheckedAt) < interval {
+			continue
+		}
+		kc, err := ApplicationKubeClient(app)
+		if errors.Is(err, kube.ErrNotConfigured) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to reach application cluster", "application", app.Name, "error", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		previous := app.SyncStatus
+		result := SyncApplication(ctx, kc, app, app.AutoSync, nil)
+		cancel()
+
+		switch {
+		case result.Applied:
+			logger.Info("Synced application", "application", app.Name, "revision", result.SourceRevision, "status", result.Status, "error", result.Error)
+		case result.Status != previous:
+			logger.Info("Application sync status changed", "application", app.Name, "from", previous, "to", result.Status, "error", result.Error)
+		}
+	}
+	return nil
+}
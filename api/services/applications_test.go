@@ -0,0 +1,209 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// gitRepo is a work tree pushing to a bare repository, which is what the
+// application points at.
+type gitRepo struct {
+	t    *testing.T
+	work string
+	bare string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	r := &gitRepo{t: t, work: filepath.Join(dir, "work"), bare: filepath.Join(dir, "repo.git")}
+	r.git(dir, "init", "--bare", "--initial-branch=main", r.bare)
+	r.git(dir, "init", "--initial-branch=main", r.work)
+	r.git(r.work, "remote", "add", "origin", r.bare)
+	return r
+}
+
+func (r *gitRepo) git(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.t, err, string(out))
+	return string(bytes.TrimSpace(out))
+}
+
+func (r *gitRepo) commit(files map[string]string) string {
+	for name, content := range files {
+		path := filepath.Join(r.work, name)
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(r.t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	r.git(r.work, "add", "-A")
+	r.git(r.work, "commit", "-q", "-m", "update")
+	r.git(r.work, "push", "-q", "origin", "main")
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func TestRenderGitApplication(t *testing.T) {
+	repo := newGitRepo(t)
+	first := repo.commit(map[string]string{
+		"plain/b-service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+		"plain/a-config.yml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n",
+		"plain/README.md":      "not a manifest",
+		"kustomized/kustomization.yaml": `namespace: shop
+namePrefix: prod-
+resources:
+- configmap.yaml
+`,
+		"kustomized/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  mode: blue\n",
+	})
+
+	ctx := context.Background()
+	app := models.KubernetesApplication{SourceType: models.ApplicationSourceGit, RepoPath: repo.bare, Revision: "main", Path: "plain"}
+
+	manifest, revision, err := RenderApplication(ctx, app)
+	require.NoError(t, err)
+	assert.Equal(t, first, revision)
+	assert.Equal(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n---\napiVersion: v1\nkind: Service\nmetadata:\n  name: web\n", manifest)
+
+	app.Path = "kustomized"
+	manifest, _, err = RenderApplication(ctx, app)
+	require.NoError(t, err)
+	assert.Contains(t, manifest, "name: prod-settings")
+	assert.Contains(t, manifest, "namespace: shop")
+
+	second := repo.commit(map[string]string{
+		"kustomized/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  mode: green\n",
+	})
+	manifest, revision, err = RenderApplication(ctx, app)
+	require.NoError(t, err)
+	assert.Equal(t, second, revision)
+	assert.Contains(t, manifest, "mode: green")
+
+	// Pinning a commit keeps rendering the old tree.
+	app.Revision = first
+	manifest, revision, err = RenderApplication(ctx, app)
+	require.NoError(t, err)
+	assert.Equal(t, first, revision)
+	assert.Contains(t, manifest, "mode: blue")
+
+	app.Revision = "main"
+	app.Path = "missing"
+	_, _, err = RenderApplication(ctx, app)
+	assert.ErrorContains(t, err, `directory "/missing" not found`)
+
+	app.Path = ""
+	app.Revision = "no-such-branch"
+	_, _, err = RenderApplication(ctx, app)
+	assert.Error(t, err)
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	archive := func(names ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 2, Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte("{}"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}))
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	dest := t.TempDir()
+	require.NoError(t, extractTar(archive("app/one.json", "./app/two.json"), dest))
+	assert.FileExists(t, filepath.Join(dest, "app", "one.json"))
+	assert.FileExists(t, filepath.Join(dest, "app", "two.json"))
+	assert.NoFileExists(t, filepath.Join(dest, "link"), "links are skipped")
+
+	assert.Error(t, extractTar(archive("../evil.json"), t.TempDir()))
+	assert.Error(t, extractTar(archive("/abs.json"), t.TempDir()))
+}
+
+func TestStoreApplicationBundleSizeLimit(t *testing.T) {
+	t.Setenv("GLUON_SECRET_KEY", "test")
+	t.Setenv("GLUON_APPLICATIONS_DIR", t.TempDir())
+	t.Setenv("GLUON_APPLICATION_BUNDLE_MAX_MB", "1")
+	require.NoError(t, config.Load())
+
+	bundle := func(size int) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "app.yaml", Mode: 0o644, Size: int64(size), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(bytes.Repeat([]byte("#"), size))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+		return buf.Bytes()
+	}
+
+	digest, err := StoreApplicationBundle(1, bundle(1024))
+	require.NoError(t, err)
+	assert.Contains(t, digest, "sha256:")
+
+	// Compresses to a few KiB but unpacks past the limit.
+	bomb := bundle(2 << 20)
+	require.Less(t, len(bomb), 1<<20)
+	_, err = StoreApplicationBundle(1, bomb)
+	assert.ErrorIs(t, err, ErrBundleTooLarge)
+	assert.FileExists(t, filepath.Join(ApplicationBundleDir(1), "app.yaml"), "the previous bundle is kept")
+}
+
+func TestPruneApplicationRevisions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.KubernetesManifestRevision{}))
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	app, other := uint(1), uint(2)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Create(&models.KubernetesManifestRevision{Operation: models.ManifestOperationSync, ApplicationID: &app}).Error)
+	}
+	require.NoError(t, db.Create(&models.KubernetesManifestRevision{Operation: models.ManifestOperationSync, ApplicationID: &other}).Error)
+	require.NoError(t, db.Create(&models.KubernetesManifestRevision{Operation: models.ManifestOperationApply}).Error)
+
+	pruneApplicationRevisions(app, 2)
+
+	var ids []uint
+	require.NoError(t, db.Model(&models.KubernetesManifestRevision{}).Order("id").Pluck("id", &ids).Error)
+	assert.Equal(t, []uint{4, 5, 6, 7}, ids)
+	assert.Equal(t, uint(5), latestApplicationRevision(app).ID)
+	assert.Nil(t, latestApplicationRevision(3))
+}
+
+func TestValidateApplicationPath(t *testing.T) {
+	for in, want := range map[string]string{"": "", ".": "", "apps/web/": "apps/web", "a/../b": "b"} {
+		got, err := ValidateApplicationPath(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"..", "../x", "/etc", "a/../../x"} {
+		_, err := ValidateApplicationPath(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"gluon-api/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Reasons a bootstrap token is refused. The agent only ever sees a generic
+// error; these end up in the audit log.
+var (
+	ErrBootstrapTokenInvalid   = errors.New("invalid bootstrap token")
+	ErrBootstrapTokenRevoked   = errors.New("bootstrap token revoked")
+	ErrBootstrapTokenExpired   = errors.New("bootstrap token expired")
+	ErrBootstrapTokenExhausted = errors.New("bootstrap token has no uses left")
+	ErrBootstrapTokenSource    = errors.New("source address not allowed for bootstrap token")
+)
+
+// ValidateBootstrapToken normalises an admin's token template before it is
+// saved.
+func ValidateBootstrapToken(t *models.BootstrapToken, labels map[string]string) error {
+	t.Description = strings.TrimSpace(t.Description)
+	if t.MaxUses < 0 {
+		return fmt.Errorf("max_uses must be 0 (unlimited) or more")
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	switch t.Role {
+	case "", models.NodeRoleHub, models.NodeRoleWorker:
+	default:
+		return fmt.Errorf("role must be hub, worker or empty")
+	}
+	t.Provider = strings.TrimSpace(t.Provider)
+	t.AllowedCIDR = strings.TrimSpace(t.AllowedCIDR)
+	if t.AllowedCIDR != "" {
+		p, err := netip.ParsePrefix(t.AllowedCIDR)
+		if err != nil {
+			return fmt.Errorf("allowed_cidr: %w", err)
+		}
+		t.AllowedCIDR = p.Masked().String()
+	}
+	if err := ValidateNodeLabels(labels); err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		data, _ := json.Marshal(labels)
+		t.Labels = data
+	}
+	return nil
+}
+
+// RedeemBootstrapToken checks plain against the stored tokens and, if it
+// may be used from sourceIP, counts one use in tx. The caller enrolls the
+// node in the same transaction, so a failed enrollment gives the use back.
+func RedeemBootstrapToken(tx *gorm.DB, plain string, sourceIP string) (*models.BootstrapToken, error) {
+	if !strings.HasPrefix(plain, "gbt_") || len(plain) != 68 {
+		return nil, ErrBootstrapTokenInvalid
+	}
+	sha := sha256.Sum256([]byte(plain))
+	var token models.BootstrapToken
+	if err := tx.Where("hash_index = ?", hex.EncodeToString(sha[:8])).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBootstrapTokenInvalid
+		}
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(token.Hash), []byte(plain)); err != nil {
+		return nil, ErrBootstrapTokenInvalid
+	}
+	now := time.Now()
+	if err := bootstrapTokenUsable(&token, sourceIP, now); err != nil {
+		return &token, err
+	}
+
+	// Count the use only if another enrollment did not take the last one.
+	res := tx.Model(&models.BootstrapToken{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", token.ID).
+		Updates(map[string]any{"uses": gorm.Expr("uses + 1"), "last_used_at": now})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &token, ErrBootstrapTokenExhausted
+	}
+	token.Uses++
+	token.LastUsedAt = &now
+	return &token, nil
+}
+
+func bootstrapTokenUsable(t *models.BootstrapToken, sourceIP string, now time.Time) error {
+	if t.RevokedAt != nil {
+		return ErrBootstrapTokenRevoked
+	}
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return ErrBootstrapTokenExpired
+	}
+	if t.MaxUses > 0 && t.Uses >= t.MaxUses {
+		return ErrBootstrapTokenExhausted
+	}
+	if t.AllowedCIDR != "" {
+		allowed, err := netip.ParsePrefix(t.AllowedCIDR)
+		if err != nil {
+			return ErrBootstrapTokenSource
+		}
+		ip, err := netip.ParseAddr(sourceIP)
+		if err != nil || !allowed.Contains(ip.Unmap()) {
+			return ErrBootstrapTokenSource
+		}
+	}
+	return nil
+}
+
+// BootstrapTokenLabels returns the labels a token gives enrolled nodes.
+// Tokens made before node field keys were reserved may still carry them;
+// those are dropped so the rest of the labels still apply.
+func BootstrapTokenLabels(t *models.BootstrapToken) map[string]string {
+	labels := map[string]string{}
+	if len(t.Labels) > 0 {
+		_ = json.Unmarshal(t.Labels, &labels)
+	}
+	for k := range labels {
+		if _, reserved := nodeFieldColumns[k]; reserved {
+			delete(labels, k)
+		}
+	}
+	return labels
+}
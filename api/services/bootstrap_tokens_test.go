@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gluon-api/models"
+	"gluon-api/utils"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestValidateBootstrapToken(t *testing.T) {
+	tok := models.BootstrapToken{MaxUses: 20, Role: models.NodeRoleWorker, AllowedCIDR: " 203.0.113.7/24 "}
+	require.NoError(t, ValidateBootstrapToken(&tok, map[string]string{"rack": "r1"}))
+	assert.Equal(t, "203.0.113.0/24", tok.AllowedCIDR)
+	assert.Equal(t, map[string]string{"rack": "r1"}, BootstrapTokenLabels(&tok))
+
+	past := time.Now().Add(-time.Minute)
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{MaxUses: -1}, nil), "max_uses")
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{ExpiresAt: &past}, nil), "expires_at")
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{Role: "admin"}, nil), "role")
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{AllowedCIDR: "nope"}, nil), "allowed_cidr")
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{}, map[string]string{"bad key!": "x"}), "label key")
+	assert.ErrorContains(t, ValidateBootstrapToken(&models.BootstrapToken{}, map[string]string{"role": "hub"}), "reserved")
+
+	old := models.BootstrapToken{Labels: []byte(`{"provider":"aws","rack":"r2"}`)}
+	assert.Equal(t, map[string]string{"rack": "r2"}, BootstrapTokenLabels(&old), "reserved keys on older tokens are dropped")
+}
+
+func TestBootstrapTokenUsable(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	cases := []struct {
+		name  string
+		token models.BootstrapToken
+		ip    string
+		err   error
+	}{
+		{"unlimited", models.BootstrapToken{MaxUses: 0, Uses: 50}, "198.51.100.1", nil},
+		{"uses left", models.BootstrapToken{MaxUses: 2, Uses: 1, ExpiresAt: &later}, "198.51.100.1", nil},
+		{"used up", models.BootstrapToken{MaxUses: 1, Uses: 1}, "198.51.100.1", ErrBootstrapTokenExhausted},
+		{"expired", models.BootstrapToken{ExpiresAt: &earlier}, "198.51.100.1", ErrBootstrapTokenExpired},
+		{"revoked", models.BootstrapToken{RevokedAt: &earlier}, "198.51.100.1", ErrBootstrapTokenRevoked},
+		{"inside cidr", models.BootstrapToken{AllowedCIDR: "10.0.0.0/8"}, "10.1.2.3", nil},
+		{"mapped ipv4", models.BootstrapToken{AllowedCIDR: "10.0.0.0/8"}, "::ffff:10.1.2.3", nil},
+		{"outside cidr", models.BootstrapToken{AllowedCIDR: "10.0.0.0/8"}, "192.0.2.1", ErrBootstrapTokenSource},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, bootstrapTokenUsable(&tc.token, tc.ip, now), tc.err)
+		})
+	}
+}
+
+func TestRedeemBootstrapTokenInTransaction(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.BootstrapToken{}))
+
+	plain, err := utils.GenerateBootstrapToken()
+	require.NoError(t, err)
+	tok := models.BootstrapToken{MaxUses: 1, Prefix: plain[:12]}
+	tok.Hash, tok.HashIndex, err = utils.HashBootstrapToken(plain)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&tok).Error)
+
+	// An enrollment that fails after redeeming gives the use back.
+	failed := errors.New("enrollment failed")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		_, err := RedeemBootstrapToken(tx, plain, "198.51.100.1")
+		require.NoError(t, err)
+		return failed
+	})
+	require.ErrorIs(t, err, failed)
+	require.NoError(t, db.First(&tok, tok.ID).Error)
+	assert.Equal(t, 0, tok.Uses)
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		_, err := RedeemBootstrapToken(tx, plain, "198.51.100.1")
+		return err
+	}))
+	_, err = RedeemBootstrapToken(db, plain, "198.51.100.1")
+	assert.ErrorIs(t, err, ErrBootstrapTokenExhausted)
+	_, err = RedeemBootstrapToken(db, "gbt_"+plain[4:10], "198.51.100.1")
+	assert.ErrorIs(t, err, ErrBootstrapTokenInvalid)
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/models"
+)
+
+// NormalizeCNI validates a CNI name, treating an empty one as the
+// configured default.
+func NormalizeCNI(cni string) (string, error) {
+	cni = strings.ToLower(strings.TrimSpace(cni))
+	if cni == "" {
+		cni = strings.ToLower(strings.TrimSpace(config.Current().KubernetesCNI))
+	}
+	switch cni {
+	case models.CNIFlannel, models.CNICalico, models.CNICilium:
+		return cni, nil
+	case "":
+		return models.CNIFlannel, nil
+	default:
+		return "", fmt.Errorf("unsupported cni %q (expected %s, %s or %s)", cni, models.CNIFlannel, models.CNICalico, models.CNICilium)
+	}
+}
+
+// CalicoPodCIDRs returns the pod CIDRs of the clusters running Calico,
+// whose pod routes are carried natively over the fabric.
+func CalicoPodCIDRs() []string {
+	var clusters []models.KubernetesCluster
+	if err := database.DB.Where("cni = ?", models.CNICalico).Order("id asc").Find(&clusters).Error; err != nil {
+		return nil
+	}
+	var cidrs []string
+	for _, cluster := range clusters {
+		if cidr := strings.TrimSpace(cluster.PodCIDR); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
@@ -0,0 +1,445 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCommandJobNotFound = errors.New("command job not found")
+	ErrCommandJobNoNodes  = errors.New("selector matches no nodes")
+)
+
+const maxCommandJobConcurrency = 100
+
+var systemdUnitNameRe = regexp.MustCompile(`^[A-Za-z0-9@._:-]+$`)
+
+// SystemdUnitName validates a unit name, defaulting to a .service unit.
+func SystemdUnitName(raw string) (string, bool) {
+	name := strings.TrimSpace(raw)
+	if name == "" || !systemdUnitNameRe.MatchString(name) {
+		return "", false
+	}
+	if !strings.Contains(name, ".") {
+		name = name + ".service"
+	}
+	return name, true
+}
+
+// commandJobKinds are the command kinds a job may fan out. Each checks the
+// payload and returns it in the form the agent expects.
+var commandJobKinds = map[string]func(json.RawMessage) (datatypes.JSON, error){
+	models.CmdKindRestartService: restartServicePayload,
+	models.CmdKindRunDiagnostic:  DiagnosticPayload,
+}
+
+func restartServicePayload(raw json.RawMessage) (datatypes.JSON, error) {
+	var p struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload")
+	}
+	name, ok := SystemdUnitName(p.Name)
+	if !ok {
+		return nil, fmt.Errorf("invalid service name")
+	}
+	data, _ := json.Marshal(map[string]string{"name": name})
+	return data, nil
+}
+
+var (
+	diagnosticNameRe     = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+	diagnosticParamKeyRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+// DiagnosticPayload checks a run_diagnostic request. Which diagnostics
+// exist and what their parameters mean is up to the agent's allow-list;
+// this only keeps obviously malformed requests from being queued.
+func DiagnosticPayload(raw json.RawMessage) (datatypes.JSON, error) {
+	var p struct {
+		Name   string            `json:"name"`
+		Params map[string]string `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload")
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if !diagnosticNameRe.MatchString(p.Name) {
+		return nil, fmt.Errorf("invalid diagnostic name")
+	}
+	if len(p.Params) > 8 {
+		return nil, fmt.Errorf("too many parameters")
+	}
+	for k, v := range p.Params {
+		if !diagnosticParamKeyRe.MatchString(k) {
+			return nil, fmt.Errorf("invalid parameter name %q", k)
+		}
+		if len(v) > 128 || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("invalid value for parameter %q", k)
+		}
+	}
+	if p.Params == nil {
+		p.Params = map[string]string{}
+	}
+	data, _ := json.Marshal(p)
+	return data, nil
+}
+
+// CommandJobSpec describes a job to start.
+type CommandJobSpec struct {
+	Kind        string
+	Payload     json.RawMessage
+	Selector    string
+	Concurrency int
+	MaxFailures int
+	// TimeoutSeconds and MaxAttempts apply to each node's command.
+	TimeoutSeconds int
+	MaxAttempts    int
+	CreatedByID    *uint
+}
+
+// CreateCommandJob queues the command for every node matching the
+// selector and starts the first batch.
+func CreateCommandJob(spec CommandJobSpec) (*models.CommandJob, error) {
+	validate, ok := commandJobKinds[spec.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported command kind %q", spec.Kind)
+	}
+	if len(spec.Payload) == 0 {
+		spec.Payload = json.RawMessage("{}")
+	}
+	payload, err := validate(spec.Payload)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := ParseNodeSelector(spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("selector is required")
+	}
+	if spec.Concurrency == 0 {
+		spec.Concurrency = 1
+	}
+	if spec.Concurrency < 1 || spec.Concurrency > maxCommandJobConcurrency {
+		return nil, fmt.Errorf("concurrency must be between 1 and %d", maxCommandJobConcurrency)
+	}
+	if spec.MaxFailures < 0 {
+		return nil, fmt.Errorf("max_failures must not be negative")
+	}
+	if err := ValidateCommandPolicy(spec.TimeoutSeconds, spec.MaxAttempts); err != nil {
+		return nil, err
+	}
+
+	var nodes []models.Node
+	if err := sel.Scope(database.DB.Select("id")).
+		Where("status <> ?", models.NodeStatusDecommissioned).
+		Order("id asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrCommandJobNoNodes
+	}
+
+	job := models.CommandJob{
+		Kind:           spec.Kind,
+		Payload:        payload,
+		Selector:       sel.String(),
+		Concurrency:    spec.Concurrency,
+		MaxFailures:    spec.MaxFailures,
+		TimeoutSeconds: spec.TimeoutSeconds,
+		MaxAttempts:    spec.MaxAttempts,
+		Status:         models.CommandJobStatusRunning,
+		CreatedByID:    spec.CreatedByID,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		cmds := make([]models.NodeCommand, 0, len(nodes))
+		for _, n := range nodes {
+			cmds = append(cmds, models.NodeCommand{
+				NodeID:  n.ID,
+				JobID:   &job.ID,
+				Kind:    job.Kind,
+				Payload: payload,
+				Status:  models.NodeCommandStatusQueued,
+
+				TimeoutSeconds: job.TimeoutSeconds,
+				MaxAttempts:    job.MaxAttempts,
+			})
+		}
+		return tx.Create(&cmds).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := AdvanceCommandJob(job.ID); err != nil {
+		logger.Error("Failed to start command job", "error", err, "job_id", job.ID)
+	}
+	return &job, nil
+}
+
+// commandJobMu keeps concurrent advances of a job (a result report racing
+// the reconciler) from releasing more commands than the job allows.
+var commandJobMu sync.Mutex
+
+// AdvanceCommandJob releases queued commands into free slots, skips the
+// rest once too many nodes failed, and completes the job when nothing is
+// left in flight.
+func AdvanceCommandJob(jobID uint) error {
+	commandJobMu.Lock()
+	defer commandJobMu.Unlock()
+
+	var job models.CommandJob
+	if err := database.DB.First(&job, jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCommandJobNotFound
+		}
+		return err
+	}
+	if job.Status != models.CommandJobStatusRunning {
+		return nil
+	}
+
+	counts, err := commandJobCounts(job.ID)
+	if err != nil {
+		return err
+	}
+	step := planCommandJobStep(&job, counts)
+
+	if step.skip {
+		if err := database.DB.Model(&models.NodeCommand{}).
+			Where("job_id = ? AND status = ?", job.ID, models.NodeCommandStatusQueued).
+			Update("status", models.NodeCommandStatusSkipped).Error; err != nil {
+			return err
+		}
+		logger.Warn("Command job exceeded its failure threshold", "job_id", job.ID, "skipped", counts[models.NodeCommandStatusQueued])
+	}
+
+	if step.release > 0 {
+		var next []uint
+		if err := database.DB.Model(&models.NodeCommand{}).
+			Where("job_id = ? AND status = ?", job.ID, models.NodeCommandStatusQueued).
+			Order("id asc").Limit(step.release).Pluck("id", &next).Error; err != nil {
+			return err
+		}
+		deadline := time.Now().Add(commandDispatchTimeout)
+		if err := database.DB.Model(&models.NodeCommand{}).
+			Where("id IN ? AND status = ?", next, models.NodeCommandStatusQueued).
+			Updates(map[string]any{
+				"status":   models.NodeCommandStatusPending,
+				"deadline": &deadline,
+			}).Error; err != nil {
+			return err
+		}
+	}
+
+	if step.done != "" {
+		now := time.Now()
+		if err := database.DB.Model(&job).Updates(map[string]any{
+			"status":       step.done,
+			"completed_at": &now,
+		}).Error; err != nil {
+			return err
+		}
+		logger.Info("Command job completed", "job_id", job.ID, "status", step.done)
+	}
+	return nil
+}
+
+type commandJobStep struct {
+	release int                     // queued commands to make pending
+	skip    bool                    // skip every queued command
+	done    models.CommandJobStatus // final status, once nothing is left
+}
+
+// planCommandJobStep decides the next step of a running job from how many
+// of its commands are in each status.
+func planCommandJobStep(job *models.CommandJob, counts map[models.NodeCommandStatus]int64) commandJobStep {
+	var step commandJobStep
+	active := counts[models.NodeCommandStatusPending] + counts[models.NodeCommandStatusRunning]
+	queued := counts[models.NodeCommandStatusQueued]
+	failed := counts[models.NodeCommandStatusFailed] > int64(job.MaxFailures)
+
+	if failed && queued > 0 {
+		step.skip = true
+		queued = 0
+	}
+	if slots := int64(job.Concurrency) - active; queued > 0 && slots > 0 {
+		step.release = int(min(slots, queued))
+		active += int64(step.release)
+		queued -= int64(step.release)
+	}
+	if active == 0 && queued == 0 {
+		step.done = models.CommandJobStatusSucceeded
+		if failed {
+			step.done = models.CommandJobStatusFailed
+		}
+	}
+	return step
+}
+
+// CancelCommandJob stops a running job: commands that have not reached
+// their agent are cancelled and running ones are asked to stop.
+func CancelCommandJob(jobID uint) (*models.CommandJob, error) {
+	commandJobMu.Lock()
+	defer commandJobMu.Unlock()
+
+	var job models.CommandJob
+	if err := database.DB.First(&job, jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommandJobNotFound
+		}
+		return nil, err
+	}
+	if job.Status != models.CommandJobStatusRunning {
+		return &job, nil
+	}
+
+	now := time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.NodeCommand{}).
+			Where("job_id = ? AND status IN ?", job.ID, []models.NodeCommandStatus{models.NodeCommandStatusQueued, models.NodeCommandStatusPending}).
+			Updates(map[string]any{
+				"status":              models.NodeCommandStatusCancelled,
+				"cancel_requested_at": &now,
+				"completed_at":        &now,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.NodeCommand{}).
+			Where("job_id = ? AND status = ?", job.ID, models.NodeCommandStatusRunning).
+			Update("cancel_requested_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&job).Updates(map[string]any{
+			"status":       models.CommandJobStatusCancelled,
+			"completed_at": &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	job.Status = models.CommandJobStatusCancelled
+	job.CompletedAt = &now
+	return &job, nil
+}
+
+// ReconcileCommandJobs advances every running job.
+func ReconcileCommandJobs() error {
+	var ids []uint
+	if err := database.DB.Model(&models.CommandJob{}).
+		Where("status = ?", models.CommandJobStatusRunning).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := AdvanceCommandJob(id); err != nil {
+			logger.Error("Failed to advance command job", "error", err, "job_id", id)
+		}
+	}
+	return nil
+}
+
+func commandJobCounts(jobID uint) (map[models.NodeCommandStatus]int64, error) {
+	var rows []struct {
+		Status models.NodeCommandStatus
+		Count  int64
+	}
+	if err := database.DB.Model(&models.NodeCommand{}).
+		Select("status, COUNT(*) AS count").
+		Where("job_id = ?", jobID).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[models.NodeCommandStatus]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	return counts, nil
+}
+
+// CommandJobNodeResult is one node's part of a job summary.
+type CommandJobNodeResult struct {
+	CommandID   uint                     `json:"command_id"`
+	NodeID      uint                     `json:"node_id"`
+	Hostname    string                   `json:"hostname"`
+	Status      models.NodeCommandStatus `json:"status"`
+	Output      string                   `json:"output,omitempty"`
+	Error       string                   `json:"error,omitempty"`
+	StartedAt   *time.Time               `json:"started_at,omitempty"`
+	CompletedAt *time.Time               `json:"completed_at,omitempty"`
+}
+
+// CommandJobSummary aggregates a job's per-node results.
+type CommandJobSummary struct {
+	Job    models.CommandJob                  `json:"job"`
+	Total  int                                `json:"total"`
+	Counts map[models.NodeCommandStatus]int64 `json:"counts"`
+	Nodes  []CommandJobNodeResult             `json:"nodes"`
+}
+
+func SummarizeCommandJob(jobID uint) (*CommandJobSummary, error) {
+	var job models.CommandJob
+	if err := database.DB.First(&job, jobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommandJobNotFound
+		}
+		return nil, err
+	}
+	var cmds []models.NodeCommand
+	if err := database.DB.Where("job_id = ?", job.ID).Order("id asc").Find(&cmds).Error; err != nil {
+		return nil, err
+	}
+	nodeIDs := make([]uint, 0, len(cmds))
+	for _, cmd := range cmds {
+		nodeIDs = append(nodeIDs, cmd.NodeID)
+	}
+	var nodes []models.Node
+	if err := database.DB.Select("id", "hostname").Where("id IN ?", nodeIDs).Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	hostnames := make(map[uint]string, len(nodes))
+	for _, n := range nodes {
+		hostnames[n.ID] = n.Hostname
+	}
+
+	summary := &CommandJobSummary{
+		Job:    job,
+		Total:  len(cmds),
+		Counts: map[models.NodeCommandStatus]int64{},
+		Nodes:  make([]CommandJobNodeResult, 0, len(cmds)),
+	}
+	for _, cmd := range cmds {
+		summary.Counts[cmd.Status]++
+		summary.Nodes = append(summary.Nodes, CommandJobNodeResult{
+			CommandID:   cmd.ID,
+			NodeID:      cmd.NodeID,
+			Hostname:    hostnames[cmd.NodeID],
+			Status:      cmd.Status,
+			Output:      cmd.Output,
+			Error:       cmd.Error,
+			StartedAt:   cmd.StartedAt,
+			CompletedAt: cmd.CompletedAt,
+		})
+	}
+	return summary, nil
+}
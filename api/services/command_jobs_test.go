@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gluon-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCommandJobStep(t *testing.T) {
+	job := &models.CommandJob{Concurrency: 2, MaxFailures: 1}
+	type counts = map[models.NodeCommandStatus]int64
+
+	assert.Equal(t, commandJobStep{release: 2}, planCommandJobStep(job, counts{models.NodeCommandStatusQueued: 5}))
+	assert.Equal(t, commandJobStep{release: 1}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusQueued:  4,
+		models.NodeCommandStatusRunning: 1,
+	}))
+	assert.Equal(t, commandJobStep{}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusQueued:  3,
+		models.NodeCommandStatusPending: 1,
+		models.NodeCommandStatusRunning: 1,
+	}))
+	// One failure is tolerated.
+	assert.Equal(t, commandJobStep{release: 1}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusQueued:  3,
+		models.NodeCommandStatusFailed:  1,
+		models.NodeCommandStatusRunning: 1,
+	}))
+	// The second one stops the rollout but lets running commands finish.
+	assert.Equal(t, commandJobStep{skip: true}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusQueued:  3,
+		models.NodeCommandStatusFailed:  2,
+		models.NodeCommandStatusRunning: 1,
+	}))
+	assert.Equal(t, commandJobStep{done: models.CommandJobStatusFailed}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusFailed:  2,
+		models.NodeCommandStatusSkipped: 3,
+	}))
+	assert.Equal(t, commandJobStep{done: models.CommandJobStatusSucceeded}, planCommandJobStep(job, counts{
+		models.NodeCommandStatusSucceeded: 4,
+		models.NodeCommandStatusFailed:    1,
+	}))
+}
+
+func TestRestartServicePayload(t *testing.T) {
+	payload, err := restartServicePayload(json.RawMessage(`{"name":" frr "}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"frr.service"}`, string(payload))
+
+	_, err = restartServicePayload(json.RawMessage(`{"name":"frr; reboot"}`))
+	assert.Error(t, err)
+	_, err = restartServicePayload(json.RawMessage(`[]`))
+	assert.Error(t, err)
+}
+
+func TestDiagnosticPayload(t *testing.T) {
+	payload, err := DiagnosticPayload(json.RawMessage(`{"name":"journal","params":{"unit":"frr","lines":"50"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"journal","params":{"unit":"frr","lines":"50"}}`, string(payload))
+
+	payload, err = DiagnosticPayload(json.RawMessage(`{"name":"ip_route"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"ip_route","params":{}}`, string(payload))
+
+	_, err = DiagnosticPayload(json.RawMessage(`{"name":"../bin/sh"}`))
+	assert.ErrorContains(t, err, "diagnostic name")
+	_, err = DiagnosticPayload(json.RawMessage(`{"name":"journal","params":{"Unit":"frr"}}`))
+	assert.ErrorContains(t, err, "parameter name")
+	_, err = DiagnosticPayload(json.RawMessage(`{"name":"journal","params":{"unit":"frr\nx"}}`))
+	assert.ErrorContains(t, err, "invalid value")
+}
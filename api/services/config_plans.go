@@ -0,0 +1,111 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gluon-api/database"
+	"gluon-api/models"
+)
+
+const (
+	// configPlansKept is how many plans are kept per node.
+	configPlansKept = 20
+	// maxPlanEntries bounds the files and actions one plan may list.
+	maxPlanEntries = 500
+	// maxPlanDiffBytes bounds one file's diff; agents cap theirs at 16KiB.
+	maxPlanDiffBytes = 64 << 10
+)
+
+// ConfigPlanInput is a plan as reported by an agent.
+type ConfigPlanInput struct {
+	Version    int                `json:"version"`
+	Hash       string             `json:"hash"`
+	DryRun     bool               `json:"dry_run"`
+	Disruptive bool               `json:"disruptive"`
+	Files      []ConfigPlanFile   `json:"files"`
+	Actions    []ConfigPlanAction `json:"actions"`
+}
+
+type ConfigPlanFile struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+type ConfigPlanAction struct {
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	Disruptive bool   `json:"disruptive"`
+	Reason     string `json:"reason"`
+}
+
+// NewConfigPlan validates a reported plan and builds the record for it.
+// Disruptive is recomputed from the actions rather than trusted.
+func NewConfigPlan(nodeID uint, in *ConfigPlanInput) (*models.NodeConfigPlan, error) {
+	if in.Version <= 0 {
+		return nil, errors.New("version is required")
+	}
+	if len(in.Files) > maxPlanEntries || len(in.Actions) > maxPlanEntries {
+		return nil, fmt.Errorf("a plan may list at most %d files and %d actions", maxPlanEntries, maxPlanEntries)
+	}
+	for i, f := range in.Files {
+		if f.Path == "" {
+			return nil, fmt.Errorf("files[%d]: path is required", i)
+		}
+		if len(f.Diff) > maxPlanDiffBytes {
+			in.Files[i].Diff = f.Diff[:maxPlanDiffBytes] + "\n... diff truncated\n"
+		}
+	}
+	disruptive := false
+	for i, a := range in.Actions {
+		if a.Action == "" {
+			return nil, fmt.Errorf("actions[%d]: action is required", i)
+		}
+		disruptive = disruptive || a.Disruptive
+	}
+
+	if in.Files == nil {
+		in.Files = []ConfigPlanFile{}
+	}
+	if in.Actions == nil {
+		in.Actions = []ConfigPlanAction{}
+	}
+	files, err := json.Marshal(in.Files)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := json.Marshal(in.Actions)
+	if err != nil {
+		return nil, err
+	}
+	return &models.NodeConfigPlan{
+		NodeID:     nodeID,
+		Version:    in.Version,
+		Hash:       in.Hash,
+		DryRun:     in.DryRun,
+		Disruptive: disruptive,
+		Files:      files,
+		Actions:    actions,
+	}, nil
+}
+
+// RecordConfigPlan stores a plan and drops the node's older ones beyond
+// configPlansKept.
+func RecordConfigPlan(plan *models.NodeConfigPlan) error {
+	if err := database.DB.Create(plan).Error; err != nil {
+		return err
+	}
+	var stale []uint
+	if err := database.DB.Model(&models.NodeConfigPlan{}).
+		Where("node_id = ?", plan.NodeID).
+		Order("id desc").Offset(configPlansKept).
+		Pluck("id", &stale).Error; err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return database.DB.Delete(&models.NodeConfigPlan{}, stale).Error
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfigPlan(t *testing.T) {
+	plan, err := NewConfigPlan(3, &ConfigPlanInput{
+		Version:    7,
+		Hash:       "abc",
+		Disruptive: false,
+		Files: []ConfigPlanFile{
+			{Path: "/etc/wireguard/wg-1.conf", Change: "update", Diff: strings.Repeat("x", maxPlanDiffBytes+10)},
+		},
+		Actions: []ConfigPlanAction{
+			{Action: "wg_syncconf", Target: "wg-1"},
+			{Action: "frr_restart", Target: "frr", Disruptive: true},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), plan.NodeID)
+	assert.Equal(t, 7, plan.Version)
+	assert.True(t, plan.Disruptive, "recomputed from the actions")
+
+	var files []ConfigPlanFile
+	require.NoError(t, json.Unmarshal(plan.Files, &files))
+	assert.True(t, strings.HasSuffix(files[0].Diff, "... diff truncated\n"))
+
+	plan, err = NewConfigPlan(3, &ConfigPlanInput{Version: 8})
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(plan.Actions))
+	assert.False(t, plan.Disruptive)
+}
+
+func TestNewConfigPlanRejectsInvalid(t *testing.T) {
+	_, err := NewConfigPlan(1, &ConfigPlanInput{})
+	assert.EqualError(t, err, "version is required")
+	_, err = NewConfigPlan(1, &ConfigPlanInput{Version: 1, Files: []ConfigPlanFile{{Change: "update"}}})
+	assert.EqualError(t, err, "files[0]: path is required")
+	_, err = NewConfigPlan(1, &ConfigPlanInput{Version: 1, Actions: []ConfigPlanAction{{Target: "wg-1"}}})
+	assert.EqualError(t, err, "actions[0]: action is required")
+	_, err = NewConfigPlan(1, &ConfigPlanInput{Version: 1, Actions: make([]ConfigPlanAction, maxPlanEntries+1)})
+	assert.Error(t, err)
+}
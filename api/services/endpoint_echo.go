@@ -0,0 +1,64 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"gluon-api/logger"
+	"net"
+	"net/netip"
+)
+
+// The endpoint echo is a minimal STUN-like service: an agent sends a probe
+// and gets back the address the packet arrived from, which is its public
+// IP:port as seen from outside any NAT.
+const (
+	endpointEchoMagic = "GLUON-ECHO1"
+	// Probes must be at least this long and replies never are, so the
+	// service can't be used to amplify traffic towards a spoofed source.
+	endpointEchoProbeSize = 64
+)
+
+// StartEndpointEcho serves the endpoint echo on the given UDP port.
+func StartEndpointEcho(port int) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp/%d: %w", port, err)
+	}
+
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				logger.Error("Endpoint echo read failed", "error", err)
+				continue
+			}
+			reply := endpointEchoReply(buf[:n], addr)
+			if reply == nil {
+				continue
+			}
+			if _, err := conn.WriteToUDP(reply, addr); err != nil {
+				logger.Warn("Endpoint echo write failed", "error", err, "remote", addr.String())
+			}
+		}
+	}()
+
+	logger.Info("Endpoint echo listening", "port", port)
+	return nil
+}
+
+// endpointEchoReply returns the response to a probe, or nil if the packet
+// isn't one.
+func endpointEchoReply(probe []byte, addr *net.UDPAddr) []byte {
+	if addr == nil || len(probe) < endpointEchoProbeSize || !bytes.HasPrefix(probe, []byte(endpointEchoMagic)) {
+		return nil
+	}
+	ap := addr.AddrPort()
+	observed := netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	reply := []byte(endpointEchoMagic + " " + observed.String())
+	if len(reply) > len(probe) {
+		return nil
+	}
+	return reply
+}
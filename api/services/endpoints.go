@@ -0,0 +1,213 @@
+package services
+
+import (
+	"fmt"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// peerEndpoint is the Endpoint local should dial to reach peer on port.
+// Hubs never dial workers: WireGuard learns a worker's address from its first
+// handshake and heartbeats record it in NodePeer.Endpoint. A peer behind NAT
+// cannot be dialled either. If both ends are behind NAT there is nobody to
+// wait for, so the last known address is used anyway.
+func peerEndpoint(local *models.Node, peer *models.Node, port int) string {
+	if local.Role == models.NodeRoleHub && peer.Role == models.NodeRoleWorker {
+		return ""
+	}
+	if peer.BehindNAT && !local.BehindNAT {
+		return ""
+	}
+	if strings.TrimSpace(peer.PublicIP) == "" || port == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", peer.PublicIP, port)
+}
+
+// GeneratedEndpoint is the Endpoint written into local's WireGuard config for
+// a peer entry whose recorded endpoint is endpoint. Endpoints learned for a
+// peer flagged as behind NAT are left out: its NAT mapping may be gone by the
+// time local dials it, and the peer keeps the path open from its side.
+func GeneratedEndpoint(local *models.Node, peer *models.Node, endpoint string) string {
+	if peer.BehindNAT && !local.BehindNAT {
+		return ""
+	}
+	return endpoint
+}
+
+// natKeepalive is the PersistentKeepalive for a peer entry on local: a node
+// behind NAT must keep its mapping open or nobody can reach it.
+func natKeepalive(local *models.Node, fallback int) int {
+	if local.BehindNAT && fallback == 0 {
+		return 25
+	}
+	return fallback
+}
+
+// sameLinkSubnet reports whether two interface addresses sit on the same
+// point-to-point /31, i.e. are the two ends of one link.
+func sameLinkSubnet(a string, b string) bool {
+	pa, err := netip.ParsePrefix(strings.TrimSpace(a))
+	if err != nil {
+		return false
+	}
+	pb, err := netip.ParsePrefix(strings.TrimSpace(b))
+	if err != nil || pa.Bits() != pb.Bits() {
+		return false
+	}
+	return pa.Masked() == pb.Masked()
+}
+
+// LinkPeersFacing returns the peer entries on other nodes that point at iface,
+// i.e. the far ends of the links iface terminates.
+func LinkPeersFacing(iface models.WireGuardInterface) ([]models.NodePeer, error) {
+	var peers []models.NodePeer
+	if err := database.DB.
+		Where("peer_node_id = ?", iface.NodeID).
+		Preload("Interface").
+		Find(&peers).Error; err != nil {
+		return nil, err
+	}
+
+	out := make([]models.NodePeer, 0, 1)
+	for _, p := range peers {
+		if sameLinkSubnet(p.Interface.Address, iface.Address) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// RefreshNodeEndpoints recomputes the configured Endpoint of every peer entry
+// that points at nodeID or lives on one of its interfaces. Config bundles are
+// hashed from these rows, so affected agents pick up the change on their next
+// sync.
+func RefreshNodeEndpoints(nodeID uint) error {
+	var ifaceIDs []uint
+	if err := database.DB.Model(&models.WireGuardInterface{}).Where("node_id = ?", nodeID).Pluck("id", &ifaceIDs).Error; err != nil {
+		return err
+	}
+
+	q := database.DB.
+		Preload("Interface").
+		Preload("Interface.Node").
+		Preload("PeerNode")
+	if len(ifaceIDs) > 0 {
+		q = q.Where("peer_node_id = ? OR interface_id IN ?", nodeID, ifaceIDs)
+	} else {
+		q = q.Where("peer_node_id = ?", nodeID)
+	}
+	var peers []models.NodePeer
+	if err := q.Find(&peers).Error; err != nil {
+		return err
+	}
+
+	ifacesByNode := make(map[uint][]models.WireGuardInterface)
+	for _, p := range peers {
+		// Hub-side worker endpoints are learned, not derived.
+		if p.Interface.Node.Role == models.NodeRoleHub && p.PeerNode.Role == models.NodeRoleWorker {
+			continue
+		}
+		remote, ok := ifacesByNode[p.PeerNodeID]
+		if !ok {
+			if err := database.DB.Where("node_id = ?", p.PeerNodeID).Find(&remote).Error; err != nil {
+				return err
+			}
+			ifacesByNode[p.PeerNodeID] = remote
+		}
+
+		port := 0
+		for _, iface := range remote {
+			if sameLinkSubnet(iface.Address, p.Interface.Address) {
+				port = iface.ListenPort
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+
+		desired := peerEndpoint(&p.Interface.Node, &p.PeerNode, port)
+		keepalive := natKeepalive(&p.Interface.Node, p.PersistentKeepAlive)
+		if desired == p.Endpoint && keepalive == p.PersistentKeepAlive {
+			continue
+		}
+		if err := database.DB.Model(&models.NodePeer{}).Where("id = ?", p.ID).Updates(map[string]any{
+			"endpoint":              desired,
+			"persistent_keep_alive": keepalive,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObserveNodeEndpoint records the public address an agent discovered through
+// the endpoint echo. A changed public IP or NAT state is written to the node
+// and pushed into every affected peer entry.
+func ObserveNodeEndpoint(node *models.Node, publicIP string, behindNAT bool) error {
+	now := time.Now()
+	updates := map[string]any{
+		"endpoint_observed_at": now,
+	}
+	messages := make([]string, 0, 2)
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(publicIP))
+	if err == nil {
+		updates["observed_public_ip"] = addr.String()
+		if isPublicAddr(addr) && addr.String() != node.PublicIP {
+			messages = append(messages, fmt.Sprintf("Public IP changed from %s to %s", node.PublicIP, addr.String()))
+			updates["public_ip"] = addr.String()
+		}
+	}
+	if !node.NATOverride && behindNAT != node.BehindNAT {
+		if behindNAT {
+			messages = append(messages, "Node detected behind NAT")
+		} else {
+			messages = append(messages, "Node no longer behind NAT")
+		}
+		updates["behind_nat"] = behindNAT
+	}
+
+	if err := database.DB.Model(&models.Node{}).Where("id = ?", node.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	node.EndpointObservedAt = &now
+	if v, ok := updates["observed_public_ip"].(string); ok {
+		node.ObservedPublicIP = v
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	if v, ok := updates["public_ip"].(string); ok {
+		node.PublicIP = v
+	}
+	if v, ok := updates["behind_nat"].(bool); ok {
+		node.BehindNAT = v
+	}
+
+	logger.Info("Node endpoint changed", "node_id", node.ID, "public_ip", node.PublicIP, "behind_nat", node.BehindNAT)
+	event := models.Event{
+		Kind:    models.EventKindNodeEndpointChanged,
+		NodeID:  &node.ID,
+		Message: strings.Join(messages, "; "),
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		logger.Error("Failed to create endpoint change event", "error", err, "node_id", node.ID)
+	}
+
+	return RefreshNodeEndpoints(node.ID)
+}
+
+// isPublicAddr filters out addresses that must never become a node's public
+// IP, e.g. when the agent reaches the API over a private network.
+func isPublicAddr(addr netip.Addr) bool {
+	if !addr.Is4() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	return !netip.MustParsePrefix("100.64.0.0/10").Contains(addr)
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"gluon-api/models"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerEndpoint(t *testing.T) {
+	hub := &models.Node{Role: models.NodeRoleHub, PublicIP: "203.0.113.1"}
+	worker := &models.Node{Role: models.NodeRoleWorker, PublicIP: "198.51.100.7"}
+	natWorker := &models.Node{Role: models.NodeRoleWorker, PublicIP: "198.51.100.8", BehindNAT: true}
+	otherNATWorker := &models.Node{Role: models.NodeRoleWorker, PublicIP: "198.51.100.9", BehindNAT: true}
+
+	// Hubs learn worker endpoints from handshakes, NAT or not.
+	assert.Equal(t, "", peerEndpoint(hub, worker, 51820))
+	assert.Equal(t, "", peerEndpoint(hub, natWorker, 51820))
+	assert.Equal(t, "203.0.113.1:52005", peerEndpoint(natWorker, hub, 52005))
+	assert.Equal(t, "198.51.100.7:55001", peerEndpoint(otherNATWorker, worker, 55001))
+	assert.Equal(t, "", peerEndpoint(worker, natWorker, 55002))
+	// Nobody can accept the handshake, so fall back to the last known address.
+	assert.Equal(t, "198.51.100.9:55003", peerEndpoint(natWorker, otherNATWorker, 55003))
+	assert.Equal(t, "", peerEndpoint(hub, &models.Node{}, 51820))
+}
+
+func TestGeneratedEndpoint(t *testing.T) {
+	hub := &models.Node{Role: models.NodeRoleHub}
+	worker := &models.Node{Role: models.NodeRoleWorker}
+	natWorker := &models.Node{Role: models.NodeRoleWorker, BehindNAT: true}
+
+	assert.Equal(t, "198.51.100.7:40001", GeneratedEndpoint(hub, worker, "198.51.100.7:40001"))
+	assert.Equal(t, "", GeneratedEndpoint(hub, natWorker, "198.51.100.8:40002"))
+	assert.Equal(t, "198.51.100.9:40003", GeneratedEndpoint(natWorker, natWorker, "198.51.100.9:40003"))
+}
+
+func TestSameLinkSubnet(t *testing.T) {
+	assert.True(t, sameLinkSubnet("10.255.8.0/31", "10.255.8.1/31"))
+	assert.False(t, sameLinkSubnet("10.255.8.1/31", "10.255.8.2/31"))
+	assert.False(t, sameLinkSubnet("10.255.8.0/31", "10.255.8.0/24"))
+	assert.False(t, sameLinkSubnet("", "10.255.8.1/31"))
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	assert.True(t, isPublicAddr(netip.MustParseAddr("198.51.100.7")))
+	assert.False(t, isPublicAddr(netip.MustParseAddr("10.1.2.3")))
+	assert.False(t, isPublicAddr(netip.MustParseAddr("192.168.1.10")))
+	assert.False(t, isPublicAddr(netip.MustParseAddr("100.64.3.4")))
+	assert.False(t, isPublicAddr(netip.MustParseAddr("127.0.0.1")))
+	assert.False(t, isPublicAddr(netip.MustParseAddr("2001:db8::1")))
+}
+
+func TestEndpointEchoReply(t *testing.T) {
+	probe := []byte(endpointEchoMagic + strings.Repeat(" ", endpointEchoProbeSize))
+	addr := &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+
+	assert.Equal(t, endpointEchoMagic+" 198.51.100.7:40000", string(endpointEchoReply(probe, addr)))
+	assert.Nil(t, endpointEchoReply([]byte(endpointEchoMagic), addr), "short probes are ignored")
+	assert.Nil(t, endpointEchoReply([]byte(strings.Repeat("x", 128)), addr), "unknown payloads are ignored")
+}
+
+func TestNATKeepalive(t *testing.T) {
+	assert.Equal(t, 25, natKeepalive(&models.Node{BehindNAT: true}, 0))
+	assert.Equal(t, 0, natKeepalive(&models.Node{}, 0))
+	assert.Equal(t, 15, natKeepalive(&models.Node{BehindNAT: true}, 15))
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRestoreInProgress = errors.New("an etcd restore is already pending")
+	ErrRestoreNotFound   = errors.New("no etcd restore is running on this node")
+)
+
+// EtcdSnapshotDue reports whether nodeID should take a snapshot now: it
+// must be its cluster's bootstrap hub, and either the schedule has come
+// round or an admin asked for one since the cluster's last snapshot.
+func EtcdSnapshotDue(nodeID uint, now time.Time) bool {
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return false
+	}
+	cluster, err := KubernetesClusterForNode(&node)
+	if err != nil {
+		return false
+	}
+	if cluster.InitializedAt == nil || cluster.BootstrapNodeID == nil || *cluster.BootstrapNodeID != nodeID {
+		return false
+	}
+
+	var last *time.Time
+	var snap models.EtcdSnapshot
+	if err := KubernetesClusterScope(database.DB, cluster).Order("id desc").First(&snap).Error; err == nil {
+		last = &snap.CreatedAt
+	}
+	return snapshotDue(last, cluster.EtcdSnapshotRequestedAt, time.Duration(config.Current().EtcdSnapshotIntervalMinutes)*time.Minute, now)
+}
+
+func snapshotDue(last *time.Time, requested *time.Time, interval time.Duration, now time.Time) bool {
+	if requested != nil && (last == nil || requested.After(*last)) {
+		return true
+	}
+	if interval <= 0 {
+		return false
+	}
+	return last == nil || now.Sub(*last) >= interval
+}
+
+// StoreEtcdSnapshot saves a gzip-compressed snapshot upload. The checksum
+// the agent computed over the uncompressed snapshot is verified while the
+// upload is written, so a truncated or corrupted upload is never kept.
+func StoreEtcdSnapshot(nodeID uint, body io.Reader, sha string, revision int64) (*models.EtcdSnapshot, error) {
+	sha = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(sha), "sha256:"))
+	if len(sha) != sha256.Size*2 {
+		return nil, errors.New("a sha256 checksum of the snapshot is required")
+	}
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return nil, errors.New("node not found")
+	}
+	cluster, err := KubernetesClusterForNode(&node)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := config.Current().EtcdSnapshotsDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*.db.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, stored, err := copyVerifiedSnapshot(tmp, body, sha)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("etcd-%s-node%d.db.gz", now.UTC().Format("20060102T150405Z"), nodeID))
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	snap := models.EtcdSnapshot{
+		CreatedAt:           now,
+		KubernetesClusterID: &cluster.ID,
+		NodeID:              nodeID,
+		Revision:            revision,
+		SizeBytes:           size,
+		StoredBytes:         stored,
+		SHA256:              "sha256:" + sha,
+		Path:                path,
+	}
+	if err := database.DB.Create(&snap).Error; err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	logger.Info("Stored etcd snapshot", "snapshot_id", snap.ID, "node_id", nodeID, "size", snap.SizeBytes, "revision", revision)
+
+	PruneEtcdSnapshots(cluster, config.Current().EtcdSnapshotRetention)
+	return &snap, nil
+}
+
+// copyVerifiedSnapshot writes a gzip-compressed snapshot to dst while
+// decompressing it on the side to check sha, the hex sha256 of the snapshot
+// itself. It returns the uncompressed and the stored size.
+func copyVerifiedSnapshot(dst io.Writer, body io.Reader, sha string) (int64, int64, error) {
+	pr, pw := io.Pipe()
+	type hashResult struct {
+		sum  string
+		size int64
+		err  error
+	}
+	hashed := make(chan hashResult, 1)
+	go func() {
+		gz, err := gzip.NewReader(pr)
+		if err != nil {
+			pr.CloseWithError(err)
+			hashed <- hashResult{err: fmt.Errorf("snapshot is not gzip-compressed: %w", err)}
+			return
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, gz)
+		pr.CloseWithError(err)
+		hashed <- hashResult{sum: hex.EncodeToString(h.Sum(nil)), size: n, err: err}
+	}()
+
+	stored, copyErr := io.Copy(io.MultiWriter(dst, pw), body)
+	pw.CloseWithError(copyErr)
+	res := <-hashed
+	if res.err != nil {
+		return 0, 0, res.err
+	}
+	if copyErr != nil {
+		return 0, 0, copyErr
+	}
+	if res.sum != sha {
+		return 0, 0, fmt.Errorf("checksum mismatch: got sha256:%s, expected sha256:%s", res.sum, sha)
+	}
+	return res.size, stored, nil
+}
+
+// PruneEtcdSnapshots keeps cluster's newest keep snapshots, plus any a
+// pending or running restore still needs. keep <= 0 keeps everything.
+func PruneEtcdSnapshots(cluster *models.KubernetesCluster, keep int) {
+	if keep <= 0 {
+		return
+	}
+	var snaps []models.EtcdSnapshot
+	if err := KubernetesClusterScope(database.DB, cluster).Order("id desc").Find(&snaps).Error; err != nil {
+		logger.Error("Failed to list etcd snapshots for pruning", "error", err)
+		return
+	}
+	for i := keep; i < len(snaps); i++ {
+		var inUse int64
+		database.DB.Model(&models.EtcdRestore{}).
+			Where("snapshot_id = ? AND status IN ?", snaps[i].ID, []string{models.EtcdRestorePending, models.EtcdRestoreRunning}).
+			Count(&inUse)
+		if inUse > 0 {
+			continue
+		}
+		if err := DeleteEtcdSnapshot(&snaps[i]); err != nil {
+			logger.Error("Failed to prune etcd snapshot", "error", err, "snapshot_id", snaps[i].ID)
+		}
+	}
+}
+
+func DeleteEtcdSnapshot(snap *models.EtcdSnapshot) error {
+	if err := os.Remove(snap.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return database.DB.Delete(snap).Error
+}
+
+// StartEtcdRestore queues a restore of snapshotID on nodeID, which has to
+// be a control-plane hub.
+func StartEtcdRestore(snapshotID uint, nodeID uint, actorID *uint) (*models.EtcdRestore, error) {
+	var active int64
+	database.DB.Model(&models.EtcdRestore{}).
+		Where("status IN ?", []string{models.EtcdRestorePending, models.EtcdRestoreRunning}).
+		Count(&active)
+	if active > 0 {
+		return nil, ErrRestoreInProgress
+	}
+
+	var node models.Node
+	if err := database.DB.First(&node, nodeID).Error; err != nil {
+		return nil, errors.New("node not found")
+	}
+	controlPlane := node.K8sState == "cluster_initialized" || node.K8sState == "joined_control_plane"
+	if node.Role != models.NodeRoleHub || !controlPlane {
+		return nil, fmt.Errorf("node %s is not a control-plane hub", node.Hostname)
+	}
+	var snap models.EtcdSnapshot
+	if err := database.DB.First(&snap, snapshotID).Error; err != nil {
+		return nil, errors.New("snapshot not found")
+	}
+	nodeCluster, err := KubernetesClusterForNode(&node)
+	if err != nil {
+		return nil, err
+	}
+	snapCluster, err := KubernetesClusterByID(snap.KubernetesClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if nodeCluster.ID != snapCluster.ID {
+		return nil, fmt.Errorf("node %s is not in the snapshot's cluster %s", node.Hostname, snapCluster.Name)
+	}
+
+	restore := models.EtcdRestore{
+		SnapshotID:    snapshotID,
+		NodeID:        nodeID,
+		Status:        models.EtcdRestorePending,
+		Message:       "waiting for the agent",
+		RequestedByID: actorID,
+	}
+	if err := database.DB.Create(&restore).Error; err != nil {
+		return nil, err
+	}
+	return &restore, nil
+}
+
+// EtcdRestoreTaskFor returns the restore nodeID's agent should run, with
+// its snapshot.
+func EtcdRestoreTaskFor(nodeID uint) (*models.EtcdRestore, *models.EtcdSnapshot, bool) {
+	var restore models.EtcdRestore
+	if err := database.DB.
+		Where("node_id = ? AND status IN ?", nodeID, []string{models.EtcdRestorePending, models.EtcdRestoreRunning}).
+		Order("id asc").First(&restore).Error; err != nil {
+		return nil, nil, false
+	}
+	var snap models.EtcdSnapshot
+	if err := database.DB.First(&snap, restore.SnapshotID).Error; err != nil {
+		return nil, nil, false
+	}
+	return &restore, &snap, true
+}
+
+// RecordEtcdRestoreReport stores an agent's progress on its restore:
+// "etcd_restoring" carries a progress message, "etcd_restored" finishes it
+// and "etcd_restore_failed" the error.
+func RecordEtcdRestoreReport(nodeID uint, state string, message string) error {
+	restore, _, ok := EtcdRestoreTaskFor(nodeID)
+	if !ok {
+		return ErrRestoreNotFound
+	}
+
+	now := time.Now()
+	switch state {
+	case "etcd_restoring":
+		return database.DB.Model(restore).Updates(map[string]any{"status": models.EtcdRestoreRunning, "message": message}).Error
+
+	case "etcd_restored":
+		// The restored member is alone now; the other hubs still carry the
+		// old membership and have to be reset before they can rejoin, and
+		// the bootstrap tokens in the snapshot may have expired.
+		var node models.Node
+		if err := database.DB.First(&node, nodeID).Error; err != nil {
+			return err
+		}
+		cluster, err := KubernetesClusterForNode(&node)
+		if err != nil {
+			return err
+		}
+		var others []models.Node
+		KubernetesClusterMembers(database.DB, cluster).
+			Where("id <> ? AND k8s_state IN ?", nodeID, []string{"cluster_initialized", "joined_control_plane"}).
+			Order("id asc").Find(&others)
+		msg := "etcd restored as a single-member cluster"
+		if len(others) > 0 {
+			names := make([]string, 0, len(others))
+			for _, n := range others {
+				names = append(names, n.Hostname)
+			}
+			msg += "; run `kubeadm reset -f` on " + strings.Join(names, ", ") + " so they rejoin as control planes"
+		}
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(restore).Updates(map[string]any{
+				"status":      models.EtcdRestoreSucceeded,
+				"message":     msg,
+				"finished_at": &now,
+			}).Error; err != nil {
+				return err
+			}
+			expired := now.Add(-time.Minute)
+			return tx.Model(&models.KubernetesCluster{}).Where("id = ?", cluster.ID).Updates(map[string]any{
+				"worker_join_command":        "",
+				"control_plane_join_command": "",
+				"join_command_expires_at":    &expired,
+			}).Error
+		})
+		if err == nil {
+			logger.Info("etcd restored from snapshot", "restore_id", restore.ID, "snapshot_id", restore.SnapshotID, "node_id", nodeID)
+		}
+		return err
+
+	case "etcd_restore_failed":
+		logger.Error("etcd restore failed", "restore_id", restore.ID, "node_id", nodeID, "error", message)
+		return database.DB.Model(restore).Updates(map[string]any{
+			"status":      models.EtcdRestoreFailed,
+			"message":     message,
+			"finished_at": &now,
+		}).Error
+
+	default:
+		return fmt.Errorf("unknown restore state %q", state)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestSnapshotDue(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ago := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+	interval := 6 * time.Hour
+
+	assert.True(t, snapshotDue(nil, nil, interval, now), "no snapshot yet")
+	assert.False(t, snapshotDue(ago(time.Hour), nil, interval, now))
+	assert.True(t, snapshotDue(ago(7*time.Hour), nil, interval, now))
+	assert.True(t, snapshotDue(ago(time.Hour), ago(time.Minute), interval, now), "requested after the last snapshot")
+	assert.False(t, snapshotDue(ago(time.Minute), ago(time.Hour), interval, now), "request already served")
+	assert.False(t, snapshotDue(nil, nil, 0, now), "schedule disabled")
+	assert.True(t, snapshotDue(nil, ago(time.Minute), 0, now), "on demand works without a schedule")
+}
+
+func TestCopyVerifiedSnapshot(t *testing.T) {
+	snapshot := bytes.Repeat([]byte("etcd"), 4096)
+	sum := sha256.Sum256(snapshot)
+	sha := hex.EncodeToString(sum[:])
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, err := w.Write(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var out bytes.Buffer
+	size, stored, err := copyVerifiedSnapshot(&out, bytes.NewReader(gz.Bytes()), sha)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(snapshot)), size)
+	assert.Equal(t, int64(gz.Len()), stored)
+	assert.Equal(t, gz.Bytes(), out.Bytes(), "stored compressed as uploaded")
+
+	_, _, err = copyVerifiedSnapshot(&bytes.Buffer{}, bytes.NewReader(gz.Bytes()), hex.EncodeToString(make([]byte, 32)))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	_, _, err = copyVerifiedSnapshot(&bytes.Buffer{}, bytes.NewReader(gz.Bytes()[:gz.Len()/2]), sha)
+	assert.Error(t, err, "truncated upload")
+
+	_, _, err = copyVerifiedSnapshot(&bytes.Buffer{}, bytes.NewReader(snapshot), sha)
+	assert.ErrorContains(t, err, "not gzip-compressed")
+}
+
+func TestStartEtcdRestoreTarget(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.KubernetesCluster{}, &models.Node{}, &models.EtcdSnapshot{}, &models.EtcdRestore{}))
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	clusters := []models.KubernetesCluster{{Name: "default"}, {Name: "edge"}}
+	require.NoError(t, db.Create(&clusters).Error)
+	nodes := []models.Node{
+		{Hostname: "hub-1", Role: models.NodeRoleHub, K8sState: "cluster_initialized"},
+		{Hostname: "hub-2", Role: models.NodeRoleHub, K8sState: "joined_worker"},
+		{Hostname: "worker-1", Role: models.NodeRoleWorker, K8sState: "joined_control_plane"},
+		{Hostname: "edge-hub", Role: models.NodeRoleHub, K8sState: "cluster_initialized", KubernetesClusterID: &clusters[1].ID},
+	}
+	require.NoError(t, db.Create(&nodes).Error)
+	require.NoError(t, db.Create(&models.EtcdSnapshot{NodeID: nodes[0].ID, Path: "/tmp/snap.db.gz"}).Error)
+
+	_, err = StartEtcdRestore(1, nodes[1].ID, nil)
+	assert.Error(t, err, "hub that is not a control-plane member")
+	_, err = StartEtcdRestore(1, nodes[2].ID, nil)
+	assert.Error(t, err, "control-plane state on a worker")
+	_, err = StartEtcdRestore(1, nodes[3].ID, nil)
+	assert.ErrorContains(t, err, "not in the snapshot's cluster")
+
+	restore, err := StartEtcdRestore(1, nodes[0].ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.EtcdRestorePending, restore.Status)
+	_, err = StartEtcdRestore(1, nodes[0].ID, nil)
+	assert.ErrorIs(t, err, ErrRestoreInProgress)
+}
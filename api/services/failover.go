@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var ErrFailoverPolicyNotFound = errors.New("failover policy not found")
+
+// ValidateFailoverPolicy normalises p and checks it before it is saved.
+func ValidateFailoverPolicy(p *models.KubernetesFailoverPolicy) error {
+	p.Namespace = strings.TrimSpace(p.Namespace)
+	if p.Namespace == "" {
+		p.Namespace = "default"
+	}
+	if errs := validation.IsDNS1123Label(p.Namespace); len(errs) > 0 {
+		return fmt.Errorf("namespace %q: %s", p.Namespace, strings.Join(errs, "; "))
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if errs := validation.IsDNS1123Subdomain(p.Name); len(errs) > 0 {
+		return fmt.Errorf("name %q: %s", p.Name, strings.Join(errs, "; "))
+	}
+	switch strings.ToLower(strings.TrimSpace(p.Kind)) {
+	case "deployment", "deployments":
+		p.Kind = models.FailoverKindDeployment
+	case "statefulset", "statefulsets":
+		p.Kind = models.FailoverKindStatefulSet
+	default:
+		return fmt.Errorf("kind must be Deployment or StatefulSet")
+	}
+	if p.GraceSeconds < 0 || p.GraceSeconds > 3600 {
+		return fmt.Errorf("grace_seconds must be between 0 and 3600")
+	}
+	if p.RecoveryTimeoutSeconds < 60 || p.RecoveryTimeoutSeconds > 86400 {
+		return fmt.Errorf("recovery_timeout_seconds must be between 60 and 86400")
+	}
+	return nil
+}
+
+// ReconcileFailover fences nodes that went offline under a protected
+// workload, follows each failover until the workload is fully available
+// again, and lifts the fence once the node's agent is back. Each cluster
+// is reconciled with its own policies and client.
+//
+// A node is only fenced when Kubernetes also reports it NotReady: if its
+// kubelet still reaches the API server, only the path to Gluon is broken
+// and deleting its pods would run two copies of a StatefulSet member.
+func ReconcileFailover() error {
+	var clusters []models.KubernetesCluster
+	if err := database.DB.Order("id asc").Find(&clusters).Error; err != nil {
+		return err
+	}
+	for i := range clusters {
+		if err := reconcileClusterFailover(&clusters[i]); err != nil {
+			logger.Error("Failed to reconcile failover", "error", err, "cluster", clusters[i].Name)
+		}
+	}
+	return nil
+}
+
+func reconcileClusterFailover(cluster *models.KubernetesCluster) error {
+	var policies []models.KubernetesFailoverPolicy
+	if err := KubernetesClusterScope(database.DB, cluster).Order("id asc").Find(&policies).Error; err != nil {
+		return err
+	}
+	var offline []models.Node
+	if err := KubernetesClusterMembers(database.DB, cluster).
+		Where("status = ? AND k8s_state IN ?", models.NodeStatusOffline, []string{"cluster_initialized", "joined_control_plane", "joined_worker"}).
+		Find(&offline).Error; err != nil {
+		return err
+	}
+	var returned []models.Node
+	if err := KubernetesClusterMembers(database.DB, cluster).
+		Where("k8s_fenced_at IS NOT NULL AND status <> ?", models.NodeStatusOffline).
+		Find(&returned).Error; err != nil {
+		return err
+	}
+	members := KubernetesClusterMembers(database.DB.Model(&models.Node{}), cluster).Select("id")
+	var open []models.KubernetesFailover
+	if err := database.DB.Where("status = ? AND node_id IN (?)", models.FailoverStatusRecovering, members).Find(&open).Error; err != nil {
+		return err
+	}
+
+	enabled := make([]models.KubernetesFailoverPolicy, 0, len(policies))
+	for _, p := range policies {
+		if p.Enabled {
+			enabled = append(enabled, p)
+		}
+	}
+	if (len(enabled) == 0 || len(offline) == 0) && len(returned) == 0 && len(open) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	kc, err := KubeClientForSynced(ctx, cluster)
+	if errors.Is(err, kube.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range returned {
+		unfenceNode(ctx, kc, &returned[i])
+	}
+	for i := range offline {
+		failoverNode(ctx, kc, &offline[i], enabled)
+	}
+
+	timeouts := map[uint]time.Duration{}
+	for _, p := range policies {
+		timeouts[p.ID] = time.Duration(p.RecoveryTimeoutSeconds) * time.Second
+	}
+	var recovering []models.KubernetesFailover
+	if err := database.DB.Where("status = ? AND node_id IN (?)", models.FailoverStatusRecovering, members).Find(&recovering).Error; err != nil {
+		return err
+	}
+	for i := range recovering {
+		timeout, ok := timeouts[recovering[i].PolicyID]
+		if !ok {
+			timeout = 15 * time.Minute
+		}
+		checkFailover(kc, &recovering[i], timeout)
+	}
+	return nil
+}
+
+func failoverNode(ctx context.Context, kc *kube.Client, node *models.Node, policies []models.KubernetesFailoverPolicy) {
+	if len(policies) == 0 {
+		return
+	}
+	kn, err := kc.FindNode(node.Hostname)
+	if err != nil || kube.NodeReady(kn) {
+		return
+	}
+	lastSeen := node.CreatedAt
+	if node.LastSeenAt != nil {
+		lastSeen = *node.LastSeenAt
+	}
+
+	for _, p := range policies {
+		if time.Since(lastSeen) < time.Duration(p.GraceSeconds)*time.Second {
+			continue
+		}
+		pods, err := kc.WorkloadPodsOnNode(p.Kind, p.Namespace, p.Name, kn.Name)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				logger.Error("Failed to list workload pods for failover", "error", err, "policy_id", p.ID, "node", kn.Name)
+			}
+			continue
+		}
+		if len(pods) == 0 {
+			continue
+		}
+
+		if node.K8sFencedAt == nil {
+			if err := kc.FenceNode(ctx, kn.Name); err != nil {
+				logger.Error("Failed to fence node", "error", err, "node_id", node.ID, "node", kn.Name)
+				return
+			}
+			now := time.Now()
+			database.DB.Model(node).Update("k8s_fenced_at", &now)
+			node.K8sFencedAt = &now
+			recordEvent(models.EventKindNodeFenced, node.ID, "Node offline and NotReady; tainted out-of-service")
+			logger.Info("Fenced offline node", "node_id", node.ID, "node", kn.Name)
+		}
+
+		var fo models.KubernetesFailover
+		err = database.DB.Where("policy_id = ? AND node_id = ? AND status = ?", p.ID, node.ID, models.FailoverStatusRecovering).First(&fo).Error
+		if err != nil {
+			fo = models.KubernetesFailover{
+				PolicyID:       p.ID,
+				NodeID:         node.ID,
+				NodeName:       kn.Name,
+				Namespace:      p.Namespace,
+				Kind:           p.Kind,
+				Name:           p.Name,
+				Status:         models.FailoverStatusRecovering,
+				NodeLastSeenAt: &lastSeen,
+				FencedAt:       *node.K8sFencedAt,
+			}
+			if err := database.DB.Create(&fo).Error; err != nil {
+				logger.Error("Failed to record failover", "error", err, "policy_id", p.ID, "node_id", node.ID)
+				continue
+			}
+		}
+
+		var problems []string
+		deleted := 0
+		for _, pod := range pods {
+			if err := kc.ForceDeletePod(ctx, pod); err != nil {
+				problems = append(problems, fmt.Sprintf("delete %s: %v", pod.Name, err))
+				continue
+			}
+			deleted++
+		}
+		detached, err := kc.DetachPodVolumes(ctx, kn.Name, pods)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		database.DB.Model(&fo).Updates(map[string]any{
+			"pods_deleted":     fo.PodsDeleted + deleted,
+			"volumes_detached": fo.VolumesDetached + detached,
+			"message":          strings.Join(problems, "; "),
+		})
+		logger.Info("Failing workload over from offline node", "policy_id", p.ID, "workload", p.Namespace+"/"+p.Name,
+			"node", kn.Name, "pods_deleted", deleted, "volumes_detached", detached)
+	}
+}
+
+// checkFailover closes a failover once no pod of the workload is left on
+// the failed node and every desired replica is available elsewhere.
+func checkFailover(kc *kube.Client, fo *models.KubernetesFailover, timeout time.Duration) {
+	available, err := kc.WorkloadAvailable(fo.Kind, fo.Namespace, fo.Name)
+	if apierrors.IsNotFound(err) {
+		finishFailover(fo, models.FailoverStatusFailed, "workload no longer exists")
+		return
+	}
+	if err != nil {
+		return
+	}
+	pods, err := kc.WorkloadPodsOnNode(fo.Kind, fo.Namespace, fo.Name, fo.NodeName)
+	if err == nil && available && len(pods) == 0 {
+		finishFailover(fo, models.FailoverStatusRecovered, "")
+		return
+	}
+	if time.Since(fo.FencedAt) > timeout {
+		finishFailover(fo, models.FailoverStatusFailed, fmt.Sprintf("not fully available %s after fencing", timeout))
+	}
+}
+
+func finishFailover(fo *models.KubernetesFailover, status string, msg string) {
+	now := time.Now()
+	updates := map[string]any{"status": status, "message": msg}
+	if status == models.FailoverStatusRecovered {
+		start := fo.FencedAt
+		if fo.NodeLastSeenAt != nil {
+			start = *fo.NodeLastSeenAt
+		}
+		secs := now.Sub(start).Seconds()
+		updates["recovered_at"] = &now
+		updates["recovery_seconds"] = &secs
+		recordEvent(models.EventKindWorkloadFailedOver, fo.NodeID,
+			fmt.Sprintf("%s %s/%s recovered off %s in %.0fs", fo.Kind, fo.Namespace, fo.Name, fo.NodeName, secs))
+		logger.Info("Workload failover recovered", "failover_id", fo.ID, "workload", fo.Namespace+"/"+fo.Name, "recovery_seconds", secs)
+	} else {
+		logger.Error("Workload failover failed", "failover_id", fo.ID, "workload", fo.Namespace+"/"+fo.Name, "error", msg)
+	}
+	database.DB.Model(fo).Updates(updates)
+}
+
+func unfenceNode(ctx context.Context, kc *kube.Client, node *models.Node) {
+	if kn, err := kc.FindNode(node.Hostname); err == nil {
+		if err := kc.UnfenceNode(ctx, kn.Name); err != nil {
+			logger.Error("Failed to unfence node", "error", err, "node_id", node.ID, "node", kn.Name)
+			return
+		}
+	}
+	database.DB.Model(node).Update("k8s_fenced_at", nil)
+	recordEvent(models.EventKindNodeUnfenced, node.ID, "Node back online; out-of-service taint removed")
+	logger.Info("Unfenced node", "node_id", node.ID, "hostname", node.Hostname)
+}
+
+func recordEvent(kind models.EventKind, nodeID uint, msg string) {
+	event := models.Event{Kind: kind, NodeID: &nodeID, Message: msg}
+	if err := database.DB.Create(&event).Error; err != nil {
+		logger.Error("Failed to create event", "error", err, "kind", kind, "node_id", nodeID)
+	}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"testing"
+
+	"gluon-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFailoverPolicy(t *testing.T) {
+	p := models.KubernetesFailoverPolicy{Kind: "statefulsets", Name: " pg ", RecoveryTimeoutSeconds: 900}
+	require.NoError(t, ValidateFailoverPolicy(&p))
+	assert.Equal(t, "default", p.Namespace)
+	assert.Equal(t, models.FailoverKindStatefulSet, p.Kind)
+	assert.Equal(t, "pg", p.Name)
+
+	bad := []struct {
+		policy models.KubernetesFailoverPolicy
+		msg    string
+	}{
+		{models.KubernetesFailoverPolicy{Kind: "DaemonSet", Name: "x", RecoveryTimeoutSeconds: 900}, "kind must be"},
+		{models.KubernetesFailoverPolicy{Kind: "Deployment", Name: "Bad_Name", RecoveryTimeoutSeconds: 900}, "name"},
+		{models.KubernetesFailoverPolicy{Kind: "Deployment", Namespace: "a.b", Name: "x", RecoveryTimeoutSeconds: 900}, "namespace"},
+		{models.KubernetesFailoverPolicy{Kind: "Deployment", Name: "x", GraceSeconds: -1, RecoveryTimeoutSeconds: 900}, "grace_seconds"},
+		{models.KubernetesFailoverPolicy{Kind: "Deployment", Name: "x", RecoveryTimeoutSeconds: 10}, "recovery_timeout_seconds"},
+	}
+	for _, tc := range bad {
+		assert.ErrorContains(t, ValidateFailoverPolicy(&tc.policy), tc.msg)
+	}
+}
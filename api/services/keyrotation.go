@@ -0,0 +1,295 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"time"
+)
+
+// WireGuard key rotation runs in four steps:
+//
+//  1. A rotate_wireguard_keys command makes the agent generate a new key pair,
+//     keeping the old one on disk, and upload the new public key.
+//  2. The upload records the old key as PreviousPublicKey and pushes the new
+//     one into the far ends' peer entries. Far ends list the peer under both
+//     keys; the old one keeps the allowed IPs, so nothing changes for traffic
+//     yet, and the rotating agent keeps running the old key.
+//  3. Once every far end has applied that config the rotation is staged and
+//     the rotating agent switches to the new key. Each far end's agent moves
+//     the allowed IPs to the new key as soon as it sees a handshake under it.
+//  4. ReconcileKeyRotations retires the old key once every far end reports a
+//     handshake under the new key, or restores it if that never happens.
+
+// QueueKeyRotation queues a rotate_wireguard_keys command for nodeID. An empty
+// ifaces rotates every interface on the node. If a rotation is already queued
+// or running for the node, that command is returned instead.
+func QueueKeyRotation(nodeID uint, ifaces []string) (*models.NodeCommand, bool, error) {
+	var existing models.NodeCommand
+	err := database.DB.
+		Where("node_id = ? AND kind = ? AND status IN ?", nodeID, models.CmdKindRotateWireGuardKeys,
+			[]models.NodeCommandStatus{models.NodeCommandStatusPending, models.NodeCommandStatusRunning}).
+		First(&existing).Error
+	if err == nil {
+		return &existing, false, nil
+	}
+
+	if ifaces == nil {
+		ifaces = []string{}
+	}
+	payload, _ := json.Marshal(map[string]any{"interfaces": ifaces})
+	cmd := models.NodeCommand{
+		NodeID:  nodeID,
+		Kind:    models.CmdKindRotateWireGuardKeys,
+		Payload: payload,
+		Status:  models.NodeCommandStatusPending,
+	}
+	if err := database.DB.Create(&cmd).Error; err != nil {
+		return nil, false, err
+	}
+	return &cmd, true, nil
+}
+
+// PublicKeyChange returns the interface columns to write when an agent
+// uploads newKey for iface. Replacing an existing key starts a rotation;
+// uploading the key being rotated away from means the agent went back to it.
+func PublicKeyChange(iface models.WireGuardInterface, newKey string, now time.Time) map[string]any {
+	switch {
+	case iface.PublicKey == "":
+		return map[string]any{"public_key": newKey}
+	case iface.PreviousPublicKey != "" && newKey == iface.PreviousPublicKey:
+		return map[string]any{
+			"public_key":          newKey,
+			"previous_public_key": "",
+			"key_rotated_at":      now,
+			"key_staged_at":       nil,
+		}
+	}
+
+	// A second rotation before the first is confirmed still falls back to
+	// the last key that was known to work.
+	previous := iface.PreviousPublicKey
+	if previous == "" {
+		previous = iface.PublicKey
+	}
+	return map[string]any{
+		"public_key":          newKey,
+		"previous_public_key": previous,
+		"key_rotated_at":      now,
+		"key_staged_at":       nil,
+	}
+}
+
+// RotatingKeys maps both keys of every interface with a rotation in flight
+// to that interface, so far ends can list the peer under either.
+func RotatingKeys() (map[string]models.WireGuardInterface, error) {
+	var rotating []models.WireGuardInterface
+	if err := database.DB.Where("previous_public_key <> ''").Find(&rotating).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]models.WireGuardInterface, 2*len(rotating))
+	for _, iface := range rotating {
+		out[iface.PublicKey] = iface
+		out[iface.PreviousPublicKey] = iface
+	}
+	return out, nil
+}
+
+// ActivePublicKey is the key the agent should run on iface: the new key only
+// once the far ends are ready for it.
+func ActivePublicKey(iface models.WireGuardInterface) string {
+	if iface.PreviousPublicKey != "" && iface.KeyStagedAt == nil {
+		return iface.PreviousPublicKey
+	}
+	return iface.PublicKey
+}
+
+// keyRotationStaged reports whether every far end of iface has applied a
+// config generated since the rotation started, i.e. one listing both keys.
+// configs holds the far ends' current configs by node ID.
+func keyRotationStaged(iface models.WireGuardInterface, facing []models.NodePeer, configs map[uint]models.NodeConfig) bool {
+	if iface.KeyRotatedAt == nil {
+		return false
+	}
+	for _, p := range facing {
+		cfg, ok := configs[p.Interface.NodeID]
+		if !ok || cfg.AppliedAt == nil || !cfg.GeneratedAt.After(*iface.KeyRotatedAt) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadFarEndConfigs(facing []models.NodePeer) (map[uint]models.NodeConfig, error) {
+	nodeIDs := make([]uint, 0, len(facing))
+	for _, p := range facing {
+		nodeIDs = append(nodeIDs, p.Interface.NodeID)
+	}
+	var configs []models.NodeConfig
+	if err := database.DB.Where("node_id IN ?", nodeIDs).Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[uint]models.NodeConfig, len(configs))
+	for _, c := range configs {
+		out[c.NodeID] = c
+	}
+	return out, nil
+}
+
+// keyRotationConfirmed reports whether every far end of iface has completed a
+// handshake under its current key since the rotation started.
+func keyRotationConfirmed(iface models.WireGuardInterface, facing []models.NodePeer) bool {
+	if iface.KeyRotatedAt == nil {
+		return false
+	}
+	for _, p := range facing {
+		if p.PeerPublicKey != iface.PublicKey {
+			return false
+		}
+		if p.LastHandshakeAt == nil || !p.LastHandshakeAt.After(*iface.KeyRotatedAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReconcileKeyRotations completes or rolls back in-flight rotations and
+// queues scheduled ones.
+func ReconcileKeyRotations() error {
+	cfg := config.Current()
+	now := time.Now()
+
+	var rotating []models.WireGuardInterface
+	if err := database.DB.Where("previous_public_key <> ''").Find(&rotating).Error; err != nil {
+		return err
+	}
+
+	for _, iface := range rotating {
+		facing, err := LinkPeersFacing(iface)
+		if err != nil {
+			logger.Error("Failed to load far ends for key rotation", "error", err, "interface_id", iface.ID)
+			continue
+		}
+
+		if iface.KeyStagedAt == nil {
+			configs, err := loadFarEndConfigs(facing)
+			if err != nil {
+				logger.Error("Failed to load far end configs for key rotation", "error", err, "interface_id", iface.ID)
+				continue
+			}
+			if keyRotationStaged(iface, facing, configs) {
+				if err := database.DB.Model(&models.WireGuardInterface{}).
+					Where("id = ? AND public_key = ?", iface.ID, iface.PublicKey).
+					UpdateColumn("key_staged_at", now).Error; err != nil {
+					logger.Error("Failed to stage WireGuard key", "error", err, "interface_id", iface.ID)
+					continue
+				}
+				logger.Info("WireGuard key staged on every far end", "node_id", iface.NodeID, "interface", iface.Name)
+			}
+		}
+
+		if keyRotationConfirmed(iface, facing) {
+			if err := database.DB.Model(&models.WireGuardInterface{}).
+				Where("id = ? AND public_key = ?", iface.ID, iface.PublicKey).
+				UpdateColumn("previous_public_key", "").Error; err != nil {
+				logger.Error("Failed to retire old WireGuard key", "error", err, "interface_id", iface.ID)
+				continue
+			}
+			recordKeyRotationEvent(iface, models.EventKindWireGuardKeyRotated,
+				fmt.Sprintf("WireGuard key for %s rotated", iface.Name))
+			continue
+		}
+
+		timeout := time.Duration(cfg.WireGuardKeyRotationTimeoutMinutes) * time.Minute
+		if timeout <= 0 || iface.KeyRotatedAt == nil || now.Sub(*iface.KeyRotatedAt) < timeout {
+			continue
+		}
+		if err := rollBackKeyRotation(iface, facing, now); err != nil {
+			logger.Error("Failed to roll back WireGuard key", "error", err, "interface_id", iface.ID)
+			continue
+		}
+		recordKeyRotationEvent(iface, models.EventKindWireGuardKeyRolledBack,
+			fmt.Sprintf("WireGuard key rotation for %s not confirmed within %s; restored previous key", iface.Name, timeout))
+	}
+
+	if cfg.WireGuardKeyRotationDays > 0 {
+		return queueScheduledKeyRotations(now.Add(-time.Duration(cfg.WireGuardKeyRotationDays) * 24 * time.Hour))
+	}
+	return nil
+}
+
+func rollBackKeyRotation(iface models.WireGuardInterface, facing []models.NodePeer, now time.Time) error {
+	if err := database.DB.Model(&models.WireGuardInterface{}).
+		Where("id = ? AND public_key = ?", iface.ID, iface.PublicKey).
+		Updates(map[string]any{
+			"public_key":          iface.PreviousPublicKey,
+			"previous_public_key": "",
+			"key_rotated_at":      now,
+			"key_staged_at":       nil,
+		}).Error; err != nil {
+		return err
+	}
+	for _, p := range facing {
+		if err := database.DB.Model(&models.NodePeer{}).
+			Where("id = ?", p.ID).
+			Update("peer_public_key", iface.PreviousPublicKey).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueScheduledKeyRotations queues a rotation for every active node with an
+// interface whose key was last changed before cutoff.
+func queueScheduledKeyRotations(cutoff time.Time) error {
+	var nodeIDs []uint
+	if err := database.DB.Model(&models.WireGuardInterface{}).
+		Joins("JOIN nodes ON nodes.id = wire_guard_interfaces.node_id").
+		Where("nodes.status = ?", models.NodeStatusActive).
+		Where("wire_guard_interfaces.public_key <> '' AND wire_guard_interfaces.previous_public_key = ''").
+		Where("COALESCE(wire_guard_interfaces.key_rotated_at, wire_guard_interfaces.created_at) < ?", cutoff).
+		Distinct().
+		Pluck("wire_guard_interfaces.node_id", &nodeIDs).Error; err != nil {
+		return err
+	}
+
+	for _, nodeID := range nodeIDs {
+		// A node that already had a go since the cutoff (e.g. an agent that
+		// doesn't support rotation and failed the command) waits for the
+		// next period rather than being retried every tick.
+		var recent int64
+		if err := database.DB.Model(&models.NodeCommand{}).
+			Where("node_id = ? AND kind = ? AND created_at > ?", nodeID, models.CmdKindRotateWireGuardKeys, cutoff).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			continue
+		}
+
+		cmd, created, err := QueueKeyRotation(nodeID, nil)
+		if err != nil {
+			logger.Error("Failed to queue scheduled key rotation", "error", err, "node_id", nodeID)
+			continue
+		}
+		if created {
+			logger.Info("Queued scheduled WireGuard key rotation", "node_id", nodeID, "command_id", cmd.ID)
+		}
+	}
+	return nil
+}
+
+func recordKeyRotationEvent(iface models.WireGuardInterface, kind models.EventKind, message string) {
+	logger.Info(message, "node_id", iface.NodeID, "interface", iface.Name)
+	event := models.Event{
+		Kind:    kind,
+		NodeID:  &iface.NodeID,
+		Message: message,
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		logger.Error("Failed to create key rotation event", "error", err, "node_id", iface.NodeID)
+	}
+}
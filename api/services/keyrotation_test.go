@@ -0,0 +1,115 @@
+package services
+
+import (
+	"gluon-api/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicKeyChange(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("first upload", func(t *testing.T) {
+		change := PublicKeyChange(models.WireGuardInterface{}, "new", now)
+		assert.Equal(t, map[string]any{"public_key": "new"}, change)
+	})
+
+	t.Run("replacing a key starts a rotation", func(t *testing.T) {
+		change := PublicKeyChange(models.WireGuardInterface{PublicKey: "old"}, "new", now)
+		assert.Equal(t, map[string]any{
+			"public_key":          "new",
+			"previous_public_key": "old",
+			"key_rotated_at":      now,
+			"key_staged_at":       nil,
+		}, change)
+	})
+
+	t.Run("rotating again keeps the last confirmed key", func(t *testing.T) {
+		iface := models.WireGuardInterface{PublicKey: "mid", PreviousPublicKey: "old"}
+		change := PublicKeyChange(iface, "new", now)
+		assert.Equal(t, "old", change["previous_public_key"])
+	})
+
+	t.Run("going back to the previous key ends the rotation", func(t *testing.T) {
+		iface := models.WireGuardInterface{PublicKey: "new", PreviousPublicKey: "old"}
+		change := PublicKeyChange(iface, "old", now)
+		assert.Equal(t, "old", change["public_key"])
+		assert.Equal(t, "", change["previous_public_key"])
+	})
+}
+
+func TestKeyRotationConfirmed(t *testing.T) {
+	rotatedAt := time.Unix(1700000000, 0)
+	before := rotatedAt.Add(-time.Minute)
+	after := rotatedAt.Add(time.Minute)
+	iface := models.WireGuardInterface{PublicKey: "new", PreviousPublicKey: "old", KeyRotatedAt: &rotatedAt}
+
+	tests := []struct {
+		name   string
+		facing []models.NodePeer
+		want   bool
+	}{
+		{
+			name:   "all far ends handshook under the new key",
+			facing: []models.NodePeer{{PeerPublicKey: "new", LastHandshakeAt: &after}},
+			want:   true,
+		},
+		{
+			name:   "far end still on the old key",
+			facing: []models.NodePeer{{PeerPublicKey: "old", LastHandshakeAt: &after}},
+			want:   false,
+		},
+		{
+			name:   "handshake predates the rotation",
+			facing: []models.NodePeer{{PeerPublicKey: "new", LastHandshakeAt: &before}},
+			want:   false,
+		},
+		{
+			name: "one of several far ends missing",
+			facing: []models.NodePeer{
+				{PeerPublicKey: "new", LastHandshakeAt: &after},
+				{PeerPublicKey: "new"},
+			},
+			want: false,
+		},
+		{
+			name:   "no far ends",
+			facing: nil,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, keyRotationConfirmed(iface, tt.facing))
+		})
+	}
+}
+
+func TestActivePublicKey(t *testing.T) {
+	staged := time.Unix(1700000000, 0)
+	assert.Equal(t, "key", ActivePublicKey(models.WireGuardInterface{PublicKey: "key"}))
+	assert.Equal(t, "old", ActivePublicKey(models.WireGuardInterface{PublicKey: "new", PreviousPublicKey: "old"}))
+	assert.Equal(t, "new", ActivePublicKey(models.WireGuardInterface{PublicKey: "new", PreviousPublicKey: "old", KeyStagedAt: &staged}))
+}
+
+func TestKeyRotationStaged(t *testing.T) {
+	rotatedAt := time.Unix(1700000000, 0)
+	iface := models.WireGuardInterface{PublicKey: "new", PreviousPublicKey: "old", KeyRotatedAt: &rotatedAt}
+	facing := []models.NodePeer{
+		{Interface: models.WireGuardInterface{NodeID: 2}},
+		{Interface: models.WireGuardInterface{NodeID: 3}},
+	}
+	applied := rotatedAt.Add(2 * time.Minute)
+	fresh := models.NodeConfig{GeneratedAt: rotatedAt.Add(time.Minute), AppliedAt: &applied}
+	stale := models.NodeConfig{GeneratedAt: rotatedAt.Add(-time.Minute), AppliedAt: &applied}
+	unapplied := models.NodeConfig{GeneratedAt: rotatedAt.Add(time.Minute)}
+
+	assert.True(t, keyRotationStaged(iface, facing, map[uint]models.NodeConfig{2: fresh, 3: fresh}))
+	assert.False(t, keyRotationStaged(iface, facing, map[uint]models.NodeConfig{2: fresh, 3: stale}))
+	assert.False(t, keyRotationStaged(iface, facing, map[uint]models.NodeConfig{2: fresh, 3: unapplied}))
+	assert.False(t, keyRotationStaged(iface, facing, map[uint]models.NodeConfig{2: fresh}))
+	assert.False(t, keyRotationStaged(models.WireGuardInterface{}, facing, map[uint]models.NodeConfig{2: fresh, 3: fresh}))
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/gorm"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var ErrClusterNotFound = errors.New("kubernetes cluster not found")
+
+// clusterRangeBits is the size of the pod and service ranges carved out of
+// the supernets for clusters after the first.
+const clusterRangeBits = 16
+
+// DefaultKubernetesCluster returns the lowest-ID cluster, which nodes not
+// assigned to a cluster belong to.
+func DefaultKubernetesCluster() (*models.KubernetesCluster, error) {
+	var cluster models.KubernetesCluster
+	if err := database.DB.Order("id asc").First(&cluster).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrClusterNotFound
+		}
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// FindKubernetesCluster resolves an admin's cluster selector: an ID, a
+// name, or "" for the default cluster.
+func FindKubernetesCluster(selector string) (*models.KubernetesCluster, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return DefaultKubernetesCluster()
+	}
+	var cluster models.KubernetesCluster
+	q := database.DB.Where("name = ?", selector)
+	if id, err := strconv.ParseUint(selector, 10, 64); err == nil {
+		q = database.DB.Where("id = ?", id)
+	}
+	if err := q.First(&cluster).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %q", ErrClusterNotFound, selector)
+		}
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// KubernetesClusterForNode returns the cluster node is assigned to, or the
+// default cluster.
+func KubernetesClusterForNode(node *models.Node) (*models.KubernetesCluster, error) {
+	if node.KubernetesClusterID == nil {
+		return DefaultKubernetesCluster()
+	}
+	var cluster models.KubernetesCluster
+	if err := database.DB.First(&cluster, *node.KubernetesClusterID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrClusterNotFound
+		}
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// KubernetesClusterByID returns the cluster a record points at; records
+// made before they carried a cluster (nil) belong to the default one.
+func KubernetesClusterByID(id *uint) (*models.KubernetesCluster, error) {
+	if id == nil {
+		return DefaultKubernetesCluster()
+	}
+	var cluster models.KubernetesCluster
+	if err := database.DB.First(&cluster, *id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrClusterNotFound
+		}
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// IsDefaultKubernetesCluster reports whether cluster is the lowest-ID one.
+func IsDefaultKubernetesCluster(cluster *models.KubernetesCluster) bool {
+	var lower int64
+	database.DB.Model(&models.KubernetesCluster{}).Where("id < ?", cluster.ID).Count(&lower)
+	return lower == 0
+}
+
+// KubernetesClusterMembers narrows a node query to the cluster's members.
+func KubernetesClusterMembers(db *gorm.DB, cluster *models.KubernetesCluster) *gorm.DB {
+	return KubernetesClusterScope(db, cluster)
+}
+
+// KubernetesClusterScope narrows a query on any table with a
+// kubernetes_cluster_id column to the cluster's rows, counting rows without
+// one towards the default cluster.
+func KubernetesClusterScope(db *gorm.DB, cluster *models.KubernetesCluster) *gorm.DB {
+	if IsDefaultKubernetesCluster(cluster) {
+		return db.Where("(kubernetes_cluster_id = ? OR kubernetes_cluster_id IS NULL)", cluster.ID)
+	}
+	return db.Where("kubernetes_cluster_id = ?", cluster.ID)
+}
+
+// ClusterKubeconfigPath is where the API finds the cluster's admin
+// kubeconfig: the one its bootstrap hub uploaded, else GLUON_KUBECONFIG
+// for the default cluster.
+func ClusterKubeconfigPath(cluster *models.KubernetesCluster) string {
+	uploaded := uploadedKubeconfigPath(cluster.ID)
+	if _, err := os.Stat(uploaded); err == nil {
+		return uploaded
+	}
+	if IsDefaultKubernetesCluster(cluster) {
+		return kube.KubeconfigPath()
+	}
+	return uploaded
+}
+
+// ClusterKubeconfigAvailable reports whether the API can reach the cluster,
+// so the bootstrap hub knows whether to upload its kubeconfig.
+func ClusterKubeconfigAvailable(cluster *models.KubernetesCluster) bool {
+	_, err := os.Stat(ClusterKubeconfigPath(cluster))
+	return err == nil
+}
+
+func uploadedKubeconfigPath(clusterID uint) string {
+	return filepath.Join(config.Current().KubeconfigsDir, fmt.Sprintf("cluster-%d.conf", clusterID))
+}
+
+// StoreClusterKubeconfig keeps the admin kubeconfig a bootstrap hub
+// reported, readable by the API only.
+func StoreClusterKubeconfig(cluster *models.KubernetesCluster, data []byte) error {
+	if _, err := clientcmd.Load(data); err != nil {
+		return fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	path := uploadedKubeconfigPath(cluster.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RemoveClusterKubeconfig deletes the kubeconfig a deleted cluster's
+// bootstrap hub uploaded.
+func RemoveClusterKubeconfig(cluster *models.KubernetesCluster) {
+	if err := os.Remove(uploadedKubeconfigPath(cluster.ID)); err != nil && !os.IsNotExist(err) {
+		logger.Error("Failed to remove cluster kubeconfig", "error", err, "cluster_id", cluster.ID)
+	}
+}
+
+// KubeClientForCluster returns the API's client for the selected cluster.
+func KubeClientForCluster(selector string) (*kube.Client, error) {
+	cluster, err := FindKubernetesCluster(selector)
+	if err != nil {
+		return nil, err
+	}
+	return KubeClientFor(cluster)
+}
+
+// KubeClientForClusterSynced is KubeClientForCluster once the caches are
+// filled.
+func KubeClientForClusterSynced(ctx context.Context, selector string) (*kube.Client, error) {
+	cluster, err := FindKubernetesCluster(selector)
+	if err != nil {
+		return nil, err
+	}
+	return KubeClientForSynced(ctx, cluster)
+}
+
+// KubeClientFor returns the API's client for cluster.
+func KubeClientFor(cluster *models.KubernetesCluster) (*kube.Client, error) {
+	return kube.SharedFor(ClusterKubeconfigPath(cluster))
+}
+
+// KubeClientForSynced is KubeClientFor once the caches are filled.
+func KubeClientForSynced(ctx context.Context, cluster *models.KubernetesCluster) (*kube.Client, error) {
+	return kube.SharedSyncedFor(ctx, ClusterKubeconfigPath(cluster))
+}
+
+// PickKubernetesClusterCIDRs chooses pod and service ranges for a cluster
+// about to be created. The first cluster gets the configured ones; later
+// clusters get the first free /16 of each supernet that overlaps no IPAM
+// pool and no other cluster.
+func PickKubernetesClusterCIDRs(first bool) (string, string, error) {
+	cfg := config.Current()
+	taken, err := takenKubernetesRanges()
+	if err != nil {
+		return "", "", err
+	}
+
+	var podPreferred, servicePreferred string
+	if first {
+		podPreferred, servicePreferred = cfg.KubernetesPodCIDR, cfg.KubernetesServiceCIDR
+	}
+	pod, err := nextClusterRange(podPreferred, cfg.KubernetesPodSupernet, taken)
+	if err != nil {
+		return "", "", fmt.Errorf("pod range: %w", err)
+	}
+	taken = append(taken, netip.MustParsePrefix(pod))
+	service, err := nextClusterRange(servicePreferred, cfg.KubernetesServiceSupernet, taken)
+	if err != nil {
+		return "", "", fmt.Errorf("service range: %w", err)
+	}
+	return pod, service, nil
+}
+
+// RegisterKubernetesClusterPools records the cluster's pod and service
+// ranges as IPAM pools it owns, taking over an unowned pool with the same
+// range (the default cluster's service pool predates clusters owning any).
+func RegisterKubernetesClusterPools(cluster *models.KubernetesCluster) error {
+	for _, p := range []struct {
+		purpose models.IPPoolPurpose
+		cidr    string
+	}{
+		{models.IPPoolPurposeKubernetesPods, cluster.PodCIDR},
+		{models.IPPoolPurposeKubernetesServices, cluster.ServiceCIDR},
+	} {
+		if strings.TrimSpace(p.cidr) == "" {
+			continue
+		}
+		var pool models.IPPool
+		err := database.DB.Where("cidr = ?", p.cidr).First(&pool).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			pool = models.IPPool{Kind: models.IPPoolKindKubernetes, Purpose: p.purpose, CIDR: p.cidr, KubernetesClusterID: &cluster.ID}
+			if err := database.DB.Create(&pool).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case pool.KubernetesClusterID == nil && pool.Kind == models.IPPoolKindKubernetes:
+			if err := database.DB.Model(&pool).Update("kubernetes_cluster_id", cluster.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// takenKubernetesRanges lists every range a new cluster must stay clear
+// of: all IPAM pools and the ranges of existing clusters.
+func takenKubernetesRanges() ([]netip.Prefix, error) {
+	var pools []models.IPPool
+	if err := database.DB.Find(&pools).Error; err != nil {
+		return nil, err
+	}
+	var clusters []models.KubernetesCluster
+	if err := database.DB.Find(&clusters).Error; err != nil {
+		return nil, err
+	}
+
+	var taken []netip.Prefix
+	add := func(cidr string) {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(cidr)); err == nil {
+			taken = append(taken, p.Masked())
+		}
+	}
+	for _, pool := range pools {
+		// The default service pool is created at startup before any
+		// cluster exists; the first cluster is meant to take it.
+		if pool.Kind == models.IPPoolKindKubernetes && pool.KubernetesClusterID == nil {
+			continue
+		}
+		add(pool.CIDR)
+	}
+	for _, c := range clusters {
+		add(c.PodCIDR)
+		add(c.ServiceCIDR)
+	}
+	return taken, nil
+}
+
+// nextClusterRange returns preferred if it is free, else the first free
+// /16 of supernet.
+func nextClusterRange(preferred string, supernet string, taken []netip.Prefix) (string, error) {
+	free := func(p netip.Prefix) bool {
+		for _, t := range taken {
+			if t.Overlaps(p) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if preferred != "" {
+		p, err := netip.ParsePrefix(preferred)
+		if err != nil {
+			return "", fmt.Errorf("invalid range %q: %w", preferred, err)
+		}
+		if free(p.Masked()) {
+			return p.Masked().String(), nil
+		}
+	}
+
+	super, err := netip.ParsePrefix(supernet)
+	if err != nil {
+		return "", fmt.Errorf("invalid supernet %q: %w", supernet, err)
+	}
+	super = super.Masked()
+	if !super.Addr().Is4() {
+		return "", fmt.Errorf("supernet %s is not IPv4", supernet)
+	}
+	if super.Bits() > clusterRangeBits {
+		if free(super) {
+			return super.String(), nil
+		}
+		return "", fmt.Errorf("supernet %s is exhausted", supernet)
+	}
+
+	step := uint32(1) << (32 - clusterRangeBits)
+	count := uint32(1) << (clusterRangeBits - super.Bits())
+	base := super.Addr().As4()
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	for i := uint32(0); i < count; i++ {
+		v := start + i*step
+		addr := netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+		candidate := netip.PrefixFrom(addr, clusterRangeBits)
+		if free(candidate) {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("supernet %s is exhausted", supernet)
+}
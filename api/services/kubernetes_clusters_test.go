@@ -0,0 +1,31 @@
+package services
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextClusterRange(t *testing.T) {
+	taken := []netip.Prefix{
+		netip.MustParsePrefix("10.244.0.0/16"),
+		netip.MustParsePrefix("10.240.0.0/16"),
+		netip.MustParsePrefix("10.241.8.0/24"),
+	}
+
+	got, err := nextClusterRange("10.250.0.0/16", "10.240.0.0/12", taken)
+	require.NoError(t, err)
+	assert.Equal(t, "10.250.0.0/16", got, "free preferred range is kept")
+
+	got, err = nextClusterRange("10.244.0.0/16", "10.240.0.0/12", taken)
+	require.NoError(t, err)
+	assert.Equal(t, "10.242.0.0/16", got, "taken preferred range falls back to the first free /16")
+
+	_, err = nextClusterRange("", "10.240.0.0/15", taken)
+	assert.ErrorContains(t, err, "exhausted")
+
+	_, err = nextClusterRange("", "not-a-cidr", taken)
+	assert.ErrorContains(t, err, "invalid supernet")
+}
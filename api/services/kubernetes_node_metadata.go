@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gluon-api/database"
+	"gluon-api/models"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Labels Gluon derives from node metadata. Admin-set labels may not use
+// the gluon.io/ prefix, so these always reflect the database.
+const (
+	GluonLabelPrefix   = "gluon.io/"
+	LabelGluonNodeID   = "gluon.io/node-id"
+	LabelGluonRole     = "gluon.io/role"
+	LabelGluonProvider = "gluon.io/provider"
+	LabelGluonHub      = "gluon.io/hub-number"
+
+	// LabelGluonHubAffinity names the hub a worker is closest to, for
+	// workloads that want to stay near one hub's region.
+	LabelGluonHubAffinity = "gluon.io/hub-affinity"
+)
+
+// KubernetesNodeMetadata is the label and taint set a node's Kubernetes
+// object should carry.
+type KubernetesNodeMetadata struct {
+	NodeID   uint                     `json:"node_id"`
+	Hostname string                   `json:"hostname"`
+	Labels   map[string]string        `json:"labels"`
+	Taints   []models.KubernetesTaint `json:"taints"`
+}
+
+// DesiredKubernetesNodeMetadata derives the labels and taints for node:
+// its role, provider, hub number or hub affinity among hubs, and region,
+// then the admin's own labels and taints on top.
+func DesiredKubernetesNodeMetadata(node *models.Node, hubs []models.Node) KubernetesNodeMetadata {
+	labels := map[string]string{
+		LabelGluonNodeID: strconv.FormatUint(uint64(node.ID), 10),
+		LabelGluonRole:   string(node.Role),
+	}
+	if v := labelValue(node.Provider); v != "" {
+		labels[LabelGluonProvider] = v
+	}
+	if node.Role == models.NodeRoleHub && node.HubNumber > 0 {
+		labels[LabelGluonHub] = strconv.Itoa(node.HubNumber)
+	}
+	if node.Role == models.NodeRoleWorker {
+		if n := workerHubAffinity(node, hubs); n > 0 {
+			labels[LabelGluonHubAffinity] = strconv.Itoa(n)
+		}
+	}
+	if v := labelValue(node.Region); v != "" {
+		labels[corev1.LabelTopologyRegion] = v
+	}
+
+	for k, v := range NodeKubernetesLabels(node) {
+		if _, managed := labels[k]; managed || strings.HasPrefix(k, GluonLabelPrefix) {
+			continue
+		}
+		labels[k] = v
+	}
+
+	return KubernetesNodeMetadata{
+		NodeID:   node.ID,
+		Hostname: node.Hostname,
+		Labels:   labels,
+		Taints:   NodeKubernetesTaints(node),
+	}
+}
+
+// ListDesiredKubernetesNodeMetadata returns the desired metadata of every
+// node that has joined cluster.
+func ListDesiredKubernetesNodeMetadata(cluster *models.KubernetesCluster) ([]KubernetesNodeMetadata, error) {
+	var nodes []models.Node
+	if err := KubernetesClusterMembers(database.DB, cluster).
+		Where("k8s_state IN ? AND status <> ?", []string{"cluster_initialized", "joined_control_plane", "joined_worker"}, models.NodeStatusDecommissioned).
+		Order("id asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	hubs, err := KubernetesAffinityHubs()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]KubernetesNodeMetadata, 0, len(nodes))
+	for i := range nodes {
+		out = append(out, DesiredKubernetesNodeMetadata(&nodes[i], hubs))
+	}
+	return out, nil
+}
+
+// KubernetesAffinityHubs returns the hubs a worker's hub affinity is
+// chosen from.
+func KubernetesAffinityHubs() ([]models.Node, error) {
+	var hubs []models.Node
+	err := database.DB.
+		Where("role = ? AND hub_number > 0 AND status <> ?", models.NodeRoleHub, models.NodeStatusDecommissioned).
+		Order("hub_number asc").Find(&hubs).Error
+	return hubs, err
+}
+
+// workerHubAffinity picks the hub sharing the worker's region, and failing
+// that its provider; a hub sharing both wins, then the lowest hub number.
+// It returns 0 when no hub has either in common.
+func workerHubAffinity(worker *models.Node, hubs []models.Node) int {
+	region, provider := labelValue(worker.Region), labelValue(worker.Provider)
+	best, bestScore := 0, 0
+	for _, hub := range hubs {
+		if hub.HubNumber <= 0 {
+			continue
+		}
+		score := 0
+		if region != "" && labelValue(hub.Region) == region {
+			score += 2
+		}
+		if provider != "" && labelValue(hub.Provider) == provider {
+			score++
+		}
+		if score > bestScore || (score == bestScore && score > 0 && hub.HubNumber < best) {
+			best, bestScore = hub.HubNumber, score
+		}
+	}
+	return best
+}
+
+func NodeKubernetesLabels(node *models.Node) map[string]string {
+	labels := map[string]string{}
+	if len(node.K8sLabels) > 0 {
+		_ = json.Unmarshal(node.K8sLabels, &labels)
+	}
+	return labels
+}
+
+func NodeKubernetesTaints(node *models.Node) []models.KubernetesTaint {
+	taints := []models.KubernetesTaint{}
+	if len(node.K8sTaints) > 0 {
+		_ = json.Unmarshal(node.K8sTaints, &taints)
+	}
+	return taints
+}
+
+// ValidateKubernetesNodeLabels checks admin-set labels against Kubernetes'
+// syntax. Keys Gluon derives itself are refused.
+func ValidateKubernetesNodeLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.HasPrefix(k, GluonLabelPrefix) {
+			return fmt.Errorf("label %q: the %s prefix is reserved for labels derived from node metadata", k, GluonLabelPrefix)
+		}
+		if k == corev1.LabelTopologyRegion {
+			return fmt.Errorf("label %q: set the node's region instead", k)
+		}
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("label key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(labels[k]); len(errs) > 0 {
+			return fmt.Errorf("label %q value %q: %s", k, labels[k], strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// ValidateKubernetesTaints checks admin-set taints; each key and effect
+// pair may appear once, as in Kubernetes.
+func ValidateKubernetesTaints(taints []models.KubernetesTaint) error {
+	seen := map[string]bool{}
+	for _, t := range taints {
+		if errs := validation.IsQualifiedName(t.Key); len(errs) > 0 {
+			return fmt.Errorf("taint key %q: %s", t.Key, strings.Join(errs, "; "))
+		}
+		if t.Value != "" {
+			if errs := validation.IsValidLabelValue(t.Value); len(errs) > 0 {
+				return fmt.Errorf("taint %q value %q: %s", t.Key, t.Value, strings.Join(errs, "; "))
+			}
+		}
+		switch corev1.TaintEffect(t.Effect) {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("taint %q: effect must be NoSchedule, PreferNoSchedule or NoExecute", t.Key)
+		}
+		id := t.Key + ":" + t.Effect
+		if seen[id] {
+			return fmt.Errorf("taint %q with effect %s is listed twice", t.Key, t.Effect)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// labelValue turns free-form metadata such as a provider name into a valid
+// label value, or "" if nothing usable is left.
+func labelValue(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	v := b.String()
+	if len(v) > validation.LabelValueMaxLength {
+		v = v[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(v, "-._")
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+
+	"gluon-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+func TestDesiredKubernetesNodeMetadata(t *testing.T) {
+	hub := &models.Node{
+		ID:        3,
+		Hostname:  "hub-2",
+		Role:      models.NodeRoleHub,
+		HubNumber: 2,
+		Provider:  "Hetzner Cloud",
+		Region:    "eu-central",
+		K8sLabels: datatypes.JSON(`{"tier":"edge","gluon.io/role":"worker"}`),
+		K8sTaints: datatypes.JSON(`[{"key":"dedicated","value":"ingress","effect":"NoSchedule"}]`),
+	}
+
+	md := DesiredKubernetesNodeMetadata(hub, []models.Node{*hub})
+	assert.Equal(t, map[string]string{
+		LabelGluonNodeID:                "3",
+		LabelGluonRole:                  "hub",
+		LabelGluonProvider:              "hetzner-cloud",
+		LabelGluonHub:                   "2",
+		"topology.kubernetes.io/region": "eu-central",
+		"tier":                          "edge",
+	}, md.Labels)
+	assert.Equal(t, []models.KubernetesTaint{{Key: "dedicated", Value: "ingress", Effect: "NoSchedule"}}, md.Taints)
+
+	worker := &models.Node{ID: 7, Hostname: "w1", Role: models.NodeRoleWorker, HubNumber: 1}
+	md = DesiredKubernetesNodeMetadata(worker, []models.Node{*hub})
+	assert.Equal(t, map[string]string{LabelGluonNodeID: "7", LabelGluonRole: "worker"}, md.Labels)
+	assert.Empty(t, md.Taints)
+
+	worker.Region = "EU-Central"
+	md = DesiredKubernetesNodeMetadata(worker, []models.Node{*hub})
+	assert.Equal(t, "2", md.Labels[LabelGluonHubAffinity])
+}
+
+func TestWorkerHubAffinity(t *testing.T) {
+	hubs := []models.Node{
+		{HubNumber: 1, Region: "us-east", Provider: "aws"},
+		{HubNumber: 2, Region: "eu-central", Provider: "hetzner"},
+		{HubNumber: 3, Region: "eu-central", Provider: "aws"},
+		{HubNumber: 4, Region: "ap-south", Provider: "hetzner"},
+	}
+	worker := func(region, provider string) *models.Node {
+		return &models.Node{Role: models.NodeRoleWorker, Region: region, Provider: provider}
+	}
+
+	assert.Equal(t, 3, workerHubAffinity(worker("eu-central", "AWS"), hubs), "region and provider")
+	assert.Equal(t, 2, workerHubAffinity(worker("eu-central", "ovh"), hubs), "region, lowest hub")
+	assert.Equal(t, 2, workerHubAffinity(worker("sa-east", "hetzner"), hubs), "provider, lowest hub")
+	assert.Equal(t, 0, workerHubAffinity(worker("sa-east", "ovh"), hubs))
+	assert.Equal(t, 0, workerHubAffinity(worker("", ""), hubs))
+}
+
+func TestValidateKubernetesNodeLabels(t *testing.T) {
+	assert.NoError(t, ValidateKubernetesNodeLabels(map[string]string{"tier": "edge", "example.com/disk": "ssd", "empty": ""}))
+	assert.Error(t, ValidateKubernetesNodeLabels(map[string]string{"gluon.io/role": "hub"}))
+	assert.Error(t, ValidateKubernetesNodeLabels(map[string]string{"topology.kubernetes.io/region": "eu"}))
+	assert.Error(t, ValidateKubernetesNodeLabels(map[string]string{"bad key": "x"}))
+	assert.Error(t, ValidateKubernetesNodeLabels(map[string]string{"tier": "not valid!"}))
+}
+
+func TestValidateKubernetesTaints(t *testing.T) {
+	assert.NoError(t, ValidateKubernetesTaints([]models.KubernetesTaint{
+		{Key: "dedicated", Value: "db", Effect: "NoSchedule"},
+		{Key: "dedicated", Effect: "NoExecute"},
+	}))
+	assert.Error(t, ValidateKubernetesTaints([]models.KubernetesTaint{{Key: "dedicated", Effect: "Sometimes"}}))
+	assert.Error(t, ValidateKubernetesTaints([]models.KubernetesTaint{
+		{Key: "dedicated", Effect: "NoSchedule"},
+		{Key: "dedicated", Value: "other", Effect: "NoSchedule"},
+	}))
+}
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "hetzner-cloud", labelValue(" Hetzner Cloud "))
+	assert.Equal(t, "aws", labelValue("AWS!"))
+	assert.Equal(t, "", labelValue("!!"))
+}
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// drainTimeout bounds how long evictions may stay blocked (usually by a
+	// PodDisruptionBudget) before the upgrade stops for an operator to look.
+	drainTimeout = 15 * time.Minute
+	// verifyTimeout bounds how long a node may take to come back Ready on
+	// the new kubelet after the agent reports the upgrade done.
+	verifyTimeout = 10 * time.Minute
+)
+
+var (
+	ErrUpgradeInProgress = errors.New("a Kubernetes upgrade is already running")
+	ErrUpgradeNotFound   = errors.New("upgrade not found")
+)
+
+var kubeVersionRe = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?$`)
+
+// KubeVersion is a parsed vMAJOR.MINOR[.PATCH] Kubernetes version. Patch is
+// -1 when only the minor was given.
+type KubeVersion struct {
+	Major, Minor, Patch int
+}
+
+func ParseKubeVersion(v string) (KubeVersion, error) {
+	m := kubeVersionRe.FindStringSubmatch(v)
+	if m == nil {
+		return KubeVersion{}, fmt.Errorf("invalid Kubernetes version %q (want vX.Y or vX.Y.Z)", v)
+	}
+	ver := KubeVersion{Patch: -1}
+	ver.Major, _ = strconv.Atoi(m[1])
+	ver.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		ver.Patch, _ = strconv.Atoi(m[3])
+	}
+	return ver, nil
+}
+
+func (v KubeVersion) String() string {
+	if v.Patch < 0 {
+		return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+	}
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// ValidateUpgradeVersion checks that to is reachable from the cluster's
+// current version: kubeadm only upgrades one minor release at a time and
+// never downgrades. It returns the normalised target.
+func ValidateUpgradeVersion(from string, to string) (string, error) {
+	target, err := ParseKubeVersion(to)
+	if err != nil {
+		return "", err
+	}
+	current, err := ParseKubeVersion(from)
+	if err != nil {
+		return "", fmt.Errorf("current cluster version: %w", err)
+	}
+	if target.Major != current.Major {
+		return "", fmt.Errorf("cannot upgrade across major versions (%s to %s)", current, target)
+	}
+	switch {
+	case target.Minor == current.Minor+1:
+	case target.Minor == current.Minor:
+		if target.Patch < 0 || target.Patch <= current.Patch {
+			return "", fmt.Errorf("cluster is already on %s", current)
+		}
+	case target.Minor < current.Minor:
+		return "", fmt.Errorf("cannot downgrade from %s to %s", current, target)
+	default:
+		return "", fmt.Errorf("can only upgrade one minor version at a time (%s to v%d.%d next)", current, current.Major, current.Minor+1)
+	}
+	return target.String(), nil
+}
+
+// StartKubernetesUpgrade plans a rolling upgrade of every node joined to
+// cluster to version and leaves it for ReconcileKubernetesUpgrade to drive.
+// kc is the cluster's client.
+func StartKubernetesUpgrade(kc *kube.Client, cluster *models.KubernetesCluster, version string, actorID *uint) (*models.KubernetesUpgrade, error) {
+	if cluster.InitializedAt == nil {
+		return nil, errors.New("cluster is not initialized")
+	}
+	if running, err := upgradeRunning(cluster); err != nil {
+		return nil, err
+	} else if running {
+		return nil, ErrUpgradeInProgress
+	}
+
+	target, err := ValidateUpgradeVersion(cluster.KubernetesVersion, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []models.Node
+	if err := KubernetesClusterMembers(database.DB, cluster).
+		Where("k8s_state IN ?", []string{"cluster_initialized", "joined_control_plane", "joined_worker"}).
+		Order("id asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no joined nodes to upgrade")
+	}
+
+	up := models.KubernetesUpgrade{
+		KubernetesClusterID: &cluster.ID,
+		FromVersion:         cluster.KubernetesVersion,
+		ToVersion:           target,
+		Status:              models.UpgradeStatusRunning,
+		StartedByID:         actorID,
+	}
+	for i, node := range upgradeOrder(nodes, cluster.BootstrapNodeID) {
+		kn, err := kc.FindNode(node.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		controlPlane := node.K8sState != "joined_worker"
+		up.Nodes = append(up.Nodes, models.KubernetesUpgradeNode{
+			NodeID:       node.ID,
+			NodeName:     kn.Name,
+			Position:     i,
+			ControlPlane: controlPlane,
+			Apply:        i == 0 && controlPlane,
+			State:        models.UpgradeNodePending,
+		})
+	}
+	if !up.Nodes[0].Apply {
+		return nil, errors.New("no joined control-plane node to run kubeadm upgrade apply")
+	}
+
+	if err := database.DB.Create(&up).Error; err != nil {
+		return nil, err
+	}
+	logger.Info("Kubernetes upgrade started", "upgrade_id", up.ID, "cluster", cluster.Name, "from", up.FromVersion, "to", up.ToVersion, "nodes", len(up.Nodes))
+	return &up, nil
+}
+
+// upgradeRunning reports whether cluster has an upgrade running already.
+func upgradeRunning(cluster *models.KubernetesCluster) (bool, error) {
+	var running int64
+	err := KubernetesClusterScope(database.DB.Model(&models.KubernetesUpgrade{}), cluster).
+		Where("status = ?", models.UpgradeStatusRunning).Count(&running).Error
+	return running > 0, err
+}
+
+// runningUpgradeStep returns the running upgrade waiting on nodeID's agent
+// and the node's step in it.
+func runningUpgradeStep(nodeID uint) (*models.KubernetesUpgrade, *models.KubernetesUpgradeNode, bool) {
+	var step models.KubernetesUpgradeNode
+	if err := database.DB.
+		Joins("JOIN kubernetes_upgrades ON kubernetes_upgrades.id = kubernetes_upgrade_nodes.upgrade_id").
+		Where("kubernetes_upgrades.status = ? AND kubernetes_upgrade_nodes.node_id = ? AND kubernetes_upgrade_nodes.state = ?",
+			models.UpgradeStatusRunning, nodeID, models.UpgradeNodeUpgrading).
+		First(&step).Error; err != nil {
+		return nil, nil, false
+	}
+	var up models.KubernetesUpgrade
+	if err := database.DB.First(&up, step.UpgradeID).Error; err != nil {
+		return nil, nil, false
+	}
+	return &up, &step, true
+}
+
+// upgradeOrder puts control planes first, the bootstrap hub leading since
+// it is the one kubeadm initialised, then workers, each group by id.
+func upgradeOrder(nodes []models.Node, bootstrapID *uint) []models.Node {
+	rank := func(n models.Node) int {
+		switch {
+		case bootstrapID != nil && n.ID == *bootstrapID && n.K8sState != "joined_worker":
+			return 0
+		case n.K8sState != "joined_worker":
+			return 1
+		default:
+			return 2
+		}
+	}
+	ordered := append([]models.Node(nil), nodes...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ri, rj := rank(ordered[i]), rank(ordered[j]); ri != rj {
+			return ri < rj
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+	return ordered
+}
+
+// UpgradeTaskFor returns the step a node's agent should run now, if the
+// running upgrade is waiting on it, along with the version to install.
+func UpgradeTaskFor(nodeID uint) (*models.KubernetesUpgradeNode, string, bool) {
+	up, step, ok := runningUpgradeStep(nodeID)
+	if !ok {
+		return nil, "", false
+	}
+	return step, up.TargetVersion(), true
+}
+
+// RecordUpgradeReport stores an agent's progress on its upgrade step:
+// "upgrading" carries a progress message, "upgraded" the version installed
+// and "upgrade_failed" the error.
+func RecordUpgradeReport(nodeID uint, state string, message string, version string) error {
+	up, step, ok := runningUpgradeStep(nodeID)
+	if !ok {
+		return ErrUpgradeNotFound
+	}
+
+	switch state {
+	case "upgrading":
+		return database.DB.Model(&step).Update("message", message).Error
+	case "upgraded":
+		if version == "" {
+			return errors.New("upgraded report without a version")
+		}
+		now := time.Now()
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(step).Updates(map[string]any{
+				"state":       models.UpgradeNodeVerifying,
+				"version":     version,
+				"message":     "waiting for the node to become Ready",
+				"finished_at": &now,
+			}).Error; err != nil {
+				return err
+			}
+			if step.Apply && up.ResolvedVersion == "" {
+				if err := tx.Model(up).Update("resolved_version", version).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&models.Node{}).Where("id = ?", nodeID).Update("k8s_version", version).Error
+		})
+		if err == nil {
+			logger.Info("Node reported Kubernetes upgrade", "upgrade_id", up.ID, "node", step.NodeName, "version", version)
+		}
+		return err
+	case "upgrade_failed":
+		failUpgrade(up, step, "agent: "+message)
+		return nil
+	default:
+		return fmt.Errorf("unknown upgrade state %q", state)
+	}
+}
+
+// RetryKubernetesUpgrade resumes a failed upgrade from the node that failed.
+func RetryKubernetesUpgrade(id uint) (*models.KubernetesUpgrade, error) {
+	var up models.KubernetesUpgrade
+	if err := database.DB.First(&up, id).Error; err != nil {
+		return nil, ErrUpgradeNotFound
+	}
+	if up.Status != models.UpgradeStatusFailed {
+		return nil, fmt.Errorf("upgrade is %s, only failed upgrades can be retried", up.Status)
+	}
+	cluster, err := KubernetesClusterByID(up.KubernetesClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if running, err := upgradeRunning(cluster); err != nil {
+		return nil, err
+	} else if running {
+		return nil, ErrUpgradeInProgress
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.KubernetesUpgradeNode{}).
+			Where("upgrade_id = ? AND state = ?", up.ID, models.UpgradeNodeFailed).
+			Updates(map[string]any{"state": models.UpgradeNodePending, "message": "", "started_at": nil, "finished_at": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&up).Updates(map[string]any{"status": models.UpgradeStatusRunning, "message": "", "finished_at": nil}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadUpgrade(up.ID)
+}
+
+// CancelKubernetesUpgrade stops a running upgrade. A node that is being
+// drained is uncordoned again; one the agent is already upgrading is left
+// cordoned, since its kubelet may be mid-restart.
+func CancelKubernetesUpgrade(ctx context.Context, id uint) (*models.KubernetesUpgrade, error) {
+	up, err := loadUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	if up.Status != models.UpgradeStatusRunning && up.Status != models.UpgradeStatusFailed {
+		return nil, fmt.Errorf("upgrade is already %s", up.Status)
+	}
+
+	var kc *kube.Client
+	if cluster, err := KubernetesClusterByID(up.KubernetesClusterID); err == nil {
+		kc, _ = KubeClientFor(cluster)
+	}
+	for _, step := range up.Nodes {
+		if step.State != models.UpgradeNodeDraining {
+			continue
+		}
+		if kc != nil {
+			if err := kc.SetUnschedulable(ctx, step.NodeName, false); err != nil {
+				logger.Error("Failed to uncordon node after cancelling upgrade", "error", err, "node", step.NodeName)
+			}
+		}
+		database.DB.Model(&models.KubernetesUpgradeNode{}).Where("id = ?", step.ID).
+			Updates(map[string]any{"state": models.UpgradeNodePending, "message": "cancelled"})
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&models.KubernetesUpgrade{}).Where("id = ?", up.ID).
+		Updates(map[string]any{"status": models.UpgradeStatusCancelled, "finished_at": &now}).Error; err != nil {
+		return nil, err
+	}
+	return loadUpgrade(up.ID)
+}
+
+func loadUpgrade(id uint) (*models.KubernetesUpgrade, error) {
+	var up models.KubernetesUpgrade
+	err := database.DB.Preload("Nodes", func(db *gorm.DB) *gorm.DB { return db.Order("position asc") }).First(&up, id).Error
+	if err != nil {
+		return nil, ErrUpgradeNotFound
+	}
+	return &up, nil
+}
+
+// ReconcileKubernetesUpgrade moves each cluster's running upgrade along by
+// at most one step per call. The next node is only started once every node
+// is Ready and etcd is healthy on every control plane.
+func ReconcileKubernetesUpgrade() error {
+	var running []models.KubernetesUpgrade
+	if err := database.DB.Where("status = ?", models.UpgradeStatusRunning).Order("id asc").Find(&running).Error; err != nil {
+		return err
+	}
+	for i := range running {
+		if err := reconcileUpgrade(&running[i]); err != nil {
+			logger.Error("Failed to reconcile Kubernetes upgrade", "error", err, "upgrade_id", running[i].ID)
+		}
+	}
+	return nil
+}
+
+func reconcileUpgrade(up *models.KubernetesUpgrade) error {
+	cluster, err := KubernetesClusterByID(up.KubernetesClusterID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	kc, err := KubeClientForSynced(ctx, cluster)
+	if errors.Is(err, kube.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded, err := loadUpgrade(up.ID)
+	if err != nil {
+		return err
+	}
+	advanceUpgrade(ctx, kc, loaded)
+	return nil
+}
+
+func advanceUpgrade(ctx context.Context, kc *kube.Client, up *models.KubernetesUpgrade) {
+	var step *models.KubernetesUpgradeNode
+	for i := range up.Nodes {
+		if up.Nodes[i].State != models.UpgradeNodeDone {
+			step = &up.Nodes[i]
+			break
+		}
+	}
+	if step == nil {
+		completeUpgrade(up)
+		return
+	}
+
+	switch step.State {
+	case models.UpgradeNodePending:
+		if ok, reason := clusterHealthy(kc); !ok {
+			setUpgradeMessage(up, "waiting before "+step.NodeName+": "+reason)
+			return
+		}
+		now := time.Now()
+		step.State = models.UpgradeNodeDraining
+		step.StartedAt = &now
+		database.DB.Model(step).Updates(map[string]any{"state": step.State, "started_at": step.StartedAt, "message": ""})
+		setUpgradeMessage(up, "upgrading "+step.NodeName)
+		fallthrough
+
+	case models.UpgradeNodeDraining:
+		remaining, err := kc.Drain(ctx, step.NodeName)
+		if err != nil {
+			failUpgrade(up, step, "drain: "+err.Error())
+			return
+		}
+		if remaining > 0 {
+			if step.StartedAt != nil && time.Since(*step.StartedAt) > drainTimeout {
+				failUpgrade(up, step, fmt.Sprintf("drain timed out with %d pods remaining", remaining))
+				return
+			}
+			database.DB.Model(step).Update("message", fmt.Sprintf("draining, %d pods remaining", remaining))
+			return
+		}
+		database.DB.Model(step).Updates(map[string]any{"state": models.UpgradeNodeUpgrading, "message": "waiting for the agent"})
+		logger.Info("Node drained for Kubernetes upgrade", "upgrade_id", up.ID, "node", step.NodeName)
+
+	case models.UpgradeNodeUpgrading:
+		// The agent picks the step up from its task and reports back.
+
+	case models.UpgradeNodeVerifying:
+		node, err := kc.Nodes.Get(step.NodeName)
+		if err == nil && kube.NodeReady(node) && node.Status.NodeInfo.KubeletVersion == step.Version {
+			if err := kc.SetUnschedulable(ctx, step.NodeName, false); err != nil {
+				database.DB.Model(step).Update("message", "uncordon: "+err.Error())
+				return
+			}
+			now := time.Now()
+			database.DB.Model(step).Updates(map[string]any{"state": models.UpgradeNodeDone, "message": "", "finished_at": &now})
+			logger.Info("Node upgraded", "upgrade_id", up.ID, "node", step.NodeName, "version", step.Version)
+			return
+		}
+		if step.FinishedAt != nil && time.Since(*step.FinishedAt) > verifyTimeout {
+			reason := "not Ready"
+			if err == nil && node.Status.NodeInfo.KubeletVersion != step.Version {
+				reason = "kubelet reports " + node.Status.NodeInfo.KubeletVersion
+			}
+			failUpgrade(up, step, "node did not come back on "+step.Version+": "+reason)
+		}
+	}
+}
+
+// clusterHealthy is the gate in front of every node: nothing is drained
+// while another node is NotReady or etcd has lost a member.
+func clusterHealthy(kc *kube.Client) (bool, string) {
+	nodes, err := kc.Nodes.List(labels.Everything())
+	if err != nil {
+		return false, err.Error()
+	}
+	for _, node := range nodes {
+		if !kube.NodeReady(node) {
+			return false, "node " + node.Name + " is not Ready"
+		}
+	}
+	return kc.EtcdHealthy()
+}
+
+func completeUpgrade(up *models.KubernetesUpgrade) {
+	version := up.TargetVersion()
+	now := time.Now()
+	cluster, err := KubernetesClusterByID(up.KubernetesClusterID)
+	if err != nil {
+		logger.Error("Failed to complete Kubernetes upgrade", "error", err, "upgrade_id", up.ID)
+		return
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(up).Updates(map[string]any{
+			"status":      models.UpgradeStatusSucceeded,
+			"message":     "",
+			"finished_at": &now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(cluster).Update("kubernetes_version", version).Error
+	})
+	if err != nil {
+		logger.Error("Failed to complete Kubernetes upgrade", "error", err, "upgrade_id", up.ID)
+		return
+	}
+	logger.Info("Kubernetes upgrade finished", "upgrade_id", up.ID, "version", version)
+}
+
+func failUpgrade(up *models.KubernetesUpgrade, step *models.KubernetesUpgradeNode, msg string) {
+	now := time.Now()
+	database.DB.Model(step).Updates(map[string]any{"state": models.UpgradeNodeFailed, "message": msg, "finished_at": &now})
+	database.DB.Model(up).Updates(map[string]any{
+		"status":      models.UpgradeStatusFailed,
+		"message":     step.NodeName + ": " + msg,
+		"finished_at": &now,
+	})
+	logger.Error("Kubernetes upgrade failed", "upgrade_id", up.ID, "node", step.NodeName, "error", msg)
+}
+
+func setUpgradeMessage(up *models.KubernetesUpgrade, msg string) {
+	if up.Message == msg {
+		return
+	}
+	up.Message = msg
+	database.DB.Model(up).Update("message", msg)
+}
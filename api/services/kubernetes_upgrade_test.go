@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+
+	"gluon-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUpgradeVersion(t *testing.T) {
+	ok := []struct{ from, to, want string }{
+		{"v1.29", "v1.30", "v1.30"},
+		{"v1.29", "1.30.2", "v1.30.2"},
+		{"v1.29.3", "v1.30", "v1.30"},
+		{"v1.29", "v1.29.4", "v1.29.4"},
+		{"v1.29.3", "v1.29.4", "v1.29.4"},
+	}
+	for _, tc := range ok {
+		got, err := ValidateUpgradeVersion(tc.from, tc.to)
+		require.NoError(t, err, "%s -> %s", tc.from, tc.to)
+		assert.Equal(t, tc.want, got)
+	}
+
+	bad := []struct{ from, to, msg string }{
+		{"v1.29", "latest", "invalid Kubernetes version"},
+		{"v1.29", "v1.31", "one minor version at a time"},
+		{"v1.29", "v1.28", "cannot downgrade"},
+		{"v1.29", "v2.0", "major versions"},
+		{"v1.29", "v1.29", "already on"},
+		{"v1.29.4", "v1.29.4", "already on"},
+		{"v1.29.4", "v1.29.2", "already on"},
+	}
+	for _, tc := range bad {
+		_, err := ValidateUpgradeVersion(tc.from, tc.to)
+		assert.ErrorContains(t, err, tc.msg, "%s -> %s", tc.from, tc.to)
+	}
+}
+
+func TestUpgradeOrder(t *testing.T) {
+	bootstrap := uint(3)
+	nodes := []models.Node{
+		{ID: 1, K8sState: "joined_worker"},
+		{ID: 2, K8sState: "joined_control_plane"},
+		{ID: 3, K8sState: "cluster_initialized"},
+		{ID: 4, K8sState: "joined_worker"},
+		{ID: 5, K8sState: "joined_control_plane"},
+	}
+
+	var ids []uint
+	for _, n := range upgradeOrder(nodes, &bootstrap) {
+		ids = append(ids, n.ID)
+	}
+	assert.Equal(t, []uint{3, 2, 5, 1, 4}, ids)
+
+	ids = nil
+	for _, n := range upgradeOrder(nodes, nil) {
+		ids = append(ids, n.ID)
+	}
+	assert.Equal(t, []uint{2, 3, 5, 1, 4}, ids, "without a bootstrap hub the lowest control plane leads")
+}
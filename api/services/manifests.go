@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/kube"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/datatypes"
+)
+
+// ManifestApply describes why a manifest is being applied and on whose
+// behalf; it ends up on the recorded revision.
+type ManifestApply struct {
+	Operation      string
+	ParentID       *uint
+	ClusterID      *uint
+	ApplicationID  *uint
+	SourceRevision string
+	ActorID        *uint
+}
+
+// ApplyManifest snapshots the live state of objs, applies them and records
+// the outcome as a revision. Nothing is applied if the snapshot fails, since
+// the apply could then not be rolled back. The revision is returned even
+// when some objects failed to apply.
+func ApplyManifest(ctx context.Context, kc *kube.Client, manifest string, objs []kube.ManifestObject, apply ManifestApply) (*models.KubernetesManifestRevision, []kube.AppliedObject, error) {
+	snaps, err := kc.Snapshot(ctx, objs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to snapshot live state: %w", err)
+	}
+
+	results, applyErr := kc.ApplyObjects(ctx, objs)
+	rev := RecordManifestRevision(apply, manifest, results, snaps, applyErr)
+	return rev, results, applyErr
+}
+
+// RecordManifestRevision stores the outcome of an apply or rollback. snaps is
+// the live state from before it, which a later rollback restores.
+func RecordManifestRevision(apply ManifestApply, manifest string, results []kube.AppliedObject, snaps []kube.ObjectSnapshot, applyErr error) *models.KubernetesManifestRevision {
+	objectsJSON, _ := json.Marshal(results)
+	// Likewise a manifest whose Secrets can't be sealed is not stored, and
+	// the revision can't be re-applied.
+	shown, sealedManifest, err := sealManifest(manifest)
+	if err != nil {
+		logger.Error("Failed to seal manifest", "operation", apply.Operation, "error", err)
+		shown, sealedManifest = "", ""
+	}
+	// Without a sealed snapshot the revision just can't be rolled back;
+	// storing Secret data in the clear is not the fallback.
+	var snapshotJSON []byte
+	if sealed, err := sealSnapshots(snaps); err != nil {
+		logger.Error("Failed to seal manifest snapshot", "operation", apply.Operation, "error", err)
+	} else {
+		snapshotJSON, _ = json.Marshal(sealed)
+	}
+	rev := models.KubernetesManifestRevision{
+		Operation:           apply.Operation,
+		ParentID:            apply.ParentID,
+		KubernetesClusterID: apply.ClusterID,
+		ApplicationID:       apply.ApplicationID,
+		SourceRevision:      apply.SourceRevision,
+		Manifest:            shown,
+		SealedManifest:      sealedManifest,
+		Objects:             datatypes.JSON(objectsJSON),
+		Snapshot:            datatypes.JSON(snapshotJSON),
+		Success:             applyErr == nil,
+		AppliedByID:         apply.ActorID,
+	}
+	if applyErr != nil {
+		rev.Error = applyErr.Error()
+	}
+	if err := database.DB.Create(&rev).Error; err != nil {
+		logger.Error("Failed to record manifest revision", "operation", apply.Operation, "error", err)
+		return nil
+	}
+	return &rev
+}
+
+// ManifestRevisionSnapshots returns the live state recorded before rev, with
+// sealed Secrets opened again.
+func ManifestRevisionSnapshots(rev *models.KubernetesManifestRevision) ([]kube.ObjectSnapshot, error) {
+	var snaps []kube.ObjectSnapshot
+	if len(rev.Snapshot) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(rev.Snapshot, &snaps); err != nil {
+		return nil, err
+	}
+	for i := range snaps {
+		if snaps[i].Sealed == "" {
+			continue
+		}
+		obj, err := openSnapshotObject(snaps[i].Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", snaps[i].Kind, snaps[i].Name, err)
+		}
+		snaps[i].Object = obj
+		snaps[i].Sealed = ""
+	}
+	return snaps, nil
+}
+
+// ManifestRevisionManifest returns the manifest rev applied, with the
+// values of its Secrets, which Manifest only shows redacted.
+func ManifestRevisionManifest(rev *models.KubernetesManifestRevision) (string, error) {
+	if rev.SealedManifest == "" {
+		return rev.Manifest, nil
+	}
+	plain, err := openSealed(rev.SealedManifest)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// SealManifestRevisions moves the Secret values of revisions recorded
+// before manifests were sealed out of the readable manifest.
+func SealManifestRevisions() error {
+	var revs []models.KubernetesManifestRevision
+	if err := database.DB.Select("id", "manifest").
+		Where("sealed_manifest = '' AND manifest LIKE ?", "%Secret%").
+		Find(&revs).Error; err != nil {
+		return err
+	}
+	for _, rev := range revs {
+		shown, sealed, err := sealManifest(rev.Manifest)
+		if err != nil {
+			logger.Error("Failed to seal manifest revision; dropping its manifest", "revision_id", rev.ID, "error", err)
+			shown = ""
+		} else if sealed == "" {
+			continue
+		}
+		if err := database.DB.Model(&models.KubernetesManifestRevision{}).Where("id = ?", rev.ID).
+			Updates(map[string]any{"manifest": shown, "sealed_manifest": sealed}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestRedacted stands in for Secret values in stored manifests.
+const manifestRedacted = "(redacted)"
+
+// sealManifest splits manifest into the text shown on a revision and the
+// sealed original. A manifest without Secret values is shown as it is and
+// sealed is empty; otherwise shown has every value of a Secret's data and
+// stringData replaced, re-rendered one object per document.
+func sealManifest(manifest string) (shown string, sealed string, err error) {
+	objs, err := kube.DecodeManifest(manifest)
+	if err != nil {
+		return "", "", err
+	}
+	redacted := false
+	docs := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		content := obj.Object
+		if obj.GetKind() == "Secret" {
+			content = obj.DeepCopy().Object
+			for _, field := range []string{"data", "stringData"} {
+				values, ok := content[field].(map[string]any)
+				if !ok {
+					continue
+				}
+				for k := range values {
+					values[k] = manifestRedacted
+					redacted = true
+				}
+			}
+		}
+		doc, err := yaml.Marshal(content)
+		if err != nil {
+			return "", "", err
+		}
+		docs = append(docs, string(doc))
+	}
+	if !redacted {
+		return manifest, "", nil
+	}
+	sealed, err = sealBytes([]byte(manifest))
+	if err != nil {
+		return "", "", err
+	}
+	return strings.Join(docs, "---\n"), sealed, nil
+}
+
+// sealSnapshots encrypts the Secrets among snaps with a key derived from the
+// API secret key; other objects are stored as they are.
+func sealSnapshots(snaps []kube.ObjectSnapshot) ([]kube.ObjectSnapshot, error) {
+	out := make([]kube.ObjectSnapshot, len(snaps))
+	for i, snap := range snaps {
+		out[i] = snap
+		if snap.Kind != "Secret" || snap.Object == nil {
+			continue
+		}
+		plain, err := json.Marshal(snap.Object)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := sealBytes(plain)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Object = nil
+		out[i].Sealed = sealed
+	}
+	return out, nil
+}
+
+func openSnapshotObject(sealed string) (map[string]any, error) {
+	plain, err := openSealed(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(plain, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// sealBytes encrypts plain with the snapshot key, nonce first, base64
+// encoded.
+func sealBytes(plain []byte) (string, error) {
+	gcm, err := snapshotCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func openSealed(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := snapshotCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sealed data: %w", err)
+	}
+	return plain, nil
+}
+
+func snapshotCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("gluon manifest snapshot\x00" + config.Current().SecretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
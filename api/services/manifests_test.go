@@ -0,0 +1,76 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gluon-api/config"
+	"gluon-api/kube"
+	"gluon-api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealedSnapshotsRoundTrip(t *testing.T) {
+	t.Setenv("GLUON_SECRET_KEY", "test")
+	require.NoError(t, config.Load())
+
+	secret := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]any{"name": "db", "namespace": "web"},
+		"data":       map[string]any{"password": "aHVudGVyMg=="},
+	}
+	configMap := map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "data": map[string]any{"k": "v"}}
+	snaps := []kube.ObjectSnapshot{
+		{APIVersion: "v1", Kind: "Secret", Namespace: "web", Name: "db", Existed: true, Object: secret},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "web", Name: "cfg", Existed: true, Object: configMap},
+		{APIVersion: "v1", Kind: "Secret", Namespace: "web", Name: "new"},
+	}
+
+	sealed, err := sealSnapshots(snaps)
+	require.NoError(t, err)
+	raw, err := json.Marshal(sealed)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "aHVudGVyMg==")
+	assert.Contains(t, string(raw), `"k":"v"`)
+	assert.NotNil(t, snaps[0].Object, "the caller's snapshots are left alone")
+
+	opened, err := ManifestRevisionSnapshots(&models.KubernetesManifestRevision{Snapshot: raw})
+	require.NoError(t, err)
+	assert.Equal(t, snaps, opened)
+
+	t.Setenv("GLUON_SECRET_KEY", "other")
+	require.NoError(t, config.Load())
+	_, err = ManifestRevisionSnapshots(&models.KubernetesManifestRevision{Snapshot: raw})
+	assert.Error(t, err)
+}
+
+func TestSealManifest(t *testing.T) {
+	t.Setenv("GLUON_SECRET_KEY", "test")
+	require.NoError(t, config.Load())
+
+	plain := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\ndata:\n  k: v\n"
+	shown, sealed, err := sealManifest(plain)
+	require.NoError(t, err)
+	assert.Equal(t, plain, shown)
+	assert.Empty(t, sealed)
+
+	manifest := plain + "---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: db\ndata:\n  password: aHVudGVyMg==\nstringData:\n  token: hunter2\n"
+	shown, sealed, err = sealManifest(manifest)
+	require.NoError(t, err)
+	assert.NotContains(t, shown, "aHVudGVyMg==")
+	assert.NotContains(t, shown, "hunter2")
+	assert.Contains(t, shown, "password: "+manifestRedacted)
+	assert.Contains(t, shown, "k: v")
+	assert.NotContains(t, sealed, "hunter2")
+
+	rev := &models.KubernetesManifestRevision{Manifest: shown, SealedManifest: sealed}
+	raw, err := json.Marshal(rev)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), sealed)
+	applied, err := ManifestRevisionManifest(rev)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, applied)
+}
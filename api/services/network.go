@@ -28,6 +28,8 @@ func EnsureDefaultPools() error {
 		{models.IPPoolPurposeHub2Worker, cfg.Hub2WorkerCIDR, intPtr(2), models.IPPoolKindWireGuard},
 		{models.IPPoolPurposeHub3Worker, cfg.Hub3WorkerCIDR, intPtr(3), models.IPPoolKindWireGuard},
 		{models.IPPoolPurposeKubernetesServices, cfg.KubernetesServiceCIDR, nil, models.IPPoolKindKubernetes},
+		{models.IPPoolPurposeServiceVIP, cfg.ServiceVIPCIDR, nil, models.IPPoolKindWireGuard},
+		{models.IPPoolPurposeWorkerShortcut, cfg.WorkerShortcutCIDR, nil, models.IPPoolKindWireGuard},
 	}
 
 	for _, p := range pools {
@@ -268,7 +270,7 @@ func createLink(hub *models.Node, worker *models.Node) error {
 			database.DB.Model(&models.NodePeer{}).Where("interface_id = ?", workerIface.ID).Update("allowed_ips", desired)
 			database.DB.Model(&workerIface).Update("listen_port", workerListenPort)
 			database.DB.Model(&models.NodePeer{}).Where("interface_id = ?", workerIface.ID).
-				Update("endpoint", fmt.Sprintf("%s:%d", hub.PublicIP, hubListenPort))
+				Update("endpoint", peerEndpoint(worker, hub, hubListenPort))
 		}
 
 		return nil
@@ -349,9 +351,9 @@ func createLink(hub *models.Node, worker *models.Node) error {
 	workerPeer := models.NodePeer{
 		InterfaceID:         workerInterface.ID,
 		PeerNodeID:          hub.ID,
-		Endpoint:            fmt.Sprintf("%s:%d", hub.PublicIP, hubListenPort),
+		Endpoint:            peerEndpoint(worker, hub, hubListenPort),
 		AllowedIPs:          fmt.Sprintf("%s/32, %s, %s, 224.0.0.5/32", hubLoopback, subnet, config.Current().LoopbackCIDR),
-		PersistentKeepAlive: 0,
+		PersistentKeepAlive: natKeepalive(worker, 0),
 		Status:              models.PeerStatusActive,
 	}
 	if err := database.DB.Create(&workerPeer).Error; err != nil {
@@ -387,7 +389,7 @@ func createHubToHubLink(hubA *models.Node, hubB *models.Node) error {
 			database.DB.Model(&hubAInterface).Update("listen_port", hubAPort)
 			database.DB.Model(&models.NodePeer{}).Where("interface_id = ?", hubAInterface.ID).
 				Updates(map[string]any{
-					"endpoint":    fmt.Sprintf("%s:%d", hubB.PublicIP, hubBPort),
+					"endpoint":    peerEndpoint(hubA, hubB, hubBPort),
 					"allowed_ips": allowed,
 				})
 		}
@@ -398,7 +400,7 @@ func createHubToHubLink(hubA *models.Node, hubB *models.Node) error {
 			database.DB.Model(&hubBInterface).Update("listen_port", hubBPort)
 			database.DB.Model(&models.NodePeer{}).Where("interface_id = ?", hubBInterface.ID).
 				Updates(map[string]any{
-					"endpoint":    fmt.Sprintf("%s:%d", hubA.PublicIP, hubAPort),
+					"endpoint":    peerEndpoint(hubB, hubA, hubAPort),
 					"allowed_ips": allowed,
 				})
 		}
@@ -461,7 +463,7 @@ func createHubToHubLink(hubA *models.Node, hubB *models.Node) error {
 	hubAPeer := models.NodePeer{
 		InterfaceID:         hubAInterface.ID,
 		PeerNodeID:          hubB.ID,
-		Endpoint:            fmt.Sprintf("%s:%d", hubB.PublicIP, hubBPort),
+		Endpoint:            peerEndpoint(hubA, hubB, hubBPort),
 		AllowedIPs:          fmt.Sprintf("%s, %s, 224.0.0.5/32", subnet, config.Current().LoopbackCIDR),
 		PersistentKeepAlive: 0,
 		Status:              models.PeerStatusActive,
@@ -473,7 +475,7 @@ func createHubToHubLink(hubA *models.Node, hubB *models.Node) error {
 	hubBPeer := models.NodePeer{
 		InterfaceID:         hubBInterface.ID,
 		PeerNodeID:          hubA.ID,
-		Endpoint:            fmt.Sprintf("%s:%d", hubA.PublicIP, hubAPort),
+		Endpoint:            peerEndpoint(hubB, hubA, hubAPort),
 		AllowedIPs:          fmt.Sprintf("%s, %s, 224.0.0.5/32", subnet, config.Current().LoopbackCIDR),
 		PersistentKeepAlive: 0,
 		Status:              models.PeerStatusActive,
@@ -2,6 +2,7 @@ package services
 
 import (
 	"gluon-api/database"
+	"gluon-api/logger"
 	"gluon-api/models"
 
 	"gorm.io/gorm"
@@ -14,6 +15,17 @@ func RebuildNetworking() error {
 		models.IPPoolPurposeHub1Worker,
 		models.IPPoolPurposeHub2Worker,
 		models.IPPoolPurposeHub3Worker,
+		models.IPPoolPurposeWorkerShortcut,
+	}
+
+	// Pinned shortcuts are admin intent, so they survive a rebuild; traffic
+	// driven ones are simply re-learned by the reconciler.
+	var pinned []models.WorkerShortcut
+	if err := database.DB.Where("pinned = ?", true).Find(&pinned).Error; err != nil {
+		return err
+	}
+	if err := database.DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.WorkerShortcut{}).Error; err != nil {
+		return err
 	}
 
 	if err := database.DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.LinkAllocation{}).Error; err != nil {
@@ -69,5 +81,19 @@ func RebuildNetworking() error {
 		}
 	}
 
+	nodesByID := make(map[uint]*models.Node, len(nodes))
+	for i := range nodes {
+		nodesByID[nodes[i].ID] = &nodes[i]
+	}
+	for _, sc := range pinned {
+		a, b := nodesByID[sc.NodeAID], nodesByID[sc.NodeBID]
+		if a == nil || b == nil || a.Role != models.NodeRoleWorker || b.Role != models.NodeRoleWorker {
+			continue
+		}
+		if _, err := CreateWorkerShortcut(a, b, true, sc.Reason, sc.CreatedByID); err != nil {
+			logger.Error("Failed to restore pinned worker shortcut", "error", err, "node_a_id", a.ID, "node_b_id", b.ID)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNodeCommandNotFound = errors.New("command not found")
+	ErrNodeCommandFinished = errors.New("command already finished")
+)
+
+const (
+	// maxCommandTimeout bounds the timeout_seconds an admin may ask for.
+	maxCommandTimeout = 24 * 60 * 60
+	// maxCommandAttempts bounds max_attempts.
+	maxCommandAttempts = 10
+	// commandReportGrace is how long after the agent-side timeout the API
+	// waits for the result before it gives up on the attempt.
+	commandReportGrace = 30 * time.Second
+	// commandDispatchTimeout is how long a command of a job may wait for its
+	// node to pick it up. A node that stays offline fails its command
+	// instead of holding the job open.
+	commandDispatchTimeout = 15 * time.Minute
+)
+
+// commandKindTimeouts are the per-attempt timeouts used when a command does
+// not set its own.
+var commandKindTimeouts = map[string]time.Duration{
+	models.CmdKindRestartService:      time.Minute,
+	models.CmdKindRunDiagnostic:       time.Minute,
+	models.CmdKindRotateWireGuardKeys: 2 * time.Minute,
+	models.CmdKindDecommission:        5 * time.Minute,
+}
+
+const defaultCommandTimeout = 5 * time.Minute
+
+// CommandTimeout is how long one attempt of cmd may run on the agent.
+func CommandTimeout(cmd *models.NodeCommand) time.Duration {
+	if cmd.TimeoutSeconds > 0 {
+		return time.Duration(cmd.TimeoutSeconds) * time.Second
+	}
+	if d, ok := commandKindTimeouts[cmd.Kind]; ok {
+		return d
+	}
+	return defaultCommandTimeout
+}
+
+// ValidateCommandPolicy checks the timeout and retry settings an admin
+// gave for a command; zero keeps the defaults.
+func ValidateCommandPolicy(timeoutSeconds int, maxAttempts int) error {
+	if timeoutSeconds < 0 || timeoutSeconds > maxCommandTimeout {
+		return fmt.Errorf("timeout_seconds must be between 0 and %d", maxCommandTimeout)
+	}
+	if maxAttempts < 0 || maxAttempts > maxCommandAttempts {
+		return fmt.Errorf("max_attempts must be at most %d", maxCommandAttempts)
+	}
+	return nil
+}
+
+// StartNodeCommand marks cmd as handed to the agent at now and sets the
+// deadline of this attempt. It reports false, leaving cmd alone, when the
+// command is no longer pending, e.g. because it was cancelled since it was
+// read; such a command must not be handed out.
+func StartNodeCommand(cmd *models.NodeCommand, now time.Time) (bool, error) {
+	timeout := CommandTimeout(cmd)
+	deadline := now.Add(timeout + commandReportGrace)
+	tx := database.DB.Model(&models.NodeCommand{}).
+		Where("id = ? AND status = ?", cmd.ID, models.NodeCommandStatusPending).
+		Updates(map[string]any{
+			"status":          models.NodeCommandStatusRunning,
+			"started_at":      &now,
+			"deadline":        &deadline,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"timeout_seconds": int(timeout / time.Second),
+		})
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return false, nil
+	}
+	cmd.Status = models.NodeCommandStatusRunning
+	cmd.StartedAt = &now
+	cmd.Deadline = &deadline
+	cmd.Attempts++
+	cmd.TimeoutSeconds = int(timeout / time.Second)
+	return true, nil
+}
+
+// dispatchDeadline is the deadline of a command that is waiting for its
+// node: set for commands of a job, none for commands sent on their own.
+func dispatchDeadline(cmd *models.NodeCommand, now time.Time) *time.Time {
+	if cmd.JobID == nil {
+		return nil
+	}
+	deadline := now.Add(commandDispatchTimeout)
+	return &deadline
+}
+
+// commandExpired reports whether a command is past its deadline: of the
+// current attempt when running, of the dispatch when pending. Commands started before deadlines existed get one
+// from their start time.
+func commandExpired(cmd *models.NodeCommand, now time.Time) bool {
+	if cmd.Deadline != nil {
+		return now.After(*cmd.Deadline)
+	}
+	if cmd.StartedAt == nil {
+		return false
+	}
+	return now.After(cmd.StartedAt.Add(CommandTimeout(cmd) + commandReportGrace))
+}
+
+// expiredCommandUpdate is what happens to a command whose attempt expired:
+// it is retried while attempts remain, otherwise it fails (or counts as
+// cancelled if an admin already asked for that). A command whose node
+// never picked it up fails.
+func expiredCommandUpdate(cmd *models.NodeCommand, now time.Time) map[string]any {
+	timeout := CommandTimeout(cmd)
+	switch {
+	case cmd.Status == models.NodeCommandStatusPending:
+		return map[string]any{
+			"status":       models.NodeCommandStatusFailed,
+			"completed_at": &now,
+			"error":        fmt.Sprintf("the node did not pick the command up within %s", commandDispatchTimeout),
+		}
+	case cmd.CancelRequestedAt != nil:
+		return map[string]any{
+			"status":       models.NodeCommandStatusCancelled,
+			"completed_at": &now,
+			"error":        "cancelled; the agent did not confirm",
+		}
+	case cmd.Attempts < cmd.MaxAttempts:
+		return map[string]any{
+			"status":     models.NodeCommandStatusPending,
+			"started_at": nil,
+			"deadline":   dispatchDeadline(cmd, now),
+			"error":      fmt.Sprintf("attempt %d timed out after %s; retrying", cmd.Attempts, timeout),
+		}
+	default:
+		return map[string]any{
+			"status":       models.NodeCommandStatusFailed,
+			"completed_at": &now,
+			"error":        fmt.Sprintf("timed out after %s (%d attempts)", timeout, cmd.Attempts),
+		}
+	}
+}
+
+// ReapNodeCommands settles running commands whose agent never reported
+// back in time, e.g. because it crashed or went offline, and job commands
+// their node never picked up.
+func ReapNodeCommands() error {
+	var waiting []models.NodeCommand
+	if err := database.DB.Omit("output").
+		Where("status = ? OR (status = ? AND deadline IS NOT NULL)", models.NodeCommandStatusRunning, models.NodeCommandStatusPending).
+		Find(&waiting).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var jobIDs []uint
+	for i := range waiting {
+		cmd := &waiting[i]
+		if !commandExpired(cmd, now) {
+			continue
+		}
+		updates := expiredCommandUpdate(cmd, now)
+		tx := database.DB.Model(&models.NodeCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, cmd.Status).
+			Updates(updates)
+		if tx.Error != nil {
+			logger.Error("Failed to reap command", "error", tx.Error, "command_id", cmd.ID)
+			continue
+		}
+		if tx.RowsAffected == 0 {
+			continue
+		}
+		logger.Warn("Command attempt expired", "command_id", cmd.ID, "node_id", cmd.NodeID,
+			"kind", cmd.Kind, "attempt", cmd.Attempts, "status", updates["status"])
+		if cmd.JobID != nil {
+			jobIDs = append(jobIDs, *cmd.JobID)
+		}
+	}
+	for _, id := range uniqueIDs(jobIDs) {
+		if err := AdvanceCommandJob(id); err != nil {
+			logger.Error("Failed to advance command job", "error", err, "job_id", id)
+		}
+	}
+	return nil
+}
+
+// CancelNodeCommand withdraws a command. One that has not reached the
+// agent is cancelled right away; a running one is flagged so the agent
+// stops it on its next heartbeat.
+func CancelNodeCommand(id uint) (*models.NodeCommand, error) {
+	var cmd models.NodeCommand
+	if err := database.DB.First(&cmd, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeCommandNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	var tx *gorm.DB
+	switch cmd.Status {
+	case models.NodeCommandStatusPending, models.NodeCommandStatusQueued:
+		tx = database.DB.Model(&models.NodeCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, cmd.Status).
+			Updates(map[string]any{
+				"status":              models.NodeCommandStatusCancelled,
+				"cancel_requested_at": &now,
+				"completed_at":        &now,
+			})
+	case models.NodeCommandStatusRunning:
+		tx = database.DB.Model(&models.NodeCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, cmd.Status).
+			Update("cancel_requested_at", &now)
+	default:
+		return &cmd, ErrNodeCommandFinished
+	}
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		// The command moved on between the read and the update.
+		return &cmd, ErrNodeCommandFinished
+	}
+
+	if err := database.DB.First(&cmd, id).Error; err != nil {
+		return nil, err
+	}
+	if cmd.JobID != nil {
+		if err := AdvanceCommandJob(*cmd.JobID); err != nil {
+			logger.Error("Failed to advance command job", "error", err, "job_id", *cmd.JobID)
+		}
+	}
+	return &cmd, nil
+}
+
+// CommandsToCancel lists the running commands of nodeID an admin asked to
+// cancel, for the agent to stop.
+func CommandsToCancel(nodeID uint) []uint {
+	var ids []uint
+	if err := database.DB.Model(&models.NodeCommand{}).
+		Where("node_id = ? AND status = ? AND cancel_requested_at IS NOT NULL", nodeID, models.NodeCommandStatusRunning).
+		Pluck("id", &ids).Error; err != nil {
+		logger.Error("Failed to load cancelled commands", "error", err, "node_id", nodeID)
+	}
+	return ids
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	assert.Equal(t, time.Minute, CommandTimeout(&models.NodeCommand{Kind: models.CmdKindRestartService}))
+	assert.Equal(t, defaultCommandTimeout, CommandTimeout(&models.NodeCommand{Kind: "something_new"}))
+	assert.Equal(t, 10*time.Second, CommandTimeout(&models.NodeCommand{Kind: models.CmdKindRestartService, TimeoutSeconds: 10}))
+}
+
+func TestCommandExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Second)
+	assert.True(t, commandExpired(&models.NodeCommand{Deadline: &past}, now))
+	assert.False(t, commandExpired(&models.NodeCommand{Deadline: &future}, now))
+	assert.False(t, commandExpired(&models.NodeCommand{}, now))
+
+	// Commands handed out before deadlines existed expire from their start.
+	longAgo := now.Add(-time.Hour)
+	recent := now.Add(-time.Minute)
+	assert.True(t, commandExpired(&models.NodeCommand{Kind: models.CmdKindRestartService, StartedAt: &longAgo}, now))
+	assert.False(t, commandExpired(&models.NodeCommand{Kind: models.CmdKindRestartService, StartedAt: &recent}, now))
+}
+
+func TestExpiredCommandUpdate(t *testing.T) {
+	now := time.Now()
+
+	retry := expiredCommandUpdate(&models.NodeCommand{Kind: models.CmdKindRestartService, Attempts: 1, MaxAttempts: 3}, now)
+	assert.Equal(t, models.NodeCommandStatusPending, retry["status"])
+	assert.Nil(t, retry["deadline"])
+	assert.Contains(t, retry["error"], "retrying")
+
+	failed := expiredCommandUpdate(&models.NodeCommand{Kind: models.CmdKindRestartService, Attempts: 3, MaxAttempts: 3}, now)
+	assert.Equal(t, models.NodeCommandStatusFailed, failed["status"])
+	assert.Equal(t, "timed out after 1m0s (3 attempts)", failed["error"])
+
+	cancelled := expiredCommandUpdate(&models.NodeCommand{Attempts: 1, MaxAttempts: 3, CancelRequestedAt: &now}, now)
+	assert.Equal(t, models.NodeCommandStatusCancelled, cancelled["status"])
+
+	// A job's retry waits for its node only so long.
+	job := uint(7)
+	retry = expiredCommandUpdate(&models.NodeCommand{JobID: &job, Kind: models.CmdKindRestartService, Attempts: 1, MaxAttempts: 3}, now)
+	assert.Equal(t, now.Add(commandDispatchTimeout), *retry["deadline"].(*time.Time))
+
+	undispatched := expiredCommandUpdate(&models.NodeCommand{JobID: &job, Status: models.NodeCommandStatusPending, MaxAttempts: 3}, now)
+	assert.Equal(t, models.NodeCommandStatusFailed, undispatched["status"])
+	assert.Equal(t, "the node did not pick the command up within 15m0s", undispatched["error"])
+}
+
+func TestStartNodeCommandSkipsCancelled(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Node{}, &models.CommandJob{}, &models.NodeCommand{}))
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	node := models.Node{Hostname: "node-1"}
+	require.NoError(t, db.Create(&node).Error)
+	cmd := models.NodeCommand{NodeID: node.ID, Kind: models.CmdKindRestartService, Status: models.NodeCommandStatusPending}
+	require.NoError(t, db.Create(&cmd).Error)
+
+	// Cancelled after the heartbeat read it as pending.
+	stale := cmd
+	_, err = CancelNodeCommand(cmd.ID)
+	require.NoError(t, err)
+	started, err := StartNodeCommand(&stale, time.Now())
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, models.NodeCommandStatusPending, stale.Status)
+
+	other := models.NodeCommand{NodeID: node.ID, Kind: models.CmdKindRestartService, Status: models.NodeCommandStatusPending}
+	require.NoError(t, db.Create(&other).Error)
+	started, err = StartNodeCommand(&other, time.Now())
+	require.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, models.NodeCommandStatusRunning, other.Status)
+	assert.Equal(t, 1, other.Attempts)
+}
+
+func TestValidateCommandPolicy(t *testing.T) {
+	assert.NoError(t, ValidateCommandPolicy(0, 0))
+	assert.NoError(t, ValidateCommandPolicy(600, 3))
+	assert.Error(t, ValidateCommandPolicy(-1, 0))
+	assert.Error(t, ValidateCommandPolicy(0, 11))
+}
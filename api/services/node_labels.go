@@ -0,0 +1,152 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ValidateNodeLabels checks Gluon node labels, which follow the Kubernetes
+// label syntax. Keys that selectors resolve to node fields are reserved.
+func ValidateNodeLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("label key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if _, reserved := nodeFieldColumns[k]; reserved {
+			return fmt.Errorf("label key %q is reserved for the node's own %s", k, k)
+		}
+		if errs := validation.IsValidLabelValue(labels[k]); len(errs) > 0 {
+			return fmt.Errorf("label %q value %q: %s", k, labels[k], strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// NodeLabels decodes the labels stored on node.
+func NodeLabels(node *models.Node) map[string]string {
+	labels := map[string]string{}
+	if len(node.Labels) > 0 {
+		_ = json.Unmarshal(node.Labels, &labels)
+	}
+	return labels
+}
+
+// SetNodeLabels replaces the labels of node and its rows in the label
+// index.
+func SetNodeLabels(node *models.Node, labels map[string]string) error {
+	_, err := writeNodeLabels(node, func(map[string]string) map[string]string {
+		if labels == nil {
+			return map[string]string{}
+		}
+		return labels
+	})
+	return err
+}
+
+// MergeNodeLabels sets the labels in set and drops the keys in remove,
+// leaving the node's other labels alone. It returns the labels from before.
+func MergeNodeLabels(node *models.Node, set map[string]string, remove []string) (map[string]string, error) {
+	return writeNodeLabels(node, func(labels map[string]string) map[string]string {
+		for _, k := range remove {
+			delete(labels, k)
+		}
+		for k, v := range set {
+			labels[k] = v
+		}
+		return labels
+	})
+}
+
+// writeNodeLabels stores change(current labels) on node. The labels are
+// re-read with the row locked in the transaction that writes them, so
+// concurrent updates don't lose each other's changes.
+func writeNodeLabels(node *models.Node, change func(map[string]string) map[string]string) (map[string]string, error) {
+	var before map[string]string
+	var data []byte
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Node
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "labels").First(&current, node.ID).Error; err != nil {
+			return err
+		}
+		before = NodeLabels(&current)
+		labels := change(NodeLabels(&current))
+		if err := ValidateNodeLabels(labels); err != nil {
+			return err
+		}
+		data, _ = json.Marshal(labels)
+		if err := tx.Model(node).Update("labels", data).Error; err != nil {
+			return err
+		}
+		return writeNodeLabelIndex(tx, node.ID, labels)
+	})
+	if err != nil {
+		return nil, err
+	}
+	node.Labels = data
+	return before, nil
+}
+
+func writeNodeLabelIndex(tx *gorm.DB, nodeID uint, labels map[string]string) error {
+	if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeLabel{}).Error; err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	rows := make([]models.NodeLabel, 0, len(labels))
+	for k, v := range labels {
+		rows = append(rows, models.NodeLabel{NodeID: nodeID, Key: k, Value: v})
+	}
+	return tx.Create(&rows).Error
+}
+
+// RebuildNodeLabelIndex rewrites the label index from the nodes' labels,
+// picking up nodes labelled before the index existed.
+func RebuildNodeLabelIndex() error {
+	var nodes []models.Node
+	if err := database.DB.Select("id", "labels").Find(&nodes).Error; err != nil {
+		return err
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range nodes {
+			if err := writeNodeLabelIndex(tx, nodes[i].ID, NodeLabels(&nodes[i])); err != nil {
+				return err
+			}
+		}
+		logger.Info("Node label index rebuilt", "nodes", len(nodes))
+		return nil
+	})
+}
+
+// NodeLabelValue is one label value and how many nodes carry it.
+type NodeLabelValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Nodes int64  `json:"nodes"`
+}
+
+// ListNodeLabelValues returns every label in use with its node count.
+func ListNodeLabelValues() ([]NodeLabelValue, error) {
+	var out []NodeLabelValue
+	err := database.DB.Model(&models.NodeLabel{}).
+		Select("key, value, COUNT(*) AS nodes").
+		Group("key, value").
+		Order("key asc, value asc").
+		Scan(&out).Error
+	return out, err
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMergeNodeLabelsConcurrently(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&models.Node{}, &models.NodeLabel{}))
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	node := models.Node{Hostname: "node-1"}
+	require.NoError(t, db.Create(&node).Error)
+	require.NoError(t, SetNodeLabels(&node, map[string]string{"env": "prod", "old": "x"}))
+
+	// Every writer starts from the same stale copy of the node.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(stale models.Node) {
+			defer wg.Done()
+			_, err := MergeNodeLabels(&stale, map[string]string{fmt.Sprintf("k%d", i): "v"}, []string{"old"})
+			assert.NoError(t, err)
+		}(node)
+	}
+	wg.Wait()
+
+	var stored models.Node
+	require.NoError(t, db.First(&stored, node.ID).Error)
+	labels := NodeLabels(&stored)
+	assert.Len(t, labels, 9)
+	assert.Equal(t, "prod", labels["env"])
+	assert.NotContains(t, labels, "old")
+
+	var indexed int64
+	require.NoError(t, db.Model(&models.NodeLabel{}).Where("node_id = ?", node.ID).Count(&indexed).Error)
+	assert.EqualValues(t, 9, indexed)
+
+	before, err := MergeNodeLabels(&stored, nil, []string{"k0"})
+	require.NoError(t, err)
+	assert.Equal(t, "v", before["k0"])
+	assert.NotContains(t, NodeLabels(&stored), "k0")
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gluon-api/models"
+	"gluon-api/selector"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// nodeFieldColumns are the selector keys answered by node columns rather
+// than labels, so `provider=hetzner,role!=hub` works without labelling.
+var nodeFieldColumns = map[string]string{
+	"hostname":   "hostname",
+	"role":       "role",
+	"provider":   "provider",
+	"os":         "os",
+	"region":     "region",
+	"status":     "status",
+	"hub_number": "hub_number",
+}
+
+// NodeSelector is a parsed label selector over nodes. Keys in
+// nodeFieldColumns are answered by the node's columns, others by its
+// labels; the empty selector matches all nodes.
+type NodeSelector selector.Selector
+
+// ParseNodeSelector parses selectors such as
+// `provider=hetzner,role!=hub,zone in (a,b),!gpu` and checks the keys and
+// values against the label syntax.
+func ParseNodeSelector(s string) (NodeSelector, error) {
+	sel, err := selector.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range sel {
+		if _, field := nodeFieldColumns[req.Key]; !field {
+			if errs := validation.IsQualifiedName(req.Key); len(errs) > 0 {
+				return nil, fmt.Errorf("selector key %q: %s", req.Key, strings.Join(errs, "; "))
+			}
+		}
+		for _, v := range req.Values {
+			if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+				return nil, fmt.Errorf("selector value %q: %s", v, strings.Join(errs, "; "))
+			}
+		}
+	}
+	return NodeSelector(sel), nil
+}
+
+// String renders the selector back in canonical form.
+func (s NodeSelector) String() string {
+	return selector.Selector(s).String()
+}
+
+// Matches reports whether node satisfies every requirement.
+func (s NodeSelector) Matches(node *models.Node) bool {
+	if len(s) == 0 {
+		return true
+	}
+	labels := NodeLabels(node)
+	return selector.Selector(s).Matches(func(key string) (string, bool) {
+		if _, field := nodeFieldColumns[key]; field {
+			return nodeFieldValue(node, key), true
+		}
+		v, ok := labels[key]
+		return v, ok
+	})
+}
+
+func nodeFieldValue(node *models.Node, key string) string {
+	switch key {
+	case "hostname":
+		return node.Hostname
+	case "role":
+		return string(node.Role)
+	case "provider":
+		return node.Provider
+	case "os":
+		return node.OS
+	case "region":
+		return node.Region
+	case "status":
+		return string(node.Status)
+	case "hub_number":
+		return strconv.Itoa(node.HubNumber)
+	}
+	return ""
+}
+
+// Scope narrows a query on nodes to those the selector matches, using the
+// label index for label keys.
+func (s NodeSelector) Scope(db *gorm.DB) *gorm.DB {
+	for _, r := range s {
+		if col, field := nodeFieldColumns[r.Key]; field {
+			switch r.Op {
+			case selector.Equals, selector.In:
+				db = db.Where("nodes."+col+" IN ?", r.Values)
+			case selector.NotEquals, selector.NotIn:
+				db = db.Where("nodes."+col+" NOT IN ?", r.Values)
+			case selector.NotExists:
+				db = db.Where("1 = 0")
+			}
+			continue
+		}
+		switch r.Op {
+		case selector.Equals, selector.In:
+			db = db.Where("nodes.id IN (SELECT node_id FROM node_labels WHERE key = ? AND value IN ?)", r.Key, r.Values)
+		case selector.NotEquals, selector.NotIn:
+			db = db.Where("nodes.id NOT IN (SELECT node_id FROM node_labels WHERE key = ? AND value IN ?)", r.Key, r.Values)
+		case selector.Exists:
+			db = db.Where("nodes.id IN (SELECT node_id FROM node_labels WHERE key = ?)", r.Key)
+		case selector.NotExists:
+			db = db.Where("nodes.id NOT IN (SELECT node_id FROM node_labels WHERE key = ?)", r.Key)
+		}
+	}
+	return db
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"testing"
+
+	"gluon-api/models"
+	"gluon-api/selector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+func TestParseNodeSelector(t *testing.T) {
+	sel, err := ParseNodeSelector(" provider=hetzner, role!=hub,zone in (fsn1, nbg1),!gpu,example.com/tier, env==prod")
+	require.NoError(t, err)
+	assert.Equal(t, NodeSelector{
+		{Key: "provider", Op: selector.Equals, Values: []string{"hetzner"}},
+		{Key: "role", Op: selector.NotEquals, Values: []string{"hub"}},
+		{Key: "zone", Op: selector.In, Values: []string{"fsn1", "nbg1"}},
+		{Key: "gpu", Op: selector.NotExists},
+		{Key: "example.com/tier", Op: selector.Exists},
+		{Key: "env", Op: selector.Equals, Values: []string{"prod"}},
+	}, sel)
+	assert.Equal(t, "provider=hetzner,role!=hub,zone in (fsn1,nbg1),!gpu,example.com/tier,env=prod", sel.String())
+
+	sel, err = ParseNodeSelector("")
+	require.NoError(t, err)
+	assert.Empty(t, sel)
+
+	_, err = ParseNodeSelector("bad key!=x")
+	assert.ErrorContains(t, err, "selector key")
+	_, err = ParseNodeSelector("env=no spaces")
+	assert.ErrorContains(t, err, "selector value")
+}
+
+func TestNodeSelectorMatches(t *testing.T) {
+	hub := &models.Node{Hostname: "hub-1", Role: models.NodeRoleHub, HubNumber: 1, Provider: "hetzner", Labels: datatypes.JSON(`{"zone":"fsn1","gpu":""}`)}
+	worker := &models.Node{Hostname: "w-1", Role: models.NodeRoleWorker, Provider: "hetzner", Labels: datatypes.JSON(`{"zone":"nbg1"}`)}
+	aws := &models.Node{Hostname: "w-2", Role: models.NodeRoleWorker, Provider: "aws"}
+
+	match := func(s string) []string {
+		sel, err := ParseNodeSelector(s)
+		require.NoError(t, err)
+		var out []string
+		for _, n := range []*models.Node{hub, worker, aws} {
+			if sel.Matches(n) {
+				out = append(out, n.Hostname)
+			}
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"hub-1", "w-1", "w-2"}, match(""))
+	assert.Equal(t, []string{"w-1"}, match("provider=hetzner,role!=hub"))
+	assert.Equal(t, []string{"hub-1", "w-1"}, match("zone in (fsn1,nbg1)"))
+	assert.Equal(t, []string{"w-1", "w-2"}, match("zone notin (fsn1)"))
+	assert.Equal(t, []string{"hub-1"}, match("gpu"))
+	assert.Equal(t, []string{"w-1", "w-2"}, match("!gpu"))
+	assert.Equal(t, []string{"hub-1"}, match("hub_number=1"))
+	assert.Empty(t, match("!role"))
+}
+
+func TestValidateNodeLabelsReservesFieldKeys(t *testing.T) {
+	require.NoError(t, ValidateNodeLabels(map[string]string{"zone": "fsn1"}))
+	assert.ErrorContains(t, ValidateNodeLabels(map[string]string{"provider": "x"}), "reserved")
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"gluon-api/database"
+	"gluon-api/models"
+	"sort"
+)
+
+// LoadAdvertisedPrefixes returns every advertised prefix keyed by owning node.
+func LoadAdvertisedPrefixes() (map[uint][]models.NodePrefix, error) {
+	var prefixes []models.NodePrefix
+	if err := database.DB.Order("node_id asc, prefix asc").Find(&prefixes).Error; err != nil {
+		return nil, err
+	}
+
+	byNode := make(map[uint][]models.NodePrefix)
+	for _, p := range prefixes {
+		byNode[p.NodeID] = append(byNode[p.NodeID], p)
+	}
+	return byNode, nil
+}
+
+// PrefixAllowedIPs returns the extra AllowedIPs a WireGuard peer entry on
+// localNodeID needs so traffic for advertised prefixes is accepted from it.
+// A worker peer only ever carries its own prefixes; a hub peer can transit
+// anything in the fabric, so it gets every prefix the local node doesn't own.
+func PrefixAllowedIPs(localNodeID uint, peer models.Node, byNode map[uint][]models.NodePrefix) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0)
+
+	add := func(prefixes []models.NodePrefix) {
+		for _, p := range prefixes {
+			if p.Prefix == "" || seen[p.Prefix] {
+				continue
+			}
+			seen[p.Prefix] = true
+			out = append(out, p.Prefix)
+		}
+	}
+
+	if peer.Role == models.NodeRoleHub {
+		for nodeID, prefixes := range byNode {
+			if nodeID == localNodeID {
+				continue
+			}
+			add(prefixes)
+		}
+	} else {
+		add(byNode[peer.ID])
+	}
+
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"gluon-api/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixAllowedIPs(t *testing.T) {
+	byNode := map[uint][]models.NodePrefix{
+		1: {{NodeID: 1, Prefix: "10.50.0.0/24"}},
+		2: {{NodeID: 2, Prefix: "192.168.1.0/24"}, {NodeID: 2, Prefix: "192.168.2.0/24"}},
+		3: {{NodeID: 3, Prefix: "192.168.3.0/24"}, {NodeID: 3, Prefix: "192.168.1.0/24"}},
+	}
+
+	tests := []struct {
+		name    string
+		localID uint
+		peer    models.Node
+		want    []string
+	}{
+		{
+			name:    "worker peer carries only its own prefixes",
+			localID: 1,
+			peer:    models.Node{ID: 2, Role: models.NodeRoleWorker},
+			want:    []string{"192.168.1.0/24", "192.168.2.0/24"},
+		},
+		{
+			name:    "worker peer without prefixes gets nothing",
+			localID: 1,
+			peer:    models.Node{ID: 9, Role: models.NodeRoleWorker},
+			want:    []string{},
+		},
+		{
+			name:    "hub peer carries every prefix not owned locally, deduplicated",
+			localID: 2,
+			peer:    models.Node{ID: 1, Role: models.NodeRoleHub},
+			want:    []string{"10.50.0.0/24", "192.168.1.0/24", "192.168.3.0/24"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PrefixAllowedIPs(tt.localID, tt.peer, byNode))
+		})
+	}
+}
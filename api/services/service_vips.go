@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"gluon-api/database"
+	"gluon-api/models"
+	"strings"
+)
+
+// AllocateServiceVIPAddress reserves the next free address in the service VIP pool.
+func AllocateServiceVIPAddress() (*models.IPAllocation, error) {
+	if err := EnsureDefaultPools(); err != nil {
+		return nil, fmt.Errorf("failed to ensure default pools: %w", err)
+	}
+
+	var pool models.IPPool
+	if err := database.DB.Where("purpose = ?", models.IPPoolPurposeServiceVIP).First(&pool).Error; err != nil {
+		return nil, fmt.Errorf("service VIP pool not found: %w", err)
+	}
+
+	var allocations []models.IPAllocation
+	database.DB.Where("pool_id = ?", pool.ID).Find(&allocations)
+
+	ip, err := findNextAvailableIP(pool.CIDR, allocations)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("service VIP pool exhausted")
+	}
+
+	allocation := models.IPAllocation{
+		PoolID:  pool.ID,
+		IP:      *ip + "/32",
+		Purpose: string(models.IPPoolPurposeServiceVIP),
+	}
+	if err := database.DB.Create(&allocation).Error; err != nil {
+		return nil, err
+	}
+	return &allocation, nil
+}
+
+// LoadServiceVIPPrefixes returns assigned service VIPs as /32 prefixes keyed by
+// node, so hubs accept VIP traffic from whichever node currently announces it.
+func LoadServiceVIPPrefixes() (map[uint][]models.NodePrefix, error) {
+	var assignments []models.ServiceVIPAssignment
+	if err := database.DB.Preload("ServiceVIP").Order("node_id asc, id asc").Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	byNode := make(map[uint][]models.NodePrefix)
+	for _, a := range assignments {
+		addr := strings.TrimSpace(a.ServiceVIP.Address)
+		if addr == "" {
+			continue
+		}
+		byNode[a.NodeID] = append(byNode[a.NodeID], models.NodePrefix{
+			NodeID: a.NodeID,
+			Prefix: addr + "/32",
+			Kind:   models.NodePrefixKindConnected,
+		})
+	}
+	return byNode, nil
+}
+
+// MergePrefixes combines per-node prefix maps into a new map.
+func MergePrefixes(maps ...map[uint][]models.NodePrefix) map[uint][]models.NodePrefix {
+	out := make(map[uint][]models.NodePrefix)
+	for _, m := range maps {
+		for nodeID, prefixes := range m {
+			out[nodeID] = append(out[nodeID], prefixes...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,449 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// workerTrafficStaleAfter bounds how long a traffic sample counts towards a
+// pair's rate. Workers report on every heartbeat, so anything older means the
+// reporter went quiet and the pair should be treated as idle.
+const workerTrafficStaleAfter = 5 * time.Minute
+
+// LoopbackTrafficReport is one entry of the loopback_traffic heartbeat field:
+// bytes exchanged with PeerIP (another node's loopback) since the last report.
+type LoopbackTrafficReport struct {
+	PeerIP          string  `json:"peer_ip"`
+	Bytes           uint64  `json:"bytes"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+}
+
+// ErrShortcutBothBehindNAT is returned when neither end of a shortcut could
+// accept the other's handshake.
+var ErrShortcutBothBehindNAT = errors.New("both workers are behind NAT")
+
+type workerPair struct {
+	A uint
+	B uint
+}
+
+func newWorkerPair(x uint, y uint) workerPair {
+	if x > y {
+		x, y = y, x
+	}
+	return workerPair{A: x, B: y}
+}
+
+// Shortcut interfaces listen on ports from this range, picked per worker so
+// the port stays valid however large node IDs grow.
+const (
+	workerShortcutPortMin = 55000
+	workerShortcutPortMax = 55999
+)
+
+// allocateShortcutListenPort returns the lowest port in the shortcut range
+// that none of the node's interfaces listens on.
+func allocateShortcutListenPort(nodeID uint) (int, error) {
+	var used []int
+	if err := database.DB.Model(&models.WireGuardInterface{}).
+		Where("node_id = ? AND listen_port BETWEEN ? AND ?", nodeID, workerShortcutPortMin, workerShortcutPortMax).
+		Pluck("listen_port", &used).Error; err != nil {
+		return 0, err
+	}
+	port, ok := freeShortcutListenPort(used)
+	if !ok {
+		return 0, fmt.Errorf("no free shortcut listen port on node %d", nodeID)
+	}
+	return port, nil
+}
+
+func freeShortcutListenPort(used []int) (int, bool) {
+	taken := make(map[int]bool, len(used))
+	for _, p := range used {
+		taken[p] = true
+	}
+	for port := workerShortcutPortMin; port <= workerShortcutPortMax; port++ {
+		if !taken[port] {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+func WorkerShortcutInterfaceName(peerID uint) string {
+	return fmt.Sprintf("wg-w%d", peerID)
+}
+
+// IsWorkerShortcutInterface reports whether name is a worker-to-worker link.
+func IsWorkerShortcutInterface(name string) bool {
+	return strings.HasPrefix(name, "wg-w") && len(name) > len("wg-w") &&
+		strings.Trim(name[len("wg-w"):], "0123456789") == ""
+}
+
+// CreateWorkerShortcut builds a direct link between two workers. If the pair
+// already has one it is returned as is, except that pinning is sticky.
+func CreateWorkerShortcut(x *models.Node, y *models.Node, pinned bool, reason string, actorID *uint) (*models.WorkerShortcut, error) {
+	if x.ID == y.ID {
+		return nil, fmt.Errorf("cannot create a shortcut from a node to itself")
+	}
+	if x.Role != models.NodeRoleWorker || y.Role != models.NodeRoleWorker {
+		return nil, fmt.Errorf("shortcuts can only connect two workers")
+	}
+	if x.BehindNAT && y.BehindNAT {
+		return nil, ErrShortcutBothBehindNAT
+	}
+	a, b := x, y
+	if a.ID > b.ID {
+		a, b = b, a
+	}
+
+	var existing models.WorkerShortcut
+	if err := database.DB.Where("node_a_id = ? AND node_b_id = ?", a.ID, b.ID).First(&existing).Error; err == nil {
+		if pinned && !existing.Pinned {
+			if err := database.DB.Model(&existing).Updates(map[string]any{"pinned": true, "reason": reason}).Error; err != nil {
+				return nil, err
+			}
+			existing.Pinned = true
+			existing.Reason = reason
+		}
+		return &existing, nil
+	}
+
+	aLoopback, err := allocateLoopbackIP(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure loopback IP for node %d: %w", a.ID, err)
+	}
+	bLoopback, err := allocateLoopbackIP(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure loopback IP for node %d: %w", b.ID, err)
+	}
+
+	aPort, err := allocateShortcutListenPort(a.ID)
+	if err != nil {
+		return nil, err
+	}
+	bPort, err := allocateShortcutListenPort(b.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool models.IPPool
+	if err := database.DB.Where("purpose = ?", models.IPPoolPurposeWorkerShortcut).First(&pool).Error; err != nil {
+		return nil, fmt.Errorf("worker shortcut pool not found: %w", err)
+	}
+
+	subnet, aIP, bIP, err := allocateSubnet31(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	link := models.LinkAllocation{
+		PoolID:  pool.ID,
+		NodeAID: a.ID,
+		NodeBID: b.ID,
+		Subnet:  subnet,
+		NodeAIP: aIP,
+		NodeBIP: bIP,
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	shortcut := models.WorkerShortcut{
+		NodeAID:          a.ID,
+		NodeBID:          b.ID,
+		LinkAllocationID: &link.ID,
+		Pinned:           pinned,
+		Reason:           reason,
+		LastActiveAt:     &now,
+		CreatedByID:      actorID,
+	}
+
+	if err := createShortcutSide(a, b, aIP, aPort, bPort, bLoopback, subnet); err != nil {
+		removeShortcutLinks(a.ID, b.ID, &link.ID)
+		return nil, err
+	}
+	if err := createShortcutSide(b, a, bIP, bPort, aPort, aLoopback, subnet); err != nil {
+		removeShortcutLinks(a.ID, b.ID, &link.ID)
+		return nil, err
+	}
+	if err := database.DB.Create(&shortcut).Error; err != nil {
+		removeShortcutLinks(a.ID, b.ID, &link.ID)
+		return nil, err
+	}
+
+	logger.Info("Created worker shortcut", "node_a_id", a.ID, "node_b_id", b.ID, "subnet", subnet, "pinned", pinned)
+	return &shortcut, nil
+}
+
+func createShortcutSide(local *models.Node, peer *models.Node, localIP string, localPort int, peerPort int, peerLoopback string, subnet string) error {
+	iface := models.WireGuardInterface{
+		NodeID:     local.ID,
+		Name:       WorkerShortcutInterfaceName(peer.ID),
+		Address:    localIP + "/31",
+		ListenPort: localPort,
+		Status:     models.InterfaceStatusDown,
+	}
+	if err := database.DB.Create(&iface).Error; err != nil {
+		return err
+	}
+
+	// Unlike hub links both sides dial out and keep the path warm, unless the
+	// peer is behind NAT and has to come to us.
+	nodePeer := models.NodePeer{
+		InterfaceID:         iface.ID,
+		PeerNodeID:          peer.ID,
+		Endpoint:            peerEndpoint(local, peer, peerPort),
+		AllowedIPs:          fmt.Sprintf("%s/32, %s, 224.0.0.5/32", peerLoopback, subnet),
+		PersistentKeepAlive: 25,
+		Status:              models.PeerStatusActive,
+	}
+	return database.DB.Create(&nodePeer).Error
+}
+
+// RemoveWorkerShortcut tears down both interfaces, the /31 and the record.
+func RemoveWorkerShortcut(shortcut *models.WorkerShortcut) error {
+	if err := removeShortcutLinks(shortcut.NodeAID, shortcut.NodeBID, shortcut.LinkAllocationID); err != nil {
+		return err
+	}
+	if err := database.DB.Delete(&models.WorkerShortcut{}, shortcut.ID).Error; err != nil {
+		return err
+	}
+	logger.Info("Removed worker shortcut", "node_a_id", shortcut.NodeAID, "node_b_id", shortcut.NodeBID)
+	return nil
+}
+
+func removeShortcutLinks(aID uint, bID uint, linkID *uint) error {
+	sides := []struct {
+		NodeID uint
+		Name   string
+	}{
+		{aID, WorkerShortcutInterfaceName(bID)},
+		{bID, WorkerShortcutInterfaceName(aID)},
+	}
+	for _, side := range sides {
+		var iface models.WireGuardInterface
+		if err := database.DB.Where("node_id = ? AND name = ?", side.NodeID, side.Name).First(&iface).Error; err != nil {
+			continue
+		}
+		if err := database.DB.Where("interface_id = ?", iface.ID).Delete(&models.NodePeer{}).Error; err != nil {
+			return err
+		}
+		if err := database.DB.Delete(&iface).Error; err != nil {
+			return err
+		}
+	}
+	if linkID != nil {
+		if err := database.DB.Delete(&models.LinkAllocation{}, *linkID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordLoopbackTraffic stores the rates a worker measured towards other
+// workers' loopbacks. Reports for non-worker or unknown addresses are dropped.
+func RecordLoopbackTraffic(reporter *models.Node, reports []LoopbackTrafficReport) error {
+	if reporter.Role != models.NodeRoleWorker || len(reports) == 0 {
+		return nil
+	}
+
+	var allocations []models.IPAllocation
+	if err := database.DB.Where("purpose = ? AND node_id IS NOT NULL", "loopback").Find(&allocations).Error; err != nil {
+		return err
+	}
+	nodeByLoopback := make(map[string]uint, len(allocations))
+	for _, a := range allocations {
+		nodeByLoopback[strings.TrimSuffix(a.IP, "/32")] = *a.NodeID
+	}
+
+	var workerIDs []uint
+	if err := database.DB.Model(&models.Node{}).Where("role = ?", models.NodeRoleWorker).Pluck("id", &workerIDs).Error; err != nil {
+		return err
+	}
+	isWorker := make(map[uint]bool, len(workerIDs))
+	for _, id := range workerIDs {
+		isWorker[id] = true
+	}
+
+	now := time.Now()
+	for _, r := range reports {
+		peerID, ok := nodeByLoopback[strings.TrimSpace(r.PeerIP)]
+		if !ok || peerID == reporter.ID || !isWorker[peerID] || r.IntervalSeconds <= 0 {
+			continue
+		}
+		rate := float64(r.Bytes) / r.IntervalSeconds
+
+		var sample models.WorkerTrafficSample
+		err := database.DB.Where("node_id = ? AND peer_node_id = ?", reporter.ID, peerID).First(&sample).Error
+		if err == nil {
+			err = database.DB.Model(&sample).Updates(map[string]any{
+				"bytes_per_second": rate,
+				"sampled_at":       now,
+			}).Error
+		} else {
+			err = database.DB.Create(&models.WorkerTrafficSample{
+				NodeID:         reporter.ID,
+				PeerNodeID:     peerID,
+				BytesPerSecond: rate,
+				SampledAt:      now,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileWorkerShortcuts creates shortcuts for pairs above the traffic
+// threshold, refreshes the activity stamp of busy ones and removes unpinned
+// shortcuts that have been idle for longer than the configured timeout.
+func ReconcileWorkerShortcuts() error {
+	cfg := config.Current()
+	now := time.Now()
+
+	var samples []models.WorkerTrafficSample
+	if err := database.DB.Where("sampled_at >= ?", now.Add(-workerTrafficStaleAfter)).Find(&samples).Error; err != nil {
+		return err
+	}
+	rates := workerPairRates(samples)
+
+	var workers []models.Node
+	if err := database.DB.Where("role = ?", models.NodeRoleWorker).Find(&workers).Error; err != nil {
+		return err
+	}
+	workersByID := make(map[uint]*models.Node, len(workers))
+	for i := range workers {
+		workersByID[workers[i].ID] = &workers[i]
+	}
+
+	var shortcuts []models.WorkerShortcut
+	if err := database.DB.Find(&shortcuts).Error; err != nil {
+		return err
+	}
+
+	threshold := float64(cfg.WorkerShortcutThresholdBytesPerSec)
+	idleAfter := time.Duration(cfg.WorkerShortcutIdleMinutes) * time.Minute
+	existing := make(map[workerPair]bool, len(shortcuts))
+	counts := make(map[uint]int)
+
+	for i := range shortcuts {
+		sc := &shortcuts[i]
+		pair := newWorkerPair(sc.NodeAID, sc.NodeBID)
+
+		if workersByID[sc.NodeAID] == nil || workersByID[sc.NodeBID] == nil {
+			if err := RemoveWorkerShortcut(sc); err != nil {
+				logger.Error("Failed to remove shortcut for non-worker node", "error", err, "shortcut_id", sc.ID)
+			}
+			continue
+		}
+
+		// A shortcut carrying a quarter of the creation threshold still counts
+		// as busy; this gap keeps a pair hovering around the threshold from
+		// flapping between hub and direct paths.
+		if threshold > 0 && rates[pair] >= threshold/4 {
+			if err := database.DB.Model(sc).Update("last_active_at", now).Error; err != nil {
+				logger.Error("Failed to record shortcut activity", "error", err, "shortcut_id", sc.ID)
+			}
+		} else if !sc.Pinned && idleAfter > 0 && (sc.LastActiveAt == nil || now.Sub(*sc.LastActiveAt) > idleAfter) {
+			if err := RemoveWorkerShortcut(sc); err != nil {
+				logger.Error("Failed to remove idle shortcut", "error", err, "shortcut_id", sc.ID)
+			}
+			continue
+		}
+
+		existing[pair] = true
+		counts[sc.NodeAID]++
+		counts[sc.NodeBID]++
+	}
+
+	if threshold <= 0 {
+		return nil
+	}
+
+	eligible := func(id uint) bool {
+		n := workersByID[id]
+		return n != nil && n.Status == models.NodeStatusActive
+	}
+	reachable := func(pair workerPair) bool {
+		a, b := workersByID[pair.A], workersByID[pair.B]
+		return a != nil && b != nil && !(a.BehindNAT && b.BehindNAT) &&
+			strings.EqualFold(strings.TrimSpace(a.Provider), strings.TrimSpace(b.Provider))
+	}
+
+	candidates := make(map[workerPair]float64)
+	for pair, rate := range rates {
+		if eligible(pair.A) && eligible(pair.B) && reachable(pair) {
+			candidates[pair] = rate
+		}
+	}
+
+	for _, pair := range planWorkerShortcuts(candidates, existing, counts, threshold, cfg.WorkerShortcutMaxPerWorker) {
+		reason := fmt.Sprintf("traffic %.0f B/s above threshold", rates[pair])
+		if _, err := CreateWorkerShortcut(workersByID[pair.A], workersByID[pair.B], false, reason, nil); err != nil {
+			logger.Error("Failed to create worker shortcut", "error", err, "node_a_id", pair.A, "node_b_id", pair.B)
+		}
+	}
+	return nil
+}
+
+// workerPairRates folds per-direction samples into one rate per pair. Both
+// workers see the same flows, so the higher of the two reports wins.
+func workerPairRates(samples []models.WorkerTrafficSample) map[workerPair]float64 {
+	rates := make(map[workerPair]float64)
+	for _, s := range samples {
+		if s.NodeID == s.PeerNodeID {
+			continue
+		}
+		pair := newWorkerPair(s.NodeID, s.PeerNodeID)
+		if s.BytesPerSecond > rates[pair] {
+			rates[pair] = s.BytesPerSecond
+		}
+	}
+	return rates
+}
+
+// planWorkerShortcuts picks the pairs to connect, busiest first, without
+// pushing any worker past maxPerWorker shortcuts (0 means unlimited).
+func planWorkerShortcuts(rates map[workerPair]float64, existing map[workerPair]bool, counts map[uint]int, threshold float64, maxPerWorker int) []workerPair {
+	pairs := make([]workerPair, 0)
+	for pair, rate := range rates {
+		if rate >= threshold && !existing[pair] {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if rates[pairs[i]] != rates[pairs[j]] {
+			return rates[pairs[i]] > rates[pairs[j]]
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+
+	used := make(map[uint]int, len(counts))
+	for id, n := range counts {
+		used[id] = n
+	}
+
+	planned := make([]workerPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if maxPerWorker > 0 && (used[pair.A] >= maxPerWorker || used[pair.B] >= maxPerWorker) {
+			continue
+		}
+		used[pair.A]++
+		used[pair.B]++
+		planned = append(planned, pair)
+	}
+	return planned
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"gluon-api/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPairRates(t *testing.T) {
+	rates := workerPairRates([]models.WorkerTrafficSample{
+		{NodeID: 5, PeerNodeID: 2, BytesPerSecond: 100},
+		{NodeID: 2, PeerNodeID: 5, BytesPerSecond: 300},
+		{NodeID: 3, PeerNodeID: 4, BytesPerSecond: 50},
+		{NodeID: 3, PeerNodeID: 3, BytesPerSecond: 999},
+	})
+
+	assert.Equal(t, map[workerPair]float64{
+		{A: 2, B: 5}: 300,
+		{A: 3, B: 4}: 50,
+	}, rates)
+}
+
+func TestPlanWorkerShortcuts(t *testing.T) {
+	rates := map[workerPair]float64{
+		{A: 1, B: 2}: 5000,
+		{A: 1, B: 3}: 4000,
+		{A: 2, B: 3}: 3000,
+		{A: 3, B: 4}: 500,
+		{A: 4, B: 5}: 2000,
+	}
+
+	t.Run("busiest pairs above threshold first", func(t *testing.T) {
+		planned := planWorkerShortcuts(rates, nil, nil, 1000, 0)
+		assert.Equal(t, []workerPair{{1, 2}, {1, 3}, {2, 3}, {4, 5}}, planned)
+	})
+
+	t.Run("skips existing shortcuts", func(t *testing.T) {
+		existing := map[workerPair]bool{{A: 1, B: 2}: true}
+		planned := planWorkerShortcuts(rates, existing, nil, 1000, 0)
+		assert.Equal(t, []workerPair{{1, 3}, {2, 3}, {4, 5}}, planned)
+	})
+
+	t.Run("respects per-worker limit including existing shortcuts", func(t *testing.T) {
+		counts := map[uint]int{3: 1}
+		planned := planWorkerShortcuts(rates, nil, counts, 1000, 2)
+		assert.Equal(t, []workerPair{{1, 2}, {1, 3}, {4, 5}}, planned)
+	})
+}
+
+func TestIsWorkerShortcutInterface(t *testing.T) {
+	assert.True(t, IsWorkerShortcutInterface(WorkerShortcutInterfaceName(42)))
+	assert.False(t, IsWorkerShortcutInterface("wg-hub1"))
+	assert.False(t, IsWorkerShortcutInterface("wg-w"))
+	assert.False(t, IsWorkerShortcutInterface("wg-worker1"))
+}
+
+func TestFreeShortcutListenPort(t *testing.T) {
+	port, ok := freeShortcutListenPort(nil)
+	assert.True(t, ok)
+	assert.Equal(t, workerShortcutPortMin, port)
+
+	port, ok = freeShortcutListenPort([]int{55000, 55001, 55003})
+	assert.True(t, ok)
+	assert.Equal(t, 55002, port)
+
+	var all []int
+	for p := workerShortcutPortMin; p <= workerShortcutPortMax; p++ {
+		all = append(all, p)
+	}
+	_, ok = freeShortcutListenPort(all)
+	assert.False(t, ok)
+}
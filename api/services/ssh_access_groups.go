@@ -0,0 +1,240 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var linuxUsernameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*[$]?$`)
+
+// SSHSudoRule is one sudoers line pushed to a node.
+type SSHSudoRule struct {
+	Username   string   `json:"username"`
+	Commands   []string `json:"commands"`
+	NoPassword bool     `json:"no_password"`
+}
+
+// SSHAccessKey is one authorized_keys line for a login on a node.
+type SSHAccessKey struct {
+	Username  string
+	PublicKey string
+}
+
+// SetSSHAccessGroupSelector validates selector and stores it on g in
+// canonical form.
+func SetSSHAccessGroupSelector(g *models.SSHAccessGroup, selector string) error {
+	parsed, err := ParseNodeSelector(selector)
+	if err != nil {
+		return err
+	}
+	g.Selector = parsed.String()
+	return nil
+}
+
+// SetSSHAccessGroupSudo validates the sudo commands and stores them on g.
+func SetSSHAccessGroupSudo(g *models.SSHAccessGroup, commands []string) error {
+	clean := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+		if strings.ContainsAny(cmd, ",\n\\:=") {
+			return fmt.Errorf("sudo command %q contains characters sudoers treats specially", cmd)
+		}
+		if cmd != "ALL" && !strings.HasPrefix(cmd, "/") {
+			return fmt.Errorf("sudo command %q must be ALL or an absolute path", cmd)
+		}
+		if !slices.Contains(clean, cmd) {
+			clean = append(clean, cmd)
+		}
+	}
+	g.SudoCommands, _ = json.Marshal(clean)
+	return nil
+}
+
+// ValidateSSHAccessMember checks a member's login and public key and
+// returns the authorized_keys line, using comment when given and the key's
+// own comment otherwise.
+func ValidateSSHAccessMember(username string, publicKey string, comment string) (string, error) {
+	if !linuxUsernameRe.MatchString(username) {
+		return "", fmt.Errorf("invalid username")
+	}
+	pub, keyComment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	if strings.ContainsAny(comment, "\r\n") {
+		return "", fmt.Errorf("comment must be a single line")
+	}
+	if comment = strings.TrimSpace(comment); comment == "" {
+		comment = keyComment
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// SSHAccessGroupMatches reports whether node falls under g's selector. A
+// selector that no longer parses matches nothing.
+func SSHAccessGroupMatches(g *models.SSHAccessGroup, node *models.Node) bool {
+	parsed, err := ParseNodeSelector(g.Selector)
+	return err == nil && parsed.Matches(node)
+}
+
+// SSHAccessGroupNodeIDs lists the nodes a group currently applies to.
+func SSHAccessGroupNodeIDs(g *models.SSHAccessGroup) ([]uint, error) {
+	var nodes []models.Node
+	if err := database.DB.Where("status <> ?", models.NodeStatusDecommissioned).Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for i := range nodes {
+		if SSHAccessGroupMatches(g, &nodes[i]) {
+			ids = append(ids, nodes[i].ID)
+		}
+	}
+	return ids, nil
+}
+
+// ExpandSSHAccessGroups returns the keys and sudo rules the access groups
+// give node, sorted so the config hash stays stable.
+func ExpandSSHAccessGroups(node *models.Node) ([]SSHAccessKey, []SSHSudoRule) {
+	var groups []models.SSHAccessGroup
+	if err := database.DB.Preload("Members").Order("name asc").Find(&groups).Error; err != nil {
+		return nil, nil
+	}
+	var keys []SSHAccessKey
+	var rules []SSHSudoRule
+	for i := range groups {
+		g := &groups[i]
+		if len(g.Members) == 0 || !SSHAccessGroupMatches(g, node) {
+			continue
+		}
+		var commands []string
+		_ = json.Unmarshal(orEmpty(g.SudoCommands, "[]"), &commands)
+		users := map[string]bool{}
+		for _, m := range g.Members {
+			keys = append(keys, SSHAccessKey{Username: m.Username, PublicKey: m.PublicKey})
+			if len(commands) > 0 && !users[m.Username] {
+				users[m.Username] = true
+				rules = append(rules, SSHSudoRule{Username: m.Username, Commands: commands, NoPassword: g.SudoNoPassword})
+			}
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Username < rules[j].Username })
+	return keys, rules
+}
+
+// MigrateSSHAccessGroupSelectors folds the roles, providers, hub_numbers
+// and labels columns groups used before selectors into each group's
+// selector, then drops the columns. A group whose old fields cannot be
+// expressed as a selector is left matching no node rather than all.
+func MigrateSSHAccessGroupSelectors() error {
+	m := database.DB.Migrator()
+	columns := []string{"roles", "providers", "hub_numbers", "labels"}
+	if !m.HasColumn(&models.SSHAccessGroup{}, "roles") {
+		return nil
+	}
+
+	var rows []struct {
+		ID         uint
+		Name       string
+		Selector   string
+		Roles      []byte
+		Providers  []byte
+		HubNumbers []byte
+		Labels     []byte
+	}
+	if err := database.DB.Table("ssh_access_groups").
+		Select("id", "name", "selector", "roles", "providers", "hub_numbers", "labels").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var roles, providers []string
+		var hubNumbers []int
+		var labels map[string]string
+		_ = json.Unmarshal(orEmpty(row.Roles, "[]"), &roles)
+		_ = json.Unmarshal(orEmpty(row.Providers, "[]"), &providers)
+		_ = json.Unmarshal(orEmpty(row.HubNumbers, "[]"), &hubNumbers)
+		_ = json.Unmarshal(orEmpty(row.Labels, "{}"), &labels)
+
+		var terms []string
+		if len(roles) > 0 {
+			terms = append(terms, "role in ("+strings.Join(roles, ",")+")")
+		}
+		if len(providers) > 0 {
+			terms = append(terms, "provider in ("+strings.Join(providers, ",")+")")
+		}
+		if len(hubNumbers) > 0 {
+			numbers := make([]string, 0, len(hubNumbers))
+			for _, n := range hubNumbers {
+				numbers = append(numbers, strconv.Itoa(n))
+			}
+			terms = append(terms, "role=hub", "hub_number in ("+strings.Join(numbers, ",")+")")
+		}
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			terms = append(terms, k+"="+labels[k])
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		if row.Selector != "" {
+			terms = append(terms, row.Selector)
+		}
+
+		merged := strings.Join(terms, ",")
+		if parsed, err := ParseNodeSelector(merged); err == nil {
+			merged = parsed.String()
+		} else {
+			logger.Error("SSH access group selector could not be migrated; the group matches no node until it is edited",
+				"error", err, "group", row.Name)
+			merged = "!role"
+		}
+		if err := database.DB.Model(&models.SSHAccessGroup{}).Where("id = ?", row.ID).Update("selector", merged).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, col := range columns {
+		if m.HasColumn(&models.SSHAccessGroup{}, col) {
+			if err := m.DropColumn(&models.SSHAccessGroup{}, col); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func orEmpty(data []byte, empty string) []byte {
+	if len(data) == 0 {
+		return []byte(empty)
+	}
+	return data
+}
+
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
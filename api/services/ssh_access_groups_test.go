@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+
+	"gluon-api/database"
+	"gluon-api/logger"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestSSHAccessGroupMatches(t *testing.T) {
+	hub := &models.Node{Role: models.NodeRoleHub, HubNumber: 2, Provider: "hetzner", Labels: datatypes.JSON(`{"env":"prod"}`)}
+	worker := &models.Node{Role: models.NodeRoleWorker, Provider: "aws"}
+	matches := func(selector string, node *models.Node) bool {
+		return SSHAccessGroupMatches(&models.SSHAccessGroup{Selector: selector}, node)
+	}
+
+	assert.True(t, matches("", hub))
+	assert.True(t, matches("", worker))
+	assert.True(t, matches("role=hub", hub))
+	assert.False(t, matches("role=hub", worker))
+	assert.True(t, matches("provider in (aws,gcp)", worker))
+	assert.True(t, matches("hub_number=2", hub))
+	assert.False(t, matches("hub_number=1", hub))
+	assert.True(t, matches("env=prod,role=hub", hub))
+	assert.False(t, matches("env=prod", worker))
+	assert.False(t, matches("provider!=aws", worker))
+	assert.False(t, matches("bad key!=x", hub), "an invalid selector matches nothing")
+}
+
+func TestSetSSHAccessGroupSelector(t *testing.T) {
+	var g models.SSHAccessGroup
+	require.NoError(t, SetSSHAccessGroupSelector(&g, " role=worker, rack==r1"))
+	assert.Equal(t, "role=worker,rack=r1", g.Selector)
+	assert.ErrorContains(t, SetSSHAccessGroupSelector(&g, "bad key!=x"), "selector key")
+	assert.Equal(t, "role=worker,rack=r1", g.Selector, "a rejected selector is not stored")
+}
+
+func TestMigrateSSHAccessGroupSelectors(t *testing.T) {
+	logger.Init()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	// The table as it was before groups only had a selector.
+	type legacyGroup struct {
+		models.SSHAccessGroup
+		Roles      datatypes.JSON
+		Providers  datatypes.JSON
+		HubNumbers datatypes.JSON
+		Labels     datatypes.JSON
+	}
+	legacy := db.Table("ssh_access_groups")
+	require.NoError(t, legacy.AutoMigrate(&legacyGroup{}))
+	require.NoError(t, legacy.Create([]legacyGroup{
+		{SSHAccessGroup: models.SSHAccessGroup{Name: "everyone"}},
+		{
+			SSHAccessGroup: models.SSHAccessGroup{Name: "hubs", Selector: "zone=fsn1"},
+			Roles:          datatypes.JSON(`["hub"]`), HubNumbers: datatypes.JSON(`[1,2]`), Labels: datatypes.JSON(`{"env":"prod"}`),
+		},
+		{SSHAccessGroup: models.SSHAccessGroup{Name: "broken"}, Providers: datatypes.JSON(`["not valid!"]`)},
+	}).Error)
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	require.NoError(t, MigrateSSHAccessGroupSelectors())
+	var groups []models.SSHAccessGroup
+	require.NoError(t, db.Order("id").Find(&groups).Error)
+	assert.Equal(t, "", groups[0].Selector)
+	assert.Equal(t, "role in (hub),role=hub,hub_number in (1,2),env=prod,zone=fsn1", groups[1].Selector)
+	assert.Equal(t, "!role", groups[2].Selector, "unmigratable groups match no node")
+	assert.False(t, db.Migrator().HasColumn(&models.SSHAccessGroup{}, "roles"))
+
+	require.NoError(t, MigrateSSHAccessGroupSelectors(), "runs once")
+}
+
+func TestSetSSHAccessGroupSudo(t *testing.T) {
+	var g models.SSHAccessGroup
+	require.NoError(t, SetSSHAccessGroupSudo(&g, []string{" /usr/bin/systemctl ", "", "/usr/bin/systemctl", "ALL"}))
+	assert.JSONEq(t, `["/usr/bin/systemctl","ALL"]`, string(g.SudoCommands))
+
+	assert.Error(t, SetSSHAccessGroupSudo(&g, []string{"systemctl"}))
+	assert.Error(t, SetSSHAccessGroupSudo(&g, []string{"/bin/sh, /bin/bash"}))
+	assert.Error(t, SetSSHAccessGroupSudo(&g, []string{"/bin/true\nroot ALL=(ALL) ALL"}))
+}
+
+func TestValidateSSHAccessMember(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGk7w0rVwM1mTT0F4fQn0yxS2NNiPQbWzDqjhVSl8fCm alice@laptop"
+
+	line, err := ValidateSSHAccessMember("alice", key, "")
+	require.NoError(t, err)
+	assert.Equal(t, key, line)
+
+	line, err = ValidateSSHAccessMember("alice", key, "ops")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGk7w0rVwM1mTT0F4fQn0yxS2NNiPQbWzDqjhVSl8fCm ops", line)
+
+	_, err = ValidateSSHAccessMember("Alice", key, "")
+	assert.ErrorContains(t, err, "username")
+	_, err = ValidateSSHAccessMember("alice", "not a key", "")
+	assert.ErrorContains(t, err, "public key")
+	_, err = ValidateSSHAccessMember("alice", key, "a\nssh-rsa AAAA")
+	assert.ErrorContains(t, err, "single line")
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// Certificate principals have the form <login>@<scope>, where the scope is
+// a node principal or SSHAllNodesPrincipal. Each node only accepts its own
+// principals, so a certificate works on the nodes it was issued for.
+const SSHAllNodesPrincipal = "all-nodes"
+
+var ErrSSHCertificateNotFound = errors.New("ssh certificate not found")
+
+// The CA key is read once per path and kept; every config bundle needs it.
+var (
+	sshCAMu     sync.Mutex
+	sshCAPath   string
+	sshCASigner ssh.Signer
+)
+
+// NodeSSHPrincipal is the scope naming one node in certificate principals.
+func NodeSSHPrincipal(nodeID uint) string {
+	return fmt.Sprintf("node-%d", nodeID)
+}
+
+// SSHUserCA loads the CA key, generating an Ed25519 one on first use.
+func SSHUserCA() (ssh.Signer, error) {
+	sshCAMu.Lock()
+	defer sshCAMu.Unlock()
+
+	path := config.Current().SSHUserCAKeyPath
+	if sshCASigner != nil && sshCAPath == path {
+		return sshCASigner, nil
+	}
+	signer, err := loadSSHUserCA(path)
+	if err != nil {
+		return nil, err
+	}
+	sshCAPath, sshCASigner = path, signer
+	return signer, nil
+}
+
+func loadSSHUserCA(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "gluon-ssh-user-ca")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+// SSHUserCAPublicKey returns the CA key as an authorized_keys line, the
+// form sshd's TrustedUserCAKeys expects.
+func SSHUserCAPublicKey() (string, error) {
+	signer, err := SSHUserCA()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// SSHCertificateRequest is what an admin asks the CA to sign.
+type SSHCertificateRequest struct {
+	PublicKey string
+	Login     string
+	NodeIDs   []uint
+	AllNodes  bool
+	TTL       time.Duration
+	UserID    *uint
+	Username  string
+}
+
+// SSHCertificatePrincipals lists the principals a certificate for login on
+// the given nodes carries.
+func SSHCertificatePrincipals(login string, nodeIDs []uint, allNodes bool) []string {
+	if allNodes {
+		return []string{login + "@" + SSHAllNodesPrincipal}
+	}
+	out := make([]string, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		p := login + "@" + NodeSSHPrincipal(id)
+		if !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ValidateSSHCertificateRequest checks r against the configured logins and
+// TTL bounds, filling in the default TTL.
+func ValidateSSHCertificateRequest(r *SSHCertificateRequest) (ssh.PublicKey, error) {
+	cfg := config.Current()
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(r.PublicKey)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, isCert := pub.(*ssh.Certificate); isCert {
+		return nil, fmt.Errorf("public_key must be a plain key, not a certificate")
+	}
+	r.Login = strings.TrimSpace(r.Login)
+	if r.Login == "" && len(cfg.SSHCertLoginUsers) > 0 {
+		r.Login = cfg.SSHCertLoginUsers[0]
+	}
+	if !slices.Contains(cfg.SSHCertLoginUsers, r.Login) {
+		return nil, fmt.Errorf("login must be one of: %s", strings.Join(cfg.SSHCertLoginUsers, ", "))
+	}
+	if !r.AllNodes && len(r.NodeIDs) == 0 {
+		return nil, fmt.Errorf("node_ids is required unless all_nodes is set")
+	}
+	if r.TTL == 0 {
+		r.TTL = time.Duration(cfg.SSHCertDefaultMinutes) * time.Minute
+	}
+	maxTTL := time.Duration(cfg.SSHCertMaxMinutes) * time.Minute
+	if r.TTL < time.Minute || r.TTL > maxTTL {
+		return nil, fmt.Errorf("ttl must be between 1 minute and %s", maxTTL)
+	}
+	return pub, nil
+}
+
+// IssueSSHCertificate signs the request's key and records the certificate.
+// It returns the certificate as an authorized_keys style line for the
+// user's key-cert.pub file.
+func IssueSSHCertificate(r SSHCertificateRequest) (*models.SSHCertificate, string, error) {
+	pub, err := ValidateSSHCertificateRequest(&r)
+	if err != nil {
+		return nil, "", err
+	}
+	if !r.AllNodes {
+		var found int64
+		if err := database.DB.Model(&models.Node{}).Where("id IN ?", r.NodeIDs).Count(&found).Error; err != nil {
+			return nil, "", err
+		}
+		if int(found) != len(uniqueIDs(r.NodeIDs)) {
+			return nil, "", fmt.Errorf("node_ids contains unknown nodes")
+		}
+	}
+	signer, err := SSHUserCA()
+	if err != nil {
+		return nil, "", fmt.Errorf("load ssh user ca: %w", err)
+	}
+
+	principals := SSHCertificatePrincipals(r.Login, r.NodeIDs, r.AllNodes)
+	principalsJSON, _ := json.Marshal(principals)
+	now := time.Now()
+	var line string
+	record := models.SSHCertificate{
+		UserID:      r.UserID,
+		Login:       r.Login,
+		Principals:  principalsJSON,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		// Allow for node clocks running a little behind.
+		ValidAfter:  now.Add(-2 * time.Minute),
+		ValidBefore: now.Add(r.TTL),
+	}
+	// The serial and key ID come from the record's ID, so the record is
+	// only kept if the certificate is signed and its key ID saved.
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		record.KeyID = fmt.Sprintf("%s-%d", r.Username, record.ID)
+		cert, err := signSSHCertificate(signer, pub, &record, principals)
+		if err != nil {
+			return fmt.Errorf("sign certificate: %w", err)
+		}
+		if err := tx.Model(&record).Update("key_id", record.KeyID).Error; err != nil {
+			return err
+		}
+		line = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &record, line, nil
+}
+
+func signSSHCertificate(signer ssh.Signer, pub ssh.PublicKey, record *models.SSHCertificate, principals []string) (*ssh.Certificate, error) {
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          uint64(record.ID),
+		CertType:        ssh.UserCert,
+		KeyId:           record.KeyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(record.ValidAfter.Unix()),
+		ValidBefore:     uint64(record.ValidBefore.Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			"permit-pty":              "",
+			"permit-port-forwarding":  "",
+			"permit-agent-forwarding": "",
+			"permit-user-rc":          "",
+		}},
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// RevokeSSHCertificate puts the certificate's key on every node's revoked
+// keys list until the certificate would have expired anyway. sshd refuses
+// the key itself, so other certificates for it stop working as well.
+func RevokeSSHCertificate(id uint, revokedBy *uint) (*models.SSHCertificate, error) {
+	var record models.SSHCertificate
+	if err := database.DB.First(&record, id).Error; err != nil {
+		return nil, ErrSSHCertificateNotFound
+	}
+	if record.RevokedAt != nil {
+		return &record, nil
+	}
+	now := time.Now()
+	if err := database.DB.Model(&record).Updates(map[string]any{"revoked_at": &now, "revoked_by_id": revokedBy}).Error; err != nil {
+		return nil, err
+	}
+	record.RevokedAt = &now
+	record.RevokedByID = revokedBy
+	return &record, nil
+}
+
+// SSHCASpec is the part of a node's config bundle that makes sshd trust
+// the CA.
+type SSHCASpec struct {
+	TrustedUserCAKeys []string            `json:"trusted_user_ca_keys"`
+	Principals        map[string][]string `json:"principals"`
+	RevokedKeys       []string            `json:"revoked_keys"`
+}
+
+// NodeSSHCASpec builds the CA settings for node. It fails rather than
+// leaving the CA or the revoked keys out, which would drop revocations from
+// the node; the node keeps its last bundle instead.
+func NodeSSHCASpec(nodeID uint) (*SSHCASpec, error) {
+	caKey, err := SSHUserCAPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("load ssh user ca: %w", err)
+	}
+	var revoked []models.SSHCertificate
+	if err := database.DB.Select("public_key").
+		Where("revoked_at IS NOT NULL AND valid_before > ?", time.Now()).
+		Order("id asc").
+		Find(&revoked).Error; err != nil {
+		return nil, fmt.Errorf("list revoked ssh certificates: %w", err)
+	}
+	keys := make([]string, 0, len(revoked))
+	for _, r := range revoked {
+		if !slices.Contains(keys, r.PublicKey) {
+			keys = append(keys, r.PublicKey)
+		}
+	}
+	return &SSHCASpec{
+		TrustedUserCAKeys: []string{caKey},
+		Principals:        nodeSSHPrincipals(nodeID, config.Current().SSHCertLoginUsers),
+		RevokedKeys:       keys,
+	}, nil
+}
+
+func nodeSSHPrincipals(nodeID uint, logins []string) map[string][]string {
+	out := make(map[string][]string, len(logins))
+	for _, login := range logins {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+		out[login] = []string{login + "@" + NodeSSHPrincipal(nodeID), login + "@" + SSHAllNodesPrincipal}
+	}
+	return out
+}
+
+func uniqueIDs(ids []uint) []uint {
+	out := slices.Clone(ids)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return slices.Compact(out)
+}
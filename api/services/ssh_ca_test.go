@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gluon-api/config"
+	"gluon-api/database"
+	"gluon-api/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+func loadSSHCAConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("GLUON_SECRET_KEY", "test")
+	t.Setenv("GLUON_SSH_USER_CA_KEY_PATH", filepath.Join(t.TempDir(), "ssh_user_ca"))
+	t.Setenv("GLUON_SSH_CERT_LOGIN_USERS", "root,ops")
+	t.Setenv("GLUON_SSH_CERT_MAX_MINUTES", "120")
+	require.NoError(t, config.Load())
+}
+
+func TestSSHUserCAIsPersisted(t *testing.T) {
+	loadSSHCAConfig(t)
+
+	first, err := SSHUserCAPublicKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "ssh-ed25519 "))
+	second, err := SSHUserCAPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// Dropping the cached key reads the same one back from disk.
+	sshCAMu.Lock()
+	sshCASigner = nil
+	sshCAMu.Unlock()
+	third, err := SSHUserCAPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, first, third)
+}
+
+func TestNodeSSHCASpecFailsClosed(t *testing.T) {
+	loadSSHCAConfig(t)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	orig := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = orig })
+
+	_, err = NodeSSHCASpec(1)
+	assert.Error(t, err, "revoked keys cannot be listed")
+
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.SSHCertificate{}))
+	now := time.Now()
+	require.NoError(t, db.Create(&models.SSHCertificate{Login: "root", PublicKey: "ssh-ed25519 AAAA revoked", ValidBefore: now.Add(time.Hour), RevokedAt: &now}).Error)
+	require.NoError(t, db.Create(&models.SSHCertificate{Login: "root", PublicKey: "ssh-ed25519 AAAA live", ValidBefore: now.Add(time.Hour)}).Error)
+	spec, err := NodeSSHCASpec(1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA revoked"}, spec.RevokedKeys)
+	assert.Len(t, spec.TrustedUserCAKeys, 1)
+}
+
+func TestValidateSSHCertificateRequest(t *testing.T) {
+	loadSSHCAConfig(t)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	line := string(ssh.MarshalAuthorizedKey(sshPub))
+
+	r := SSHCertificateRequest{PublicKey: line, NodeIDs: []uint{3}}
+	_, err = ValidateSSHCertificateRequest(&r)
+	require.NoError(t, err)
+	assert.Equal(t, "root", r.Login)
+	assert.Equal(t, time.Hour, r.TTL)
+
+	bad := []struct {
+		req SSHCertificateRequest
+		msg string
+	}{
+		{SSHCertificateRequest{PublicKey: "nope", NodeIDs: []uint{3}}, "invalid public key"},
+		{SSHCertificateRequest{PublicKey: line, Login: "admin", NodeIDs: []uint{3}}, "login must be one of"},
+		{SSHCertificateRequest{PublicKey: line}, "node_ids"},
+		{SSHCertificateRequest{PublicKey: line, AllNodes: true, TTL: 3 * time.Hour}, "ttl"},
+	}
+	for _, tc := range bad {
+		_, err := ValidateSSHCertificateRequest(&tc.req)
+		assert.ErrorContains(t, err, tc.msg)
+	}
+}
+
+func TestSSHCertificateScopedToNodes(t *testing.T) {
+	loadSSHCAConfig(t)
+	signer, err := SSHUserCA()
+	require.NoError(t, err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	now := time.Now()
+	principals := SSHCertificatePrincipals("ops", []uint{3, 3, 5}, false)
+	assert.Equal(t, []string{"ops@node-3", "ops@node-5"}, principals)
+	cert, err := signSSHCertificate(signer, sshPub, &models.SSHCertificate{
+		ID: 9, KeyID: "alice-9", ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Hour),
+	}, principals)
+	require.NoError(t, err)
+
+	checker := ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return string(auth.Marshal()) == string(signer.PublicKey().Marshal())
+	}}
+	accepts := func(nodeID uint, login string) bool {
+		for _, p := range nodeSSHPrincipals(nodeID, []string{"root", "ops"})[login] {
+			if checker.CheckCert(p, cert) == nil {
+				return true
+			}
+		}
+		return false
+	}
+	assert.True(t, accepts(3, "ops"))
+	assert.True(t, accepts(5, "ops"))
+	assert.False(t, accepts(4, "ops"), "certificate is not valid on other nodes")
+	assert.False(t, accepts(3, "root"), "certificate is not valid for other logins")
+
+	all := SSHCertificatePrincipals("root", nil, true)
+	cert, err = signSSHCertificate(signer, sshPub, &models.SSHCertificate{
+		ID: 10, ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Hour),
+	}, all)
+	require.NoError(t, err)
+	assert.True(t, accepts(4, "root"))
+}
@@ -82,3 +82,19 @@ func TestHashEnrollmentSecret(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, matched, "hash index should be 16 lowercase hex characters")
 }
+
+func TestGenerateBootstrapToken(t *testing.T) {
+	token, err := GenerateBootstrapToken()
+	assert.NoError(t, err)
+
+	// Total length: "gbt_" (4) + 64 hex chars (32 bytes) = 68
+	assert.Equal(t, 68, len(token), "token should be 68 characters total")
+	matched, err := regexp.MatchString("^gbt_[0-9a-f]{64}$", token)
+	assert.NoError(t, err)
+	assert.True(t, matched, "token should be gbt_ followed by lowercase hex")
+
+	bcryptHash, hashIndex, err := HashBootstrapToken(token)
+	assert.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(bcryptHash), []byte(token)))
+	assert.Equal(t, 16, len(hashIndex), "hash index should be 16 characters")
+}
@@ -28,3 +28,20 @@ func HashEnrollmentSecret(plainSecret string) (bcryptHash string, hashIndex stri
 
 	return string(hashed), hashIndex, nil
 }
+
+// GenerateBootstrapToken returns a pre-shared enrollment token that admins
+// hand to agents for unattended onboarding.
+func GenerateBootstrapToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return "gbt_" + hex.EncodeToString(randomBytes), nil
+}
+
+// HashBootstrapToken hashes a bootstrap token the way enrollment secrets
+// are hashed: bcrypt for verification, a SHA-256 prefix for lookup.
+func HashBootstrapToken(plainToken string) (bcryptHash string, hashIndex string, err error) {
+	return HashEnrollmentSecret(plainToken)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
@@ -39,6 +40,7 @@ var runCmd = &cobra.Command{
 
 var (
 	iptablesTarget    string
+	iptablesSelector  string
 	iptablesInterface string
 	iptablesTTL       int
 	iptablesPing      string
@@ -46,6 +48,7 @@ var (
 
 var (
 	tcNetemTarget    string
+	tcNetemSelector  string
 	tcNetemInterface string
 	tcNetemLatency   int
 	tcNetemLoss      float64
@@ -76,22 +79,26 @@ a structured experiment result.`,
 func init() {
 	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "path to chaosmonkey.yaml (default: auto-detect)")
 
-	iptablesBlockCmd.Flags().StringVar(&iptablesTarget, "target", "", "node name as defined in config (required)")
+	iptablesBlockCmd.Flags().StringVar(&iptablesTarget, "target", "", "node name as defined in config")
+	iptablesBlockCmd.Flags().StringVar(&iptablesSelector, "selector", "", "label selector picking a random matching node, e.g. role=worker,provider=hetzner")
 	iptablesBlockCmd.Flags().StringVar(&iptablesInterface, "interface", "", "WireGuard interface to block, e.g. wg-worker1 (required)")
 	iptablesBlockCmd.Flags().IntVar(&iptablesTTL, "ttl", 60, "fault duration in seconds (self-revert after TTL)")
 	iptablesBlockCmd.Flags().StringVar(&iptablesPing, "ping", "", "IP to ICMP-probe during the fault (default: node loopback)")
 
-	_ = iptablesBlockCmd.MarkFlagRequired("target")
+	iptablesBlockCmd.MarkFlagsOneRequired("target", "selector")
+	iptablesBlockCmd.MarkFlagsMutuallyExclusive("target", "selector")
 	_ = iptablesBlockCmd.MarkFlagRequired("interface")
 
-	tcNetemCmd.Flags().StringVar(&tcNetemTarget, "target", "", "node name as defined in config (required)")
+	tcNetemCmd.Flags().StringVar(&tcNetemTarget, "target", "", "node name as defined in config")
+	tcNetemCmd.Flags().StringVar(&tcNetemSelector, "selector", "", "label selector picking a random matching node, e.g. role=worker,provider=hetzner")
 	tcNetemCmd.Flags().StringVar(&tcNetemInterface, "interface", "", "WireGuard interface to apply netem on, e.g. wg-worker1 (required)")
 	tcNetemCmd.Flags().IntVar(&tcNetemLatency, "latency", 0, "emulated one-way delay in milliseconds (required, must be > 0)")
 	tcNetemCmd.Flags().Float64Var(&tcNetemLoss, "loss", 0, "emulated packet loss percentage (optional, default 0)")
 	tcNetemCmd.Flags().IntVar(&tcNetemTTL, "ttl", 60, "fault duration in seconds (self-revert after TTL)")
 	tcNetemCmd.Flags().StringVar(&tcNetemPing, "ping", "", "IP to ICMP-probe during the fault (default: node loopback)")
 
-	_ = tcNetemCmd.MarkFlagRequired("target")
+	tcNetemCmd.MarkFlagsOneRequired("target", "selector")
+	tcNetemCmd.MarkFlagsMutuallyExclusive("target", "selector")
 	_ = tcNetemCmd.MarkFlagRequired("interface")
 	_ = tcNetemCmd.MarkFlagRequired("latency")
 
@@ -109,10 +116,11 @@ func runIptablesBlock(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
-	node, ok := cfg.Nodes[iptablesTarget]
-	if !ok {
-		return fmt.Errorf("node %q not found in config (known nodes: %v)", iptablesTarget, nodeNames(cfg))
+	name, node, err := resolveTarget(cfg, iptablesTarget, iptablesSelector)
+	if err != nil {
+		return err
 	}
+	iptablesTarget = name
 
 	pingTarget := iptablesPing
 	if pingTarget == "" {
@@ -248,10 +256,11 @@ func runTcNetem(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
-	node, ok := cfg.Nodes[tcNetemTarget]
-	if !ok {
-		return fmt.Errorf("node %q not found in config (known nodes: %v)", tcNetemTarget, nodeNames(cfg))
+	name, node, err := resolveTarget(cfg, tcNetemTarget, tcNetemSelector)
+	if err != nil {
+		return err
 	}
+	tcNetemTarget = name
 
 	pingTarget := tcNetemPing
 	if pingTarget == "" {
@@ -477,6 +486,27 @@ func runResults(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// resolveTarget looks up the --target node, or picks one of the nodes
+// matching --selector at random.
+func resolveTarget(cfg *config.Config, target string, selector string) (string, config.NodeConfig, error) {
+	if selector != "" {
+		names, err := cfg.MatchNodes(selector)
+		if err != nil {
+			return "", config.NodeConfig{}, fmt.Errorf("parse selector: %w", err)
+		}
+		if len(names) == 0 {
+			return "", config.NodeConfig{}, fmt.Errorf("no node matches selector %q", selector)
+		}
+		target = names[rand.IntN(len(names))]
+		fmt.Printf("Selector:   %s matched %v\n", selector, names)
+	}
+	node, ok := cfg.Nodes[target]
+	if !ok {
+		return "", config.NodeConfig{}, fmt.Errorf("node %q not found in config (known nodes: %v)", target, nodeNames(cfg))
+	}
+	return target, node, nil
+}
+
 func nodeNames(cfg *config.Config) []string {
 	names := make([]string, 0, len(cfg.Nodes))
 	for k := range cfg.Nodes {
@@ -2,11 +2,17 @@ module gluon-chaosmonkey
 
 go 1.25.0
 
+require (
+	github.com/spf13/cobra v1.10.2
+	gluon-api v0.0.0
+	golang.org/x/crypto v0.49.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.10.2 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
-	golang.org/x/crypto v0.49.0 // indirect
 	golang.org/x/sys v0.42.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace gluon-api => ../api
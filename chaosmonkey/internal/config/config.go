@@ -13,6 +13,9 @@ type NodeConfig struct {
 	Host       string   `yaml:"host"`
 	Interfaces []string `yaml:"interfaces"`
 	Loopback   string   `yaml:"loopback"`
+	// Labels let --selector pick targets, using the same keys as the API's
+	// node labels (e.g. provider, role, zone).
+	Labels map[string]string `yaml:"labels"`
 }
 
 type Config struct {
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"sort"
+
+	"gluon-api/selector"
+)
+
+// MatchNodes returns the names of the nodes matching a label selector in
+// the API's syntax (`provider=hetzner,role!=hub,zone in (a,b),!gpu`). The
+// node's name is available as the "name" label.
+func (c *Config) MatchNodes(s string) ([]string, error) {
+	sel, err := selector.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name, node := range c.Nodes {
+		labels := map[string]string{"name": name}
+		for k, v := range node.Labels {
+			labels[k] = v
+		}
+		if sel.MatchesLabels(labels) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}